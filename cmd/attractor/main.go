@@ -3,23 +3,42 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/ashka-vakil/attractor/pkg/agent"
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+	"github.com/ashka-vakil/attractor/pkg/agent/eval"
+	"github.com/ashka-vakil/attractor/pkg/config"
 	"github.com/ashka-vakil/attractor/pkg/llm"
 	_ "github.com/ashka-vakil/attractor/pkg/llm/provider/anthropic"
-	_ "github.com/ashka-vakil/attractor/pkg/llm/provider/gemini"
+	"github.com/ashka-vakil/attractor/pkg/llm/provider/gemini"
+	_ "github.com/ashka-vakil/attractor/pkg/llm/provider/mistral"
 	_ "github.com/ashka-vakil/attractor/pkg/llm/provider/openai"
+	_ "github.com/ashka-vakil/attractor/pkg/llm/provider/xai"
 	"github.com/ashka-vakil/attractor/pkg/pipeline"
 	"github.com/ashka-vakil/attractor/pkg/pipeline/handler"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/lsp"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/notify"
 	"github.com/ashka-vakil/attractor/pkg/pipeline/transform"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 func main() {
@@ -37,6 +56,20 @@ func main() {
 		cmdServe(os.Args[2:])
 	case "validate":
 		cmdValidate(os.Args[2:])
+	case "eval":
+		cmdEval(os.Args[2:])
+	case "diff":
+		cmdDiff(os.Args[2:])
+	case "logs":
+		cmdLogs(os.Args[2:])
+	case "report":
+		cmdReport(os.Args[2:])
+	case "config":
+		cmdConfig(os.Args[2:])
+	case "models":
+		cmdModels(os.Args[2:])
+	case "lsp":
+		cmdLSP(os.Args[2:])
 	case "version":
 		fmt.Println("attractor v0.1.0")
 	case "help", "-h", "--help":
@@ -56,6 +89,13 @@ Commands:
   agent     Start an interactive coding agent session
   serve     Start the HTTP pipeline server
   validate  Validate a DOT pipeline file
+  eval      Run agent evaluation scenarios
+  diff      Compare two DOT pipeline files
+  logs      Show the stage outcomes of a pipeline run
+  report    Write a Markdown and HTML summary of a pipeline run
+  config    Show the settings loaded from config files and the environment
+  models    List the models of the configured providers
+  lsp       Start a language server for DOT pipeline files
   version   Print version
   help      Show this help
 
@@ -66,81 +106,456 @@ Use "attractor <command> -h" for command-specific help.
 // cmdRun executes a DOT pipeline from a file.
 func cmdRun(args []string) {
 	fs := flag.NewFlagSet("run", flag.ExitOnError)
-	logsDir := fs.String("logs", "", "Directory for pipeline logs (default: temp dir)")
+	logsDir := fs.String("logs", "", "Directory for pipeline logs (default: logs_root from config, or a temp dir)")
+	useAgent := fs.Bool("agent", false, "Run codergen stages as coding agent sessions that can edit files and run commands")
+	watch := fs.Bool("watch", false, "Re-run the pipeline when the file changes, reusing LLM responses for unchanged prompts")
+	useCache := fs.Bool("cache", false, "Skip codergen stages whose inputs match an earlier successful run, reusing its outcome")
+	from := fs.String("from", "", "Re-run only the stages downstream of this node, seeded from an earlier run's checkpoint")
+	checkpointPath := fs.String("checkpoint", "", "Checkpoint file or run logs directory for -from (default: the -logs directory)")
+	recordAnswers := fs.String("record-answers", "", "Save the questions human gates ask, and their answers, to this file")
+	replayAnswers := fs.String("replay-answers", "", "Answer human gates from a file saved with -record-answers")
+	output := fs.String("output", "text", "Result format: text, or json to print the result as JSON on stdout")
+	exitCodesFlag := fs.String("exit-codes", "", "Exit code for each run status, e.g. partial_success=2,error=3 (default: 1 for fail and error, else 0)")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "Usage: attractor run [options] <pipeline.dot>")
 		os.Exit(1)
 	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unknown -output %q (want text or json)\n", *output)
+		os.Exit(2)
+	}
+	exitCodes, err := parseExitCodes(*exitCodesFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	cfg := loadConfig()
 
-	client := llm.FromEnv()
+	// In watch mode, identical LLM requests (the same stage prompt and model
+	// settings) are answered from memory across runs. Agent sessions are not
+	// cached: replaying their tool calls against a changed workspace would
+	// be wrong.
+	var cache *watchCache
+	var clientOpts []llm.ClientOption
+	if *watch && !*useAgent {
+		cache = &watchCache{MemoryCache: llm.NewMemoryCache(0)}
+		clientOpts = append(clientOpts, llm.WithMiddleware(llm.CacheMiddleware(cache)))
+	}
+	// Every LLM call of the run, from LLM stages, agent sessions, and fan-in
+	// judges, shares the graph's requests_per_minute and tokens_per_minute
+	// budget. Cache hits are not paced.
+	pacer := &handler.RunRateLimit{}
+	clientOpts = append(clientOpts,
+		llm.WithMiddleware(pacer.Middleware()),
+		llm.WithStreamMiddleware(pacer.StreamMiddleware()))
+	client := newClient(cfg, clientOpts...)
 	defer client.Close()
+	reportDebugLogDir(client)
+
+	// Secrets referenced as ${secret:NAME} are expanded at execution time and
+	// redacted from events, stage logs, and checkpoints.
+	store := secrets.FromEnv()
+	// Context values under secret-looking keys are scrubbed from
+	// checkpoints and status.json, which are encrypted if a key is set.
+	scrubber, err := secrets.ScrubberFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	cipher, err := secrets.CipherFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	registry := handler.NewRegistry(nil, &handler.AutoApproveInterviewer{})
+	// Without a configured provider, codergen stages run in simulation mode.
+	var backend handler.CodergenBackend
+	switch {
+	case !client.HasProviders():
+	case *useAgent:
+		backend = &handler.AgentBackend{
+			Client:  client,
+			Profile: agentProfile(cfg.Provider, cfg.Model),
+			Config:  agent.DefaultSessionConfig(),
+			Secrets: store,
+			Policy:  configPolicy(cfg),
+			Shell:   configShell(cfg),
+		}
+	default:
+		_, model := resolveModel(cfg.Provider, cfg.Model)
+		backend = &handler.LLMBackend{Client: client, DefaultModel: model}
+	}
+
+	// Human gates are approved automatically, unless a recorded session
+	// answers them; questions it has no answer for are still approved.
+	var interviewer handler.Interviewer = &handler.AutoApproveInterviewer{}
+	if *replayAnswers != "" {
+		iv, err := handler.LoadInterview(*replayAnswers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		interviewer = handler.NewReplayInterviewer(iv, interviewer)
+	}
+	var recorder *handler.RecordingInterviewer
+	if *recordAnswers != "" {
+		recorder = &handler.RecordingInterviewer{Inner: interviewer}
+		interviewer = recorder
+	}
+	saveAnswers := func() {
+		if recorder == nil {
+			return
+		}
+		if err := recorder.Save(*recordAnswers); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	registry := handler.NewRegistry(backend, interviewer, handler.WithSecrets(store), handler.WithScrubber(scrubber), handler.WithShell(configShell(cfg)))
 	resolver := &registryAdapter{registry: registry}
 
-	opts := []pipeline.RunnerOption{}
+	// Ctrl+C stops the run once the stage in progress ends, and in watch
+	// mode stops watching too; a second Ctrl+C exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	opts := []pipeline.RunnerOption{
+		pipeline.WithSecrets(store),
+		pipeline.WithScrubber(scrubber),
+		pipeline.WithCheckpointCipher(cipher),
+		pipeline.WithContext(ctx),
+	}
+	if *logsDir == "" {
+		*logsDir = cfg.LogsRoot
+	}
 	if *logsDir != "" {
 		opts = append(opts, pipeline.WithLogsRoot(*logsDir))
 	}
+	if *from != "" {
+		cp, err := loadRunCheckpoint(*checkpointPath, *logsDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -from: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, pipeline.WithResumeFrom(*from, cp))
+	}
+	// With -cache, codergen stages whose backend, prompt, model, and
+	// incoming context match an earlier successful run are skipped. Agent
+	// stages change the workspace, so they always run, and simulated stages
+	// (no provider) are never cached.
+	if b, ok := backend.(*handler.LLMBackend); ok && *useCache {
+		provider, _ := resolveModel(cfg.Provider, cfg.Model)
+		identity := "llm/" + provider + "/" + b.DefaultModel
+		opts = append(opts, pipeline.WithStageCache(pipeline.NewStageCache(pipeline.DefaultStageCacheDir(), identity)))
+	}
 
 	runner := pipeline.NewRunner(resolver, opts...)
 	runner.RegisterTransform(transform.VariableExpansion())
 	runner.RegisterTransform(transform.StylesheetApplication())
+	runner.RegisterTransform(pacer)
+
+	// Forward pipeline events to Slack/email when notification channels are configured.
+	sink := notify.NewSink(notify.ConfigFromEnv())
+	sink.OnError = func(err error) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	runner.RegisterTransform(sink)
+	runner.OnEvent(sink.Handle)
+
+	if !*watch {
+		result, err := runner.RunFromFile(fs.Arg(0))
+		saveAnswers()
+		sink.Flush()
+		out := newRunOutput(result, err)
+		out.ExitCode = exitCodes[out.Status]
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		if *output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(out)
+		} else if err == nil {
+			fmt.Printf("Pipeline completed: status=%s, stages=%d\n", result.Status, len(result.CompletedNodes))
+			printStageUsage(result)
+		}
+		os.Exit(out.ExitCode)
+	}
 
-	result, err := runner.RunFromFile(fs.Arg(0))
+	path := fs.Arg(0)
+	err = watchFile(ctx, path, 500*time.Millisecond, func(source []byte) {
+		fmt.Fprintf(os.Stderr, "==> Running %s\n", path)
+		hitsBefore := cache.Hits()
+		result, err := runner.RunFromSource(string(source))
+		saveAnswers()
+		sink.Flush()
+		if *output == "json" {
+			// One result per line, so each run can be parsed as it ends.
+			out := newRunOutput(result, err)
+			out.ExitCode = exitCodes[out.Status]
+			json.NewEncoder(os.Stdout).Encode(out)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else if *output != "json" {
+			fmt.Printf("Pipeline completed: status=%s, stages=%d\n", result.Status, len(result.CompletedNodes))
+			printStageUsage(result)
+		}
+		if hits := cache.Hits() - hitsBefore; hits > 0 {
+			fmt.Fprintf(os.Stderr, "Reused %d cached LLM response(s)\n", hits)
+		}
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Watching %s for changes (Ctrl+C to stop)\n", path)
+		}
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
 
-	fmt.Printf("Pipeline completed: status=%s, stages=%d\n", result.Status, len(result.CompletedNodes))
-	if result.Status == pipeline.StatusFail {
-		os.Exit(1)
+// runOutput is the result of `attractor run -output json`. Status is the
+// run's final status, or "error" if the pipeline couldn't be run to the end;
+// the stage details come from the run's logs directory.
+type runOutput struct {
+	Status         string                 `json:"status"`
+	ExitCode       int                    `json:"exit_code"`
+	Error          string                 `json:"error,omitempty"`
+	LogsDir        string                 `json:"logs_dir,omitempty"`
+	Duration       string                 `json:"duration,omitempty"`
+	Stages         []pipeline.StageLog    `json:"stages"`
+	Failures       []runFailure           `json:"failures,omitempty"`
+	GoalGates      []pipeline.GoalGateLog `json:"goal_gates,omitempty"`
+	Usage          pipeline.Usage         `json:"usage"`
+	BudgetExceeded bool                   `json:"budget_exceeded,omitempty"`
+}
+
+// runFailure is a stage that ended the run failed.
+type runFailure struct {
+	Stage  string `json:"stage"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newRunOutput(result *pipeline.RunResult, runErr error) runOutput {
+	out := runOutput{Status: "error", Stages: []pipeline.StageLog{}}
+	if runErr != nil {
+		out.Error = runErr.Error()
+	}
+	if result == nil {
+		return out
+	}
+	if runErr == nil {
+		out.Status = string(result.Status)
+	}
+	out.LogsDir = result.LogsRoot
+	out.Usage = result.Usage
+	out.BudgetExceeded = result.BudgetExceeded
+	run, err := pipeline.LoadRunLog(result.LogsRoot)
+	if err != nil {
+		return out
+	}
+	out.Duration = run.Duration
+	out.GoalGates = run.GoalGates
+	if out.Error == "" {
+		out.Error = run.Error
+	}
+	if run.Stages != nil {
+		out.Stages = run.Stages
+	}
+	for _, s := range run.Stages {
+		if s.Status == pipeline.StatusFail {
+			out.Failures = append(out.Failures, runFailure{Stage: s.Path(), Reason: s.FailureReason})
+		}
+	}
+	return out
+}
+
+// parseExitCodes reads -exit-codes, a comma-separated list of status=code
+// pairs, over the default codes.
+func parseExitCodes(spec string) (map[string]int, error) {
+	codes := map[string]int{
+		string(pipeline.StatusSuccess):        0,
+		string(pipeline.StatusPartialSuccess): 0,
+		string(pipeline.StatusFail):           1,
+		"error":                               1,
+	}
+	if spec == "" {
+		return codes, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		status, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if _, known := codes[status]; !ok || !known {
+			return nil, fmt.Errorf("-exit-codes: invalid entry %q: want status=code, with status one of success, partial_success, fail, error", pair)
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("-exit-codes: invalid code %q for %s: want 0-255", value, status)
+		}
+		codes[status] = code
+	}
+	return codes, nil
+}
+
+// printStageUsage prints the tokens and estimated cost of each stage that called
+// an LLM, in the order the stages first ran, and the run's total.
+func printStageUsage(result *pipeline.RunResult) {
+	if len(result.NodeUsage) == 0 {
+		return
+	}
+	var order []string
+	seen := make(map[string]bool)
+	for _, id := range result.CompletedNodes {
+		if _, ok := result.NodeUsage[id]; ok && !seen[id] {
+			seen[id] = true
+			order = append(order, id)
+		}
+	}
+	fmt.Printf("  %-24s %10s %10s %10s\n", "STAGE", "INPUT", "OUTPUT", "COST $")
+	row := func(name string, u pipeline.Usage) {
+		fmt.Printf("  %-24s %10d %10d %10.4f\n", name, u.InputTokens, u.OutputTokens, u.CostUSD)
+	}
+	for _, id := range order {
+		row(id, result.NodeUsage[id])
+	}
+	row("total", result.Usage)
+}
+
+// loadRunCheckpoint loads the checkpoint at path, a checkpoint file or a
+// run's logs directory, or if path is empty, the latest in logsDir.
+func loadRunCheckpoint(path, logsDir string) (*pipeline.Checkpoint, error) {
+	if path == "" {
+		path = logsDir
+	}
+	if path == "" {
+		return nil, fmt.Errorf("no checkpoint: pass -checkpoint or -logs")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return pipeline.LoadLatestCheckpoint(path)
+	}
+	return pipeline.LoadCheckpoint(path)
+}
+
+// watchFile calls run with the file's content now and again each time the
+// content changes, polling every interval, until ctx is done. A file that
+// disappears, as during an editor's save-by-rename, is waited for.
+func watchFile(ctx context.Context, path string, interval time.Duration, run func(source []byte)) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for {
+		run(source)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			data, err := os.ReadFile(path)
+			if err == nil && !bytes.Equal(data, source) {
+				source = data
+				break
+			}
+		}
+	}
+}
+
+// watchCache is the response cache of watch mode; it counts hits so each
+// run can report how many stages were reused.
+type watchCache struct {
+	*llm.MemoryCache
+	hits atomic.Int64
+}
+
+func (c *watchCache) Get(key string) (*llm.Response, bool) {
+	resp, ok := c.MemoryCache.Get(key)
+	if ok {
+		c.hits.Add(1)
+	}
+	return resp, ok
+}
+
+// Hits returns the number of requests served from the cache. It is safe to
+// call on a nil cache.
+func (c *watchCache) Hits() int64 {
+	if c == nil {
+		return 0
 	}
+	return c.hits.Load()
 }
 
 // cmdAgent starts an interactive coding agent session.
 func cmdAgent(args []string) {
 	fs := flag.NewFlagSet("agent", flag.ExitOnError)
 	model := fs.String("model", "", "Model to use (e.g., claude-opus-4-6, gpt-4.1)")
-	provider := fs.String("provider", "", "Provider (anthropic, openai, gemini)")
+	provider := fs.String("provider", "", "Provider (anthropic, openai, gemini, xai, mistral)")
 	maxTurns := fs.Int("max-turns", 0, "Maximum number of turns (0 = unlimited)")
+	jail := fs.Bool("jail", false, "Reject file tool paths outside the working directory")
+	noNetwork := fs.Bool("no-network", false, "Reject bash commands that use the network")
+	maxTotalTokens := fs.Int("max-total-tokens", 0, "Stop after this many input+output tokens (0 = unlimited)")
+	maxCost := fs.Float64("max-cost", 0, "Stop after this estimated cost in USD (0 = unlimited)")
+	cacheTools := fs.Bool("cache-tools", false, "Reuse results of identical read-only tool calls until files change")
+	enableTools := fs.String("tools", "", "Comma-separated tools to offer the model (default: all)")
+	disableTools := fs.String("disable-tools", "", "Comma-separated tools to withhold from the model")
+	postEdit := fs.String("post-edit", "", "Command to run after each file edit, with {path} as the file (e.g., 'gofmt -l -w {path}')")
+	promptTemplate := fs.String("prompt-template", "", "System prompt template file (Go text/template)")
+	exportPath := fs.String("export", "", "Write the session transcript to this file (.md for markdown, otherwise JSON)")
 	fs.Parse(args)
+	cfg := loadConfig()
 
-	client := llm.FromEnv()
+	client := newClient(cfg)
 	defer client.Close()
+	reportDebugLogDir(client)
 	requireProvider(client)
 
-	// Resolve provider and model
-	prov := *provider
-	mod := *model
-	if prov == "" {
-		prov = detectProvider()
-	}
-	if mod == "" {
-		mod = defaultModel(prov)
-	}
-
-	// Select profile
-	var profile *agent.ProviderProfile
-	switch prov {
-	case "openai":
-		profile = agent.DefaultOpenAIProfile(mod)
-	case "gemini":
-		profile = agent.DefaultGeminiProfile(mod)
-	default:
-		profile = agent.DefaultAnthropicProfile(mod)
-	}
+	profile := agentProfile(cmp.Or(*provider, cfg.Provider), cmp.Or(*model, cfg.Model))
 
 	config := agent.DefaultSessionConfig()
 	if *maxTurns > 0 {
 		config.MaxTurns = *maxTurns
 	}
+	config.MaxTotalTokens = *maxTotalTokens
+	config.MaxCostUSD = *maxCost
+	config.CacheToolResults = *cacheTools
+	config.EnabledTools = splitList(*enableTools)
+	config.DisabledTools = splitList(*disableTools)
+	if *postEdit != "" {
+		config.PostEditHooks = []agent.PostEditHook{{Command: *postEdit}}
+	}
+
+	localEnv := env.NewLocalEnvironment("")
+	localEnv.Secrets = secrets.FromEnv()
+	localEnv.Policy = configPolicy(cfg)
+	localEnv.Shell = configShell(cfg)
+	if (*jail || *noNetwork) && localEnv.Policy == nil {
+		localEnv.Policy = &env.Policy{}
+	}
+	if *jail {
+		localEnv.Policy.JailPaths = true
+	}
+	if *noNetwork {
+		localEnv.Policy.DisableNetwork = true
+	}
 
-	session := agent.NewSession(client, profile, nil, config)
+	session := agent.NewSession(client, profile, localEnv, config)
 	defer session.Close()
+	session.Prompt = agent.NewPromptBuilder(localEnv.WorkDir)
+	if *promptTemplate != "" {
+		if err := session.Prompt.LoadTemplate(*promptTemplate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// Print events
 	session.EventEmitter.On(func(e agent.Event) {
@@ -149,6 +564,14 @@ func cmdAgent(args []string) {
 			if name, ok := e.Data["tool_name"].(string); ok {
 				fmt.Fprintf(os.Stderr, "  [tool] %s\n", name)
 			}
+		case agent.EventToolCallOutputDelta:
+			if delta, ok := e.Data["delta"].(string); ok {
+				fmt.Fprint(os.Stderr, delta)
+			}
+		case agent.EventPolicyViolation:
+			fmt.Fprintf(os.Stderr, "  [policy] %v: %v\n", e.Data["rule"], e.Data["detail"])
+		case agent.EventContextCompacted:
+			fmt.Fprintf(os.Stderr, "  [context] prompt too long, %v\n", e.Data["summary"])
 		case agent.EventError:
 			if msg, ok := e.Data["error"].(string); ok {
 				fmt.Fprintf(os.Stderr, "  [error] %s\n", msg)
@@ -186,7 +609,33 @@ func cmdAgent(args []string) {
 		os.Exit(1)
 	}
 
-	if err := session.Submit(ctx, prompt); err != nil {
+	err := session.Submit(ctx, prompt)
+
+	// At the turn limit, ask whether to keep going if stdin is still free
+	// for an answer.
+	var answers *bufio.Reader
+	for err == nil && session.State == agent.StateTurnLimitReached {
+		if fs.NArg() == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: stopped at the turn limit of %d\n", *maxTurns)
+			break
+		}
+		if answers == nil {
+			answers = bufio.NewReader(os.Stdin)
+		}
+		fmt.Fprintf(os.Stderr, "Turn limit of %d reached. Continue? [y/N] ", *maxTurns)
+		answer, _ := answers.ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Fprintf(os.Stderr, "Warning: stopped at the turn limit of %d\n", *maxTurns)
+			break
+		}
+		err = session.Continue(ctx)
+	}
+
+	// A budget stop still ends with a summary turn, so print it below.
+	var budgetErr *agent.BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -202,42 +651,208 @@ func cmdAgent(args []string) {
 			}
 		}
 	}
+	fmt.Fprintf(os.Stderr, "Usage: %s\n", session.Usage())
+	if changes := session.Changes(); len(changes.Files) > 0 {
+		fmt.Fprintf(os.Stderr, "Changes: %s\n", changes)
+	}
+	if *exportPath != "" {
+		if err := exportTranscript(session, *exportPath, localEnv.Secrets); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: export transcript: %v\n", err)
+		}
+	}
+	if budgetErr != nil {
+		os.Exit(1)
+	}
+}
+
+// agentProfile resolves the provider and model (detecting defaults when
+// empty) and returns the matching agent profile.
+func agentProfile(provider, model string) *agent.ProviderProfile {
+	provider, model = resolveModel(provider, model)
+	switch provider {
+	case "openai":
+		return agent.DefaultOpenAIProfile(model)
+	case "gemini":
+		return agent.DefaultGeminiProfile(model)
+	case "xai":
+		return agent.DefaultXAIProfile(model)
+	case "mistral":
+		return agent.DefaultMistralProfile(model)
+	default:
+		return agent.DefaultAnthropicProfile(model)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// cmdEval runs agent evaluation scenarios and prints a summary report.
+func cmdEval(args []string) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	model := fs.String("model", "", "Model to use for live scenarios")
+	provider := fs.String("provider", "", "Provider (anthropic, openai, gemini, xai, mistral)")
+	recordDir := fs.String("record", "", "Write a transcript of each live run to this directory")
+	keep := fs.Bool("keep", false, "Keep scenario working directories")
+	jsonOut := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: attractor eval [options] <scenario.json|dir>...")
+		os.Exit(1)
+	}
+
+	scenarios, err := eval.LoadScenarios(fs.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Scenarios with a recording replay it; the rest need a provider.
+	cfg := loadConfig()
+	client := newClient(cfg)
+	defer client.Close()
+	reportDebugLogDir(client)
+
+	runner := &eval.Runner{
+		Profile:      agentProfile(cmp.Or(*provider, cfg.Provider), cmp.Or(*model, cfg.Model)),
+		Config:       agent.DefaultSessionConfig(),
+		RecordDir:    *recordDir,
+		KeepWorkDirs: *keep,
+	}
+	if client.HasProviders() {
+		runner.Client = client
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	report := runner.RunAll(ctx, scenarios)
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Print(report)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// exportTranscript writes the session transcript to path, with secret values
+// redacted. A .md extension selects markdown; anything else is JSON.
+func exportTranscript(session *agent.Session, path string, store *secrets.Store) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	opts := agent.ExportOptions{Format: agent.ExportJSON, Redact: store.Redact}
+	if strings.EqualFold(filepath.Ext(path), ".md") {
+		opts.Format = agent.ExportMarkdown
+	}
+	if err := session.Export(f, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // cmdServe starts the HTTP pipeline server.
 func cmdServe(args []string) {
 	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 	addr := fs.String("addr", ":8080", "Listen address")
+	logsDir := fs.String("logs", "", "Directory for run logs (default: logs_root from config, else none)")
+	keysFile := fs.String("api-keys", "", "JSON file of API keys; when set, requests must authenticate")
+	interviewer := fs.String("interviewer", "auto", "How human gates are answered: auto (approve) or web (queue for the web UI)")
+	allowPrivate := fs.Bool("webhook-allow-private", false, "Allow callback_url to reach loopback, private, and link-local addresses")
 	fs.Parse(args)
 
-	registry := handler.NewRegistry(nil, &handler.AutoApproveInterviewer{})
+	cfg := loadConfig()
+	var opts []pipeline.ServerOption
+	if logsRoot := cmp.Or(*logsDir, cfg.LogsRoot); logsRoot != "" {
+		opts = append(opts, pipeline.WithServerLogsRoot(logsRoot))
+	}
+	if *keysFile != "" {
+		keys, err := pipeline.LoadAPIKeys(*keysFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, pipeline.WithAPIKeys(keys...))
+		fmt.Fprintf(os.Stderr, "Loaded %d API keys\n", len(keys))
+	}
+
+	// Callbacks are signed when a secret is set; it is not a flag so it
+	// stays out of the process list.
+	opts = append(opts, pipeline.WithWebhooks(pipeline.WebhookConfig{
+		Secret:       os.Getenv("ATTRACTOR_WEBHOOK_SECRET"),
+		AllowPrivate: *allowPrivate,
+	}))
+
+	switch *interviewer {
+	case "auto":
+	case "web":
+		opts = append(opts, pipeline.WithRunResolver(func(questions *pipeline.QuestionQueue) pipeline.HandlerResolver {
+			return &registryAdapter{registry: handler.NewRegistry(nil, &handler.WebInterviewer{Questions: questions}, handler.WithShell(configShell(cfg)))}
+		}))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -interviewer %q (want auto or web)\n", *interviewer)
+		os.Exit(1)
+	}
+
+	registry := handler.NewRegistry(nil, &handler.AutoApproveInterviewer{}, handler.WithShell(configShell(cfg)))
 	resolver := &registryAdapter{registry: registry}
-	server := pipeline.NewServer(resolver)
+	server := pipeline.NewServer(resolver, opts...)
 
 	fmt.Fprintf(os.Stderr, "Listening on %s\n", *addr)
+	if *interviewer == "web" {
+		fmt.Fprintln(os.Stderr, "Answer human gates in the web UI at /ui")
+	}
 	if err := http.ListenAndServe(*addr, server.Handler()); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-// cmdValidate validates a DOT pipeline file.
+// cmdValidate validates a DOT pipeline file. Diagnostics are printed as
+// text, JSON, or SARIF 2.1.0; the exit status is 1 if any is an error.
 func cmdValidate(args []string) {
 	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: text, json, or sarif")
+	strict := fs.Bool("strict", false, "Warn about unknown attributes and repeated nodes and edges")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "Usage: attractor validate <pipeline.dot>")
+		fmt.Fprintln(os.Stderr, "Usage: attractor validate [options] <pipeline.dot>")
+		os.Exit(1)
+	}
+	switch *format {
+	case "text", "json", "sarif":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q (want text, json, or sarif)\n", *format)
 		os.Exit(1)
 	}
 
-	data, err := os.ReadFile(fs.Arg(0))
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
 		os.Exit(1)
 	}
 
-	graph, err := pipeline.Parse(string(data))
+	var parseOpts []pipeline.ParseOption
+	if *strict {
+		parseOpts = append(parseOpts, pipeline.WithStrict())
+	}
+	graph, err := pipeline.Parse(string(data), parseOpts...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Parse error: %v\n", err)
 		os.Exit(1)
@@ -246,28 +861,468 @@ func cmdValidate(args []string) {
 	diagnostics := pipeline.Validate(graph)
 	hasErrors := false
 	for _, d := range diagnostics {
-		fmt.Println(d.String())
 		if d.Severity == pipeline.SeverityError {
 			hasErrors = true
 		}
 	}
 
+	switch *format {
+	case "json":
+		if diagnostics == nil {
+			diagnostics = []pipeline.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{"file": path, "valid": !hasErrors, "diagnostics": diagnostics})
+	case "sarif":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(pipeline.DiagnosticsToSARIF(diagnostics, filepath.ToSlash(path)))
+	default:
+		for _, d := range diagnostics {
+			fmt.Println(d.String())
+		}
+		if len(diagnostics) == 0 {
+			fmt.Println("Valid.")
+		}
+	}
+
 	if hasErrors {
 		os.Exit(1)
 	}
-	if len(diagnostics) == 0 {
-		fmt.Println("Valid.")
+}
+
+// cmdLSP serves the Language Server Protocol over stdin and stdout for
+// editors editing pipeline DOT files.
+func cmdLSP(args []string) {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Parse(args)
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cmdDiff compares two DOT pipelines. Like diff(1), it exits 1 when they
+// differ.
+func cmdDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print the diff as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: attractor diff [options] <old.dot> <new.dot>")
+		os.Exit(2)
+	}
+
+	var graphs [2]*pipeline.Graph
+	for i, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(2)
+		}
+		graphs[i], err = pipeline.Parse(string(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Parse error in %s: %v\n", path, err)
+			os.Exit(2)
+		}
+	}
+
+	d := pipeline.Diff(graphs[0], graphs[1])
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+	} else {
+		fmt.Print(d)
+	}
+	if !d.Empty() {
+		os.Exit(1)
+	}
+}
+
+// cmdLogs prints the stage outcomes of a run from its logs directory.
+// With -follow it keeps printing stages as they finish until the run ends.
+func cmdLogs(args []string) {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print the run as JSON (one stage per line with -follow)")
+	follow := fs.Bool("follow", false, "Keep printing stages as they finish until the run ends")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: attractor logs [options] <run-dir>")
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+
+	run, err := pipeline.LoadRunLog(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !*follow {
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(run)
+		} else {
+			printRunHeader(run)
+			for _, s := range run.Stages {
+				printStageLog(s)
+			}
+			printRunSummary(run)
+		}
+		if run.Status == string(pipeline.StatusFail) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	enc := json.NewEncoder(os.Stdout)
+	if !*jsonOut {
+		printRunHeader(run)
+	}
+	printed := 0
+	for {
+		for _, s := range run.Stages[printed:] {
+			if *jsonOut {
+				enc.Encode(s)
+			} else {
+				printStageLog(s)
+			}
+		}
+		printed = len(run.Stages)
+		if run.Finished() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+		next, err := pipeline.LoadRunLog(dir)
+		if err != nil {
+			// Files can be caught mid-write; try again on the next tick.
+			continue
+		}
+		run = next
+	}
+	if *jsonOut {
+		run.Stages = nil
+		enc.Encode(run)
+	} else {
+		printRunSummary(run)
+	}
+	if run.Status == string(pipeline.StatusFail) {
+		os.Exit(1)
+	}
+}
+
+// cmdReport regenerates a run's report.md and report.html from its logs
+// directory and prints one of them.
+func cmdReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "md", "Format to print: md or html")
+	quiet := fs.Bool("q", false, "Write the report files without printing")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: attractor report [options] <run-dir>")
+		os.Exit(2)
+	}
+	name := pipeline.ReportMarkdownFile
+	switch *format {
+	case "md":
+	case "html":
+		name = pipeline.ReportHTMLFile
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (want md or html)\n", *format)
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+
+	if err := pipeline.WriteReport(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *quiet {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(data)
+}
+
+func printRunHeader(run *pipeline.RunLog) {
+	name := run.Name
+	if name == "" {
+		name = filepath.Base(run.Dir)
+	}
+	fmt.Printf("Run %s (%s)\n", name, run.Dir)
+	if run.Goal != "" {
+		fmt.Printf("Goal: %s\n", run.Goal)
+	}
+	fmt.Println()
+}
+
+func printStageLog(s pipeline.StageLog) {
+	line := fmt.Sprintf("  %-16s %-15s", s.Path(), s.Status)
+	if s.Duration != "" {
+		line += " " + s.Duration
+	}
+	if s.Retries > 0 {
+		line += fmt.Sprintf(" retries=%d", s.Retries)
+	}
+	if s.Usage != nil {
+		line += fmt.Sprintf(" tokens=%d", s.Usage.TotalTokens)
+		if s.Usage.CostUSD > 0 {
+			line += fmt.Sprintf(" cost=$%.4f", s.Usage.CostUSD)
+		}
+	}
+	fmt.Println(strings.TrimRight(line, " "))
+	if s.FailureReason != "" {
+		fmt.Printf("      reason: %s\n", s.FailureReason)
+	}
+}
+
+func printRunSummary(run *pipeline.RunLog) {
+	fmt.Println()
+	summary := fmt.Sprintf("Status: %s, stages=%d", run.Status, len(run.Stages))
+	if run.Duration != "" {
+		summary += ", duration=" + run.Duration
+	}
+	if run.Usage.TotalTokens > 0 {
+		summary += fmt.Sprintf(", tokens=%d", run.Usage.TotalTokens)
+	}
+	if run.Usage.CostUSD > 0 {
+		summary += fmt.Sprintf(", cost=$%.4f", run.Usage.CostUSD)
+	}
+	fmt.Println(summary)
+	if run.Error != "" {
+		fmt.Printf("Error: %s\n", run.Error)
+	}
+}
+
+// cmdConfig prints the settings in effect and where each came from.
+func cmdConfig(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print the settings as JSON")
+	fs.Parse(args)
+
+	cfg := loadConfig()
+	// Header values often carry credentials, so only their names are shown.
+	for name := range cfg.Headers {
+		cfg.Headers[name] = "[REDACTED]"
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(cfg)
+		return
+	}
+
+	fmt.Println("Config files (lowest precedence first):")
+	userPath := config.UserPath()
+	if !slices.Contains(cfg.Files, userPath) {
+		fmt.Printf("  %s (not found)\n", userPath)
+	}
+	for _, path := range cfg.Files {
+		fmt.Printf("  %s\n", path)
+	}
+	if len(cfg.Sources) == 0 {
+		fmt.Println("\nNo settings; built-in defaults apply.")
+		return
+	}
+	fmt.Println("\nSettings:")
+	for _, key := range cfg.Keys() {
+		var value interface{}
+		switch key {
+		case "provider":
+			value = cfg.Provider
+		case "model":
+			value = cfg.Model
+		case "logs_root":
+			value = cfg.LogsRoot
+		case "tools.jail_paths":
+			value = cfg.Tools.JailPaths
+		case "tools.disable_network":
+			value = cfg.Tools.DisableNetwork
+		case "tools.allow_commands":
+			value = cfg.Tools.AllowCommands
+		case "tools.deny_commands":
+			value = cfg.Tools.DenyCommands
+		case "tools.max_output_bytes":
+			value = cfg.Tools.MaxOutputBytes
+		case "tools.shell":
+			value = cfg.Tools.Shell
+		default:
+			if provider, ok := strings.CutPrefix(key, "max_concurrent."); ok {
+				value = cfg.MaxConcurrent[provider]
+			} else {
+				value = cfg.Headers[strings.TrimPrefix(key, "headers.")]
+			}
+		}
+		data, _ := json.Marshal(value)
+		fmt.Printf("  %-24s = %-40s # %s\n", key, data, cfg.Sources[key])
+	}
+}
+
+// cmdModels lists the models of each configured provider, from the
+// provider's API where possible and otherwise from the built-in catalog.
+func cmdModels(args []string) {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	provider := fs.String("provider", "", "Only list this provider's models")
+	offline := fs.Bool("offline", false, "List the built-in catalog without querying providers")
+	jsonOut := fs.Bool("json", false, "Print the models as JSON")
+	fs.Parse(args)
+
+	cfg := loadConfig()
+	client := newClient(cfg)
+	defer client.Close()
+
+	var lists []llm.ProviderModels
+	if *offline || !client.HasProviders() {
+		if !*offline {
+			fmt.Fprintln(os.Stderr, "No LLM provider configured; showing the built-in catalog.")
+		}
+		for _, name := range []string{"anthropic", "openai", "gemini", "xai", "mistral"} {
+			if *provider == "" || *provider == name {
+				lists = append(lists, llm.ProviderModels{Provider: name, Models: client.Catalog().List(name)})
+			}
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		lists = client.ListProviderModels(ctx, *provider)
+		if len(lists) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: provider %q is not configured\n", *provider)
+			os.Exit(1)
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(lists)
+		return
+	}
+	for i, pm := range lists {
+		if i > 0 {
+			fmt.Println()
+		}
+		source := "catalog"
+		if pm.Live {
+			source = "live"
+		}
+		fmt.Printf("%s (%s)\n", pm.Provider, source)
+		if pm.Err != nil {
+			fmt.Printf("  listing failed, showing the catalog: %v\n", pm.Err)
+		}
+		fmt.Printf("  %-34s %8s %6s %10s %10s\n", "MODEL", "CONTEXT", "TOOLS", "IN $/MTOK", "OUT $/MTOK")
+		for _, m := range pm.Models {
+			tools := "-"
+			if m.SupportsTools {
+				tools = "yes"
+			}
+			fmt.Printf("  %-34s %8s %6s %10s %10s\n", m.ID, formatTokens(m.ContextWindow), tools,
+				formatPrice(m.InputCostPerMTok), formatPrice(m.OutputCostPerMTok))
+		}
+	}
+}
+
+// formatTokens abbreviates a token count, e.g. 200000 as 200K; 0 is unknown.
+func formatTokens(n int) string {
+	switch {
+	case n == 0:
+		return "-"
+	case n%1000000 == 0:
+		return fmt.Sprintf("%dM", n/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%dK", n/1000)
+	default:
+		return fmt.Sprint(n)
 	}
 }
 
+// formatPrice formats a per-million-token price; 0 is unknown.
+func formatPrice(usd float64) string {
+	if usd == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", usd)
+}
+
+// loadConfig reads the user and project config files and ATTRACTOR_*
+// overrides, exiting if a file is malformed.
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: config: %v\n", err)
+		os.Exit(1)
+	}
+	for _, w := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: config: %s\n", w)
+	}
+	return cfg
+}
+
+// configPolicy returns the config's agent tool policy, exiting if a command
+// pattern doesn't compile. It is nil when the config has no [tools] table.
+func configPolicy(cfg *config.Config) *env.Policy {
+	policy, err := cfg.Policy()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: config: %v\n", err)
+		os.Exit(1)
+	}
+	return policy
+}
+
+// configShell returns the config's shell for agent and tool commands,
+// exiting if tools.shell names an unknown shell.
+func configShell(cfg *config.Config) env.Shell {
+	shell, err := cfg.Shell()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: config: %v\n", err)
+		os.Exit(1)
+	}
+	return shell
+}
+
+// newClient creates an LLM client from the environment, using the config's
+// default provider, headers, and concurrency limits.
+func newClient(cfg *config.Config, opts ...llm.ClientOption) *llm.Client {
+	opts = append([]llm.ClientOption{llm.WithHeaders(cfg.Headers)}, opts...)
+	for provider, n := range cfg.MaxConcurrent {
+		opts = append(opts, llm.WithMaxConcurrent(provider, n))
+	}
+	if cfg.Provider != "" {
+		opts = append(opts, llm.WithDefaultProvider(cfg.Provider))
+	}
+	return llm.FromEnv(opts...)
+}
+
 func requireProvider(client *llm.Client) {
 	if !client.HasProviders() {
 		fmt.Fprintln(os.Stderr, "Error: no LLM provider configured.")
-		fmt.Fprintln(os.Stderr, "Set one of: ANTHROPIC_API_KEY, OPENAI_API_KEY, GEMINI_API_KEY, or GOOGLE_API_KEY")
+		fmt.Fprintln(os.Stderr, "Set one of: ANTHROPIC_API_KEY, OPENAI_API_KEY, GEMINI_API_KEY, GOOGLE_API_KEY, XAI_API_KEY, or MISTRAL_API_KEY")
 		os.Exit(1)
 	}
 }
 
+// reportDebugLogDir tells the user where ATTRACTOR_LLM_DEBUG logs are written.
+func reportDebugLogDir(client *llm.Client) {
+	if dir := client.DebugLogDir(); dir != "" {
+		fmt.Fprintf(os.Stderr, "LLM debug logs: %s\n", dir)
+	}
+}
+
 // registryAdapter wraps handler.Registry to satisfy pipeline.HandlerResolver,
 // bridging the handler.Handler and pipeline.Handler interfaces.
 type registryAdapter struct {
@@ -282,6 +1337,18 @@ func (r *registryAdapter) Resolve(node *pipeline.Node) pipeline.Handler {
 	return h
 }
 
+// resolveModel fills in an empty provider from the configured API keys and
+// an empty model with the provider's default.
+func resolveModel(provider, model string) (string, string) {
+	if provider == "" {
+		provider = detectProvider()
+	}
+	if model == "" {
+		model = defaultModel(provider)
+	}
+	return provider, model
+}
+
 func detectProvider() string {
 	if os.Getenv("ANTHROPIC_API_KEY") != "" {
 		return "anthropic"
@@ -289,9 +1356,15 @@ func detectProvider() string {
 	if os.Getenv("OPENAI_API_KEY") != "" {
 		return "openai"
 	}
-	if os.Getenv("GEMINI_API_KEY") != "" || os.Getenv("GOOGLE_API_KEY") != "" {
+	if os.Getenv("GEMINI_API_KEY") != "" || os.Getenv("GOOGLE_API_KEY") != "" || gemini.VertexFromEnv() {
 		return "gemini"
 	}
+	if os.Getenv("XAI_API_KEY") != "" {
+		return "xai"
+	}
+	if os.Getenv("MISTRAL_API_KEY") != "" {
+		return "mistral"
+	}
 	return "anthropic"
 }
 
@@ -301,6 +1374,10 @@ func defaultModel(provider string) string {
 		return "gpt-4.1"
 	case "gemini":
 		return "gemini-2.5-pro"
+	case "xai":
+		return "grok-code-fast-1"
+	case "mistral":
+		return "codestral-latest"
 	default:
 		return "claude-sonnet-4-5-20250929"
 	}
@@ -0,0 +1,112 @@
+// Package sse parses Server-Sent Events streams as used by the LLM provider
+// streaming APIs. It follows the WHATWG event stream format: CRLF, LF, and CR
+// line endings, comment lines, multi-line data fields, and event/id fields.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxLineSize bounds a single line. Tool call deltas and base64 payloads can
+// be large, so this is well above bufio's default.
+const maxLineSize = 16 * 1024 * 1024
+
+// Event is a single dispatched event.
+type Event struct {
+	Event string // value of the last "event:" field, empty if none
+	Data  string // "data:" fields joined with "\n"
+	ID    string // value of the last "id:" field
+	Retry int    // reconnection time in milliseconds, 0 if not sent
+}
+
+// Reader reads events from a stream.
+type Reader struct {
+	scanner *bufio.Scanner
+}
+
+// NewReader returns a Reader for r.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	scanner.Split(scanLines)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next event with a non-empty data buffer. It returns io.EOF
+// when the stream ends; a trailing event without a terminating blank line is
+// still returned first.
+func (r *Reader) Next() (Event, error) {
+	var ev Event
+	var data []string
+	hasData := false
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			if hasData {
+				ev.Data = strings.Join(data, "\n")
+				return ev, nil
+			}
+			ev = Event{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				ev.ID = value
+			}
+		case "retry":
+			if n, err := strconv.Atoi(value); err == nil {
+				ev.Retry = n
+			}
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	if hasData {
+		ev.Data = strings.Join(data, "\n")
+		return ev, nil
+	}
+	return Event{}, io.EOF
+}
+
+// scanLines is a bufio.SplitFunc that accepts CRLF, LF, or a lone CR as the
+// line terminator.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if !atEOF {
+			return 0, nil, nil // need one more byte to tell CR from CRLF
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
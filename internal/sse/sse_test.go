@@ -0,0 +1,86 @@
+package sse
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, input string) []Event {
+	t.Helper()
+	r := NewReader(strings.NewReader(input))
+	var events []Event
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestReaderBasic(t *testing.T) {
+	events := readAll(t, "data: one\n\ndata: two\n\n")
+	if len(events) != 2 || events[0].Data != "one" || events[1].Data != "two" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestReaderLineEndings(t *testing.T) {
+	for name, input := range map[string]string{
+		"crlf": "event: ping\r\ndata: a\r\n\r\ndata: b\r\n\r\n",
+		"cr":   "event: ping\rdata: a\r\rdata: b\r\r",
+	} {
+		t.Run(name, func(t *testing.T) {
+			events := readAll(t, input)
+			if len(events) != 2 {
+				t.Fatalf("expected 2 events, got %+v", events)
+			}
+			if events[0].Event != "ping" || events[0].Data != "a" || events[1].Data != "b" {
+				t.Errorf("unexpected events: %+v", events)
+			}
+			if events[1].Event != "" {
+				t.Errorf("expected event name to reset between events, got %q", events[1].Event)
+			}
+		})
+	}
+}
+
+func TestReaderMultiLineDataAndComments(t *testing.T) {
+	events := readAll(t, ": keep-alive\nevent: message_start\nid: 7\ndata: {\"a\":\ndata:1}\nretry: 1500\n\n")
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %+v", events)
+	}
+	ev := events[0]
+	if ev.Data != "{\"a\":\n1}" {
+		t.Errorf("expected joined data, got %q", ev.Data)
+	}
+	if ev.Event != "message_start" || ev.ID != "7" || ev.Retry != 1500 {
+		t.Errorf("unexpected fields: %+v", ev)
+	}
+}
+
+func TestReaderSkipsEventsWithoutData(t *testing.T) {
+	events := readAll(t, "event: ping\n\n: comment only\n\ndata: x\n\n")
+	if len(events) != 1 || events[0].Data != "x" || events[0].Event != "" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestReaderTrailingEventWithoutBlankLine(t *testing.T) {
+	events := readAll(t, "data: [DONE]")
+	if len(events) != 1 || events[0].Data != "[DONE]" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestReaderLargeLine(t *testing.T) {
+	big := strings.Repeat("x", 2*1024*1024)
+	events := readAll(t, "data: "+big+"\n\n")
+	if len(events) != 1 || len(events[0].Data) != len(big) {
+		t.Errorf("expected one %d-byte event", len(big))
+	}
+}
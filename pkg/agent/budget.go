@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BudgetExceededError is returned by Submit when the session reaches
+// SessionConfig.MaxTotalTokens or MaxCostUSD.
+type BudgetExceededError struct {
+	Limit string  // "tokens" or "cost"
+	Used  float64 // tokens, or USD for cost
+	Max   float64
+}
+
+func (e *BudgetExceededError) Error() string {
+	if e.Limit == "cost" {
+		return fmt.Sprintf("session budget exceeded: cost $%.4f reached limit $%.4f", e.Used, e.Max)
+	}
+	return fmt.Sprintf("session budget exceeded: %.0f tokens reached limit %.0f", e.Used, e.Max)
+}
+
+// budgetSummaryPrompt asks the model to wrap up once the budget is spent.
+const budgetSummaryPrompt = "The session budget has been exhausted and no more tool calls are possible. Summarize what you accomplished, what remains unfinished, and any next steps."
+
+// checkBudget reports whether the session has reached a configured limit.
+func (s *Session) checkBudget() *BudgetExceededError {
+	if s.Config.MaxTotalTokens <= 0 && s.Config.MaxCostUSD <= 0 {
+		return nil
+	}
+	usage := s.Usage()
+	if s.Config.MaxTotalTokens > 0 {
+		used := usage.Total.InputTokens + usage.Total.OutputTokens
+		if used >= s.Config.MaxTotalTokens {
+			return &BudgetExceededError{Limit: "tokens", Used: float64(used), Max: float64(s.Config.MaxTotalTokens)}
+		}
+	}
+	if s.Config.MaxCostUSD > 0 && usage.CostUSD >= s.Config.MaxCostUSD {
+		return &BudgetExceededError{Limit: "cost", Used: usage.CostUSD, Max: s.Config.MaxCostUSD}
+	}
+	return nil
+}
+
+// finishOverBudget emits EventBudgetExceeded and asks the model for a final
+// summary without tools, so the session ends with a usable answer.
+func (s *Session) finishOverBudget(ctx context.Context, budgetErr *BudgetExceededError) error {
+	s.EventEmitter.Emit(Event{
+		Type:      EventBudgetExceeded,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"limit": budgetErr.Limit,
+			"used":  budgetErr.Used,
+			"max":   budgetErr.Max,
+		},
+	})
+
+	s.History = append(s.History, &SteeringTurn{
+		Content:   budgetSummaryPrompt,
+		Timestamp: time.Now(),
+	})
+	req := s.buildRequest()
+	req.Tools = nil
+	resp, err := s.callLLM(ctx, req)
+	if err != nil {
+		s.EventEmitter.Emit(Event{
+			Type:      EventError,
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"error": err.Error()},
+		})
+		return budgetErr
+	}
+	s.History = append(s.History, &AssistantTurn{
+		Content:    resp.Content,
+		Reasoning:  resp.Reasoning,
+		Usage:      resp.Usage,
+		ResponseID: resp.ID,
+		Timestamp:  time.Now(),
+	})
+	s.recordUsage(req.Model, resp.Usage)
+	s.EventEmitter.Emit(Event{
+		Type:      EventTurnCompleted,
+		Timestamp: time.Now(),
+		Data:      map[string]interface{}{"content": resp.Content},
+	})
+	return budgetErr
+}
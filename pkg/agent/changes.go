@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// FileReader is implemented by execution environments that can read files
+// directly. Sessions use it to snapshot files before write_file and
+// edit_file so Changes can report diffs; without it only paths are tracked.
+type FileReader interface {
+	ReadFile(path string) ([]byte, error)
+}
+
+// ChangeStatus describes how a file changed during a session.
+type ChangeStatus string
+
+const (
+	ChangeCreated  ChangeStatus = "created"
+	ChangeModified ChangeStatus = "modified"
+	ChangeDeleted  ChangeStatus = "deleted"
+)
+
+// FileChange is one file written or edited by the agent.
+type FileChange struct {
+	Path    string       `json:"path"`
+	Status  ChangeStatus `json:"status"`
+	Added   int          `json:"added"`
+	Removed int          `json:"removed"`
+	Diff    string       `json:"diff,omitempty"`
+}
+
+// ChangeSummary lists the files the agent changed, in the order they were
+// first touched. Files whose content ended up unchanged are omitted.
+type ChangeSummary struct {
+	Files   []FileChange `json:"files"`
+	Added   int          `json:"added"`
+	Removed int          `json:"removed"`
+}
+
+// String renders the summary as one line per file.
+func (c ChangeSummary) String() string {
+	if len(c.Files) == 0 {
+		return "no files changed"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d file(s) changed (+%d -%d)", len(c.Files), c.Added, c.Removed)
+	for _, f := range c.Files {
+		fmt.Fprintf(&sb, "\n  %-8s %s (+%d -%d)", f.Status, f.Path, f.Added, f.Removed)
+	}
+	return sb.String()
+}
+
+// Diff concatenates the unified diffs of all changed files.
+func (c ChangeSummary) Diff() string {
+	var sb strings.Builder
+	for _, f := range c.Files {
+		sb.WriteString(f.Diff)
+	}
+	return sb.String()
+}
+
+// fileSnapshot is a file's content before the agent first modified it.
+type fileSnapshot struct {
+	path    string
+	content []byte
+	existed bool
+	known   bool // false when the environment cannot read files
+}
+
+// isFileWriteTool reports whether a tool call modifies the file named by its
+// "path" argument.
+func isFileWriteTool(name string) bool {
+	return name == "write_file" || name == "edit_file" || name == "notebook_edit"
+}
+
+// snapshotBeforeWrite records the original content of the file a write tool
+// is about to modify. Only the first write to each path is snapshotted.
+func (s *Session) snapshotBeforeWrite(toolName string, arguments json.RawMessage) {
+	if !isFileWriteTool(toolName) {
+		return
+	}
+	var params struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal(arguments, &params) != nil || params.Path == "" {
+		return
+	}
+	s.snapshotPath(params.Path)
+}
+
+// snapshotPath records the original content of path unless it already has a
+// snapshot.
+func (s *Session) snapshotPath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findSnapshot(path) != nil {
+		return
+	}
+	snap := &fileSnapshot{path: path}
+	if fr, ok := s.ExecutionEnv.(FileReader); ok {
+		data, err := fr.ReadFile(path)
+		switch {
+		case err == nil:
+			snap.content, snap.existed, snap.known = data, true, true
+		case errors.Is(err, fs.ErrNotExist):
+			snap.known = true
+		}
+	}
+	s.snapshots = append(s.snapshots, snap)
+}
+
+// Changes compares every file the agent wrote or edited against its current
+// content and returns the resulting summary.
+func (s *Session) Changes() ChangeSummary {
+	s.mu.Lock()
+	snapshots := append([]*fileSnapshot(nil), s.snapshots...)
+	s.mu.Unlock()
+
+	fr, _ := s.ExecutionEnv.(FileReader)
+	summary := ChangeSummary{Files: []FileChange{}}
+	for _, snap := range snapshots {
+		change := FileChange{Path: snap.path, Status: ChangeModified}
+		if fr == nil || !snap.known {
+			summary.Files = append(summary.Files, change)
+			continue
+		}
+		current, err := fr.ReadFile(snap.path)
+		exists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		switch {
+		case !snap.existed && !exists:
+			continue
+		case !snap.existed:
+			change.Status = ChangeCreated
+		case !exists:
+			change.Status = ChangeDeleted
+		}
+		change.Diff, change.Added, change.Removed = unifiedDiff(snap.path, snap.content, current, snap.existed, exists)
+		if change.Diff == "" && snap.existed == exists {
+			continue
+		}
+		summary.Files = append(summary.Files, change)
+		summary.Added += change.Added
+		summary.Removed += change.Removed
+	}
+	return summary
+}
+
+// emitSessionSummary emits EventSessionSummary if the agent changed any files.
+func (s *Session) emitSessionSummary() {
+	summary := s.Changes()
+	if len(summary.Files) == 0 {
+		return
+	}
+	s.EventEmitter.Emit(Event{
+		Type:      EventSessionSummary,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"files":   summary.Files,
+			"added":   summary.Added,
+			"removed": summary.Removed,
+			"diff":    summary.Diff(),
+		},
+	})
+}
+
+// executeDiagnostics runs the environment's diagnostics tool, checking the
+// files changed in this session when the call names no paths.
+func (s *Session) executeDiagnostics(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var params struct {
+		Paths []string `json:"paths"`
+	}
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &params); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	if len(params.Paths) == 0 {
+		for _, f := range s.Changes().Files {
+			if f.Status != ChangeDeleted {
+				params.Paths = append(params.Paths, f.Path)
+			}
+		}
+		if len(params.Paths) == 0 {
+			return "No files changed in this session.", nil
+		}
+		data, err := json.Marshal(params)
+		if err != nil {
+			return "", err
+		}
+		arguments = data
+	}
+	return s.ExecutionEnv.Execute(ctx, "diagnostics", arguments)
+}
@@ -0,0 +1,28 @@
+package agent
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+
+	diff, added, removed := unifiedDiff("x.txt", []byte(before), []byte(after), true, true)
+	expected := "--- a/x.txt\n+++ b/x.txt\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -10,3 +10,4 @@\n j\n k\n l\n+m\n"
+	if diff != expected {
+		t.Errorf("expected diff:\n%s\ngot:\n%s", expected, diff)
+	}
+	if added != 2 || removed != 1 {
+		t.Errorf("expected +2 -1, got +%d -%d", added, removed)
+	}
+
+	if diff, _, _ := unifiedDiff("x.txt", []byte(before), []byte(before), true, true); diff != "" {
+		t.Errorf("expected empty diff for identical content, got %q", diff)
+	}
+
+	diff, _, removed = unifiedDiff("x.txt", []byte("a\nb\n"), nil, true, false)
+	if diff != "--- a/x.txt\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-a\n-b\n" || removed != 2 {
+		t.Errorf("unexpected deletion diff %q", diff)
+	}
+}
@@ -0,0 +1,75 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// ToolFunc implements a custom tool. It receives the model's JSON arguments
+// and returns the tool output; an error is sent to the model as the result.
+type ToolFunc func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// customTool is a tool added with RegisterTool.
+type customTool struct {
+	schema llm.Tool
+	run    ToolFunc
+}
+
+// RegisterTool adds a tool implemented by fn, replacing any built-in or
+// custom tool of the same name. schema is the JSON Schema of the arguments;
+// its top-level "description", if any, becomes the tool description the
+// model sees. Tools reports the tool, so sessions offer it automatically,
+// and its output goes through the same policy limits and secret redaction
+// as built-in tools.
+func (e *LocalEnvironment) RegisterTool(name string, schema json.RawMessage, fn ToolFunc) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("register tool: name and function are required")
+	}
+	var parsed struct {
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return fmt.Errorf("register tool %s: invalid schema: %w", name, err)
+	}
+	tool := customTool{
+		schema: llm.Tool{Name: name, Description: parsed.Description, Parameters: schema},
+		run:    fn,
+	}
+
+	e.customMu.Lock()
+	defer e.customMu.Unlock()
+	for i, t := range e.custom {
+		if t.schema.Name == name {
+			e.custom[i] = tool
+			return nil
+		}
+	}
+	e.custom = append(e.custom, tool)
+	return nil
+}
+
+// lookupCustom returns the registered tool named name.
+func (e *LocalEnvironment) lookupCustom(name string) (customTool, bool) {
+	e.customMu.RLock()
+	defer e.customMu.RUnlock()
+	for _, t := range e.custom {
+		if t.schema.Name == name {
+			return t, true
+		}
+	}
+	return customTool{}, false
+}
+
+// customSchemas returns the schemas of the registered tools.
+func (e *LocalEnvironment) customSchemas() []llm.Tool {
+	e.customMu.RLock()
+	defer e.customMu.RUnlock()
+	schemas := make([]llm.Tool, len(e.custom))
+	for i, t := range e.custom {
+		schemas[i] = t.schema
+	}
+	return schemas
+}
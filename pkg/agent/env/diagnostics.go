@@ -0,0 +1,227 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diagnosticsTimeout bounds one diagnostics call. Checkers such as go vet
+// build packages, so they get longer than Timeout.
+const diagnosticsTimeout = 2 * time.Minute
+
+// Diagnostic is one problem a Checker reported.
+type Diagnostic struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"` // "error" or "warning"
+	Message  string `json:"message"`
+	Source   string `json:"source"`
+}
+
+// String formats d as path:line:col: severity: message (source).
+func (d Diagnostic) String() string {
+	pos := fmt.Sprintf("%s:%d", d.Path, d.Line)
+	if d.Column > 0 {
+		pos += fmt.Sprintf(":%d", d.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", pos, d.Severity, d.Message, d.Source)
+}
+
+// Checker reports diagnostics for the files of one language. Run receives
+// the working directory, the environment to start commands with (the one
+// bash commands get, so a DisableNetwork policy applies), and the files to
+// check, relative to the directory, and returns the problems found; a
+// checker that finds problems should not also return an error.
+type Checker struct {
+	Name       string
+	Extensions []string // e.g. ".go"
+	Run        func(ctx context.Context, dir string, env []string, files []string) ([]Diagnostic, error)
+}
+
+// handles reports whether c checks path.
+func (c Checker) handles(path string) bool {
+	return slices.Contains(c.Extensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// DefaultCheckers returns the checkers used when LocalEnvironment.Checkers
+// is nil: gopls check for Go files, or go vet where gopls is not installed.
+func DefaultCheckers() []Checker {
+	return []Checker{GoChecker()}
+}
+
+// GoChecker checks Go files with gopls check if it is on PATH, and otherwise
+// with go vet on the packages containing them.
+func GoChecker() Checker {
+	return Checker{
+		Name:       "go",
+		Extensions: []string{".go"},
+		Run: func(ctx context.Context, dir string, env []string, files []string) ([]Diagnostic, error) {
+			if _, err := exec.LookPath("gopls"); err == nil {
+				return CommandChecker("gopls", "error", "gopls", "check").Run(ctx, dir, env, files)
+			}
+			var pkgs []string
+			for _, f := range files {
+				pkg := "./" + filepath.ToSlash(filepath.Dir(f))
+				if !slices.Contains(pkgs, pkg) {
+					pkgs = append(pkgs, pkg)
+				}
+			}
+			return runChecker(ctx, dir, env, "go vet", "error", append([]string{"go", "vet"}, pkgs...))
+		},
+	}
+}
+
+// CommandChecker returns a Checker that runs argv with the files appended
+// and parses output lines of the form path:line[:col]: message, as printed
+// by most compilers and linters, or with gopls's column span,
+// path:line:col-[line:]col: message. A message starting with "warning:" or
+// "error:" sets the severity; otherwise it is severity.
+func CommandChecker(name, severity string, argv ...string) Checker {
+	return Checker{
+		Name: name,
+		Run: func(ctx context.Context, dir string, env []string, files []string) ([]Diagnostic, error) {
+			return runChecker(ctx, dir, env, name, severity, append(slices.Clone(argv), files...))
+		},
+	}
+}
+
+// diagnosticLine matches path:line[:col]: message, with go vet's optional
+// "vet: " prefix and the end of a gopls span, "-col" or "-line:col", after
+// the column.
+var diagnosticLine = regexp.MustCompile(`^(?:vet: )?([^:\s][^:]*):(\d+)(?::(\d+)(?:-(?:\d+:)?\d+)?)?:\s*(.*)$`)
+
+// runChecker runs argv in dir with the environment env and parses its
+// combined output. A failing exit status is only an error when no
+// diagnostics were parsed, since checkers usually exit non-zero when they
+// find problems.
+func runChecker(ctx context.Context, dir string, env []string, source, severity string, argv []string) ([]Diagnostic, error) {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	runErr := cmd.Run()
+
+	var diags []Diagnostic
+	for _, line := range strings.Split(out.String(), "\n") {
+		m := diagnosticLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{Path: relativeTo(dir, m[1]), Severity: severity, Message: m[4], Source: source}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		for _, sev := range []string{"error", "warning"} {
+			if rest, ok := strings.CutPrefix(d.Message, sev+":"); ok {
+				d.Severity, d.Message = sev, strings.TrimSpace(rest)
+			}
+		}
+		diags = append(diags, d)
+	}
+	if runErr != nil && len(diags) == 0 {
+		if _, ok := runErr.(*exec.ExitError); !ok || ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %w", source, runErr)
+		}
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", source, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", source, runErr)
+	}
+	return diags, nil
+}
+
+// relativeTo returns path relative to dir when it is inside dir.
+func relativeTo(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// diagnostics runs the checkers for the given files, grouping files by
+// checker, and lists the problems found followed by a count.
+func (e *LocalEnvironment) diagnostics(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Paths []string `json:"paths"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if len(params.Paths) == 0 {
+		return "", fmt.Errorf("paths is required")
+	}
+
+	checkers := e.Checkers
+	if checkers == nil {
+		checkers = DefaultCheckers()
+	}
+	byChecker := make([][]string, len(checkers))
+	var unchecked []string
+	for _, p := range params.Paths {
+		resolved, err := e.jailPath(p)
+		if err != nil {
+			return "", err
+		}
+		rel := relativeTo(e.WorkDir, resolved)
+		i := slices.IndexFunc(checkers, func(c Checker) bool { return c.handles(rel) })
+		if i < 0 {
+			unchecked = append(unchecked, p)
+			continue
+		}
+		if !slices.Contains(byChecker[i], rel) {
+			byChecker[i] = append(byChecker[i], rel)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, max(e.Timeout, diagnosticsTimeout))
+	defer cancel()
+	var diags []Diagnostic
+	for i, c := range checkers {
+		if len(byChecker[i]) == 0 {
+			continue
+		}
+		found, err := c.Run(ctx, e.WorkDir, e.commandEnv(), byChecker[i])
+		if err != nil {
+			return "", fmt.Errorf("%s checker: %w", c.Name, err)
+		}
+		diags = append(diags, found...)
+	}
+	return formatDiagnostics(diags, unchecked), nil
+}
+
+// formatDiagnostics lists diags, one per line, and a summary count.
+func formatDiagnostics(diags []Diagnostic, unchecked []string) string {
+	var sb strings.Builder
+	errs := 0
+	for _, d := range diags {
+		if d.Severity == "error" {
+			errs++
+		}
+		sb.WriteString(d.String())
+		sb.WriteString("\n")
+	}
+	if len(diags) == 0 {
+		sb.WriteString("No problems found.")
+	} else {
+		fmt.Fprintf(&sb, "%d error(s), %d warning(s)", errs, len(diags)-errs)
+	}
+	if len(unchecked) > 0 {
+		fmt.Fprintf(&sb, "\nNo checker for: %s", strings.Join(unchecked, ", "))
+	}
+	return sb.String()
+}
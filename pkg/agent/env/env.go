@@ -2,26 +2,54 @@
 package env
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/agent/tools"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // Environment is the interface for tool execution.
 type Environment interface {
 	Execute(ctx context.Context, toolName string, arguments json.RawMessage) (string, error)
+	// Tools returns the schemas of the tools Execute implements.
+	Tools() []llm.Tool
 }
 
 // LocalEnvironment executes tools on the local filesystem.
 type LocalEnvironment struct {
 	WorkDir string
 	Timeout time.Duration
+	// Secrets, if set, expands ${secret:NAME} in bash commands and redacts
+	// resolved values from all tool output.
+	Secrets *secrets.Store
+	// Policy, if set, restricts commands, paths, network use, and output size.
+	// Rejected calls return a *PolicyViolation.
+	Policy *Policy
+	// Env holds extra KEY=VALUE variables for bash commands, added after
+	// the filtered process environment so they take precedence.
+	Env []string
+	// Shell runs bash tool commands; the zero value uses DefaultShell, so
+	// commands run with pwsh or cmd on Windows.
+	Shell Shell
+	// Checkers run the diagnostics tool, the first whose extensions match
+	// checking each file; nil uses DefaultCheckers.
+	Checkers []Checker
+
+	indexOnce sync.Once
+	index     *CodeIndex
+
+	customMu sync.RWMutex
+	custom   []customTool
 }
 
 // NewLocalEnvironment creates a local execution environment.
@@ -35,24 +63,90 @@ func NewLocalEnvironment(workDir string) *LocalEnvironment {
 	}
 }
 
-// Execute runs a tool by name.
+// Execute runs a tool by name. Secrets are redacted from the output before
+// it is limited, so a secret cut by the limit is still recognized.
 func (e *LocalEnvironment) Execute(ctx context.Context, toolName string, arguments json.RawMessage) (string, error) {
-	switch toolName {
-	case "read_file":
-		return e.readFile(arguments)
-	case "write_file":
-		return e.writeFile(arguments)
-	case "edit_file":
-		return e.editFile(arguments)
-	case "bash":
-		return e.bash(ctx, arguments)
-	case "glob":
-		return e.glob(arguments)
-	case "grep":
-		return e.grep(ctx, arguments)
-	default:
-		return "", fmt.Errorf("unknown tool: %s", toolName)
+	output, err := e.execute(ctx, toolName, arguments)
+	if e.Secrets != nil {
+		output = e.Secrets.Redact(output)
+		if err != nil {
+			if msg := e.Secrets.Redact(err.Error()); msg != err.Error() {
+				err = errors.New(msg)
+			}
+		}
 	}
+	return e.Policy.LimitOutput(output), err
+}
+
+func (e *LocalEnvironment) execute(ctx context.Context, toolName string, arguments json.RawMessage) (string, error) {
+	if t, ok := e.lookupCustom(toolName); ok {
+		return t.run(ctx, arguments)
+	}
+	for _, t := range builtinTools {
+		if t.schema().Name == toolName {
+			return t.run(e, ctx, arguments)
+		}
+	}
+	return "", fmt.Errorf("unknown tool: %s", toolName)
+}
+
+// builtinTool pairs a tool's schema with its implementation, so the schemas
+// Tools reports always match what Execute runs.
+type builtinTool struct {
+	schema func() llm.Tool
+	run    func(e *LocalEnvironment, ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+var builtinTools = []builtinTool{
+	{tools.ReadFile, withoutContext((*LocalEnvironment).readFile)},
+	{tools.WriteFile, withoutContext((*LocalEnvironment).writeFile)},
+	{tools.EditFile, withoutContext((*LocalEnvironment).editFile)},
+	{tools.NotebookRead, withoutContext((*LocalEnvironment).notebookRead)},
+	{tools.NotebookEdit, withoutContext((*LocalEnvironment).notebookEdit)},
+	{tools.Bash, (*LocalEnvironment).bash},
+	{tools.GlobSearch, withoutContext((*LocalEnvironment).glob)},
+	{tools.GrepSearch, (*LocalEnvironment).grep},
+	{tools.SearchCode, withoutContext((*LocalEnvironment).searchCode)},
+	{tools.Diagnostics, (*LocalEnvironment).diagnostics},
+}
+
+func withoutContext(run func(*LocalEnvironment, json.RawMessage) (string, error)) func(*LocalEnvironment, context.Context, json.RawMessage) (string, error) {
+	return func(e *LocalEnvironment, _ context.Context, args json.RawMessage) (string, error) {
+		return run(e, args)
+	}
+}
+
+// BuiltinTools returns the schemas of the tools every LocalEnvironment
+// implements.
+func BuiltinTools() []llm.Tool {
+	schemas := make([]llm.Tool, len(builtinTools))
+	for i, t := range builtinTools {
+		schemas[i] = t.schema()
+	}
+	return schemas
+}
+
+// Tools returns the schemas of the tools Execute implements: the built-in
+// tools and those added with RegisterTool, which replace built-ins of the
+// same name. The bash tool's description names the shell when it is not
+// bash, so the model writes commands for it.
+func (e *LocalEnvironment) Tools() []llm.Tool {
+	custom := e.customSchemas()
+	overridden := make(map[string]bool, len(custom))
+	for _, t := range custom {
+		overridden[t.Name] = true
+	}
+	var schemas []llm.Tool
+	for _, t := range BuiltinTools() {
+		if overridden[t.Name] {
+			continue
+		}
+		if shell := e.Shell.String(); t.Name == "bash" && shell != "bash" {
+			t.Description = fmt.Sprintf("Execute a command with %s (%s) and return the output. Use for running tests, building, git operations, etc.", shell, runtime.GOOS)
+		}
+		schemas = append(schemas, t)
+	}
+	return append(schemas, custom...)
 }
 
 func (e *LocalEnvironment) readFile(args json.RawMessage) (string, error) {
@@ -65,13 +159,19 @@ func (e *LocalEnvironment) readFile(args json.RawMessage) (string, error) {
 		return "", fmt.Errorf("invalid arguments: %w", err)
 	}
 
-	path := e.resolvePath(params.Path)
+	path, err := e.jailPath(params.Path)
+	if err != nil {
+		return "", err
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", fmt.Errorf("read file: %w", err)
 	}
 
-	content := string(data)
+	content, ok := decodeText(data)
+	if !ok {
+		return describeBinary(params.Path, data), nil
+	}
 	if params.Offset > 0 || params.Limit > 0 {
 		lines := strings.Split(content, "\n")
 		start := params.Offset
@@ -85,7 +185,7 @@ func (e *LocalEnvironment) readFile(args json.RawMessage) (string, error) {
 		content = strings.Join(lines[start:end], "\n")
 	}
 
-	return content, nil
+	return truncateLongLines(content), nil
 }
 
 func (e *LocalEnvironment) writeFile(args json.RawMessage) (string, error) {
@@ -97,7 +197,10 @@ func (e *LocalEnvironment) writeFile(args json.RawMessage) (string, error) {
 		return "", fmt.Errorf("invalid arguments: %w", err)
 	}
 
-	path := e.resolvePath(params.Path)
+	path, err := e.jailPath(params.Path)
+	if err != nil {
+		return "", err
+	}
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return "", fmt.Errorf("create directory: %w", err)
@@ -120,7 +223,10 @@ func (e *LocalEnvironment) editFile(args json.RawMessage) (string, error) {
 		return "", fmt.Errorf("invalid arguments: %w", err)
 	}
 
-	path := e.resolvePath(params.Path)
+	path, err := e.jailPath(params.Path)
+	if err != nil {
+		return "", err
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", fmt.Errorf("read file: %w", err)
@@ -172,6 +278,17 @@ func filterEnvironment() []string {
 	return filtered
 }
 
+// commandEnv returns the environment of the commands e runs, from bash
+// calls and diagnostics checkers alike: the filtered process environment
+// plus Env, and under a DisableNetwork policy, an unroutable HTTP proxy.
+func (e *LocalEnvironment) commandEnv() []string {
+	vars := append(filterEnvironment(), e.Env...)
+	if e.Policy != nil && e.Policy.DisableNetwork {
+		vars = append(vars, disabledNetworkEnv...)
+	}
+	return vars
+}
+
 func (e *LocalEnvironment) bash(ctx context.Context, args json.RawMessage) (string, error) {
 	var params struct {
 		Command   string `json:"command"`
@@ -189,15 +306,26 @@ func (e *LocalEnvironment) bash(ctx context.Context, args json.RawMessage) (stri
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "bash", "-c", params.Command)
+	if err := e.Policy.CheckCommand(params.Command); err != nil {
+		return "", err
+	}
+
+	command, err := e.Secrets.Expand(params.Command)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := e.Shell.Command(ctx, command)
 	cmd.Dir = e.WorkDir
-	cmd.Env = filterEnvironment()
+	cmd.Env = e.commandEnv()
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	stdout, stderr := newStreamWriters(ctx, e.Secrets, e.Policy)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
 	output := stdout.String()
 	if stderr.Len() > 0 {
 		output += "\nSTDERR:\n" + stderr.String()
@@ -209,58 +337,112 @@ func (e *LocalEnvironment) bash(ctx context.Context, args json.RawMessage) (stri
 	return output, nil
 }
 
-func (e *LocalEnvironment) glob(args json.RawMessage) (string, error) {
+// searchCode runs a ranked symbol and text search over WorkDir. The index is
+// built on first use and refreshed incrementally on each call.
+func (e *LocalEnvironment) searchCode(args json.RawMessage) (string, error) {
 	var params struct {
-		Pattern string `json:"pattern"`
-		Path    string `json:"path"`
+		Query string `json:"query"`
+		Path  string `json:"path"`
+		Limit int    `json:"limit"`
 	}
 	if err := json.Unmarshal(args, &params); err != nil {
 		return "", fmt.Errorf("invalid arguments: %w", err)
 	}
 
-	searchDir := e.WorkDir
+	dir := ""
 	if params.Path != "" {
-		searchDir = e.resolvePath(params.Path)
+		resolved, err := e.jailPath(params.Path)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(e.WorkDir, resolved)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("path %s is outside the working directory", params.Path)
+		}
+		dir = rel
 	}
 
-	pattern := filepath.Join(searchDir, params.Pattern)
-	matches, err := filepath.Glob(pattern)
+	e.indexOnce.Do(func() { e.index = NewCodeIndex(e.WorkDir) })
+	results, err := e.index.Search(params.Query, dir, params.Limit)
 	if err != nil {
-		return "", fmt.Errorf("glob: %w", err)
+		return "", err
+	}
+	if len(results) == 0 {
+		return "No matches found.", nil
 	}
 
-	return strings.Join(matches, "\n"), nil
+	var sb strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		if r.Symbol != nil {
+			fmt.Fprintf(&sb, "%s:%d  %s %s\n", r.Path, r.Line, r.Symbol.Kind, r.Symbol.Name)
+		} else {
+			fmt.Fprintf(&sb, "%s:%d\n", r.Path, r.Line)
+		}
+		sb.WriteString(r.Snippet)
+	}
+	return sb.String(), nil
 }
 
-func (e *LocalEnvironment) grep(ctx context.Context, args json.RawMessage) (string, error) {
-	var params struct {
-		Pattern string `json:"pattern"`
-		Path    string `json:"path"`
-		Glob    string `json:"glob"`
-	}
-	if err := json.Unmarshal(args, &params); err != nil {
-		return "", fmt.Errorf("invalid arguments: %w", err)
+// ReadFile returns the raw content of path, resolved against WorkDir and
+// subject to the path policy.
+func (e *LocalEnvironment) ReadFile(path string) ([]byte, error) {
+	resolved, err := e.jailPath(path)
+	if err != nil {
+		return nil, err
 	}
+	return os.ReadFile(resolved)
+}
 
-	searchPath := e.WorkDir
-	if params.Path != "" {
-		searchPath = e.resolvePath(params.Path)
+// WriteFile replaces the content of path, creating parent directories as
+// needed.
+func (e *LocalEnvironment) WriteFile(path string, data []byte) error {
+	resolved, err := e.jailPath(path)
+	if err != nil {
+		return err
 	}
+	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(resolved, data, 0o644)
+}
 
-	cmdArgs := []string{"-rn", params.Pattern, searchPath}
-	if params.Glob != "" {
-		cmdArgs = append([]string{"--include=" + params.Glob}, cmdArgs...)
+// ModTime returns the modification time of path, a file or directory.
+func (e *LocalEnvironment) ModTime(path string) (time.Time, error) {
+	resolved, err := e.jailPath(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return time.Time{}, err
 	}
+	return info.ModTime(), nil
+}
 
-	cmd := exec.CommandContext(ctx, "grep", cmdArgs...)
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
+// RemoveFile deletes path.
+func (e *LocalEnvironment) RemoveFile(path string) error {
+	resolved, err := e.jailPath(path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(resolved)
+}
 
-	cmd.Run() // grep returns non-zero if no matches
-	return stdout.String(), nil
+// jailPath resolves path against WorkDir and applies the path policy.
+func (e *LocalEnvironment) jailPath(path string) (string, error) {
+	resolved := e.resolvePath(path)
+	if err := e.Policy.CheckPath(e.WorkDir, resolved); err != nil {
+		return "", err
+	}
+	return resolved, nil
 }
 
 func (e *LocalEnvironment) resolvePath(path string) string {
+	// Accept forward slashes on Windows, as models usually write them.
+	path = filepath.FromSlash(path)
 	if filepath.IsAbs(path) {
 		return path
 	}
@@ -1,13 +1,23 @@
 package env
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // helper to create a LocalEnvironment pointing at a temp directory.
@@ -99,6 +109,48 @@ func TestReadFile(t *testing.T) {
 	})
 }
 
+func TestReadFileBinaryAndLongLines(t *testing.T) {
+	e, dir := setupEnv(t)
+	ctx := context.Background()
+
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewGray(image.Rect(0, 0, 64, 32))); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"logo.png":  logo.Bytes(),
+		"data.bin":  append([]byte("ELF"), make([]byte, 1000)...),
+		"utf16.txt": {0xFF, 0xFE, 'h', 0, 'i', 0},
+		"min.js":    []byte("short\n" + strings.Repeat("x", maxLineLength+5)),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		t.Helper()
+		result, err := e.Execute(ctx, "read_file", json.RawMessage(`{"path": "`+name+`"}`))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return result
+	}
+
+	if result := read("logo.png"); !strings.HasPrefix(result, "Binary file logo.png (") || !strings.Contains(result, "image/png, png image, 64x32 pixels") || !strings.Contains(result, "00000000  89 50 4e 47") {
+		t.Errorf("unexpected PNG summary:\n%s", result)
+	}
+	if result := read("data.bin"); !strings.Contains(result, "1003 bytes") || !strings.Contains(result, "First 256 bytes") {
+		t.Errorf("unexpected binary summary:\n%s", result)
+	}
+	if result := read("utf16.txt"); result != "hi" {
+		t.Errorf("expected decoded UTF-16, got %q", result)
+	}
+	if result := read("min.js"); result != "short\n"+strings.Repeat("x", maxLineLength)+"... [line truncated, 5 more characters]" {
+		t.Errorf("expected the long line to be truncated, got %q", result[len(result)-60:])
+	}
+}
+
 // --- write_file tests ---
 
 func TestWriteFile(t *testing.T) {
@@ -370,6 +422,46 @@ func TestGlob(t *testing.T) {
 	})
 }
 
+func TestGlobRecursive(t *testing.T) {
+	e, dir := setupEnv(t)
+	ctx := context.Background()
+	files := []string{"main.go", "pkg/a/a.go", "pkg/a/a_test.go", "pkg/b/b_test.go", "vendor/x/x_test.go", "build/out_test.go", ".git/hooks/h_test.go"}
+	for i, name := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(files)) * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+	}
+	os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("# build output\n/build/\nvendor\n"), 0o644)
+	glob := func(args string) string {
+		t.Helper()
+		result, err := e.Execute(ctx, "glob", json.RawMessage(args))
+		if err != nil {
+			t.Fatalf("glob %s: %v", args, err)
+		}
+		return result
+	}
+
+	if got := glob(`{"pattern": "**/*_test.go"}`); got != "pkg/a/a_test.go\npkg/b/b_test.go" {
+		t.Errorf("unexpected recursive matches:\n%s", got)
+	}
+	if got := glob(`{"pattern": "**/*.go", "sort_by": "mtime", "limit": 2}`); got != "pkg/b/b_test.go\npkg/a/a_test.go\n[Showing 2 of 4 matches.]" {
+		t.Errorf("unexpected newest matches:\n%s", got)
+	}
+	if got := glob(`{"pattern": "pkg/*/a*.go"}`); got != "pkg/a/a.go\npkg/a/a_test.go" {
+		t.Errorf("unexpected matches below a literal directory:\n%s", got)
+	}
+	if got := glob(`{"pattern": "**/out_test.go", "include_ignored": true}`); got != "build/out_test.go" {
+		t.Errorf("expected ignored files with include_ignored, got:\n%s", got)
+	}
+	if got := glob(`{"pattern": "*"}`); got != ".gitignore\nmain.go\npkg" {
+		t.Errorf("expected a flat pattern not to recurse, got:\n%s", got)
+	}
+}
+
 // --- grep tests ---
 
 func TestGrep(t *testing.T) {
@@ -426,6 +518,61 @@ func TestGrep(t *testing.T) {
 	})
 }
 
+func TestGrepModes(t *testing.T) {
+	for _, backend := range []string{"ripgrep", "go"} {
+		t.Run(backend, func(t *testing.T) {
+			if backend == "ripgrep" && ripgrepPath() == "" {
+				t.Skip("rg is not installed")
+			}
+			useRipgrep = backend == "ripgrep"
+			defer func() { useRipgrep = true }()
+
+			e, dir := setupEnv(t)
+			ctx := context.Background()
+			os.MkdirAll(filepath.Join(dir, "src"), 0o755)
+			os.MkdirAll(filepath.Join(dir, ".hidden"), 0o755)
+			files := map[string]string{
+				"src/a.go":          "package a\n\nfunc Alpha() {\n\treturn\n}\n\nfunc Beta() {}\n",
+				"src/b.go":          "package b\n\n// FUNC in a comment\n",
+				"notes.txt":         "func is not Go here\n",
+				".hidden/secret.go": "func Hidden() {}\n",
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			grep := func(args string) string {
+				t.Helper()
+				result, err := e.Execute(ctx, "grep", json.RawMessage(args))
+				if err != nil {
+					t.Fatalf("grep %s: %v", args, err)
+				}
+				return result
+			}
+
+			if got := grep(`{"pattern": "^func", "glob": "*.go", "after_context": 1}`); got != "src/a.go:3:func Alpha() {\nsrc/a.go-4-\treturn\n--\nsrc/a.go:7:func Beta() {}\n" {
+				t.Errorf("unexpected content with context:\n%s", got)
+			}
+			if got := grep(`{"pattern": "func", "path": "src", "case_insensitive": true, "output_mode": "count"}`); got != "src/a.go:2\nsrc/b.go:1\n" {
+				t.Errorf("unexpected counts:\n%s", got)
+			}
+			if got := grep(`{"pattern": "func", "output_mode": "files_with_matches", "max_results": 1}`); !strings.Contains(got, "[Showing 1 of 2 files (3 matches)") {
+				t.Errorf("expected a capped file list, got:\n%s", got)
+			}
+			if got := grep(`{"pattern": "func", "path": "src/a.go", "max_results": 1}`); got != "src/a.go:3:func Alpha() {\n\n[Showing 1 of 2 matches in 1 files; narrow the search or raise max_results.]\n" {
+				t.Errorf("unexpected capped content:\n%s", got)
+			}
+			if got := grep(`{"pattern": "Alpha\\(\\) \\{\\n\\treturn", "multiline": true}`); got != "src/a.go:3:func Alpha() {\nsrc/a.go:4:\treturn\n" {
+				t.Errorf("unexpected multiline match:\n%s", got)
+			}
+			if _, err := e.Execute(ctx, "grep", json.RawMessage(`{"pattern": "x", "output_mode": "lines"}`)); err == nil {
+				t.Error("expected an error for an invalid output_mode")
+			}
+		})
+	}
+}
+
 // --- resolvePath tests ---
 
 func TestResolvePath(t *testing.T) {
@@ -515,6 +662,67 @@ func TestUnknownTool(t *testing.T) {
 	}
 }
 
+func TestToolsMatchExecute(t *testing.T) {
+	e, _ := setupEnv(t)
+	seen := make(map[string]bool)
+	for _, tool := range e.Tools() {
+		if seen[tool.Name] {
+			t.Errorf("duplicate tool %s", tool.Name)
+		}
+		seen[tool.Name] = true
+		if !json.Valid(tool.Parameters) {
+			t.Errorf("%s: invalid parameter schema", tool.Name)
+		}
+		// Every listed tool must be dispatched, even if the arguments fail.
+		if _, err := e.Execute(context.Background(), tool.Name, json.RawMessage(`{"path": "missing", "pattern": "x", "query": "x"}`)); err != nil && strings.Contains(err.Error(), "unknown tool") {
+			t.Errorf("%s is listed but not implemented", tool.Name)
+		}
+	}
+	if len(seen) != 10 {
+		t.Errorf("expected 10 tools, got %d", len(seen))
+	}
+}
+
+func TestRegisterTool(t *testing.T) {
+	e, _ := setupEnv(t)
+	schema := json.RawMessage(`{"type": "object", "description": "Look up a support ticket", "properties": {"id": {"type": "string"}}}`)
+	err := e.RegisterTool("lookup_ticket", schema, func(ctx context.Context, args json.RawMessage) (string, error) {
+		var params struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(args, &params)
+		return "ticket " + params.ID, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterTool: %v", err)
+	}
+
+	tools := e.Tools()
+	last := tools[len(tools)-1]
+	if last.Name != "lookup_ticket" || last.Description != "Look up a support ticket" || len(tools) != len(BuiltinTools())+1 {
+		t.Errorf("expected lookup_ticket to be listed after the built-ins, got %+v", last)
+	}
+	result, err := e.Execute(context.Background(), "lookup_ticket", json.RawMessage(`{"id": "T-7"}`))
+	if err != nil || result != "ticket T-7" {
+		t.Errorf("expected ticket T-7, got %q, %v", result, err)
+	}
+
+	// A custom tool replaces a built-in of the same name.
+	e.RegisterTool("bash", json.RawMessage(`{"type": "object"}`), func(ctx context.Context, args json.RawMessage) (string, error) {
+		return "sandboxed", nil
+	})
+	if result, _ := e.Execute(context.Background(), "bash", json.RawMessage(`{"command": "echo hi"}`)); result != "sandboxed" {
+		t.Errorf("expected the custom bash, got %q", result)
+	}
+	if len(e.Tools()) != len(BuiltinTools())+1 {
+		t.Errorf("expected bash to be replaced, not added")
+	}
+
+	if err := e.RegisterTool("broken", json.RawMessage(`{`), func(context.Context, json.RawMessage) (string, error) { return "", nil }); err == nil {
+		t.Error("expected an invalid schema to be rejected")
+	}
+}
+
 // --- NewLocalEnvironment default WorkDir ---
 
 func TestNewLocalEnvironmentDefaults(t *testing.T) {
@@ -527,3 +735,543 @@ func TestNewLocalEnvironmentDefaults(t *testing.T) {
 		t.Errorf("default Timeout = %v, want %v", e.Timeout, 10*time.Second)
 	}
 }
+
+func TestBashSecrets(t *testing.T) {
+	e, _ := setupEnv(t)
+	t.Setenv("ATTRACTOR_SECRET_DEPLOY_KEY", "k-9f8e7d")
+	e.Secrets = secrets.FromEnv()
+
+	args, _ := json.Marshal(map[string]interface{}{"command": "echo key=${secret:DEPLOY_KEY}"})
+	result, err := e.Execute(context.Background(), "bash", args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "k-9f8e7d") {
+		t.Errorf("expected secret value to be redacted, got %q", result)
+	}
+	if !strings.Contains(result, "key=[REDACTED:DEPLOY_KEY]") {
+		t.Errorf("expected redaction marker in output, got %q", result)
+	}
+}
+
+// --- policy tests ---
+
+func TestPolicyCommands(t *testing.T) {
+	e, _ := setupEnv(t)
+	ctx := context.Background()
+	e.Policy = &Policy{
+		AllowCommands:  MustCompilePatterns(`^(echo|ls|curl)\b`),
+		DenyCommands:   MustCompilePatterns(`rm\s+-rf`),
+		DisableNetwork: true,
+	}
+
+	tests := []struct {
+		command string
+		rule    string
+	}{
+		{"echo ok", ""},
+		{"echo ok; rm -rf /", "command_denied"},
+		{"cat /etc/hosts", "command_not_allowed"},
+		{"curl https://example.com", "network_disabled"},
+	}
+	for _, tt := range tests {
+		args, _ := json.Marshal(map[string]interface{}{"command": tt.command})
+		_, err := e.Execute(ctx, "bash", args)
+		if tt.rule == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", tt.command, err)
+			}
+			continue
+		}
+		var violation *PolicyViolation
+		if !errors.As(err, &violation) {
+			t.Errorf("%q: expected PolicyViolation, got %v", tt.command, err)
+			continue
+		}
+		if violation.Rule != tt.rule {
+			t.Errorf("%q: expected rule %s, got %s", tt.command, tt.rule, violation.Rule)
+		}
+	}
+}
+
+func TestPolicyPathJail(t *testing.T) {
+	e, dir := setupEnv(t)
+	ctx := context.Background()
+	e.Policy = &Policy{JailPaths: true}
+
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("nope"), 0o644)
+	os.WriteFile(filepath.Join(dir, "inside.txt"), []byte("yes"), 0o644)
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+
+	for _, path := range []string{
+		filepath.Join(outside, "secret.txt"),
+		"../" + filepath.Base(outside) + "/secret.txt",
+		"escape/secret.txt",
+	} {
+		args, _ := json.Marshal(map[string]interface{}{"path": path})
+		_, err := e.Execute(ctx, "read_file", args)
+		var violation *PolicyViolation
+		if !errors.As(err, &violation) || violation.Rule != "path_outside_workdir" {
+			t.Errorf("%s: expected path_outside_workdir violation, got %v", path, err)
+		}
+	}
+
+	args, _ := json.Marshal(map[string]interface{}{"path": "inside.txt"})
+	if _, err := e.Execute(ctx, "read_file", args); err != nil {
+		t.Errorf("expected read inside WorkDir to succeed, got %v", err)
+	}
+	args, _ = json.Marshal(map[string]interface{}{"path": "new/file.txt", "content": "x"})
+	if _, err := e.Execute(ctx, "write_file", args); err != nil {
+		t.Errorf("expected write of new file inside WorkDir to succeed, got %v", err)
+	}
+}
+
+func TestPolicyMaxOutput(t *testing.T) {
+	e, _ := setupEnv(t)
+	e.Policy = &Policy{MaxOutputBytes: 10}
+
+	args, _ := json.Marshal(map[string]interface{}{"command": "printf '%0100d' 0"})
+	result, err := e.Execute(context.Background(), "bash", args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(result, "0000000000\n[output truncated by policy: 90 bytes omitted]") {
+		t.Errorf("expected truncated output, got %q", result)
+	}
+}
+
+func TestPolicyMaxOutputRedactsFirst(t *testing.T) {
+	e, _ := setupEnv(t)
+	t.Setenv("ATTRACTOR_SECRET_VALUE", "supersecretvalue123")
+	e.Secrets = secrets.FromEnv()
+	e.Secrets.Expand("${secret:VALUE}")
+	e.Policy = &Policy{MaxOutputBytes: 12}
+
+	args, _ := json.Marshal(map[string]interface{}{"command": "printf 'ab supersecretvalue123'"})
+	result, _ := e.Execute(context.Background(), "bash", args)
+	if strings.Contains(result, "supersecr") {
+		t.Errorf("expected no part of the secret in truncated output, got %q", result)
+	}
+	if !strings.HasPrefix(result, "ab [REDACTED") {
+		t.Errorf("expected the redacted output truncated, got %q", result)
+	}
+}
+
+func TestPolicyLimitOutputRuneAligned(t *testing.T) {
+	p := &Policy{MaxOutputBytes: 4}
+	got := p.LimitOutput("caf\u00e9s")
+	if !utf8.ValidString(got) || !strings.HasPrefix(got, "caf\n") {
+		t.Errorf("expected the cut before é, got %q", got)
+	}
+	if !strings.Contains(got, "3 bytes omitted") {
+		t.Errorf("expected the dropped count to include the whole rune, got %q", got)
+	}
+}
+
+func TestBashStreamsOutput(t *testing.T) {
+	e, _ := setupEnv(t)
+
+	var mu sync.Mutex
+	streamed := map[string]string{}
+	ctx := WithOutputFunc(context.Background(), func(stream, chunk string) {
+		mu.Lock()
+		defer mu.Unlock()
+		streamed[stream] += chunk
+	})
+
+	args, _ := json.Marshal(map[string]interface{}{"command": "echo building; echo warning >&2; echo done"})
+	result, err := e.Execute(ctx, "bash", args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if streamed["stdout"] != "building\ndone\n" {
+		t.Errorf("unexpected streamed stdout %q", streamed["stdout"])
+	}
+	if streamed["stderr"] != "warning\n" {
+		t.Errorf("unexpected streamed stderr %q", streamed["stderr"])
+	}
+	if !strings.Contains(result, "building\ndone\n") || !strings.Contains(result, "STDERR:\nwarning") {
+		t.Errorf("expected full output in result, got %q", result)
+	}
+}
+
+func TestStreamWriterRedactsAcrossChunks(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_TOKEN", "tok-123")
+	store := secrets.FromEnv()
+	store.Expand("${secret:TOKEN}")
+
+	var streamed []string
+	ctx := WithOutputFunc(context.Background(), func(stream, chunk string) {
+		streamed = append(streamed, chunk)
+	})
+	stdout, _ := newStreamWriters(ctx, store, nil)
+	for _, chunk := range []string{"key=tok", "-123 caf\xc3", "\xa9\n"} {
+		stdout.Write([]byte(chunk))
+	}
+	stdout.Flush()
+
+	for _, chunk := range streamed {
+		if !utf8.ValidString(chunk) {
+			t.Errorf("streamed chunk %q splits a rune", chunk)
+		}
+	}
+	if got := strings.Join(streamed, ""); got != "key=[REDACTED:TOKEN] café\n" {
+		t.Errorf("unexpected streamed output %q", got)
+	}
+}
+
+func TestStreamWriterStopsAtOutputLimit(t *testing.T) {
+	var streamed string
+	ctx := WithOutputFunc(context.Background(), func(stream, chunk string) {
+		streamed += chunk
+	})
+	stdout, stderr := newStreamWriters(ctx, nil, &Policy{MaxOutputBytes: 10})
+	stdout.Write([]byte("0123456"))
+	stderr.Write([]byte("789abcdef"))
+	stdout.Write([]byte("more"))
+	stdout.Flush()
+	stderr.Flush()
+
+	if streamed != "0123456789\n[output truncated by policy]\n" {
+		t.Errorf("unexpected streamed output %q", streamed)
+	}
+	if stdout.String() != "0123456more" {
+		t.Errorf("expected full output to be kept, got %q", stdout.String())
+	}
+}
+
+// --- search_code tests ---
+
+func TestSearchCode(t *testing.T) {
+	e, dir := setupEnv(t)
+	ctx := context.Background()
+
+	files := map[string]string{
+		"config/parse.go":   "package config\n\n// ParseConfig reads a config file.\nfunc ParseConfig(path string) (*Config, error) {\n\treturn nil, nil\n}\n\ntype Config struct{}\n\nfunc (c *Config) Validate() error { return nil }\n",
+		"app/main.py":       "import config\n\nclass Loader:\n    def parse_config(self, path):\n        return config.load(path)\n",
+		"web/app.ts":        "export async function fetchUser(id: string) {}\nconst parseConfigLine = (line) => line.trim()\n",
+		"docs/notes.md":     "We parse config files at startup.\n",
+		"node_modules/x.js": "function parseConfig() {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+
+	search := func(t *testing.T, params map[string]interface{}) string {
+		t.Helper()
+		args, _ := json.Marshal(params)
+		result, err := e.Execute(ctx, "search_code", args)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+
+	t.Run("ranks declarations first", func(t *testing.T) {
+		result := search(t, map[string]interface{}{"query": "parse config"})
+		goDecl := strings.Index(result, "config/parse.go:4  func ParseConfig\n")
+		pyDecl := strings.Index(result, "app/main.py:4  func parse_config\n")
+		partial := strings.Index(result, "web/app.ts:2  func parseConfigLine\n")
+		if goDecl < 0 || pyDecl < 0 || partial < 0 || goDecl > partial || pyDecl > partial {
+			t.Errorf("expected exact declarations before partial ones, got:\n%s", result)
+		}
+		if !strings.Contains(result, "docs/notes.md:1") {
+			t.Errorf("expected text match in results, got:\n%s", result)
+		}
+		if strings.Contains(result, "node_modules") {
+			t.Errorf("expected node_modules to be skipped, got:\n%s", result)
+		}
+		if strings.Index(result, "docs/notes.md") < strings.Index(result, "web/app.ts") {
+			t.Errorf("expected text matches after declarations, got:\n%s", result)
+		}
+	})
+
+	t.Run("methods and path filter", func(t *testing.T) {
+		result := search(t, map[string]interface{}{"query": "Validate", "path": "config"})
+		if !strings.Contains(result, "config/parse.go:10  method Config.Validate") {
+			t.Errorf("expected Config.Validate, got:\n%s", result)
+		}
+		result = search(t, map[string]interface{}{"query": "fetchUser", "path": "app"})
+		if result != "No matches found." {
+			t.Errorf("expected no matches outside path, got:\n%s", result)
+		}
+	})
+
+	t.Run("picks up new files", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(dir, "config/watch.go"), []byte("package config\n\nfunc WatchConfig() {}\n"), 0o644); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		result := search(t, map[string]interface{}{"query": "WatchConfig", "limit": 1})
+		if !strings.HasPrefix(result, "config/watch.go:3  func WatchConfig\n") {
+			t.Errorf("expected WatchConfig, got:\n%s", result)
+		}
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		args, _ := json.Marshal(map[string]interface{}{"query": "  "})
+		if _, err := e.Execute(ctx, "search_code", args); err == nil {
+			t.Error("expected error for empty query")
+		}
+	})
+}
+
+// --- notebook tests ---
+
+const testNotebook = `{
+ "cells": [
+  {
+   "cell_type": "markdown",
+   "id": "intro",
+   "metadata": {},
+   "source": ["# Analysis\n", "Totals <by> month."]
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 3,
+   "id": "sum",
+   "metadata": {"tags": ["keep"]},
+   "outputs": [
+    {"name": "stdout", "output_type": "stream", "text": ["42\n"]},
+    {"data": {"image/png": "iVBOR"}, "metadata": {}, "output_type": "display_data"}
+   ],
+   "source": ["total = sum(values)\n", "print(total)"]
+  }
+ ],
+ "metadata": {"language_info": {"name": "python", "version": "3.12.1"}},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}
+`
+
+func TestNotebookTools(t *testing.T) {
+	e, dir := setupEnv(t)
+	ctx := context.Background()
+	path := filepath.Join(dir, "analysis.ipynb")
+	if err := os.WriteFile(path, []byte(testNotebook), 0o644); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	run := func(tool string, args map[string]interface{}) (string, error) {
+		t.Helper()
+		data, _ := json.Marshal(args)
+		return e.Execute(ctx, tool, data)
+	}
+
+	result, err := run("notebook_read", map[string]interface{}{"path": "analysis.ipynb"})
+	if err != nil {
+		t.Fatalf("notebook_read: %v", err)
+	}
+	want := "## Cell 1 (code, id sum)\n\n```python\ntotal = sum(values)\nprint(total)\n```\n\nOutput:\n\n```\n42\n[image/png output]\n```"
+	if !strings.HasPrefix(result, "## Cell 0 (markdown, id intro)\n\n```\n# Analysis\nTotals <by> month.\n```") || !strings.HasSuffix(result, want) {
+		t.Errorf("unexpected notebook_read output:\n%s", result)
+	}
+
+	if _, err := run("notebook_edit", map[string]interface{}{"path": "analysis.ipynb", "cell_id": "sum", "source": "total = sum(values) * 2\nprint(total)"}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if _, err := run("notebook_edit", map[string]interface{}{"path": "analysis.ipynb", "cell_id": "intro", "edit_mode": "insert", "cell_type": "code", "source": "import math\n"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := run("notebook_edit", map[string]interface{}{"path": "analysis.ipynb", "index": 0, "edit_mode": "delete"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	var nb struct {
+		Cells []struct {
+			ID             string          `json:"id"`
+			CellType       string          `json:"cell_type"`
+			Source         []string        `json:"source"`
+			Outputs        []interface{}   `json:"outputs"`
+			ExecutionCount *int            `json:"execution_count"`
+			Metadata       json.RawMessage `json:"metadata"`
+		} `json:"cells"`
+	}
+	if err := json.Unmarshal(data, &nb); err != nil {
+		t.Fatalf("edited notebook is not valid JSON: %v\n%s", err, data)
+	}
+	if len(nb.Cells) != 2 {
+		t.Fatalf("expected 2 cells, got %d:\n%s", len(nb.Cells), data)
+	}
+	inserted, sum := nb.Cells[0], nb.Cells[1]
+	if inserted.CellType != "code" || len(inserted.ID) != 8 || strings.Join(inserted.Source, "") != "import math\n" {
+		t.Errorf("unexpected inserted cell: %+v", inserted)
+	}
+	if strings.Join(sum.Source, "|") != "total = sum(values) * 2\n|print(total)" || len(sum.Outputs) != 0 || sum.ExecutionCount != nil {
+		t.Errorf("expected replaced source with cleared outputs, got %+v", sum)
+	}
+	if !strings.Contains(string(sum.Metadata), `"keep"`) {
+		t.Errorf("expected cell metadata to survive, got %s", sum.Metadata)
+	}
+	if !strings.Contains(string(data), "\n \"nbformat_minor\": 5\n}\n") || !strings.Contains(string(data), `"version": "3.12.1"`) {
+		t.Errorf("expected Jupyter layout and metadata to be kept, got:\n%s", data)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"path": "analysis.ipynb", "cell_id": "missing", "source": "x"},
+		{"path": "analysis.ipynb", "index": 5, "edit_mode": "delete"},
+		{"path": "analysis.ipynb", "index": 0, "edit_mode": "insert", "source": "x"},
+		{"path": "analysis.ipynb", "index": 0, "edit_mode": "move"},
+	} {
+		if _, err := run("notebook_edit", args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+	// A bare notebook without nbformat_minor gets cells without IDs.
+	os.WriteFile(filepath.Join(dir, "empty.ipynb"), []byte(`{"cells": [], "metadata": {}}`), 0o644)
+	if _, err := run("notebook_edit", map[string]interface{}{"path": "empty.ipynb", "index": 0, "edit_mode": "insert", "cell_type": "code", "source": "x = 1"}); err != nil {
+		t.Fatalf("insert into an empty notebook: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "empty.ipynb")); strings.Contains(string(data), `"id"`) {
+		t.Errorf("expected no cell ID, got:\n%s", data)
+	}
+}
+
+func TestShell(t *testing.T) {
+	for name, want := range map[string]string{"bash": "-c", "PWSH": "-Command", "cmd": "/C"} {
+		shell, err := ParseShell(name)
+		if err != nil {
+			t.Fatalf("ParseShell(%q) failed: %v", name, err)
+		}
+		if shell.Name != strings.ToLower(name) || shell.Args[len(shell.Args)-1] != want {
+			t.Errorf("ParseShell(%q): expected %s ... %s, got %+v", name, strings.ToLower(name), want, shell)
+		}
+	}
+	if _, err := ParseShell("fish"); err == nil {
+		t.Error("expected an error for an unknown shell")
+	}
+
+	e, _ := setupEnv(t)
+	if desc := toolDescription(e.Tools(), "bash"); DefaultShell().Name == "bash" && !strings.HasPrefix(desc, "Execute a bash command") {
+		t.Errorf("expected the default bash tool description, got %q", desc)
+	}
+
+	e.Shell, _ = ParseShell("sh")
+	out, err := e.Execute(context.Background(), "bash", json.RawMessage(`{"command": "echo $0"}`))
+	if err != nil {
+		t.Fatalf("bash failed: %v", err)
+	}
+	if strings.TrimSpace(out) != "sh" {
+		t.Errorf("expected the command to run with sh, got %q", out)
+	}
+	if desc := toolDescription(e.Tools(), "bash"); !strings.Contains(desc, "with sh") {
+		t.Errorf("expected the description to name sh, got %q", desc)
+	}
+}
+
+func toolDescription(tools []llm.Tool, name string) string {
+	for _, t := range tools {
+		if t.Name == name {
+			return t.Description
+		}
+	}
+	return ""
+}
+
+func TestDiagnostics(t *testing.T) {
+	e, dir := setupEnv(t)
+	ctx := context.Background()
+	var checked []string
+	e.Checkers = []Checker{{
+		Name:       "fake",
+		Extensions: []string{".go"},
+		Run: func(_ context.Context, d string, _ []string, files []string) ([]Diagnostic, error) {
+			if d != dir {
+				t.Errorf("checker ran in %s, want %s", d, dir)
+			}
+			checked = files
+			return []Diagnostic{
+				{Path: "a.go", Line: 3, Column: 2, Severity: "error", Message: "undefined: x", Source: "fake"},
+				{Path: "a.go", Line: 7, Severity: "warning", Message: "unused", Source: "fake"},
+			}, nil
+		},
+	}}
+
+	out, err := e.Execute(ctx, "diagnostics", json.RawMessage(`{"paths":["a.go","sub/b.go","a.go","notes.txt"]}`))
+	if err != nil {
+		t.Fatalf("diagnostics: %v", err)
+	}
+	if strings.Join(checked, ",") != "a.go,sub/b.go" {
+		t.Errorf("checked %v", checked)
+	}
+	want := "a.go:3:2: error: undefined: x (fake)\na.go:7: warning: unused (fake)\n1 error(s), 1 warning(s)\nNo checker for: notes.txt"
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	if _, err := e.Execute(ctx, "diagnostics", json.RawMessage(`{}`)); err == nil {
+		t.Error("expected an error without paths")
+	}
+}
+
+func TestCommandCheckerSpansAndSandbox(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	e, _ := setupEnv(t)
+	e.Policy = &Policy{DisableNetwork: true}
+	// gopls check reports spans as line:col-col or line:col-line:col.
+	script := `echo "a.go:3:5-9: undefined: x"; echo "b.go:4:2-5:1: warning: long span"; echo "c.go:7: proxy=$HTTPS_PROXY"`
+	e.Checkers = []Checker{CommandChecker("fake", "error", "sh", "-c", script, "sh")}
+	e.Checkers[0].Extensions = []string{".go"}
+
+	out, err := e.Execute(context.Background(), "diagnostics", json.RawMessage(`{"paths":["a.go"]}`))
+	if err != nil {
+		t.Fatalf("diagnostics: %v", err)
+	}
+	for _, want := range []string{
+		"a.go:3:5: error: undefined: x (fake)",
+		"b.go:4:2: warning: long span (fake)",
+		"c.go:7: error: proxy=http://127.0.0.1:9 (fake)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestGoVetDiagnostics(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not installed")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/demo\n\ngo 1.21\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Printf(\"%d\\n\", \"x\")\n}\n"), 0o644)
+
+	diags, err := runChecker(context.Background(), dir, filterEnvironment(), "go vet", "error", []string{"go", "vet", "./."})
+	if err != nil {
+		t.Fatalf("go vet: %v", err)
+	}
+	if len(diags) != 1 || diags[0].Path != "main.go" || diags[0].Line != 6 || !strings.Contains(diags[0].Message, "Printf") {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, tc := range []struct {
+		shell, arg, want string
+	}{
+		{"sh", "it's; rm -rf /", `'it'\''s; rm -rf /'`},
+		{"pwsh", "it's $(whoami)", `'it''s $(whoami)'`},
+		{"pwsh", "a’b", `'a’’b'`},
+		{"cmd", "a & b | c", `"a & b | c"`},
+	} {
+		shell, _ := ParseShell(tc.shell)
+		got, err := shell.Quote(tc.arg)
+		if err != nil || got != tc.want {
+			t.Errorf("%s.Quote(%q) = %s, %v; want %s", tc.shell, tc.arg, got, err, tc.want)
+		}
+	}
+	cmd, _ := ParseShell("cmd")
+	for _, arg := range []string{`a" & calc`, "%PATH%", "!x!", "a\r\nb"} {
+		if _, err := cmd.Quote(arg); err == nil {
+			t.Errorf("expected cmd to refuse %q", arg)
+		}
+	}
+}
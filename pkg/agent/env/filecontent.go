@@ -0,0 +1,104 @@
+package env
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for image dimensions
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// sniffLen is how much of a file is inspected to decide whether it is
+	// text.
+	sniffLen = 8000
+	// hexdumpLen is how much of a binary file read_file shows.
+	hexdumpLen = 256
+	// maxLineLength is the longest line read_file returns whole; longer
+	// lines, as in minified or generated files, are cut.
+	maxLineLength = 2000
+)
+
+// decodeText returns data as UTF-8 text, decoding UTF-16 and dropping a
+// byte order mark. It reports false if data looks binary: it has a NUL byte
+// or is not valid UTF-8 near the start.
+func decodeText(data []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		data = data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false), true
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true), true
+	}
+
+	head := data
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+		// Allow for a rune cut off at the end of the sample.
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(head) {
+		return "", false
+	}
+	return string(data), true
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// describeBinary summarizes a binary file for read_file: its size, MIME type,
+// image dimensions if it is an image, and a hex dump of its first bytes.
+func describeBinary(name string, data []byte) string {
+	mimeType := http.DetectContentType(data)
+	if mimeType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+			mimeType = byExt
+		}
+	}
+	details := []string{fmt.Sprintf("%d bytes", len(data)), mimeType}
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		details = append(details, fmt.Sprintf("%s image, %dx%d pixels", format, cfg.Width, cfg.Height))
+	}
+
+	head := data
+	if len(head) > hexdumpLen {
+		head = head[:hexdumpLen]
+	}
+	return fmt.Sprintf("Binary file %s (%s); its content is not shown. First %d bytes:\n%s",
+		name, strings.Join(details, ", "), len(head), strings.TrimRight(hex.Dump(head), "\n"))
+}
+
+// truncateLongLines cuts lines longer than maxLineLength characters.
+func truncateLongLines(content string) string {
+	if len(content) <= maxLineLength {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if n := utf8.RuneCountInString(line); n > maxLineLength {
+			runes := []rune(line)
+			lines[i] = fmt.Sprintf("%s... [line truncated, %d more characters]", string(runes[:maxLineLength]), n-maxLineLength)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
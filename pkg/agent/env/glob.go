@@ -0,0 +1,250 @@
+package env
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// glob lists the files and directories under the search path that match a
+// pattern, where ** matches any number of directories. Entries ignored by
+// .gitignore files, and .git itself, are left out unless include_ignored is
+// set. Results are sorted by path, or newest first with sort_by "mtime".
+func (e *LocalEnvironment) glob(args json.RawMessage) (string, error) {
+	var params struct {
+		Pattern        string `json:"pattern"`
+		Path           string `json:"path"`
+		SortBy         string `json:"sort_by"`
+		Limit          int    `json:"limit"`
+		IncludeIgnored bool   `json:"include_ignored"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if params.SortBy != "" && params.SortBy != "path" && params.SortBy != "mtime" {
+		return "", fmt.Errorf("invalid sort_by %q (want path or mtime)", params.SortBy)
+	}
+	pattern := strings.Trim(filepath.ToSlash(params.Pattern), "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", fmt.Errorf("glob: %w", err)
+	}
+
+	searchDir := e.WorkDir
+	if params.Path != "" {
+		var err error
+		if searchDir, err = e.jailPath(params.Path); err != nil {
+			return "", err
+		}
+	}
+
+	// Start the walk below the pattern's literal directories, and don't
+	// descend further than its segments reach unless it has **.
+	segments := strings.Split(pattern, "/")
+	for len(segments) > 1 && !hasGlobMeta(segments[0]) {
+		searchDir = filepath.Join(searchDir, segments[0])
+		segments = segments[1:]
+	}
+	if err := e.Policy.CheckPath(e.WorkDir, searchDir); err != nil {
+		return "", err
+	}
+	pattern = strings.Join(segments, "/")
+	maxDepth := len(segments)
+	if strings.Contains(pattern, "**") {
+		maxDepth = -1
+	}
+
+	var ignore *gitignore
+	if !params.IncludeIgnored {
+		ignore = newGitignore(e.WorkDir, searchDir)
+	}
+
+	type entry struct {
+		path    string
+		modTime time.Time
+	}
+	var matches []entry
+	err := filepath.WalkDir(searchDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p == searchDir {
+			if d.IsDir() && ignore != nil {
+				ignore.load(p)
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(searchDir, p)
+		rel = filepath.ToSlash(rel)
+		if ignore != nil && (d.Name() == ".git" || ignore.ignored(p, d.IsDir())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if matchGlob(pattern, rel) {
+			var modTime time.Time
+			if info, err := d.Info(); err == nil {
+				modTime = info.ModTime()
+			}
+			matches = append(matches, entry{e.displayPath(p), modTime})
+		}
+		if d.IsDir() {
+			if maxDepth >= 0 && strings.Count(rel, "/")+1 >= maxDepth {
+				return filepath.SkipDir
+			}
+			if ignore != nil {
+				ignore.load(p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("glob: %w", err)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if params.SortBy == "mtime" && !matches[i].modTime.Equal(matches[j].modTime) {
+			return matches[i].modTime.After(matches[j].modTime)
+		}
+		return matches[i].path < matches[j].path
+	})
+	var sb strings.Builder
+	for i, m := range matches {
+		if params.Limit > 0 && i == params.Limit {
+			fmt.Fprintf(&sb, "\n[Showing %d of %d matches.]", params.Limit, len(matches))
+			break
+		}
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(m.path)
+	}
+	return sb.String(), nil
+}
+
+// displayPath returns path relative to WorkDir if it is inside it.
+func (e *LocalEnvironment) displayPath(p string) string {
+	if rel, err := filepath.Rel(e.WorkDir, p); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return p
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// matchGlob reports whether the slash-separated name matches pattern, in
+// which a ** segment matches zero or more path segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// gitignore holds the rules of the .gitignore files loaded so far.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+// ignoreRule is one .gitignore line. dir is the directory of its file.
+type ignoreRule struct {
+	dir      string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// newGitignore returns a gitignore with the rules of the .gitignore files
+// in root and the directories between it and dir, if dir is inside root.
+func newGitignore(root, dir string) *gitignore {
+	g := &gitignore{}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || strings.HasPrefix(rel, "..") || rel == "." {
+		return g
+	}
+	g.load(root)
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := range parts[:len(parts)-1] {
+		g.load(filepath.Join(root, filepath.Join(parts[:i+1]...)))
+	}
+	return g
+}
+
+// load adds the rules of dir/.gitignore, if it exists.
+func (g *gitignore) load(dir string) {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dir}
+		if line, rule.negate = strings.CutPrefix(line, "!"); rule.negate && line == "" {
+			continue
+		}
+		line, rule.dirOnly = strings.CutSuffix(line, "/")
+		// A slash other than a trailing one anchors the pattern to the
+		// .gitignore's directory.
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		g.rules = append(g.rules, rule)
+	}
+}
+
+// ignored reports whether the last rule matching p ignores it.
+func (g *gitignore) ignored(p string, isDir bool) bool {
+	ignored := false
+	for _, r := range g.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		match := false
+		if r.anchored {
+			match = matchGlob(r.pattern, rel)
+		} else {
+			match, _ = path.Match(r.pattern, path.Base(rel))
+		}
+		if match {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,377 @@
+package env
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// defaultGrepMaxResults caps the matches (or files, when listing files) a
+// grep call returns unless max_results is given.
+const defaultGrepMaxResults = 100
+
+// useRipgrep is cleared by tests to exercise the pure Go search.
+var useRipgrep = true
+
+// ripgrepPath returns the path of rg, or "" if it is not installed.
+var ripgrepPath = sync.OnceValue(func() string {
+	path, _ := exec.LookPath("rg")
+	return path
+})
+
+// grepOptions are the arguments of a grep call.
+type grepOptions struct {
+	Pattern         string `json:"pattern"`
+	Path            string `json:"path"`
+	Glob            string `json:"glob"`
+	OutputMode      string `json:"output_mode"`
+	CaseInsensitive bool   `json:"case_insensitive"`
+	Multiline       bool   `json:"multiline"`
+	Before          int    `json:"before_context"`
+	After           int    `json:"after_context"`
+	Context         int    `json:"context"`
+	MaxResults      int    `json:"max_results"`
+}
+
+// grepFile holds the matching lines of one file, in order, with their
+// context lines.
+type grepFile struct {
+	path    string
+	lines   []grepLine
+	matches int
+}
+
+// grepLine is a line of a grepFile. match is the 1-based index of the match
+// the line belongs to in the file, or 0 for a context line.
+type grepLine struct {
+	num   int
+	text  string
+	match int
+}
+
+// grep searches file contents with ripgrep if it is installed, or in Go
+// otherwise, and formats the results for the output mode: content (matching
+// lines), files_with_matches, or count.
+func (e *LocalEnvironment) grep(ctx context.Context, args json.RawMessage) (string, error) {
+	var opts grepOptions
+	if err := json.Unmarshal(args, &opts); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	switch opts.OutputMode {
+	case "":
+		opts.OutputMode = "content"
+	case "content", "files_with_matches", "count":
+	default:
+		return "", fmt.Errorf("invalid output_mode %q (want content, files_with_matches, or count)", opts.OutputMode)
+	}
+	if opts.Context > 0 {
+		opts.Before = max(opts.Before, opts.Context)
+		opts.After = max(opts.After, opts.Context)
+	}
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = defaultGrepMaxResults
+	}
+
+	searchPath := e.WorkDir
+	if opts.Path != "" {
+		var err error
+		if searchPath, err = e.jailPath(opts.Path); err != nil {
+			return "", err
+		}
+	}
+
+	var files []*grepFile
+	var err error
+	if rg := ripgrepPath(); rg != "" && useRipgrep {
+		files, err = ripgrep(ctx, rg, searchPath, opts)
+	} else {
+		files, err = grepGo(ctx, searchPath, opts)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		f.path = e.displayPath(f.path)
+	}
+	return formatGrep(files, opts), nil
+}
+
+// ripgrep runs rg --json and collects its match and context messages. Files
+// are sorted by path, so results are stable and in the Go search's order.
+func ripgrep(ctx context.Context, rg, searchPath string, opts grepOptions) ([]*grepFile, error) {
+	args := []string{"--json", "--sort=path"}
+	if opts.CaseInsensitive {
+		args = append(args, "--ignore-case")
+	}
+	if opts.Multiline {
+		args = append(args, "--multiline")
+	}
+	if opts.Before > 0 {
+		args = append(args, fmt.Sprintf("--before-context=%d", opts.Before))
+	}
+	if opts.After > 0 {
+		args = append(args, fmt.Sprintf("--after-context=%d", opts.After))
+	}
+	if opts.Glob != "" {
+		args = append(args, "--glob="+opts.Glob)
+	}
+	args = append(args, "--", opts.Pattern, searchPath)
+
+	cmd := exec.CommandContext(ctx, rg, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// rg exits 1 when nothing matches and 2 on errors, which may still
+	// come with matches from other files.
+	if err := cmd.Run(); err != nil && stdout.Len() == 0 && stderr.Len() > 0 {
+		return nil, fmt.Errorf("grep: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	type rgText struct {
+		Text  string `json:"text"`
+		Bytes []byte `json:"bytes"`
+	}
+	text := func(t rgText) string {
+		if t.Text != "" {
+			return t.Text
+		}
+		return string(t.Bytes)
+	}
+	var files []*grepFile
+	byPath := make(map[string]*grepFile)
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var msg struct {
+			Type string `json:"type"`
+			Data struct {
+				Path       rgText `json:"path"`
+				Lines      rgText `json:"lines"`
+				LineNumber int    `json:"line_number"`
+			} `json:"data"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil || (msg.Type != "match" && msg.Type != "context") {
+			continue
+		}
+		path := text(msg.Data.Path)
+		f := byPath[path]
+		if f == nil {
+			f = &grepFile{path: path}
+			byPath[path] = f
+			files = append(files, f)
+		}
+		match := 0
+		if msg.Type == "match" {
+			f.matches++
+			match = f.matches
+		}
+		// A multiline match spans several lines.
+		lines := strings.Split(strings.TrimSuffix(text(msg.Data.Lines), "\n"), "\n")
+		for i, line := range lines {
+			f.lines = append(f.lines, grepLine{num: msg.Data.LineNumber + i, text: strings.TrimSuffix(line, "\r"), match: match})
+		}
+	}
+	return files, nil
+}
+
+// grepGo searches searchPath in Go. Like ripgrep, it skips hidden files and
+// directories and binary files; unlike it, it does not read .gitignore.
+func grepGo(ctx context.Context, searchPath string, opts grepOptions) ([]*grepFile, error) {
+	pattern := opts.Pattern
+	if opts.Multiline {
+		pattern = "(?m)" + pattern
+	}
+	if opts.CaseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	var files []*grepFile
+	err = filepath.WalkDir(searchPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		hidden := path != searchPath && strings.HasPrefix(d.Name(), ".")
+		if d.IsDir() {
+			if hidden {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if hidden || !d.Type().IsRegular() || !grepGlobMatch(opts.Glob, searchPath, path) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		content, ok := decodeText(data)
+		if !ok {
+			return nil
+		}
+		if f := grepContent(path, content, re, opts); f != nil {
+			files = append(files, f)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// grepGlobMatch reports whether path matches glob. A glob without a slash
+// matches the file name, as with grep --include and rg --glob.
+func grepGlobMatch(glob, root, path string) bool {
+	if glob == "" {
+		return true
+	}
+	name := filepath.Base(path)
+	if strings.Contains(glob, "/") {
+		name, _ = filepath.Rel(root, path)
+		name = filepath.ToSlash(name)
+	}
+	ok, _ := filepath.Match(glob, name)
+	return ok
+}
+
+// grepContent returns the matches of re in content with their context
+// lines, or nil if there are none.
+func grepContent(path, content string, re *regexp.Regexp, opts grepOptions) *grepFile {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	matched := make([]int, len(lines))
+	f := &grepFile{path: path}
+	if opts.Multiline {
+		// Map each match to the lines it spans; matches sharing a line are
+		// merged, as ripgrep does.
+		starts := make([]int, len(lines))
+		for i := 1; i < len(lines); i++ {
+			starts[i] = starts[i-1] + len(lines[i-1]) + 1
+		}
+		lineAt := func(offset int) int {
+			i, found := slices.BinarySearch(starts, offset)
+			if !found {
+				i--
+			}
+			return min(i, len(lines)-1)
+		}
+		for _, loc := range re.FindAllStringIndex(content, -1) {
+			first, last := lineAt(loc[0]), lineAt(max(loc[1]-1, loc[0]))
+			if matched[first] == 0 {
+				f.matches++
+			}
+			for i := first; i <= last; i++ {
+				matched[i] = f.matches
+			}
+		}
+	} else {
+		for i, line := range lines {
+			if re.MatchString(line) {
+				f.matches++
+				matched[i] = f.matches
+			}
+		}
+	}
+	if f.matches == 0 {
+		return nil
+	}
+
+	for i := range lines {
+		show := matched[i] > 0
+		for j := max(0, i-opts.After); !show && j < i; j++ {
+			show = matched[j] > 0
+		}
+		for j := i + 1; !show && j <= i+opts.Before && j < len(lines); j++ {
+			show = matched[j] > 0
+		}
+		if show {
+			f.lines = append(f.lines, grepLine{num: i + 1, text: strings.TrimSuffix(lines[i], "\r"), match: matched[i]})
+		}
+	}
+	return f
+}
+
+// formatGrep renders results in the output mode, showing at most
+// MaxResults matches (or files) and saying how many were left out. It
+// returns "" when nothing matched.
+func formatGrep(files []*grepFile, opts grepOptions) string {
+	totalMatches := 0
+	for _, f := range files {
+		totalMatches += f.matches
+	}
+	var sb strings.Builder
+	if opts.OutputMode != "content" {
+		shown := min(len(files), opts.MaxResults)
+		for _, f := range files[:shown] {
+			if opts.OutputMode == "count" {
+				fmt.Fprintf(&sb, "%s:%d\n", f.path, f.matches)
+			} else {
+				fmt.Fprintf(&sb, "%s\n", f.path)
+			}
+		}
+		if shown < len(files) {
+			fmt.Fprintf(&sb, "\n[Showing %d of %d files (%d matches); narrow the search or raise max_results.]\n", shown, len(files), totalMatches)
+		}
+		return sb.String()
+	}
+
+	shown := 0
+	for _, f := range files {
+		if shown == opts.MaxResults {
+			break
+		}
+		lines := f.lines
+		if n := f.matches; shown+n > opts.MaxResults {
+			lines = capLines(lines, opts.MaxResults-shown, opts.After)
+		}
+		shown += min(f.matches, opts.MaxResults-shown)
+		prev := 0
+		for _, line := range lines {
+			if prev != 0 && line.num != prev+1 {
+				sb.WriteString("--\n")
+			}
+			sep := "-"
+			if line.match > 0 {
+				sep = ":"
+			}
+			fmt.Fprintf(&sb, "%s%s%d%s%s\n", f.path, sep, line.num, sep, line.text)
+			prev = line.num
+		}
+	}
+	if shown < totalMatches {
+		fmt.Fprintf(&sb, "\n[Showing %d of %d matches in %d files; narrow the search or raise max_results.]\n", shown, totalMatches, len(files))
+	}
+	return sb.String()
+}
+
+// capLines returns the lines of the first n matches and up to after lines
+// of context following the last of them.
+func capLines(lines []grepLine, n, after int) []grepLine {
+	last := 0
+	for i, line := range lines {
+		if line.match > n {
+			lines = lines[:i]
+			break
+		}
+		if line.match > 0 {
+			last = line.num
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1].num > last+after {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
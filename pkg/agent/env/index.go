@@ -0,0 +1,476 @@
+package env
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxIndexedFileSize skips generated bundles and data files.
+const maxIndexedFileSize = 512 * 1024
+
+// skippedIndexDirs are never descended into when indexing.
+var skippedIndexDirs = map[string]bool{
+	".git": true, "node_modules": true, "vendor": true, "dist": true,
+	"build": true, "target": true, "__pycache__": true, ".venv": true,
+}
+
+// Symbol is a declaration found by the code index.
+type Symbol struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"` // func, method, type, class, interface, const, var, ...
+	Path      string `json:"path"` // relative to the index root
+	Line      int    `json:"line"`
+	Signature string `json:"signature"`
+}
+
+// SearchResult is a ranked match returned by CodeIndex.Search.
+type SearchResult struct {
+	Path    string  `json:"path"`
+	Line    int     `json:"line"`
+	Score   float64 `json:"score"`
+	Symbol  *Symbol `json:"symbol,omitempty"` // nil for plain text matches
+	Snippet string  `json:"snippet"`
+}
+
+// CodeIndex is an in-memory index of the declarations and source lines under
+// a directory. Go files are parsed with go/parser; other languages use
+// line-based heuristics. Refresh re-reads only files whose size or
+// modification time changed.
+type CodeIndex struct {
+	root string
+
+	mu    sync.Mutex
+	files map[string]*indexedFile
+}
+
+type indexedFile struct {
+	modTime time.Time
+	size    int64
+	lines   []string
+	symbols []Symbol
+}
+
+// NewCodeIndex creates an empty index of root. Call Refresh or Search to
+// populate it.
+func NewCodeIndex(root string) *CodeIndex {
+	return &CodeIndex{root: root, files: make(map[string]*indexedFile)}
+}
+
+// Refresh brings the index up to date with the files on disk.
+func (ix *CodeIndex) Refresh() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	seen := make(map[string]bool, len(ix.files))
+	err := filepath.WalkDir(ix.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != ix.root && (skippedIndexDirs[name] || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if languageOf(path) == "" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		rel, err := filepath.Rel(ix.root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+		if f, ok := ix.files[rel]; ok && f.size == info.Size() && f.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		ix.files[rel] = &indexedFile{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			lines:   strings.Split(string(src), "\n"),
+			symbols: extractSymbols(rel, src),
+		}
+		return nil
+	})
+	for rel := range ix.files {
+		if !seen[rel] {
+			delete(ix.files, rel)
+		}
+	}
+	return err
+}
+
+// Search refreshes the index and returns up to limit results for query,
+// best first. Declarations whose names match the query rank above plain
+// text matches. If dir is non-empty, only files under that relative
+// directory are searched.
+func (ix *CodeIndex) Search(query, dir string, limit int) ([]SearchResult, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("query must contain at least one word")
+	}
+	if err := ix.Refresh(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	dir = strings.Trim(filepath.ToSlash(filepath.Clean(dir)), "/")
+	if dir == "." {
+		dir = ""
+	}
+	whole := strings.Join(terms, "")
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var results []SearchResult
+	for rel, f := range ix.files {
+		if dir != "" && rel != dir && !strings.HasPrefix(rel, dir+"/") {
+			continue
+		}
+		symbolLines := make(map[int]bool, len(f.symbols))
+		for i := range f.symbols {
+			sym := &f.symbols[i]
+			if score := scoreSymbol(sym, terms, whole); score > 0 {
+				results = append(results, SearchResult{
+					Path: rel, Line: sym.Line, Score: score, Symbol: sym,
+					Snippet: snippet(f.lines, sym.Line, 0, 8),
+				})
+				symbolLines[sym.Line] = true
+			}
+		}
+		for i, line := range f.lines {
+			if symbolLines[i+1] {
+				continue
+			}
+			if score := scoreLine(line, terms); score > 0 {
+				results = append(results, SearchResult{
+					Path: rel, Line: i + 1, Score: score,
+					Snippet: snippet(f.lines, i+1, 2, 2),
+				})
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].Line < results[j].Line
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// scoreSymbol ranks a declaration against the query terms.
+func scoreSymbol(sym *Symbol, terms []string, whole string) float64 {
+	name := strings.ToLower(sym.Name)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	nameWords := splitIdentifier(sym.Name)
+	joined := strings.ReplaceAll(name, "_", "")
+	path := strings.ToLower(sym.Path)
+
+	var score float64
+	matched := 0
+	for _, term := range terms {
+		switch {
+		case name == term:
+			score += 50
+		case containsWord(nameWords, term):
+			score += 25
+		case strings.Contains(name, term):
+			score += 15
+		case strings.Contains(path, term):
+			score += 3
+			continue
+		default:
+			continue
+		}
+		matched++
+	}
+	if matched == 0 {
+		return 0
+	}
+	if joined == whole {
+		score += 100
+	}
+	// Prefer declarations that cover every term.
+	score *= float64(matched) / float64(len(terms))
+	switch sym.Kind {
+	case "type", "class", "interface", "struct", "trait":
+		score += 5
+	case "func", "method":
+		score += 3
+	}
+	return score
+}
+
+// scoreLine ranks a source line that mentions every query term.
+func scoreLine(line string, terms []string) float64 {
+	lower := strings.ToLower(line)
+	var score float64
+	for _, term := range terms {
+		n := strings.Count(lower, term)
+		if n == 0 {
+			return 0
+		}
+		score += 1 + 0.1*float64(min(n, 5))
+	}
+	return score
+}
+
+// snippet returns lines [line-before, line+after] (1-based), numbered.
+func snippet(lines []string, line, before, after int) string {
+	start := max(line-before, 1)
+	end := min(line+after, len(lines))
+	var sb strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&sb, "%6d\t%s\n", i, lines[i-1])
+	}
+	return sb.String()
+}
+
+// queryTerms splits a query into lowercase words, also splitting camelCase
+// and snake_case identifiers.
+func queryTerms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, field := range strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		for _, w := range splitIdentifier(field) {
+			if len(w) > 1 && !seen[w] {
+				seen[w] = true
+				terms = append(terms, w)
+			}
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits an identifier into lowercase words at underscores,
+// dots, and camelCase boundaries.
+func splitIdentifier(ident string) []string {
+	var words []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, strings.ToLower(string(cur)))
+			cur = cur[:0]
+		}
+	}
+	runes := []rune(ident)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '.' || r == '-':
+			flush()
+			continue
+		case unicode.IsUpper(r) && len(cur) > 0:
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return words
+}
+
+func containsWord(words []string, w string) bool {
+	for _, x := range words {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// languageOf returns the language key used for symbol extraction, or "" for
+// files that are not indexed.
+func languageOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return "go"
+	case ".py":
+		return "python"
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		return "js"
+	case ".rs":
+		return "rust"
+	case ".java", ".kt", ".scala", ".cs":
+		return "java"
+	case ".rb":
+		return "ruby"
+	case ".c", ".h", ".cc", ".cpp", ".hpp":
+		return "c"
+	case ".md", ".txt", ".yaml", ".yml", ".toml", ".json", ".dot", ".sh", ".sql", ".proto":
+		return "text"
+	}
+	return ""
+}
+
+// symbolPatterns are the line heuristics for languages without a parser.
+// The last submatch group that is non-empty names the symbol; kindGroup
+// (if > 0) names its kind.
+var symbolPatterns = map[string][]struct {
+	re        *regexp.Regexp
+	kind      string
+	kindGroup int
+}{
+	"python": {
+		{re: regexp.MustCompile(`^\s*(?:async\s+)?(def|class)\s+([A-Za-z_]\w*)`), kindGroup: 1},
+	},
+	"js": {
+		{re: regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:declare\s+)?(?:abstract\s+)?(?:async\s+)?(function|class|interface|type|enum)\*?\s+([A-Za-z_$][\w$]*)`), kindGroup: 1},
+		{re: regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*=>|[A-Za-z_$][\w$]*\s*=>)`), kind: "func"},
+	},
+	"rust": {
+		{re: regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?(?:unsafe\s+)?(fn|struct|enum|trait|type|mod|const|static)\s+([A-Za-z_]\w*)`), kindGroup: 1},
+		{re: regexp.MustCompile(`^\s*impl(?:<[^>]*>)?\s+(?:[\w:<>]+\s+for\s+)?([A-Za-z_]\w*)`), kind: "impl"},
+	},
+	"java": {
+		{re: regexp.MustCompile(`^\s*(?:(?:public|private|protected|internal|static|final|abstract|sealed|data|open|partial)\s+)*(class|interface|enum|record|object|struct)\s+([A-Za-z_]\w*)`), kindGroup: 1},
+		{re: regexp.MustCompile(`^\s*(?:(?:public|private|protected|internal|static|final|abstract|override|async|virtual|synchronized)\s+)+[\w<>\[\],.? ]+\s+([A-Za-z_]\w*)\s*\(`), kind: "method"},
+		{re: regexp.MustCompile(`^\s*fun\s+(?:<[^>]*>\s*)?(?:[\w.]+\.)?([A-Za-z_]\w*)\s*\(`), kind: "func"},
+	},
+	"ruby": {
+		{re: regexp.MustCompile(`^\s*(def|class|module)\s+(?:self\.)?([A-Za-z_][\w?!]*)`), kindGroup: 1},
+	},
+	"c": {
+		{re: regexp.MustCompile(`^(?:struct|class|enum|union)\s+([A-Za-z_]\w*)\s*\{?\s*$`), kind: "type"},
+		{re: regexp.MustCompile(`^[A-Za-z_][\w\s\*&:<>,]*?[\s\*&]([A-Za-z_][\w:]*)\s*\([^;]*$`), kind: "func"},
+		{re: regexp.MustCompile(`^#define\s+([A-Za-z_]\w*)`), kind: "macro"},
+	},
+}
+
+// extractSymbols finds the declarations in a source file.
+func extractSymbols(rel string, src []byte) []Symbol {
+	lang := languageOf(rel)
+	if lang == "go" {
+		if syms, err := extractGoSymbols(rel, src); err == nil {
+			return syms
+		}
+	}
+	patterns := symbolPatterns[lang]
+	if len(patterns) == 0 {
+		return nil
+	}
+	var syms []Symbol
+	for i, line := range strings.Split(string(src), "\n") {
+		for _, p := range patterns {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			kind := p.kind
+			if p.kindGroup > 0 {
+				kind = m[p.kindGroup]
+			}
+			if kind == "def" || kind == "fn" || kind == "function" {
+				kind = "func"
+			}
+			syms = append(syms, Symbol{
+				Name: m[len(m)-1], Kind: kind, Path: rel, Line: i + 1,
+				Signature: strings.TrimSpace(line),
+			})
+			break
+		}
+	}
+	return syms
+}
+
+// extractGoSymbols parses a Go file and returns its top-level declarations.
+// Methods are named Receiver.Method.
+func extractGoSymbols(rel string, src []byte) ([]Symbol, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, rel, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(src), "\n")
+	add := func(syms []Symbol, name, kind string, pos token.Pos) []Symbol {
+		line := fset.Position(pos).Line
+		return append(syms, Symbol{
+			Name: name, Kind: kind, Path: rel, Line: line,
+			Signature: strings.TrimSpace(lines[line-1]),
+		})
+	}
+
+	var syms []Symbol
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				syms = add(syms, receiverName(d.Recv.List[0].Type)+"."+d.Name.Name, "method", d.Pos())
+			} else {
+				syms = add(syms, d.Name.Name, "func", d.Pos())
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch sp := spec.(type) {
+				case *ast.TypeSpec:
+					kind := "type"
+					if _, ok := sp.Type.(*ast.InterfaceType); ok {
+						kind = "interface"
+					}
+					syms = add(syms, sp.Name.Name, kind, sp.Pos())
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, n := range sp.Names {
+						if n.Name != "_" {
+							syms = add(syms, n.Name, kind, n.Pos())
+						}
+					}
+				}
+			}
+		}
+	}
+	return syms, nil
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
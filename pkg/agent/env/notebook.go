@@ -0,0 +1,313 @@
+package env
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// notebook is a Jupyter notebook decoded loosely, so fields this package
+// does not know about survive an edit unchanged.
+type notebook struct {
+	doc   map[string]interface{}
+	cells []map[string]interface{}
+}
+
+func loadNotebook(path string) (*notebook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read notebook: %w", err)
+	}
+	// Keep numbers as written, so execution counts and metadata round-trip.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid notebook JSON: %w", err)
+	}
+	raw, _ := doc["cells"].([]interface{})
+	nb := &notebook{doc: doc}
+	for i, c := range raw {
+		cell, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid notebook: cell %d is not an object", i)
+		}
+		nb.cells = append(nb.cells, cell)
+	}
+	return nb, nil
+}
+
+// save writes the notebook in the layout Jupyter uses: sorted keys, one-space
+// indentation, and a trailing newline.
+func (nb *notebook) save(path string) error {
+	cells := make([]interface{}, len(nb.cells))
+	for i, c := range nb.cells {
+		cells[i] = c
+	}
+	nb.doc["cells"] = cells
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(nb.doc); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// language returns the notebook's kernel language, defaulting to python.
+func (nb *notebook) language() string {
+	if meta, ok := nb.doc["metadata"].(map[string]interface{}); ok {
+		if info, ok := meta["language_info"].(map[string]interface{}); ok {
+			if name, ok := info["name"].(string); ok && name != "" {
+				return name
+			}
+		}
+	}
+	return "python"
+}
+
+// findCell returns the index of the cell with the given ID, or the cell at
+// index when id is empty.
+func (nb *notebook) findCell(id string, index int) (int, error) {
+	if id == "" {
+		if index < 0 || index >= len(nb.cells) {
+			return 0, fmt.Errorf("cell index %d out of range (notebook has %d cells)", index, len(nb.cells))
+		}
+		return index, nil
+	}
+	for i, c := range nb.cells {
+		if c["id"] == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no cell with id %q", id)
+}
+
+// multilineText joins a notebook text field, which is either a string or a
+// list of lines.
+func multilineText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []interface{}:
+		var sb strings.Builder
+		for _, line := range t {
+			if s, ok := line.(string); ok {
+				sb.WriteString(s)
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// sourceLines splits source into the list-of-lines form Jupyter writes, each
+// line keeping its newline.
+func sourceLines(source string) []interface{} {
+	lines := []interface{}{}
+	for source != "" {
+		i := strings.IndexByte(source, '\n')
+		if i < 0 {
+			lines = append(lines, source)
+			break
+		}
+		lines = append(lines, source[:i+1])
+		source = source[i+1:]
+	}
+	return lines
+}
+
+// notebookRead renders a notebook's cells, with their IDs, sources, and
+// outputs, as markdown.
+func (e *LocalEnvironment) notebookRead(args json.RawMessage) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	path, err := e.jailPath(params.Path)
+	if err != nil {
+		return "", err
+	}
+	nb, err := loadNotebook(path)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for i, cell := range nb.cells {
+		cellType, _ := cell["cell_type"].(string)
+		fmt.Fprintf(&sb, "## Cell %d (%s", i, cellType)
+		if id, ok := cell["id"].(string); ok {
+			fmt.Fprintf(&sb, ", id %s", id)
+		}
+		sb.WriteString(")\n\n")
+		lang := ""
+		if cellType == "code" {
+			lang = nb.language()
+		}
+		fmt.Fprintf(&sb, "```%s\n%s\n```\n", lang, strings.TrimRight(multilineText(cell["source"]), "\n"))
+		if outputs, ok := cell["outputs"].([]interface{}); ok && len(outputs) > 0 {
+			sb.WriteString("\nOutput:\n\n```\n")
+			for _, o := range outputs {
+				if out, ok := o.(map[string]interface{}); ok {
+					sb.WriteString(strings.TrimRight(renderOutput(out), "\n"))
+					sb.WriteString("\n")
+				}
+			}
+			sb.WriteString("```\n")
+		}
+		sb.WriteString("\n")
+	}
+	if len(nb.cells) == 0 {
+		return "Notebook has no cells.", nil
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// renderOutput returns the text of a cell output; rich outputs without a
+// text/plain form are named by MIME type.
+func renderOutput(out map[string]interface{}) string {
+	switch out["output_type"] {
+	case "stream":
+		return multilineText(out["text"])
+	case "error":
+		return fmt.Sprintf("%v: %v", out["ename"], out["evalue"])
+	}
+	data, _ := out["data"].(map[string]interface{})
+	if text, ok := data["text/plain"]; ok {
+		return multilineText(text)
+	}
+	mimes := make([]string, 0, len(data))
+	for mime := range data {
+		mimes = append(mimes, mime)
+	}
+	sort.Strings(mimes)
+	return fmt.Sprintf("[%s output]", strings.Join(mimes, ", "))
+}
+
+// notebookEdit replaces, inserts, or deletes one cell. Replacing a code
+// cell's source clears its outputs, as they no longer match it.
+func (e *LocalEnvironment) notebookEdit(args json.RawMessage) (string, error) {
+	var params struct {
+		Path     string `json:"path"`
+		CellID   string `json:"cell_id"`
+		Index    int    `json:"index"`
+		Source   string `json:"source"`
+		CellType string `json:"cell_type"`
+		EditMode string `json:"edit_mode"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	path, err := e.jailPath(params.Path)
+	if err != nil {
+		return "", err
+	}
+	nb, err := loadNotebook(path)
+	if err != nil {
+		return "", err
+	}
+	if params.CellType != "" && params.CellType != "code" && params.CellType != "markdown" && params.CellType != "raw" {
+		return "", fmt.Errorf("invalid cell_type %q (want code, markdown, or raw)", params.CellType)
+	}
+
+	var summary string
+	switch params.EditMode {
+	case "", "replace":
+		i, err := nb.findCell(params.CellID, params.Index)
+		if err != nil {
+			return "", err
+		}
+		cell := nb.cells[i]
+		cell["source"] = sourceLines(params.Source)
+		if params.CellType != "" && params.CellType != cell["cell_type"] {
+			cell["cell_type"] = params.CellType
+			if params.CellType != "code" {
+				delete(cell, "outputs")
+				delete(cell, "execution_count")
+			}
+		}
+		if cell["cell_type"] == "code" {
+			cell["outputs"] = []interface{}{}
+			cell["execution_count"] = nil
+		}
+		summary = fmt.Sprintf("Replaced cell %d", i)
+	case "insert":
+		// Insert after the cell named by cell_id, or at index.
+		at := params.Index
+		if params.CellID != "" {
+			i, err := nb.findCell(params.CellID, 0)
+			if err != nil {
+				return "", err
+			}
+			at = i + 1
+		}
+		if at < 0 || at > len(nb.cells) {
+			return "", fmt.Errorf("cell index %d out of range (notebook has %d cells)", at, len(nb.cells))
+		}
+		if params.CellType == "" {
+			return "", fmt.Errorf("cell_type is required to insert a cell")
+		}
+		cell := map[string]interface{}{
+			"cell_type": params.CellType,
+			"metadata":  map[string]interface{}{},
+			"source":    sourceLines(params.Source),
+		}
+		if params.CellType == "code" {
+			cell["outputs"] = []interface{}{}
+			cell["execution_count"] = nil
+		}
+		if nb.hasCellIDs() {
+			cell["id"] = newCellID()
+		}
+		nb.cells = append(nb.cells[:at], append([]map[string]interface{}{cell}, nb.cells[at:]...)...)
+		summary = fmt.Sprintf("Inserted %s cell %d", params.CellType, at)
+	case "delete":
+		i, err := nb.findCell(params.CellID, params.Index)
+		if err != nil {
+			return "", err
+		}
+		nb.cells = append(nb.cells[:i], nb.cells[i+1:]...)
+		summary = fmt.Sprintf("Deleted cell %d", i)
+	default:
+		return "", fmt.Errorf("invalid edit_mode %q (want replace, insert, or delete)", params.EditMode)
+	}
+
+	if err := nb.save(path); err != nil {
+		return "", fmt.Errorf("write notebook: %w", err)
+	}
+	return fmt.Sprintf("%s in %s", summary, params.Path), nil
+}
+
+// hasCellIDs reports whether the notebook's cells carry IDs (nbformat 4.5+).
+func (nb *notebook) hasCellIDs() bool {
+	for _, c := range nb.cells {
+		if _, ok := c["id"]; ok {
+			return true
+		}
+	}
+	if len(nb.cells) == 0 {
+		// A notebook without nbformat_minor is treated as predating IDs.
+		n, ok := nb.doc["nbformat_minor"].(json.Number)
+		if !ok {
+			return false
+		}
+		minor, _ := n.Int64()
+		return minor >= 5
+	}
+	return false
+}
+
+func newCellID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
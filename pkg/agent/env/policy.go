@@ -0,0 +1,161 @@
+package env
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Policy restricts what a LocalEnvironment may do. The zero value allows everything.
+type Policy struct {
+	// AllowCommands, if non-empty, requires every bash command to match at least one pattern.
+	AllowCommands []*regexp.Regexp
+	// DenyCommands rejects bash commands matching any pattern. Deny wins over allow.
+	DenyCommands []*regexp.Regexp
+	// JailPaths rejects file tool paths that resolve outside WorkDir.
+	JailPaths bool
+	// DisableNetwork rejects bash commands that invoke common network tools and
+	// points HTTP proxies at an unroutable address. This is best effort, not
+	// a kernel-level sandbox.
+	DisableNetwork bool
+	// MaxOutputBytes truncates tool output beyond this size (0 = unlimited).
+	MaxOutputBytes int
+}
+
+// PolicyViolation is returned when a tool call is rejected by the Policy. Its
+// message is written for the model, so it can choose a permitted alternative.
+type PolicyViolation struct {
+	Rule   string // command_denied, command_not_allowed, path_outside_workdir, network_disabled
+	Detail string
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("policy violation (%s): %s", v.Rule, v.Detail)
+}
+
+// networkCommandPattern matches commands that normally reach the network.
+var networkCommandPattern = regexp.MustCompile(
+	`(^|[\s;&|(]+)(curl|wget|ssh|scp|sftp|rsync|nc|ncat|telnet|ftp|ping|dig|nslookup)(\s|$)` +
+		`|\bgit\s+(clone|fetch|pull|push|ls-remote)\b` +
+		`|\b(npm|yarn|pnpm)\s+(install|add|publish)\b` +
+		`|\bpip3?\s+(install|download)\b` +
+		`|\bgo\s+(get|mod\s+download)\b`)
+
+// disabledNetworkEnv points HTTP clients at an unroutable proxy.
+var disabledNetworkEnv = []string{
+	"http_proxy=http://127.0.0.1:9", "https_proxy=http://127.0.0.1:9",
+	"HTTP_PROXY=http://127.0.0.1:9", "HTTPS_PROXY=http://127.0.0.1:9",
+	"no_proxy=", "NO_PROXY=",
+}
+
+// MustCompilePatterns compiles command patterns for a Policy, panicking on
+// invalid expressions.
+func MustCompilePatterns(patterns ...string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		res[i] = regexp.MustCompile(p)
+	}
+	return res
+}
+
+// CheckCommand reports whether a bash command is permitted.
+func (p *Policy) CheckCommand(command string) error {
+	if p == nil {
+		return nil
+	}
+	for _, re := range p.DenyCommands {
+		if re.MatchString(command) {
+			return &PolicyViolation{
+				Rule:   "command_denied",
+				Detail: fmt.Sprintf("command matches denied pattern %q; use a different command", re.String()),
+			}
+		}
+	}
+	if len(p.AllowCommands) > 0 {
+		allowed := false
+		for _, re := range p.AllowCommands {
+			if re.MatchString(command) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			patterns := make([]string, len(p.AllowCommands))
+			for i, re := range p.AllowCommands {
+				patterns[i] = re.String()
+			}
+			return &PolicyViolation{
+				Rule:   "command_not_allowed",
+				Detail: "command does not match any allowed pattern: " + strings.Join(patterns, ", "),
+			}
+		}
+	}
+	if p.DisableNetwork && networkCommandPattern.MatchString(command) {
+		return &PolicyViolation{
+			Rule:   "network_disabled",
+			Detail: "network access is disabled in this environment; work with local files only",
+		}
+	}
+	return nil
+}
+
+// CheckPath reports whether an absolute path is permitted under workDir.
+func (p *Policy) CheckPath(workDir, path string) error {
+	if p == nil || !p.JailPaths {
+		return nil
+	}
+	if !withinDir(workDir, path) {
+		return &PolicyViolation{
+			Rule:   "path_outside_workdir",
+			Detail: fmt.Sprintf("%s is outside the working directory %s; use a path inside it", path, workDir),
+		}
+	}
+	return nil
+}
+
+// LimitOutput truncates output to MaxOutputBytes, at a rune boundary, noting
+// how much was dropped.
+func (p *Policy) LimitOutput(output string) string {
+	if p == nil || p.MaxOutputBytes <= 0 || len(output) <= p.MaxOutputBytes {
+		return output
+	}
+	kept := TruncateUTF8(output, p.MaxOutputBytes)
+	dropped := len(output) - len(kept)
+	return kept + fmt.Sprintf("\n[output truncated by policy: %d bytes omitted]", dropped)
+}
+
+// withinDir reports whether path is dir or inside it, following symlinks
+// where they exist so a link cannot escape the jail.
+func withinDir(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if resolved, err := evalExistingPrefix(path); err == nil {
+		path = resolved
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// evalExistingPrefix resolves symlinks in the longest existing prefix of path,
+// so paths to files that do not exist yet are still checked.
+func evalExistingPrefix(path string) (string, error) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved, nil
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolved, err := evalExistingPrefix(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(path)), nil
+}
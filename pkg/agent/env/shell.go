@@ -0,0 +1,112 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Shell runs command strings: Name is the program and Args the arguments
+// that precede the command, e.g. bash -c or pwsh -NoProfile -Command. The
+// zero value runs commands with DefaultShell.
+type Shell struct {
+	Name string
+	Args []string
+}
+
+// ParseShell returns the shell named name: bash, sh, zsh, pwsh, powershell,
+// or cmd. An empty name returns the zero Shell.
+func ParseShell(name string) (Shell, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return Shell{}, nil
+	case "bash", "sh", "zsh":
+		return Shell{Name: strings.ToLower(name), Args: []string{"-c"}}, nil
+	case "pwsh", "powershell":
+		return Shell{Name: strings.ToLower(name), Args: []string{"-NoProfile", "-NonInteractive", "-Command"}}, nil
+	case "cmd":
+		return Shell{Name: "cmd", Args: []string{"/C"}}, nil
+	}
+	return Shell{}, fmt.Errorf("unknown shell %q (want bash, sh, zsh, pwsh, powershell, or cmd)", name)
+}
+
+// DefaultShell returns the shell for agent commands: bash, or sh where bash
+// is not installed, and on Windows pwsh, then Windows PowerShell, then cmd.
+func DefaultShell() Shell {
+	if runtime.GOOS != "windows" {
+		return firstShell("bash", "sh")
+	}
+	return SystemShell()
+}
+
+// SystemShell returns the platform's standard shell: sh, or on Windows pwsh,
+// then Windows PowerShell, then cmd.
+func SystemShell() Shell {
+	if runtime.GOOS != "windows" {
+		shell, _ := ParseShell("sh")
+		return shell
+	}
+	return firstShell("pwsh", "powershell", "cmd")
+}
+
+// firstShell returns the first of names found on PATH, or the last one.
+func firstShell(names ...string) Shell {
+	name := names[len(names)-1]
+	for _, n := range names {
+		if _, err := exec.LookPath(n); err == nil {
+			name = n
+			break
+		}
+	}
+	shell, _ := ParseShell(name)
+	return shell
+}
+
+// Command returns a command that runs command with the shell.
+func (s Shell) Command(ctx context.Context, command string) *exec.Cmd {
+	if s.Name == "" {
+		s = DefaultShell()
+	}
+	return exec.CommandContext(ctx, s.Name, append(append([]string{}, s.Args...), command)...)
+}
+
+// String returns the shell's program name.
+func (s Shell) String() string {
+	if s.Name == "" {
+		return DefaultShell().Name
+	}
+	return s.Name
+}
+
+// Quote quotes arg as a single literal argument for the shell: in single
+// quotes for sh-like shells and PowerShell, and in double quotes for cmd.
+// cmd expands %VAR% and !VAR! even inside quotes and has no escape that
+// works in every context, so Quote fails for arguments containing ", %, !,
+// or a line break rather than let them be interpreted.
+func (s Shell) Quote(arg string) (string, error) {
+	if s.Name == "" {
+		s = DefaultShell()
+	}
+	switch s.Name {
+	case "pwsh", "powershell":
+		// PowerShell also treats typographic single quotes as quotes.
+		var b strings.Builder
+		b.WriteByte('\'')
+		for _, r := range arg {
+			if strings.ContainsRune("'‘’‚‛", r) {
+				b.WriteRune(r)
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('\'')
+		return b.String(), nil
+	case "cmd":
+		if strings.ContainsAny(arg, "\"%!\r\n") {
+			return "", fmt.Errorf("cannot safely quote %q for cmd: it contains \", %%, !, or a line break", arg)
+		}
+		return `"` + arg + `"`, nil
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'", nil
+}
@@ -0,0 +1,148 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// OutputFunc receives chunks of tool output as they are produced. stream is
+// "stdout" or "stderr". Calls for a single tool invocation are serialized.
+type OutputFunc func(stream, chunk string)
+
+type outputFuncKey struct{}
+
+// WithOutputFunc returns a context that streams tool output to fn while
+// commands run. The complete output is still returned from Execute.
+func WithOutputFunc(ctx context.Context, fn OutputFunc) context.Context {
+	return context.WithValue(ctx, outputFuncKey{}, fn)
+}
+
+func outputFuncFrom(ctx context.Context) OutputFunc {
+	fn, _ := ctx.Value(outputFuncKey{}).(OutputFunc)
+	return fn
+}
+
+// streamOutput is the state shared by a command's stdout and stderr writers.
+// mu serializes the OutputFunc; seen and sent count raw output bytes against
+// limit, the policy's MaxOutputBytes.
+type streamOutput struct {
+	mu        sync.Mutex
+	fn        OutputFunc
+	secrets   *secrets.Store
+	limit     int
+	seen      int
+	sent      int
+	truncated bool
+}
+
+// streamWriter buffers output and forwards it to an OutputFunc. Streamed
+// chunks are redacted, end on rune boundaries, and stop at the policy's
+// output limit; a tail that might be the start of a secret or of a multibyte
+// rune is held back until more output arrives or Flush is called.
+type streamWriter struct {
+	out     *streamOutput
+	buf     bytes.Buffer
+	pending []byte
+	stream  string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+	w.buf.Write(p)
+	if w.out.fn != nil && len(p) > 0 {
+		w.out.seen += len(p)
+		w.pending = append(w.pending, p...)
+		w.emit(false)
+	}
+	return len(p), nil
+}
+
+// Flush streams whatever output is still held back. Call it once the command
+// has exited.
+func (w *streamWriter) Flush() {
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+	if w.out.fn != nil {
+		w.emit(true)
+	}
+}
+
+func (w *streamWriter) emit(final bool) {
+	out := w.out
+	if out.truncated {
+		w.pending = nil
+		return
+	}
+	n := len(w.pending)
+	over := out.limit > 0 && out.seen > out.limit
+	if over && out.limit-out.sent < n {
+		n = out.limit - out.sent
+	}
+	cut := n
+	if !final || over {
+		cut = out.secrets.Boundary(string(w.pending), n)
+		cut = runeBoundary(w.pending, cut)
+	}
+	if cut > 0 {
+		out.fn(w.stream, out.secrets.Redact(string(w.pending[:cut])))
+		out.sent += cut
+		w.pending = append(w.pending[:0], w.pending[cut:]...)
+	}
+	if over {
+		out.fn(w.stream, "\n[output truncated by policy]\n")
+		out.truncated = true
+		w.pending = nil
+	}
+}
+
+// runeBoundary moves cut back so that p[:cut] does not end inside a
+// multibyte rune.
+func runeBoundary[T string | []byte](p T, cut int) int {
+	for i := cut - 1; i >= 0 && i >= cut-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune([]byte(p[i:cut])) {
+				return i
+			}
+			break
+		}
+	}
+	return cut
+}
+
+// TruncateUTF8 returns the first n bytes of s, or fewer so that it does not
+// end inside a multibyte rune.
+func TruncateUTF8(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	return s[:runeBoundary(s, n)]
+}
+
+func (w *streamWriter) String() string {
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+	return w.buf.String()
+}
+
+func (w *streamWriter) Len() int {
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+	return w.buf.Len()
+}
+
+// newStreamWriters returns stdout and stderr writers that stream to the
+// context's OutputFunc, if any, redacting store's secrets and stopping at
+// policy's MaxOutputBytes.
+func newStreamWriters(ctx context.Context, store *secrets.Store, policy *Policy) (*streamWriter, *streamWriter) {
+	out := &streamOutput{fn: outputFuncFrom(ctx), secrets: store}
+	if policy != nil {
+		out.limit = policy.MaxOutputBytes
+	}
+	return &streamWriter{out: out, stream: "stdout"},
+		&streamWriter{out: out, stream: "stderr"}
+}
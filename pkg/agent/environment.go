@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"slices"
 
 	"github.com/ashka-vakil/attractor/pkg/agent/env"
 	"github.com/ashka-vakil/attractor/pkg/agent/tools"
@@ -12,6 +13,10 @@ import (
 // ExecutionEnvironment is the interface for tool execution.
 type ExecutionEnvironment interface {
 	Execute(ctx context.Context, toolName string, arguments json.RawMessage) (string, error)
+	// Tools returns the schemas of the tools Execute implements. Sessions
+	// offer them to the model in place of profile definitions of the same
+	// name, so schemas always match the implementation.
+	Tools() []llm.Tool
 }
 
 // NewLocalEnvironment creates a local execution environment.
@@ -19,15 +24,65 @@ func NewLocalEnvironment() ExecutionEnvironment {
 	return env.NewLocalEnvironment("")
 }
 
-// DefaultToolSet returns the default set of tools.
+// tools returns the tools offered to the model: the profile's tools, with the
+// environment's schema in place of any of the same name, followed, with
+// SessionConfig.DiscoverEnvironmentTools, by the environment's tools the
+// profile does not list, less any the session config disables.
+func (s *Session) tools() []llm.Tool {
+	var envTools []llm.Tool
+	if s.ExecutionEnv != nil {
+		envTools = s.ExecutionEnv.Tools()
+	}
+	fromEnv := make(map[string]llm.Tool, len(envTools))
+	for _, t := range envTools {
+		fromEnv[t.Name] = t
+	}
+
+	var result []llm.Tool
+	listed := make(map[string]bool)
+	for _, t := range s.ProviderProfile.Tools {
+		if et, ok := fromEnv[t.Name]; ok {
+			t = et
+		}
+		listed[t.Name] = true
+		if s.toolEnabled(t.Name) {
+			result = append(result, t)
+		}
+	}
+	if !s.Config.DiscoverEnvironmentTools {
+		return result
+	}
+	for _, t := range envTools {
+		if !listed[t.Name] && s.toolEnabled(t.Name) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// toolEnabled reports whether SessionConfig.EnabledTools and DisabledTools
+// allow the named tool.
+func (s *Session) toolEnabled(name string) bool {
+	if len(s.Config.EnabledTools) > 0 && !slices.Contains(s.Config.EnabledTools, name) {
+		return false
+	}
+	return !slices.Contains(s.Config.DisabledTools, name)
+}
+
+// DefaultToolSet returns the default set of tools: those of a local
+// environment plus the session's own.
 func DefaultToolSet() []llm.Tool {
+	return append(env.BuiltinTools(), SessionTools()...)
+}
+
+// SessionTools returns the tools a session handles itself rather than
+// passing to its execution environment.
+func SessionTools() []llm.Tool {
 	return []llm.Tool{
-		tools.ReadFile(),
-		tools.WriteFile(),
-		tools.EditFile(),
-		tools.Bash(),
-		tools.GlobSearch(),
-		tools.GrepSearch(),
+		tools.MultiEdit(),
+		tools.TodoWrite(),
+		tools.TodoRead(),
+		tools.RevertChanges(),
 	}
 }
 
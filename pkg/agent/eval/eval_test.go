@@ -0,0 +1,157 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/agent"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+func scriptedClient() *llm.Client {
+	return llm.NewClient(llm.WithProvider("mock", NewReplayAdapter([]*llm.Response{
+		{
+			FinishReason: llm.FinishReasonToolCalls,
+			ToolCalls: []llm.ToolCall{
+				{ID: "call-1", Name: "edit_file", Arguments: json.RawMessage(`{"path":"greet.go","old_string":"hi","new_string":"hello"}`)},
+			},
+			CreatedAt: time.Now(),
+		},
+		{Content: "Updated the greeting.", FinishReason: llm.FinishReasonStop, CreatedAt: time.Now()},
+	})))
+}
+
+func greetScenario() *Scenario {
+	return &Scenario{
+		Name:   "greet",
+		Files:  map[string]string{"greet.go": "package greet\n\nconst Greeting = \"hi\"\n"},
+		Prompt: "Change the greeting to hello",
+		Assertions: []Assertion{
+			{Type: AssertFileContains, Path: "greet.go", Value: `"hello"`},
+			{Type: AssertFileNotContains, Path: "greet.go", Value: `"hi"`},
+			{Type: AssertFileAbsent, Path: "notes.txt"},
+			{Type: AssertOutputMatches, Value: `(?i)updated`},
+			{Type: AssertToolCalled, Value: "edit_file"},
+			{Type: AssertToolNotCalled, Value: "bash"},
+		},
+	}
+}
+
+func TestRunnerLiveAndReplay(t *testing.T) {
+	recordDir := t.TempDir()
+	runner := &Runner{
+		Client:    scriptedClient(),
+		Profile:   agent.DefaultAnthropicProfile("test-model"),
+		Config:    agent.DefaultSessionConfig(),
+		RecordDir: recordDir,
+	}
+
+	res := runner.Run(context.Background(), greetScenario())
+	if !res.Passed {
+		t.Fatalf("expected live run to pass, got error %q failures %v", res.Error, res.Failures)
+	}
+	if res.Output != "Updated the greeting." {
+		t.Errorf("unexpected output %q", res.Output)
+	}
+
+	recording := filepath.Join(recordDir, "greet"+TranscriptSuffix)
+	if _, err := os.Stat(recording); err != nil {
+		t.Fatalf("expected recording: %v", err)
+	}
+
+	replayed := greetScenario()
+	replayed.Recording = recording
+	runner.Client = nil
+	res = runner.Run(context.Background(), replayed)
+	if !res.Passed || !res.Replayed {
+		t.Fatalf("expected replay to pass, got %+v", res)
+	}
+}
+
+func TestRunnerReportsFailures(t *testing.T) {
+	runner := &Runner{
+		Client:  scriptedClient(),
+		Profile: agent.DefaultAnthropicProfile("test-model"),
+		Config:  agent.DefaultSessionConfig(),
+	}
+	s := greetScenario()
+	s.Assertions = []Assertion{
+		{Type: AssertFileEquals, Path: "greet.go", Value: "package greet\n"},
+		{Type: AssertFileExists, Path: "README.md"},
+		{Type: AssertOutputContains, Value: "tests pass"},
+	}
+	noClient := greetScenario()
+	noClient.Name = "no-client"
+
+	rep := (&Runner{Profile: runner.Profile}).RunAll(context.Background(), []*Scenario{noClient})
+	if rep.Failed != 1 || !strings.Contains(rep.Results[0].Error, "no LLM client") {
+		t.Errorf("expected missing client to fail, got %+v", rep.Results[0])
+	}
+
+	rep = runner.RunAll(context.Background(), []*Scenario{s})
+	if rep.Passed != 0 || rep.Failed != 1 {
+		t.Fatalf("expected 1 failure, got %d passed %d failed", rep.Passed, rep.Failed)
+	}
+	if got := len(rep.Results[0].Failures); got != 3 {
+		t.Errorf("expected 3 assertion failures, got %v", rep.Results[0].Failures)
+	}
+	if out := rep.String(); !strings.Contains(out, "FAIL  greet") || !strings.Contains(out, "README.md does not exist") {
+		t.Errorf("unexpected report:\n%s", out)
+	}
+}
+
+func TestLoadScenarios(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("b.json", `{"prompt":"do b","recording":"b.transcript.json","assertions":[{"type":"tool_called","value":"bash"}]}`)
+	write("a.json", `{"name":"first","prompt":"do a","assertions":[]}`)
+	write("b.transcript.json", `{"version":1,"turns":[]}`)
+
+	scenarios, err := LoadScenarios(dir)
+	if err != nil {
+		t.Fatalf("LoadScenarios failed: %v", err)
+	}
+	if len(scenarios) != 2 || scenarios[0].Name != "first" || scenarios[1].Name != "b" {
+		t.Fatalf("unexpected scenarios: %+v", scenarios)
+	}
+	if scenarios[1].Recording != filepath.Join(dir, "b.transcript.json") {
+		t.Errorf("expected recording resolved against scenario dir, got %q", scenarios[1].Recording)
+	}
+
+	for _, bad := range []Scenario{
+		{Name: "x"},
+		{Name: "x", Prompt: "p", Files: map[string]string{"../escape.txt": ""}},
+		{Name: "x", Prompt: "p", Assertions: []Assertion{{Type: "file_contains"}}},
+		{Name: "x", Prompt: "p", Assertions: []Assertion{{Type: "output_matches", Value: "("}}},
+		{Name: "x", Prompt: "p", Assertions: []Assertion{{Type: "vibes", Value: "good"}}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected validation error for %+v", bad)
+		}
+	}
+}
+
+func TestRunnerReportsTurnLimit(t *testing.T) {
+	config := agent.DefaultSessionConfig()
+	config.MaxTurns = 1
+	runner := &Runner{
+		Client:  scriptedClient(),
+		Profile: agent.DefaultAnthropicProfile("test-model"),
+		Config:  config,
+	}
+	s := greetScenario()
+	s.FollowUps = []string{"Now add a farewell"}
+	res := runner.Run(context.Background(), s)
+	if res.Passed || !strings.Contains(res.Error, "limit of 1 turns") {
+		t.Fatalf("expected the turn limit reported as an error, got %+v", res)
+	}
+}
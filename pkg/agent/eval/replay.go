@@ -0,0 +1,64 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ashka-vakil/attractor/pkg/agent"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// ReplayAdapter is an llm.ProviderAdapter that returns recorded responses in
+// order, ignoring the request. It fails once the recording is exhausted, so a
+// scenario that diverges from its recording surfaces as an error.
+type ReplayAdapter struct {
+	mu        sync.Mutex
+	responses []*llm.Response
+	next      int
+}
+
+// NewReplayAdapter creates an adapter that replays responses.
+func NewReplayAdapter(responses []*llm.Response) *ReplayAdapter {
+	return &ReplayAdapter{responses: responses}
+}
+
+func (a *ReplayAdapter) Name() string { return "replay" }
+func (a *ReplayAdapter) Close() error { return nil }
+
+func (a *ReplayAdapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.next >= len(a.responses) {
+		return nil, fmt.Errorf("replay: recording exhausted after %d responses", len(a.responses))
+	}
+	resp := *a.responses[a.next]
+	a.next++
+	return &resp, nil
+}
+
+func (a *ReplayAdapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.StreamEvent, error) {
+	resp, err := a.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan llm.StreamEvent, 1)
+	ch <- llm.StreamEvent{Type: llm.StreamEventEnd, Response: resp, FinishReason: resp.FinishReason, Usage: &resp.Usage}
+	close(ch)
+	return ch, nil
+}
+
+// loadReplayClient builds a client that replays the transcript at path.
+func loadReplayClient(path string) (*llm.Client, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+	t, err := agent.ImportTranscript(f)
+	if err != nil {
+		return nil, err
+	}
+	return llm.NewClient(llm.WithProvider("replay", NewReplayAdapter(t.Responses()))), nil
+}
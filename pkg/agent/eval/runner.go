@@ -0,0 +1,291 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/agent"
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// Runner executes scenarios, each in a fresh temporary working directory.
+type Runner struct {
+	// Client serves scenarios without a recording. It may be nil if every
+	// scenario is replayed.
+	Client *llm.Client
+	// Profile selects the model, tools, and system prompt.
+	Profile *agent.ProviderProfile
+	// Config is the base session configuration; a scenario's MaxTurns
+	// overrides Config.MaxTurns.
+	Config agent.SessionConfig
+	// RecordDir, if set, receives a <name>.transcript.json recording of
+	// each live run, usable as a scenario's Recording.
+	RecordDir string
+	// KeepWorkDirs leaves working directories in place for inspection.
+	KeepWorkDirs bool
+}
+
+// Result is the outcome of one scenario.
+type Result struct {
+	Scenario  string            `json:"scenario"`
+	Passed    bool              `json:"passed"`
+	Failures  []string          `json:"failures,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Output    string            `json:"output"`
+	ToolCalls []string          `json:"tool_calls,omitempty"`
+	Usage     agent.UsageReport `json:"usage"`
+	Duration  time.Duration     `json:"duration"`
+	WorkDir   string            `json:"work_dir,omitempty"`
+	Replayed  bool              `json:"replayed,omitempty"`
+}
+
+// Report summarizes a batch of scenario runs.
+type Report struct {
+	Results  []Result          `json:"results"`
+	Passed   int               `json:"passed"`
+	Failed   int               `json:"failed"`
+	Usage    agent.UsageReport `json:"usage"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// RunAll runs the scenarios in order and summarizes them.
+func (r *Runner) RunAll(ctx context.Context, scenarios []*Scenario) Report {
+	start := time.Now()
+	var rep Report
+	for _, s := range scenarios {
+		res := r.Run(ctx, s)
+		rep.Results = append(rep.Results, res)
+		if res.Passed {
+			rep.Passed++
+		} else {
+			rep.Failed++
+		}
+		rep.Usage.Calls += res.Usage.Calls
+		rep.Usage.Total = rep.Usage.Total.Add(res.Usage.Total)
+		rep.Usage.CostUSD += res.Usage.CostUSD
+	}
+	rep.Duration = time.Since(start)
+	return rep
+}
+
+// Run executes one scenario and evaluates its assertions. Errors from the
+// agent loop fail the scenario but assertions are still checked.
+func (r *Runner) Run(ctx context.Context, s *Scenario) Result {
+	start := time.Now()
+	res := Result{Scenario: s.Name}
+
+	fail := func(err error) Result {
+		res.Error = err.Error()
+		res.Duration = time.Since(start)
+		return res
+	}
+
+	client := r.Client
+	if s.Recording != "" {
+		replay, err := loadReplayClient(s.Recording)
+		if err != nil {
+			return fail(err)
+		}
+		client, res.Replayed = replay, true
+	}
+	if client == nil {
+		return fail(errors.New("no LLM client configured and scenario has no recording"))
+	}
+	if r.Profile == nil {
+		return fail(errors.New("runner has no profile"))
+	}
+
+	dir, err := os.MkdirTemp("", "attractor-eval-")
+	if err != nil {
+		return fail(err)
+	}
+	if r.KeepWorkDirs {
+		res.WorkDir = dir
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	if err := writeFiles(dir, s.Files); err != nil {
+		return fail(err)
+	}
+
+	config := r.Config
+	if s.MaxTurns > 0 {
+		config.MaxTurns = s.MaxTurns
+	}
+	session := agent.NewSession(client, r.Profile, env.NewLocalEnvironment(dir), config)
+	defer session.Close()
+
+	session.EventEmitter.On(func(e agent.Event) {
+		if e.Type != agent.EventToolCallStarted {
+			return
+		}
+		if name, ok := e.Data["tool_name"].(string); ok {
+			res.ToolCalls = append(res.ToolCalls, name)
+		}
+	})
+
+	for _, input := range append([]string{s.Prompt}, s.FollowUps...) {
+		if err = session.Submit(ctx, input); err != nil {
+			res.Error = err.Error()
+			break
+		}
+		if session.State == agent.StateTurnLimitReached {
+			res.Error = fmt.Sprintf("agent reached its limit of %d turns", config.MaxTurns)
+			break
+		}
+	}
+	res.Output = lastAssistantContent(session.History)
+	res.Usage = session.Usage()
+
+	if r.RecordDir != "" && !res.Replayed {
+		if err := record(session, filepath.Join(r.RecordDir, s.Name+TranscriptSuffix)); err != nil {
+			res.Failures = append(res.Failures, fmt.Sprintf("record transcript: %v", err))
+		}
+	}
+
+	for _, a := range s.Assertions {
+		if msg := check(a, dir, res.Output, res.ToolCalls); msg != "" {
+			res.Failures = append(res.Failures, msg)
+		}
+	}
+	res.Passed = res.Error == "" && len(res.Failures) == 0
+	res.Duration = time.Since(start)
+	return res
+}
+
+// check evaluates one assertion and returns a failure message, or "".
+func check(a Assertion, dir, output string, toolCalls []string) string {
+	switch a.Type {
+	case AssertOutputContains:
+		if !strings.Contains(output, a.Value) {
+			return fmt.Sprintf("output does not contain %q", a.Value)
+		}
+		return ""
+	case AssertOutputMatches:
+		if !regexp.MustCompile(a.Value).MatchString(output) {
+			return fmt.Sprintf("output does not match /%s/", a.Value)
+		}
+		return ""
+	case AssertToolCalled, AssertToolNotCalled:
+		called := false
+		for _, name := range toolCalls {
+			if name == a.Value {
+				called = true
+				break
+			}
+		}
+		if a.Type == AssertToolCalled && !called {
+			return fmt.Sprintf("tool %s was not called", a.Value)
+		}
+		if a.Type == AssertToolNotCalled && called {
+			return fmt.Sprintf("tool %s was called", a.Value)
+		}
+		return ""
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(a.Path)))
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Sprintf("%s: %v", a.Path, err)
+	}
+	switch a.Type {
+	case AssertFileAbsent:
+		if exists {
+			return fmt.Sprintf("%s exists", a.Path)
+		}
+		return ""
+	case AssertFileNotContains:
+		if exists && strings.Contains(string(data), a.Value) {
+			return fmt.Sprintf("%s contains %q", a.Path, a.Value)
+		}
+		return ""
+	}
+	if !exists {
+		return fmt.Sprintf("%s does not exist", a.Path)
+	}
+	content := string(data)
+	switch a.Type {
+	case AssertFileEquals:
+		if content != a.Value {
+			return fmt.Sprintf("%s content differs from expected", a.Path)
+		}
+	case AssertFileContains:
+		if !strings.Contains(content, a.Value) {
+			return fmt.Sprintf("%s does not contain %q", a.Path, a.Value)
+		}
+	case AssertFileMatches:
+		if !regexp.MustCompile(a.Value).MatchString(content) {
+			return fmt.Sprintf("%s does not match /%s/", a.Path, a.Value)
+		}
+	}
+	return ""
+}
+
+func writeFiles(dir string, files map[string]string) error {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lastAssistantContent(history []agent.Turn) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if at, ok := history[i].(*agent.AssistantTurn); ok && at.Content != "" {
+			return at.Content
+		}
+	}
+	return ""
+}
+
+func record(session *agent.Session, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := session.Export(f, agent.ExportOptions{Format: agent.ExportJSON}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// String renders the report as one line per scenario plus a total.
+func (rep Report) String() string {
+	var b strings.Builder
+	for _, res := range rep.Results {
+		status := "PASS"
+		if !res.Passed {
+			status = "FAIL"
+		}
+		mode := ""
+		if res.Replayed {
+			mode = " (replay)"
+		}
+		fmt.Fprintf(&b, "%s  %s%s  %s\n", status, res.Scenario, mode, res.Duration.Round(time.Millisecond))
+		if res.Error != "" {
+			fmt.Fprintf(&b, "      error: %s\n", res.Error)
+		}
+		for _, f := range res.Failures {
+			fmt.Fprintf(&b, "      - %s\n", f)
+		}
+	}
+	fmt.Fprintf(&b, "\n%d passed, %d failed in %s; %s\n",
+		rep.Passed, rep.Failed, rep.Duration.Round(time.Millisecond), rep.Usage)
+	return b.String()
+}
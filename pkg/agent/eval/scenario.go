@@ -0,0 +1,160 @@
+// Package eval runs coding agent scenarios and checks their outcomes, for
+// regression testing agent behavior against live or recorded providers.
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Assertion types.
+const (
+	AssertFileExists      = "file_exists"
+	AssertFileAbsent      = "file_absent"
+	AssertFileEquals      = "file_equals"
+	AssertFileContains    = "file_contains"
+	AssertFileNotContains = "file_not_contains"
+	AssertFileMatches     = "file_matches"
+	AssertOutputContains  = "output_contains"
+	AssertOutputMatches   = "output_matches"
+	AssertToolCalled      = "tool_called"
+	AssertToolNotCalled   = "tool_not_called"
+)
+
+// TranscriptSuffix is the file name suffix of recordings written by
+// Runner.RecordDir.
+const TranscriptSuffix = ".transcript.json"
+
+// Scenario is one agent task and the checks applied after it runs.
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Files are written to a fresh working directory before the run, keyed
+	// by slash-separated relative path.
+	Files  map[string]string `json:"files,omitempty"`
+	Prompt string            `json:"prompt"`
+	// FollowUps are submitted in order after Prompt completes.
+	FollowUps []string `json:"follow_ups,omitempty"`
+	MaxTurns  int      `json:"max_turns,omitempty"`
+	// Recording is a transcript exported by agent.Session.Export. When set,
+	// the run replays its assistant turns instead of calling a provider.
+	// Relative paths are resolved against the scenario file's directory.
+	Recording  string      `json:"recording,omitempty"`
+	Assertions []Assertion `json:"assertions"`
+}
+
+// Assertion is a single check on the final files, the agent's last response,
+// or the tools it called. Path names a file for file_* types; Value is the
+// expected content, substring, regular expression, or tool name.
+type Assertion struct {
+	Type  string `json:"type"`
+	Path  string `json:"path,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Validate checks the scenario for missing fields and malformed assertions.
+func (s *Scenario) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("scenario has no name")
+	}
+	if strings.TrimSpace(s.Prompt) == "" {
+		return fmt.Errorf("scenario %s: prompt is required", s.Name)
+	}
+	for path := range s.Files {
+		clean := filepath.Clean(filepath.FromSlash(path))
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("scenario %s: file %q must be a relative path inside the working directory", s.Name, path)
+		}
+	}
+	for i, a := range s.Assertions {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("scenario %s: assertion %d: %w", s.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+func (a Assertion) validate() error {
+	switch a.Type {
+	case AssertFileExists, AssertFileAbsent, AssertFileEquals, AssertFileContains, AssertFileNotContains, AssertFileMatches:
+		if a.Path == "" {
+			return fmt.Errorf("%s requires path", a.Type)
+		}
+	case AssertOutputContains, AssertOutputMatches, AssertToolCalled, AssertToolNotCalled:
+		if a.Value == "" {
+			return fmt.Errorf("%s requires value", a.Type)
+		}
+	default:
+		return fmt.Errorf("unknown assertion type %q", a.Type)
+	}
+	if a.Type == AssertFileMatches || a.Type == AssertOutputMatches {
+		if _, err := regexp.Compile(a.Value); err != nil {
+			return fmt.Errorf("%s: %w", a.Type, err)
+		}
+	}
+	return nil
+}
+
+// LoadScenario reads a scenario from a JSON file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if s.Recording != "" && !filepath.IsAbs(s.Recording) {
+		s.Recording = filepath.Join(filepath.Dir(path), s.Recording)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// LoadScenarios loads each path, expanding directories to the *.json files
+// they contain (sorted by name). Recordings named *.transcript.json are
+// skipped.
+func LoadScenarios(paths ...string) ([]*Scenario, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if !strings.HasSuffix(m, TranscriptSuffix) {
+				files = append(files, m)
+			}
+		}
+	}
+
+	var scenarios []*Scenario
+	for _, f := range files {
+		s, err := LoadScenario(f)
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, s)
+	}
+	return scenarios, nil
+}
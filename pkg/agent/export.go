@@ -0,0 +1,305 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// TranscriptVersion is the current version of the JSON transcript format.
+const TranscriptVersion = 1
+
+// ExportFormat selects the output of Session.Export.
+type ExportFormat string
+
+const (
+	ExportJSON     ExportFormat = "json"
+	ExportMarkdown ExportFormat = "markdown"
+)
+
+// ExportOptions configures Session.Export.
+type ExportOptions struct {
+	Format ExportFormat // default ExportJSON
+	// Redact, if set, is applied to every message, tool argument, and tool
+	// output before it is written, e.g. secrets.Store.Redact.
+	Redact func(string) string
+	// OmitToolOutput replaces tool results with their size, for sharing
+	// transcripts without file contents or command output.
+	OmitToolOutput bool
+}
+
+// Transcript is the serializable form of a session's conversation.
+type Transcript struct {
+	Version    int              `json:"version"`
+	SessionID  string           `json:"session_id"`
+	Provider   string           `json:"provider,omitempty"`
+	Model      string           `json:"model,omitempty"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Turns      []TranscriptTurn `json:"turns"`
+	Todos      []TodoItem       `json:"todos,omitempty"`
+	Usage      UsageReport      `json:"usage"`
+}
+
+// TranscriptTurn is one history entry. Type is "user", "steering",
+// "assistant", or "tool_results"; only the fields for that type are set.
+type TranscriptTurn struct {
+	Type       string           `json:"type"`
+	Content    string           `json:"content,omitempty"`
+	ToolCalls  []llm.ToolCall   `json:"tool_calls,omitempty"`
+	Reasoning  string           `json:"reasoning,omitempty"`
+	Usage      *llm.Usage       `json:"usage,omitempty"`
+	ResponseID string           `json:"response_id,omitempty"`
+	Results    []llm.ToolResult `json:"results,omitempty"`
+	Timestamp  time.Time        `json:"timestamp"`
+}
+
+// Transcript captures the session's history, plan, and usage.
+func (s *Session) Transcript() *Transcript {
+	t := &Transcript{
+		Version:    TranscriptVersion,
+		SessionID:  s.ID,
+		ExportedAt: time.Now(),
+		Todos:      s.Todos(),
+		Usage:      s.Usage(),
+	}
+	if s.ProviderProfile != nil {
+		t.Provider, t.Model = s.ProviderProfile.Provider, s.ProviderProfile.Model
+	}
+	for _, turn := range s.History {
+		switch v := turn.(type) {
+		case *UserTurn:
+			t.Turns = append(t.Turns, TranscriptTurn{Type: "user", Content: v.Content, Timestamp: v.Timestamp})
+		case *SteeringTurn:
+			t.Turns = append(t.Turns, TranscriptTurn{Type: "steering", Content: v.Content, Timestamp: v.Timestamp})
+		case *AssistantTurn:
+			usage := v.Usage
+			t.Turns = append(t.Turns, TranscriptTurn{
+				Type: "assistant", Content: v.Content, ToolCalls: v.ToolCalls, Reasoning: v.Reasoning,
+				Usage: &usage, ResponseID: v.ResponseID, Timestamp: v.Timestamp,
+			})
+		case *ToolResultsTurn:
+			t.Turns = append(t.Turns, TranscriptTurn{Type: "tool_results", Results: v.Results, Timestamp: v.Timestamp})
+		}
+	}
+	return t
+}
+
+// Export writes the session transcript to w as JSON or markdown.
+func (s *Session) Export(w io.Writer, opts ExportOptions) error {
+	t := s.Transcript().sanitize(opts)
+	switch opts.Format {
+	case "", ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	case ExportMarkdown:
+		_, err := io.WriteString(w, t.Markdown())
+		return err
+	default:
+		return fmt.Errorf("unknown export format %q", opts.Format)
+	}
+}
+
+// sanitize returns a copy of t with redaction and output omission applied.
+func (t *Transcript) sanitize(opts ExportOptions) *Transcript {
+	redact := opts.Redact
+	if redact == nil {
+		redact = func(s string) string { return s }
+	}
+	out := *t
+	out.Turns = make([]TranscriptTurn, len(t.Turns))
+	for i, turn := range t.Turns {
+		turn.Content = redact(turn.Content)
+		turn.Reasoning = redact(turn.Reasoning)
+		if turn.ToolCalls != nil {
+			calls := make([]llm.ToolCall, len(turn.ToolCalls))
+			for j, tc := range turn.ToolCalls {
+				tc.Arguments = redactJSON(tc.Arguments, redact)
+				calls[j] = tc
+			}
+			turn.ToolCalls = calls
+		}
+		if turn.Results != nil {
+			results := make([]llm.ToolResult, len(turn.Results))
+			for j, r := range turn.Results {
+				if opts.OmitToolOutput {
+					r.Content = fmt.Sprintf("[%d bytes omitted]", len(r.Content))
+				} else {
+					r.Content = redact(r.Content)
+				}
+				results[j] = r
+			}
+			turn.Results = results
+		}
+		out.Turns[i] = turn
+	}
+	if t.Todos != nil {
+		out.Todos = make([]TodoItem, len(t.Todos))
+		for i, item := range t.Todos {
+			item.Content = redact(item.Content)
+			out.Todos[i] = item
+		}
+	}
+	return &out
+}
+
+// redactJSON applies redact to raw JSON text, falling back to a JSON string
+// if redaction breaks the document.
+func redactJSON(raw json.RawMessage, redact func(string) string) json.RawMessage {
+	if len(raw) == 0 {
+		return raw
+	}
+	redacted := redact(string(raw))
+	if json.Valid([]byte(redacted)) {
+		return json.RawMessage(redacted)
+	}
+	data, _ := json.Marshal(redacted)
+	return data
+}
+
+// Markdown renders the transcript as a human-readable document.
+func (t *Transcript) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Session %s\n\n", t.SessionID)
+	if t.Model != "" {
+		fmt.Fprintf(&b, "- Model: %s (%s)\n", t.Model, t.Provider)
+	}
+	fmt.Fprintf(&b, "- Exported: %s\n", t.ExportedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Usage: %s\n", strings.ReplaceAll(t.Usage.String(), "\n", "\n  "))
+
+	for _, turn := range t.Turns {
+		switch turn.Type {
+		case "user":
+			fmt.Fprintf(&b, "\n## User\n\n%s\n", turn.Content)
+		case "steering":
+			fmt.Fprintf(&b, "\n## Steering\n\n%s\n", turn.Content)
+		case "assistant":
+			b.WriteString("\n## Assistant\n")
+			if turn.Reasoning != "" {
+				fmt.Fprintf(&b, "\n<details><summary>Reasoning</summary>\n\n%s\n\n</details>\n", turn.Reasoning)
+			}
+			if turn.Content != "" {
+				fmt.Fprintf(&b, "\n%s\n", turn.Content)
+			}
+			for _, tc := range turn.ToolCalls {
+				fmt.Fprintf(&b, "\n**Tool call** `%s` (%s)\n\n", tc.Name, tc.ID)
+				writeFenced(&b, "json", string(tc.Arguments))
+			}
+		case "tool_results":
+			b.WriteString("\n## Tool Results\n")
+			for _, r := range turn.Results {
+				status := ""
+				if r.IsError {
+					status = " (error)"
+				}
+				fmt.Fprintf(&b, "\n**%s**%s\n\n", r.ToolCallID, status)
+				writeFenced(&b, "", r.Content)
+			}
+		}
+	}
+
+	if len(t.Todos) > 0 {
+		fmt.Fprintf(&b, "\n## Plan\n\n%s\n", formatTodos(t.Todos))
+	}
+	return b.String()
+}
+
+// writeFenced writes content as a code block whose fence is longer than any
+// backtick run inside it.
+func writeFenced(b *strings.Builder, lang, content string) {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	fmt.Fprintf(b, "%s%s\n%s\n%s\n", fence, lang, strings.TrimSuffix(content, "\n"), fence)
+}
+
+// ImportTranscript reads a transcript written by Export in JSON format.
+func ImportTranscript(r io.Reader) (*Transcript, error) {
+	var t Transcript
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, fmt.Errorf("decode transcript: %w", err)
+	}
+	if t.Version > TranscriptVersion {
+		return nil, fmt.Errorf("transcript version %d is newer than supported version %d", t.Version, TranscriptVersion)
+	}
+	for i, turn := range t.Turns {
+		switch turn.Type {
+		case "user", "steering", "assistant", "tool_results":
+		default:
+			return nil, fmt.Errorf("turn %d: unknown type %q", i, turn.Type)
+		}
+	}
+	return &t, nil
+}
+
+// History converts the transcript back into session history.
+func (t *Transcript) History() []Turn {
+	history := make([]Turn, 0, len(t.Turns))
+	for _, turn := range t.Turns {
+		switch turn.Type {
+		case "user":
+			history = append(history, &UserTurn{Content: turn.Content, Timestamp: turn.Timestamp})
+		case "steering":
+			history = append(history, &SteeringTurn{Content: turn.Content, Timestamp: turn.Timestamp})
+		case "assistant":
+			at := &AssistantTurn{
+				Content: turn.Content, ToolCalls: turn.ToolCalls, Reasoning: turn.Reasoning,
+				ResponseID: turn.ResponseID, Timestamp: turn.Timestamp,
+			}
+			if turn.Usage != nil {
+				at.Usage = *turn.Usage
+			}
+			history = append(history, at)
+		case "tool_results":
+			history = append(history, &ToolResultsTurn{Results: turn.Results, Timestamp: turn.Timestamp})
+		}
+	}
+	return history
+}
+
+// Responses returns the assistant turns as LLM responses, in order, so a
+// recorded session can be replayed through a scripted adapter in tests.
+func (t *Transcript) Responses() []*llm.Response {
+	var responses []*llm.Response
+	for _, turn := range t.Turns {
+		if turn.Type != "assistant" {
+			continue
+		}
+		resp := &llm.Response{
+			ID:           turn.ResponseID,
+			Model:        t.Model,
+			Content:      turn.Content,
+			ToolCalls:    turn.ToolCalls,
+			Reasoning:    turn.Reasoning,
+			FinishReason: llm.FinishReasonStop,
+			CreatedAt:    turn.Timestamp,
+		}
+		if len(turn.ToolCalls) > 0 {
+			resp.FinishReason = llm.FinishReasonToolCalls
+		}
+		if turn.Usage != nil {
+			resp.Usage = *turn.Usage
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+// Restore replaces the session's history, plan, and usage totals with those
+// of t, so a conversation can be continued from an imported transcript.
+func (s *Session) Restore(t *Transcript) {
+	history := t.History()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.History = history
+	s.todos = append([]TodoItem(nil), t.Todos...)
+	s.usage = make(map[string]*ModelUsage, len(t.Usage.ByModel))
+	for _, mu := range t.Usage.ByModel {
+		s.usage[mu.Model] = &mu
+	}
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// Hooks are optional callbacks around each LLM and tool call, for policy
+// layers and custom telemetry. Nil hooks are skipped. Hooks run on the
+// session's goroutine, so they must not call back into Submit.
+type Hooks struct {
+	// BeforeLLMCall may modify req in place. An error aborts Submit.
+	BeforeLLMCall func(ctx context.Context, req *llm.Request) error
+
+	// AfterLLMCall may inspect or modify resp before it is recorded in the
+	// history. An error aborts Submit.
+	AfterLLMCall func(ctx context.Context, req *llm.Request, resp *llm.Response) error
+
+	// BeforeToolCall runs before a tool executes. Returning an error vetoes the
+	// call; the model receives the error as the tool result. Returning a
+	// non-nil result skips execution and sends that result instead.
+	BeforeToolCall func(ctx context.Context, call llm.ToolCall) (*llm.ToolResult, error)
+
+	// AfterToolCall may modify the result before it is sent to the model.
+	AfterToolCall func(ctx context.Context, call llm.ToolCall, result *llm.ToolResult)
+}
+
+// callLLM sends req through the LLM hooks and the client.
+func (s *Session) callLLM(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	if s.Hooks.BeforeLLMCall != nil {
+		if err := s.Hooks.BeforeLLMCall(ctx, req); err != nil {
+			return nil, fmt.Errorf("before LLM call hook: %w", err)
+		}
+	}
+	resp, err := s.LLMClient.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if s.Hooks.AfterLLMCall != nil {
+		if err := s.Hooks.AfterLLMCall(ctx, req, resp); err != nil {
+			return nil, fmt.Errorf("after LLM call hook: %w", err)
+		}
+	}
+	return resp, nil
+}
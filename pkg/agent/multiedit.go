@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// multiEdit is one edit of a multi_edit call.
+type multiEdit struct {
+	Path       string `json:"path"`
+	OldString  string `json:"old_string"`
+	NewString  string `json:"new_string"`
+	ReplaceAll bool   `json:"replace_all"`
+	Regex      bool   `json:"regex"`
+}
+
+// editedFile is a file's content before and after a multi_edit call.
+type editedFile struct {
+	path         string
+	before       []byte
+	after        string
+	replacements int
+}
+
+// executeMultiEdit runs the multi_edit tool. Every edit is applied in memory
+// first, so a failing edit leaves all files untouched; with dry_run the
+// resulting diff is returned instead of written.
+func (s *Session) executeMultiEdit(arguments json.RawMessage) (string, error) {
+	var params struct {
+		Edits  []multiEdit `json:"edits"`
+		DryRun bool        `json:"dry_run"`
+	}
+	if err := json.Unmarshal(arguments, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if len(params.Edits) == 0 {
+		return "", errors.New("no edits given")
+	}
+	fr, canRead := s.ExecutionEnv.(FileReader)
+	fw, canWrite := s.ExecutionEnv.(FileWriter)
+	if !canRead || !canWrite {
+		return "", errors.New("execution environment does not support multi_edit")
+	}
+
+	var files []*editedFile
+	byPath := make(map[string]*editedFile)
+	for i, edit := range params.Edits {
+		// Spellings of the same file, such as "a.go" and "./a.go", share
+		// one entry so their edits apply in order to the same content.
+		path := filepath.Clean(edit.Path)
+		f := byPath[path]
+		if f == nil {
+			if err := s.checkStalePath(path); err != nil {
+				return "", err
+			}
+			data, err := fr.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("edit %d: read %s: %w", i+1, path, err)
+			}
+			f = &editedFile{path: path, before: data, after: string(data)}
+			byPath[path] = f
+			files = append(files, f)
+		}
+		after, n, err := applyEdit(f.after, edit)
+		if err != nil {
+			return "", fmt.Errorf("edit %d (%s): %w", i+1, edit.Path, err)
+		}
+		f.after = after
+		f.replacements += n
+	}
+
+	total := 0
+	for _, f := range files {
+		total += f.replacements
+	}
+	if params.DryRun {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Dry run: %d replacement(s) in %d file(s) would be made; no files were changed.\n\n", total, len(files))
+		for _, f := range files {
+			diff, _, _ := unifiedDiff(f.path, f.before, []byte(f.after), true, true)
+			sb.WriteString(diff)
+		}
+		return sb.String(), nil
+	}
+
+	for i, f := range files {
+		s.snapshotPath(f.path)
+		if err := fw.WriteFile(f.path, []byte(f.after)); err != nil {
+			// Put back the files already written.
+			for _, done := range files[:i] {
+				fw.WriteFile(done.path, done.before)
+			}
+			return "", fmt.Errorf("write %s: %w", f.path, err)
+		}
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Made %d replacement(s) in %d file(s):", total, len(files))
+	for _, f := range files {
+		s.recordPathVersion(f.path)
+		fmt.Fprintf(&sb, "\n%s (%d)", f.path, f.replacements)
+	}
+	return sb.String(), nil
+}
+
+// applyEdit applies one edit to content and returns the new content and the
+// number of replacements. Without replace_all the old string or pattern must
+// match exactly once.
+func applyEdit(content string, edit multiEdit) (string, int, error) {
+	if edit.OldString == "" {
+		return "", 0, errors.New("old_string is empty")
+	}
+	if !edit.Regex {
+		count := strings.Count(content, edit.OldString)
+		if err := checkMatchCount(count, edit.ReplaceAll, "old_string"); err != nil {
+			return "", 0, err
+		}
+		return strings.ReplaceAll(content, edit.OldString, edit.NewString), count, nil
+	}
+
+	re, err := regexp.Compile(edit.OldString)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid regex: %w", err)
+	}
+	count := len(re.FindAllStringIndex(content, -1))
+	if err := checkMatchCount(count, edit.ReplaceAll, "pattern"); err != nil {
+		return "", 0, err
+	}
+	return re.ReplaceAllString(content, edit.NewString), count, nil
+}
+
+func checkMatchCount(count int, replaceAll bool, what string) error {
+	switch {
+	case count == 0:
+		return fmt.Errorf("%s not found in file", what)
+	case count > 1 && !replaceAll:
+		return fmt.Errorf("%s found %d times; must be unique unless replace_all is set", what, count)
+	}
+	return nil
+}
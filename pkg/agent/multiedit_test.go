@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+)
+
+func TestMultiEdit(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go": "package a\n\nfunc oldName() {}\n\nvar x = oldName\n",
+		"b.go": "package b\n\n// greet(\"hi\")\n// greet(\"yo\")\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := NewSession(nil, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), DefaultSessionConfig())
+	args := `{"edits": [
+		{"path": "a.go", "old_string": "oldName", "new_string": "newName", "replace_all": true},
+		{"path": "b.go", "old_string": "greet\\(\"(\\w+)\"\\)", "new_string": "say(\"$1!\")", "regex": true, "replace_all": true},
+		{"path": "a.go", "old_string": "package a", "new_string": "package a // edited"}
+	]`
+
+	preview, err := session.executeMultiEdit(json.RawMessage(args + `, "dry_run": true}`))
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if !strings.HasPrefix(preview, "Dry run: 5 replacement(s) in 2 file(s)") || !strings.Contains(preview, "+// say(\"yo!\")\n") {
+		t.Errorf("unexpected preview:\n%s", preview)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(data) != files["a.go"] {
+		t.Errorf("expected the dry run to leave a.go alone, got %q", data)
+	}
+
+	result, err := session.executeMultiEdit(json.RawMessage(args + `}`))
+	if err != nil {
+		t.Fatalf("multi_edit failed: %v", err)
+	}
+	if result != "Made 5 replacement(s) in 2 file(s):\na.go (3)\nb.go (2)" {
+		t.Errorf("unexpected result: %q", result)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "b.go")); string(data) != "package b\n\n// say(\"hi!\")\n// say(\"yo!\")\n" {
+		t.Errorf("unexpected b.go: %q", data)
+	}
+	if changes := session.Changes(); len(changes.Files) != 2 {
+		t.Errorf("expected both files in the change summary, got %+v", changes.Files)
+	}
+}
+
+func TestMultiEditAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "one one", "b.txt": "two"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := NewSession(nil, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), DefaultSessionConfig())
+
+	cases := map[string]string{
+		`{"edits": [{"path": "b.txt", "old_string": "two", "new_string": "2"}, {"path": "a.txt", "old_string": "one", "new_string": "1"}]}`:             "edit 2 (a.txt): old_string found 2 times",
+		`{"edits": [{"path": "b.txt", "old_string": "two", "new_string": "2"}, {"path": "a.txt", "old_string": "three", "new_string": "3"}]}`:           "edit 2 (a.txt): old_string not found",
+		`{"edits": [{"path": "b.txt", "old_string": "two", "new_string": "2"}, {"path": "a.txt", "old_string": "(", "new_string": "", "regex": true}]}`: "invalid regex",
+		`{"edits": [{"path": "missing.txt", "old_string": "x", "new_string": "y"}]}`:                                                                    "edit 1: read missing.txt",
+	}
+	for args, want := range cases {
+		if _, err := session.executeMultiEdit(json.RawMessage(args)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error containing %q, got %v", want, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "b.txt")); string(data) != "two" {
+		t.Errorf("expected b.txt to be untouched, got %q", data)
+	}
+}
+
+func TestMultiEditSamePathSpellings(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	session := NewSession(nil, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), DefaultSessionConfig())
+
+	result, err := session.executeMultiEdit(json.RawMessage(`{"edits": [
+		{"path": "a.txt", "old_string": "one", "new_string": "1"},
+		{"path": "./a.txt", "old_string": "1 two", "new_string": "1 2"}
+	]}`))
+	if err != nil {
+		t.Fatalf("multi_edit failed: %v", err)
+	}
+	if result != "Made 2 replacement(s) in 1 file(s):\na.txt (2)" {
+		t.Errorf("unexpected result: %q", result)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "1 2" {
+		t.Errorf("expected both edits applied in order, got %q", data)
+	}
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// droppedOutputNote replaces a tool output removed to fit the context window.
+const droppedOutputNote = "[Output removed to fit the context window. Run the tool again if you need it.]"
+
+// compactForContext recovers from a context-length error by replacing the
+// oldest tool outputs in the history with a short note until at least half of
+// the tool output bytes are gone, and emits EventContextCompacted describing
+// what was dropped. It reports false when there was nothing left to drop.
+func (s *Session) compactForContext(cause error) bool {
+	total := 0
+	for _, turn := range s.History {
+		if t, ok := turn.(*ToolResultsTurn); ok {
+			for _, r := range t.Results {
+				if r.Content != droppedOutputNote {
+					total += len(r.Content)
+				}
+			}
+		}
+	}
+
+	var dropped []string
+	removed := 0
+	for _, turn := range s.History {
+		t, ok := turn.(*ToolResultsTurn)
+		if !ok {
+			continue
+		}
+		for i, r := range t.Results {
+			if removed*2 >= total {
+				break
+			}
+			if r.Content == droppedOutputNote || len(r.Content) <= len(droppedOutputNote) {
+				continue
+			}
+			removed += len(r.Content)
+			dropped = append(dropped, r.ToolCallID)
+			t.Results[i].Content = droppedOutputNote
+		}
+	}
+	if len(dropped) == 0 {
+		return false
+	}
+
+	s.EventEmitter.Emit(Event{
+		Type:      EventContextCompacted,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"error":         cause.Error(),
+			"dropped_calls": dropped,
+			"bytes_removed": removed,
+			"summary":       fmt.Sprintf("dropped the output of %d tool call(s) (%d bytes)", len(dropped), removed),
+		},
+	})
+	return true
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TodoStatus is the state of a single plan item.
+type TodoStatus string
+
+const (
+	TodoPending    TodoStatus = "pending"
+	TodoInProgress TodoStatus = "in_progress"
+	TodoDone       TodoStatus = "done"
+)
+
+// TodoItem is one entry in the session's task plan.
+type TodoItem struct {
+	ID      string     `json:"id"`
+	Content string     `json:"content"`
+	Status  TodoStatus `json:"status"`
+}
+
+// isPlanTool reports whether a tool call is handled by the session's plan
+// state rather than the execution environment.
+func isPlanTool(name string) bool {
+	return name == "todo_write" || name == "todo_read"
+}
+
+// Todos returns a copy of the current task plan.
+func (s *Session) Todos() []TodoItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TodoItem(nil), s.todos...)
+}
+
+// executePlanTool runs todo_write or todo_read against the session's plan.
+func (s *Session) executePlanTool(name string, arguments json.RawMessage) (string, error) {
+	if name == "todo_read" {
+		return formatTodos(s.Todos()), nil
+	}
+
+	var params struct {
+		Todos []TodoItem `json:"todos"`
+	}
+	if err := json.Unmarshal(arguments, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	for i := range params.Todos {
+		item := &params.Todos[i]
+		item.Content = strings.TrimSpace(item.Content)
+		if item.Content == "" {
+			return "", fmt.Errorf("todo %d has no content", i+1)
+		}
+		switch item.Status {
+		case TodoPending, TodoInProgress, TodoDone:
+		case "":
+			item.Status = TodoPending
+		default:
+			return "", fmt.Errorf("todo %d has invalid status %q (want pending, in_progress, or done)", i+1, item.Status)
+		}
+		if item.ID == "" {
+			item.ID = strconv.Itoa(i + 1)
+		}
+	}
+
+	s.mu.Lock()
+	s.todos = params.Todos
+	s.mu.Unlock()
+
+	todos := s.Todos()
+	s.EventEmitter.Emit(Event{
+		Type:      EventPlanUpdated,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"todos": todos,
+		},
+	})
+	return formatTodos(todos), nil
+}
+
+// formatTodos renders the plan as a checklist for the model.
+func formatTodos(todos []TodoItem) string {
+	if len(todos) == 0 {
+		return "No tasks planned."
+	}
+	var b strings.Builder
+	done := 0
+	for _, item := range todos {
+		mark := " "
+		switch item.Status {
+		case TodoInProgress:
+			mark = "~"
+		case TodoDone:
+			mark = "x"
+			done++
+		}
+		fmt.Fprintf(&b, "[%s] %s. %s\n", mark, item.ID, item.Content)
+	}
+	fmt.Fprintf(&b, "%d/%d done", done, len(todos))
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+)
+
+// PostEditHook is a command run after the agent modifies a file, such as a
+// formatter or a quick lint.
+type PostEditHook struct {
+	// Command runs with the environment's bash tool. {path} is replaced by
+	// the modified file's path, quoted for the shell that runs it.
+	Command string `json:"command"`
+	// Pattern, if set, limits the hook to files whose base name matches this
+	// glob, e.g. "*.go".
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// matches reports whether the hook applies to path.
+func (h PostEditHook) matches(path string) bool {
+	if h.Pattern == "" {
+		return true
+	}
+	ok, _ := filepath.Match(h.Pattern, filepath.Base(path))
+	return ok
+}
+
+// editedPaths returns the files a successful tool call modified.
+func editedPaths(toolName string, arguments json.RawMessage) []string {
+	if isFileWriteTool(toolName) {
+		if path := toolPath(arguments); path != "" {
+			return []string{path}
+		}
+		return nil
+	}
+	if toolName != "multi_edit" {
+		return nil
+	}
+	var params struct {
+		Edits  []multiEdit `json:"edits"`
+		DryRun bool        `json:"dry_run"`
+	}
+	if json.Unmarshal(arguments, &params) != nil || params.DryRun {
+		return nil
+	}
+	var paths []string
+	for _, e := range params.Edits {
+		if !slices.Contains(paths, e.Path) {
+			paths = append(paths, e.Path)
+		}
+	}
+	return paths
+}
+
+// runPostEditHooks runs the configured hooks for each file the tool call
+// modified and returns their output, to be appended to the tool result.
+// Files a hook rewrites are recorded as seen so the next edit is not
+// rejected as stale.
+func (s *Session) runPostEditHooks(ctx context.Context, toolName string, arguments json.RawMessage) string {
+	if len(s.Config.PostEditHooks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, path := range editedPaths(toolName, arguments) {
+		ran := false
+		for _, h := range s.Config.PostEditHooks {
+			if !h.matches(path) {
+				continue
+			}
+			ran = true
+			quoted, err := s.quotePath(path)
+			if err != nil {
+				fmt.Fprintf(&sb, "\n\n[post-edit: %s]\nError: %v", h.Command, err)
+				continue
+			}
+			command := strings.ReplaceAll(h.Command, "{path}", quoted)
+			args, _ := json.Marshal(map[string]string{"command": command})
+			output, err := s.ExecutionEnv.Execute(ctx, "bash", args)
+			if err != nil {
+				output = "Error: " + err.Error()
+			}
+			if output = strings.TrimSpace(output); output != "" {
+				fmt.Fprintf(&sb, "\n\n[post-edit: %s]\n%s", command, output)
+			}
+		}
+		if ran {
+			s.recordPathVersion(path)
+		}
+	}
+	return sb.String()
+}
+
+// quotePath quotes path for the shell of the session's environment: the
+// configured shell of a local environment, or sh for others. A path made
+// only of characters no shell treats specially is left as is.
+func (s *Session) quotePath(path string) (string, error) {
+	if path != "" && strings.Trim(path, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./") == "" {
+		return path, nil
+	}
+	shell := env.Shell{Name: "sh"}
+	if local, ok := s.ExecutionEnv.(*env.LocalEnvironment); ok {
+		shell = local.Shell
+	}
+	return shell.Quote(path)
+}
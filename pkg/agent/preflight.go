@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// preflightWindowRatio is how full the context window must have been on the
+// previous turn before the next prompt is counted ahead of sending it.
+const preflightWindowRatio = 0.9
+
+// preflight counts the input tokens of req before it is sent, when a
+// guardrail depends on them: a MaxTotalTokens budget, or a previous prompt
+// that filled most of the context window. If the prompt would overflow the
+// context window, the history is compacted as after a context-length error
+// and req is rebuilt, saving a failed call. It returns a BudgetExceededError
+// if the prompt would take the session past MaxTotalTokens; the first prompt
+// of a session is always sent.
+func (s *Session) preflight(ctx context.Context, req *llm.Request) (*llm.Request, *BudgetExceededError) {
+	window := s.contextWindow()
+	nearWindow := window > 0 && float64(s.lastInputTokens()) >= preflightWindowRatio*float64(window)
+	if s.Config.MaxTotalTokens <= 0 && !nearWindow {
+		return req, nil
+	}
+	count, err := s.LLMClient.CountTokens(ctx, req)
+	if err != nil {
+		return req, nil
+	}
+
+	if nearWindow && count.InputTokens > window {
+		cause := fmt.Errorf("prompt of %d tokens exceeds the %d-token context window", count.InputTokens, window)
+		if s.compactForContext(cause) {
+			req = s.buildRequest()
+			if count, err = s.LLMClient.CountTokens(ctx, req); err != nil {
+				return req, nil
+			}
+		}
+	}
+
+	if s.Config.MaxTotalTokens > 0 {
+		usage := s.Usage()
+		used := usage.Total.InputTokens + usage.Total.OutputTokens
+		if used > 0 && used+count.InputTokens > s.Config.MaxTotalTokens {
+			return req, &BudgetExceededError{Limit: "tokens", Used: float64(used + count.InputTokens), Max: float64(s.Config.MaxTotalTokens)}
+		}
+	}
+	return req, nil
+}
+
+// lastInputTokens returns the prompt size of the latest LLM response.
+func (s *Session) lastInputTokens() int {
+	for i := len(s.History) - 1; i >= 0; i-- {
+		if t, ok := s.History[i].(*AssistantTurn); ok {
+			return t.Usage.InputTokens
+		}
+	}
+	return 0
+}
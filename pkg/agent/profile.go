@@ -33,45 +33,18 @@ func (p *ProviderProfile) RegisterTool(tool llm.Tool) {
 	p.Tools = append(p.Tools, tool)
 }
 
-// BuildSystemPrompt generates the full system prompt including environment context.
+// BuildSystemPrompt generates the full system prompt including environment
+// context, using DefaultPromptTemplate. Use a PromptBuilder to customize the
+// template or cache the result.
 func BuildSystemPrompt(profile *ProviderProfile, workDir string, userInstructions string) string {
-	var parts []string
-
-	// 1. Provider-specific base instructions
-	parts = append(parts, profile.SystemPrompt)
-
-	// 2. Environment context
-	envContext := buildEnvironmentContext(workDir, profile.Model)
-	parts = append(parts, envContext)
-
-	// 3. Tool descriptions
-	if len(profile.Tools) > 0 {
-		toolDesc := buildToolDescriptions(profile.Tools)
-		parts = append(parts, toolDesc)
-	}
-
-	// 4. Project documentation
-	projectDocs := discoverProjectDocs(workDir, profile.Provider)
-	if projectDocs != "" {
-		parts = append(parts, projectDocs)
-	}
-
-	// 5. User instruction overrides (highest priority)
-	if userInstructions != "" {
-		parts = append(parts, "# User Instructions\n"+userInstructions)
-	}
-
-	return strings.Join(parts, "\n\n")
+	b := NewPromptBuilder(workDir)
+	b.UserInstructions = userInstructions
+	prompt, _ := b.Build(profile) // the default template cannot fail
+	return prompt
 }
 
 func buildEnvironmentContext(workDir string, model string) string {
-	gitBranch := ""
-	if data, err := os.ReadFile(filepath.Join(workDir, ".git", "HEAD")); err == nil {
-		ref := strings.TrimSpace(string(data))
-		if strings.HasPrefix(ref, "ref: refs/heads/") {
-			gitBranch = strings.TrimPrefix(ref, "ref: refs/heads/")
-		}
-	}
+	branch := gitBranch(workDir)
 
 	ctx := fmt.Sprintf(`# Environment
 - Platform: %s/%s
@@ -84,8 +57,8 @@ func buildEnvironmentContext(workDir string, model string) string {
 		model,
 	)
 
-	if gitBranch != "" {
-		ctx += "\n- Git branch: " + gitBranch
+	if branch != "" {
+		ctx += "\n- Git branch: " + branch
 	}
 
 	return ctx
@@ -180,6 +153,20 @@ Use bash for running tests, builds, and git operations.`,
 	}
 }
 
+// DefaultXAIProfile returns the default profile for xAI Grok models.
+func DefaultXAIProfile(model string) *ProviderProfile {
+	p := DefaultGeminiProfile(model)
+	p.Name, p.Provider = "xai", "xai"
+	return p
+}
+
+// DefaultMistralProfile returns the default profile for Mistral models.
+func DefaultMistralProfile(model string) *ProviderProfile {
+	p := DefaultGeminiProfile(model)
+	p.Name, p.Provider = "mistral", "mistral"
+	return p
+}
+
 // ApplyPatchTool returns the apply_patch tool definition (v4a format for OpenAI profile).
 func ApplyPatchTool() llm.Tool {
 	return llm.Tool{
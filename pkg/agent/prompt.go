@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultPromptTemplate reproduces BuildSystemPrompt: the profile's base
+// instructions, environment facts, tool docs, project docs, and user
+// instructions, separated by blank lines.
+const DefaultPromptTemplate = `{{.BaseInstructions}}
+
+{{.Environment}}
+{{- if .Tools}}
+
+{{.Tools}}
+{{- end}}
+{{- if .ProjectDocs}}
+
+{{.ProjectDocs}}
+{{- end}}
+{{- if .UserInstructions}}
+
+# User Instructions
+{{.UserInstructions}}
+{{- end}}`
+
+// PromptData is the value a prompt template is executed with. The
+// preformatted sections (Environment, Tools, ProjectDocs) are provided
+// alongside the raw facts so templates can either reuse or replace them.
+type PromptData struct {
+	BaseInstructions string // the profile's SystemPrompt
+	Environment      string // "# Environment" section
+	Tools            string // "# Available Tools" section, empty without tools
+	ProjectDocs      string // AGENTS.md and provider-specific files, may be empty
+	UserInstructions string
+
+	Provider  string
+	Model     string
+	WorkDir   string
+	Platform  string // GOOS/GOARCH
+	Date      string // YYYY-MM-DD
+	GitBranch string
+}
+
+// PromptBuilder assembles system prompts from a template and the project
+// instructions found in WorkDir. Results are cached per provider and model
+// until Refresh is called, so instruction files are read once per session
+// rather than once per LLM call.
+type PromptBuilder struct {
+	WorkDir          string
+	UserInstructions string
+
+	mu    sync.Mutex
+	tmpl  *template.Template
+	cache map[string]string
+}
+
+// NewPromptBuilder returns a builder for workDir using DefaultPromptTemplate.
+// An empty workDir means the current directory.
+func NewPromptBuilder(workDir string) *PromptBuilder {
+	if workDir == "" {
+		workDir, _ = os.Getwd()
+	}
+	return &PromptBuilder{
+		WorkDir: workDir,
+		tmpl:    template.Must(template.New("system_prompt").Parse(DefaultPromptTemplate)),
+	}
+}
+
+// SetTemplate replaces the template with text, a text/template executed with
+// PromptData. It clears the cache.
+func (b *PromptBuilder) SetTemplate(text string) error {
+	tmpl, err := template.New("system_prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("parse prompt template: %w", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tmpl = tmpl
+	b.cache = nil
+	return nil
+}
+
+// LoadTemplate reads a template file and installs it with SetTemplate.
+func (b *PromptBuilder) LoadTemplate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read prompt template: %w", err)
+	}
+	return b.SetTemplate(string(data))
+}
+
+// Refresh discards cached prompts so the next Build re-reads project
+// instruction files and environment facts.
+func (b *PromptBuilder) Refresh() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cache = nil
+}
+
+// Build returns the system prompt for profile.
+func (b *PromptBuilder) Build(profile *ProviderProfile) (string, error) {
+	key := profile.Provider + "\x00" + profile.Model + "\x00" + profile.SystemPrompt
+	for _, t := range profile.Tools {
+		key += "\x00" + t.Name
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if prompt, ok := b.cache[key]; ok {
+		return prompt, nil
+	}
+
+	var sb strings.Builder
+	if err := b.tmpl.Execute(&sb, b.promptData(profile)); err != nil {
+		return "", fmt.Errorf("execute prompt template: %w", err)
+	}
+	prompt := sb.String()
+	if b.cache == nil {
+		b.cache = make(map[string]string)
+	}
+	b.cache[key] = prompt
+	return prompt, nil
+}
+
+func (b *PromptBuilder) promptData(profile *ProviderProfile) PromptData {
+	data := PromptData{
+		BaseInstructions: profile.SystemPrompt,
+		Environment:      buildEnvironmentContext(b.WorkDir, profile.Model),
+		ProjectDocs:      discoverProjectDocs(b.WorkDir, profile.Provider),
+		UserInstructions: b.UserInstructions,
+		Provider:         profile.Provider,
+		Model:            profile.Model,
+		WorkDir:          b.WorkDir,
+		Platform:         runtime.GOOS + "/" + runtime.GOARCH,
+		Date:             time.Now().Format("2006-01-02"),
+		GitBranch:        gitBranch(b.WorkDir),
+	}
+	if len(profile.Tools) > 0 {
+		data.Tools = buildToolDescriptions(profile.Tools)
+	}
+	return data
+}
+
+// gitBranch returns the checked-out branch of the repository at workDir, or
+// "" if it is not a git checkout or HEAD is detached.
+func gitBranch(workDir string) string {
+	data, err := os.ReadFile(filepath.Join(workDir, ".git", "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(ref, "ref: refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(ref, "ref: refs/heads/")
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// FileWriter is implemented by execution environments that can restore files
+// directly. Together with FileReader it enables Rollback and the
+// revert_changes tool.
+type FileWriter interface {
+	WriteFile(path string, data []byte) error
+	RemoveFile(path string) error
+}
+
+// Rollback restores every file the agent wrote or edited to its content before
+// the session first touched it, and deletes files the agent created. Either
+// all files are restored or, if any restore fails, none are.
+func (s *Session) Rollback() error {
+	_, err := s.revertChanges(nil)
+	return err
+}
+
+// revertChanges rolls back the tracked files named in paths, or all tracked
+// files when paths is empty, and returns the paths that were restored.
+func (s *Session) revertChanges(paths []string) ([]string, error) {
+	fr, canRead := s.ExecutionEnv.(FileReader)
+	fw, canWrite := s.ExecutionEnv.(FileWriter)
+	if !canRead || !canWrite {
+		return nil, errors.New("execution environment does not support reverting files")
+	}
+
+	s.mu.Lock()
+	reverted, err := s.revertLocked(fr, fw, paths)
+	s.mu.Unlock()
+	if err != nil || len(reverted) == 0 {
+		return reverted, err
+	}
+	s.EventEmitter.Emit(Event{
+		Type:      EventChangesReverted,
+		Timestamp: time.Now(),
+		Data:      map[string]interface{}{"paths": reverted},
+	})
+	return reverted, nil
+}
+
+// revertLocked does the work of revertChanges. s.mu must be held.
+func (s *Session) revertLocked(fr FileReader, fw FileWriter, paths []string) ([]string, error) {
+	var targets []*fileSnapshot
+	if len(paths) == 0 {
+		targets = append(targets, s.snapshots...)
+	} else {
+		for _, p := range paths {
+			snap := s.findSnapshot(p)
+			if snap == nil {
+				return nil, fmt.Errorf("%s was not modified in this session", p)
+			}
+			targets = append(targets, snap)
+		}
+	}
+	for _, snap := range targets {
+		if !snap.known {
+			return nil, fmt.Errorf("no snapshot of %s to restore", snap.path)
+		}
+	}
+
+	// Capture the current content first so a failed restore can put back
+	// the files already reverted.
+	current := make([]*fileSnapshot, len(targets))
+	for i, snap := range targets {
+		cur := &fileSnapshot{path: snap.path, known: true}
+		data, err := fr.ReadFile(snap.path)
+		switch {
+		case err == nil:
+			cur.content, cur.existed = data, true
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, fmt.Errorf("read %s: %w", snap.path, err)
+		}
+		current[i] = cur
+	}
+
+	for i, snap := range targets {
+		if err := restoreSnapshot(fw, snap); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				restoreSnapshot(fw, current[j])
+			}
+			return nil, fmt.Errorf("revert %s: %w", snap.path, err)
+		}
+	}
+
+	reverted := make([]string, len(targets))
+	for i, snap := range targets {
+		reverted[i] = snap.path
+		s.removeSnapshot(snap)
+	}
+	return reverted, nil
+}
+
+// restoreSnapshot writes snap's content back, or removes the file if it did
+// not exist when the snapshot was taken.
+func restoreSnapshot(fw FileWriter, snap *fileSnapshot) error {
+	if !snap.existed {
+		if err := fw.RemoveFile(snap.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return fw.WriteFile(snap.path, snap.content)
+}
+
+// findSnapshot returns the snapshot for path, or nil. s.mu must be held.
+func (s *Session) findSnapshot(path string) *fileSnapshot {
+	for _, snap := range s.snapshots {
+		if snap.path == path {
+			return snap
+		}
+	}
+	return nil
+}
+
+// removeSnapshot stops tracking snap. s.mu must be held.
+func (s *Session) removeSnapshot(snap *fileSnapshot) {
+	for i, other := range s.snapshots {
+		if other == snap {
+			s.snapshots = append(s.snapshots[:i], s.snapshots[i+1:]...)
+			return
+		}
+	}
+}
+
+// executeRevertTool runs the revert_changes tool.
+func (s *Session) executeRevertTool(arguments json.RawMessage) (string, error) {
+	var params struct {
+		Paths []string `json:"paths"`
+	}
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &params); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	reverted, err := s.revertChanges(params.Paths)
+	if err != nil {
+		return "", err
+	}
+	if len(reverted) == 0 {
+		return "No changes to revert.", nil
+	}
+	return fmt.Sprintf("Reverted %d file(s):\n%s", len(reverted), strings.Join(reverted, "\n")), nil
+}
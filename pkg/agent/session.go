@@ -2,11 +2,14 @@ package agent
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
 	"github.com/ashka-vakil/attractor/pkg/llm"
 )
 
@@ -23,10 +26,26 @@ type Session struct {
 	SteeringQueue   []string
 	FollowupQueue   []string
 	Subagents       map[string]*SubAgent
+	Hooks           Hooks
+	// Prompt, if set, builds the system prompt from the profile instead of
+	// sending ProviderProfile.SystemPrompt verbatim.
+	Prompt          *PromptBuilder
 
 	mu              sync.Mutex
 	turnCount       int
 	loopDetector    *loopDetector
+	todos           []TodoItem
+	contextWarned   bool
+	usage           map[string]*ModelUsage
+	snapshots       []*fileSnapshot
+	toolCache       map[string]cachedResult
+	readHashes      map[string][sha256.Size]byte
+	argStats        ToolArgumentStats
+	// interruptLLM cancels the LLM call in flight, if any, for Steer.
+	interruptLLM context.CancelFunc
+	// pendingToolCalls holds the tool calls of the turn that hit MaxTurns,
+	// run by Continue.
+	pendingToolCalls []llm.ToolCall
 }
 
 // NewSession creates a new agent session.
@@ -51,20 +70,17 @@ func NewSession(client *llm.Client, profile *ProviderProfile, env ExecutionEnvir
 // Submit sends user input to the agent and processes it through the agentic loop.
 func (s *Session) Submit(ctx context.Context, input string) error {
 	s.mu.Lock()
+	if s.State == StateTurnLimitReached {
+		s.mu.Unlock()
+		return fmt.Errorf("session reached its turn limit; call Continue to resume")
+	}
 	if s.State != StateIdle && s.State != StateAwaitingInput {
 		s.mu.Unlock()
 		return fmt.Errorf("session is not idle (state: %s)", s.State)
 	}
 	s.State = StateProcessing
 	s.mu.Unlock()
-
-	defer func() {
-		s.mu.Lock()
-		if s.State == StateProcessing {
-			s.State = StateIdle
-		}
-		s.mu.Unlock()
-	}()
+	defer s.endProcessing()
 
 	s.EventEmitter.Emit(Event{
 		Type:      EventSessionStarted,
@@ -81,11 +97,85 @@ func (s *Session) Submit(ctx context.Context, input string) error {
 	return s.runLoop(ctx)
 }
 
-// Steer injects a message between tool rounds.
+// Continue resumes a session stopped at its turn limit
+// (StateTurnLimitReached) with a fresh MaxTurns budget. It first runs the
+// tool calls of the turn that hit the limit, then continues the loop.
+func (s *Session) Continue(ctx context.Context) error {
+	s.mu.Lock()
+	if s.State != StateTurnLimitReached {
+		s.mu.Unlock()
+		return fmt.Errorf("session has not reached its turn limit (state: %s)", s.State)
+	}
+	s.State = StateProcessing
+	s.turnCount = 0
+	pending := s.pendingToolCalls
+	s.pendingToolCalls = nil
+	s.mu.Unlock()
+	defer s.endProcessing()
+
+	if len(pending) > 0 {
+		if err := s.runToolRound(ctx, pending); err != nil {
+			return err
+		}
+	}
+	return s.runLoop(ctx)
+}
+
+// endProcessing returns the session to idle once Submit or Continue
+// finishes, unless the loop left it in another state.
+func (s *Session) endProcessing() {
+	s.mu.Lock()
+	if s.State == StateProcessing {
+		s.State = StateIdle
+	}
+	s.mu.Unlock()
+}
+
+// Steer injects a message into the running loop. It is delivered before the
+// next LLM call: an LLM call in flight is cancelled and sent again with the
+// message, and tool calls of the current round that have not started yet
+// are skipped.
 func (s *Session) Steer(message string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.SteeringQueue = append(s.SteeringQueue, message)
+	if s.interruptLLM != nil {
+		s.interruptLLM()
+	}
+}
+
+// errSteered reports an LLM call cancelled by Steer.
+var errSteered = errors.New("LLM call interrupted by steering")
+
+// callLLMSteerable is callLLM for the loop: Steer cancels the call while it
+// is in flight, and a steer that arrived since the loop last checked goes
+// out before it. Either way it returns errSteered.
+func (s *Session) callLLMSteerable(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	if len(s.SteeringQueue) > 0 {
+		s.mu.Unlock()
+		return nil, errSteered
+	}
+	s.interruptLLM = cancel
+	s.mu.Unlock()
+
+	resp, err := s.callLLM(callCtx, req)
+	s.mu.Lock()
+	s.interruptLLM = nil
+	s.mu.Unlock()
+	if err != nil && ctx.Err() == nil && callCtx.Err() != nil {
+		return nil, errSteered
+	}
+	return resp, err
+}
+
+// steeringPending reports whether a steering message is waiting.
+func (s *Session) steeringPending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.SteeringQueue) > 0
 }
 
 // FollowUp queues a message for after the current input completes.
@@ -95,8 +185,10 @@ func (s *Session) FollowUp(message string) {
 	s.FollowupQueue = append(s.FollowupQueue, message)
 }
 
-// Close terminates the session.
+// Close terminates the session, first emitting EventSessionSummary if the
+// agent changed any files.
 func (s *Session) Close() {
+	s.emitSessionSummary()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.State = StateClosed
@@ -141,8 +233,15 @@ func (s *Session) runLoop(ctx context.Context) error {
 			s.mu.Unlock()
 		}
 
+		if budgetErr := s.checkBudget(); budgetErr != nil {
+			return s.finishOverBudget(ctx, budgetErr)
+		}
+
 		// Build LLM request from history
-		req := s.buildRequest()
+		req, budgetErr := s.preflight(ctx, s.buildRequest())
+		if budgetErr != nil {
+			return s.finishOverBudget(ctx, budgetErr)
+		}
 
 		s.EventEmitter.Emit(Event{
 			Type:      EventTurnStarted,
@@ -151,7 +250,16 @@ func (s *Session) runLoop(ctx context.Context) error {
 		})
 
 		// Call LLM
-		resp, err := s.LLMClient.Complete(ctx, req)
+		resp, err := s.callLLMSteerable(ctx, req)
+		if llm.IsContextLengthError(err) && s.compactForContext(err) {
+			// Retry once with the oldest tool outputs dropped.
+			req = s.buildRequest()
+			resp, err = s.callLLMSteerable(ctx, req)
+		}
+		if errors.Is(err, errSteered) {
+			// Drop the interrupted response and deliver the steering message.
+			continue
+		}
 		if err != nil {
 			s.EventEmitter.Emit(Event{
 				Type:      EventError,
@@ -163,20 +271,18 @@ func (s *Session) runLoop(ctx context.Context) error {
 
 		// Record assistant turn
 		assistantTurn := &AssistantTurn{
-			Content:    resp.Content,
-			ToolCalls:  resp.ToolCalls,
-			Reasoning:  resp.Reasoning,
-			Usage:      resp.Usage,
-			ResponseID: resp.ID,
-			Timestamp:  time.Now(),
+			Content:      resp.Content,
+			ToolCalls:    resp.ToolCalls,
+			Reasoning:    resp.Reasoning,
+			Usage:        resp.Usage,
+			ResponseID:   resp.ID,
+			FinishReason: resp.FinishReason,
+			Timestamp:    time.Now(),
 		}
 		s.History = append(s.History, assistantTurn)
 		s.turnCount++
-
-		// Check turn limit
-		if s.Config.MaxTurns > 0 && s.turnCount >= s.Config.MaxTurns {
-			break
-		}
+		s.recordUsage(req.Model, resp.Usage)
+		s.checkContextWindow(resp.Usage)
 
 		// If no tool calls, the loop is done
 		if len(resp.ToolCalls) == 0 {
@@ -184,41 +290,35 @@ func (s *Session) runLoop(ctx context.Context) error {
 				Type:      EventTurnCompleted,
 				Timestamp: time.Now(),
 				Data: map[string]interface{}{
-					"content":    resp.Content,
-					"tool_round": toolRound,
+					"content":       resp.Content,
+					"tool_round":    toolRound,
+					"finish_reason": string(resp.FinishReason),
 				},
 			})
 			break
 		}
 
-		// Execute tool calls
-		results, err := s.executeToolCalls(ctx, resp.ToolCalls)
-		if err != nil {
-			return fmt.Errorf("tool execution failed: %w", err)
+		// Check turn limit: stop before running the tool calls, leaving
+		// them for Continue.
+		if s.Config.MaxTurns > 0 && s.turnCount >= s.Config.MaxTurns {
+			s.mu.Lock()
+			s.State = StateTurnLimitReached
+			s.pendingToolCalls = resp.ToolCalls
+			s.mu.Unlock()
+			s.EventEmitter.Emit(Event{
+				Type:      EventTurnLimitReached,
+				Timestamp: time.Now(),
+				Data: map[string]interface{}{
+					"turns":              s.turnCount,
+					"max_turns":          s.Config.MaxTurns,
+					"pending_tool_calls": len(resp.ToolCalls),
+				},
+			})
+			return nil
 		}
 
-		// Record tool results
-		s.History = append(s.History, &ToolResultsTurn{
-			Results:   results,
-			Timestamp: time.Now(),
-		})
-
-		// Loop detection
-		if s.Config.EnableLoopDetection {
-			for _, tc := range resp.ToolCalls {
-				if s.loopDetector.recordAndCheck(tc.Name, string(tc.Arguments)) {
-					s.EventEmitter.Emit(Event{
-						Type:      EventLoopDetected,
-						Timestamp: time.Now(),
-						Data: map[string]interface{}{
-							"tool":  tc.Name,
-							"count": s.Config.LoopDetectionWindow,
-						},
-					})
-					// Inject steering to break the loop
-					s.Steer("You appear to be in a loop calling the same tool repeatedly. Please try a different approach.")
-				}
-			}
+		if err := s.runToolRound(ctx, resp.ToolCalls); err != nil {
+			return err
 		}
 
 		toolRound++
@@ -237,23 +337,106 @@ func (s *Session) runLoop(ctx context.Context) error {
 	return nil
 }
 
+// runToolRound executes a turn's tool calls, records their results, and
+// checks them for loops.
+func (s *Session) runToolRound(ctx context.Context, toolCalls []llm.ToolCall) error {
+	results, err := s.executeToolCalls(ctx, toolCalls)
+	if err != nil {
+		return fmt.Errorf("tool execution failed: %w", err)
+	}
+
+	// Record tool results
+	s.History = append(s.History, &ToolResultsTurn{
+		Results:   results,
+		Timestamp: time.Now(),
+	})
+
+	// Loop detection
+	if s.Config.EnableLoopDetection {
+		for _, tc := range toolCalls {
+			if s.loopDetector.recordAndCheck(tc.Name, string(tc.Arguments)) {
+				s.EventEmitter.Emit(Event{
+					Type:      EventLoopDetected,
+					Timestamp: time.Now(),
+					Data: map[string]interface{}{
+						"tool":  tc.Name,
+						"count": s.Config.LoopDetectionWindow,
+					},
+				})
+				// Inject steering to break the loop
+				s.Steer("You appear to be in a loop calling the same tool repeatedly. Please try a different approach.")
+			}
+		}
+	}
+	return nil
+}
+
+// contextWindow returns the model's context window in tokens from the
+// client's catalog, or 0 if the model is unknown.
+func (s *Session) contextWindow() int {
+	info, ok := s.LLMClient.Catalog().Lookup(s.ProviderProfile.Model)
+	if !ok {
+		return 0
+	}
+	return info.ContextWindow
+}
+
+// checkContextWindow emits EventContextWindowWarning the first time prompt
+// usage crosses Config.ContextWarningThreshold of the model's context window.
+func (s *Session) checkContextWindow(usage llm.Usage) {
+	if s.contextWarned || s.Config.ContextWarningThreshold <= 0 {
+		return
+	}
+	window := s.contextWindow()
+	if window == 0 {
+		return
+	}
+	ratio := float64(usage.InputTokens) / float64(window)
+	if ratio < s.Config.ContextWarningThreshold {
+		return
+	}
+	s.contextWarned = true
+	s.EventEmitter.Emit(Event{
+		Type:      EventContextWindowWarning,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"input_tokens":   usage.InputTokens,
+			"context_window": window,
+			"ratio":          ratio,
+		},
+	})
+}
+
 func (s *Session) buildRequest() *llm.Request {
 	req := &llm.Request{
 		Model: s.ProviderProfile.Model,
 	}
 
+	tools := s.tools()
 	if s.ProviderProfile.SystemPrompt != "" {
 		req.SystemPrompt = s.ProviderProfile.SystemPrompt
 	}
+	if s.Prompt != nil {
+		// Describe the tools the model actually gets.
+		profile := *s.ProviderProfile
+		profile.Tools = tools
+		if prompt, err := s.Prompt.Build(&profile); err != nil {
+			s.EventEmitter.Emit(Event{
+				Type:      EventError,
+				Timestamp: time.Now(),
+				Data:      map[string]interface{}{"error": err.Error()},
+			})
+		} else {
+			req.SystemPrompt = prompt
+		}
+	}
 
 	if s.Config.ReasoningEffort != "" {
 		req.ReasoningEffort = s.Config.ReasoningEffort
 	}
 
 	// Build tools
-	for _, t := range s.ProviderProfile.Tools {
-		req.Tools = append(req.Tools, t)
-	}
+	req.Tools = tools
 
 	// Build messages from history
 	for _, turn := range s.History {
@@ -292,7 +475,24 @@ func (s *Session) buildRequest() *llm.Request {
 
 func (s *Session) executeToolCalls(ctx context.Context, toolCalls []llm.ToolCall) ([]llm.ToolResult, error) {
 	results := make([]llm.ToolResult, len(toolCalls))
-	for i, tc := range toolCalls {
+	for i := range toolCalls {
+		// toolCalls shares its array with the assistant turn in the
+		// history, so repaired arguments are also what the provider sees
+		// when the call is sent back.
+		argErr := s.checkToolArguments(&toolCalls[i])
+		tc := toolCalls[i]
+
+		// A steering message skips the rest of the round so the model sees
+		// it right away.
+		if s.steeringPending() {
+			results[i] = llm.ToolResult{
+				ToolCallID: tc.ID,
+				Content:    "Skipped: the user sent a new instruction before this tool call ran.",
+				IsError:    true,
+			}
+			continue
+		}
+
 		s.EventEmitter.Emit(Event{
 			Type:      EventToolCallStarted,
 			Timestamp: time.Now(),
@@ -302,13 +502,73 @@ func (s *Session) executeToolCalls(ctx context.Context, toolCalls []llm.ToolCall
 			},
 		})
 
-		result, err := s.ExecutionEnv.Execute(ctx, tc.Name, tc.Arguments)
+		var result string
+		var err error
+		var hooked *llm.ToolResult
+		cached := false
+		if argErr != nil {
+			err = argErr
+		} else if !s.toolEnabled(tc.Name) {
+			err = fmt.Errorf("tool %s is not enabled in this session", tc.Name)
+		} else if s.Hooks.BeforeToolCall != nil {
+			if hooked, err = s.Hooks.BeforeToolCall(ctx, tc); err != nil {
+				err = fmt.Errorf("tool call rejected: %w", err)
+			}
+		}
+		switch {
+		case err != nil:
+		case hooked != nil:
+			result = hooked.Content
+		case isPlanTool(tc.Name):
+			result, err = s.executePlanTool(tc.Name, tc.Arguments)
+		case tc.Name == "revert_changes":
+			result, err = s.executeRevertTool(tc.Arguments)
+			s.updateToolCache(tc, result, err)
+		case tc.Name == "multi_edit":
+			result, err = s.executeMultiEdit(tc.Arguments)
+			s.updateToolCache(tc, result, err)
+			if err == nil {
+				result += s.runPostEditHooks(ctx, tc.Name, tc.Arguments)
+			}
+		case tc.Name == "diagnostics":
+			result, err = s.executeDiagnostics(s.streamToolOutput(ctx, tc), tc.Arguments)
+		default:
+			if result, cached = s.cachedToolResult(tc); cached {
+				break
+			}
+			if err = s.checkStaleRead(tc.Name, tc.Arguments); err != nil {
+				break
+			}
+			s.snapshotBeforeWrite(tc.Name, tc.Arguments)
+			result, err = s.ExecutionEnv.Execute(s.streamToolOutput(ctx, tc), tc.Name, tc.Arguments)
+			s.updateToolCache(tc, result, err)
+			if err == nil {
+				s.recordFileVersion(tc.Name, tc.Arguments)
+				result += s.runPostEditHooks(ctx, tc.Name, tc.Arguments)
+			}
+		}
+		var violation *env.PolicyViolation
+		if errors.As(err, &violation) {
+			s.EventEmitter.Emit(Event{
+				Type:      EventPolicyViolation,
+				Timestamp: time.Now(),
+				Data: map[string]interface{}{
+					"tool_name": tc.Name,
+					"tool_id":   tc.ID,
+					"rule":      violation.Rule,
+					"detail":    violation.Detail,
+				},
+			})
+		}
 		if err != nil {
 			results[i] = llm.ToolResult{
 				ToolCallID: tc.ID,
 				Content:    fmt.Sprintf("Error: %s", err),
 				IsError:    true,
 			}
+		} else if hooked != nil {
+			results[i] = *hooked
+			results[i].ToolCallID = tc.ID
 		} else {
 			// Apply full two-stage truncation pipeline
 			content := s.applyTruncation(tc.Name, result)
@@ -318,6 +578,9 @@ func (s *Session) executeToolCalls(ctx context.Context, toolCalls []llm.ToolCall
 				Content:    content,
 			}
 		}
+		if s.Hooks.AfterToolCall != nil {
+			s.Hooks.AfterToolCall(ctx, tc, &results[i])
+		}
 
 		// TOOL_CALL_END event carries full untruncated output
 		s.EventEmitter.Emit(Event{
@@ -327,6 +590,7 @@ func (s *Session) executeToolCalls(ctx context.Context, toolCalls []llm.ToolCall
 				"tool_name": tc.Name,
 				"tool_id":   tc.ID,
 				"is_error":  results[i].IsError,
+				"cached":    cached,
 				"output":    result, // full untruncated output
 			},
 		})
@@ -334,6 +598,35 @@ func (s *Session) executeToolCalls(ctx context.Context, toolCalls []llm.ToolCall
 	return results, nil
 }
 
+// streamToolOutput returns a context that emits EventToolCallOutputDelta events
+// for output produced while tc runs, up to Config.ToolOutputStreamLimit bytes.
+func (s *Session) streamToolOutput(ctx context.Context, tc llm.ToolCall) context.Context {
+	limit := s.Config.ToolOutputStreamLimit
+	sent := 0
+	truncated := false
+	return env.WithOutputFunc(ctx, func(stream, chunk string) {
+		if truncated {
+			return
+		}
+		if limit > 0 && sent+len(chunk) > limit {
+			chunk = env.TruncateUTF8(chunk, limit-sent)
+			truncated = true
+		}
+		sent += len(chunk)
+		s.EventEmitter.Emit(Event{
+			Type:      EventToolCallOutputDelta,
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"tool_name": tc.Name,
+				"tool_id":   tc.ID,
+				"stream":    stream,
+				"delta":     chunk,
+				"truncated": truncated,
+			},
+		})
+	})
+}
+
 // defaultToolOutputLimits provides default character limits per tool name.
 var defaultToolOutputLimits = map[string]int{
 	"read_file": 50000,
@@ -3,9 +3,14 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
 	"github.com/ashka-vakil/attractor/pkg/llm"
 )
 
@@ -13,11 +18,13 @@ import (
 type mockLLMAdapter struct {
 	responses []*llm.Response
 	callIdx   int
+	requests  []*llm.Request
 }
 
 func (m *mockLLMAdapter) Name() string { return "mock" }
 func (m *mockLLMAdapter) Close() error { return nil }
 func (m *mockLLMAdapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	m.requests = append(m.requests, req)
 	if m.callIdx >= len(m.responses) {
 		return &llm.Response{
 			Content:      "Done.",
@@ -40,6 +47,8 @@ type mockEnv struct {
 	results map[string]string
 }
 
+func (m *mockEnv) Tools() []llm.Tool { return nil }
+
 func (m *mockEnv) Execute(ctx context.Context, toolName string, arguments json.RawMessage) (string, error) {
 	if result, ok := m.results[toolName]; ok {
 		return result, nil
@@ -155,6 +164,84 @@ func TestSessionSteering(t *testing.T) {
 	_ = callCount
 }
 
+// blockingLLMAdapter blocks its first Complete until the context is
+// cancelled, then answers like mockLLMAdapter.
+type blockingLLMAdapter struct {
+	mockLLMAdapter
+	started chan struct{}
+}
+
+func (b *blockingLLMAdapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	if len(b.requests) == 0 {
+		b.requests = append(b.requests, req)
+		close(b.started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return b.mockLLMAdapter.Complete(ctx, req)
+}
+
+func TestSessionSteerInterruptsLLMCall(t *testing.T) {
+	adapter := &blockingLLMAdapter{started: make(chan struct{})}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), &mockEnv{results: map[string]string{}}, DefaultSessionConfig())
+
+	go func() {
+		<-adapter.started
+		session.Steer("Use tabs")
+	}()
+	if err := session.Submit(context.Background(), "Format the file"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if len(adapter.requests) != 2 {
+		t.Fatalf("expected the LLM call to be sent again, got %d calls", len(adapter.requests))
+	}
+	var kinds []string
+	for _, turn := range session.History {
+		kinds = append(kinds, turn.turnType())
+	}
+	if got := strings.Join(kinds, ","); got != "user,steering,assistant" {
+		t.Errorf("expected the steer before the only assistant turn, got %s", got)
+	}
+}
+
+func TestSessionSteerSkipsRemainingToolCalls(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "shell", Arguments: json.RawMessage(`{"command":"ls"}`)},
+					{ID: "call-2", Name: "shell", Arguments: json.RawMessage(`{"command":"rm -r build"}`)},
+				},
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), &mockEnv{results: map[string]string{}}, DefaultSessionConfig())
+	var ran []string
+	session.Hooks.BeforeToolCall = func(ctx context.Context, call llm.ToolCall) (*llm.ToolResult, error) {
+		ran = append(ran, call.ID)
+		session.Steer("Don't delete anything")
+		return nil, nil
+	}
+
+	if err := session.Submit(context.Background(), "Clean up"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if len(ran) != 1 || ran[0] != "call-1" {
+		t.Errorf("expected only call-1 to run, got %v", ran)
+	}
+	results := session.History[2].(*ToolResultsTurn).Results
+	if len(results) != 2 || !results[1].IsError || !strings.HasPrefix(results[1].Content, "Skipped") {
+		t.Errorf("expected call-2 to be skipped, got %+v", results)
+	}
+	if steer, ok := session.History[3].(*SteeringTurn); !ok || steer.Content != "Don't delete anything" {
+		t.Errorf("expected the steer right after the tool results, got %+v", session.History[3])
+	}
+}
+
 func TestSessionMaxTurns(t *testing.T) {
 	adapter := &mockLLMAdapter{
 		responses: []*llm.Response{
@@ -173,6 +260,70 @@ func TestSessionMaxTurns(t *testing.T) {
 	}
 }
 
+func TestSessionContinueAfterTurnLimit(t *testing.T) {
+	shell := llm.ToolCall{ID: "call-1", Name: "shell", Arguments: json.RawMessage(`{"command":"ls"}`)}
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{FinishReason: llm.FinishReasonToolCalls, ToolCalls: []llm.ToolCall{shell}},
+			{FinishReason: llm.FinishReasonToolCalls, ToolCalls: []llm.ToolCall{shell}},
+			{Content: "All done.", FinishReason: llm.FinishReasonStop},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.MaxTurns = 1
+	session := NewSession(client, DefaultOpenAIProfile("test-model"), &mockEnv{results: map[string]string{"shell": "file.go"}}, config)
+
+	var limitEvents []Event
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventTurnLimitReached {
+			limitEvents = append(limitEvents, e)
+		}
+	})
+
+	if err := session.Submit(context.Background(), "list files"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if session.State != StateTurnLimitReached {
+		t.Fatalf("expected %s, got %s", StateTurnLimitReached, session.State)
+	}
+	if len(limitEvents) != 1 || limitEvents[0].Data["max_turns"] != 1 || limitEvents[0].Data["pending_tool_calls"] != 1 {
+		t.Errorf("expected one turn_limit_reached event, got %v", limitEvents)
+	}
+	if _, ok := session.History[len(session.History)-1].(*AssistantTurn); !ok {
+		t.Errorf("expected the pending tool calls not to run yet, got %T", session.History[len(session.History)-1])
+	}
+	if err := session.Submit(context.Background(), "more"); err == nil {
+		t.Error("expected Submit to fail at the turn limit")
+	}
+
+	// Each Continue runs the pending tool calls and gets one more turn.
+	if err := session.Continue(context.Background()); err != nil {
+		t.Fatalf("Continue failed: %v", err)
+	}
+	if session.State != StateTurnLimitReached || len(limitEvents) != 2 {
+		t.Fatalf("expected the second turn to hit the limit, got %s after %d events", session.State, len(limitEvents))
+	}
+	if err := session.Continue(context.Background()); err != nil {
+		t.Fatalf("Continue failed: %v", err)
+	}
+	if session.State != StateIdle {
+		t.Errorf("expected %s, got %s", StateIdle, session.State)
+	}
+	var results int
+	for _, turn := range session.History {
+		if _, ok := turn.(*ToolResultsTurn); ok {
+			results++
+		}
+	}
+	if results != 2 {
+		t.Errorf("expected 2 tool results turns, got %d", results)
+	}
+	if err := session.Continue(context.Background()); err == nil {
+		t.Error("expected Continue to fail when the session is idle")
+	}
+}
+
 func TestSessionClose(t *testing.T) {
 	client := llm.NewClient(llm.WithProvider("mock", &mockLLMAdapter{}))
 	profile := DefaultAnthropicProfile("test-model")
@@ -255,6 +406,98 @@ func TestOpenAIProfileHasApplyPatch(t *testing.T) {
 	}
 }
 
+// toolEnv is a mockEnv that reports its own tool schemas.
+type toolEnv struct {
+	mockEnv
+	tools []llm.Tool
+}
+
+func (e *toolEnv) Tools() []llm.Tool { return e.tools }
+
+func TestSessionToolsFromEnvironment(t *testing.T) {
+	adapter := &mockLLMAdapter{}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	environment := &toolEnv{tools: []llm.Tool{
+		{Name: "read_file", Description: "Read a file from the sandbox", Parameters: json.RawMessage(`{"type":"object"}`)},
+		{Name: "deploy", Description: "Deploy the service", Parameters: json.RawMessage(`{"type":"object"}`)},
+	}}
+	config := DefaultSessionConfig()
+	config.DiscoverEnvironmentTools = true
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), environment, config)
+	session.Prompt = NewPromptBuilder(t.TempDir())
+
+	if err := session.Submit(context.Background(), "ship it"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	req := adapter.requests[0]
+	byName := make(map[string]llm.Tool)
+	for _, tool := range req.Tools {
+		byName[tool.Name] = tool
+	}
+	if byName["read_file"].Description != "Read a file from the sandbox" {
+		t.Errorf("expected the environment's read_file schema, got %q", byName["read_file"].Description)
+	}
+	if req.Tools[len(req.Tools)-1].Name != "deploy" || len(req.Tools) != len(DefaultToolSet())+1 {
+		t.Errorf("expected deploy to be added after the profile's tools, got %d tools", len(req.Tools))
+	}
+	if !strings.Contains(req.SystemPrompt, "deploy") {
+		t.Error("expected the system prompt to describe the discovered tool")
+	}
+
+	// Without the opt-in, only the profile's tools are offered.
+	adapter = &mockLLMAdapter{}
+	client = llm.NewClient(llm.WithProvider("mock", adapter))
+	session = NewSession(client, DefaultAnthropicProfile("test-model"), environment, DefaultSessionConfig())
+	if err := session.Submit(context.Background(), "ship it"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	for _, tool := range adapter.requests[0].Tools {
+		if tool.Name == "deploy" {
+			t.Error("expected the unlisted environment tool to be left out by default")
+		}
+	}
+	if got := len(adapter.requests[0].Tools); got != len(DefaultToolSet()) {
+		t.Errorf("expected the profile's %d tools, got %d", len(DefaultToolSet()), got)
+	}
+}
+
+func TestSessionToolFiltering(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls:    []llm.ToolCall{{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"rm -rf /"}`)}},
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.DisabledTools = []string{"bash", "write_file"}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), &mockEnv{results: map[string]string{"bash": "deleted"}}, config)
+
+	if err := session.Submit(context.Background(), "clean up"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	for _, tool := range adapter.requests[0].Tools {
+		if tool.Name == "bash" || tool.Name == "write_file" {
+			t.Errorf("expected %s to be disabled", tool.Name)
+		}
+	}
+	result := session.History[2].(*ToolResultsTurn).Results[0]
+	if !result.IsError || !strings.Contains(result.Content, "tool bash is not enabled") {
+		t.Errorf("expected the disabled tool call to fail, got %+v", result)
+	}
+
+	session.Config.EnabledTools = []string{"read_file", "grep", "bash"}
+	var names []string
+	for _, tool := range session.tools() {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "read_file,grep" {
+		t.Errorf("expected read_file,grep, got %s", got)
+	}
+}
+
 func TestRegisterCustomTool(t *testing.T) {
 	profile := DefaultAnthropicProfile("test-model")
 	originalCount := len(profile.Tools)
@@ -319,3 +562,1068 @@ func containsSubstr(s, sub string) bool {
 	}
 	return false
 }
+
+func TestSessionPolicyViolation(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"rm -rf build"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	localEnv := env.NewLocalEnvironment(t.TempDir())
+	localEnv.Policy = &env.Policy{DenyCommands: env.MustCompilePatterns(`\brm\b`)}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), localEnv, DefaultSessionConfig())
+
+	var violations []Event
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventPolicyViolation {
+			violations = append(violations, e)
+		}
+	})
+
+	if err := session.Submit(context.Background(), "clean up"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("expected 1 policy violation event, got %d", len(violations))
+	}
+	if violations[0].Data["rule"] != "command_denied" {
+		t.Errorf("expected command_denied, got %v", violations[0].Data["rule"])
+	}
+
+	var found bool
+	for _, turn := range session.History {
+		if tr, ok := turn.(*ToolResultsTurn); ok {
+			for _, r := range tr.Results {
+				if r.IsError && strings.Contains(r.Content, "policy violation") {
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("expected the model to receive the policy violation as a tool error")
+	}
+}
+
+func TestSessionStreamsToolOutput(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"printf 0123456789"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.ToolOutputStreamLimit = 4
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(t.TempDir()), config)
+
+	var streamed string
+	var truncated bool
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolCallOutputDelta {
+			streamed += e.Data["delta"].(string)
+			if e.Data["truncated"] == true {
+				truncated = true
+			}
+		}
+	})
+
+	if err := session.Submit(context.Background(), "count"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if streamed != "0123" {
+		t.Errorf("expected streamed output limited to 4 bytes, got %q", streamed)
+	}
+	if !truncated {
+		t.Error("expected final delta to be marked truncated")
+	}
+}
+
+func TestSessionStreamsToolOutputRuneAligned(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"printf 'a\u00e9b'"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.ToolOutputStreamLimit = 2
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(t.TempDir()), config)
+
+	var streamed string
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolCallOutputDelta {
+			streamed += e.Data["delta"].(string)
+		}
+	})
+
+	if err := session.Submit(context.Background(), "accent"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if streamed != "a" {
+		t.Errorf("expected the limit to stop before the split rune, got %q", streamed)
+	}
+}
+
+func TestSessionTodoTools(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "todo_write", Arguments: json.RawMessage(`{"todos":[
+						{"content":"Reproduce the bug","status":"done"},
+						{"content":"Write the fix","status":"in_progress"},
+						{"content":"Run tests"}
+					]}`)},
+					{ID: "call-2", Name: "todo_read", Arguments: json.RawMessage(`{}`)},
+					{ID: "call-3", Name: "todo_write", Arguments: json.RawMessage(`{"todos":[{"content":"x","status":"blocked"}]}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	env := &mockEnv{results: map[string]string{}}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env, DefaultSessionConfig())
+
+	var planEvents int
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventPlanUpdated {
+			planEvents++
+		}
+	})
+
+	if err := session.Submit(context.Background(), "fix the bug"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	todos := session.Todos()
+	if len(todos) != 3 {
+		t.Fatalf("expected 3 todos, got %d", len(todos))
+	}
+	if todos[1].Status != TodoInProgress || todos[2].Status != TodoPending || todos[2].ID != "3" {
+		t.Errorf("unexpected todos: %+v", todos)
+	}
+	if planEvents != 1 {
+		t.Errorf("expected 1 plan_updated event, got %d", planEvents)
+	}
+
+	var results []llm.ToolResult
+	for _, turn := range session.History {
+		if tr, ok := turn.(*ToolResultsTurn); ok {
+			results = append(results, tr.Results...)
+		}
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 tool results, got %d", len(results))
+	}
+	if !strings.Contains(results[1].Content, "[~] 2. Write the fix") || !strings.Contains(results[1].Content, "1/3 done") {
+		t.Errorf("unexpected todo_read output %q", results[1].Content)
+	}
+	if !results[2].IsError {
+		t.Error("expected invalid status to be reported as a tool error")
+	}
+}
+
+func TestSessionContextWindowWarning(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				Content:      "ok",
+				FinishReason: llm.FinishReasonStop,
+				Usage:        llm.Usage{InputTokens: 900},
+			},
+		},
+	}
+	catalog := llm.NewModelCatalog(llm.ModelInfo{ID: "small-model", ContextWindow: 1000})
+	client := llm.NewClient(llm.WithProvider("mock", adapter), llm.WithCatalog(catalog))
+	session := NewSession(client, DefaultAnthropicProfile("small-model"), &mockEnv{}, DefaultSessionConfig())
+
+	var warnings []Event
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventContextWindowWarning {
+			warnings = append(warnings, e)
+		}
+	})
+
+	if err := session.Submit(context.Background(), "hi"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 context window warning, got %d", len(warnings))
+	}
+	if warnings[0].Data["context_window"] != 1000 {
+		t.Errorf("expected context_window 1000, got %v", warnings[0].Data["context_window"])
+	}
+}
+
+func TestSessionUsage(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls:    []llm.ToolCall{{ID: "call-1", Name: "shell", Arguments: json.RawMessage(`{"command":"ls"}`)}},
+				Usage:        llm.Usage{InputTokens: 1000, OutputTokens: 100, TotalTokens: 1100},
+			},
+			{
+				Content:      "done",
+				FinishReason: llm.FinishReasonStop,
+				Usage:        llm.Usage{InputTokens: 2000, OutputTokens: 200, TotalTokens: 2200},
+			},
+		},
+	}
+	catalog := llm.NewModelCatalog(llm.ModelInfo{ID: "priced-model", InputCostPerMTok: 1, OutputCostPerMTok: 10})
+	client := llm.NewClient(llm.WithProvider("mock", adapter), llm.WithCatalog(catalog))
+	env := &mockEnv{results: map[string]string{"shell": "file.go"}}
+	session := NewSession(client, DefaultOpenAIProfile("priced-model"), env, DefaultSessionConfig())
+
+	if err := session.Submit(context.Background(), "list files"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	report := session.Usage()
+	if report.Calls != 2 {
+		t.Errorf("expected 2 calls, got %d", report.Calls)
+	}
+	if report.Total.InputTokens != 3000 || report.Total.OutputTokens != 300 {
+		t.Errorf("unexpected totals: %+v", report.Total)
+	}
+	if want := 3000.0/1e6 + 300*10.0/1e6; report.CostUSD != want {
+		t.Errorf("expected cost %v, got %v", want, report.CostUSD)
+	}
+	if len(report.ByModel) != 1 || report.ByModel[0].Model != "priced-model" {
+		t.Errorf("unexpected per-model breakdown: %+v", report.ByModel)
+	}
+}
+
+func TestSessionBudgetExceeded(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls:    []llm.ToolCall{{ID: "call-1", Name: "shell", Arguments: json.RawMessage(`{"command":"ls"}`)}},
+				Usage:        llm.Usage{InputTokens: 900, OutputTokens: 200},
+			},
+			{
+				Content:      "Listed files; nothing else done.",
+				FinishReason: llm.FinishReasonStop,
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.MaxTotalTokens = 1000
+	session := NewSession(client, DefaultOpenAIProfile("test-model"), &mockEnv{results: map[string]string{}}, config)
+
+	var budgetEvents int
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventBudgetExceeded {
+			budgetEvents++
+		}
+	})
+
+	err := session.Submit(context.Background(), "list files")
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected BudgetExceededError, got %v", err)
+	}
+	if budgetErr.Limit != "tokens" || budgetErr.Used != 1100 {
+		t.Errorf("unexpected budget error: %+v", budgetErr)
+	}
+	if budgetEvents != 1 {
+		t.Errorf("expected 1 budget_exceeded event, got %d", budgetEvents)
+	}
+	if len(adapter.requests) != 2 {
+		t.Fatalf("expected 2 LLM calls, got %d", len(adapter.requests))
+	}
+	if len(adapter.requests[1].Tools) != 0 {
+		t.Error("expected summary request to have no tools")
+	}
+	last, ok := session.History[len(session.History)-1].(*AssistantTurn)
+	if !ok || last.Content != "Listed files; nothing else done." {
+		t.Errorf("expected final summary turn, got %+v", session.History[len(session.History)-1])
+	}
+}
+
+func TestSessionHooks(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "shell", Arguments: json.RawMessage(`{"command":"rm -rf /"}`)},
+					{ID: "call-2", Name: "read_file", Arguments: json.RawMessage(`{"path":"a.txt"}`)},
+					{ID: "call-3", Name: "glob", Arguments: json.RawMessage(`{"pattern":"*"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	env := &mockEnv{results: map[string]string{"glob": "a.txt"}}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env, DefaultSessionConfig())
+
+	var llmCalls int
+	var after []string
+	session.Hooks = Hooks{
+		BeforeLLMCall: func(ctx context.Context, req *llm.Request) error {
+			req.StopSequences = append(req.StopSequences, "<halt>")
+			return nil
+		},
+		AfterLLMCall: func(ctx context.Context, req *llm.Request, resp *llm.Response) error {
+			llmCalls++
+			return nil
+		},
+		BeforeToolCall: func(ctx context.Context, call llm.ToolCall) (*llm.ToolResult, error) {
+			switch call.Name {
+			case "shell":
+				return nil, errors.New("shell is disabled")
+			case "read_file":
+				return &llm.ToolResult{Content: "cached contents"}, nil
+			}
+			return nil, nil
+		},
+		AfterToolCall: func(ctx context.Context, call llm.ToolCall, result *llm.ToolResult) {
+			after = append(after, call.Name)
+			if call.Name == "glob" {
+				result.Content += " (filtered)"
+			}
+		},
+	}
+
+	if err := session.Submit(context.Background(), "clean up"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if llmCalls != 2 {
+		t.Errorf("expected 2 LLM calls, got %d", llmCalls)
+	}
+	for _, req := range adapter.requests {
+		if len(req.StopSequences) != 1 || req.StopSequences[0] != "<halt>" {
+			t.Errorf("expected BeforeLLMCall to modify request, got stop sequences %v", req.StopSequences)
+		}
+	}
+	if len(after) != 3 {
+		t.Errorf("expected AfterToolCall for every call, got %v", after)
+	}
+
+	var results []llm.ToolResult
+	for _, turn := range session.History {
+		if tr, ok := turn.(*ToolResultsTurn); ok {
+			results = append(results, tr.Results...)
+		}
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 tool results, got %d", len(results))
+	}
+	if !results[0].IsError || !strings.Contains(results[0].Content, "shell is disabled") {
+		t.Errorf("expected vetoed shell call, got %+v", results[0])
+	}
+	if results[1].Content != "cached contents" || results[1].ToolCallID != "call-2" {
+		t.Errorf("expected synthetic read_file result, got %+v", results[1])
+	}
+	if results[2].Content != "a.txt (filtered)" {
+		t.Errorf("expected AfterToolCall to modify result, got %q", results[2].Content)
+	}
+}
+
+func TestSessionBeforeLLMCallAborts(t *testing.T) {
+	client := llm.NewClient(llm.WithProvider("mock", &mockLLMAdapter{}))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), &mockEnv{}, DefaultSessionConfig())
+	session.Hooks.BeforeLLMCall = func(ctx context.Context, req *llm.Request) error {
+		return errors.New("quota exhausted")
+	}
+
+	err := session.Submit(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "quota exhausted") {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+}
+
+func TestSessionChanges(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "edit_file", Arguments: json.RawMessage(`{"path":"main.go","old_string":"\"hi\"","new_string":"\"hello\""}`)},
+					{ID: "call-2", Name: "write_file", Arguments: json.RawMessage(`{"path":"README.md","content":"# Demo\n"}`)},
+					{ID: "call-3", Name: "edit_file", Arguments: json.RawMessage(`{"path":"main.go","old_string":"package main","new_string":"package main // demo"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), DefaultSessionConfig())
+
+	var summaryEvents int
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventSessionSummary {
+			summaryEvents++
+		}
+	})
+
+	if err := session.Submit(context.Background(), "say hello"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	changes := session.Changes()
+	if len(changes.Files) != 2 {
+		t.Fatalf("expected 2 changed files, got %+v", changes.Files)
+	}
+	main, readme := changes.Files[0], changes.Files[1]
+	if main.Path != "main.go" || main.Status != ChangeModified || main.Added != 2 || main.Removed != 2 {
+		t.Errorf("unexpected main.go change: %+v", main)
+	}
+	if !strings.Contains(main.Diff, "-\tprintln(\"hi\")\n+\tprintln(\"hello\")\n") {
+		t.Errorf("unexpected diff:\n%s", main.Diff)
+	}
+	if readme.Status != ChangeCreated || readme.Added != 1 || !strings.HasPrefix(readme.Diff, "--- /dev/null\n+++ b/README.md\n") {
+		t.Errorf("unexpected README.md change: %+v", readme)
+	}
+	if changes.Added != 3 || changes.Removed != 2 {
+		t.Errorf("expected +3 -2, got +%d -%d", changes.Added, changes.Removed)
+	}
+
+	session.Close()
+	if summaryEvents != 1 {
+		t.Errorf("expected 1 session_summary event, got %d", summaryEvents)
+	}
+}
+
+func TestSessionToolCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read := json.RawMessage(`{"path":"a.txt"}`)
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "read_file", Arguments: read},
+					{ID: "call-2", Name: "read_file", Arguments: json.RawMessage(`{ "path": "a.txt" }`)},
+					{ID: "call-3", Name: "write_file", Arguments: json.RawMessage(`{"path":"a.txt","content":"two"}`)},
+					{ID: "call-4", Name: "read_file", Arguments: read},
+					{ID: "call-5", Name: "read_file", Arguments: read},
+				},
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.CacheToolResults = true
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), config)
+
+	// Change the file behind the agent's back before the last read.
+	session.Hooks.BeforeToolCall = func(ctx context.Context, call llm.ToolCall) (*llm.ToolResult, error) {
+		if call.ID == "call-5" {
+			os.WriteFile(path, []byte("three"), 0o644)
+			os.Chtimes(path, time.Now(), time.Now().Add(time.Hour))
+		}
+		return nil, nil
+	}
+	cached := map[string]bool{}
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolCallCompleted {
+			cached[e.Data["tool_id"].(string)] = e.Data["cached"].(bool)
+		}
+	})
+
+	if err := session.Submit(context.Background(), "read a.txt"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	want := map[string]bool{"call-1": false, "call-2": true, "call-3": false, "call-4": false, "call-5": false}
+	for id, hit := range want {
+		if cached[id] != hit {
+			t.Errorf("%s: expected cached=%v, got %v", id, hit, cached[id])
+		}
+	}
+	var contents []string
+	for _, r := range session.History[2].(*ToolResultsTurn).Results {
+		contents = append(contents, r.Content)
+	}
+	if got := strings.Join(contents[:2], ",") + "," + strings.Join(contents[3:], ","); got != "one,one,two,three" {
+		t.Errorf("expected reads one,one,two,three, got %s", got)
+	}
+}
+
+func TestSessionToolCacheSkipsSearches(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "pkg", "a.txt")
+	os.MkdirAll(filepath.Dir(nested), 0o755)
+	if err := os.WriteFile(nested, []byte("needle one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read := json.RawMessage(`{"path":"pkg/a.txt"}`)
+	grep := json.RawMessage(`{"pattern":"needle"}`)
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "read_file", Arguments: read},
+					{ID: "call-2", Name: "grep", Arguments: grep},
+					{ID: "call-3", Name: "grep", Arguments: grep},
+				},
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.CacheToolResults = true
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), config)
+
+	// Edit the nested file without touching the directory's mtime.
+	session.Hooks.BeforeToolCall = func(ctx context.Context, call llm.ToolCall) (*llm.ToolResult, error) {
+		if call.ID == "call-3" {
+			info, _ := os.Stat(filepath.Dir(nested))
+			os.WriteFile(nested, []byte("needle two\n"), 0o644)
+			os.Chtimes(filepath.Dir(nested), info.ModTime(), info.ModTime())
+		}
+		return nil, nil
+	}
+	cached := map[string]bool{}
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolCallCompleted {
+			cached[e.Data["tool_id"].(string)] = e.Data["cached"].(bool)
+		}
+	})
+
+	if err := session.Submit(context.Background(), "search"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if cached["call-2"] || cached["call-3"] {
+		t.Errorf("expected searches to run every time, got %v", cached)
+	}
+	results := session.History[2].(*ToolResultsTurn).Results
+	if !strings.Contains(results[2].Content, "needle two") {
+		t.Errorf("expected the second grep to see the edit, got %q", results[2].Content)
+	}
+}
+
+func TestSessionStaleEdit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("one two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	edit := json.RawMessage(`{"path":"a.txt","old_string":"two","new_string":"2"}`)
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "read_file", Arguments: json.RawMessage(`{"path":"a.txt"}`)},
+					{ID: "call-2", Name: "edit_file", Arguments: edit},
+					{ID: "call-3", Name: "read_file", Arguments: json.RawMessage(`{"path":"./a.txt"}`)},
+					{ID: "call-4", Name: "edit_file", Arguments: edit},
+					{ID: "call-5", Name: "edit_file", Arguments: json.RawMessage(`{"path":"a.txt","old_string":"2","new_string":"II"}`)},
+				},
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), DefaultSessionConfig())
+	session.Hooks.BeforeToolCall = func(ctx context.Context, call llm.ToolCall) (*llm.ToolResult, error) {
+		if call.ID == "call-2" {
+			os.WriteFile(path, []byte("zero one two"), 0o644)
+		}
+		return nil, nil
+	}
+
+	if err := session.Submit(context.Background(), "edit a.txt"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	results := session.History[2].(*ToolResultsTurn).Results
+	if !results[1].IsError || !strings.Contains(results[1].Content, "a.txt has changed since you last read it") {
+		t.Errorf("expected the stale edit to be rejected, got %+v", results[1])
+	}
+	if results[3].IsError || results[4].IsError {
+		t.Errorf("expected edits after a re-read and after the agent's own edit to succeed, got %+v", results[3:])
+	}
+	if data, _ := os.ReadFile(path); string(data) != "zero one II" {
+		t.Errorf("expected zero one II, got %q", data)
+	}
+}
+
+func TestSessionRollback(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "alpha\n", "b.txt": "beta\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "write_file", Arguments: json.RawMessage(`{"path":"a.txt","content":"broken\n"}`)},
+					{ID: "call-2", Name: "edit_file", Arguments: json.RawMessage(`{"path":"b.txt","old_string":"beta","new_string":"BETA"}`)},
+					{ID: "call-3", Name: "write_file", Arguments: json.RawMessage(`{"path":"sub/new.txt","content":"new\n"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-4", Name: "revert_changes", Arguments: json.RawMessage(`{"paths":["a.txt"]}`)},
+					{ID: "call-5", Name: "revert_changes", Arguments: json.RawMessage(`{"paths":["c.txt"]}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), DefaultSessionConfig())
+
+	if err := session.Submit(context.Background(), "try something"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+	if got := read("a.txt"); got != "alpha\n" {
+		t.Errorf("expected revert_changes to restore a.txt, got %q", got)
+	}
+	if got := read("b.txt"); got != "BETA\n" {
+		t.Errorf("expected b.txt to keep its edit until rollback, got %q", got)
+	}
+	last := session.History[len(session.History)-2].(*ToolResultsTurn)
+	if !last.Results[1].IsError {
+		t.Error("expected reverting an untouched file to fail")
+	}
+
+	if err := session.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := read("b.txt"); got != "beta\n" {
+		t.Errorf("expected b.txt restored, got %q", got)
+	}
+	if got := read("sub/new.txt"); got != "<missing>" {
+		t.Errorf("expected created file removed, got %q", got)
+	}
+	if changes := session.Changes(); len(changes.Files) != 0 {
+		t.Errorf("expected no changes after rollback, got %+v", changes.Files)
+	}
+
+	unsupported := NewSession(client, DefaultAnthropicProfile("test-model"), &mockEnv{}, DefaultSessionConfig())
+	if err := unsupported.Rollback(); err == nil {
+		t.Error("expected Rollback to fail for an environment without file access")
+	}
+}
+
+func TestPromptBuilder(t *testing.T) {
+	dir := t.TempDir()
+	agentsPath := filepath.Join(dir, "AGENTS.md")
+	if err := os.WriteFile(agentsPath, []byte("Run make test before finishing."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "CLAUDE.md"), []byte("Prefer table-driven tests."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	profile := DefaultAnthropicProfile("test-model")
+	b := NewPromptBuilder(dir)
+
+	prompt, err := b.Build(profile)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if prompt != BuildSystemPrompt(profile, dir, "") {
+		t.Error("expected default template to match BuildSystemPrompt")
+	}
+	for _, want := range []string{profile.SystemPrompt, "Working directory: " + dir, "**read_file**", "Run make test", "Prefer table-driven tests."} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected prompt to contain %q", want)
+		}
+	}
+
+	// Cached until Refresh.
+	if err := os.WriteFile(agentsPath, []byte("Use make check instead."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if prompt, _ := b.Build(profile); !strings.Contains(prompt, "Run make test") {
+		t.Error("expected cached prompt before Refresh")
+	}
+	b.Refresh()
+	if prompt, _ := b.Build(profile); !strings.Contains(prompt, "Use make check instead.") {
+		t.Error("expected updated AGENTS.md after Refresh")
+	}
+
+	if err := b.SetTemplate("{{.Model}} on {{.Provider}}\n{{.ProjectDocs}}"); err != nil {
+		t.Fatalf("SetTemplate failed: %v", err)
+	}
+	prompt, _ = b.Build(profile)
+	if !strings.HasPrefix(prompt, "test-model on anthropic\n# Project Instructions (AGENTS.md)") {
+		t.Errorf("unexpected custom prompt %q", prompt)
+	}
+	if err := b.SetTemplate("{{.Missing"); err == nil {
+		t.Error("expected parse error for invalid template")
+	}
+
+	adapter := &mockLLMAdapter{}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, profile, &mockEnv{}, DefaultSessionConfig())
+	session.Prompt = b
+	if err := session.Submit(context.Background(), "hi"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if got := adapter.requests[0].SystemPrompt; got != prompt {
+		t.Errorf("expected session to use built prompt, got %q", got)
+	}
+}
+
+func TestSessionExportImport(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"echo hunter2"}`)},
+				},
+				Usage:     llm.Usage{InputTokens: 10, OutputTokens: 5},
+				CreatedAt: time.Now(),
+			},
+			{
+				Content:      "The password is hunter2.",
+				FinishReason: llm.FinishReasonStop,
+				Usage:        llm.Usage{InputTokens: 20, OutputTokens: 7},
+				CreatedAt:    time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	env := &mockEnv{results: map[string]string{"bash": "hunter2\n```\ndone"}}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env, DefaultSessionConfig())
+	if err := session.Submit(context.Background(), "print the password"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	redact := func(s string) string { return strings.ReplaceAll(s, "hunter2", "[REDACTED]") }
+
+	var md strings.Builder
+	if err := session.Export(&md, ExportOptions{Format: ExportMarkdown, Redact: redact}); err != nil {
+		t.Fatalf("markdown export failed: %v", err)
+	}
+	for _, want := range []string{"## User\n\nprint the password", "**Tool call** `bash` (call-1)", "````\n[REDACTED]\n```\ndone\n````", "The password is [REDACTED]."} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("expected markdown to contain %q, got:\n%s", want, md.String())
+		}
+	}
+	if strings.Contains(md.String(), "hunter2") {
+		t.Error("expected secret to be redacted from markdown")
+	}
+
+	var buf strings.Builder
+	if err := session.Export(&buf, ExportOptions{Redact: redact}); err != nil {
+		t.Fatalf("JSON export failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Error("expected secret to be redacted from JSON")
+	}
+
+	transcript, err := ImportTranscript(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("ImportTranscript failed: %v", err)
+	}
+	if len(transcript.Turns) != len(session.History) {
+		t.Fatalf("expected %d turns, got %d", len(session.History), len(transcript.Turns))
+	}
+	if transcript.Usage.Total.InputTokens != 30 {
+		t.Errorf("expected 30 input tokens in transcript, got %d", transcript.Usage.Total.InputTokens)
+	}
+
+	responses := transcript.Responses()
+	if len(responses) != 2 || responses[0].FinishReason != llm.FinishReasonToolCalls || responses[1].Content != "The password is [REDACTED]." {
+		t.Errorf("unexpected replay responses: %+v", responses)
+	}
+
+	restored := NewSession(client, DefaultAnthropicProfile("test-model"), env, DefaultSessionConfig())
+	restored.Restore(transcript)
+	if len(restored.History) != len(session.History) {
+		t.Errorf("expected restored history of %d turns, got %d", len(session.History), len(restored.History))
+	}
+	if restored.Usage().Total.OutputTokens != 12 {
+		t.Errorf("expected restored usage of 12 output tokens, got %d", restored.Usage().Total.OutputTokens)
+	}
+
+	if _, err := ImportTranscript(strings.NewReader(`{"version":1,"turns":[{"type":"bogus"}]}`)); err == nil {
+		t.Error("expected error for unknown turn type")
+	}
+}
+
+func TestSessionDiagnosticsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	localEnv := env.NewLocalEnvironment(dir)
+	var checked []string
+	localEnv.Checkers = []env.Checker{{
+		Name:       "fake",
+		Extensions: []string{".go", ".md"},
+		Run: func(_ context.Context, _ string, _ []string, files []string) ([]env.Diagnostic, error) {
+			checked = files
+			return nil, nil
+		},
+	}}
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "diagnostics", Arguments: json.RawMessage(`{}`)},
+					{ID: "call-2", Name: "write_file", Arguments: json.RawMessage(`{"path":"main.go","content":"package main\n"}`)},
+					{ID: "call-3", Name: "write_file", Arguments: json.RawMessage(`{"path":"README.md","content":"# Demo\n"}`)},
+					{ID: "call-4", Name: "diagnostics", Arguments: json.RawMessage(`{}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), localEnv, DefaultSessionConfig())
+
+	outputs := make(map[string]string)
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolCallCompleted {
+			outputs[e.Data["tool_id"].(string)] = e.Data["output"].(string)
+		}
+	})
+	if err := session.Submit(context.Background(), "check"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if outputs["call-1"] != "No files changed in this session." {
+		t.Errorf("unexpected first result: %q", outputs["call-1"])
+	}
+	if outputs["call-4"] != "No problems found." {
+		t.Errorf("unexpected second result: %q", outputs["call-4"])
+	}
+	if strings.Join(checked, ",") != "main.go,README.md" {
+		t.Errorf("checked %v, want the changed files", checked)
+	}
+}
+
+func TestSessionPostEditHooks(t *testing.T) {
+	dir := t.TempDir()
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "write_file", Arguments: json.RawMessage(`{"path":"main.go","content":"package main\n"}`)},
+					{ID: "call-2", Name: "write_file", Arguments: json.RawMessage(`{"path":"notes.txt","content":"hi\n"}`)},
+					{ID: "call-3", Name: "edit_file", Arguments: json.RawMessage(`{"path":"main.go","old_string":"// formatted","new_string":"// done"}`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.PostEditHooks = []PostEditHook{
+		{Command: "echo '// formatted' >> {path} && echo checked {path}", Pattern: "*.go"},
+	}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), env.NewLocalEnvironment(dir), config)
+
+	outputs := make(map[string]string)
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolCallCompleted {
+			outputs[e.Data["tool_id"].(string)] = e.Data["output"].(string)
+		}
+	})
+	if err := session.Submit(context.Background(), "write"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if !strings.HasSuffix(outputs["call-1"], "[post-edit: echo '// formatted' >> main.go && echo checked main.go]\nchecked main.go") {
+		t.Errorf("expected hook output in result, got %q", outputs["call-1"])
+	}
+	if strings.Contains(outputs["call-2"], "post-edit") {
+		t.Errorf("hook ran for a file its pattern excludes: %q", outputs["call-2"])
+	}
+	// The hook rewrote main.go, but the edit is not rejected as stale.
+	if !strings.HasPrefix(outputs["call-3"], "Edited main.go") {
+		t.Errorf("edit after hook failed: %q", outputs["call-3"])
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "main.go"))
+	if string(data) != "package main\n// done\n// formatted\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+// overflowAdapter fails with a context-length error while any tool result in
+// the request is longer than limit bytes.
+type overflowAdapter struct {
+	mockLLMAdapter
+	limit int
+}
+
+func (a *overflowAdapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	for _, m := range req.Messages {
+		if m.Role == llm.RoleTool && len(m.Content) > a.limit {
+			a.requests = append(a.requests, req)
+			return nil, &llm.LLMError{Type: llm.ErrorTypeContextLength, Message: "prompt is too long", StatusCode: 400, Provider: "mock"}
+		}
+	}
+	return a.mockLLMAdapter.Complete(ctx, req)
+}
+
+func TestSessionContextOverflowRecovery(t *testing.T) {
+	adapter := &overflowAdapter{
+		limit: 200,
+		mockLLMAdapter: mockLLMAdapter{responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls:    []llm.ToolCall{{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"cat big.log"}`)}},
+				CreatedAt:    time.Now(),
+			},
+		}},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	tenv := &mockEnv{results: map[string]string{"bash": strings.Repeat("x", 500)}}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), tenv, DefaultSessionConfig())
+
+	var compacted []Event
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventContextCompacted {
+			compacted = append(compacted, e)
+		}
+	})
+	if err := session.Submit(context.Background(), "read the log"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if len(compacted) != 1 {
+		t.Fatalf("expected 1 context_compacted event, got %d", len(compacted))
+	}
+	if ids := compacted[0].Data["dropped_calls"].([]string); len(ids) != 1 || ids[0] != "call-1" {
+		t.Errorf("unexpected dropped calls %v", ids)
+	}
+	last := adapter.requests[len(adapter.requests)-1]
+	if got := last.Messages[len(last.Messages)-1].Content; got != droppedOutputNote {
+		t.Errorf("expected the tool output to be replaced, got %q", got)
+	}
+
+	// With nothing left to drop, the error is returned.
+	adapter.limit = 0
+	if err := session.Submit(context.Background(), "again"); !llm.IsContextLengthError(err) {
+		t.Errorf("expected a context length error, got %v", err)
+	}
+}
+
+// tokenCountingAdapter counts the tokens of a request with count.
+type tokenCountingAdapter struct {
+	mockLLMAdapter
+	count func(req *llm.Request) int
+}
+
+func (a *tokenCountingAdapter) CountTokens(ctx context.Context, req *llm.Request) (int, error) {
+	return a.count(req), nil
+}
+
+func TestSessionBudgetPreflight(t *testing.T) {
+	adapter := &tokenCountingAdapter{
+		mockLLMAdapter: mockLLMAdapter{responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls:    []llm.ToolCall{{ID: "call-1", Name: "shell", Arguments: json.RawMessage(`{"command":"ls"}`)}},
+				Usage:        llm.Usage{InputTokens: 900, OutputTokens: 200},
+			},
+			{Content: "Stopping here.", FinishReason: llm.FinishReasonStop},
+		}},
+		count: func(req *llm.Request) int { return 4000 },
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	config := DefaultSessionConfig()
+	config.MaxTotalTokens = 5000
+	session := NewSession(client, DefaultOpenAIProfile("test-model"), &mockEnv{results: map[string]string{}}, config)
+
+	err := session.Submit(context.Background(), "list files")
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected BudgetExceededError, got %v", err)
+	}
+	// 1100 tokens used plus a 4000-token prompt would pass the limit.
+	if budgetErr.Used != 5100 {
+		t.Errorf("Used = %v, want 5100", budgetErr.Used)
+	}
+	if len(adapter.requests) != 2 || len(adapter.requests[1].Tools) != 0 {
+		t.Errorf("expected the first turn and a summary request, got %d requests", len(adapter.requests))
+	}
+}
+
+func TestSessionPreflightCompaction(t *testing.T) {
+	adapter := &tokenCountingAdapter{
+		mockLLMAdapter: mockLLMAdapter{responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls:    []llm.ToolCall{{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command":"cat big.log"}`)}},
+				Usage:        llm.Usage{InputTokens: 950},
+			},
+		}},
+		count: func(req *llm.Request) int {
+			for _, m := range req.Messages {
+				if m.Role == llm.RoleTool && len(m.Content) > 200 {
+					return 1500
+				}
+			}
+			return 500
+		},
+	}
+	catalog := llm.NewModelCatalog(llm.ModelInfo{ID: "test-model", Provider: "mock", ContextWindow: 1000})
+	client := llm.NewClient(llm.WithProvider("mock", adapter), llm.WithCatalog(catalog))
+	tenv := &mockEnv{results: map[string]string{"bash": strings.Repeat("x", 500)}}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), tenv, DefaultSessionConfig())
+
+	var compacted int
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventContextCompacted {
+			compacted++
+		}
+	})
+	if err := session.Submit(context.Background(), "read the log"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if compacted != 1 {
+		t.Errorf("expected 1 context_compacted event, got %d", compacted)
+	}
+	if len(adapter.requests) != 2 {
+		t.Fatalf("expected 2 LLM calls, got %d", len(adapter.requests))
+	}
+	for _, m := range adapter.requests[1].Messages {
+		if m.Role == llm.RoleTool && m.Content != droppedOutputNote {
+			t.Errorf("tool output sent without compaction: %d bytes", len(m.Content))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// toolPath returns the cleaned "path" argument of a tool call, or "" if it
+// has none.
+func toolPath(arguments json.RawMessage) string {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal(arguments, &params) != nil || params.Path == "" {
+		return ""
+	}
+	return filepath.Clean(params.Path)
+}
+
+// fileHash returns the SHA-256 of path's current content, or false if the
+// environment cannot read it.
+func (s *Session) fileHash(path string) ([sha256.Size]byte, bool) {
+	fr, ok := s.ExecutionEnv.(FileReader)
+	if !ok {
+		return [sha256.Size]byte{}, false
+	}
+	data, err := fr.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256(data), true
+}
+
+// recordFileVersion remembers the content of the file a successful read or
+// write call (read_file, notebook_read, or a file write tool) saw, so a
+// later edit can tell whether it changed since.
+func (s *Session) recordFileVersion(toolName string, arguments json.RawMessage) {
+	if toolName != "read_file" && toolName != "notebook_read" && !isFileWriteTool(toolName) {
+		return
+	}
+	if path := toolPath(arguments); path != "" {
+		s.recordPathVersion(path)
+	}
+}
+
+// recordPathVersion remembers the current content of path as seen by the
+// agent.
+func (s *Session) recordPathVersion(path string) {
+	path = filepath.Clean(path)
+	hash, ok := s.fileHash(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok {
+		delete(s.readHashes, path)
+		return
+	}
+	if s.readHashes == nil {
+		s.readHashes = make(map[string][sha256.Size]byte)
+	}
+	s.readHashes[path] = hash
+}
+
+// checkStaleRead rejects an edit_file or notebook_edit call on a file whose
+// content changed since the agent last read or wrote it, as an edit based
+// on an outdated view could corrupt it. Files the agent never read are not
+// checked.
+func (s *Session) checkStaleRead(toolName string, arguments json.RawMessage) error {
+	if toolName != "edit_file" && toolName != "notebook_edit" {
+		return nil
+	}
+	return s.checkStalePath(toolPath(arguments))
+}
+
+// checkStalePath is checkStaleRead for a single path.
+func (s *Session) checkStalePath(path string) error {
+	path = filepath.Clean(path)
+	s.mu.Lock()
+	seen, ok := s.readHashes[path]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if current, ok := s.fileHash(path); ok && current != seen {
+		return fmt.Errorf("%s has changed since you last read it; read it again with read_file before editing", path)
+	}
+	return nil
+}
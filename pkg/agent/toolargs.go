@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// InvalidToolArgumentsError is returned, as the tool result, for a tool call
+// whose arguments are not a JSON object and could not be repaired. The model
+// is asked to send the call again.
+type InvalidToolArgumentsError struct {
+	ToolName  string
+	Arguments string
+	Err       error
+}
+
+func (e *InvalidToolArgumentsError) Error() string {
+	return fmt.Sprintf("the arguments of this %s call are not valid JSON (%v); the call was not run. Send it again with the arguments as a single JSON object", e.ToolName, e.Err)
+}
+
+func (e *InvalidToolArgumentsError) Unwrap() error {
+	return e.Err
+}
+
+// ToolArgumentStats counts tool calls whose arguments were not valid JSON.
+type ToolArgumentStats struct {
+	// Repaired calls ran with arguments fixed by the session.
+	Repaired int `json:"repaired"`
+	// Rejected calls were answered with an InvalidToolArgumentsError.
+	Rejected int `json:"rejected"`
+	// ByTool counts repaired and rejected calls per tool name.
+	ByTool map[string]int `json:"by_tool,omitempty"`
+}
+
+// ToolArgumentStats returns how many tool calls in the session had invalid
+// JSON arguments and what became of them.
+func (s *Session) ToolArgumentStats() ToolArgumentStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.argStats
+	if stats.ByTool != nil {
+		byTool := make(map[string]int, len(stats.ByTool))
+		for k, v := range stats.ByTool {
+			byTool[k] = v
+		}
+		stats.ByTool = byTool
+	}
+	return stats
+}
+
+// checkToolArguments repairs the arguments of tc in place when they are not
+// a JSON object, or replaces them with {} and returns an
+// InvalidToolArgumentsError when they cannot be repaired. Either way the
+// history then holds valid JSON, which providers require when the call is
+// sent back. It emits EventToolArgumentsInvalid for any invalid arguments.
+func (s *Session) checkToolArguments(tc *llm.ToolCall) error {
+	if isJSONObject(tc.Arguments) {
+		return nil
+	}
+	original := string(tc.Arguments)
+	repaired, ok := repairJSONObject(original)
+
+	var argErr *InvalidToolArgumentsError
+	if ok {
+		tc.Arguments = json.RawMessage(repaired)
+	} else {
+		var v interface{}
+		err := json.Unmarshal([]byte(original), &v)
+		if err == nil {
+			err = fmt.Errorf("expected an object, got %s", strings.TrimSpace(original))
+		}
+		argErr = &InvalidToolArgumentsError{ToolName: tc.Name, Arguments: original, Err: err}
+		tc.Arguments = json.RawMessage(`{}`)
+	}
+
+	s.mu.Lock()
+	if ok {
+		s.argStats.Repaired++
+	} else {
+		s.argStats.Rejected++
+	}
+	if s.argStats.ByTool == nil {
+		s.argStats.ByTool = make(map[string]int)
+	}
+	s.argStats.ByTool[tc.Name]++
+	s.mu.Unlock()
+
+	data := map[string]interface{}{
+		"tool_name": tc.Name,
+		"tool_id":   tc.ID,
+		"arguments": original,
+		"repaired":  ok,
+	}
+	if ok {
+		data["repaired_arguments"] = repaired
+	}
+	s.EventEmitter.Emit(Event{
+		Type:      EventToolArgumentsInvalid,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	if argErr != nil {
+		return argErr
+	}
+	return nil
+}
+
+// isJSONObject reports whether raw is a JSON object.
+func isJSONObject(raw json.RawMessage) bool {
+	var obj map[string]json.RawMessage
+	return json.Unmarshal(raw, &obj) == nil && obj != nil
+}
+
+// repairJSONObject fixes the cosmetic mistakes models commonly make in tool
+// arguments: empty arguments, a markdown code fence around them, an object
+// encoded as a JSON string, and trailing commas. It reports false if the
+// result is still not a JSON object. Arguments cut off before their closing
+// quotes and brackets, as at max_tokens, are not repaired: closing them
+// would run the call with truncated content, such as half a file.
+func repairJSONObject(raw string) (string, bool) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "{}", true
+	}
+	if strings.HasPrefix(s, "```") {
+		s = strings.TrimPrefix(s, "```")
+		s = strings.TrimPrefix(s, "json")
+		s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "```"))
+	}
+	var inner string
+	if json.Unmarshal([]byte(s), &inner) == nil {
+		s = strings.TrimSpace(inner)
+	}
+	s = removeTrailingCommas(s)
+	if !isJSONObject(json.RawMessage(s)) {
+		return "", false
+	}
+	return s, true
+}
+
+// removeTrailingCommas drops commas that directly precede a closing bracket
+// outside of strings.
+func removeTrailingCommas(s string) string {
+	var sb strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			rest := strings.TrimLeft(s[i+1:], " \t\r\n")
+			if rest == "" || rest[0] == '}' || rest[0] == ']' {
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+func TestRepairJSONObject(t *testing.T) {
+	tests := []struct {
+		raw, want string
+		ok        bool
+	}{
+		{"", "{}", true},
+		{"```json\n{\"path\": \"a.go\"}\n```", `{"path": "a.go"}`, true},
+		{`"{\"path\": \"a.go\"}"`, `{"path": "a.go"}`, true},
+		{`{"paths": ["a", "b",], }`, `{"paths": ["a", "b"] }`, true},
+		{`{"command": "echo a,}", "x": 1,}`, `{"command": "echo a,}", "x": 1}`, true},
+		// Truncated arguments are rejected, not closed and run.
+		{`{"path": "a.go", "content": "line one\n`, "", false},
+		{`{"edits": [{"path": "a.go"`, "", false},
+		{`path=a.go`, "", false},
+		{`["a.go"]`, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := repairJSONObject(tt.raw)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("repairJSONObject(%q) = %q, %v; want %q, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSessionInvalidToolArguments(t *testing.T) {
+	adapter := &mockLLMAdapter{
+		responses: []*llm.Response{
+			{
+				FinishReason: llm.FinishReasonToolCalls,
+				ToolCalls: []llm.ToolCall{
+					{ID: "call-1", Name: "bash", Arguments: json.RawMessage(`{"command": "ls",}`)},
+					{ID: "call-2", Name: "bash", Arguments: json.RawMessage(`command: ls`)},
+				},
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+	client := llm.NewClient(llm.WithProvider("mock", adapter))
+	var executed []string
+	tenv := &recordingEnv{onExecute: func(args json.RawMessage) { executed = append(executed, string(args)) }}
+	session := NewSession(client, DefaultAnthropicProfile("test-model"), tenv, DefaultSessionConfig())
+
+	var invalid []Event
+	session.EventEmitter.On(func(e Event) {
+		if e.Type == EventToolArgumentsInvalid {
+			invalid = append(invalid, e)
+		}
+	})
+	if err := session.Submit(context.Background(), "list files"); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if len(executed) != 1 || executed[0] != `{"command": "ls"}` {
+		t.Errorf("expected only the repaired call to run, got %v", executed)
+	}
+	if len(invalid) != 2 || invalid[0].Data["repaired"] != true || invalid[1].Data["repaired"] != false {
+		t.Errorf("unexpected events %+v", invalid)
+	}
+	stats := session.ToolArgumentStats()
+	if stats.Repaired != 1 || stats.Rejected != 1 || stats.ByTool["bash"] != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// The history holds valid JSON, and the rejected call's result asks
+	// the model to try again.
+	assistant := session.History[1].(*AssistantTurn)
+	if string(assistant.ToolCalls[1].Arguments) != "{}" {
+		t.Errorf("expected rejected arguments replaced with {}, got %s", assistant.ToolCalls[1].Arguments)
+	}
+	result := session.History[2].(*ToolResultsTurn).Results[1]
+	if !result.IsError || !containsStr(result.Content, "Send it again") {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	var argErr *InvalidToolArgumentsError
+	if err := session.checkToolArguments(&llm.ToolCall{Name: "bash", Arguments: json.RawMessage(`nope`)}); !errors.As(err, &argErr) || argErr.Arguments != "nope" {
+		t.Errorf("expected an InvalidToolArgumentsError, got %v", err)
+	}
+}
+
+// recordingEnv reports the arguments of each call to onExecute.
+type recordingEnv struct {
+	onExecute func(json.RawMessage)
+}
+
+func (e *recordingEnv) Tools() []llm.Tool { return nil }
+
+func (e *recordingEnv) Execute(ctx context.Context, toolName string, arguments json.RawMessage) (string, error) {
+	e.onExecute(arguments)
+	return "ok", nil
+}
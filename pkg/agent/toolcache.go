@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// FileStater is implemented by execution environments that can report when a
+// file or directory last changed. The tool result cache uses it to notice
+// changes made outside the session; without it, cached results are only
+// dropped when the agent itself writes.
+type FileStater interface {
+	ModTime(path string) (time.Time, error)
+}
+
+// isReadOnlyTool reports whether a tool only reads files, so calling it
+// leaves cached results valid.
+func isReadOnlyTool(name string) bool {
+	switch name {
+	case "read_file", "grep", "glob", "search_code", "notebook_read":
+		return true
+	}
+	return false
+}
+
+// isCacheableTool reports whether a tool's result can be reused for
+// identical arguments: it reads a single file, whose modification time shows
+// when the result goes stale. Searches are not cached, since a directory's
+// modification time misses edits to the files beneath it.
+func isCacheableTool(name string) bool {
+	switch name {
+	case "read_file", "notebook_read":
+		return true
+	}
+	return false
+}
+
+// cachedResult is a tool result and the modification time of the file it
+// read when it was produced.
+type cachedResult struct {
+	output  string
+	path    string
+	modTime time.Time
+}
+
+// cachedToolResult returns the cached result of an identical earlier call,
+// if caching is enabled and the file it read is unchanged.
+func (s *Session) cachedToolResult(tc llm.ToolCall) (string, bool) {
+	if !s.Config.CacheToolResults || !isCacheableTool(tc.Name) {
+		return "", false
+	}
+	key := toolCacheKey(tc)
+	s.mu.Lock()
+	entry, ok := s.toolCache[key]
+	s.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	if modTime, _ := s.toolModTime(entry.path); !modTime.Equal(entry.modTime) {
+		s.mu.Lock()
+		delete(s.toolCache, key)
+		s.mu.Unlock()
+		return "", false
+	}
+	return entry.output, true
+}
+
+// updateToolCache records the result of a successful cacheable call, or
+// clears the cache after any call that may change files: write_file,
+// edit_file, bash, and every other tool that is not read-only.
+func (s *Session) updateToolCache(tc llm.ToolCall, output string, err error) {
+	if !s.Config.CacheToolResults {
+		return
+	}
+	if !isReadOnlyTool(tc.Name) {
+		s.mu.Lock()
+		s.toolCache = nil
+		s.mu.Unlock()
+		return
+	}
+	if err != nil || !isCacheableTool(tc.Name) {
+		return
+	}
+	var params struct {
+		Path string `json:"path"`
+	}
+	json.Unmarshal(tc.Arguments, &params)
+	path := params.Path
+	if path == "" {
+		path = "."
+	}
+	modTime, statErr := s.toolModTime(path)
+	if statErr != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.toolCache == nil {
+		s.toolCache = make(map[string]cachedResult)
+	}
+	s.toolCache[toolCacheKey(tc)] = cachedResult{output: output, path: path, modTime: modTime}
+}
+
+// toolModTime returns the modification time of path, or the zero time if the
+// environment cannot report it.
+func (s *Session) toolModTime(path string) (time.Time, error) {
+	fs, ok := s.ExecutionEnv.(FileStater)
+	if !ok {
+		return time.Time{}, nil
+	}
+	return fs.ModTime(path)
+}
+
+// toolCacheKey identifies a call by tool name and arguments, ignoring
+// argument whitespace and key order.
+func toolCacheKey(tc llm.ToolCall) string {
+	var args interface{}
+	if json.Unmarshal(tc.Arguments, &args) == nil {
+		if canonical, err := json.Marshal(args); err == nil {
+			return tc.Name + "\x00" + string(canonical)
+		}
+	}
+	return tc.Name + "\x00" + string(bytes.TrimSpace(tc.Arguments))
+}
@@ -11,7 +11,7 @@ import (
 func ReadFile() llm.Tool {
 	return llm.Tool{
 		Name:        "read_file",
-		Description: "Read the contents of a file at the given path. Returns the file contents as a string.",
+		Description: "Read the contents of a file at the given path. Returns the file contents as a string. Binary files are summarized (size, type, image dimensions, and a hex dump of the start) instead, and lines over 2000 characters are truncated.",
 		Parameters: json.RawMessage(`{
 			"type": "object",
 			"properties": {
@@ -81,6 +81,98 @@ func EditFile() llm.Tool {
 	}
 }
 
+// MultiEdit returns the multi_edit tool definition. It applies a batch of
+// literal or regex replacements across files, all or nothing.
+func MultiEdit() llm.Tool {
+	return llm.Tool{
+		Name:        "multi_edit",
+		Description: "Apply several find/replace edits, across one or more files, in one call. Edits run in order and are all applied or, if any fails, none are. Set replace_all to replace every match instead of requiring exactly one, and regex to treat old_string as a Go regular expression whose capture groups new_string can use as $1 or ${name}. Set dry_run to get a diff of the changes without writing them.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"edits": {
+					"type": "array",
+					"description": "The edits to apply, in order",
+					"items": {
+						"type": "object",
+						"properties": {
+							"path": {"type": "string", "description": "The path to the file to edit"},
+							"old_string": {"type": "string", "description": "The text, or with regex the pattern, to find"},
+							"new_string": {"type": "string", "description": "The replacement text"},
+							"replace_all": {"type": "boolean", "description": "Replace every match (default: exactly one match is required)"},
+							"regex": {"type": "boolean", "description": "Treat old_string as a regular expression"}
+						},
+						"required": ["path", "old_string", "new_string"]
+					}
+				},
+				"dry_run": {
+					"type": "boolean",
+					"description": "Return a diff of the edits without changing any file"
+				}
+			},
+			"required": ["edits"]
+		}`),
+	}
+}
+
+// NotebookRead returns the notebook_read tool definition.
+func NotebookRead() llm.Tool {
+	return llm.Tool{
+		Name:        "notebook_read",
+		Description: "Read a Jupyter notebook (.ipynb), showing each cell's index, ID, type, source, and outputs. Use this instead of read_file for notebooks.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"path": {
+					"type": "string",
+					"description": "The path to the notebook"
+				}
+			},
+			"required": ["path"]
+		}`),
+	}
+}
+
+// NotebookEdit returns the notebook_edit tool definition.
+func NotebookEdit() llm.Tool {
+	return llm.Tool{
+		Name:        "notebook_edit",
+		Description: "Edit one cell of a Jupyter notebook (.ipynb) while keeping the notebook valid. Replace a cell's source (clearing a code cell's outputs), insert a new cell, or delete a cell. Use this instead of edit_file for notebooks.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"path": {
+					"type": "string",
+					"description": "The path to the notebook"
+				},
+				"cell_id": {
+					"type": "string",
+					"description": "The ID of the cell to edit; for insert, the new cell goes after it"
+				},
+				"index": {
+					"type": "integer",
+					"description": "The 0-based index of the cell to edit, or the position to insert at, when cell_id is not given"
+				},
+				"source": {
+					"type": "string",
+					"description": "The new cell source (ignored for delete)"
+				},
+				"cell_type": {
+					"type": "string",
+					"enum": ["code", "markdown", "raw"],
+					"description": "The cell type; required for insert"
+				},
+				"edit_mode": {
+					"type": "string",
+					"enum": ["replace", "insert", "delete"],
+					"description": "What to do with the cell (default: replace)"
+				}
+			},
+			"required": ["path"]
+		}`),
+	}
+}
+
 // Bash returns the bash tool definition.
 func Bash() llm.Tool {
 	return llm.Tool{
@@ -107,17 +199,30 @@ func Bash() llm.Tool {
 func GlobSearch() llm.Tool {
 	return llm.Tool{
 		Name:        "glob",
-		Description: "Search for files matching a glob pattern.",
+		Description: "Search for files matching a glob pattern, where ** matches any number of directories. Files ignored by .gitignore are skipped. Use sort_by \"mtime\" to list the most recently changed files first.",
 		Parameters: json.RawMessage(`{
 			"type": "object",
 			"properties": {
 				"pattern": {
 					"type": "string",
-					"description": "The glob pattern to match (e.g., '**/*.go')"
+					"description": "The glob pattern to match (e.g., '**/*.go' or 'src/**/*_test.go')"
 				},
 				"path": {
 					"type": "string",
 					"description": "The directory to search in"
+				},
+				"sort_by": {
+					"type": "string",
+					"enum": ["path", "mtime"],
+					"description": "Sort by path (default) or by modification time, newest first"
+				},
+				"limit": {
+					"type": "integer",
+					"description": "Maximum number of results (default: all)"
+				},
+				"include_ignored": {
+					"type": "boolean",
+					"description": "Include files ignored by .gitignore"
 				}
 			},
 			"required": ["pattern"]
@@ -129,7 +234,7 @@ func GlobSearch() llm.Tool {
 func GrepSearch() llm.Tool {
 	return llm.Tool{
 		Name:        "grep",
-		Description: "Search file contents for a regex pattern.",
+		Description: "Search file contents for a regex pattern. Prints matching lines as path:line:text (context lines as path-line-text), the matching files, or per-file match counts. Hidden and binary files are skipped, and results are capped at max_results.",
 		Parameters: json.RawMessage(`{
 			"type": "object",
 			"properties": {
@@ -144,9 +249,127 @@ func GrepSearch() llm.Tool {
 				"glob": {
 					"type": "string",
 					"description": "Glob pattern to filter files"
+				},
+				"output_mode": {
+					"type": "string",
+					"enum": ["content", "files_with_matches", "count"],
+					"description": "content shows matching lines (default), files_with_matches lists the files, count shows matches per file"
+				},
+				"case_insensitive": {
+					"type": "boolean",
+					"description": "Ignore case"
+				},
+				"multiline": {
+					"type": "boolean",
+					"description": "Let the pattern match across lines, e.g. with \\n"
+				},
+				"before_context": {
+					"type": "integer",
+					"description": "Lines of context to show before each match (like grep -B)"
+				},
+				"after_context": {
+					"type": "integer",
+					"description": "Lines of context to show after each match (like grep -A)"
+				},
+				"context": {
+					"type": "integer",
+					"description": "Lines of context to show before and after each match (like grep -C)"
+				},
+				"max_results": {
+					"type": "integer",
+					"description": "Maximum matches, or files for files_with_matches and count (default: 100)"
 				}
 			},
 			"required": ["pattern"]
 		}`),
 	}
 }
+
+// SearchCode returns the search_code tool definition.
+func SearchCode() llm.Tool {
+	return llm.Tool{
+		Name:        "search_code",
+		Description: "Search the codebase for declarations and code related to a query, returning ranked snippets with file paths and line numbers. Matches function, type, and method names (camelCase and snake_case aware) before plain text. Prefer this over grep to find where something is defined.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"query": {"type": "string", "description": "Identifier or words to search for, e.g. \"parse config\" or \"ReadFile\""},
+				"path": {"type": "string", "description": "Directory to restrict the search to (default: working directory)"},
+				"limit": {"type": "integer", "description": "Maximum number of results (default: 10)"}
+			},
+			"required": ["query"]
+		}`),
+	}
+}
+
+// Diagnostics returns the diagnostics tool definition.
+func Diagnostics() llm.Tool {
+	return llm.Tool{
+		Name:        "diagnostics",
+		Description: "Check files for compile errors and warnings with the language's checker (gopls check or go vet for Go) and list the problems as path:line:col: severity: message. Run it on the files you changed before finishing to validate your edits. Without paths, checks the files changed in this session.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"paths": {"type": "array", "items": {"type": "string"}, "description": "Files to check (default: files changed in this session)"}
+			},
+			"required": []
+		}`),
+	}
+}
+
+// TodoWrite returns the todo_write tool definition. The plan is replaced as a
+// whole on every call.
+func TodoWrite() llm.Tool {
+	return llm.Tool{
+		Name:        "todo_write",
+		Description: "Create or update your task plan for the current request. Pass the complete list each time; use it to track progress on multi-step work. Keep exactly one item in_progress while working.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"todos": {
+					"type": "array",
+					"description": "The full task list",
+					"items": {
+						"type": "object",
+						"properties": {
+							"id": {"type": "string", "description": "Stable identifier for the item"},
+							"content": {"type": "string", "description": "What needs to be done"},
+							"status": {"type": "string", "enum": ["pending", "in_progress", "done"]}
+						},
+						"required": ["content", "status"]
+					}
+				}
+			},
+			"required": ["todos"]
+		}`),
+	}
+}
+
+// TodoRead returns the todo_read tool definition.
+func TodoRead() llm.Tool {
+	return llm.Tool{
+		Name:        "todo_read",
+		Description: "Read the current task plan and the status of each item.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {},
+			"required": []
+		}`),
+	}
+}
+
+// RevertChanges returns the revert_changes tool definition. It restores files
+// the agent wrote or edited during the session to their original content.
+func RevertChanges() llm.Tool {
+	return llm.Tool{
+		Name:        "revert_changes",
+		Description: "Undo file changes made during this session with write_file and edit_file, restoring the original content and deleting newly created files. Reverts all changed files unless paths is given. Use this to back out a failed approach.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"paths": {"type": "array", "items": {"type": "string"}, "description": "Files to revert (default: all changed files)"}
+			},
+			"required": []
+		}`),
+	}
+}
@@ -30,6 +30,21 @@ var allTools = []toolTestCase{
 		fn:             EditFile,
 		requiredFields: []string{"path", "old_string", "new_string"},
 	},
+	{
+		name:           "MultiEdit",
+		fn:             MultiEdit,
+		requiredFields: []string{"edits"},
+	},
+	{
+		name:           "NotebookRead",
+		fn:             NotebookRead,
+		requiredFields: []string{"path"},
+	},
+	{
+		name:           "NotebookEdit",
+		fn:             NotebookEdit,
+		requiredFields: []string{"path"},
+	},
 	{
 		name:           "Bash",
 		fn:             Bash,
@@ -45,6 +60,26 @@ var allTools = []toolTestCase{
 		fn:             GrepSearch,
 		requiredFields: []string{"pattern"},
 	},
+	{
+		name:           "SearchCode",
+		fn:             SearchCode,
+		requiredFields: []string{"query"},
+	},
+	{
+		name:           "TodoWrite",
+		fn:             TodoWrite,
+		requiredFields: []string{"todos"},
+	},
+	{
+		name:           "TodoRead",
+		fn:             TodoRead,
+		requiredFields: []string{},
+	},
+	{
+		name:           "RevertChanges",
+		fn:             RevertChanges,
+		requiredFields: []string{},
+	},
 }
 
 func TestToolDefinitions(t *testing.T) {
@@ -15,6 +15,9 @@ const (
 	StateProcessing    SessionState = "processing"
 	StateAwaitingInput SessionState = "awaiting_input"
 	StateClosed        SessionState = "closed"
+	// StateTurnLimitReached means the loop stopped at SessionConfig.MaxTurns
+	// with tool calls pending; Session.Continue resumes it.
+	StateTurnLimitReached SessionState = "turn_limit_reached"
 )
 
 // SessionConfig configures the agent session.
@@ -28,6 +31,35 @@ type SessionConfig struct {
 	EnableLoopDetection     bool              `json:"enable_loop_detection"`
 	LoopDetectionWindow     int               `json:"loop_detection_window"`
 	MaxSubagentDepth        int               `json:"max_subagent_depth"`
+	// ToolOutputStreamLimit caps the bytes streamed as output delta events per
+	// tool call (0 = unlimited). It does not affect the final tool result.
+	ToolOutputStreamLimit   int               `json:"tool_output_stream_limit,omitempty"`
+	// ContextWarningThreshold is the fraction of the model's context window
+	// (from the client's model catalog) at which EventContextWindowWarning is
+	// emitted. 0 disables the warning.
+	ContextWarningThreshold float64           `json:"context_warning_threshold,omitempty"`
+	// MaxTotalTokens and MaxCostUSD stop the session before the next LLM call
+	// once input+output tokens or estimated cost reach the limit (0 = unlimited).
+	// With MaxTotalTokens set, each prompt is counted before it is sent, and
+	// one that would cross the limit stops the session too.
+	MaxTotalTokens          int               `json:"max_total_tokens,omitempty"`
+	MaxCostUSD              float64           `json:"max_cost_usd,omitempty"`
+	// CacheToolResults reuses the results of identical read_file and
+	// notebook_read calls until the file they read changes or the agent
+	// writes, edits, or runs a command. Searches always run.
+	CacheToolResults        bool              `json:"cache_tool_results,omitempty"`
+	// EnabledTools, if set, limits the tools offered to the model to those
+	// named; DisabledTools removes tools by name. Calls to other tools fail.
+	EnabledTools            []string          `json:"enabled_tools,omitempty"`
+	DisabledTools           []string          `json:"disabled_tools,omitempty"`
+	// DiscoverEnvironmentTools also offers the execution environment's
+	// tools that the profile does not list. Off by default, so the profile
+	// alone decides which tools the model gets.
+	DiscoverEnvironmentTools bool             `json:"discover_environment_tools,omitempty"`
+	// PostEditHooks run after each successful write_file, edit_file,
+	// multi_edit, or notebook_edit call, e.g. gofmt -w {path}; their output
+	// is appended to the tool result.
+	PostEditHooks           []PostEditHook    `json:"post_edit_hooks,omitempty"`
 }
 
 // DefaultSessionConfig returns the default session configuration.
@@ -40,6 +72,8 @@ func DefaultSessionConfig() SessionConfig {
 		EnableLoopDetection:     true,
 		LoopDetectionWindow:     10,
 		MaxSubagentDepth:        1,
+		ToolOutputStreamLimit:   256 * 1024,
+		ContextWarningThreshold: 0.8,
 	}
 }
 
@@ -71,7 +105,10 @@ type AssistantTurn struct {
 	Reasoning  string         `json:"reasoning,omitempty"`
 	Usage      llm.Usage      `json:"usage"`
 	ResponseID string         `json:"response_id,omitempty"`
-	Timestamp  time.Time      `json:"timestamp"`
+	// FinishReason is why the model stopped; content_filter means the
+	// provider withheld or cut short Content.
+	FinishReason llm.FinishReason `json:"finish_reason,omitempty"`
+	Timestamp    time.Time        `json:"timestamp"`
 }
 
 func (t *AssistantTurn) turnType() string { return "assistant" }
@@ -88,17 +125,27 @@ func (t *ToolResultsTurn) turnType() string { return "tool_results" }
 type EventType string
 
 const (
-	EventSessionStarted    EventType = "session_started"
-	EventTurnStarted       EventType = "turn_started"
-	EventTurnCompleted     EventType = "turn_completed"
-	EventToolCallStarted   EventType = "tool_call_started"
-	EventToolCallCompleted EventType = "tool_call_completed"
-	EventTextDelta         EventType = "text_delta"
-	EventReasoningDelta    EventType = "reasoning_delta"
-	EventError             EventType = "error"
-	EventSessionClosed     EventType = "session_closed"
-	EventLoopDetected      EventType = "loop_detected"
-	EventSteeringApplied   EventType = "steering_applied"
+	EventSessionStarted       EventType = "session_started"
+	EventTurnStarted          EventType = "turn_started"
+	EventTurnCompleted        EventType = "turn_completed"
+	EventToolCallStarted      EventType = "tool_call_started"
+	EventToolCallCompleted    EventType = "tool_call_completed"
+	EventToolCallOutputDelta  EventType = "tool_call_output_delta"
+	EventTextDelta            EventType = "text_delta"
+	EventReasoningDelta       EventType = "reasoning_delta"
+	EventError                EventType = "error"
+	EventSessionClosed        EventType = "session_closed"
+	EventLoopDetected         EventType = "loop_detected"
+	EventSteeringApplied      EventType = "steering_applied"
+	EventPolicyViolation      EventType = "policy_violation"
+	EventPlanUpdated          EventType = "plan_updated"
+	EventContextWindowWarning EventType = "context_window_warning"
+	EventBudgetExceeded       EventType = "budget_exceeded"
+	EventSessionSummary       EventType = "session_summary"
+	EventChangesReverted      EventType = "changes_reverted"
+	EventTurnLimitReached     EventType = "turn_limit_reached"
+	EventContextCompacted     EventType = "context_compacted"
+	EventToolArgumentsInvalid EventType = "tool_arguments_invalid"
 )
 
 // Event is a single agent event.
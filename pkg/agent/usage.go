@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// ModelUsage is the token usage and estimated cost for one model.
+type ModelUsage struct {
+	Model   string    `json:"model"`
+	Calls   int       `json:"calls"`
+	Usage   llm.Usage `json:"usage"`
+	CostUSD float64   `json:"cost_usd"`
+}
+
+// UsageReport summarizes token usage across all LLM calls in a session.
+// Costs are estimated from the client's model catalog; unknown models count
+// as zero cost.
+type UsageReport struct {
+	Calls   int          `json:"calls"`
+	Total   llm.Usage    `json:"total"`
+	CostUSD float64      `json:"cost_usd"`
+	ByModel []ModelUsage `json:"by_model"`
+}
+
+// recordUsage adds the usage of one LLM call to the session totals.
+func (s *Session) recordUsage(model string, usage llm.Usage) {
+	cost := s.LLMClient.Catalog().EstimateCost(model, usage)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage == nil {
+		s.usage = make(map[string]*ModelUsage)
+	}
+	mu, ok := s.usage[model]
+	if !ok {
+		mu = &ModelUsage{Model: model}
+		s.usage[model] = mu
+	}
+	mu.Calls++
+	mu.Usage = mu.Usage.Add(usage)
+	mu.CostUSD += cost
+}
+
+// Usage returns the accumulated token usage for the session.
+func (s *Session) Usage() UsageReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r UsageReport
+	for _, mu := range s.usage {
+		r.Calls += mu.Calls
+		r.Total = r.Total.Add(mu.Usage)
+		r.CostUSD += mu.CostUSD
+		r.ByModel = append(r.ByModel, *mu)
+	}
+	sort.Slice(r.ByModel, func(i, j int) bool { return r.ByModel[i].Model < r.ByModel[j].Model })
+	return r
+}
+
+// String renders the report as a short human-readable summary.
+func (r UsageReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d calls, %d input + %d output tokens, est. $%.4f",
+		r.Calls, r.Total.InputTokens, r.Total.OutputTokens, r.CostUSD)
+	if len(r.ByModel) > 1 {
+		for _, m := range r.ByModel {
+			fmt.Fprintf(&b, "\n  %s: %d calls, %d input + %d output tokens, est. $%.4f",
+				m.Model, m.Calls, m.Usage.InputTokens, m.Usage.OutputTokens, m.CostUSD)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,211 @@
+// Package bus is an in-process event bus shared by the pipeline engine and
+// coding agent sessions, so one subscriber can follow a whole run: stage
+// transitions and the tool calls of the agents running those stages.
+package bus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Topic names a stream of events on the bus.
+type Topic string
+
+const (
+	// TopicPipeline carries pipeline engine events (pipeline/events types).
+	TopicPipeline Topic = "pipeline"
+	// TopicAgent carries coding agent session events (agent.EventType
+	// types). Events from an agent running a pipeline stage carry the
+	// stage's node ID in Data["node"].
+	TopicAgent Topic = "agent"
+)
+
+// Message is an event published on the bus.
+type Message struct {
+	Topic     Topic                  `json:"topic"`
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// Backpressure decides what Publish does when a subscriber's buffer is full.
+type Backpressure int
+
+const (
+	// Block makes Publish wait until the subscriber has room, so no
+	// message is lost but a slow subscriber slows the publisher.
+	Block Backpressure = iota
+	// DropOldest discards the oldest buffered message to make room; suited
+	// to UIs that only need recent state.
+	DropOldest
+	// DropNewest discards the message being published.
+	DropNewest
+)
+
+// DefaultBuffer is the subscription buffer size when none is given.
+const DefaultBuffer = 256
+
+// Bus fans published messages out to subscribers. It is safe for concurrent
+// use. Messages from one publisher reach each subscriber in publish order.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []*Subscription
+}
+
+// New creates an empty bus.
+func New() *Bus {
+	return &Bus{}
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*Subscription)
+
+// WithTopics limits a subscription to the given topics. Without it the
+// subscription receives every topic.
+func WithTopics(topics ...Topic) SubscribeOption {
+	return func(s *Subscription) {
+		if s.topics == nil {
+			s.topics = make(map[Topic]bool)
+		}
+		for _, t := range topics {
+			s.topics[t] = true
+		}
+	}
+}
+
+// WithBuffer sets how many messages the subscription buffers.
+func WithBuffer(n int) SubscribeOption {
+	return func(s *Subscription) {
+		s.buffer = n
+	}
+}
+
+// WithBackpressure sets what happens when the buffer is full. The default
+// is Block.
+func WithBackpressure(policy Backpressure) SubscribeOption {
+	return func(s *Subscription) {
+		s.policy = policy
+	}
+}
+
+// Subscribe registers a subscriber. Read messages from C and call Close when
+// done; a Block subscriber that stops reading stalls publishers.
+func (b *Bus) Subscribe(opts ...SubscribeOption) *Subscription {
+	s := &Subscription{bus: b, buffer: DefaultBuffer, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.buffer < 1 {
+		s.buffer = 1
+	}
+	s.ch = make(chan Message, s.buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, s)
+	return s
+}
+
+// Publish delivers msg to every subscriber of its topic, applying each
+// subscriber's backpressure policy. A zero Timestamp is set to now.
+func (b *Bus) Publish(msg Message) {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, s := range subs {
+		if s.topics == nil || s.topics[msg.Topic] {
+			s.deliver(msg)
+		}
+	}
+}
+
+func (b *Bus) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Subscription is a buffered stream of bus messages.
+type Subscription struct {
+	bus     *Bus
+	topics  map[Topic]bool
+	buffer  int
+	policy  Backpressure
+	ch      chan Message
+	dropped atomic.Int64
+
+	// mu is held for reading while delivering and for writing while
+	// closing, so ch is never closed under a sender.
+	mu        sync.RWMutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// C returns the channel messages are delivered on. It is closed by Close.
+func (s *Subscription) C() <-chan Message {
+	return s.ch
+}
+
+// Dropped returns how many messages were discarded because the buffer was
+// full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes C once any in-progress delivery returns.
+// Buffered messages can still be read from C. Close is idempotent.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.bus.remove(s)
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+func (s *Subscription) deliver(msg Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.ch <- msg:
+		default:
+			s.dropped.Add(1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- msg:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+		}
+	}
+}
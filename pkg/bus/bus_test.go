@@ -0,0 +1,137 @@
+package bus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func drain(s *Subscription) []Message {
+	var msgs []Message
+	for {
+		select {
+		case m := <-s.C():
+			msgs = append(msgs, m)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestPublishFiltersByTopic(t *testing.T) {
+	b := New()
+	all := b.Subscribe()
+	agentOnly := b.Subscribe(WithTopics(TopicAgent))
+
+	b.Publish(Message{Topic: TopicPipeline, Type: "stage_started"})
+	b.Publish(Message{Topic: TopicAgent, Type: "tool_call_started"})
+
+	if got := drain(all); len(got) != 2 {
+		t.Errorf("expected 2 messages for the unfiltered subscriber, got %d", len(got))
+	}
+	got := drain(agentOnly)
+	if len(got) != 1 || got[0].Type != "tool_call_started" {
+		t.Errorf("expected only the agent message, got %v", got)
+	}
+	if got[0].Timestamp.IsZero() {
+		t.Error("expected Publish to set a timestamp")
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	b := New()
+	oldest := b.Subscribe(WithBuffer(2), WithBackpressure(DropOldest))
+	newest := b.Subscribe(WithBuffer(2), WithBackpressure(DropNewest))
+	for _, typ := range []string{"a", "b", "c"} {
+		b.Publish(Message{Topic: TopicPipeline, Type: typ})
+	}
+
+	if got := drain(oldest); len(got) != 2 || got[0].Type != "b" || got[1].Type != "c" {
+		t.Errorf("DropOldest: expected [b c], got %v", got)
+	}
+	if oldest.Dropped() != 1 {
+		t.Errorf("DropOldest: expected 1 dropped, got %d", oldest.Dropped())
+	}
+	if got := drain(newest); len(got) != 2 || got[0].Type != "a" || got[1].Type != "b" {
+		t.Errorf("DropNewest: expected [a b], got %v", got)
+	}
+	if newest.Dropped() != 1 {
+		t.Errorf("DropNewest: expected 1 dropped, got %d", newest.Dropped())
+	}
+}
+
+func TestBlockWaitsForSubscriber(t *testing.T) {
+	b := New()
+	sub := b.Subscribe(WithBuffer(1))
+	b.Publish(Message{Type: "first"})
+
+	published := make(chan struct{})
+	go func() {
+		b.Publish(Message{Type: "second"})
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("expected Publish to block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if m := <-sub.C(); m.Type != "first" {
+		t.Errorf("expected first, got %s", m.Type)
+	}
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("expected Publish to finish once the subscriber read")
+	}
+	if m := <-sub.C(); m.Type != "second" {
+		t.Errorf("expected second, got %s", m.Type)
+	}
+}
+
+func TestCloseUnblocksPublisher(t *testing.T) {
+	b := New()
+	sub := b.Subscribe(WithBuffer(1))
+	b.Publish(Message{Type: "fill"})
+
+	done := make(chan struct{})
+	go func() {
+		b.Publish(Message{Type: "blocked"})
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	sub.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to release the blocked publisher")
+	}
+
+	if m, ok := <-sub.C(); !ok || m.Type != "fill" {
+		t.Errorf("expected the buffered message after Close, got %v %v", m, ok)
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Error("expected C to be closed")
+	}
+	sub.Close()
+	b.Publish(Message{Type: "after"}) // must not panic
+}
+
+func TestConcurrentPublish(t *testing.T) {
+	b := New()
+	sub := b.Subscribe(WithBuffer(1000))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				b.Publish(Message{Topic: TopicAgent})
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(drain(sub)); got != 500 {
+		t.Errorf("expected 500 messages, got %d", got)
+	}
+}
@@ -0,0 +1,384 @@
+// Package config loads attractor defaults from a user config file
+// (~/.config/attractor/config.toml), a project file (.attractor.toml), and
+// ATTRACTOR_* environment variables, in increasing order of precedence.
+//
+// A config file looks like:
+//
+//	provider  = "anthropic"
+//	model     = "claude-sonnet-4-5-20250929"
+//	logs_root = "./runs"
+//
+//	[headers]
+//	X-Gateway-Team = "platform"
+//
+//	[max_concurrent]
+//	anthropic = 4
+//
+//	[tools]
+//	jail_paths      = true
+//	disable_network = false
+//	allow_commands  = ["^go ", "^git (status|diff)"]
+//	deny_commands   = ["rm -rf"]
+//	shell           = "pwsh"
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+)
+
+// ProjectFile is the name of the project config file, looked up in the
+// working directory and its parents.
+const ProjectFile = ".attractor.toml"
+
+// Config holds the defaults read from config files and the environment.
+type Config struct {
+	// Provider is the default LLM provider (anthropic, openai, gemini).
+	Provider string `json:"provider,omitempty"`
+	// Model is the default model.
+	Model string `json:"model,omitempty"`
+	// LogsRoot is the default directory for pipeline run logs.
+	LogsRoot string `json:"logs_root,omitempty"`
+	// Headers are extra HTTP headers sent with every LLM request.
+	Headers map[string]string `json:"headers,omitempty"`
+	// MaxConcurrent caps the requests in flight to each named provider;
+	// see llm.WithMaxConcurrent.
+	MaxConcurrent map[string]int `json:"max_concurrent,omitempty"`
+	// Tools is the default policy for agent tool calls.
+	Tools ToolPolicy `json:"tools"`
+
+	// Files lists the config files that were read, lowest precedence first.
+	Files []string `json:"files,omitempty"`
+	// Sources maps each setting that was set to the file or environment
+	// variable it came from.
+	Sources map[string]string `json:"sources,omitempty"`
+	// Warnings lists the project file settings that were ignored because
+	// they would have relaxed the tool policy.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ToolPolicy is the [tools] table; it maps to an env.Policy.
+type ToolPolicy struct {
+	JailPaths      bool     `json:"jail_paths,omitempty"`
+	DisableNetwork bool     `json:"disable_network,omitempty"`
+	AllowCommands  []string `json:"allow_commands,omitempty"`
+	DenyCommands   []string `json:"deny_commands,omitempty"`
+	MaxOutputBytes int      `json:"max_output_bytes,omitempty"`
+	// Shell runs agent bash commands and pipeline tool commands: bash, sh,
+	// zsh, pwsh, powershell, or cmd. Empty means the platform default.
+	Shell string `json:"shell,omitempty"`
+}
+
+// Load reads the user config file, then the nearest project config file
+// above the working directory, then applies environment overrides. Missing
+// files are skipped. The project file comes with the repository rather than
+// from the user, so its [tools] settings may only tighten the policy; see
+// LoadProjectFile.
+func Load() (*Config, error) {
+	c := &Config{}
+	if path := UserPath(); path != "" {
+		if err := c.LoadFile(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if path := FindProjectFile(wd); path != "" {
+			if err := c.LoadProjectFile(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.ApplyEnv()
+	return c, nil
+}
+
+// UserPath returns the user config file path: $ATTRACTOR_CONFIG if set,
+// otherwise config.toml under $XDG_CONFIG_HOME/attractor or
+// ~/.config/attractor. It returns "" if no home directory is known.
+func UserPath() string {
+	if path := os.Getenv("ATTRACTOR_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "attractor", "config.toml")
+}
+
+// FindProjectFile returns the path of the ProjectFile in dir or its nearest
+// parent, or "" if there is none.
+func FindProjectFile(dir string) string {
+	for {
+		path := filepath.Join(dir, ProjectFile)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadFile reads a config file over c; settings in the file replace those
+// already set. A relative logs_root is resolved against the file's
+// directory.
+func (c *Config) LoadFile(path string) error {
+	return c.loadFile(path, false)
+}
+
+// LoadProjectFile reads a project config file over c like LoadFile, except
+// that its [tools] policy settings only apply where they make the policy
+// stricter: jail_paths and disable_network can't be turned off,
+// max_output_bytes can't be raised, allow_commands can't replace an existing
+// allow list, and deny_commands adds to the existing list. Settings that
+// would relax the policy are ignored and reported in Warnings.
+func (c *Config) LoadProjectFile(path string) error {
+	return c.loadFile(path, true)
+}
+
+func (c *Config) loadFile(path string, project bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err := parseTOML(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, e := range entries {
+		set := c.set
+		if project && strings.HasPrefix(e.Key, "tools.") && e.Key != "tools.shell" {
+			set = c.tighten
+		}
+		if err := set(e.Key, e.Value, path); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, e.Line, err)
+		}
+	}
+	if c.Sources["logs_root"] == path && c.LogsRoot != "" && !filepath.IsAbs(c.LogsRoot) {
+		c.LogsRoot = filepath.Join(filepath.Dir(path), c.LogsRoot)
+	}
+	c.Files = append(c.Files, path)
+	return nil
+}
+
+// ApplyEnv overrides settings from ATTRACTOR_PROVIDER, ATTRACTOR_MODEL,
+// ATTRACTOR_LOGS_ROOT, and ATTRACTOR_SHELL.
+func (c *Config) ApplyEnv() {
+	for key, name := range map[string]string{
+		"provider":    "ATTRACTOR_PROVIDER",
+		"model":       "ATTRACTOR_MODEL",
+		"logs_root":   "ATTRACTOR_LOGS_ROOT",
+		"tools.shell": "ATTRACTOR_SHELL",
+	} {
+		if v := os.Getenv(name); v != "" {
+			c.set(key, v, name)
+		}
+	}
+}
+
+func (c *Config) set(key string, value interface{}, source string) error {
+	var err error
+	switch key {
+	case "provider":
+		c.Provider, err = stringValue(value)
+	case "model":
+		c.Model, err = stringValue(value)
+	case "logs_root":
+		c.LogsRoot, err = stringValue(value)
+	case "tools.jail_paths":
+		c.Tools.JailPaths, err = boolValue(value)
+	case "tools.disable_network":
+		c.Tools.DisableNetwork, err = boolValue(value)
+	case "tools.allow_commands":
+		c.Tools.AllowCommands, err = stringsValue(value)
+	case "tools.deny_commands":
+		c.Tools.DenyCommands, err = stringsValue(value)
+	case "tools.max_output_bytes":
+		c.Tools.MaxOutputBytes, err = intValue(value)
+	case "tools.shell":
+		c.Tools.Shell, err = stringValue(value)
+	default:
+		if provider, ok := strings.CutPrefix(key, "max_concurrent."); ok {
+			var n int
+			if n, err = intValue(value); err == nil {
+				if c.MaxConcurrent == nil {
+					c.MaxConcurrent = make(map[string]int)
+				}
+				c.MaxConcurrent[provider] = n
+			}
+			break
+		}
+		name, ok := strings.CutPrefix(key, "headers.")
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		var v string
+		if v, err = stringValue(value); err == nil {
+			if c.Headers == nil {
+				c.Headers = make(map[string]string)
+			}
+			c.Headers[name] = v
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s %w", key, err)
+	}
+	if c.Sources == nil {
+		c.Sources = make(map[string]string)
+	}
+	c.Sources[key] = source
+	return nil
+}
+
+// tighten applies a [tools] policy setting from a project file where it
+// makes the policy stricter, and otherwise keeps the current value and adds
+// a warning.
+func (c *Config) tighten(key string, value interface{}, source string) error {
+	prev := c.Tools
+	prevSource, wasSet := c.Sources[key]
+	if err := c.set(key, value, source); err != nil {
+		return err
+	}
+	relaxed := false
+	switch key {
+	case "tools.jail_paths":
+		relaxed = prev.JailPaths && !c.Tools.JailPaths
+	case "tools.disable_network":
+		relaxed = prev.DisableNetwork && !c.Tools.DisableNetwork
+	case "tools.allow_commands":
+		// Patterns can't be intersected, so an allow list can only be
+		// added where there is none.
+		relaxed = len(prev.AllowCommands) > 0 && !slices.Equal(prev.AllowCommands, c.Tools.AllowCommands)
+	case "tools.deny_commands":
+		c.Tools.DenyCommands = append(slices.Clone(prev.DenyCommands), c.Tools.DenyCommands...)
+	case "tools.max_output_bytes":
+		relaxed = prev.MaxOutputBytes > 0 && (c.Tools.MaxOutputBytes <= 0 || c.Tools.MaxOutputBytes > prev.MaxOutputBytes)
+	}
+	if !relaxed {
+		return nil
+	}
+	c.Tools = prev
+	if wasSet {
+		c.Sources[key] = prevSource
+	}
+	c.Warnings = append(c.Warnings, fmt.Sprintf("%s: ignoring %s, which would relax the tool policy set in %s", source, key, prevSource))
+	return nil
+}
+
+// Policy compiles the tool policy, or returns nil if no [tools] setting was
+// given so callers keep their own default. tools.shell is not part of the
+// policy; see Shell.
+func (c *Config) Policy() (*env.Policy, error) {
+	set := false
+	for key := range c.Sources {
+		if strings.HasPrefix(key, "tools.") && key != "tools.shell" {
+			set = true
+		}
+	}
+	if !set {
+		return nil, nil
+	}
+	allow, err := compilePatterns(c.Tools.AllowCommands)
+	if err != nil {
+		return nil, fmt.Errorf("tools.allow_commands: %w", err)
+	}
+	deny, err := compilePatterns(c.Tools.DenyCommands)
+	if err != nil {
+		return nil, fmt.Errorf("tools.deny_commands: %w", err)
+	}
+	return &env.Policy{
+		AllowCommands:  allow,
+		DenyCommands:   deny,
+		JailPaths:      c.Tools.JailPaths,
+		DisableNetwork: c.Tools.DisableNetwork,
+		MaxOutputBytes: c.Tools.MaxOutputBytes,
+	}, nil
+}
+
+// Shell returns the configured shell, or the zero env.Shell, which selects
+// the platform default, if tools.shell is not set.
+func (c *Config) Shell() (env.Shell, error) {
+	shell, err := env.ParseShell(c.Tools.Shell)
+	if err != nil {
+		return env.Shell{}, fmt.Errorf("tools.shell: %w", err)
+	}
+	return shell, nil
+}
+
+// Keys returns the settings that were set, sorted.
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, len(c.Sources))
+	for key := range c.Sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func stringValue(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("must be a string")
+	}
+	return s, nil
+}
+
+func intValue(v interface{}) (int, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("must be an integer")
+	}
+	return int(n), nil
+}
+
+func boolValue(v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("must be true or false")
+	}
+	return b, nil
+}
+
+func stringsValue(v interface{}) ([]string, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
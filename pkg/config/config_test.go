@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	entries, err := parseTOML(`
+# defaults
+provider = "openai"   # trailing comment
+count = 1_000
+ratio = 0.5
+quoted = 'C:\path # not a comment'
+
+[tools]
+jail_paths = true
+deny_commands = [
+  "rm -rf",   # dangerous
+  "sudo",
+]
+
+[headers]
+"X-Team.Name" = "core"
+`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	got := make(map[string]interface{})
+	for _, e := range entries {
+		got[e.Key] = e.Value
+	}
+	if got["provider"] != "openai" {
+		t.Errorf("expected provider openai, got %v", got["provider"])
+	}
+	if got["count"] != int64(1000) || got["ratio"] != 0.5 {
+		t.Errorf("unexpected numbers: %v %v", got["count"], got["ratio"])
+	}
+	if got["quoted"] != `C:\path # not a comment` {
+		t.Errorf("unexpected literal string %q", got["quoted"])
+	}
+	if got["tools.jail_paths"] != true {
+		t.Errorf("expected tools.jail_paths true, got %v", got["tools.jail_paths"])
+	}
+	deny, _ := got["tools.deny_commands"].([]interface{})
+	if len(deny) != 2 || deny[1] != "sudo" {
+		t.Errorf("unexpected multi-line array %v", got["tools.deny_commands"])
+	}
+	if got["headers.X-Team.Name"] != "core" {
+		t.Errorf("expected quoted header key, got %v", got)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, src := range []string{
+		`provider "openai"`,
+		`provider = "openai`,
+		`[tools`,
+		`provider = openai`,
+		`list = ["a" "b"]`,
+	} {
+		if _, err := parseTOML(src); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	home := t.TempDir()
+	project := t.TempDir()
+	writeFile(t, filepath.Join(home, "attractor", "config.toml"), `
+provider = "anthropic"
+model = "claude-sonnet-4-5-20250929"
+[headers]
+X-Team = "core"
+`)
+	writeFile(t, filepath.Join(project, ProjectFile), `
+model = "claude-opus-4-1"
+logs_root = "runs"
+[tools]
+deny_commands = ["rm -rf"]
+[max_concurrent]
+anthropic = 4
+`)
+	sub := filepath.Join(project, "sub")
+	os.MkdirAll(sub, 0o755)
+
+	t.Setenv("ATTRACTOR_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("ATTRACTOR_PROVIDER", "openai")
+	t.Setenv("ATTRACTOR_MODEL", "")
+	t.Setenv("ATTRACTOR_LOGS_ROOT", "")
+	t.Chdir(sub)
+
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.Provider != "openai" || c.Sources["provider"] != "ATTRACTOR_PROVIDER" {
+		t.Errorf("expected env provider override, got %q from %q", c.Provider, c.Sources["provider"])
+	}
+	if c.Model != "claude-opus-4-1" {
+		t.Errorf("expected project model, got %q", c.Model)
+	}
+	if c.LogsRoot != filepath.Join(project, "runs") {
+		t.Errorf("expected logs_root relative to the project file, got %q", c.LogsRoot)
+	}
+	if c.Headers["X-Team"] != "core" {
+		t.Errorf("expected user header, got %v", c.Headers)
+	}
+	if c.MaxConcurrent["anthropic"] != 4 {
+		t.Errorf("expected anthropic concurrency 4, got %v", c.MaxConcurrent)
+	}
+	if len(c.Files) != 2 {
+		t.Errorf("expected 2 files, got %v", c.Files)
+	}
+
+	policy, err := c.Policy()
+	if err != nil {
+		t.Fatalf("Policy failed: %v", err)
+	}
+	if policy == nil || len(policy.DenyCommands) != 1 || !policy.DenyCommands[0].MatchString("rm -rf /") {
+		t.Errorf("expected deny policy, got %+v", policy)
+	}
+}
+
+func TestProjectFileOnlyTightensToolPolicy(t *testing.T) {
+	home := t.TempDir()
+	project := t.TempDir()
+	writeFile(t, filepath.Join(home, "attractor", "config.toml"), `
+[tools]
+jail_paths = true
+disable_network = true
+allow_commands = ["^go "]
+deny_commands = ["rm -rf"]
+max_output_bytes = 1000
+`)
+	writeFile(t, filepath.Join(project, ProjectFile), `
+[tools]
+jail_paths = false
+disable_network = false
+allow_commands = [".*"]
+deny_commands = ["curl"]
+max_output_bytes = 500
+shell = "sh"
+`)
+	t.Setenv("ATTRACTOR_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("ATTRACTOR_SHELL", "")
+	t.Chdir(project)
+
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	userPath := filepath.Join(home, "attractor", "config.toml")
+	if !c.Tools.JailPaths || !c.Tools.DisableNetwork {
+		t.Errorf("expected the project file not to lift jail_paths or disable_network, got %+v", c.Tools)
+	}
+	if !reflect.DeepEqual(c.Tools.AllowCommands, []string{"^go "}) || c.Sources["tools.allow_commands"] != userPath {
+		t.Errorf("expected the user's allow list, got %v from %q", c.Tools.AllowCommands, c.Sources["tools.allow_commands"])
+	}
+	if !reflect.DeepEqual(c.Tools.DenyCommands, []string{"rm -rf", "curl"}) {
+		t.Errorf("expected the deny lists combined, got %v", c.Tools.DenyCommands)
+	}
+	if c.Tools.MaxOutputBytes != 500 || c.Tools.Shell != "sh" {
+		t.Errorf("expected a lower max_output_bytes and the project shell, got %+v", c.Tools)
+	}
+	if len(c.Warnings) != 3 {
+		t.Errorf("expected 3 warnings, got %q", c.Warnings)
+	}
+
+	// A project file can add a policy where the user has none.
+	c = &Config{}
+	if err := c.LoadProjectFile(filepath.Join(project, ProjectFile)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Tools.JailPaths || !reflect.DeepEqual(c.Tools.AllowCommands, []string{".*"}) || len(c.Warnings) != 0 {
+		t.Errorf("expected the project policy, got %+v with warnings %q", c.Tools, c.Warnings)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeFile(t, path, "provider = \"openai\"\nmodle = \"gpt-4.1\"\n")
+	err := (&Config{}).LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), ":2: unknown setting") {
+		t.Errorf("expected unknown setting error on line 2, got %v", err)
+	}
+
+	writeFile(t, path, "[tools]\njail_paths = \"yes\"\n")
+	err = (&Config{}).LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "tools.jail_paths must be true or false") {
+		t.Errorf("expected type error, got %v", err)
+	}
+}
+
+func TestPolicyUnset(t *testing.T) {
+	c := &Config{Provider: "openai"}
+	if policy, err := c.Policy(); policy != nil || err != nil {
+		t.Errorf("expected no policy, got %+v, %v", policy, err)
+	}
+}
+
+func TestShell(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeFile(t, path, "[tools]\nshell = \"pwsh\"\n")
+	c := &Config{}
+	if err := c.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	shell, err := c.Shell()
+	if err != nil || shell.Name != "pwsh" {
+		t.Errorf("expected pwsh, got %+v, %v", shell, err)
+	}
+	if policy, _ := c.Policy(); policy != nil {
+		t.Errorf("expected tools.shell alone not to set a policy, got %+v", policy)
+	}
+
+	c.Tools.Shell = "fish"
+	if _, err := c.Shell(); err == nil || !strings.Contains(err.Error(), "tools.shell") {
+		t.Errorf("expected an unknown shell error, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// entry is a key assignment in a TOML document, with its table prefix
+// joined into Key.
+type entry struct {
+	Key   string
+	Value interface{} // string, int64, float64, bool, or []interface{}
+	Line  int
+}
+
+// parseTOML parses the subset of TOML used by config files: [table] and
+// [dotted.table] headers, bare or quoted keys, and string, integer, float,
+// boolean, and array values. Arrays may span lines; inline tables are not
+// supported.
+func parseTOML(src string) ([]entry, error) {
+	var entries []entry
+	table := ""
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %q", lineNo, line)
+			}
+			name, err := parseKey(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			table = name
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key, err := parseKey(strings.TrimSpace(line[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+		// An array continues until its brackets balance.
+		for strings.HasPrefix(raw, "[") && !arrayClosed(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		value, rest, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", lineNo, strings.TrimSpace(rest))
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		entries = append(entries, entry{Key: key, Value: value, Line: lineNo})
+	}
+	return entries, nil
+}
+
+// stripComment removes a # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// arrayClosed reports whether the brackets of an array value balance,
+// ignoring brackets inside strings.
+func arrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// parseKey parses a bare, quoted, or dotted key and returns it joined by
+// dots.
+func parseKey(s string) (string, error) {
+	var parts []string
+	for s != "" {
+		var part string
+		if s[0] == '"' || s[0] == '\'' {
+			v, rest, err := parseString(s)
+			if err != nil {
+				return "", err
+			}
+			part, s = v, strings.TrimSpace(rest)
+		} else {
+			end := strings.IndexFunc(s, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+			})
+			if end < 0 {
+				end = len(s)
+			}
+			part, s = s[:end], strings.TrimSpace(s[end:])
+			if part == "" {
+				return "", fmt.Errorf("invalid key")
+			}
+		}
+		parts = append(parts, part)
+		if s == "" {
+			break
+		}
+		if s[0] != '.' {
+			return "", fmt.Errorf("invalid key near %q", s)
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("empty key")
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// parseValue parses the value at the start of s and returns the rest.
+func parseValue(s string) (interface{}, string, error) {
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"', '\'':
+		return parseString(s)
+	case '[':
+		var items []interface{}
+		s = strings.TrimSpace(s[1:])
+		for {
+			if strings.HasPrefix(s, "]") {
+				return items, s[1:], nil
+			}
+			item, rest, err := parseValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+	}
+
+	end := strings.IndexAny(s, ",] \t")
+	if end < 0 {
+		end = len(s)
+	}
+	word, rest := s[:end], s[end:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	clean := strings.ReplaceAll(word, "_", "")
+	if n, err := strconv.ParseInt(clean, 10, 64); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q", word)
+}
+
+// parseString parses a basic ("...") or literal ('...') string at the start
+// of s and returns the rest.
+func parseString(s string) (string, string, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			if quote == '\'' {
+				return s[1:i], s[i+1:], nil
+			}
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+			}
+			return v, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,231 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ResponseCache stores responses keyed by a content hash of the request.
+type ResponseCache interface {
+	Get(key string) (*Response, bool)
+	Set(key string, resp *Response)
+}
+
+// cacheKeyMaterial is the subset of a Request that determines its response.
+type cacheKeyMaterial struct {
+	Model           string          `json:"model"`
+	Provider        string          `json:"provider,omitempty"`
+	SystemPrompt    string          `json:"system_prompt,omitempty"`
+	Messages        []Message       `json:"messages"`
+	Tools           []Tool          `json:"tools,omitempty"`
+	ToolChoice      interface{}     `json:"tool_choice,omitempty"`
+	MaxTokens       int             `json:"max_tokens,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"top_p,omitempty"`
+	StopSequences   []string        `json:"stop_sequences,omitempty"`
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
+	ResponseFormat  *ResponseFormat `json:"response_format,omitempty"`
+	Logprobs        bool            `json:"logprobs,omitempty"`
+	TopLogprobs     int             `json:"top_logprobs,omitempty"`
+	// ProviderOptions reach the request body, so they change the response.
+	// encoding/json writes map keys sorted, which keeps the key stable.
+	ProviderOptions map[string]interface{} `json:"provider_options,omitempty"`
+}
+
+// CacheKey returns the content-addressed cache key for a request.
+func CacheKey(req *Request) string {
+	data, _ := json.Marshal(cacheKeyMaterial{
+		Model:           req.Model,
+		Provider:        req.Provider,
+		SystemPrompt:    req.SystemPrompt,
+		Messages:        req.Messages,
+		Tools:           req.Tools,
+		ToolChoice:      req.ToolChoice,
+		MaxTokens:       req.MaxTokens,
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		StopSequences:   req.StopSequences,
+		ReasoningEffort: req.ReasoningEffort,
+		ResponseFormat:  req.ResponseFormat,
+		Logprobs:        req.Logprobs,
+		TopLogprobs:     req.TopLogprobs,
+		ProviderOptions: req.ProviderOptions,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheMiddleware returns middleware that serves identical requests from cache.
+// Only successful responses are stored. A response served from cache has
+// Cached set and a zero Usage, since no tokens were billed for it.
+func CacheMiddleware(cache ResponseCache) Middleware {
+	return func(ctx context.Context, req *Request, next MiddlewareNext) (*Response, error) {
+		key := CacheKey(req)
+		if resp, ok := cache.Get(key); ok {
+			resp.Cached = true
+			resp.Usage = Usage{}
+			return resp, nil
+		}
+		resp, err := next(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		cache.Set(key, resp)
+		return resp, nil
+	}
+}
+
+type cacheEntry struct {
+	StoredAt time.Time `json:"stored_at"`
+	Response *Response `json:"response"`
+	// Raw and Headers are the Response fields its JSON form leaves out,
+	// kept so DiskCache hits carry them too.
+	Raw     interface{} `json:"raw,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+}
+
+func (e cacheEntry) expired(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(e.StoredAt) > ttl
+}
+
+// MemoryCache is an in-process ResponseCache. A zero TTL never expires entries.
+type MemoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// NewMemoryCache creates an in-memory response cache.
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Get returns a cached response if present and not expired.
+func (c *MemoryCache) Get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(c.ttl) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return cloneResponse(entry.Response), true
+}
+
+// Set stores a copy of resp.
+func (c *MemoryCache) Set(key string, resp *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{StoredAt: time.Now(), Response: cloneResponse(resp)}
+}
+
+// cloneResponse copies resp, its slices, and its raw form, so callers of a
+// MemoryCache can't change the entries it holds.
+func cloneResponse(resp *Response) *Response {
+	out := *resp
+	out.Raw = cloneRaw(resp.Raw)
+	out.Parts = slices.Clone(resp.Parts)
+	out.ToolCalls = slices.Clone(resp.ToolCalls)
+	out.Warnings = slices.Clone(resp.Warnings)
+	out.Logprobs = slices.Clone(resp.Logprobs)
+	out.Headers = resp.Headers.Clone()
+	if resp.RateLimit != nil {
+		rl := *resp.RateLimit
+		out.RateLimit = &rl
+	}
+	return &out
+}
+
+// cloneRaw deep-copies the objects and arrays of a decoded JSON value.
+func cloneRaw(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = cloneRaw(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = cloneRaw(e)
+		}
+		return out
+	}
+	return v
+}
+
+// DiskCache is a ResponseCache that persists entries as JSON files in a directory,
+// so cached responses survive across runs. A zero TTL never expires entries.
+type DiskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewDiskCache creates a disk-backed response cache rooted at dir.
+func NewDiskCache(dir string, ttl time.Duration) *DiskCache {
+	return &DiskCache{dir: dir, ttl: ttl}
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get returns a cached response if present and not expired.
+func (c *DiskCache) Get(key string) (*Response, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := decodeJSON(data, &entry); err != nil || entry.Response == nil {
+		return nil, false
+	}
+	if entry.expired(c.ttl) {
+		os.Remove(c.path(key))
+		return nil, false
+	}
+	entry.Response.Raw = entry.Raw
+	entry.Response.Headers = entry.Headers
+	return entry.Response, true
+}
+
+// Set stores a response. Write failures are ignored; the cache is best-effort.
+// The entry is written to a temporary file and renamed into place, so a
+// concurrent Get never reads a partial entry.
+func (c *DiskCache) Set(key string, resp *Response) {
+	data, err := json.Marshal(cacheEntry{StoredAt: time.Now(), Response: resp, Raw: resp.Raw, Headers: resp.Headers})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(c.dir, "."+key+".tmp-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err != nil || closeErr != nil {
+		return
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return
+	}
+	os.Rename(tmp.Name(), c.path(key))
+}
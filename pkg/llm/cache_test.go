@@ -0,0 +1,178 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// countingAdapter counts Complete calls.
+type countingAdapter struct {
+	mockAdapter
+	calls int
+}
+
+func (c *countingAdapter) Complete(ctx context.Context, req *Request) (*Response, error) {
+	c.calls++
+	return c.mockAdapter.Complete(ctx, req)
+}
+
+func TestCacheKeyStable(t *testing.T) {
+	req1 := &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "hi"}}}
+	req2 := &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "hi"}}}
+	req3 := &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "bye"}}}
+
+	if CacheKey(req1) != CacheKey(req2) {
+		t.Error("identical requests should produce identical keys")
+	}
+	if CacheKey(req1) == CacheKey(req3) {
+		t.Error("different messages should produce different keys")
+	}
+
+	req4 := &Request{Model: "m", Messages: req1.Messages, Tools: []Tool{{Name: "t"}}}
+	if CacheKey(req1) == CacheKey(req4) {
+		t.Error("tools should affect the key")
+	}
+
+	opts := func(effort string) *Request {
+		return &Request{Model: "m", Messages: req1.Messages, ProviderOptions: map[string]interface{}{
+			"openai": map[string]interface{}{"service_tier": "flex", "reasoning": map[string]interface{}{"effort": effort, "summary": "auto"}},
+		}}
+	}
+	if CacheKey(opts("low")) != CacheKey(opts("low")) {
+		t.Error("identical provider options should produce identical keys")
+	}
+	if CacheKey(req1) == CacheKey(opts("low")) || CacheKey(opts("low")) == CacheKey(opts("high")) {
+		t.Error("provider options should affect the key")
+	}
+
+	if CacheKey(req1) == CacheKey(&Request{Model: "m", Messages: req1.Messages, Logprobs: true}) ||
+		CacheKey(req1) == CacheKey(&Request{Model: "m", Messages: req1.Messages, TopLogprobs: 3}) {
+		t.Error("logprobs should affect the key")
+	}
+}
+
+func TestCacheMiddlewareMemory(t *testing.T) {
+	adapter := &countingAdapter{mockAdapter: mockAdapter{name: "test", response: &Response{
+		Content: "cached",
+		Usage:   Usage{InputTokens: 10, OutputTokens: 5, TotalTokens: 15},
+	}}}
+	client := NewClient(
+		WithProvider("test", adapter),
+		WithMiddleware(CacheMiddleware(NewMemoryCache(0))),
+	)
+
+	req := &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "hi"}}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Complete(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Content != "cached" || len(resp.Warnings) != 0 {
+			t.Errorf("expected the cached response, got %+v", resp)
+		}
+		// Only the provider call is billed.
+		if hit := i > 0; resp.Cached != hit || (resp.Usage.TotalTokens == 0) != hit {
+			t.Errorf("call %d: expected cached=%v, got cached=%v with usage %+v", i, hit, resp.Cached, resp.Usage)
+		}
+		// Changes to a returned response don't reach the cache.
+		resp.Content = "changed"
+		resp.Warnings = append(resp.Warnings, Warning{Message: "added"})
+	}
+	if adapter.calls != 1 {
+		t.Errorf("expected 1 provider call, got %d", adapter.calls)
+	}
+}
+
+func TestMemoryCacheKeepsRaw(t *testing.T) {
+	cache := NewMemoryCache(0)
+	cache.Set("k", &Response{
+		Raw:     map[string]interface{}{"choices": []interface{}{map[string]interface{}{"logprobs": "lp"}}},
+		Headers: http.Header{"X-Request-Id": {"req_1"}},
+	})
+
+	resp, _ := cache.Get("k")
+	if v, ok := resp.RawField("choices", "0", "logprobs"); !ok || v != "lp" {
+		t.Errorf("expected the raw response on a hit, got %v", resp.Raw)
+	}
+	if resp.Headers.Get("X-Request-Id") != "req_1" {
+		t.Errorf("expected the headers on a hit, got %v", resp.Headers)
+	}
+	// Changes to a hit's raw form don't reach the cache.
+	resp.Raw.(map[string]interface{})["choices"].([]interface{})[0] = "changed"
+	resp, _ = cache.Get("k")
+	if _, ok := resp.RawField("choices", "0", "logprobs"); !ok {
+		t.Errorf("cached raw response was changed: %v", resp.Raw)
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	cache := NewMemoryCache(time.Millisecond)
+	cache.Set("k", &Response{Content: "x"})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("k"); ok {
+		t.Error("expected entry to expire")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewDiskCache(dir, time.Hour)
+	cache.Set("k", &Response{
+		ID:      "r1",
+		Content: "from disk",
+		Raw:     map[string]interface{}{"usage": map[string]interface{}{"total_tokens": json.Number("15")}},
+		Headers: http.Header{"X-Request-Id": {"req_1"}},
+	})
+
+	// A fresh instance sees the same entry.
+	resp, ok := NewDiskCache(dir, time.Hour).Get("k")
+	if !ok {
+		t.Fatal("expected disk cache hit")
+	}
+	if resp.Content != "from disk" {
+		t.Errorf("expected 'from disk', got %q", resp.Content)
+	}
+	if v, ok := resp.RawField("usage", "total_tokens"); !ok || v != json.Number("15") {
+		t.Errorf("expected the raw response to survive the disk, got %v", resp.Raw)
+	}
+	if resp.Headers.Get("X-Request-Id") != "req_1" {
+		t.Errorf("expected the headers to survive the disk, got %v", resp.Headers)
+	}
+
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("expected miss for unknown key")
+	}
+
+	// Entries are renamed into place, leaving no temporary files behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "k.json" {
+		t.Errorf("expected only k.json in the cache directory, got %v", entries)
+	}
+}
+
+func TestFromEnvAppliesOptions(t *testing.T) {
+	t.Setenv("ATTRACTOR_LLM_DEBUG", "")
+	adapter := &countingAdapter{mockAdapter: mockAdapter{name: "test", response: &Response{Content: "cached"}}}
+	client := FromEnv(
+		WithProvider("test", adapter),
+		WithDefaultProvider("test"),
+		WithMiddleware(CacheMiddleware(NewMemoryCache(0))),
+	)
+
+	req := &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "hi"}}}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Complete(context.Background(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if adapter.calls != 1 {
+		t.Errorf("expected the cache option to apply, got %d provider calls", adapter.calls)
+	}
+}
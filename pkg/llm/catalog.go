@@ -1,5 +1,11 @@
 package llm
 
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
 // ModelInfo describes a known model.
 type ModelInfo struct {
 	ID             string `json:"id"`
@@ -10,48 +16,184 @@ type ModelInfo struct {
 	SupportsVision bool   `json:"supports_vision"`
 	SupportsTools  bool   `json:"supports_tools"`
 	SupportsReasoning bool `json:"supports_reasoning"`
+	InputCostPerMTok  float64 `json:"input_cost_per_mtok,omitempty"`  // USD per million input tokens
+	OutputCostPerMTok float64 `json:"output_cost_per_mtok,omitempty"` // USD per million output tokens
 }
 
 // knownModels is the built-in model catalog.
 var knownModels = []ModelInfo{
 	// OpenAI
-	{ID: "gpt-5.2", Provider: "openai", DisplayName: "GPT-5.2", ContextWindow: 128000, MaxOutput: 16384, SupportsVision: true, SupportsTools: true, SupportsReasoning: true},
-	{ID: "gpt-4.1", Provider: "openai", DisplayName: "GPT-4.1", ContextWindow: 1000000, MaxOutput: 32768, SupportsVision: true, SupportsTools: true},
-	{ID: "gpt-4o", Provider: "openai", DisplayName: "GPT-4o", ContextWindow: 128000, MaxOutput: 16384, SupportsVision: true, SupportsTools: true},
-	{ID: "o3", Provider: "openai", DisplayName: "o3", ContextWindow: 200000, MaxOutput: 100000, SupportsTools: true, SupportsReasoning: true},
+	{ID: "gpt-5.2", Provider: "openai", DisplayName: "GPT-5.2", ContextWindow: 128000, MaxOutput: 16384, SupportsVision: true, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 1.75, OutputCostPerMTok: 14},
+	{ID: "gpt-4.1", Provider: "openai", DisplayName: "GPT-4.1", ContextWindow: 1000000, MaxOutput: 32768, SupportsVision: true, SupportsTools: true, InputCostPerMTok: 2, OutputCostPerMTok: 8},
+	{ID: "gpt-4o", Provider: "openai", DisplayName: "GPT-4o", ContextWindow: 128000, MaxOutput: 16384, SupportsVision: true, SupportsTools: true, InputCostPerMTok: 2.5, OutputCostPerMTok: 10},
+	{ID: "o3", Provider: "openai", DisplayName: "o3", ContextWindow: 200000, MaxOutput: 100000, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 2, OutputCostPerMTok: 8},
 
 	// Anthropic
-	{ID: "claude-opus-4-6", Provider: "anthropic", DisplayName: "Claude Opus 4.6", ContextWindow: 1000000, MaxOutput: 32000, SupportsVision: true, SupportsTools: true, SupportsReasoning: true},
-	{ID: "claude-sonnet-4-5-20250929", Provider: "anthropic", DisplayName: "Claude Sonnet 4.5", ContextWindow: 200000, MaxOutput: 16384, SupportsVision: true, SupportsTools: true, SupportsReasoning: true},
-	{ID: "claude-haiku-4-5-20251001", Provider: "anthropic", DisplayName: "Claude Haiku 4.5", ContextWindow: 200000, MaxOutput: 8192, SupportsVision: true, SupportsTools: true},
+	{ID: "claude-opus-4-6", Provider: "anthropic", DisplayName: "Claude Opus 4.6", ContextWindow: 1000000, MaxOutput: 128000, SupportsVision: true, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 5, OutputCostPerMTok: 25},
+	{ID: "claude-sonnet-4-5-20250929", Provider: "anthropic", DisplayName: "Claude Sonnet 4.5", ContextWindow: 200000, MaxOutput: 64000, SupportsVision: true, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 3, OutputCostPerMTok: 15},
+	{ID: "claude-haiku-4-5-20251001", Provider: "anthropic", DisplayName: "Claude Haiku 4.5", ContextWindow: 200000, MaxOutput: 64000, SupportsVision: true, SupportsTools: true, InputCostPerMTok: 1, OutputCostPerMTok: 5},
 
 	// Gemini
-	{ID: "gemini-2.5-pro", Provider: "gemini", DisplayName: "Gemini 2.5 Pro", ContextWindow: 1000000, MaxOutput: 65536, SupportsVision: true, SupportsTools: true, SupportsReasoning: true},
-	{ID: "gemini-2.5-flash", Provider: "gemini", DisplayName: "Gemini 2.5 Flash", ContextWindow: 1000000, MaxOutput: 65536, SupportsVision: true, SupportsTools: true},
+	{ID: "gemini-2.5-pro", Provider: "gemini", DisplayName: "Gemini 2.5 Pro", ContextWindow: 1000000, MaxOutput: 65536, SupportsVision: true, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 1.25, OutputCostPerMTok: 10},
+	{ID: "gemini-2.5-flash", Provider: "gemini", DisplayName: "Gemini 2.5 Flash", ContextWindow: 1000000, MaxOutput: 65536, SupportsVision: true, SupportsTools: true, InputCostPerMTok: 0.3, OutputCostPerMTok: 2.5},
+
+	// xAI
+	{ID: "grok-4", Provider: "xai", DisplayName: "Grok 4", ContextWindow: 256000, MaxOutput: 64000, SupportsVision: true, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 3, OutputCostPerMTok: 15},
+	{ID: "grok-code-fast-1", Provider: "xai", DisplayName: "Grok Code Fast 1", ContextWindow: 256000, MaxOutput: 10000, SupportsTools: true, SupportsReasoning: true, InputCostPerMTok: 0.2, OutputCostPerMTok: 1.5},
+
+	// Mistral
+	{ID: "mistral-large-latest", Provider: "mistral", DisplayName: "Mistral Large", ContextWindow: 128000, MaxOutput: 32768, SupportsVision: true, SupportsTools: true, InputCostPerMTok: 2, OutputCostPerMTok: 6},
+	{ID: "codestral-latest", Provider: "mistral", DisplayName: "Codestral", ContextWindow: 256000, MaxOutput: 32768, SupportsTools: true, InputCostPerMTok: 0.3, OutputCostPerMTok: 0.9},
 }
 
-// GetModelInfo returns info about a known model by ID.
-func GetModelInfo(modelID string) (ModelInfo, bool) {
-	for _, m := range knownModels {
-		if m.ID == modelID {
-			return m, true
+// ModelCatalog describes the capabilities and pricing of known models. It is
+// safe for concurrent use.
+type ModelCatalog struct {
+	mu     sync.RWMutex
+	models []ModelInfo
+}
+
+// NewModelCatalog creates a catalog containing the given models.
+func NewModelCatalog(models ...ModelInfo) *ModelCatalog {
+	c := &ModelCatalog{}
+	for _, m := range models {
+		c.Register(m)
+	}
+	return c
+}
+
+// DefaultCatalog is the built-in catalog used by GetModelInfo, ListModels,
+// EstimateCost, and clients without WithCatalog.
+var DefaultCatalog = NewModelCatalog(knownModels...)
+
+// Register adds a model, replacing any existing entry with the same ID.
+func (c *ModelCatalog) Register(info ModelInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, m := range c.models {
+		if m.ID == info.ID {
+			c.models[i] = info
+			return
 		}
 	}
-	return ModelInfo{}, false
+	c.models = append(c.models, info)
 }
 
-// ListModels returns all known models, optionally filtered by provider.
-func ListModels(provider string) []ModelInfo {
-	if provider == "" {
-		result := make([]ModelInfo, len(knownModels))
-		copy(result, knownModels)
-		return result
+// modelVersionSuffix matches dated or "latest" suffixes on model IDs, so
+// "claude-opus-4-6-20260101" resolves to the "claude-opus-4-6" entry.
+var modelVersionSuffix = regexp.MustCompile(`-(\d{8}|\d{4}-\d{2}-\d{2}|latest)$`)
+
+// Lookup returns info about a model by ID, ignoring version suffixes.
+func (c *ModelCatalog) Lookup(modelID string) (ModelInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	base := modelVersionSuffix.ReplaceAllString(modelID, "")
+	for _, id := range []string{modelID, base} {
+		for _, m := range c.models {
+			if m.ID == id {
+				return m, true
+			}
+		}
 	}
+	return ModelInfo{}, false
+}
+
+// List returns all models, optionally filtered by provider.
+func (c *ModelCatalog) List(provider string) []ModelInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	var result []ModelInfo
-	for _, m := range knownModels {
-		if m.Provider == provider {
+	for _, m := range c.models {
+		if provider == "" || m.Provider == provider {
 			result = append(result, m)
 		}
 	}
 	return result
 }
+
+// Check returns warnings for request features the model does not support.
+// Unknown models produce no warnings.
+func (c *ModelCatalog) Check(req *Request) []Warning {
+	info, ok := c.Lookup(req.Model)
+	if !ok {
+		return nil
+	}
+	var warnings []Warning
+	if len(req.Tools) > 0 && !info.SupportsTools {
+		warnings = append(warnings, Warning{
+			Code:    "unsupported_tools",
+			Message: fmt.Sprintf("model %s does not support tool calling", req.Model),
+		})
+	}
+	if req.ReasoningEffort != "" && !info.SupportsReasoning {
+		warnings = append(warnings, Warning{
+			Code:    "unsupported_reasoning",
+			Message: fmt.Sprintf("model %s does not support reasoning effort", req.Model),
+		})
+	}
+	if (req.Logprobs || req.TopLogprobs > 0) && !logprobsProviders[info.Provider] {
+		warnings = append(warnings, Warning{
+			Code:    "unsupported_logprobs",
+			Message: fmt.Sprintf("model %s does not return logprobs", req.Model),
+		})
+	}
+	if !info.SupportsVision && requestHasImages(req) {
+		warnings = append(warnings, Warning{
+			Code:    "unsupported_vision",
+			Message: fmt.Sprintf("model %s does not support image input", req.Model),
+		})
+	}
+	if info.MaxOutput > 0 && req.MaxTokens > info.MaxOutput {
+		warnings = append(warnings, Warning{
+			Code:    "max_tokens_exceeded",
+			Message: fmt.Sprintf("max_tokens %d exceeds the %d output token limit of %s", req.MaxTokens, info.MaxOutput, req.Model),
+		})
+	}
+	return warnings
+}
+
+// logprobsProviders are the providers whose adapters return logprobs.
+var logprobsProviders = map[string]bool{"openai": true, "xai": true}
+
+// ReturnsLogprobs reports whether the named provider's adapter returns token
+// logprobs when a request asks for them.
+func ReturnsLogprobs(provider string) bool {
+	return logprobsProviders[provider]
+}
+
+func requestHasImages(req *Request) bool {
+	for _, m := range req.Messages {
+		for _, p := range m.Parts {
+			if p.Type == ContentPartImage {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetModelInfo returns info about a known model by ID.
+func GetModelInfo(modelID string) (ModelInfo, bool) {
+	return DefaultCatalog.Lookup(modelID)
+}
+
+// ListModels returns all known models, optionally filtered by provider.
+func ListModels(provider string) []ModelInfo {
+	return DefaultCatalog.List(provider)
+}
+
+// EstimateCost returns the approximate USD cost of the given usage for a model.
+// Unknown models and models without pricing return 0.
+func (c *ModelCatalog) EstimateCost(modelID string, usage Usage) float64 {
+	info, ok := c.Lookup(modelID)
+	if !ok {
+		return 0
+	}
+	return float64(usage.InputTokens)*info.InputCostPerMTok/1e6 +
+		float64(usage.OutputTokens)*info.OutputCostPerMTok/1e6
+}
+
+// EstimateCost estimates cost using DefaultCatalog.
+func EstimateCost(modelID string, usage Usage) float64 {
+	return DefaultCatalog.EstimateCost(modelID, usage)
+}
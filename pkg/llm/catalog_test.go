@@ -45,3 +45,63 @@ func TestListModelsEmpty(t *testing.T) {
 		t.Errorf("expected empty list for unknown provider, got %d", len(result))
 	}
 }
+
+func TestEstimateCost(t *testing.T) {
+	cost := EstimateCost("claude-sonnet-4-5-20250929", Usage{InputTokens: 1000000, OutputTokens: 1000000})
+	if cost != 18 {
+		t.Errorf("expected cost 18, got %v", cost)
+	}
+	if cost := EstimateCost("nonexistent-model", Usage{InputTokens: 1000}); cost != 0 {
+		t.Errorf("expected 0 for unknown model, got %v", cost)
+	}
+}
+
+func TestModelCatalogLookupVersionSuffix(t *testing.T) {
+	c := NewModelCatalog(ModelInfo{ID: "claude-opus-4-6", Provider: "anthropic"})
+	if _, ok := c.Lookup("claude-opus-4-6-20260101"); !ok {
+		t.Error("expected dated model ID to resolve")
+	}
+	if _, ok := c.Lookup("claude-opus-4-6-latest"); !ok {
+		t.Error("expected -latest model ID to resolve")
+	}
+	if _, ok := c.Lookup("claude-opus-4"); ok {
+		t.Error("expected shorter model ID not to resolve")
+	}
+}
+
+func TestModelCatalogRegisterReplaces(t *testing.T) {
+	c := NewModelCatalog(ModelInfo{ID: "m", ContextWindow: 1000})
+	c.Register(ModelInfo{ID: "m", ContextWindow: 2000})
+	if got := c.List(""); len(got) != 1 || got[0].ContextWindow != 2000 {
+		t.Errorf("expected single replaced entry, got %+v", got)
+	}
+}
+
+func TestModelCatalogCheck(t *testing.T) {
+	c := NewModelCatalog(ModelInfo{ID: "basic", MaxOutput: 1000})
+	req := &Request{
+		Model:           "basic",
+		Tools:           []Tool{{Name: "t"}},
+		ReasoningEffort: "high",
+		Logprobs:        true,
+		MaxTokens:       2000,
+		Messages: []Message{{Role: RoleUser, Parts: []ContentPart{
+			{Type: ContentPartImage, ImageURL: "https://example.com/a.png"},
+		}}},
+	}
+
+	codes := map[string]bool{}
+	for _, w := range c.Check(req) {
+		codes[w.Code] = true
+	}
+	for _, want := range []string{"unsupported_tools", "unsupported_reasoning", "unsupported_vision", "unsupported_logprobs", "max_tokens_exceeded"} {
+		if !codes[want] {
+			t.Errorf("expected warning %s, got %v", want, codes)
+		}
+	}
+
+	req.Model = "unknown"
+	if w := c.Check(req); len(w) != 0 {
+		t.Errorf("expected no warnings for unknown model, got %v", w)
+	}
+}
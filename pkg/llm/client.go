@@ -15,6 +15,9 @@ type Client struct {
 	defaultProvider string
 	middleware      []Middleware
 	streamMW       []StreamMiddleware
+	catalog         *ModelCatalog
+	debugLogDir     string
+	limiters        map[string]*limiter
 }
 
 // ClientOption configures a Client.
@@ -48,6 +51,52 @@ func WithStreamMiddleware(mw ...StreamMiddleware) ClientOption {
 	}
 }
 
+// WithHeaders sends extra HTTP headers with every request, e.g. for a proxy
+// or gateway in front of the providers. Headers already set on a request take
+// precedence.
+func WithHeaders(headers map[string]string) ClientOption {
+	return func(c *Client) {
+		if len(headers) == 0 {
+			return
+		}
+		c.middleware = append(c.middleware, func(ctx context.Context, req *Request, next MiddlewareNext) (*Response, error) {
+			return next(ctx, withHeaders(req, headers))
+		})
+		c.streamMW = append(c.streamMW, func(ctx context.Context, req *Request, next StreamMiddlewareNext) (<-chan StreamEvent, error) {
+			return next(ctx, withHeaders(req, headers))
+		})
+	}
+}
+
+// withHeaders returns a copy of req with headers added under its own.
+func withHeaders(req *Request, headers map[string]string) *Request {
+	r := *req
+	r.Headers = make(map[string]string, len(headers)+len(req.Headers))
+	for k, v := range headers {
+		r.Headers[k] = v
+	}
+	for k, v := range req.Headers {
+		r.Headers[k] = v
+	}
+	return &r
+}
+
+// WithCatalog sets the model catalog used to warn about unsupported request
+// features. Defaults to DefaultCatalog.
+func WithCatalog(catalog *ModelCatalog) ClientOption {
+	return func(c *Client) {
+		c.catalog = catalog
+	}
+}
+
+// Catalog returns the client's model catalog.
+func (c *Client) Catalog() *ModelCatalog {
+	if c.catalog == nil {
+		return DefaultCatalog
+	}
+	return c.catalog
+}
+
 // NewClient creates a new Client with the given options.
 func NewClient(opts ...ClientOption) *Client {
 	c := &Client{
@@ -94,11 +143,18 @@ func RegisterProviderFactory(name, envKeys string, factory ProviderFactory) {
 }
 
 // FromEnv creates a Client from environment variables.
-// Registers providers whose API keys are present.
-func FromEnv() *Client {
+// Registers providers whose API keys are present. When ATTRACTOR_LLM_DEBUG is
+// set, every request and response is logged to a per-run directory. opts are
+// applied last, so their middleware runs inside the debug logger.
+func FromEnv(opts ...ClientOption) *Client {
 	c := &Client{
 		providers: make(map[string]ProviderAdapter),
 	}
+	if dir := DebugLogDirFromEnv(); dir != "" {
+		c.debugLogDir = dir
+		c.middleware = append(c.middleware, DebugLogMiddleware(dir))
+		c.streamMW = append(c.streamMW, DebugLogStreamMiddleware(dir))
+	}
 
 	registryMu.Lock()
 	registrations := make([]providerRegistration, len(providerFactories))
@@ -114,9 +170,18 @@ func FromEnv() *Client {
 		}
 	}
 
+	for _, opt := range opts {
+		opt(c)
+	}
 	return c
 }
 
+// DebugLogDir returns the directory receiving request/response logs, or ""
+// if debug logging is disabled.
+func (c *Client) DebugLogDir() string {
+	return c.debugLogDir
+}
+
 // HasProviders returns true if at least one provider is registered.
 func (c *Client) HasProviders() bool {
 	c.mu.RLock()
@@ -153,8 +218,12 @@ func (c *Client) resolveProvider(req *Request) (ProviderAdapter, error) {
 	return adapter, nil
 }
 
-// Complete sends a blocking request to the resolved provider, applying middleware.
+// Complete validates req and sends a blocking request to the resolved provider,
+// applying middleware.
 func (c *Client) Complete(ctx context.Context, req *Request) (*Response, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
 	adapter, err := c.resolveProvider(req)
 	if err != nil {
 		return nil, err
@@ -162,6 +231,12 @@ func (c *Client) Complete(ctx context.Context, req *Request) (*Response, error)
 
 	// Build the middleware chain.
 	final := func(ctx context.Context, r *Request) (*Response, error) {
+		if l := c.limiterFor(r); l != nil {
+			if err := l.acquire(ctx); err != nil {
+				return nil, err
+			}
+			defer l.release()
+		}
 		return adapter.Complete(ctx, r)
 	}
 
@@ -174,18 +249,46 @@ func (c *Client) Complete(ctx context.Context, req *Request) (*Response, error)
 		}
 	}
 
-	return chain(ctx, req)
+	resp, err := chain(ctx, req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	// Middleware such as the cache may hand back a shared response, so the
+	// warnings go on a copy.
+	if warnings := c.Catalog().Check(req); len(warnings) > 0 {
+		copied := *resp
+		copied.Warnings = append(append([]Warning(nil), resp.Warnings...), warnings...)
+		resp = &copied
+	}
+	return resp, nil
 }
 
-// Stream sends a streaming request to the resolved provider, applying middleware.
+// Stream validates req and sends a streaming request to the resolved provider,
+// applying middleware.
+// Capability warnings are not attached to streams; use Catalog().Check.
 func (c *Client) Stream(ctx context.Context, req *Request) (<-chan StreamEvent, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
 	adapter, err := c.resolveProvider(req)
 	if err != nil {
 		return nil, err
 	}
 
 	final := func(ctx context.Context, r *Request) (<-chan StreamEvent, error) {
-		return adapter.Stream(ctx, r)
+		l := c.limiterFor(r)
+		if l == nil {
+			return adapter.Stream(ctx, r)
+		}
+		if err := l.acquire(ctx); err != nil {
+			return nil, err
+		}
+		events, err := adapter.Stream(ctx, r)
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+		return limitStream(ctx, l, events), nil
 	}
 
 	chain := final
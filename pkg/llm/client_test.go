@@ -182,3 +182,54 @@ func TestSingleProviderDefaultSelection(t *testing.T) {
 		t.Errorf("expected ok, got %q", resp.Content)
 	}
 }
+
+func TestClientCatalogWarnings(t *testing.T) {
+	adapter := &mockAdapter{name: "test", response: &Response{Content: "ok"}}
+	catalog := NewModelCatalog(ModelInfo{ID: "no-tools", SupportsTools: false})
+	client := NewClient(WithProvider("test", adapter), WithCatalog(catalog))
+
+	// The adapter returns the same response each time, as a cache would.
+	for i := 0; i < 2; i++ {
+		resp, err := client.Complete(context.Background(), &Request{
+			Model:    "no-tools",
+			Messages: []Message{{Role: RoleUser, Content: "Hi"}},
+			Tools:    []Tool{{Name: "search"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(resp.Warnings) != 1 || resp.Warnings[0].Code != "unsupported_tools" {
+			t.Errorf("call %d: expected one unsupported_tools warning, got %v", i+1, resp.Warnings)
+		}
+	}
+	if len(adapter.response.Warnings) != 0 {
+		t.Errorf("expected the shared response to be left alone, got %v", adapter.response.Warnings)
+	}
+}
+
+func TestClientWithHeaders(t *testing.T) {
+	var got map[string]string
+	capture := func(ctx context.Context, req *Request, next MiddlewareNext) (*Response, error) {
+		got = req.Headers
+		return next(ctx, req)
+	}
+	client := NewClient(
+		WithProvider("test", &mockAdapter{name: "test", response: &Response{Content: "ok"}}),
+		WithHeaders(map[string]string{"X-Team": "core", "X-Trace": "default"}),
+		WithMiddleware(capture),
+	)
+
+	req := &Request{
+		Messages: []Message{{Role: RoleUser, Content: "Hi"}},
+		Headers:  map[string]string{"X-Trace": "request"},
+	}
+	if _, err := client.Complete(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["X-Team"] != "core" || got["X-Trace"] != "request" {
+		t.Errorf("expected client header plus request override, got %v", got)
+	}
+	if len(req.Headers) != 1 {
+		t.Errorf("expected caller's request to be unchanged, got %v", req.Headers)
+	}
+}
@@ -0,0 +1,175 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DebugLogEnv is the environment variable that enables request/response
+// logging in FromEnv. "1" or "true" logs under the system temp directory;
+// any other non-empty value is used as the base directory.
+const DebugLogEnv = "ATTRACTOR_LLM_DEBUG"
+
+// DebugLogDirFromEnv returns a fresh per-run log directory when DebugLogEnv
+// is set, or "" when debug logging is disabled.
+func DebugLogDirFromEnv() string {
+	v := strings.TrimSpace(os.Getenv(DebugLogEnv))
+	switch strings.ToLower(v) {
+	case "", "0", "false":
+		return ""
+	case "1", "true":
+		v = filepath.Join(os.TempDir(), "attractor-llm-debug")
+	}
+	return filepath.Join(v, time.Now().Format("20060102-150405.000000000"))
+}
+
+// debugLogEntry is the JSON document written for each call.
+type debugLogEntry struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	DurationMs int64       `json:"duration_ms"`
+	Stream     bool        `json:"stream,omitempty"`
+	Request    interface{} `json:"request"`
+	Response   interface{} `json:"response,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// debugLogger writes numbered, pretty-printed payloads to a directory.
+type debugLogger struct {
+	dir string
+	seq atomic.Int64
+}
+
+func (l *debugLogger) write(req *Request, entry debugLogEntry) {
+	entry.Request = redactPayload(req)
+	if entry.Response != nil {
+		entry.Response = redactPayload(entry.Response)
+	}
+	entry.Error = redactString(entry.Error)
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		return
+	}
+	name := fmt.Sprintf("%04d-%s.json", l.seq.Add(1), sanitizeFileName(req.Model))
+	os.WriteFile(filepath.Join(l.dir, name), data, 0o600)
+}
+
+// DebugLogMiddleware returns middleware that writes each request and response
+// as pretty JSON to dir, with API keys and credential fields redacted.
+// Write failures are ignored; logging is best-effort.
+func DebugLogMiddleware(dir string) Middleware {
+	l := &debugLogger{dir: dir}
+	return func(ctx context.Context, req *Request, next MiddlewareNext) (*Response, error) {
+		start := time.Now()
+		resp, err := next(ctx, req)
+		entry := debugLogEntry{Timestamp: start, DurationMs: time.Since(start).Milliseconds()}
+		if resp != nil {
+			entry.Response = resp
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		l.write(req, entry)
+		return resp, err
+	}
+}
+
+// DebugLogStreamMiddleware is the streaming counterpart of DebugLogMiddleware.
+// The accumulated response is written once the stream ends.
+func DebugLogStreamMiddleware(dir string) StreamMiddleware {
+	l := &debugLogger{dir: dir}
+	return func(ctx context.Context, req *Request, next StreamMiddlewareNext) (<-chan StreamEvent, error) {
+		start := time.Now()
+		in, err := next(ctx, req)
+		if err != nil {
+			l.write(req, debugLogEntry{Timestamp: start, Stream: true, Error: err.Error()})
+			return nil, err
+		}
+		out := make(chan StreamEvent, cap(in))
+		go func() {
+			defer close(out)
+			acc := &StreamAccumulator{}
+			var errs []string
+			for ev := range in {
+				acc.Process(ev)
+				if ev.Error != nil {
+					errs = append(errs, ev.Error.Error())
+				}
+				out <- ev
+			}
+			l.write(req, debugLogEntry{
+				Timestamp:  start,
+				DurationMs: time.Since(start).Milliseconds(),
+				Stream:     true,
+				Response:   acc.Response(),
+				Error:      strings.Join(errs, "; "),
+			})
+		}()
+		return out, nil
+	}
+}
+
+// sensitiveKeyPattern matches JSON object keys whose values are credentials.
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(api[_-]?key|authorization|password|secret|token)$`)
+
+// credentialPattern matches API key formats used by supported providers and
+// bearer tokens embedded in free text.
+var credentialPattern = regexp.MustCompile(`sk-(ant-)?[A-Za-z0-9_\-]{16,}|AIza[0-9A-Za-z_\-]{30,}|(?i:bearer)\s+[A-Za-z0-9._\-]{16,}`)
+
+// redactPayload returns a JSON-compatible copy of v with credentials removed.
+func redactPayload(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+	return redactValue(generic)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if _, isString := child.(string); isString && sensitiveKeyPattern.MatchString(k) {
+				val[k] = "[REDACTED]"
+				continue
+			}
+			val[k] = redactValue(child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = redactValue(child)
+		}
+		return val
+	case string:
+		return redactString(val)
+	default:
+		return v
+	}
+}
+
+func redactString(s string) string {
+	return credentialPattern.ReplaceAllString(s, "[REDACTED]")
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._\-]+`)
+
+func sanitizeFileName(s string) string {
+	if s == "" {
+		return "request"
+	}
+	return unsafeFileChars.ReplaceAllString(s, "_")
+}
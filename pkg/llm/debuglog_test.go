@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugLogMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	adapter := &mockAdapter{name: "test", response: &Response{Content: "key is sk-ant-REDACTED"}}
+	client := NewClient(WithProvider("test", adapter), WithMiddleware(DebugLogMiddleware(dir)))
+
+	_, err := client.Complete(context.Background(), &Request{
+		Model:           "claude/test",
+		Messages:        []Message{{Role: RoleUser, Content: "Hi"}},
+		ProviderOptions: map[string]interface{}{"anthropic": map[string]interface{}{"api_key": "plain-secret"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "0001-claude_test.json"))
+	if err != nil {
+		t.Fatalf("expected log file: %v", err)
+	}
+	log := string(data)
+	if !strings.Contains(log, `"content": "Hi"`) {
+		t.Errorf("expected pretty-printed request in log, got %s", log)
+	}
+	if strings.Contains(log, "plain-secret") || strings.Contains(log, "sk-ant-REDACTED") {
+		t.Errorf("expected credentials to be redacted, got %s", log)
+	}
+}
+
+func TestDebugLogStreamMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	adapter := &mockAdapter{name: "test", response: &Response{Content: "streamed"}}
+	client := NewClient(WithProvider("test", adapter), WithStreamMiddleware(DebugLogStreamMiddleware(dir)))
+
+	ch, err := client.Stream(context.Background(), &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for range ch {
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "0001-m.json"))
+	if err != nil {
+		t.Fatalf("expected log file: %v", err)
+	}
+	if !strings.Contains(string(data), `"content": "streamed"`) {
+		t.Errorf("expected accumulated stream response in log, got %s", data)
+	}
+}
+
+func TestDebugLogDirFromEnv(t *testing.T) {
+	t.Setenv(DebugLogEnv, "")
+	if dir := DebugLogDirFromEnv(); dir != "" {
+		t.Errorf("expected disabled, got %q", dir)
+	}
+	base := t.TempDir()
+	t.Setenv(DebugLogEnv, base)
+	if dir := DebugLogDirFromEnv(); filepath.Dir(dir) != base {
+		t.Errorf("expected run directory under %s, got %q", base, dir)
+	}
+}
@@ -1,7 +1,12 @@
 package llm
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,6 +19,9 @@ const (
 	ErrorTypeServer     ErrorType = "server"
 	ErrorTypeNetwork    ErrorType = "network"
 	ErrorTypeBadRequest ErrorType = "bad_request"
+	// ErrorTypeContextLength is a bad request whose prompt exceeds the
+	// model's context window. Retrying only helps with a shorter prompt.
+	ErrorTypeContextLength ErrorType = "context_length"
 	ErrorTypeTimeout    ErrorType = "timeout"
 	ErrorTypeUnknown    ErrorType = "unknown"
 )
@@ -25,7 +33,9 @@ type LLMError struct {
 	StatusCode int       `json:"status_code,omitempty"`
 	Provider   string    `json:"provider,omitempty"`
 	RetryAfter time.Duration `json:"retry_after,omitempty"`
-	Cause      error     `json:"-"`
+	// ResetAt is when the provider said an exhausted rate limit resets.
+	ResetAt time.Time `json:"reset_at,omitempty"`
+	Cause   error     `json:"-"`
 }
 
 func (e *LLMError) Error() string {
@@ -49,6 +59,82 @@ func (e *LLMError) IsRetryable() bool {
 	}
 }
 
+// RetryDelay returns how long the provider asked callers to wait before
+// retrying: the longer of RetryAfter and the time until ResetAt. It is 0 when
+// the provider gave no hint.
+func (e *LLMError) RetryDelay() time.Duration {
+	delay := e.RetryAfter
+	if !e.ResetAt.IsZero() {
+		delay = max(delay, time.Until(e.ResetAt))
+	}
+	return max(delay, 0)
+}
+
+// ClassifyHTTPResponse is ClassifyHTTPError plus the retry hints of the
+// response: the Retry-After and retry-after-ms headers, the rate limit
+// reset headers of Anthropic and OpenAI, and the retryDelay of a Gemini
+// error body.
+func ClassifyHTTPResponse(resp *http.Response, body string, provider string) *LLMError {
+	err := ClassifyHTTPError(resp.StatusCode, body, provider)
+	err.RetryAfter, err.ResetAt = retryHints(resp.Header, body, time.Now())
+	return err
+}
+
+// geminiRetryDelay matches the retryDelay of a google.rpc.RetryInfo detail.
+var geminiRetryDelay = regexp.MustCompile(`"retryDelay"\s*:\s*"([0-9.]+s)"`)
+
+// retryHints extracts the wait a response asked for, as a duration, a reset
+// time, or both.
+func retryHints(h http.Header, body string, now time.Time) (time.Duration, time.Time) {
+	var after time.Duration
+	var resetAt time.Time
+
+	if v := h.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			after = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	if v := h.Get("Retry-After"); v != "" && after == 0 {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			after = time.Duration(secs * float64(time.Second))
+		} else if t, err := http.ParseTime(v); err == nil {
+			resetAt = t
+		}
+	}
+	if m := geminiRetryDelay.FindStringSubmatch(body); m != nil && after == 0 {
+		if d, err := time.ParseDuration(m[1]); err == nil {
+			after = d
+		}
+	}
+
+	// Reset headers describe every limit; only exhausted ones matter.
+	for _, limit := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		if h.Get("anthropic-ratelimit-"+limit+"-remaining") == "0" {
+			if t, err := time.Parse(time.RFC3339, h.Get("anthropic-ratelimit-"+limit+"-reset")); err == nil && t.After(resetAt) {
+				resetAt = t
+			}
+		}
+		if h.Get("x-ratelimit-remaining-"+limit) == "0" {
+			if d, err := time.ParseDuration(strings.TrimSpace(h.Get("x-ratelimit-reset-" + limit))); err == nil && now.Add(d).After(resetAt) {
+				resetAt = now.Add(d)
+			}
+		}
+	}
+	return after, resetAt
+}
+
+// IsContextLengthError reports whether err is, or wraps, an LLMError of type
+// ErrorTypeContextLength.
+func IsContextLengthError(err error) bool {
+	var llmErr *LLMError
+	return errors.As(err, &llmErr) && llmErr.Type == ErrorTypeContextLength
+}
+
+// contextLengthMessage matches the context overflow errors of Anthropic
+// ("prompt is too long"), OpenAI ("context_length_exceeded", "maximum
+// context length"), and Gemini ("input token count ... exceeds").
+var contextLengthMessage = regexp.MustCompile(`(?i)prompt is too long|context_length_exceeded|maximum context length|context window|input token count.*exceeds|too many (input )?tokens`)
+
 // ClassifyHTTPError maps HTTP status codes to error types.
 func ClassifyHTTPError(statusCode int, body string, provider string) *LLMError {
 	var errType ErrorType
@@ -57,6 +143,8 @@ func ClassifyHTTPError(statusCode int, body string, provider string) *LLMError {
 		errType = ErrorTypeAuth
 	case statusCode == 429:
 		errType = ErrorTypeRateLimit
+	case (statusCode == 400 || statusCode == 413 || statusCode == 422) && contextLengthMessage.MatchString(body):
+		errType = ErrorTypeContextLength
 	case statusCode == 400 || statusCode == 422:
 		errType = ErrorTypeBadRequest
 	case statusCode >= 500:
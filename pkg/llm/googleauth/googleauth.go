@@ -0,0 +1,252 @@
+// Package googleauth obtains OAuth access tokens for Google Cloud APIs from
+// Application Default Credentials. Provider adapters that reach models
+// through Vertex AI use it.
+package googleauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// TokenSource supplies OAuth access tokens.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token, such as
+// the output of `gcloud auth print-access-token`.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+// DefaultCredentials returns a TokenSource for Google Application Default
+// Credentials, looked up on first use in this order: the JSON file named by
+// GOOGLE_APPLICATION_CREDENTIALS, the file written by `gcloud auth
+// application-default login`, and the metadata server of a Google Cloud VM.
+// Service account and authorized user files are supported. Tokens are
+// cached until shortly before they expire. client is used for token
+// requests.
+func DefaultCredentials(client *http.Client) TokenSource {
+	return &adcTokenSource{client: client}
+}
+
+type adcTokenSource struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	fetch   func(ctx context.Context) (string, time.Time, error)
+	token   string
+	expires time.Time
+}
+
+func (s *adcTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > time.Minute {
+		return s.token, nil
+	}
+	if s.fetch == nil {
+		fetch, err := s.findCredentials()
+		if err != nil {
+			return "", err
+		}
+		s.fetch = fetch
+	}
+	token, expires, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, expires
+	return token, nil
+}
+
+// credentialsFile is the subset of a Google credentials JSON file we use.
+type credentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *adcTokenSource) findCredentials() (func(ctx context.Context) (string, time.Time, error), error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		dir := os.Getenv("CLOUDSDK_CONFIG")
+		if dir == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				dir = filepath.Join(home, ".config", "gcloud")
+			}
+		}
+		if dir != "" {
+			if candidate := filepath.Join(dir, "application_default_credentials.json"); fileExists(candidate) {
+				path = candidate
+			}
+		}
+	}
+	if path == "" {
+		return s.metadataToken, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds credentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch creds.Type {
+	case "service_account":
+		key, err := parsePrivateKey(creds.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if creds.TokenURI == "" {
+			creds.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		return func(ctx context.Context) (string, time.Time, error) {
+			assertion, err := signJWT(key, creds.ClientEmail, creds.TokenURI, time.Now())
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			return s.exchange(ctx, creds.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}, nil
+	case "authorized_user":
+		return func(ctx context.Context) (string, time.Time, error) {
+			return s.exchange(ctx, "https://oauth2.googleapis.com/token", url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {creds.ClientID},
+				"client_secret": {creds.ClientSecret},
+				"refresh_token": {creds.RefreshToken},
+			})
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported credentials type %q", path, creds.Type)
+	}
+}
+
+// exchange posts an OAuth token request and returns the access token.
+func (s *adcTokenSource) exchange(ctx context.Context, tokenURL string, form url.Values) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.doTokenRequest(req)
+}
+
+// metadataToken asks the metadata server of a Google Cloud VM for a token
+// of its service account. GCE_METADATA_HOST overrides the server address.
+func (s *adcTokenSource) metadataToken(ctx context.Context) (string, time.Time, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	u := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" + url.QueryEscape(cloudPlatformScope)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, expires, err := s.doTokenRequest(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("no credentials file and metadata server unavailable: %w", err)
+	}
+	return token, expires, nil
+}
+
+func (s *adcTokenSource) doTokenRequest(req *http.Request) (string, time.Time, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", time.Time{}, fmt.Errorf("token request: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", time.Time{}, fmt.Errorf("token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", time.Time{}, errors.New("token response has no access_token")
+	}
+	return tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second), nil
+}
+
+// parsePrivateKey parses the PEM RSA key of a service account.
+func parsePrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private_key is not an RSA key")
+	}
+	return key, nil
+}
+
+// signJWT returns the RS256-signed assertion a service account exchanges
+// for an access token.
+func signJWT(key *rsa.PrivateKey, email, audience string, now time.Time) (string, error) {
+	header := `{"alg":"RS256","typ":"JWT"}`
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": cloudPlatformScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
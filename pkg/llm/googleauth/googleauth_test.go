@@ -0,0 +1,85 @@
+package googleauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServiceAccountCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("grant_type = %q", r.Form.Get("grant_type"))
+		}
+		if parts := strings.Split(r.Form.Get("assertion"), "."); len(parts) != 3 {
+			t.Errorf("assertion is not a JWT: %q", r.Form.Get("assertion"))
+		}
+		io.WriteString(w, `{"access_token":"sa-token","expires_in":3600,"token_type":"Bearer"}`)
+	}))
+	defer tokenServer.Close()
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	creds, _ := json.Marshal(credentialsFile{
+		Type:        "service_account",
+		ClientEmail: "bot@proj.iam.gserviceaccount.com",
+		PrivateKey:  string(pemKey),
+		TokenURI:    tokenServer.URL,
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	ts := DefaultCredentials(http.DefaultClient)
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		if token != "sa-token" {
+			t.Errorf("token = %q, want sa-token", token)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("token endpoint called %d times, want 1 (cached)", calls)
+	}
+}
+
+func TestMetadataServerCredentials(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			t.Errorf("missing Metadata-Flavor header")
+		}
+		io.WriteString(w, `{"access_token":"vm-token","expires_in":3600}`)
+	}))
+	defer metadata.Close()
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadata.URL, "http://"))
+
+	token, err := DefaultCredentials(http.DefaultClient).Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if token != "vm-token" {
+		t.Errorf("token = %q, want vm-token", token)
+	}
+}
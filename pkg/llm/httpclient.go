@@ -0,0 +1,198 @@
+package llm
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// CABundleEnv names the environment variable holding the path of a PEM file
+// of extra CA certificates that provider adapters trust, for networks that
+// intercept TLS with a corporate CA.
+const CABundleEnv = "ATTRACTOR_CA_BUNDLE"
+
+// Default timeouts of provider requests. Reasoning models can think for
+// minutes before answering, so the request timeout is generous; streams are
+// bounded by the idle timeout instead.
+const (
+	DefaultConnectTimeout    = 30 * time.Second
+	DefaultRequestTimeout    = 10 * time.Minute
+	DefaultStreamIdleTimeout = 5 * time.Minute
+)
+
+// HTTPConfig configures the HTTP client of a provider adapter. The zero value
+// gives a client that uses the proxy named by HTTPS_PROXY, HTTP_PROXY, and
+// NO_PROXY, and trusts the system roots plus the certificates in the file
+// named by ATTRACTOR_CA_BUNDLE.
+type HTTPConfig struct {
+	// Client, if set, is used as is; the other fields are ignored.
+	Client *http.Client
+	// Proxy, if set, replaces the proxy from the environment.
+	Proxy *url.URL
+	// TLSConfig, if set, replaces the default TLS configuration, including
+	// the ATTRACTOR_CA_BUNDLE certificates.
+	TLSConfig *tls.Config
+	// ConnectTimeout bounds dialing and the TLS handshake.
+	ConnectTimeout time.Duration
+	// RequestTimeout bounds a whole non-streaming request, from connecting
+	// to reading the last byte of the response. Streams are exempt.
+	RequestTimeout time.Duration
+	// StreamIdleTimeout ends a stream that sends no data for this long,
+	// including a stream whose response headers never arrive.
+	StreamIdleTimeout time.Duration
+}
+
+// NewClient returns the HTTP client described by c. If ATTRACTOR_CA_BUNDLE
+// cannot be loaded, every request through the client fails with the reason.
+func (c HTTPConfig) NewClient() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	// No client timeout: it would cut off long streams. Requests get their
+	// deadline from RequestContext instead.
+	client := &http.Client{}
+
+	tlsConfig := c.TLSConfig
+	if tlsConfig == nil {
+		var err error
+		if tlsConfig, err = caBundleTLSConfig(os.Getenv(CABundleEnv)); err != nil {
+			client.Transport = failingTransport{err}
+			return client
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	connect := cmp.Or(c.ConnectTimeout, DefaultConnectTimeout)
+	transport.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connect
+	if c.Proxy != nil {
+		transport.Proxy = http.ProxyURL(c.Proxy)
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	client.Transport = transport
+	return client
+}
+
+// RequestContext returns ctx with the deadline for a non-streaming request:
+// req.Timeout if set, else RequestTimeout, else DefaultRequestTimeout. req
+// may be nil.
+func (c HTTPConfig) RequestContext(ctx context.Context, req *Request) (context.Context, context.CancelFunc) {
+	timeout := cmp.Or(c.RequestTimeout, DefaultRequestTimeout)
+	if req != nil && req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// StreamContext returns ctx for a streaming request, canceled if the
+// response has not started to arrive within the stream idle timeout (see
+// StreamBody). The cause of that cancellation, context.Cause(ctx), is an
+// ErrorTypeTimeout LLMError. Call cancel once the stream ends.
+func (c HTTPConfig) StreamContext(ctx context.Context, req *Request, provider string) (context.Context, context.CancelFunc) {
+	idle := c.streamIdleTimeout(req)
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(idle, func() {
+		cancel(&LLMError{
+			Type:     ErrorTypeTimeout,
+			Message:  fmt.Sprintf("no response headers within %s", idle),
+			Provider: provider,
+		})
+	})
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { timer.Stop() },
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// StreamBody wraps the body of a streamed response so that a read fails with
+// an ErrorTypeTimeout LLMError, and the body is closed, once no data has
+// arrived for req.StreamIdleTimeout, StreamIdleTimeout, or
+// DefaultStreamIdleTimeout, in that order of precedence.
+func (c HTTPConfig) StreamBody(body io.ReadCloser, req *Request, provider string) io.ReadCloser {
+	idle := c.streamIdleTimeout(req)
+	w := &idleBody{body: body, idle: idle, provider: provider}
+	w.timer = time.AfterFunc(idle, func() {
+		w.expired.Store(true)
+		body.Close()
+	})
+	return w
+}
+
+func (c HTTPConfig) streamIdleTimeout(req *Request) time.Duration {
+	if req != nil && req.StreamIdleTimeout > 0 {
+		return req.StreamIdleTimeout
+	}
+	return cmp.Or(c.StreamIdleTimeout, DefaultStreamIdleTimeout)
+}
+
+// idleBody is a response body closed when reads stall.
+type idleBody struct {
+	body     io.ReadCloser
+	idle     time.Duration
+	provider string
+	timer    *time.Timer
+	expired  atomic.Bool
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.expired.Load() {
+		return n, &LLMError{
+			Type:     ErrorTypeTimeout,
+			Message:  fmt.Sprintf("stream sent no data for %s", b.idle),
+			Provider: b.provider,
+		}
+	}
+	if n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
+
+// caBundleTLSConfig returns a TLS configuration trusting the system roots and
+// the PEM certificates in path, or nil when path is empty.
+func caBundleTLSConfig(path string) (*tls.Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", CABundleEnv, err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates in %s", CABundleEnv, path)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// failingTransport fails every request with err.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPConfigProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := HTTPConfig{Proxy: proxyURL}.NewClient()
+	resp, err := client.Get("http://api.example.invalid/v1/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://api.example.invalid/v1/messages" {
+		t.Errorf("expected the request to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestHTTPConfigCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	// Without the server's certificate the request fails verification.
+	t.Setenv(CABundleEnv, "")
+	if _, err := (HTTPConfig{}).NewClient().Get(server.URL); err == nil {
+		t.Fatal("expected a certificate error")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(CABundleEnv, bundle)
+	resp, err := (HTTPConfig{}).NewClient().Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the CA bundle to be trusted: %v", err)
+	}
+	resp.Body.Close()
+
+	t.Setenv(CABundleEnv, filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := (HTTPConfig{}).NewClient().Get(server.URL); err == nil || !strings.Contains(err.Error(), CABundleEnv) {
+		t.Errorf("expected an error naming %s, got %v", CABundleEnv, err)
+	}
+}
+
+func TestHTTPConfigClient(t *testing.T) {
+	custom := &http.Client{}
+	if got := (HTTPConfig{Client: custom, Proxy: &url.URL{Host: "ignored"}}).NewClient(); got != custom {
+		t.Error("expected the custom client to be used as is")
+	}
+}
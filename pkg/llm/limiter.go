@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// WithMaxConcurrent allows at most n requests to provider in flight at once,
+// so parallel pipeline branches and subagents sharing the client queue up
+// instead of tripping the provider's rate limits together. Requests over the
+// limit wait in first-come, first-served order; one whose context ends while
+// it waits leaves the queue with a timeout error (or the context's error, if
+// cancelled) without reaching the provider. A stream holds its slot until it
+// ends. n <= 0 removes the limit.
+//
+// The limit applies per attempt: a request retried by RetryMiddleware frees
+// its slot while it backs off.
+func WithMaxConcurrent(provider string, n int) ClientOption {
+	return func(c *Client) {
+		if n <= 0 {
+			delete(c.limiters, provider)
+			return
+		}
+		if c.limiters == nil {
+			c.limiters = make(map[string]*limiter)
+		}
+		c.limiters[provider] = &limiter{provider: provider, max: n}
+	}
+}
+
+// limiter is a counting semaphore with a FIFO queue of waiters.
+type limiter struct {
+	provider string
+	max      int
+
+	mu      sync.Mutex
+	active  int
+	waiters []chan struct{}
+}
+
+// acquire takes a slot, waiting behind earlier callers until one is free or
+// ctx ends.
+func (l *limiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.active < l.max && len(l.waiters) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	granted := true
+	for i, w := range l.waiters {
+		if w == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			granted = false
+			break
+		}
+	}
+	l.mu.Unlock()
+	if granted {
+		// The slot was handed over as ctx ended; pass it on.
+		l.release()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &LLMError{
+			Type:     ErrorTypeTimeout,
+			Message:  fmt.Sprintf("deadline passed waiting for one of %d concurrent %s requests to finish", l.max, l.provider),
+			Provider: l.provider,
+			Cause:    ctx.Err(),
+		}
+	}
+	return ctx.Err()
+}
+
+// release frees a slot, handing it to the longest waiter if there is one.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.waiters) > 0 {
+		ready := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		close(ready)
+		return
+	}
+	l.active--
+}
+
+// limiterFor returns the concurrency limit of the provider req resolves
+// to, or nil if it has none.
+func (c *Client) limiterFor(req *Request) *limiter {
+	if len(c.limiters) == 0 {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	provider := req.Provider
+	if provider == "" {
+		provider = c.defaultProvider
+	}
+	return c.limiters[provider]
+}
+
+// limitStream holds l's slot until events is drained or ctx ends.
+func limitStream(ctx context.Context, l *limiter, events <-chan StreamEvent) <-chan StreamEvent {
+	out := make(chan StreamEvent)
+	go func() {
+		defer close(out)
+		defer l.release()
+		sender := NewStreamSender(ctx, out)
+		for ev := range events {
+			if !sender.Send(ev) {
+				sender.Finish(nil)
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateAdapter blocks each call until the test releases it, tracking how
+// many are in flight.
+type gateAdapter struct {
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	started  chan string
+	gate     chan struct{}
+}
+
+func newGateAdapter() *gateAdapter {
+	return &gateAdapter{started: make(chan string, 10), gate: make(chan struct{})}
+}
+
+func (g *gateAdapter) Name() string { return "gate" }
+func (g *gateAdapter) Close() error { return nil }
+
+func (g *gateAdapter) enter(req *Request) {
+	g.mu.Lock()
+	g.inFlight++
+	if g.inFlight > g.peak {
+		g.peak = g.inFlight
+	}
+	g.mu.Unlock()
+	g.started <- req.Messages[0].Content
+}
+
+func (g *gateAdapter) leave() {
+	g.mu.Lock()
+	g.inFlight--
+	g.mu.Unlock()
+}
+
+func (g *gateAdapter) Complete(ctx context.Context, req *Request) (*Response, error) {
+	g.enter(req)
+	defer g.leave()
+	<-g.gate
+	return &Response{Content: req.Messages[0].Content}, nil
+}
+
+func (g *gateAdapter) Stream(ctx context.Context, req *Request) (<-chan StreamEvent, error) {
+	g.enter(req)
+	ch := make(chan StreamEvent)
+	go func() {
+		defer close(ch)
+		defer g.leave()
+		<-g.gate
+		ch <- StreamEvent{Type: StreamEventEnd, FinishReason: FinishReasonStop}
+	}()
+	return ch, nil
+}
+
+func userRequest(content string) *Request {
+	return &Request{Messages: []Message{{Role: RoleUser, Content: content}}}
+}
+
+func TestMaxConcurrentQueuesInOrder(t *testing.T) {
+	adapter := newGateAdapter()
+	client := NewClient(WithProvider("gate", adapter), WithMaxConcurrent("gate", 1))
+
+	var wg sync.WaitGroup
+	call := func(name string) {
+		defer wg.Done()
+		if _, err := client.Complete(context.Background(), userRequest(name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	wg.Add(1)
+	go call("first")
+	if got := <-adapter.started; got != "first" {
+		t.Fatalf("started %q, want first", got)
+	}
+	// Queue the others one at a time so their order is known.
+	for _, name := range []string{"second", "third"} {
+		wg.Add(1)
+		go call(name)
+		waitForWaiters(t, client.limiters["gate"], name)
+	}
+
+	for _, want := range []string{"second", "third"} {
+		adapter.gate <- struct{}{}
+		if got := <-adapter.started; got != want {
+			t.Errorf("started %q, want %s", got, want)
+		}
+	}
+	adapter.gate <- struct{}{}
+	wg.Wait()
+	if adapter.peak != 1 {
+		t.Errorf("peak concurrency = %d, want 1", adapter.peak)
+	}
+}
+
+// waitForWaiters waits until name's request has joined l's queue.
+func waitForWaiters(t *testing.T, l *limiter, name string) {
+	t.Helper()
+	want := map[string]int{"second": 1, "third": 2}[name]
+	for i := 0; i < 1000; i++ {
+		l.mu.Lock()
+		n := len(l.waiters)
+		l.mu.Unlock()
+		if n == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s never queued", name)
+}
+
+func TestMaxConcurrentDeadline(t *testing.T) {
+	adapter := newGateAdapter()
+	client := NewClient(WithProvider("gate", adapter), WithMaxConcurrent("gate", 1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Complete(context.Background(), userRequest("first"))
+	}()
+	<-adapter.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.Complete(ctx, userRequest("late"))
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) || llmErr.Type != ErrorTypeTimeout {
+		t.Fatalf("err = %v, want a timeout error", err)
+	}
+
+	// The abandoned request must not hold a slot.
+	adapter.gate <- struct{}{}
+	<-done
+	go client.Complete(context.Background(), userRequest("next"))
+	select {
+	case got := <-adapter.started:
+		if got != "next" {
+			t.Errorf("started %q, want next", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slot was not released")
+	}
+	adapter.gate <- struct{}{}
+}
+
+func TestMaxConcurrentStreamHoldsSlot(t *testing.T) {
+	adapter := newGateAdapter()
+	client := NewClient(WithProvider("gate", adapter), WithMaxConcurrent("gate", 1))
+
+	events, err := client.Stream(context.Background(), userRequest("stream"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-adapter.started
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Complete(context.Background(), userRequest("after"))
+	}()
+	select {
+	case got := <-adapter.started:
+		t.Fatalf("%s started while the stream was open", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	adapter.gate <- struct{}{}
+	for range events {
+	}
+	if got := <-adapter.started; got != "after" {
+		t.Errorf("started %q, want after", got)
+	}
+	adapter.gate <- struct{}{}
+	<-done
+}
@@ -0,0 +1,36 @@
+package llm
+
+import "math"
+
+// TokenLogprob is the log probability of one output token, with the
+// likeliest alternatives at its position when Request.TopLogprobs is set.
+type TokenLogprob struct {
+	Token       string             `json:"token"`
+	Logprob     float64            `json:"logprob"`
+	TopLogprobs []TokenAlternative `json:"top_logprobs,omitempty"`
+}
+
+// TokenAlternative is a token the model could have produced instead.
+type TokenAlternative struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+// Probability returns the token's probability, between 0 and 1.
+func (t TokenLogprob) Probability() float64 {
+	return math.Exp(t.Logprob)
+}
+
+// Confidence returns the geometric mean of the output tokens'
+// probabilities, a 0-1 score of how sure the model was of its answer as a
+// whole. It reports false when the response has no logprobs.
+func (r *Response) Confidence() (float64, bool) {
+	if len(r.Logprobs) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, t := range r.Logprobs {
+		sum += t.Logprob
+	}
+	return math.Exp(sum / float64(len(r.Logprobs))), true
+}
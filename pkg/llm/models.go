@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"sort"
+)
+
+// ModelLister is implemented by provider adapters that can list the models
+// available to their account.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ProviderModels is the model list of one provider.
+type ProviderModels struct {
+	Provider string      `json:"provider"`
+	Models   []ModelInfo `json:"models"`
+	// Live is true when the list came from the provider's API rather than
+	// the catalog.
+	Live bool `json:"live"`
+	// Err is why the provider's API could not be used, if it was tried.
+	Err error `json:"-"`
+}
+
+// ListProviderModels lists the models of each registered provider, or only
+// of provider if it is non-empty. Providers whose adapter is a ModelLister
+// are queried, and what the API doesn't report (context window, tool
+// support, pricing) is filled in from the catalog; the others, and any
+// whose query fails, fall back to the catalog's models.
+func (c *Client) ListProviderModels(ctx context.Context, provider string) []ProviderModels {
+	c.mu.RLock()
+	var names []string
+	adapters := make(map[string]ProviderAdapter)
+	for name, adapter := range c.providers {
+		if provider == "" || name == provider {
+			names = append(names, name)
+			adapters[name] = adapter
+		}
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+
+	catalog := c.Catalog()
+	var result []ProviderModels
+	for _, name := range names {
+		pm := ProviderModels{Provider: name}
+		if lister, ok := adapters[name].(ModelLister); ok {
+			models, err := lister.ListModels(ctx)
+			if err == nil {
+				pm.Live = true
+				for _, m := range models {
+					pm.Models = append(pm.Models, withCatalogInfo(catalog, name, m))
+				}
+			}
+			pm.Err = err
+		}
+		if !pm.Live {
+			pm.Models = catalog.List(name)
+		}
+		result = append(result, pm)
+	}
+	return result
+}
+
+// withCatalogInfo fills the fields the provider didn't report from the
+// catalog entry for the model, if any.
+func withCatalogInfo(catalog *ModelCatalog, provider string, m ModelInfo) ModelInfo {
+	m.Provider = provider
+	known, ok := catalog.Lookup(m.ID)
+	if !ok {
+		return m
+	}
+	if m.DisplayName == "" {
+		m.DisplayName = known.DisplayName
+	}
+	if m.ContextWindow == 0 {
+		m.ContextWindow = known.ContextWindow
+	}
+	if m.MaxOutput == 0 {
+		m.MaxOutput = known.MaxOutput
+	}
+	m.SupportsVision = m.SupportsVision || known.SupportsVision
+	m.SupportsTools = m.SupportsTools || known.SupportsTools
+	m.SupportsReasoning = m.SupportsReasoning || known.SupportsReasoning
+	if m.InputCostPerMTok == 0 && m.OutputCostPerMTok == 0 {
+		m.InputCostPerMTok = known.InputCostPerMTok
+		m.OutputCostPerMTok = known.OutputCostPerMTok
+	}
+	return m
+}
@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// listingAdapter is a mockAdapter that can list its models.
+type listingAdapter struct {
+	mockAdapter
+	models []ModelInfo
+	err    error
+}
+
+func (a *listingAdapter) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	return a.models, a.err
+}
+
+func TestListProviderModels(t *testing.T) {
+	catalog := NewModelCatalog(
+		ModelInfo{ID: "live-1", Provider: "live", ContextWindow: 100000, SupportsTools: true, InputCostPerMTok: 1, OutputCostPerMTok: 2},
+		ModelInfo{ID: "static-1", Provider: "static", ContextWindow: 8000},
+		ModelInfo{ID: "broken-1", Provider: "broken"},
+	)
+	client := NewClient(
+		WithCatalog(catalog),
+		WithProvider("live", &listingAdapter{models: []ModelInfo{{ID: "live-1", DisplayName: "Live One"}, {ID: "live-new"}}}),
+		WithProvider("static", &mockAdapter{name: "static"}),
+		WithProvider("broken", &listingAdapter{err: errors.New("unauthorized")}),
+	)
+
+	lists := client.ListProviderModels(context.Background(), "")
+	if len(lists) != 3 || lists[0].Provider != "broken" || lists[1].Provider != "live" || lists[2].Provider != "static" {
+		t.Fatalf("expected providers sorted by name, got %+v", lists)
+	}
+
+	broken := lists[0]
+	if broken.Live || broken.Err == nil || len(broken.Models) != 1 {
+		t.Errorf("expected catalog fallback with error, got %+v", broken)
+	}
+
+	live := lists[1]
+	if !live.Live || len(live.Models) != 2 {
+		t.Fatalf("expected 2 live models, got %+v", live)
+	}
+	m := live.Models[0]
+	if m.DisplayName != "Live One" || m.ContextWindow != 100000 || !m.SupportsTools || m.OutputCostPerMTok != 2 || m.Provider != "live" {
+		t.Errorf("expected live model enriched from catalog, got %+v", m)
+	}
+	if live.Models[1].ContextWindow != 0 {
+		t.Errorf("expected unknown model to stay bare, got %+v", live.Models[1])
+	}
+
+	static := lists[2]
+	if static.Live || static.Err != nil || len(static.Models) != 1 || static.Models[0].ID != "static-1" {
+		t.Errorf("expected catalog models, got %+v", static)
+	}
+
+	if only := client.ListProviderModels(context.Background(), "static"); len(only) != 1 {
+		t.Errorf("expected one provider when filtered, got %d", len(only))
+	}
+}
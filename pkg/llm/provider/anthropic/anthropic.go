@@ -2,18 +2,21 @@
 package anthropic
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/ashka-vakil/attractor/internal/sse"
 	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/googleauth"
 )
 
 func init() {
@@ -27,7 +30,17 @@ type Adapter struct {
 	apiKey     string
 	baseURL    string
 	httpClient *http.Client
+	httpConfig llm.HTTPConfig
 	headers    map[string]string
+
+	// Cloud transport settings; see WithTransport.
+	transport    string
+	transportErr error
+	region       string
+	project      string
+	aws          awsCredentials
+	bedrockToken string
+	tokens       googleauth.TokenSource
 }
 
 // Option configures the Anthropic adapter.
@@ -48,17 +61,52 @@ func WithHeaders(h map[string]string) Option {
 	return func(a *Adapter) { a.headers = h }
 }
 
+// WithHTTPClient sets the HTTP client used for API requests, in place of
+// one built from the proxy and TLS options.
+func WithHTTPClient(c *http.Client) Option {
+	return func(a *Adapter) { a.httpConfig.Client = c }
+}
+
+// WithProxy sends requests through the proxy at proxyURL instead of the one
+// named by HTTPS_PROXY.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(a *Adapter) { a.httpConfig.Proxy = proxyURL }
+}
+
+// WithConnectTimeout bounds dialing and the TLS handshake (default: 30s).
+func WithConnectTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.ConnectTimeout = d }
+}
+
+// WithRequestTimeout bounds a whole non-streaming request (default: 10m).
+// Request.Timeout overrides it per call.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.RequestTimeout = d }
+}
+
+// WithStreamIdleTimeout ends a stream that sends no data for d (default:
+// 5m). Streams have no overall deadline.
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.StreamIdleTimeout = d }
+}
+
+// WithTLSConfig sets the TLS configuration, e.g. to trust a custom CA.
+func WithTLSConfig(c *tls.Config) Option {
+	return func(a *Adapter) { a.httpConfig.TLSConfig = c }
+}
+
 // NewAdapter creates a new Anthropic adapter.
 func NewAdapter(opts ...Option) *Adapter {
-	a := &Adapter{
-		baseURL: "https://api.anthropic.com",
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-		},
-	}
+	a := &Adapter{}
 	for _, opt := range opts {
 		opt(a)
 	}
+	a.httpClient = a.httpConfig.NewClient()
+	defaultURL, err := a.configureTransport()
+	a.transportErr = err
+	if a.baseURL == "" {
+		a.baseURL = defaultURL
+	}
 	if a.apiKey == "" {
 		a.apiKey = os.Getenv("ANTHROPIC_API_KEY")
 	}
@@ -171,7 +219,7 @@ func (a *Adapter) buildRequest(req *llm.Request) messagesRequest {
 
 	maxTokens := req.MaxTokens
 	if maxTokens == 0 {
-		maxTokens = 4096
+		maxTokens = limitsFor(req).defaultMaxTokens
 	}
 
 	mr := messagesRequest{
@@ -218,26 +266,36 @@ func (a *Adapter) buildRequest(req *llm.Request) messagesRequest {
 	return mr
 }
 
-func (a *Adapter) doRequest(ctx context.Context, body interface{}, stream bool) (*http.Response, error) {
-	data, err := json.Marshal(body)
+// doRequest posts body, with req's provider options merged in, and the
+// headers set by setHeaders, shaped for the adapter's transport.
+func (a *Adapter) doRequest(ctx context.Context, body interface{}, req *llm.Request, stream bool) (*http.Response, error) {
+	if a.transportErr != nil {
+		return nil, a.transportErr
+	}
+	data, err := llm.EncodeRequestBody(body, "anthropic", req.ProviderOptions)
+	if err == nil {
+		data, err = a.transportBody(data, req.Headers)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/v1/messages", bytes.NewReader(data))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.messagesURL(req.Model, stream), bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", a.apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-	for k, v := range a.headers {
-		httpReq.Header.Set(k, v)
+	a.setHeaders(httpReq, req.Headers)
+	if err := a.authorize(ctx, httpReq, data); err != nil {
+		return nil, err
 	}
 
 	resp, err := a.httpClient.Do(httpReq)
 	if err != nil {
+		if cause, ok := context.Cause(ctx).(*llm.LLMError); ok {
+			return nil, cause
+		}
 		return nil, &llm.LLMError{
 			Type:     llm.ErrorTypeNetwork,
 			Message:  err.Error(),
@@ -249,38 +307,96 @@ func (a *Adapter) doRequest(ctx context.Context, body interface{}, stream bool)
 	if resp.StatusCode >= 400 {
 		respBody, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, llm.ClassifyHTTPError(resp.StatusCode, string(respBody), "anthropic")
+		return nil, llm.ClassifyHTTPResponse(resp, string(respBody), "anthropic")
 	}
 
 	return resp, nil
 }
 
+// setHeaders sets the authentication headers, then the adapter's headers,
+// then the request's own headers. Cloud transports authenticate in
+// authorize instead.
+func (a *Adapter) setHeaders(httpReq *http.Request, headers map[string]string) {
+	if a.transport == TransportDirect {
+		httpReq.Header.Set("x-api-key", a.apiKey)
+		httpReq.Header.Set("anthropic-version", "2023-06-01")
+	}
+	for k, v := range a.headers {
+		httpReq.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+}
+
+// getJSON sends a GET request for path and decodes the JSON response into out.
+func (a *Adapter) getJSON(ctx context.Context, path string, out interface{}) error {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, nil)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	a.setHeaders(httpReq, nil)
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return &llm.LLMError{
+			Type:     llm.ErrorTypeNetwork,
+			Message:  err.Error(),
+			Provider: "anthropic",
+			Cause:    err,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return llm.ClassifyHTTPResponse(resp, string(body), "anthropic")
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
 func (a *Adapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, req)
+	defer cancel()
+	if _, err := resolveMaxTokens(req); err != nil {
+		return nil, err
+	}
 	mr := a.buildRequest(req)
 	mr.Stream = false
 
-	resp, err := a.doRequest(ctx, mr, false)
+	resp, err := a.doRequest(ctx, mr, req, false)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var msgResp messagesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&msgResp); err != nil {
+	raw, err := llm.DecodeResponse(resp.Body, &msgResp)
+	if err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
 
-	return a.convertResponse(&msgResp), nil
+	result := a.convertResponse(&msgResp)
+	result.Raw = raw
+	result.Headers = resp.Header
+	return result, nil
 }
 
 func convertStopReason(reason string) llm.FinishReason {
 	switch reason {
-	case "end_turn":
+	case "end_turn", "stop_sequence":
 		return llm.FinishReasonStop
-	case "max_tokens":
+	case "max_tokens", "model_context_window_exceeded":
 		return llm.FinishReasonLength
 	case "tool_use":
 		return llm.FinishReasonToolCalls
+	case "refusal":
+		return llm.FinishReasonContentFilter
 	default:
 		return llm.FinishReasonStop
 	}
@@ -321,31 +437,50 @@ func (a *Adapter) convertResponse(mr *messagesResponse) *llm.Response {
 }
 
 func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.StreamEvent, error) {
+	if _, err := resolveMaxTokens(req); err != nil {
+		return nil, err
+	}
 	mr := a.buildRequest(req)
 	mr.Stream = true
 
-	resp, err := a.doRequest(ctx, mr, true)
+	ctx, cancel := a.httpConfig.StreamContext(ctx, req, "anthropic")
+	resp, err := a.doRequest(ctx, mr, req, true)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
+	resp.Body = a.httpConfig.StreamBody(resp.Body, req, "anthropic")
+	if a.transport == TransportBedrock {
+		resp.Body = newEventStreamBody(resp.Body)
+	}
 	ch := make(chan llm.StreamEvent, 64)
 	go func() {
 		defer close(ch)
+		defer cancel()
 		defer resp.Body.Close()
 
+		// Closing the body on cancellation unblocks the reader.
+		stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+		defer stop()
+		out := llm.NewStreamSender(ctx, ch)
+		var streamErr error
+		defer func() { out.Finish(streamErr) }()
+
 		var stopReason string
 		var finalUsage *llm.Usage
 		var currentBlockType string
 
-		scanner := bufio.NewScanner(resp.Body)
-		scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024) // 1MB buffer for large tool call deltas
-		for scanner.Scan() {
-			line := scanner.Text()
-			if !strings.HasPrefix(line, "data: ") {
-				continue
+		reader := sse.NewReader(resp.Body)
+		for {
+			msg, err := reader.Next()
+			if err != nil {
+				if err != io.EOF {
+					streamErr = err
+				}
+				break
 			}
-			data := strings.TrimPrefix(line, "data: ")
+			data := msg.Data
 
 			var event struct {
 				Type         string            `json:"type"`
@@ -360,7 +495,9 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 
 			switch event.Type {
 			case "message_start":
-				ch <- llm.StreamEvent{Type: llm.StreamEventStart}
+				if !out.Send(llm.StreamEvent{Type: llm.StreamEventStart}) {
+					return
+				}
 				if event.Message != nil {
 					finalUsage = &llm.Usage{
 						InputTokens: event.Message.Usage.InputTokens,
@@ -370,12 +507,14 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 				if event.ContentBlock != nil {
 					currentBlockType = event.ContentBlock.Type
 					if event.ContentBlock.Type == "tool_use" {
-						ch <- llm.StreamEvent{
+						if !out.Send(llm.StreamEvent{
 							Type: llm.StreamEventToolCallStart,
 							ToolCall: &llm.ToolCall{
 								ID:   event.ContentBlock.ID,
 								Name: event.ContentBlock.Name,
 							},
+						}) {
+							return
 						}
 					}
 				}
@@ -383,25 +522,33 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 				if event.Delta != nil {
 					switch event.Delta.Type {
 					case "text_delta":
-						ch <- llm.StreamEvent{
+						if !out.Send(llm.StreamEvent{
 							Type:  llm.StreamEventDelta,
 							Delta: event.Delta.Text,
+						}) {
+							return
 						}
 					case "input_json_delta":
-						ch <- llm.StreamEvent{
+						if !out.Send(llm.StreamEvent{
 							Type:  llm.StreamEventToolCallDelta,
 							Delta: event.Delta.PartialJSON,
+						}) {
+							return
 						}
 					case "thinking_delta":
-						ch <- llm.StreamEvent{
+						if !out.Send(llm.StreamEvent{
 							Type:  llm.StreamEventReasoningDelta,
 							Delta: event.Delta.Thinking,
+						}) {
+							return
 						}
 					}
 				}
 			case "content_block_stop":
 				if currentBlockType == "tool_use" {
-					ch <- llm.StreamEvent{Type: llm.StreamEventToolCallEnd}
+					if !out.Send(llm.StreamEvent{Type: llm.StreamEventToolCallEnd}) {
+						return
+					}
 				}
 				currentBlockType = ""
 			case "message_delta":
@@ -430,16 +577,16 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 				endEvent := llm.StreamEvent{
 					Type:         llm.StreamEventEnd,
 					FinishReason: fr,
+					Headers:      resp.Header,
 				}
 				if finalUsage != nil {
 					endEvent.Usage = finalUsage
 				}
-				ch <- endEvent
+				if !out.Send(endEvent) {
+					return
+				}
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			ch <- llm.StreamEvent{Type: llm.StreamEventError, Error: err}
-		}
 	}()
 
 	return ch, nil
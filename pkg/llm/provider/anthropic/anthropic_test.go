@@ -3,11 +3,14 @@ package anthropic
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ashka-vakil/attractor/pkg/llm"
 )
@@ -21,18 +24,18 @@ func TestBuildRequest(t *testing.T) {
 
 	t.Run("basic user message", func(t *testing.T) {
 		req := &llm.Request{
-			Model: "claude-sonnet-4-20250514",
+			Model: "claude-sonnet-4-5-20250929",
 			Messages: []llm.Message{
 				{Role: llm.RoleUser, Content: "Hello"},
 			},
 		}
 		mr := adapter.buildRequest(req)
 
-		if mr.Model != "claude-sonnet-4-20250514" {
-			t.Errorf("expected model claude-sonnet-4-20250514, got %s", mr.Model)
+		if mr.Model != "claude-sonnet-4-5-20250929" {
+			t.Errorf("expected model claude-sonnet-4-5-20250929, got %s", mr.Model)
 		}
-		if mr.MaxTokens != 4096 {
-			t.Errorf("expected default max_tokens 4096, got %d", mr.MaxTokens)
+		if mr.MaxTokens != 16384 {
+			t.Errorf("expected default max_tokens 16384, got %d", mr.MaxTokens)
 		}
 		if len(mr.Messages) != 1 {
 			t.Fatalf("expected 1 message, got %d", len(mr.Messages))
@@ -724,6 +727,73 @@ func TestStream(t *testing.T) {
 	})
 }
 
+// ---------------------------------------------------------------------------
+// TestResolveMaxTokens
+// ---------------------------------------------------------------------------
+
+func TestResolveMaxTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     llm.Request
+		want    int
+		wantErr bool
+	}{
+		{name: "newer model default", req: llm.Request{Model: "claude-opus-4-6"}, want: 16384},
+		{name: "vertex model default", req: llm.Request{Model: "claude-sonnet-4-5@20250929"}, want: 16384},
+		{name: "unknown model default", req: llm.Request{Model: "custom-model"}, want: 4096},
+		{name: "explicit within limit", req: llm.Request{Model: "claude-sonnet-4-5-20250929", MaxTokens: 64000}, want: 64000},
+		{name: "explicit above limit", req: llm.Request{Model: "claude-haiku-4-5-20251001", MaxTokens: 64001}, wantErr: true},
+		{name: "bedrock model above limit", req: llm.Request{Model: "us.anthropic.claude-haiku-4-5-20251001-v1:0", MaxTokens: 64001}, wantErr: true},
+		{name: "unknown model is not validated", req: llm.Request{Model: "custom-model", MaxTokens: 500000}, want: 500000},
+		{
+			name: "provider option overrides",
+			req: llm.Request{Model: "claude-3-5-sonnet-20241022", ProviderOptions: map[string]interface{}{
+				"anthropic": map[string]interface{}{"max_output_tokens": float64(128000), "default_max_tokens": 32000},
+			}},
+			want: 32000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveMaxTokens(&tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompleteRejectsMaxTokensAboveLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not reach the API")
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+	_, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:     "claude-haiku-4-5-20251001",
+		Messages:  []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		MaxTokens: 100000,
+	})
+	llmErr, ok := err.(*llm.LLMError)
+	if !ok {
+		t.Fatalf("expected *llm.LLMError, got %T (%v)", err, err)
+	}
+	if llmErr.Type != llm.ErrorTypeBadRequest {
+		t.Errorf("expected bad_request, got %s", llmErr.Type)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestConvertStopReason
 // ---------------------------------------------------------------------------
@@ -734,8 +804,11 @@ func TestConvertStopReason(t *testing.T) {
 		expected llm.FinishReason
 	}{
 		{"end_turn", llm.FinishReasonStop},
+		{"stop_sequence", llm.FinishReasonStop},
 		{"max_tokens", llm.FinishReasonLength},
+		{"model_context_window_exceeded", llm.FinishReasonLength},
 		{"tool_use", llm.FinishReasonToolCalls},
+		{"refusal", llm.FinishReasonContentFilter},
 		{"unknown_reason", llm.FinishReasonStop},
 		{"", llm.FinishReasonStop},
 	}
@@ -750,6 +823,58 @@ func TestConvertStopReason(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// TestStreamCancellation
+// ---------------------------------------------------------------------------
+
+func TestStreamCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hi"}}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+	ch, err := adapter.Stream(ctx, &llm.Request{
+		Model:    "claude-sonnet-4-5-20250929",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ev := <-ch; ev.Type != llm.StreamEventDelta {
+		t.Fatalf("expected first delta, got %+v", ev)
+	}
+	cancel()
+
+	var last llm.StreamEvent
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			last = ev
+		case <-timeout:
+			t.Fatal("stream did not close after cancellation")
+		}
+	}
+	if last.Type != llm.StreamEventError || !errors.Is(last.Error, context.Canceled) {
+		t.Errorf("expected terminal context.Canceled error, got %+v", last)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestName
 // ---------------------------------------------------------------------------
@@ -819,6 +944,12 @@ func TestDoRequestSendsHeaders(t *testing.T) {
 		if r.Header.Get("anthropic-version") != "2023-06-01" {
 			t.Errorf("expected anthropic-version, got %s", r.Header.Get("anthropic-version"))
 		}
+		if r.Header.Get("X-Gateway") != "adapter" {
+			t.Errorf("expected X-Gateway adapter, got %s", r.Header.Get("X-Gateway"))
+		}
+		if r.Header.Get("X-Request") != "yes" {
+			t.Errorf("expected X-Request yes, got %s", r.Header.Get("X-Request"))
+		}
 
 		// Read the request body to verify it is valid JSON
 		body, err := io.ReadAll(r.Body)
@@ -840,12 +971,127 @@ func TestDoRequestSendsHeaders(t *testing.T) {
 	}))
 	defer server.Close()
 
-	adapter := NewAdapter(WithAPIKey("my-key"), WithBaseURL(server.URL))
+	adapter := NewAdapter(WithAPIKey("my-key"), WithBaseURL(server.URL), WithHeaders(map[string]string{"X-Gateway": "adapter"}))
 	_, err := adapter.Complete(context.Background(), &llm.Request{
 		Model:    "claude-sonnet-4-20250514",
 		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		Headers:  map[string]string{"X-Request": "yes"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/models" {
+			t.Errorf("expected GET /v1/models, got %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "my-key" {
+			t.Errorf("expected x-api-key my-key, got %s", r.Header.Get("x-api-key"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("after_id") == "" {
+			fmt.Fprint(w, `{"data": [{"id": "claude-opus-4-6", "display_name": "Claude Opus 4.6"}], "has_more": true, "last_id": "claude-opus-4-6"}`)
+			return
+		}
+		fmt.Fprint(w, `{"data": [{"id": "claude-3-5-haiku-20241022", "display_name": "Claude Haiku 3.5"}], "has_more": false}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("my-key"), WithBaseURL(server.URL))
+	models, err := adapter.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("expected 2 models across pages, got %d", len(models))
+	}
+	if models[0].DisplayName != "Claude Opus 4.6" || models[0].MaxOutput != 128000 {
+		t.Errorf("unexpected first model: %+v", models[0])
+	}
+	if models[1].ID != "claude-3-5-haiku-20241022" || models[1].Provider != "anthropic" {
+		t.Errorf("unexpected second model: %+v", models[1])
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestWithHTTPClient(t *testing.T) {
+	var calls int
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		body := `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`
+		return &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})}
+
+	adapter := NewAdapter(WithAPIKey("key"), WithHTTPClient(client))
+	resp, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:    "claude-sonnet-4-5",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
 	})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if calls != 1 || resp.Content != "ok" {
+		t.Errorf("expected the request to use the custom client, got %d calls and %q", calls, resp.Content)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages/count_tokens" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := body["max_tokens"]; ok {
+			t.Errorf("max_tokens sent to count_tokens: %v", body)
+		}
+		if body["system"] != "Be brief." || body["model"] != "claude-sonnet-4-5" {
+			t.Errorf("body = %v", body)
+		}
+		io.WriteString(w, `{"input_tokens":42}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	n, err := adapter.CountTokens(context.Background(), &llm.Request{
+		Model:        "claude-sonnet-4-5",
+		SystemPrompt: "Be brief.",
+		Messages:     []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 42 {
+		t.Errorf("CountTokens = %d, want 42", n)
+	}
+}
+
+func TestCompleteKeepsRawResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Request-Id", "req_1")
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1,"service_tier":"standard"}}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	resp, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:    "claude-sonnet-4-5",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tier, _ := resp.RawField("usage", "service_tier"); tier != "standard" {
+		t.Errorf("service_tier = %v", tier)
+	}
+	if resp.Headers.Get("Request-Id") != "req_1" {
+		t.Errorf("Request-Id header = %q", resp.Headers.Get("Request-Id"))
+	}
 }
@@ -0,0 +1,176 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// eventStreamBody turns a Bedrock response stream, in the binary AWS event
+// stream encoding, into the server-sent events of the Messages API, so
+// Stream parses both alike. Each Bedrock chunk carries one Messages API
+// event, base64-encoded; an exception message ends the stream with an
+// LLMError.
+type eventStreamBody struct {
+	body io.ReadCloser
+	buf  bytes.Buffer
+	err  error
+}
+
+func newEventStreamBody(body io.ReadCloser) io.ReadCloser {
+	return &eventStreamBody{body: body}
+}
+
+func (b *eventStreamBody) Read(p []byte) (int, error) {
+	for b.buf.Len() == 0 && b.err == nil {
+		b.err = b.next()
+	}
+	if b.buf.Len() > 0 {
+		return b.buf.Read(p)
+	}
+	return 0, b.err
+}
+
+func (b *eventStreamBody) Close() error {
+	return b.body.Close()
+}
+
+// next decodes one message into buf.
+func (b *eventStreamBody) next() error {
+	var prelude [12]byte
+	if _, err := io.ReadFull(b.body, prelude[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("event stream: truncated message")
+		}
+		return err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return errors.New("event stream: prelude checksum mismatch")
+	}
+	if total < 16+headersLen || total > 16<<20 {
+		return fmt.Errorf("event stream: bad message length %d", total)
+	}
+	msg := make([]byte, total-12)
+	if _, err := io.ReadFull(b.body, msg); err != nil {
+		return fmt.Errorf("event stream: truncated message")
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(prelude[:]), crc32.IEEETable, msg[:len(msg)-4])
+	if crc != binary.BigEndian.Uint32(msg[len(msg)-4:]) {
+		return errors.New("event stream: message checksum mismatch")
+	}
+	headers, err := parseEventHeaders(msg[:headersLen])
+	if err != nil {
+		return err
+	}
+	payload := msg[headersLen : len(msg)-4]
+
+	switch headers[":message-type"] {
+	case "exception", "error":
+		return bedrockStreamError(headers, payload)
+	}
+	if headers[":event-type"] != "chunk" {
+		return nil
+	}
+	var chunk struct {
+		Bytes string `json:"bytes"`
+	}
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		return fmt.Errorf("event stream: %w", err)
+	}
+	event, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+	if err != nil {
+		return fmt.Errorf("event stream: %w", err)
+	}
+	b.buf.WriteString("data: ")
+	if err := json.Compact(&b.buf, event); err != nil {
+		return fmt.Errorf("event stream: %w", err)
+	}
+	b.buf.WriteString("\n\n")
+	return nil
+}
+
+// parseEventHeaders returns the string-valued headers of a message.
+func parseEventHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 2+nameLen {
+			return nil, errors.New("event stream: bad header")
+		}
+		name := string(data[1 : 1+nameLen])
+		typ := data[1+nameLen]
+		data = data[2+nameLen:]
+		var size int
+		switch typ {
+		case 0, 1: // bool
+		case 2:
+			size = 1
+		case 3:
+			size = 2
+		case 4:
+			size = 4
+		case 5, 8: // int64, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes, string
+			if len(data) < 2 {
+				return nil, errors.New("event stream: bad header")
+			}
+			n := int(binary.BigEndian.Uint16(data))
+			if len(data) < 2+n {
+				return nil, errors.New("event stream: bad header")
+			}
+			if typ == 7 {
+				headers[name] = string(data[2 : 2+n])
+			}
+			data = data[2+n:]
+			continue
+		default:
+			return nil, fmt.Errorf("event stream: unknown header type %d", typ)
+		}
+		if len(data) < size {
+			return nil, errors.New("event stream: bad header")
+		}
+		data = data[size:]
+	}
+	return headers, nil
+}
+
+// bedrockStreamError converts an exception sent mid-stream to an LLMError.
+func bedrockStreamError(headers map[string]string, payload []byte) error {
+	kind := headers[":exception-type"]
+	if kind == "" {
+		kind = headers[":error-code"]
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(payload, &body)
+	message := body.Message
+	if message == "" {
+		message = strings.TrimSpace(string(payload))
+	}
+	errType := llm.ErrorTypeServer
+	switch kind {
+	case "throttlingException":
+		errType = llm.ErrorTypeRateLimit
+	case "validationException":
+		errType = llm.ErrorTypeBadRequest
+	}
+	return &llm.LLMError{
+		Type:     errType,
+		Message:  fmt.Sprintf("%s: %s", kind, message),
+		Provider: "anthropic",
+	}
+}
@@ -0,0 +1,128 @@
+package anthropic
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// modelLimits describes the output token limits for a request's model.
+type modelLimits struct {
+	defaultMaxTokens int // used when the request does not set MaxTokens
+	maxOutputTokens  int // hard limit accepted by the API
+}
+
+// defaultMaxTokensCap caps the max_tokens sent when a request leaves it
+// unset, so models with very large output limits don't reserve all of it.
+const defaultMaxTokensCap = 16384
+
+// fallbackMaxTokens is the default for models not in the llm catalog.
+const fallbackMaxTokens = 4096
+
+// bedrockModelVersion matches the version suffix of Bedrock model IDs, "-v1:0".
+var bedrockModelVersion = regexp.MustCompile(`-v\d+(:\d+)?$`)
+
+// catalogModel returns the llm catalog entry for a model ID, which may be a
+// Bedrock or Vertex AI ID.
+func catalogModel(model string) (llm.ModelInfo, bool) {
+	// Bedrock IDs look like "us.anthropic.claude-sonnet-4-5-20250929-v1:0".
+	if _, rest, ok := strings.Cut(model, "anthropic."); ok {
+		model = bedrockModelVersion.ReplaceAllString(rest, "")
+	}
+	// Vertex AI IDs look like "claude-sonnet-4-5@20250929".
+	model = strings.Replace(model, "@", "-", 1)
+	return llm.GetModelInfo(model)
+}
+
+// limitsFor returns the output limits for a model: its MaxOutput in the llm
+// catalog, with a default of that limit up to defaultMaxTokensCap. Overrides
+// are read from ProviderOptions["anthropic"]: "default_max_tokens" and
+// "max_output_tokens". A zero maxOutputTokens means the limit is unknown.
+func limitsFor(req *llm.Request) modelLimits {
+	limits := modelLimits{defaultMaxTokens: fallbackMaxTokens}
+	if info, ok := catalogModel(req.Model); ok && info.MaxOutput > 0 {
+		limits.maxOutputTokens = info.MaxOutput
+		limits.defaultMaxTokens = min(info.MaxOutput, defaultMaxTokensCap)
+	}
+	opts, _ := req.ProviderOptions["anthropic"].(map[string]interface{})
+	if n, ok := intOption(opts, "max_output_tokens"); ok {
+		limits.maxOutputTokens = n
+	}
+	if n, ok := intOption(opts, "default_max_tokens"); ok {
+		limits.defaultMaxTokens = n
+	}
+	if limits.maxOutputTokens > 0 && limits.defaultMaxTokens > limits.maxOutputTokens {
+		limits.defaultMaxTokens = limits.maxOutputTokens
+	}
+	return limits
+}
+
+// resolveMaxTokens returns the max_tokens to send for req, rejecting values
+// above the model's output limit before the request reaches the API.
+func resolveMaxTokens(req *llm.Request) (int, error) {
+	limits := limitsFor(req)
+	if req.MaxTokens == 0 {
+		return limits.defaultMaxTokens, nil
+	}
+	if limits.maxOutputTokens > 0 && req.MaxTokens > limits.maxOutputTokens {
+		return 0, &llm.LLMError{
+			Type:     llm.ErrorTypeBadRequest,
+			Message:  fmt.Sprintf("max_tokens %d exceeds the %d output token limit for %s", req.MaxTokens, limits.maxOutputTokens, req.Model),
+			Provider: "anthropic",
+		}
+	}
+	return req.MaxTokens, nil
+}
+
+// intOption reads a numeric option, accepting both Go ints and JSON numbers.
+func intOption(opts map[string]interface{}, key string) (int, bool) {
+	switch v := opts[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// modelsPage is a page of the Models API response.
+type modelsPage struct {
+	Data []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"data"`
+	HasMore bool   `json:"has_more"`
+	LastID  string `json:"last_id"`
+}
+
+// ListModels lists the models available to the API key via the Models API.
+// Output limits are filled in from the llm catalog. The cloud transports have no
+// Models API, so for them the built-in catalog is returned.
+func (a *Adapter) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	if a.transport != TransportDirect {
+		return llm.ListModels("anthropic"), nil
+	}
+	var models []llm.ModelInfo
+	path := "/v1/models?limit=1000"
+	for {
+		var page modelsPage
+		if err := a.getJSON(ctx, path, &page); err != nil {
+			return nil, err
+		}
+		for _, m := range page.Data {
+			info := llm.ModelInfo{ID: m.ID, Provider: "anthropic", DisplayName: m.DisplayName}
+			info.MaxOutput = limitsFor(&llm.Request{Model: m.ID}).maxOutputTokens
+			models = append(models, info)
+		}
+		if !page.HasMore || page.LastID == "" {
+			return models, nil
+		}
+		path = "/v1/models?limit=1000&after_id=" + url.QueryEscape(page.LastID)
+	}
+}
@@ -0,0 +1,76 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// countTokensRequest is the body of /v1/messages/count_tokens: the parts of
+// a Messages API request that take up input tokens.
+type countTokensRequest struct {
+	Model      string         `json:"model"`
+	Messages   []messageParam `json:"messages"`
+	System     string         `json:"system,omitempty"`
+	Tools      []toolParam    `json:"tools,omitempty"`
+	ToolChoice interface{}    `json:"tool_choice,omitempty"`
+	Thinking   *thinkingParam `json:"thinking,omitempty"`
+}
+
+// CountTokens returns the input tokens of req as counted by the Messages
+// API's count_tokens endpoint, which is free and not rate limited with the
+// messages themselves. The cloud transports have no such endpoint.
+func (a *Adapter) CountTokens(ctx context.Context, req *llm.Request) (int, error) {
+	if a.transport != TransportDirect {
+		return 0, fmt.Errorf("count_tokens is not available through %s", a.transport)
+	}
+	ctx, cancel := a.httpConfig.RequestContext(ctx, req)
+	defer cancel()
+	mr := a.buildRequest(req)
+	data, err := json.Marshal(countTokensRequest{
+		Model:      mr.Model,
+		Messages:   mr.Messages,
+		System:     mr.System,
+		Tools:      mr.Tools,
+		ToolChoice: mr.ToolChoice,
+		Thinking:   mr.Thinking,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/v1/messages/count_tokens", bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	a.setHeaders(httpReq, req.Headers)
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, &llm.LLMError{
+			Type:     llm.ErrorTypeNetwork,
+			Message:  err.Error(),
+			Provider: "anthropic",
+			Cause:    err,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, llm.ClassifyHTTPResponse(resp, string(body), "anthropic")
+	}
+	var out struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("decode response: %w", err)
+	}
+	return out.InputTokens, nil
+}
@@ -0,0 +1,204 @@
+package anthropic
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/googleauth"
+)
+
+// Transports for WithTransport. Claude on Amazon Bedrock and Google Cloud
+// Vertex AI takes the Messages API body, but with the model in the URL, an
+// anthropic_version field in place of the header, and the cloud's own auth.
+const (
+	TransportDirect  = ""
+	TransportBedrock = "bedrock"
+	TransportVertex  = "vertex"
+)
+
+const (
+	bedrockVersion = "bedrock-2023-05-31"
+	vertexVersion  = "vertex-2023-10-16"
+)
+
+// WithTransport sends requests to Claude through a cloud marketplace:
+// "bedrock" for Amazon Bedrock or "vertex" for Google Cloud Vertex AI. The
+// model of each request is then the cloud's model ID, such as
+// "anthropic.claude-sonnet-4-5-20250929-v1:0" on Bedrock or
+// "claude-sonnet-4-5@20250929" on Vertex AI.
+//
+// Bedrock signs requests with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN, or sends the Bedrock API key in
+// AWS_BEARER_TOKEN_BEDROCK; the region comes from AWS_REGION. Vertex AI uses
+// Google Application Default Credentials, with the project from
+// ANTHROPIC_VERTEX_PROJECT_ID or GOOGLE_CLOUD_PROJECT and the region from
+// CLOUD_ML_REGION. The options below override the environment.
+func WithTransport(transport string) Option {
+	return func(a *Adapter) { a.transport = transport }
+}
+
+// WithRegion sets the AWS region or Google Cloud location of the Bedrock or
+// Vertex AI transport.
+func WithRegion(region string) Option {
+	return func(a *Adapter) { a.region = region }
+}
+
+// WithProject sets the Google Cloud project of the Vertex AI transport.
+func WithProject(project string) Option {
+	return func(a *Adapter) { a.project = project }
+}
+
+// WithAWSCredentials sets the credentials that sign Bedrock requests.
+func WithAWSCredentials(accessKeyID, secretAccessKey, sessionToken string) Option {
+	return func(a *Adapter) {
+		a.aws = awsCredentials{accessKeyID: accessKeyID, secretAccessKey: secretAccessKey, sessionToken: sessionToken}
+	}
+}
+
+// WithTokenSource sets where Vertex AI access tokens come from.
+func WithTokenSource(ts googleauth.TokenSource) Option {
+	return func(a *Adapter) { a.tokens = ts }
+}
+
+// configureTransport fills in the cloud settings not given as options from
+// the environment. It returns the default base URL of the transport.
+func (a *Adapter) configureTransport() (string, error) {
+	switch a.transport {
+	case TransportDirect:
+		return "https://api.anthropic.com", nil
+	case TransportBedrock:
+		a.region = cmp.Or(a.region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+		if a.aws.accessKeyID == "" {
+			a.aws = awsCredentials{
+				accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+				secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			}
+		}
+		if a.bedrockToken == "" {
+			a.bedrockToken = os.Getenv("AWS_BEARER_TOKEN_BEDROCK")
+		}
+		return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", a.region), nil
+	case TransportVertex:
+		a.region = cmp.Or(a.region, os.Getenv("CLOUD_ML_REGION"), os.Getenv("GOOGLE_CLOUD_LOCATION"), "us-east5")
+		a.project = cmp.Or(a.project, os.Getenv("ANTHROPIC_VERTEX_PROJECT_ID"), os.Getenv("GOOGLE_CLOUD_PROJECT"))
+		if a.tokens == nil {
+			a.tokens = googleauth.DefaultCredentials(a.httpClient)
+		}
+		if a.region == "global" {
+			return "https://aiplatform.googleapis.com/v1", nil
+		}
+		return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1", a.region), nil
+	default:
+		return "", fmt.Errorf("unknown transport %q (want bedrock or vertex)", a.transport)
+	}
+}
+
+// messagesURL returns where a Messages API request for model is sent.
+func (a *Adapter) messagesURL(model string, stream bool) string {
+	switch a.transport {
+	case TransportBedrock:
+		action := "invoke"
+		if stream {
+			action = "invoke-with-response-stream"
+		}
+		return fmt.Sprintf("%s/model/%s/%s", a.baseURL, awsURIEncode(model), action)
+	case TransportVertex:
+		method := "rawPredict"
+		if stream {
+			method = "streamRawPredict"
+		}
+		return fmt.Sprintf("%s/projects/%s/locations/%s/publishers/anthropic/models/%s:%s", a.baseURL, a.project, a.region, model, method)
+	default:
+		return a.baseURL + "/v1/messages"
+	}
+}
+
+// transportBody rewrites an encoded Messages API body for the transport:
+// the model moves to the URL and anthropic_version into the body. Bedrock
+// chooses streaming by URL and takes beta flags in the body.
+func (a *Adapter) transportBody(data []byte, headers map[string]string) ([]byte, error) {
+	if a.transport == TransportDirect {
+		return data, nil
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	delete(body, "model")
+	version := vertexVersion
+	if a.transport == TransportBedrock {
+		version = bedrockVersion
+		delete(body, "stream")
+		if betas := a.betas(headers); len(betas) > 0 {
+			body["anthropic_beta"], _ = json.Marshal(betas)
+		}
+	}
+	body["anthropic_version"], _ = json.Marshal(version)
+	return json.Marshal(body)
+}
+
+// betas returns the anthropic-beta flags of the adapter and request headers.
+func (a *Adapter) betas(headers map[string]string) []string {
+	var betas []string
+	for _, h := range []map[string]string{a.headers, headers} {
+		for k, v := range h {
+			if !strings.EqualFold(k, "anthropic-beta") {
+				continue
+			}
+			for _, b := range strings.Split(v, ",") {
+				if b = strings.TrimSpace(b); b != "" {
+					betas = append(betas, b)
+				}
+			}
+		}
+	}
+	return betas
+}
+
+// authorize sets the credentials of the transport on httpReq, whose body is
+// data. It runs after every other header is set, since a Bedrock signature
+// covers them.
+func (a *Adapter) authorize(ctx context.Context, httpReq *http.Request, data []byte) error {
+	switch a.transport {
+	case TransportBedrock:
+		httpReq.Header.Del("anthropic-beta")
+		if a.bedrockToken != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+a.bedrockToken)
+			return nil
+		}
+		if a.aws.accessKeyID == "" || a.aws.secretAccessKey == "" {
+			return &llm.LLMError{
+				Type:     llm.ErrorTypeAuth,
+				Message:  "Bedrock needs AWS credentials; set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or AWS_BEARER_TOKEN_BEDROCK",
+				Provider: "anthropic",
+			}
+		}
+		signV4(httpReq, data, a.aws, a.region, "bedrock", timeNow())
+	case TransportVertex:
+		if a.project == "" {
+			return &llm.LLMError{
+				Type:     llm.ErrorTypeAuth,
+				Message:  "Vertex AI needs a project; set ANTHROPIC_VERTEX_PROJECT_ID or use WithProject",
+				Provider: "anthropic",
+			}
+		}
+		token, err := a.tokens.Token(ctx)
+		if err != nil {
+			return &llm.LLMError{
+				Type:     llm.ErrorTypeAuth,
+				Message:  "Vertex AI credentials: " + err.Error(),
+				Provider: "anthropic",
+				Cause:    err,
+			}
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/googleauth"
+)
+
+// TestSignV4 checks the signer against the get-vanilla case of the AWS
+// Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBedrockComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.EscapedPath(); got != "/model/anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke" {
+			t.Errorf("path = %q", got)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-west-2/bedrock/aws4_request") {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("session token not sent")
+		}
+		if r.Header.Get("x-api-key") != "" || r.Header.Get("anthropic-version") != "" || r.Header.Get("anthropic-beta") != "" {
+			t.Errorf("Anthropic API headers sent to Bedrock: %v", r.Header)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["anthropic_version"] != bedrockVersion {
+			t.Errorf("anthropic_version = %v", body["anthropic_version"])
+		}
+		if _, ok := body["model"]; ok {
+			t.Errorf("model sent in the body: %v", body)
+		}
+		if betas, _ := body["anthropic_beta"].([]interface{}); len(betas) != 1 || betas[0] != "context-1m-2025-08-07" {
+			t.Errorf("anthropic_beta = %v", body["anthropic_beta"])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	a := NewAdapter(
+		WithTransport(TransportBedrock),
+		WithBaseURL(server.URL),
+		WithRegion("us-west-2"),
+		WithAWSCredentials("AKID", "secret", "session"),
+		WithHeaders(map[string]string{"anthropic-beta": "context-1m-2025-08-07"}),
+	)
+	resp, err := a.Complete(context.Background(), &llm.Request{
+		Model:    "anthropic.claude-sonnet-4-5-20250929-v1:0",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "ok" {
+		t.Errorf("Content = %q, want ok", resp.Content)
+	}
+}
+
+func TestBedrockCompleteMissingCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_BEARER_TOKEN_BEDROCK", "")
+	a := NewAdapter(WithTransport(TransportBedrock))
+	_, err := a.Complete(context.Background(), &llm.Request{
+		Model:    "anthropic.claude-sonnet-4-5-20250929-v1:0",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	var llmErr *llm.LLMError
+	if !errors.As(err, &llmErr) || llmErr.Type != llm.ErrorTypeAuth {
+		t.Fatalf("err = %v, want an auth error", err)
+	}
+}
+
+// encodeEvent encodes one AWS event stream message with string headers.
+func encodeEvent(headers map[string]string, payload []byte) []byte {
+	var h bytes.Buffer
+	for k, v := range headers {
+		h.WriteByte(byte(len(k)))
+		h.WriteString(k)
+		h.WriteByte(7)
+		binary.Write(&h, binary.BigEndian, uint16(len(v)))
+		h.WriteString(v)
+	}
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.BigEndian, uint32(16+h.Len()+len(payload)))
+	binary.Write(&msg, binary.BigEndian, uint32(h.Len()))
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	msg.Write(h.Bytes())
+	msg.Write(payload)
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	return msg.Bytes()
+}
+
+func bedrockChunk(event string) []byte {
+	payload, _ := json.Marshal(map[string]string{"bytes": base64.StdEncoding.EncodeToString([]byte(event))})
+	return encodeEvent(map[string]string{":message-type": "event", ":event-type": "chunk"}, payload)
+}
+
+func TestBedrockStream(t *testing.T) {
+	var exception bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/invoke-with-response-stream") {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer bedrock-key" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := body["stream"]; ok {
+			t.Errorf("stream sent in the Bedrock body: %v", body)
+		}
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(bedrockChunk(`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":5}}}`))
+		w.Write(bedrockChunk(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`))
+		if exception {
+			w.Write(encodeEvent(map[string]string{":message-type": "exception", ":exception-type": "throttlingException"}, []byte(`{"message":"Too many requests"}`)))
+			return
+		}
+		w.Write(bedrockChunk(`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`))
+		w.Write(bedrockChunk(`{"type":"message_stop"}`))
+	}))
+	defer server.Close()
+
+	a := NewAdapter(WithTransport(TransportBedrock), WithBaseURL(server.URL))
+	a.bedrockToken = "bedrock-key"
+	req := &llm.Request{
+		Model:    "anthropic.claude-sonnet-4-5-20250929-v1:0",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	}
+
+	t.Run("events", func(t *testing.T) {
+		events, err := a.Stream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var text string
+		var end *llm.StreamEvent
+		for ev := range events {
+			if ev.Type == llm.StreamEventDelta {
+				text += ev.Delta
+			}
+			if ev.Type == llm.StreamEventEnd {
+				end = &ev
+			}
+		}
+		if text != "Hello" {
+			t.Errorf("text = %q, want Hello", text)
+		}
+		if end == nil || end.Usage == nil || end.Usage.InputTokens != 5 || end.Usage.OutputTokens != 1 {
+			t.Errorf("end event = %+v", end)
+		}
+	})
+
+	t.Run("exception", func(t *testing.T) {
+		exception = true
+		events, err := a.Stream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var streamErr error
+		for ev := range events {
+			if ev.Type == llm.StreamEventError {
+				streamErr = ev.Error
+			}
+		}
+		var llmErr *llm.LLMError
+		if !errors.As(streamErr, &llmErr) || llmErr.Type != llm.ErrorTypeRateLimit {
+			t.Errorf("stream error = %v, want a rate limit error", streamErr)
+		}
+	})
+}
+
+func TestVertexComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/proj/locations/us-east5/publishers/anthropic/models/claude-sonnet-4-5@20250929:rawPredict" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" || r.Header.Get("x-api-key") != "" {
+			t.Errorf("auth headers = %v", r.Header)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["anthropic_version"] != vertexVersion {
+			t.Errorf("anthropic_version = %v", body["anthropic_version"])
+		}
+		if _, ok := body["model"]; ok {
+			t.Errorf("model sent in the body: %v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("CLOUD_ML_REGION", "")
+	t.Setenv("GOOGLE_CLOUD_LOCATION", "")
+	a := NewAdapter(
+		WithTransport(TransportVertex),
+		WithBaseURL(server.URL),
+		WithProject("proj"),
+		WithTokenSource(googleauth.StaticToken("tok")),
+	)
+	resp, err := a.Complete(context.Background(), &llm.Request{
+		Model:    "claude-sonnet-4-5@20250929",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "ok" {
+		t.Errorf("Content = %q, want ok", resp.Content)
+	}
+}
+
+func TestUnknownTransport(t *testing.T) {
+	a := NewAdapter(WithTransport("azure"))
+	_, err := a.Complete(context.Background(), &llm.Request{
+		Model:    "claude-sonnet-4-5",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), `unknown transport "azure"`) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -2,22 +2,26 @@
 package gemini
 
 import (
-	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/ashka-vakil/attractor/internal/sse"
 	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/googleauth"
 )
 
 func init() {
-	llm.RegisterProviderFactory("gemini", "GEMINI_API_KEY,GOOGLE_API_KEY", func() llm.ProviderAdapter {
+	llm.RegisterProviderFactory("gemini", "GEMINI_API_KEY,GOOGLE_API_KEY,"+VertexEnv, func() llm.ProviderAdapter {
 		return NewAdapter()
 	})
 }
@@ -27,6 +31,9 @@ type Adapter struct {
 	apiKey     string
 	baseURL    string
 	httpClient *http.Client
+	httpConfig llm.HTTPConfig
+	vertex     *vertexConfig // nil for the Gemini API
+	tokens     TokenSource
 }
 
 // Option configures the Gemini adapter.
@@ -42,17 +49,67 @@ func WithBaseURL(url string) Option {
 	return func(a *Adapter) { a.baseURL = url }
 }
 
-// NewAdapter creates a new Gemini adapter.
+// WithHTTPClient sets the HTTP client used for API requests, in place of
+// one built from the proxy and TLS options.
+func WithHTTPClient(c *http.Client) Option {
+	return func(a *Adapter) { a.httpConfig.Client = c }
+}
+
+// WithProxy sends requests through the proxy at proxyURL instead of the one
+// named by HTTPS_PROXY.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(a *Adapter) { a.httpConfig.Proxy = proxyURL }
+}
+
+// WithConnectTimeout bounds dialing and the TLS handshake (default: 30s).
+func WithConnectTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.ConnectTimeout = d }
+}
+
+// WithRequestTimeout bounds a whole non-streaming request (default: 10m).
+// Request.Timeout overrides it per call.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.RequestTimeout = d }
+}
+
+// WithStreamIdleTimeout ends a stream that sends no data for d (default:
+// 5m). Streams have no overall deadline.
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.StreamIdleTimeout = d }
+}
+
+// WithTLSConfig sets the TLS configuration, e.g. to trust a custom CA.
+func WithTLSConfig(c *tls.Config) Option {
+	return func(a *Adapter) { a.httpConfig.TLSConfig = c }
+}
+
+// NewAdapter creates a new Gemini adapter. It uses the Gemini API with an
+// API key, or Vertex AI when WithVertexAI is given or
+// GOOGLE_GENAI_USE_VERTEXAI is true.
 func NewAdapter(opts ...Option) *Adapter {
 	a := &Adapter{
 		baseURL: "https://generativelanguage.googleapis.com/v1beta",
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-		},
+		vertex:  vertexFromEnv(),
 	}
 	for _, opt := range opts {
 		opt(a)
 	}
+	a.httpClient = a.httpConfig.NewClient()
+	if a.vertex != nil {
+		if a.vertex.project == "" {
+			a.vertex.project = os.Getenv(ProjectEnv)
+		}
+		if a.vertex.location == "" {
+			a.vertex.location = cmp.Or(os.Getenv(LocationEnv), DefaultLocation)
+		}
+		if a.baseURL == "https://generativelanguage.googleapis.com/v1beta" {
+			a.baseURL = vertexBaseURL(a.vertex.location)
+		}
+		if a.tokens == nil {
+			a.tokens = googleauth.DefaultCredentials(a.httpClient)
+		}
+		return a
+	}
 	if a.apiKey == "" {
 		a.apiKey = os.Getenv("GEMINI_API_KEY")
 		if a.apiKey == "" {
@@ -83,6 +140,7 @@ type content struct {
 
 type part struct {
 	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
 	FunctionCall     *functionCall     `json:"functionCall,omitempty"`
 	FunctionResponse *functionResponse `json:"functionResponse,omitempty"`
 }
@@ -112,11 +170,31 @@ type generationConfig struct {
 	Temperature     *float64 `json:"temperature,omitempty"`
 	TopP            *float64 `json:"topP,omitempty"`
 	StopSequences   []string `json:"stopSequences,omitempty"`
+
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ResponseSchema   json.RawMessage `json:"responseSchema,omitempty"`
+	ThinkingConfig   *thinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type thinkingConfig struct {
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
 }
 
 type generateResponse struct {
-	Candidates    []candidate    `json:"candidates"`
-	UsageMetadata usageMetadata  `json:"usageMetadata"`
+	Candidates     []candidate     `json:"candidates"`
+	UsageMetadata  usageMetadata   `json:"usageMetadata"`
+	PromptFeedback *promptFeedback `json:"promptFeedback,omitempty"`
+}
+
+// promptFeedback explains a response without candidates: a blockReason
+// means the prompt itself was refused.
+type promptFeedback struct {
+	BlockReason string `json:"blockReason"`
+}
+
+func (gr *generateResponse) promptBlocked() bool {
+	return gr.PromptFeedback != nil && gr.PromptFeedback.BlockReason != ""
 }
 
 type candidate struct {
@@ -128,6 +206,45 @@ type usageMetadata struct {
 	PromptTokenCount     int `json:"promptTokenCount"`
 	CandidatesTokenCount int `json:"candidatesTokenCount"`
 	TotalTokenCount      int `json:"totalTokenCount"`
+	ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+}
+
+// usage converts Gemini usage metadata. Thought tokens are billed as output
+// but reported separately, so they are added to OutputTokens.
+func (u usageMetadata) usage() llm.Usage {
+	return llm.Usage{
+		InputTokens:     u.PromptTokenCount,
+		OutputTokens:    u.CandidatesTokenCount + u.ThoughtsTokenCount,
+		TotalTokens:     u.TotalTokenCount,
+		ReasoningTokens: u.ThoughtsTokenCount,
+	}
+}
+
+// reasoningBudgets maps llm.Request.ReasoningEffort to a thinking budget.
+var reasoningBudgets = map[string]int{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+// buildThinkingConfig derives a thinkingConfig from ProviderOptions["gemini"]
+// ["thinking_config"] when present, falling back to ReasoningEffort.
+func buildThinkingConfig(req *llm.Request) *thinkingConfig {
+	if opts, ok := req.ProviderOptions["gemini"].(map[string]interface{}); ok {
+		if raw, ok := opts["thinking_config"]; ok {
+			data, err := json.Marshal(raw)
+			if err == nil {
+				var tc thinkingConfig
+				if json.Unmarshal(data, &tc) == nil {
+					return &tc
+				}
+			}
+		}
+	}
+	if budget, ok := reasoningBudgets[req.ReasoningEffort]; ok {
+		return &thinkingConfig{ThinkingBudget: &budget, IncludeThoughts: true}
+	}
+	return nil
 }
 
 func convertGeminiFinishReason(reason string) llm.FinishReason {
@@ -136,8 +253,8 @@ func convertGeminiFinishReason(reason string) llm.FinishReason {
 		return llm.FinishReasonStop
 	case "MAX_TOKENS":
 		return llm.FinishReasonLength
-	case "SAFETY", "RECITATION", "OTHER":
-		return llm.FinishReasonStop
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return llm.FinishReasonContentFilter
 	default:
 		return llm.FinishReasonStop
 	}
@@ -154,8 +271,21 @@ func (a *Adapter) buildRequest(req *llm.Request) generateRequest {
 
 	for _, m := range req.Messages {
 		switch m.Role {
-		case llm.RoleSystem:
-			continue
+		case llm.RoleSystem, llm.RoleDeveloper:
+			// Gemini only accepts a single systemInstruction. System messages
+			// before the conversation starts are folded into it; later ones
+			// keep their position as a user-role preamble.
+			if len(gr.Contents) == 0 {
+				if gr.SystemInstruction == nil {
+					gr.SystemInstruction = &content{}
+				}
+				gr.SystemInstruction.Parts = append(gr.SystemInstruction.Parts, part{Text: m.Content})
+				continue
+			}
+			gr.Contents = append(gr.Contents, content{
+				Role:  "user",
+				Parts: []part{{Text: "[System] " + m.Content}},
+			})
 		case llm.RoleUser:
 			gr.Contents = append(gr.Contents, content{
 				Role:  "user",
@@ -211,16 +341,41 @@ func (a *Adapter) buildRequest(req *llm.Request) generateRequest {
 	gc.Temperature = req.Temperature
 	gc.TopP = req.TopP
 	gc.StopSequences = req.StopSequences
+	if rf := req.ResponseFormat; rf != nil {
+		switch rf.Type {
+		case "json_object":
+			gc.ResponseMimeType = "application/json"
+		case "json_schema":
+			gc.ResponseMimeType = "application/json"
+			gc.ResponseSchema = unwrapSchema(rf.JSONSchema)
+		}
+	}
+	gc.ThinkingConfig = buildThinkingConfig(req)
 	gr.GenerationConfig = gc
 
 	return gr
 }
 
+// unwrapSchema accepts either a bare JSON schema or the OpenAI-style
+// {"name": ..., "schema": {...}} wrapper and returns the bare schema.
+func unwrapSchema(raw json.RawMessage) json.RawMessage {
+	var wrapper struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+	}
+	if err := json.Unmarshal(raw, &wrapper); err == nil && len(wrapper.Schema) > 0 {
+		return wrapper.Schema
+	}
+	return raw
+}
+
 func (a *Adapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, req)
+	defer cancel()
 	gr := a.buildRequest(req)
 
-	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", a.baseURL, req.Model, a.apiKey)
-	data, err := json.Marshal(gr)
+	url := a.modelURL(req.Model, "generateContent", false)
+	data, err := llm.EncodeRequestBody(gr, "gemini", req.ProviderOptions)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
@@ -230,6 +385,12 @@ func (a *Adapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	if err := a.authorize(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := a.httpClient.Do(httpReq)
 	if err != nil {
@@ -244,25 +405,25 @@ func (a *Adapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, llm.ClassifyHTTPError(resp.StatusCode, string(body), "gemini")
+		return nil, llm.ClassifyHTTPResponse(resp, string(body), "gemini")
 	}
 
 	var genResp generateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
+	raw, err := llm.DecodeResponse(resp.Body, &genResp)
+	if err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
 
-	return a.convertResponse(&genResp), nil
+	result := a.convertResponse(&genResp)
+	result.Raw = raw
+	result.Headers = resp.Header
+	return result, nil
 }
 
 func (a *Adapter) convertResponse(gr *generateResponse) *llm.Response {
 	resp := &llm.Response{
-		ID:    fmt.Sprintf("gemini-%d", time.Now().UnixNano()),
-		Usage: llm.Usage{
-			InputTokens:  gr.UsageMetadata.PromptTokenCount,
-			OutputTokens: gr.UsageMetadata.CandidatesTokenCount,
-			TotalTokens:  gr.UsageMetadata.TotalTokenCount,
-		},
+		ID:        fmt.Sprintf("gemini-%d", time.Now().UnixNano()),
+		Usage:     gr.UsageMetadata.usage(),
 		CreatedAt: time.Now(),
 	}
 
@@ -271,8 +432,12 @@ func (a *Adapter) convertResponse(gr *generateResponse) *llm.Response {
 
 		resp.FinishReason = convertGeminiFinishReason(cand.FinishReason)
 
-		var textParts []string
+		var textParts, thoughtParts []string
 		for _, p := range cand.Content.Parts {
+			if p.Thought {
+				thoughtParts = append(thoughtParts, p.Text)
+				continue
+			}
 			if p.Text != "" {
 				textParts = append(textParts, p.Text)
 			}
@@ -286,20 +451,25 @@ func (a *Adapter) convertResponse(gr *generateResponse) *llm.Response {
 			}
 		}
 		resp.Content = strings.Join(textParts, "")
+		resp.Reasoning = strings.Join(thoughtParts, "")
 
 		if len(resp.ToolCalls) > 0 {
 			resp.FinishReason = llm.FinishReasonToolCalls
 		}
+	} else if gr.promptBlocked() {
+		resp.FinishReason = llm.FinishReasonContentFilter
 	}
 
 	return resp
 }
 
-func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.StreamEvent, error) {
+// openStream sends a streaming request and returns the response once its
+// headers arrive.
+func (a *Adapter) openStream(ctx context.Context, req *llm.Request) (*http.Response, error) {
 	gr := a.buildRequest(req)
 
-	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s", a.baseURL, req.Model, a.apiKey)
-	data, err := json.Marshal(gr)
+	url := a.modelURL(req.Model, "streamGenerateContent", true)
+	data, err := llm.EncodeRequestBody(gr, "gemini", req.ProviderOptions)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
@@ -309,9 +479,18 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	if err := a.authorize(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := a.httpClient.Do(httpReq)
 	if err != nil {
+		if cause, ok := context.Cause(ctx).(*llm.LLMError); ok {
+			return nil, cause
+		}
 		return nil, &llm.LLMError{
 			Type:     llm.ErrorTypeNetwork,
 			Message:  err.Error(),
@@ -323,22 +502,43 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, llm.ClassifyHTTPError(resp.StatusCode, string(body), "gemini")
+		return nil, llm.ClassifyHTTPResponse(resp, string(body), "gemini")
 	}
+	return resp, nil
+}
 
+func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.StreamEvent, error) {
+	ctx, cancel := a.httpConfig.StreamContext(ctx, req, "gemini")
+	resp, err := a.openStream(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = a.httpConfig.StreamBody(resp.Body, req, "gemini")
 	ch := make(chan llm.StreamEvent, 64)
 	go func() {
 		defer close(ch)
+		defer cancel()
 		defer resp.Body.Close()
 
-		scanner := bufio.NewScanner(resp.Body)
-		scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024) // 1MB buffer
-		for scanner.Scan() {
-			line := scanner.Text()
-			if !strings.HasPrefix(line, "data: ") {
-				continue
+		// Closing the body on cancellation unblocks the reader.
+		stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+		defer stop()
+		out := llm.NewStreamSender(ctx, ch)
+		var streamErr error
+		defer func() { out.Finish(streamErr) }()
+
+		reader := sse.NewReader(resp.Body)
+		for {
+			msg, err := reader.Next()
+			if err != nil {
+				if err != io.EOF {
+					streamErr = err
+				}
+				break
 			}
-			data := strings.TrimPrefix(line, "data: ")
+			data := msg.Data
 
 			var chunk generateResponse
 			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
@@ -346,16 +546,21 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 			}
 
 			if len(chunk.Candidates) == 0 {
-				// May contain usage metadata without candidates
-				if chunk.UsageMetadata.TotalTokenCount > 0 {
-					ch <- llm.StreamEvent{
-						Type: llm.StreamEventEnd,
-						Usage: &llm.Usage{
-							InputTokens:  chunk.UsageMetadata.PromptTokenCount,
-							OutputTokens: chunk.UsageMetadata.CandidatesTokenCount,
-							TotalTokens:  chunk.UsageMetadata.TotalTokenCount,
-						},
-						FinishReason: llm.FinishReasonStop,
+				// May contain usage metadata without candidates, or
+				// feedback on a blocked prompt.
+				if chunk.UsageMetadata.TotalTokenCount > 0 || chunk.promptBlocked() {
+					usage := chunk.UsageMetadata.usage()
+					fr := llm.FinishReasonStop
+					if chunk.promptBlocked() {
+						fr = llm.FinishReasonContentFilter
+					}
+					if !out.Send(llm.StreamEvent{
+						Type:         llm.StreamEventEnd,
+						Usage:        &usage,
+						FinishReason: fr,
+						Headers:      resp.Header,
+					}) {
+						return
 					}
 				}
 				continue
@@ -363,21 +568,36 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 
 			cand := chunk.Candidates[0]
 			for _, p := range cand.Content.Parts {
+				if p.Thought {
+					if p.Text != "" {
+						if !out.Send(llm.StreamEvent{
+							Type:  llm.StreamEventReasoningDelta,
+							Delta: p.Text,
+						}) {
+							return
+						}
+					}
+					continue
+				}
 				if p.Text != "" {
-					ch <- llm.StreamEvent{
+					if !out.Send(llm.StreamEvent{
 						Type:  llm.StreamEventDelta,
 						Delta: p.Text,
+					}) {
+						return
 					}
 				}
 				if p.FunctionCall != nil {
 					args, _ := json.Marshal(p.FunctionCall.Args)
-					ch <- llm.StreamEvent{
+					if !out.Send(llm.StreamEvent{
 						Type: llm.StreamEventToolCallStart,
 						ToolCall: &llm.ToolCall{
 							ID:        fmt.Sprintf("call_%s_%d", p.FunctionCall.Name, time.Now().UnixNano()),
 							Name:      p.FunctionCall.Name,
 							Arguments: args,
 						},
+					}) {
+						return
 					}
 				}
 			}
@@ -397,21 +617,95 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 				endEvent := llm.StreamEvent{
 					Type:         llm.StreamEventEnd,
 					FinishReason: fr,
+					Headers:      resp.Header,
 				}
 				if chunk.UsageMetadata.TotalTokenCount > 0 {
-					endEvent.Usage = &llm.Usage{
-						InputTokens:  chunk.UsageMetadata.PromptTokenCount,
-						OutputTokens: chunk.UsageMetadata.CandidatesTokenCount,
-						TotalTokens:  chunk.UsageMetadata.TotalTokenCount,
-					}
+					usage := chunk.UsageMetadata.usage()
+					endEvent.Usage = &usage
+				}
+				if !out.Send(endEvent) {
+					return
 				}
-				ch <- endEvent
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			ch <- llm.StreamEvent{Type: llm.StreamEventError, Error: err}
-		}
 	}()
 
 	return ch, nil
 }
+
+// ListModels lists the models that support generateContent, with their
+// token limits as reported by the API. Vertex AI has no listing of
+// publisher models, so there the built-in catalog is returned.
+func (a *Adapter) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	if a.vertex != nil {
+		return llm.ListModels("gemini"), nil
+	}
+	ctx, cancel := a.httpConfig.RequestContext(ctx, nil)
+	defer cancel()
+	var models []llm.ModelInfo
+	pageToken := ""
+	for {
+		query := url.Values{"key": {a.apiKey}, "pageSize": {"1000"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/models?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		resp, err := a.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, &llm.LLMError{
+				Type:     llm.ErrorTypeNetwork,
+				Message:  err.Error(),
+				Provider: "gemini",
+				Cause:    err,
+			}
+		}
+		var page struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				DisplayName                string   `json:"displayName"`
+				InputTokenLimit            int      `json:"inputTokenLimit"`
+				OutputTokenLimit           int      `json:"outputTokenLimit"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+				Thinking                   bool     `json:"thinking"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if resp.StatusCode >= 400 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, llm.ClassifyHTTPResponse(resp, string(body), "gemini")
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+
+		for _, m := range page.Models {
+			generates := false
+			for _, method := range m.SupportedGenerationMethods {
+				if method == "generateContent" {
+					generates = true
+				}
+			}
+			if !generates {
+				continue
+			}
+			models = append(models, llm.ModelInfo{
+				ID:                strings.TrimPrefix(m.Name, "models/"),
+				Provider:          "gemini",
+				DisplayName:       m.DisplayName,
+				ContextWindow:     m.InputTokenLimit,
+				MaxOutput:         m.OutputTokenLimit,
+				SupportsReasoning: m.Thinking,
+			})
+		}
+		if page.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
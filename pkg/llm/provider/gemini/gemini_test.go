@@ -3,11 +3,14 @@ package gemini
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ashka-vakil/attractor/pkg/llm"
 )
@@ -63,9 +66,10 @@ func TestBuildRequest(t *testing.T) {
 		}
 	})
 
-	t.Run("system role message is skipped", func(t *testing.T) {
+	t.Run("leading system messages fold into system instruction", func(t *testing.T) {
 		req := &llm.Request{
-			Model: "gemini-2.0-flash",
+			Model:        "gemini-2.0-flash",
+			SystemPrompt: "Base prompt",
 			Messages: []llm.Message{
 				{Role: llm.RoleSystem, Content: "System msg"},
 				{Role: llm.RoleUser, Content: "Hi"},
@@ -74,11 +78,40 @@ func TestBuildRequest(t *testing.T) {
 		gr := adapter.buildRequest(req)
 
 		if len(gr.Contents) != 1 {
-			t.Fatalf("expected 1 content (system skipped), got %d", len(gr.Contents))
+			t.Fatalf("expected 1 content, got %d", len(gr.Contents))
 		}
 		if gr.Contents[0].Role != "user" {
 			t.Errorf("expected user role, got %s", gr.Contents[0].Role)
 		}
+		if gr.SystemInstruction == nil || len(gr.SystemInstruction.Parts) != 2 {
+			t.Fatalf("expected 2 system instruction parts, got %+v", gr.SystemInstruction)
+		}
+		if gr.SystemInstruction.Parts[1].Text != "System msg" {
+			t.Errorf("expected System msg, got %s", gr.SystemInstruction.Parts[1].Text)
+		}
+	})
+
+	t.Run("mid-conversation system message becomes user preamble", func(t *testing.T) {
+		req := &llm.Request{
+			Model: "gemini-2.0-flash",
+			Messages: []llm.Message{
+				{Role: llm.RoleUser, Content: "Hi"},
+				{Role: llm.RoleAssistant, Content: "Hello!"},
+				{Role: llm.RoleSystem, Content: "Stage 2: review the code"},
+				{Role: llm.RoleUser, Content: "Go"},
+			},
+		}
+		gr := adapter.buildRequest(req)
+
+		if gr.SystemInstruction != nil {
+			t.Errorf("expected nil system instruction, got %+v", gr.SystemInstruction)
+		}
+		if len(gr.Contents) != 4 {
+			t.Fatalf("expected 4 contents, got %d", len(gr.Contents))
+		}
+		if gr.Contents[2].Role != "user" || gr.Contents[2].Parts[0].Text != "[System] Stage 2: review the code" {
+			t.Errorf("expected system preamble as user content, got %+v", gr.Contents[2])
+		}
 	})
 
 	t.Run("assistant message maps to model role", func(t *testing.T) {
@@ -235,6 +268,64 @@ func TestBuildRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("response format", func(t *testing.T) {
+		req := &llm.Request{
+			Model:          "gemini-2.0-flash",
+			Messages:       []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+			ResponseFormat: &llm.ResponseFormat{Type: "json_object"},
+		}
+		gr := adapter.buildRequest(req)
+		if gr.GenerationConfig.ResponseMimeType != "application/json" {
+			t.Errorf("expected application/json, got %q", gr.GenerationConfig.ResponseMimeType)
+		}
+		if gr.GenerationConfig.ResponseSchema != nil {
+			t.Errorf("expected no schema, got %s", gr.GenerationConfig.ResponseSchema)
+		}
+
+		req.ResponseFormat = &llm.ResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: json.RawMessage(`{"name":"test","schema":{"type":"object"}}`),
+		}
+		gr = adapter.buildRequest(req)
+		if string(gr.GenerationConfig.ResponseSchema) != `{"type":"object"}` {
+			t.Errorf("expected unwrapped schema, got %s", gr.GenerationConfig.ResponseSchema)
+		}
+
+		req.ResponseFormat.JSONSchema = json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}}}`)
+		gr = adapter.buildRequest(req)
+		if string(gr.GenerationConfig.ResponseSchema) != string(req.ResponseFormat.JSONSchema) {
+			t.Errorf("expected bare schema to pass through, got %s", gr.GenerationConfig.ResponseSchema)
+		}
+	})
+
+	t.Run("thinking config", func(t *testing.T) {
+		req := &llm.Request{
+			Model:           "gemini-2.5-pro",
+			Messages:        []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+			ReasoningEffort: "medium",
+		}
+		tc := adapter.buildRequest(req).GenerationConfig.ThinkingConfig
+		if tc == nil || tc.ThinkingBudget == nil || *tc.ThinkingBudget != 8192 || !tc.IncludeThoughts {
+			t.Errorf("expected budget 8192 with thoughts, got %+v", tc)
+		}
+
+		req.ProviderOptions = map[string]interface{}{
+			"gemini": map[string]interface{}{
+				"thinking_config": map[string]interface{}{"thinkingBudget": 0},
+			},
+		}
+		tc = adapter.buildRequest(req).GenerationConfig.ThinkingConfig
+		if tc == nil || tc.ThinkingBudget == nil || *tc.ThinkingBudget != 0 || tc.IncludeThoughts {
+			t.Errorf("expected provider options passthrough, got %+v", tc)
+		}
+
+		req.ProviderOptions = nil
+		req.ReasoningEffort = ""
+		if tc := adapter.buildRequest(req).GenerationConfig.ThinkingConfig; tc != nil {
+			t.Errorf("expected no thinking config, got %+v", tc)
+		}
+	})
+
 	t.Run("no tools when empty", func(t *testing.T) {
 		req := &llm.Request{
 			Model:    "gemini-2.0-flash",
@@ -445,6 +536,38 @@ func TestComplete(t *testing.T) {
 // TestCompleteError
 // ---------------------------------------------------------------------------
 
+func TestConvertResponseThoughts(t *testing.T) {
+	adapter := NewAdapter(WithAPIKey("test-key"))
+	resp := adapter.convertResponse(&generateResponse{
+		Candidates: []candidate{{
+			Content: content{
+				Role: "model",
+				Parts: []part{
+					{Text: "Let me think.", Thought: true},
+					{Text: "The answer is 4."},
+				},
+			},
+			FinishReason: "STOP",
+		}},
+		UsageMetadata: usageMetadata{
+			PromptTokenCount:     10,
+			CandidatesTokenCount: 5,
+			ThoughtsTokenCount:   20,
+			TotalTokenCount:      35,
+		},
+	})
+
+	if resp.Content != "The answer is 4." {
+		t.Errorf("expected content without thoughts, got %q", resp.Content)
+	}
+	if resp.Reasoning != "Let me think." {
+		t.Errorf("expected reasoning, got %q", resp.Reasoning)
+	}
+	if resp.Usage.ReasoningTokens != 20 || resp.Usage.OutputTokens != 25 {
+		t.Errorf("expected 20 reasoning / 25 output tokens, got %+v", resp.Usage)
+	}
+}
+
 func TestCompleteError(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -612,6 +735,47 @@ func TestStream(t *testing.T) {
 		}
 	})
 
+	t.Run("thought streaming", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			flusher := w.(http.Flusher)
+
+			events := []string{
+				`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Thinking...","thought":true}]}}]}`,
+				`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Done"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":1,"thoughtsTokenCount":7,"totalTokenCount":11}}`,
+			}
+			for _, event := range events {
+				fmt.Fprintf(w, "%s\n\n", event)
+				flusher.Flush()
+			}
+		}))
+		defer server.Close()
+
+		adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+		ch, err := adapter.Stream(context.Background(), &llm.Request{
+			Model:    "gemini-2.5-flash",
+			Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		acc := &llm.StreamAccumulator{}
+		for ev := range ch {
+			acc.Process(ev)
+		}
+		resp := acc.Response()
+		if resp.Reasoning != "Thinking..." {
+			t.Errorf("expected reasoning 'Thinking...', got %q", resp.Reasoning)
+		}
+		if resp.Content != "Done" {
+			t.Errorf("expected content 'Done', got %q", resp.Content)
+		}
+		if resp.Usage.ReasoningTokens != 7 {
+			t.Errorf("expected 7 reasoning tokens, got %d", resp.Usage.ReasoningTokens)
+		}
+	})
+
 	t.Run("function call streaming", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/event-stream")
@@ -790,8 +954,9 @@ func TestConvertGeminiFinishReason(t *testing.T) {
 	}{
 		{"STOP", llm.FinishReasonStop},
 		{"MAX_TOKENS", llm.FinishReasonLength},
-		{"SAFETY", llm.FinishReasonStop},
-		{"RECITATION", llm.FinishReasonStop},
+		{"SAFETY", llm.FinishReasonContentFilter},
+		{"RECITATION", llm.FinishReasonContentFilter},
+		{"PROHIBITED_CONTENT", llm.FinishReasonContentFilter},
 		{"OTHER", llm.FinishReasonStop},
 		{"UNKNOWN", llm.FinishReasonStop},
 		{"", llm.FinishReasonStop},
@@ -807,6 +972,66 @@ func TestConvertGeminiFinishReason(t *testing.T) {
 	}
 }
 
+func TestConvertResponsePromptBlocked(t *testing.T) {
+	a := NewAdapter(WithAPIKey("key"))
+	resp := a.convertResponse(&generateResponse{PromptFeedback: &promptFeedback{BlockReason: "SAFETY"}})
+	if resp.FinishReason != llm.FinishReasonContentFilter {
+		t.Errorf("FinishReason = %s, want content_filter", resp.FinishReason)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestStreamCancellation
+// ---------------------------------------------------------------------------
+
+func TestStreamCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hi"}]}}]}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+	ch, err := adapter.Stream(ctx, &llm.Request{
+		Model:    "gemini-2.0-flash",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ev := <-ch; ev.Type != llm.StreamEventDelta {
+		t.Fatalf("expected first delta, got %+v", ev)
+	}
+	cancel()
+
+	var last llm.StreamEvent
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			last = ev
+		case <-timeout:
+			t.Fatal("stream did not close after cancellation")
+		}
+	}
+	if last.Type != llm.StreamEventError || !errors.Is(last.Error, context.Canceled) {
+		t.Errorf("expected terminal context.Canceled error, got %+v", last)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestName
 // ---------------------------------------------------------------------------
@@ -914,3 +1139,83 @@ func TestStreamURLConstruction(t *testing.T) {
 		t.Errorf("expected query to contain key=my-api-key, got %s", capturedQuery)
 	}
 }
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" || r.URL.Query().Get("key") != "test-key" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models": [
+			{"name": "models/gemini-2.5-pro", "displayName": "Gemini 2.5 Pro", "inputTokenLimit": 1048576, "outputTokenLimit": 65536, "supportedGenerationMethods": ["generateContent", "countTokens"], "thinking": true},
+			{"name": "models/text-embedding-004", "supportedGenerationMethods": ["embedContent"]}
+		]}`))
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+	models, err := adapter.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(models) != 1 {
+		t.Fatalf("expected only the generative model, got %+v", models)
+	}
+	m := models[0]
+	if m.ID != "gemini-2.5-pro" || m.ContextWindow != 1048576 || m.MaxOutput != 65536 || !m.SupportsReasoning {
+		t.Errorf("unexpected model: %+v", m)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-2.5-pro:countTokens" || r.URL.Query().Get("key") != "key" {
+			t.Errorf("URL = %q", r.URL)
+		}
+		var body struct {
+			GenerateContentRequest map[string]interface{} `json:"generateContentRequest"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.GenerateContentRequest["model"] != "models/gemini-2.5-pro" || body.GenerateContentRequest["systemInstruction"] == nil {
+			t.Errorf("generateContentRequest = %v", body.GenerateContentRequest)
+		}
+		io.WriteString(w, `{"totalTokens":17}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	n, err := adapter.CountTokens(context.Background(), &llm.Request{
+		Model:        "gemini-2.5-pro",
+		SystemPrompt: "Be brief.",
+		Messages:     []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 17 {
+		t.Errorf("CountTokens = %d, want 17", n)
+	}
+}
+
+func TestCompleteKeepsRawResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP","safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]}]}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	resp, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:    "gemini-2.5-pro",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p, _ := resp.RawField("candidates", "0", "safetyRatings", "0", "probability"); p != "NEGLIGIBLE" {
+		t.Errorf("safety rating probability = %v", p)
+	}
+	if resp.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("headers not kept: %v", resp.Headers)
+	}
+}
@@ -0,0 +1,76 @@
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+// CountTokens returns the input tokens of req as counted by the countTokens
+// endpoint. The Gemini API takes the whole generate request, so the system
+// instruction and tools are counted too; Vertex AI takes them at the top
+// level.
+func (a *Adapter) CountTokens(ctx context.Context, req *llm.Request) (int, error) {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, req)
+	defer cancel()
+	gr := a.buildRequest(req)
+	var body interface{}
+	if a.vertex != nil {
+		body = struct {
+			Contents          []content    `json:"contents"`
+			Tools             []geminiTool `json:"tools,omitempty"`
+			SystemInstruction *content     `json:"systemInstruction,omitempty"`
+		}{gr.Contents, gr.Tools, gr.SystemInstruction}
+	} else {
+		body = struct {
+			GenerateContentRequest interface{} `json:"generateContentRequest"`
+		}{struct {
+			Model string `json:"model"`
+			generateRequest
+		}{"models/" + req.Model, gr}}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, fmt.Errorf("marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.modelURL(req.Model, "countTokens", false), bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	if err := a.authorize(ctx, httpReq); err != nil {
+		return 0, err
+	}
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, &llm.LLMError{
+			Type:     llm.ErrorTypeNetwork,
+			Message:  err.Error(),
+			Provider: "gemini",
+			Cause:    err,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, llm.ClassifyHTTPResponse(resp, string(body), "gemini")
+	}
+	var out struct {
+		TotalTokens int `json:"totalTokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("decode response: %w", err)
+	}
+	return out.TotalTokens, nil
+}
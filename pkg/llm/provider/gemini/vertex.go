@@ -0,0 +1,131 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/googleauth"
+)
+
+// Vertex AI is Google Cloud's endpoint for Gemini. It authenticates with
+// OAuth access tokens instead of API keys and addresses models by project,
+// location, and publisher. These variables select it from the environment,
+// with the names Google's own SDKs use.
+const (
+	VertexEnv   = "GOOGLE_GENAI_USE_VERTEXAI"
+	ProjectEnv  = "GOOGLE_CLOUD_PROJECT"
+	LocationEnv = "GOOGLE_CLOUD_LOCATION"
+)
+
+// DefaultLocation is the Vertex AI location used when none is configured.
+const DefaultLocation = "us-central1"
+
+// TokenSource supplies OAuth access tokens for Vertex AI requests.
+type TokenSource = googleauth.TokenSource
+
+// StaticToken is a TokenSource that always returns the same token, such as
+// the output of `gcloud auth print-access-token`.
+type StaticToken = googleauth.StaticToken
+
+type vertexConfig struct {
+	project  string
+	location string
+}
+
+// WithVertexAI sends requests to Vertex AI in project and location instead
+// of the Gemini API. Requests are authenticated with Application Default
+// Credentials unless WithTokenSource is given. An empty location means
+// DefaultLocation.
+func WithVertexAI(project, location string) Option {
+	return func(a *Adapter) {
+		a.vertex = &vertexConfig{project: project, location: location}
+	}
+}
+
+// WithTokenSource sets where Vertex AI access tokens come from.
+func WithTokenSource(ts TokenSource) Option {
+	return func(a *Adapter) { a.tokens = ts }
+}
+
+// VertexFromEnv reports whether GOOGLE_GENAI_USE_VERTEXAI selects Vertex AI:
+// it is set to 1, true, or yes, in any case.
+func VertexFromEnv() bool {
+	switch strings.ToLower(os.Getenv(VertexEnv)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// vertexFromEnv returns the Vertex AI configuration named by the environment,
+// or nil when GOOGLE_GENAI_USE_VERTEXAI is not set to true.
+func vertexFromEnv() *vertexConfig {
+	if !VertexFromEnv() {
+		return nil
+	}
+	return &vertexConfig{project: os.Getenv(ProjectEnv), location: os.Getenv(LocationEnv)}
+}
+
+// vertexBaseURL returns the regional Vertex AI endpoint for location.
+func vertexBaseURL(location string) string {
+	if location == "global" {
+		return "https://aiplatform.googleapis.com/v1"
+	}
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1", location)
+}
+
+// modelURL returns the URL of method (generateContent or
+// streamGenerateContent) on model. On Vertex AI a bare model name is a
+// Google publisher model; "publishers/<p>/models/<m>" selects another
+// publisher, and a full "projects/..." resource name is used as is.
+func (a *Adapter) modelURL(model, method string, stream bool) string {
+	if a.vertex == nil {
+		if stream {
+			return fmt.Sprintf("%s/models/%s:%s?alt=sse&key=%s", a.baseURL, model, method, a.apiKey)
+		}
+		return fmt.Sprintf("%s/models/%s:%s?key=%s", a.baseURL, model, method, a.apiKey)
+	}
+	path := model
+	switch {
+	case strings.HasPrefix(model, "projects/"):
+	case strings.HasPrefix(model, "publishers/"):
+		path = fmt.Sprintf("projects/%s/locations/%s/%s", a.vertex.project, a.vertex.location, model)
+	default:
+		path = fmt.Sprintf("projects/%s/locations/%s/publishers/google/models/%s", a.vertex.project, a.vertex.location, model)
+	}
+	u := fmt.Sprintf("%s/%s:%s", a.baseURL, path, method)
+	if stream {
+		u += "?alt=sse"
+	}
+	return u
+}
+
+// authorize adds the Vertex AI access token to httpReq. API-key requests
+// carry the key in the URL and need nothing more.
+func (a *Adapter) authorize(ctx context.Context, httpReq *http.Request) error {
+	if a.vertex == nil {
+		return nil
+	}
+	if a.vertex.project == "" {
+		return &llm.LLMError{
+			Type:     llm.ErrorTypeAuth,
+			Message:  "Vertex AI needs a project; set " + ProjectEnv + " or use WithVertexAI",
+			Provider: "gemini",
+		}
+	}
+	token, err := a.tokens.Token(ctx)
+	if err != nil {
+		return &llm.LLMError{
+			Type:     llm.ErrorTypeAuth,
+			Message:  "Vertex AI credentials: " + err.Error(),
+			Provider: "gemini",
+			Cause:    err,
+		}
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
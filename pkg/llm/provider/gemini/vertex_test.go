@@ -0,0 +1,96 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+)
+
+func TestVertexModelURL(t *testing.T) {
+	a := NewAdapter(WithVertexAI("proj", "europe-west4"), WithTokenSource(StaticToken("tok")))
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gemini-2.5-pro", "https://europe-west4-aiplatform.googleapis.com/v1/projects/proj/locations/europe-west4/publishers/google/models/gemini-2.5-pro:generateContent"},
+		{"publishers/anthropic/models/claude", "https://europe-west4-aiplatform.googleapis.com/v1/projects/proj/locations/europe-west4/publishers/anthropic/models/claude:generateContent"},
+		{"projects/other/locations/us/endpoints/123", "https://europe-west4-aiplatform.googleapis.com/v1/projects/other/locations/us/endpoints/123:generateContent"},
+	}
+	for _, tt := range tests {
+		if got := a.modelURL(tt.model, "generateContent", false); got != tt.want {
+			t.Errorf("modelURL(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+	if got := vertexBaseURL("global"); got != "https://aiplatform.googleapis.com/v1" {
+		t.Errorf("global base URL = %q", got)
+	}
+}
+
+func TestVertexFromEnv(t *testing.T) {
+	t.Setenv(VertexEnv, "true")
+	t.Setenv(ProjectEnv, "env-proj")
+	t.Setenv(LocationEnv, "")
+	a := NewAdapter(WithTokenSource(StaticToken("tok")))
+	if a.vertex == nil || a.vertex.project != "env-proj" || a.vertex.location != DefaultLocation {
+		t.Fatalf("vertex config = %+v", a.vertex)
+	}
+	if a.baseURL != "https://us-central1-aiplatform.googleapis.com/v1" {
+		t.Errorf("baseURL = %q", a.baseURL)
+	}
+}
+
+func TestVertexFromEnvValues(t *testing.T) {
+	for value, want := range map[string]bool{"1": true, "TRUE": true, "yes": true, "": false, "0": false, "false": false, "no": false} {
+		t.Setenv(VertexEnv, value)
+		if got := VertexFromEnv(); got != want {
+			t.Errorf("VertexFromEnv with %s=%q = %v, want %v", VertexEnv, value, got, want)
+		}
+	}
+}
+
+func TestVertexComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/proj/locations/us-central1/publishers/google/models/gemini-2.5-pro:generateContent" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if r.URL.Query().Get("key") != "" {
+			t.Errorf("API key sent to Vertex AI: %q", r.URL.RawQuery)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer tok" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`)
+	}))
+	defer server.Close()
+
+	a := NewAdapter(WithVertexAI("proj", ""), WithBaseURL(server.URL), WithTokenSource(StaticToken("tok")))
+	resp, err := a.Complete(context.Background(), &llm.Request{
+		Model:    "gemini-2.5-pro",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "ok" {
+		t.Errorf("Content = %q, want ok", resp.Content)
+	}
+}
+
+func TestVertexMissingProject(t *testing.T) {
+	t.Setenv(ProjectEnv, "")
+	a := NewAdapter(WithVertexAI("", ""), WithTokenSource(StaticToken("tok")))
+	_, err := a.Complete(context.Background(), &llm.Request{
+		Model:    "gemini-2.5-pro",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	var llmErr *llm.LLMError
+	if !errors.As(err, &llmErr) || llmErr.Type != llm.ErrorTypeAuth {
+		t.Fatalf("err = %v, want an auth error", err)
+	}
+}
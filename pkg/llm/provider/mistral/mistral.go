@@ -0,0 +1,39 @@
+// Package mistral implements the Mistral provider adapter for the unified LLM
+// client. Mistral's chat completions API follows OpenAI's with a few
+// differences: it has no stream_options, reasoning_effort, or logprobs and
+// calls the forced tool choice "any".
+package mistral
+
+import (
+	"os"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/provider/openai"
+)
+
+func init() {
+	llm.RegisterProviderFactory("mistral", "MISTRAL_API_KEY", func() llm.ProviderAdapter {
+		return NewAdapter()
+	})
+}
+
+// DefaultBaseURL is the Mistral API endpoint.
+const DefaultBaseURL = "https://api.mistral.ai/v1"
+
+// NewAdapter creates a Mistral adapter. The API key and base URL default to
+// MISTRAL_API_KEY and MISTRAL_BASE_URL; opts take precedence. Any
+// openai.Option applies.
+func NewAdapter(opts ...openai.Option) *openai.Adapter {
+	defaults := []openai.Option{openai.WithAPIKey(os.Getenv("MISTRAL_API_KEY"))}
+	if url := os.Getenv("MISTRAL_BASE_URL"); url != "" {
+		defaults = append(defaults, openai.WithBaseURL(url))
+	}
+	return openai.NewCompatibleAdapter(openai.Compat{
+		Name:               "mistral",
+		BaseURL:            DefaultBaseURL,
+		NoStreamOptions:    true,
+		RequiredToolChoice: "any",
+		NoReasoningEffort:  true,
+		NoLogprobs:         true,
+	}, append(defaults, opts...)...)
+}
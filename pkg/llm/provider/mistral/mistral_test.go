@@ -0,0 +1,86 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/provider/openai"
+)
+
+func TestName(t *testing.T) {
+	if got := NewAdapter(openai.WithAPIKey("key")).Name(); got != "mistral" {
+		t.Errorf("Name() = %q, want mistral", got)
+	}
+}
+
+func TestCompleteRequestBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("path = %q, want /chat/completions", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["tool_choice"] != "any" {
+			t.Errorf("tool_choice = %v, want any", body["tool_choice"])
+		}
+		if _, ok := body["reasoning_effort"]; ok {
+			t.Errorf("reasoning_effort sent to Mistral: %v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"cmpl-1","model":"codestral-latest","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(openai.WithAPIKey("test-key"), openai.WithBaseURL(server.URL))
+	resp, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:           "codestral-latest",
+		Messages:        []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		Tools:           []llm.Tool{{Name: "noop", Parameters: json.RawMessage(`{"type":"object"}`)}},
+		ToolChoice:      llm.ToolChoiceRequired,
+		ReasoningEffort: "high",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "ok" {
+		t.Errorf("Content = %q, want ok", resp.Content)
+	}
+}
+
+func TestStreamOmitsStreamOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := body["stream_options"]; ok {
+			t.Errorf("stream_options sent to Mistral: %v", body)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"c\",\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(openai.WithAPIKey("key"), openai.WithBaseURL(server.URL))
+	events, err := adapter.Stream(context.Background(), &llm.Request{
+		Model:    "codestral-latest",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var text strings.Builder
+	for ev := range events {
+		text.WriteString(ev.Delta)
+	}
+	if text.String() != "hi" {
+		t.Errorf("streamed text = %q, want hi", text.String())
+	}
+}
@@ -2,17 +2,19 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
+	"sort"
 	"time"
 
+	"github.com/ashka-vakil/attractor/internal/sse"
 	"github.com/ashka-vakil/attractor/pkg/llm"
 )
 
@@ -29,7 +31,28 @@ type Adapter struct {
 	orgID      string
 	projectID  string
 	httpClient *http.Client
+	httpConfig llm.HTTPConfig
 	headers    map[string]string
+	compat     Compat
+}
+
+// Compat describes a chat completions API that follows OpenAI's, such as
+// xAI's or Mistral's, for NewCompatibleAdapter.
+type Compat struct {
+	// Name identifies the provider in errors, model lists, and
+	// ProviderOptions keys.
+	Name    string
+	BaseURL string
+	// NoStreamOptions omits stream_options, for APIs that reject it. They
+	// must report usage in the last stream chunk without being asked.
+	NoStreamOptions bool
+	// RequiredToolChoice is the tool_choice that forces a tool call
+	// (default: "required").
+	RequiredToolChoice string
+	// NoReasoningEffort omits reasoning_effort, for APIs without it.
+	NoReasoningEffort bool
+	// NoLogprobs omits logprobs and top_logprobs, for APIs without them.
+	NoLogprobs bool
 }
 
 // Option configures the OpenAI adapter.
@@ -55,17 +78,43 @@ func WithHeaders(h map[string]string) Option {
 	return func(a *Adapter) { a.headers = h }
 }
 
+// WithHTTPClient sets the HTTP client used for API requests, in place of
+// one built from the proxy and TLS options.
+func WithHTTPClient(c *http.Client) Option {
+	return func(a *Adapter) { a.httpConfig.Client = c }
+}
+
+// WithProxy sends requests through the proxy at proxyURL instead of the one
+// named by HTTPS_PROXY.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(a *Adapter) { a.httpConfig.Proxy = proxyURL }
+}
+
+// WithConnectTimeout bounds dialing and the TLS handshake (default: 30s).
+func WithConnectTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.ConnectTimeout = d }
+}
+
+// WithRequestTimeout bounds a whole non-streaming request (default: 10m).
+// Request.Timeout overrides it per call.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.RequestTimeout = d }
+}
+
+// WithStreamIdleTimeout ends a stream that sends no data for d (default:
+// 5m). Streams have no overall deadline.
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(a *Adapter) { a.httpConfig.StreamIdleTimeout = d }
+}
+
+// WithTLSConfig sets the TLS configuration, e.g. to trust a custom CA.
+func WithTLSConfig(c *tls.Config) Option {
+	return func(a *Adapter) { a.httpConfig.TLSConfig = c }
+}
+
 // NewAdapter creates a new OpenAI adapter.
 func NewAdapter(opts ...Option) *Adapter {
-	a := &Adapter{
-		baseURL: "https://api.openai.com/v1",
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-		},
-	}
-	for _, opt := range opts {
-		opt(a)
-	}
+	a := NewCompatibleAdapter(Compat{Name: "openai", BaseURL: "https://api.openai.com/v1"}, opts...)
 	if a.apiKey == "" {
 		a.apiKey = os.Getenv("OPENAI_API_KEY")
 	}
@@ -78,7 +127,25 @@ func NewAdapter(opts ...Option) *Adapter {
 	return a
 }
 
-func (a *Adapter) Name() string { return "openai" }
+// NewCompatibleAdapter creates an adapter for an OpenAI-compatible chat
+// completions API. Unlike NewAdapter it reads no OPENAI_* environment
+// variables, so callers pass the API key with WithAPIKey.
+func NewCompatibleAdapter(compat Compat, opts ...Option) *Adapter {
+	if compat.RequiredToolChoice == "" {
+		compat.RequiredToolChoice = "required"
+	}
+	a := &Adapter{
+		baseURL: compat.BaseURL,
+		compat:  compat,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.httpClient = a.httpConfig.NewClient()
+	return a
+}
+
+func (a *Adapter) Name() string { return a.compat.Name }
 
 func (a *Adapter) Close() error { return nil }
 
@@ -96,6 +163,8 @@ type chatRequest struct {
 	StreamOptions    *streamOptions    `json:"stream_options,omitempty"`
 	ResponseFormat   *responseFormat   `json:"response_format,omitempty"`
 	ReasoningEffort  string            `json:"reasoning_effort,omitempty"`
+	Logprobs         bool              `json:"logprobs,omitempty"`
+	TopLogprobs      int               `json:"top_logprobs,omitempty"`
 }
 
 type streamOptions struct {
@@ -122,6 +191,7 @@ type chatFunction struct {
 }
 
 type chatToolCall struct {
+	Index    *int             `json:"index,omitempty"` // set on streaming deltas only
 	ID       string           `json:"id"`
 	Type     string           `json:"type"`
 	Function chatFunctionCall `json:"function"`
@@ -146,10 +216,15 @@ type chatResponse struct {
 }
 
 type chatChoice struct {
-	Index        int         `json:"index"`
-	Message      chatMessage `json:"message"`
-	FinishReason string      `json:"finish_reason"`
-	Delta        chatMessage `json:"delta"`
+	Index        int           `json:"index"`
+	Message      chatMessage   `json:"message"`
+	FinishReason string        `json:"finish_reason"`
+	Delta        chatMessage   `json:"delta"`
+	Logprobs     *chatLogprobs `json:"logprobs,omitempty"`
+}
+
+type chatLogprobs struct {
+	Content []llm.TokenLogprob `json:"content"`
 }
 
 type chatUsage struct {
@@ -202,9 +277,13 @@ func (a *Adapter) buildRequest(req *llm.Request) chatRequest {
 		Stop:        req.StopSequences,
 	}
 
-	if req.ReasoningEffort != "" {
+	if req.ReasoningEffort != "" && !a.compat.NoReasoningEffort {
 		cr.ReasoningEffort = req.ReasoningEffort
 	}
+	if !a.compat.NoLogprobs {
+		cr.Logprobs = req.Logprobs || req.TopLogprobs > 0
+		cr.TopLogprobs = req.TopLogprobs
+	}
 
 	for _, t := range req.Tools {
 		cr.Tools = append(cr.Tools, chatTool{
@@ -221,6 +300,9 @@ func (a *Adapter) buildRequest(req *llm.Request) chatRequest {
 		switch tc := req.ToolChoice.(type) {
 		case llm.ToolChoice:
 			cr.ToolChoice = string(tc)
+			if tc == llm.ToolChoiceRequired {
+				cr.ToolChoice = a.compat.RequiredToolChoice
+			}
 		case llm.ToolChoiceFunction:
 			cr.ToolChoice = map[string]interface{}{
 				"type":     "function",
@@ -239,8 +321,10 @@ func (a *Adapter) buildRequest(req *llm.Request) chatRequest {
 	return cr
 }
 
-func (a *Adapter) doRequest(ctx context.Context, body interface{}, stream bool) (*http.Response, error) {
-	data, err := json.Marshal(body)
+// doRequest posts body, with req's provider options merged in, and the
+// headers set by setHeaders.
+func (a *Adapter) doRequest(ctx context.Context, body interface{}, req *llm.Request, stream bool) (*http.Response, error) {
+	data, err := llm.EncodeRequestBody(body, a.compat.Name, req.ProviderOptions)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
@@ -251,6 +335,33 @@ func (a *Adapter) doRequest(ctx context.Context, body interface{}, stream bool)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	a.setHeaders(httpReq, req.Headers)
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		if cause, ok := context.Cause(ctx).(*llm.LLMError); ok {
+			return nil, cause
+		}
+		return nil, &llm.LLMError{
+			Type:     llm.ErrorTypeNetwork,
+			Message:  err.Error(),
+			Provider: a.compat.Name,
+			Cause:    err,
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, llm.ClassifyHTTPResponse(resp, string(body), a.compat.Name)
+	}
+
+	return resp, nil
+}
+
+// setHeaders sets the authentication headers, then the adapter's headers,
+// then the request's own headers.
+func (a *Adapter) setHeaders(httpReq *http.Request, headers map[string]string) {
 	httpReq.Header.Set("Authorization", "Bearer "+a.apiKey)
 	if a.orgID != "" {
 		httpReq.Header.Set("OpenAI-Organization", a.orgID)
@@ -261,42 +372,77 @@ func (a *Adapter) doRequest(ctx context.Context, body interface{}, stream bool)
 	for k, v := range a.headers {
 		httpReq.Header.Set(k, v)
 	}
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+}
+
+// getJSON sends a GET request for path and decodes the JSON response into out.
+func (a *Adapter) getJSON(ctx context.Context, path string, out interface{}) error {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, nil)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	a.setHeaders(httpReq, nil)
 
 	resp, err := a.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, &llm.LLMError{
+		return &llm.LLMError{
 			Type:     llm.ErrorTypeNetwork,
 			Message:  err.Error(),
-			Provider: "openai",
+			Provider: a.compat.Name,
 			Cause:    err,
 		}
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return nil, llm.ClassifyHTTPError(resp.StatusCode, string(body), "openai")
+		return llm.ClassifyHTTPResponse(resp, string(body), a.compat.Name)
 	}
-
-	return resp, nil
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
 }
 
 func (a *Adapter) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, req)
+	defer cancel()
 	cr := a.buildRequest(req)
 	cr.Stream = false
 
-	resp, err := a.doRequest(ctx, cr, false)
+	resp, err := a.doRequest(ctx, cr, req, false)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var chatResp chatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+	raw, err := llm.DecodeResponse(resp.Body, &chatResp)
+	if err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
 
-	return a.convertResponse(&chatResp), nil
+	result := a.convertResponse(&chatResp)
+	result.Raw = raw
+	result.Headers = resp.Header
+	return result, nil
+}
+
+func convertFinishReason(reason string) llm.FinishReason {
+	switch reason {
+	case "length":
+		return llm.FinishReasonLength
+	case "tool_calls", "function_call":
+		return llm.FinishReasonToolCalls
+	case "content_filter":
+		return llm.FinishReasonContentFilter
+	default:
+		return llm.FinishReasonStop
+	}
 }
 
 func (a *Adapter) convertResponse(cr *chatResponse) *llm.Response {
@@ -316,14 +462,12 @@ func (a *Adapter) convertResponse(cr *chatResponse) *llm.Response {
 		if content, ok := choice.Message.Content.(string); ok {
 			resp.Content = content
 		}
+		if choice.Logprobs != nil {
+			resp.Logprobs = choice.Logprobs.Content
+		}
 
-		switch choice.FinishReason {
-		case "stop":
-			resp.FinishReason = llm.FinishReasonStop
-		case "length":
-			resp.FinishReason = llm.FinishReasonLength
-		case "tool_calls":
-			resp.FinishReason = llm.FinishReasonToolCalls
+		if choice.FinishReason != "" {
+			resp.FinishReason = convertFinishReason(choice.FinishReason)
 		}
 
 		for _, tc := range choice.Message.ToolCalls {
@@ -341,44 +485,81 @@ func (a *Adapter) convertResponse(cr *chatResponse) *llm.Response {
 func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.StreamEvent, error) {
 	cr := a.buildRequest(req)
 	cr.Stream = true
-	cr.StreamOptions = &streamOptions{IncludeUsage: true}
+	if !a.compat.NoStreamOptions {
+		cr.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
 
-	resp, err := a.doRequest(ctx, cr, true)
+	ctx, cancel := a.httpConfig.StreamContext(ctx, req, a.compat.Name)
+	resp, err := a.doRequest(ctx, cr, req, true)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
+	resp.Body = a.httpConfig.StreamBody(resp.Body, req, a.compat.Name)
 	ch := make(chan llm.StreamEvent, 64)
 	go func() {
 		defer close(ch)
+		defer cancel()
 		defer resp.Body.Close()
 
+		// Closing the body on cancellation unblocks the reader.
+		stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+		defer stop()
+		out := llm.NewStreamSender(ctx, ch)
+		var streamErr error
+		defer func() { out.Finish(streamErr) }()
+
 		var finalUsage *llm.Usage
 		var finishReason llm.FinishReason
 
-		scanner := bufio.NewScanner(resp.Body)
-		scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024) // 1MB buffer
-		for scanner.Scan() {
-			line := scanner.Text()
-			if !strings.HasPrefix(line, "data: ") {
-				continue
+		// Tool call deltas are correlated by index; parallel calls may
+		// interleave, and only the first delta for an index carries the ID.
+		toolCalls := map[int]*llm.ToolCall{}
+		var toolOrder []int
+		lastIndex := 0
+		flushToolCalls := func() bool {
+			for _, idx := range toolOrder {
+				call := *toolCalls[idx]
+				if !out.Send(llm.StreamEvent{Type: llm.StreamEventToolCallEnd, ToolCall: &call}) {
+					return false
+				}
 			}
-			data := strings.TrimPrefix(line, "data: ")
+			toolOrder = nil
+			return true
+		}
+
+		reader := sse.NewReader(resp.Body)
+		for {
+			msg, err := reader.Next()
+			if err != nil {
+				if err != io.EOF {
+					streamErr = err
+				}
+				break
+			}
+			data := msg.Data
 			if data == "[DONE]" {
+				if !flushToolCalls() {
+					return
+				}
 				endEvent := llm.StreamEvent{
 					Type:         llm.StreamEventEnd,
 					FinishReason: finishReason,
+					Headers:      resp.Header,
 				}
 				if finalUsage != nil {
 					endEvent.Usage = finalUsage
 				}
-				ch <- endEvent
+				if !out.Send(endEvent) {
+					return
+				}
 				break
 			}
 
 			var chunk chatResponse
 			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-				ch <- llm.StreamEvent{Type: llm.StreamEventError, Error: err}
+				streamErr = err
 				return
 			}
 
@@ -399,47 +580,80 @@ func (a *Adapter) Stream(ctx context.Context, req *llm.Request) (<-chan llm.Stre
 			delta := choice.Delta
 
 			if content, ok := delta.Content.(string); ok && content != "" {
-				ch <- llm.StreamEvent{
+				ev := llm.StreamEvent{
 					Type:  llm.StreamEventDelta,
 					Delta: content,
 				}
+				if choice.Logprobs != nil {
+					ev.Logprobs = choice.Logprobs.Content
+				}
+				if !out.Send(ev) {
+					return
+				}
 			}
 
 			for _, tc := range delta.ToolCalls {
-				if tc.Function.Name != "" {
-					ch <- llm.StreamEvent{
+				idx := lastIndex
+				if tc.Index != nil {
+					idx = *tc.Index
+				}
+				lastIndex = idx
+				call, ok := toolCalls[idx]
+				if !ok {
+					call = &llm.ToolCall{ID: tc.ID, Name: tc.Function.Name}
+					toolCalls[idx] = call
+					toolOrder = append(toolOrder, idx)
+					if !out.Send(llm.StreamEvent{
 						Type: llm.StreamEventToolCallStart,
 						ToolCall: &llm.ToolCall{
-							ID:   tc.ID,
-							Name: tc.Function.Name,
+							ID:   call.ID,
+							Name: call.Name,
 						},
+					}) {
+						return
 					}
 				}
 				if tc.Function.Arguments != "" {
-					ch <- llm.StreamEvent{
-						Type:  llm.StreamEventToolCallDelta,
-						Delta: tc.Function.Arguments,
+					call.Arguments = append(call.Arguments, tc.Function.Arguments...)
+					if !out.Send(llm.StreamEvent{
+						Type:     llm.StreamEventToolCallDelta,
+						Delta:    tc.Function.Arguments,
+						ToolCall: &llm.ToolCall{ID: call.ID, Name: call.Name},
+					}) {
+						return
 					}
 				}
 			}
 
 			if choice.FinishReason != "" {
-				switch choice.FinishReason {
-				case "stop":
-					finishReason = llm.FinishReasonStop
-				case "length":
-					finishReason = llm.FinishReasonLength
-				case "tool_calls":
-					finishReason = llm.FinishReasonToolCalls
-				default:
-					finishReason = llm.FinishReasonStop
+				finishReason = convertFinishReason(choice.FinishReason)
+				if !flushToolCalls() {
+					return
 				}
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			ch <- llm.StreamEvent{Type: llm.StreamEventError, Error: err}
-		}
 	}()
 
 	return ch, nil
 }
+
+// ListModels lists the models available to the API key. The API reports
+// only IDs; the rest of each entry comes from the catalog.
+func (a *Adapter) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	ctx, cancel := a.httpConfig.RequestContext(ctx, nil)
+	defer cancel()
+	var page struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := a.getJSON(ctx, "/models", &page); err != nil {
+		return nil, err
+	}
+	models := make([]llm.ModelInfo, 0, len(page.Data))
+	for _, m := range page.Data {
+		models = append(models, llm.ModelInfo{ID: m.ID, Provider: a.compat.Name})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models, nil
+}
@@ -3,11 +3,13 @@ package openai
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/ashka-vakil/attractor/pkg/llm"
 )
@@ -497,6 +499,28 @@ func TestComplete(t *testing.T) {
 	})
 }
 
+func TestConvertFinishReason(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected llm.FinishReason
+	}{
+		{"stop", llm.FinishReasonStop},
+		{"length", llm.FinishReasonLength},
+		{"tool_calls", llm.FinishReasonToolCalls},
+		{"function_call", llm.FinishReasonToolCalls},
+		{"content_filter", llm.FinishReasonContentFilter},
+		{"unknown_reason", llm.FinishReasonStop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := convertFinishReason(tt.input); got != tt.expected {
+				t.Errorf("convertFinishReason(%q) = %s, want %s", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestCompleteError
 // ---------------------------------------------------------------------------
@@ -667,6 +691,9 @@ func TestStream(t *testing.T) {
 		if lastEvent.Usage.TotalTokens != 15 {
 			t.Errorf("expected total_tokens 15, got %d", lastEvent.Usage.TotalTokens)
 		}
+		if got := lastEvent.Headers.Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("expected the response headers on the end event, got Content-Type %q", got)
+		}
 	})
 
 	t.Run("tool call streaming", func(t *testing.T) {
@@ -752,6 +779,65 @@ func TestStream(t *testing.T) {
 		}
 	})
 
+	t.Run("parallel tool call streaming", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			flusher := w.(http.Flusher)
+
+			chunks := []string{
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`,
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`,
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}`,
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{\"path\":\"b.go\"}"}}]}}]}`,
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"a.go\"}"}}]}}]}`,
+				`data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+				`data: [DONE]`,
+			}
+
+			for _, chunk := range chunks {
+				fmt.Fprintf(w, "%s\n\n", chunk)
+				flusher.Flush()
+			}
+		}))
+		defer server.Close()
+
+		adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+		ch, err := adapter.Stream(context.Background(), &llm.Request{
+			Model:    "gpt-4o",
+			Messages: []llm.Message{{Role: llm.RoleUser, Content: "Read both"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		acc := &llm.StreamAccumulator{}
+		var ends []llm.ToolCall
+		for ev := range ch {
+			acc.Process(ev)
+			if ev.Type == llm.StreamEventToolCallEnd {
+				ends = append(ends, *ev.ToolCall)
+			}
+		}
+
+		if len(ends) != 2 {
+			t.Fatalf("expected 2 tool_call_end events, got %d", len(ends))
+		}
+		if ends[0].ID != "call_a" || string(ends[0].Arguments) != `{"path":"a.go"}` {
+			t.Errorf("unexpected first call: %s %s", ends[0].ID, ends[0].Arguments)
+		}
+		if ends[1].ID != "call_b" || string(ends[1].Arguments) != `{"path":"b.go"}` {
+			t.Errorf("unexpected second call: %s %s", ends[1].ID, ends[1].Arguments)
+		}
+
+		resp := acc.Response()
+		if len(resp.ToolCalls) != 2 {
+			t.Fatalf("expected 2 accumulated tool calls, got %d", len(resp.ToolCalls))
+		}
+		if string(resp.ToolCalls[0].Arguments) != `{"path":"a.go"}` || string(resp.ToolCalls[1].Arguments) != `{"path":"b.go"}` {
+			t.Errorf("accumulated arguments merged incorrectly: %s / %s", resp.ToolCalls[0].Arguments, resp.ToolCalls[1].Arguments)
+		}
+	})
+
 	t.Run("DONE sentinel handled", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/event-stream")
@@ -863,6 +949,162 @@ func TestStreamError(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// TestStreamCancellation
+// ---------------------------------------------------------------------------
+
+func TestStreamCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", `data: {"choices":[{"index":0,"delta":{"content":"Hi"}}]}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL))
+	ch, err := adapter.Stream(ctx, &llm.Request{
+		Model:    "gpt-4o",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ev := <-ch; ev.Type != llm.StreamEventDelta {
+		t.Fatalf("expected first delta, got %+v", ev)
+	}
+	cancel()
+
+	var last llm.StreamEvent
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			last = ev
+		case <-timeout:
+			t.Fatal("stream did not close after cancellation")
+		}
+	}
+	if last.Type != llm.StreamEventError || !errors.Is(last.Error, context.Canceled) {
+		t.Errorf("expected terminal context.Canceled error, got %+v", last)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", `data: {"choices":[{"index":0,"delta":{"content":"Hi"}}]}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// The request timeout does not apply to streams; the idle timeout does.
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL), WithRequestTimeout(time.Millisecond), WithStreamIdleTimeout(time.Hour))
+	ch, err := adapter.Stream(context.Background(), &llm.Request{
+		Model:             "gpt-4o",
+		Messages:          []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		StreamIdleTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var events []llm.StreamEvent
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatal("stream did not end after going idle")
+		}
+	}
+	var llmErr *llm.LLMError
+	last := events[len(events)-1]
+	if events[0].Type != llm.StreamEventDelta || last.Type != llm.StreamEventError || !errors.As(last.Error, &llmErr) || llmErr.Type != llm.ErrorTypeTimeout {
+		t.Errorf("expected a delta then a timeout error, got %+v", events)
+	}
+}
+
+func TestStreamHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL), WithStreamIdleTimeout(50*time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		_, err := adapter.Stream(context.Background(), &llm.Request{
+			Model:    "gpt-4o",
+			Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var llmErr *llm.LLMError
+		if !errors.As(err, &llmErr) || llmErr.Type != llm.ErrorTypeTimeout {
+			t.Errorf("expected a timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream waited for headers past the idle timeout")
+	}
+}
+
+func TestCompleteRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	adapter := NewAdapter(WithAPIKey("test-key"), WithBaseURL(server.URL), WithRequestTimeout(time.Hour))
+	start := time.Now()
+	_, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:    "gpt-4o",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		Timeout:  50 * time.Millisecond,
+	})
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("per-request timeout not applied, took %v", elapsed)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestName
 // ---------------------------------------------------------------------------
@@ -952,3 +1194,130 @@ func TestDoRequestSendsHeaders(t *testing.T) {
 		}
 	})
 }
+
+func TestCompleteMergesProviderOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["parallel_tool_calls"] != false || body["model"] != "gpt-4o" {
+			t.Errorf("provider options not merged: %v", body)
+		}
+		if _, ok := body["service_tier"]; ok {
+			t.Errorf("another provider's option leaked into the body: %v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chatResponse{
+			ID:      "chatcmpl-opts",
+			Model:   "gpt-4o",
+			Choices: []chatChoice{{Message: chatMessage{Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	_, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:    "gpt-4o",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		ProviderOptions: map[string]interface{}{
+			"openai.parallel_tool_calls": false,
+			"anthropic.service_tier":     "auto",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCompleteKeepsRawResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req-123")
+		io.WriteString(w, `{"id":"chatcmpl-raw","model":"gpt-4o","system_fingerprint":"fp_1","choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop","logprobs":{"content":[{"token":"Hi","logprob":-0.1}]}}]}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	resp, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:    "gpt-4o",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fp, _ := resp.RawField("system_fingerprint"); fp != "fp_1" {
+		t.Errorf("system_fingerprint = %v", fp)
+	}
+	if token, _ := resp.RawField("choices", "0", "logprobs", "content", "0", "token"); token != "Hi" {
+		t.Errorf("logprobs token = %v", token)
+	}
+	if got := resp.Headers.Get("X-Request-Id"); got != "req-123" {
+		t.Errorf("X-Request-Id header = %q", got)
+	}
+}
+
+func TestCompleteLogprobs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody chatRequest
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		if !reqBody.Logprobs || reqBody.TopLogprobs != 2 {
+			t.Errorf("logprobs = %v, top_logprobs = %d", reqBody.Logprobs, reqBody.TopLogprobs)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-lp","model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"yes"},"finish_reason":"stop","logprobs":{"content":[{"token":"yes","logprob":-0.05,"top_logprobs":[{"token":"yes","logprob":-0.05},{"token":"no","logprob":-3.2}]}]}}]}`)
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	resp, err := adapter.Complete(context.Background(), &llm.Request{
+		Model:       "gpt-4o",
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: "Is it?"}},
+		TopLogprobs: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Logprobs) != 1 || resp.Logprobs[0].Token != "yes" || len(resp.Logprobs[0].TopLogprobs) != 2 {
+		t.Fatalf("Logprobs = %+v", resp.Logprobs)
+	}
+	if alt := resp.Logprobs[0].TopLogprobs[1]; alt.Token != "no" || alt.Logprob != -3.2 {
+		t.Errorf("alternative = %+v", alt)
+	}
+}
+
+func TestStreamLogprobs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody chatRequest
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		if !reqBody.Logprobs || reqBody.TopLogprobs != 0 {
+			t.Errorf("logprobs = %v, top_logprobs = %d", reqBody.Logprobs, reqBody.TopLogprobs)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`data: {"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hello"},"logprobs":{"content":[{"token":"Hello","logprob":-0.1}]}}]}`,
+			`data: {"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"!"},"logprobs":{"content":[{"token":"!","logprob":-0.3}]}}]}`,
+			`data: {"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			`data: [DONE]`,
+		} {
+			fmt.Fprintf(w, "%s\n\n", chunk)
+		}
+	}))
+	defer server.Close()
+
+	adapter := NewAdapter(WithAPIKey("key"), WithBaseURL(server.URL))
+	ch, err := adapter.Stream(context.Background(), &llm.Request{
+		Model:    "gpt-4o",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		Logprobs: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	acc := &llm.StreamAccumulator{}
+	for ev := range ch {
+		acc.Process(ev)
+	}
+	resp := acc.Response()
+	if len(resp.Logprobs) != 2 || resp.Logprobs[1].Token != "!" {
+		t.Errorf("Logprobs = %+v", resp.Logprobs)
+	}
+}
@@ -0,0 +1,31 @@
+// Package xai implements the xAI (Grok) provider adapter for the unified LLM
+// client. xAI's chat completions API follows OpenAI's, so the adapter is an
+// OpenAI adapter pointed at api.x.ai.
+package xai
+
+import (
+	"os"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/provider/openai"
+)
+
+func init() {
+	llm.RegisterProviderFactory("xai", "XAI_API_KEY", func() llm.ProviderAdapter {
+		return NewAdapter()
+	})
+}
+
+// DefaultBaseURL is the xAI API endpoint.
+const DefaultBaseURL = "https://api.x.ai/v1"
+
+// NewAdapter creates an xAI adapter. The API key and base URL default to
+// XAI_API_KEY and XAI_BASE_URL; opts take precedence. Any openai.Option
+// applies.
+func NewAdapter(opts ...openai.Option) *openai.Adapter {
+	defaults := []openai.Option{openai.WithAPIKey(os.Getenv("XAI_API_KEY"))}
+	if url := os.Getenv("XAI_BASE_URL"); url != "" {
+		defaults = append(defaults, openai.WithBaseURL(url))
+	}
+	return openai.NewCompatibleAdapter(openai.Compat{Name: "xai", BaseURL: DefaultBaseURL}, append(defaults, opts...)...)
+}
@@ -0,0 +1,50 @@
+package xai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/llm/provider/openai"
+)
+
+func TestName(t *testing.T) {
+	if got := NewAdapter(openai.WithAPIKey("key")).Name(); got != "xai" {
+		t.Errorf("Name() = %q, want xai", got)
+	}
+}
+
+func TestBaseURLFromEnv(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer env-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["tool_choice"] != "required" {
+			t.Errorf("tool_choice = %v, want required", body["tool_choice"])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"cmpl-1","model":"grok-4","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+	t.Setenv("XAI_API_KEY", "env-key")
+	t.Setenv("XAI_BASE_URL", server.URL)
+
+	resp, err := NewAdapter().Complete(context.Background(), &llm.Request{
+		Model:      "grok-4",
+		Messages:   []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		Tools:      []llm.Tool{{Name: "noop", Parameters: json.RawMessage(`{"type":"object"}`)}},
+		ToolChoice: llm.ToolChoiceRequired,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "ok" {
+		t.Errorf("Content = %q, want ok", resp.Content)
+	}
+}
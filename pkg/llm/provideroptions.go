@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EncodeRequestBody marshals an adapter's request body and merges in the
+// namespaced ProviderOptions for provider. A key "openai.parallel_tool_calls"
+// sets the top-level field parallel_tool_calls of an OpenAI request body, and
+// dots go deeper: "gemini.generationConfig.seed" sets seed inside
+// generationConfig. Object values are merged into existing objects, other
+// values replace what the adapter built, and nil removes the field. This lets
+// callers use new provider features before the adapters know about them.
+//
+// Keys without a dot, such as the adapter settings under "anthropic", and
+// keys of other providers are ignored.
+func EncodeRequestBody(body interface{}, provider string, options map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	prefix := provider + "."
+	var keys []string
+	for k := range options {
+		if strings.HasPrefix(k, prefix) && len(k) > len(prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return data, nil
+	}
+	// Apply shorter paths first so "x.y" refines what "x" set.
+	sort.Slice(keys, func(i, j int) bool {
+		di, dj := strings.Count(keys[i], "."), strings.Count(keys[j], ".")
+		if di != dj {
+			return di < dj
+		}
+		return keys[i] < keys[j]
+	})
+
+	var merged map[string]interface{}
+	if err := decodeJSON(data, &merged); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		value, err := normalizeOption(options[k])
+		if err != nil {
+			return nil, fmt.Errorf("provider option %s: %w", k, err)
+		}
+		if err := setPath(merged, strings.Split(k[len(prefix):], "."), value); err != nil {
+			return nil, fmt.Errorf("provider option %s: %w", k, err)
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// normalizeOption round-trips v through JSON so structs and typed maps merge
+// like the decoded body.
+func normalizeOption(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = decodeJSON(data, &out)
+	return out, err
+}
+
+// decodeJSON decodes data keeping numbers as json.Number, so large integers
+// in the body survive the round trip.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// setPath sets the field at path in obj, creating objects along the way.
+func setPath(obj map[string]interface{}, path []string, value interface{}) error {
+	for _, name := range path[:len(path)-1] {
+		next, ok := obj[name].(map[string]interface{})
+		if !ok {
+			if obj[name] != nil {
+				return fmt.Errorf("%s is not an object", name)
+			}
+			next = make(map[string]interface{})
+			obj[name] = next
+		}
+		obj = next
+	}
+	last := path[len(path)-1]
+	switch v := value.(type) {
+	case nil:
+		delete(obj, last)
+	case map[string]interface{}:
+		existing, ok := obj[last].(map[string]interface{})
+		if !ok {
+			obj[last] = v
+			return nil
+		}
+		mergeObjects(existing, v)
+	default:
+		obj[last] = v
+	}
+	return nil
+}
+
+// mergeObjects merges src into dst recursively.
+func mergeObjects(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sv, srcObj := v.(map[string]interface{})
+		dv, dstObj := dst[k].(map[string]interface{})
+		switch {
+		case srcObj && dstObj:
+			mergeObjects(dv, sv)
+		case v == nil:
+			delete(dst, k)
+		default:
+			dst[k] = v
+		}
+	}
+}
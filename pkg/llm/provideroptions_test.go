@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeRequestBody(t *testing.T) {
+	body := map[string]interface{}{
+		"model":            "gemini-2.5-pro",
+		"temperature":      0.5,
+		"generationConfig": map[string]interface{}{"maxOutputTokens": 1024, "seed": 1},
+		"cachedTokens":     int64(9007199254740993),
+	}
+	data, err := EncodeRequestBody(body, "gemini", map[string]interface{}{
+		"gemini.generationConfig":      map[string]interface{}{"topK": 40},
+		"gemini.generationConfig.seed": 7,
+		"gemini.safetySettings":        []map[string]string{{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}},
+		"gemini.temperature":           nil,
+		"openai.parallel_tool_calls":   false,
+		"gemini":                       map[string]interface{}{"thinking_config": map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"cachedTokens":9007199254740993,"generationConfig":{"maxOutputTokens":1024,"seed":7,"topK":40},"model":"gemini-2.5-pro","safetySettings":[{"category":"HARM_CATEGORY_HARASSMENT","threshold":"BLOCK_NONE"}]}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+
+	// Without options for the provider the body is marshaled as is.
+	data, err = EncodeRequestBody(body, "anthropic", map[string]interface{}{"openai.x": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := json.Marshal(body)
+	if string(data) != string(plain) {
+		t.Errorf("expected the plain body, got %s", data)
+	}
+
+	if _, err := EncodeRequestBody(body, "gemini", map[string]interface{}{"gemini.model.name": "x"}); err == nil {
+		t.Error("expected an error setting a field inside a string")
+	}
+}
@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// DecodeResponse reads a provider's JSON response body into v, the
+// adapter's typed view of it, and also returns the whole body decoded
+// generically, for Response.Raw. Numbers in the raw form are json.Number.
+func DecodeResponse(body io.Reader, v interface{}) (map[string]interface{}, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := decodeJSON(data, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// RawField returns the field at path in the raw provider response, for
+// provider-specific data the unified Response has no place for, such as
+// OpenAI's logprobs or Gemini's safety ratings:
+//
+//	resp.RawField("choices", "0", "logprobs")
+//	resp.RawField("candidates", "0", "safetyRatings")
+//
+// Array elements are addressed by their decimal index. It reports false if
+// the response has no raw form or the path does not exist.
+func (r *Response) RawField(path ...string) (interface{}, bool) {
+	raw, ok := r.Raw.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	var v interface{} = raw
+	for _, name := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if v, ok = node[name]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
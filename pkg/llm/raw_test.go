@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeResponseRawField(t *testing.T) {
+	var typed struct {
+		ID string `json:"id"`
+	}
+	raw, err := DecodeResponse(strings.NewReader(`{"id":"r1","choices":[{"logprobs":{"content":[{"token":"Hi","logprob":-0.25}]}}],"seed":9007199254740993}`), &typed)
+	if err != nil {
+		t.Fatalf("DecodeResponse: %v", err)
+	}
+	if typed.ID != "r1" {
+		t.Errorf("typed ID = %q", typed.ID)
+	}
+	resp := &Response{Raw: raw}
+
+	token, ok := resp.RawField("choices", "0", "logprobs", "content", "0", "token")
+	if !ok || token != "Hi" {
+		t.Errorf("token = %v, %v", token, ok)
+	}
+	if seed, _ := resp.RawField("seed"); seed != json.Number("9007199254740993") {
+		t.Errorf("seed = %v, want the exact integer", seed)
+	}
+	for _, path := range [][]string{{"missing"}, {"choices", "1"}, {"choices", "x"}, {"id", "deeper"}} {
+		if _, ok := resp.RawField(path...); ok {
+			t.Errorf("RawField(%v) found a value", path)
+		}
+	}
+	if _, ok := (&Response{}).RawField("id"); ok {
+		t.Error("RawField on a response without Raw found a value")
+	}
+}
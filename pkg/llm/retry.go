@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"math"
 	"math/rand"
 	"time"
@@ -74,7 +75,8 @@ func PatientRetryConfig() RetryConfig {
 
 // DefaultShouldRetry returns true for retryable errors.
 func DefaultShouldRetry(err error) bool {
-	if llmErr, ok := err.(*LLMError); ok {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
 		return llmErr.IsRetryable()
 	}
 	return false
@@ -93,7 +95,10 @@ func (rc RetryConfig) DelayForAttempt(attempt int) time.Duration {
 	return time.Duration(delay)
 }
 
-// Retry executes fn with retry logic according to the config.
+// Retry executes fn with retry logic according to the config. When an
+// error says when to retry (LLMError.RetryDelay), Retry waits that long
+// instead of backing off; if the wait is longer than MaxDelay it returns the
+// error at once rather than retrying before the limit resets.
 func Retry(ctx context.Context, config RetryConfig, fn func(ctx context.Context) (*Response, error)) (*Response, error) {
 	shouldRetry := config.ShouldRetry
 	if shouldRetry == nil {
@@ -112,12 +117,13 @@ func Retry(ctx context.Context, config RetryConfig, fn func(ctx context.Context)
 			return nil, lastErr
 		}
 
-		// Use Retry-After header if available and within max delay
 		delay := config.DelayForAttempt(attempt)
-		if llmErr, ok := err.(*LLMError); ok && llmErr.RetryAfter > 0 {
-			retryAfter := llmErr.RetryAfter
-			if retryAfter <= config.MaxDelay {
-				delay = retryAfter
+		var llmErr *LLMError
+		if errors.As(err, &llmErr) {
+			if wait := llmErr.RetryDelay(); wait > config.MaxDelay {
+				return nil, lastErr
+			} else if wait > 0 {
+				delay = wait
 			}
 		}
 		select {
@@ -128,3 +134,13 @@ func Retry(ctx context.Context, config RetryConfig, fn func(ctx context.Context)
 	}
 	return nil, lastErr
 }
+
+// RetryMiddleware retries failed requests according to config, using Retry.
+// Streaming requests are not retried.
+func RetryMiddleware(config RetryConfig) Middleware {
+	return func(ctx context.Context, req *Request, next MiddlewareNext) (*Response, error) {
+		return Retry(ctx, config, func(ctx context.Context) (*Response, error) {
+			return next(ctx, req)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryHints(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		header    map[string]string
+		body      string
+		wantAfter time.Duration
+		wantReset time.Time
+	}{
+		{name: "retry-after seconds", header: map[string]string{"Retry-After": "7"}, wantAfter: 7 * time.Second},
+		{name: "retry-after date", header: map[string]string{"Retry-After": "Fri, 02 Jan 2026 03:05:00 GMT"}, wantReset: now.Add(55 * time.Second)},
+		{name: "retry-after-ms wins", header: map[string]string{"retry-after-ms": "1500", "Retry-After": "2"}, wantAfter: 1500 * time.Millisecond},
+		{
+			name: "anthropic exhausted limit",
+			header: map[string]string{
+				"anthropic-ratelimit-requests-remaining": "12",
+				"anthropic-ratelimit-requests-reset":     "2026-01-02T03:09:00Z",
+				"anthropic-ratelimit-tokens-remaining":   "0",
+				"anthropic-ratelimit-tokens-reset":       "2026-01-02T03:04:35Z",
+			},
+			wantReset: now.Add(30 * time.Second),
+		},
+		{
+			name: "openai exhausted limit",
+			header: map[string]string{
+				"x-ratelimit-remaining-requests": "0",
+				"x-ratelimit-reset-requests":     "1m30s",
+				"x-ratelimit-remaining-tokens":   "900",
+				"x-ratelimit-reset-tokens":       "6m0s",
+			},
+			wantReset: now.Add(90 * time.Second),
+		},
+		{
+			name:      "gemini retry info",
+			body:      `{"error": {"code": 429, "details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "33s"}]}}`,
+			wantAfter: 33 * time.Second,
+		},
+		{name: "no hints"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			after, reset := retryHints(h, tt.body, now)
+			if after != tt.wantAfter {
+				t.Errorf("expected retry after %v, got %v", tt.wantAfter, after)
+			}
+			if !reset.Equal(tt.wantReset) {
+				t.Errorf("expected reset at %v, got %v", tt.wantReset, reset)
+			}
+		})
+	}
+}
+
+func TestClassifyHTTPResponse(t *testing.T) {
+	resp := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"3"}}}
+	err := ClassifyHTTPResponse(resp, "slow down", "test")
+	if err.Type != ErrorTypeRateLimit || err.RetryAfter != 3*time.Second {
+		t.Errorf("expected rate limit with 3s retry after, got %+v", err)
+	}
+	if d := err.RetryDelay(); d != 3*time.Second {
+		t.Errorf("expected retry delay 3s, got %v", d)
+	}
+
+	err = &LLMError{RetryAfter: time.Second, ResetAt: time.Now().Add(time.Minute)}
+	if d := err.RetryDelay(); d < 59*time.Second {
+		t.Errorf("expected the later reset to win, got %v", d)
+	}
+}
+
+func TestClassifyContextLength(t *testing.T) {
+	bodies := []string{
+		`{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+		`{"error":{"message":"This model's maximum context length is 128000 tokens.","code":"context_length_exceeded"}}`,
+		`{"error":{"code":400,"message":"The input token count (1200000) exceeds the maximum number of tokens allowed (1048576)."}}`,
+	}
+	for _, body := range bodies {
+		err := ClassifyHTTPError(400, body, "test")
+		if err.Type != ErrorTypeContextLength || err.IsRetryable() {
+			t.Errorf("expected a non-retryable context_length error for %s, got %s", body, err.Type)
+		}
+		if !IsContextLengthError(fmt.Errorf("wrapped: %w", err)) {
+			t.Errorf("IsContextLengthError missed a wrapped error")
+		}
+	}
+	if err := ClassifyHTTPError(400, `{"error":{"message":"invalid model"}}`, "test"); err.Type != ErrorTypeBadRequest {
+		t.Errorf("expected bad_request, got %s", err.Type)
+	}
+}
+
+func TestRetryWaitsForAdvertisedReset(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 3, InitialDelay: time.Hour, BackoffFactor: 1, MaxDelay: time.Second}
+	calls := 0
+	start := time.Now()
+	resp, err := Retry(context.Background(), config, func(ctx context.Context) (*Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, fmt.Errorf("wrapped: %w", &LLMError{Type: ErrorTypeRateLimit, RetryAfter: 20 * time.Millisecond})
+		}
+		return &Response{Content: "ok"}, nil
+	})
+	if err != nil || resp.Content != "ok" {
+		t.Fatalf("expected success, got %v, %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected to wait the advertised 20ms instead of backing off, waited %v", elapsed)
+	}
+}
+
+func TestRetryGivesUpWhenResetExceedsMaxDelay(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, BackoffFactor: 1, MaxDelay: time.Second}
+	calls := 0
+	_, err := Retry(context.Background(), config, func(ctx context.Context) (*Response, error) {
+		calls++
+		return nil, &LLMError{Type: ErrorTypeRateLimit, ResetAt: time.Now().Add(time.Hour)}
+	})
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) {
+		t.Fatalf("expected the rate limit error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected no retry before the reset, got %d calls", calls)
+	}
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	calls := 0
+	adapter := &mockAdapter{name: "test", response: &Response{Content: "ok"}}
+	flaky := func(ctx context.Context, req *Request, next MiddlewareNext) (*Response, error) {
+		calls++
+		if calls < 3 {
+			return nil, &LLMError{Type: ErrorTypeServer, RetryAfter: time.Millisecond}
+		}
+		return next(ctx, req)
+	}
+	config := DefaultRetryConfig()
+	client := NewClient(WithProvider("test", adapter), WithMiddleware(RetryMiddleware(config), flaky))
+	resp, err := client.Complete(context.Background(), &Request{Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	if err != nil || resp.Content != "ok" {
+		t.Fatalf("expected success after retries, got %v, %v", resp, err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+}
@@ -0,0 +1,46 @@
+package llm
+
+import "context"
+
+// StreamSender delivers events from a provider's stream goroutine to the
+// caller, giving up as soon as the request context is cancelled so the
+// goroutine never blocks on a consumer that has stopped reading.
+type StreamSender struct {
+	ctx context.Context
+	ch  chan<- StreamEvent
+}
+
+// NewStreamSender creates a sender for ch bound to ctx.
+func NewStreamSender(ctx context.Context, ch chan<- StreamEvent) *StreamSender {
+	return &StreamSender{ctx: ctx, ch: ch}
+}
+
+// Send delivers ev. It returns false if the context was cancelled first, in
+// which case the caller should stop reading and return.
+func (s *StreamSender) Send(ev StreamEvent) bool {
+	if s.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case s.ch <- ev:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// Finish emits the terminal error event, if any. A cancelled context takes
+// precedence over err (which is then usually a read on a closed body) and is
+// delivered without blocking, since the consumer may already be gone.
+func (s *StreamSender) Finish(err error) {
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		select {
+		case s.ch <- StreamEvent{Type: StreamEventError, Error: ctxErr}:
+		default:
+		}
+		return
+	}
+	if err != nil {
+		s.ch <- StreamEvent{Type: StreamEventError, Error: err}
+	}
+}
@@ -0,0 +1,35 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStreamSenderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan StreamEvent, 1)
+	s := NewStreamSender(ctx, ch)
+
+	if !s.Send(StreamEvent{Type: StreamEventDelta}) {
+		t.Fatal("expected send to succeed before cancellation")
+	}
+	<-ch
+	cancel()
+	if s.Send(StreamEvent{Type: StreamEventDelta}) {
+		t.Error("expected send to fail after cancellation")
+	}
+
+	s.Finish(errors.New("read on closed body"))
+	ev := <-ch
+	if ev.Type != StreamEventError || !errors.Is(ev.Error, context.Canceled) {
+		t.Errorf("expected context.Canceled error event, got %+v", ev)
+	}
+}
+
+func TestStreamSenderFinishDoesNotBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ch := make(chan StreamEvent) // nobody reading
+	NewStreamSender(ctx, ch).Finish(nil)
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// TokenCounter is implemented by provider adapters that can count the input
+// tokens of a request without running it.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, req *Request) (int, error)
+}
+
+// TokenCount is the number of input tokens a request would use.
+type TokenCount struct {
+	InputTokens int `json:"input_tokens"`
+	// Estimated is true when the count is EstimateTokens' approximation
+	// rather than the provider's.
+	Estimated bool `json:"estimated"`
+	// Err is why the provider could not count, if it was asked.
+	Err error `json:"-"`
+}
+
+// CountTokens returns the number of input tokens req would use. Providers
+// whose adapter is a TokenCounter are asked; for the others, and when the
+// provider's count fails, the tokens are estimated locally. It fails only if
+// no provider can be resolved for req.
+func (c *Client) CountTokens(ctx context.Context, req *Request) (TokenCount, error) {
+	adapter, err := c.resolveProvider(req)
+	if err != nil {
+		return TokenCount{}, err
+	}
+	var count TokenCount
+	if counter, ok := adapter.(TokenCounter); ok {
+		n, err := counter.CountTokens(ctx, req)
+		if err == nil {
+			return TokenCount{InputTokens: n}, nil
+		}
+		count.Err = err
+	}
+	count.InputTokens = EstimateTokens(req)
+	count.Estimated = true
+	return count, nil
+}
+
+// estimatedCharsPerToken is the usual ratio of English text and code in
+// current tokenizers.
+const estimatedCharsPerToken = 4
+
+// EstimateTokens approximates the input tokens of req from the size of its
+// system prompt, messages, tool calls, and tool definitions. It is meant for
+// guardrails, not billing: expect it to be off by 10-20% either way.
+func EstimateTokens(req *Request) int {
+	chars := len(req.SystemPrompt)
+	tokens := 0
+	for _, m := range req.Messages {
+		tokens += 4 // role and message framing
+		chars += len(m.Content)
+		for _, p := range m.Parts {
+			switch p.Type {
+			case ContentPartImage:
+				tokens += 1000 // providers charge roughly this for a typical image
+			default:
+				chars += len(p.Text)
+			}
+		}
+		for _, tc := range m.ToolCalls {
+			chars += len(tc.Name) + len(tc.Arguments)
+		}
+	}
+	for _, t := range req.Tools {
+		chars += len(t.Name) + len(t.Description) + len(t.Parameters)
+	}
+	if req.ResponseFormat != nil {
+		if data, err := json.Marshal(req.ResponseFormat); err == nil {
+			chars += len(data)
+		}
+	}
+	return tokens + (chars+estimatedCharsPerToken-1)/estimatedCharsPerToken
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tokenCountingAdapter is a mockAdapter that can count tokens.
+type tokenCountingAdapter struct {
+	mockAdapter
+	tokens int
+	err    error
+}
+
+func (a *tokenCountingAdapter) CountTokens(ctx context.Context, req *Request) (int, error) {
+	return a.tokens, a.err
+}
+
+func TestClientCountTokens(t *testing.T) {
+	req := &Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: strings.Repeat("a", 400)}}}
+	estimate := EstimateTokens(req)
+
+	tests := []struct {
+		name          string
+		adapter       ProviderAdapter
+		want          int
+		wantEstimated bool
+		wantErr       bool
+	}{
+		{"provider count", &tokenCountingAdapter{tokens: 123}, 123, false, false},
+		{"provider failure", &tokenCountingAdapter{err: errors.New("unauthorized")}, estimate, true, true},
+		{"no counter", &mockAdapter{}, estimate, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(WithProvider("p", tt.adapter))
+			count, err := client.CountTokens(context.Background(), req)
+			if err != nil {
+				t.Fatalf("CountTokens: %v", err)
+			}
+			if count.InputTokens != tt.want || count.Estimated != tt.wantEstimated || (count.Err != nil) != tt.wantErr {
+				t.Errorf("count = %+v, want %d tokens, estimated %v", count, tt.want, tt.wantEstimated)
+			}
+		})
+	}
+
+	if _, err := NewClient().CountTokens(context.Background(), req); err == nil {
+		t.Error("expected an error without providers")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	req := &Request{
+		SystemPrompt: strings.Repeat("s", 40),
+		Messages: []Message{
+			{Role: RoleUser, Content: strings.Repeat("u", 40)},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{Name: "read", Arguments: json.RawMessage(`{"path":"a.go"}`)}}},
+		},
+		Tools: []Tool{{Name: "read", Description: "Read a file", Parameters: json.RawMessage(`{"type":"object"}`)}},
+	}
+	// 40 + 40 + 4+15 + 4+11+17 = 131 chars -> 33 tokens, plus 4 per message.
+	if got := EstimateTokens(req); got != 41 {
+		t.Errorf("EstimateTokens = %d, want 41", got)
+	}
+}
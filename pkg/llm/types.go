@@ -4,6 +4,7 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 	"time"
 )
 
@@ -26,6 +27,9 @@ const (
 	FinishReasonLength    FinishReason = "length"
 	FinishReasonToolCalls FinishReason = "tool_calls"
 	FinishReasonError     FinishReason = "error"
+	// FinishReasonContentFilter means the provider's safety system stopped
+	// or withheld the output, which may be cut short or empty.
+	FinishReasonContentFilter FinishReason = "content_filter"
 )
 
 // ContentPartType identifies the type of content in a message.
@@ -115,7 +119,25 @@ type Request struct {
 	SystemPrompt    string              `json:"system_prompt,omitempty"`
 	ReasoningEffort string              `json:"reasoning_effort,omitempty"`
 	ResponseFormat  *ResponseFormat     `json:"response_format,omitempty"`
+	// Logprobs asks for the log probability of each output token, and
+	// TopLogprobs (0-20) for that many likeliest alternatives at each
+	// position; TopLogprobs implies Logprobs. OpenAI-compatible providers
+	// only.
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs int  `json:"top_logprobs,omitempty"`
+	// ProviderOptions holds adapter settings under a provider's name (e.g.
+	// "anthropic": {"max_output_tokens": ...}) and, under namespaced keys
+	// such as "openai.parallel_tool_calls", fields merged into the outgoing
+	// request body; see EncodeRequestBody.
 	ProviderOptions map[string]interface{} `json:"provider_options,omitempty"`
+	// Timeout, if set, overrides the adapter's deadline for a non-streaming
+	// call; StreamIdleTimeout overrides how long a stream may go without
+	// data. Neither is serialized.
+	Timeout           time.Duration `json:"-"`
+	StreamIdleTimeout time.Duration `json:"-"`
+	// Headers are extra HTTP headers sent with the request, after the
+	// adapter's own. They are not serialized, so they stay out of debug logs.
+	Headers map[string]string `json:"-"`
 }
 
 // ResponseFormat controls the output format from the model.
@@ -135,9 +157,12 @@ type Response struct {
 	Usage        Usage        `json:"usage"`
 	Reasoning    string       `json:"reasoning,omitempty"`
 	CreatedAt    time.Time      `json:"created_at"`
-	Raw          interface{}    `json:"-"`                          // Preserved raw provider response
+	Raw          interface{}    `json:"-"`                          // Provider response body as map[string]interface{}; see RawField. Nil for streamed responses
+	Headers      http.Header    `json:"-"`                          // Provider HTTP response headers
 	Warnings     []Warning      `json:"warnings,omitempty"`        // Non-fatal issues
 	RateLimit    *RateLimitInfo `json:"rate_limit,omitempty"`      // Rate limit metadata
+	Logprobs     []TokenLogprob `json:"logprobs,omitempty"`        // Per-token probabilities, if requested
+	Cached       bool           `json:"cached,omitempty"`          // Served by CacheMiddleware; Usage is zero
 }
 
 // Warning is a non-fatal issue from a response.
@@ -196,10 +221,12 @@ func (u Usage) Add(other Usage) Usage {
 // StreamAccumulator accumulates stream events into a complete Response.
 type StreamAccumulator struct {
 	content      string
+	logprobs     []TokenLogprob
 	reasoning    string
 	toolCalls    []ToolCall
 	finishReason FinishReason
 	usage        *Usage
+	headers      http.Header
 	model        string
 	id           string
 }
@@ -209,6 +236,7 @@ func (a *StreamAccumulator) Process(event StreamEvent) {
 	switch event.Type {
 	case StreamEventDelta:
 		a.content += event.Delta
+		a.logprobs = append(a.logprobs, event.Logprobs...)
 	case StreamEventReasoningDelta:
 		a.reasoning += event.Delta
 	case StreamEventToolCallStart:
@@ -216,16 +244,46 @@ func (a *StreamAccumulator) Process(event StreamEvent) {
 			a.toolCalls = append(a.toolCalls, *event.ToolCall)
 		}
 	case StreamEventToolCallDelta:
-		if len(a.toolCalls) > 0 && event.Delta != "" {
+		if event.Delta == "" {
+			break
+		}
+		if tc := a.findToolCall(event.ToolCall); tc != nil {
+			tc.Arguments = append(tc.Arguments, []byte(event.Delta)...)
+		} else if len(a.toolCalls) > 0 {
 			last := &a.toolCalls[len(a.toolCalls)-1]
 			last.Arguments = append(last.Arguments, []byte(event.Delta)...)
 		}
+	case StreamEventToolCallEnd:
+		if event.ToolCall == nil {
+			break
+		}
+		if tc := a.findToolCall(event.ToolCall); tc != nil {
+			*tc = *event.ToolCall
+		} else if event.ToolCall.ID != "" {
+			a.toolCalls = append(a.toolCalls, *event.ToolCall)
+		}
 	case StreamEventEnd:
 		a.finishReason = event.FinishReason
 		if event.Usage != nil {
 			a.usage = event.Usage
 		}
+		if event.Headers != nil {
+			a.headers = event.Headers
+		}
+	}
+}
+
+// findToolCall returns the accumulated tool call with the same ID as ref, if any.
+func (a *StreamAccumulator) findToolCall(ref *ToolCall) *ToolCall {
+	if ref == nil || ref.ID == "" {
+		return nil
+	}
+	for i := range a.toolCalls {
+		if a.toolCalls[i].ID == ref.ID {
+			return &a.toolCalls[i]
+		}
 	}
+	return nil
 }
 
 // Response returns the accumulated response.
@@ -237,6 +295,8 @@ func (a *StreamAccumulator) Response() *Response {
 		Reasoning:    a.reasoning,
 		ToolCalls:    a.toolCalls,
 		FinishReason: a.finishReason,
+		Logprobs:     a.logprobs,
+		Headers:      a.headers,
 		CreatedAt:    time.Now(),
 	}
 	if a.usage != nil {
@@ -267,6 +327,8 @@ type StreamEvent struct {
 	FinishReason FinishReason    `json:"finish_reason,omitempty"`
 	Usage        *Usage          `json:"usage,omitempty"`
 	Response     *Response       `json:"response,omitempty"`
+	Logprobs     []TokenLogprob  `json:"logprobs,omitempty"` // of a delta's tokens
+	Headers      http.Header     `json:"-"`                  // provider HTTP response headers, on the end event
 	Error        error           `json:"-"`
 }
 
@@ -2,6 +2,8 @@ package llm
 
 import (
 	"encoding/json"
+	"math"
+	"net/http"
 	"testing"
 )
 
@@ -38,9 +40,13 @@ func TestStreamAccumulator(t *testing.T) {
 		Type:         StreamEventEnd,
 		FinishReason: FinishReasonStop,
 		Usage:        &Usage{InputTokens: 10, OutputTokens: 5, TotalTokens: 15},
+		Headers:      http.Header{"X-Request-Id": {"req_1"}},
 	})
 
 	resp := acc.Response()
+	if resp.Headers.Get("X-Request-Id") != "req_1" {
+		t.Errorf("expected the end event's headers, got %v", resp.Headers)
+	}
 	if resp.Content != "Hello, world!" {
 		t.Errorf("expected 'Hello, world!', got %q", resp.Content)
 	}
@@ -94,6 +100,26 @@ func TestStreamAccumulatorWithReasoning(t *testing.T) {
 	}
 }
 
+func TestStreamAccumulatorWithLogprobs(t *testing.T) {
+	acc := &StreamAccumulator{}
+
+	acc.Process(StreamEvent{Type: StreamEventDelta, Delta: "Yes", Logprobs: []TokenLogprob{{Token: "Yes", Logprob: -0.2}}})
+	acc.Process(StreamEvent{Type: StreamEventDelta, Delta: ".", Logprobs: []TokenLogprob{{Token: ".", Logprob: 0}}})
+	acc.Process(StreamEvent{Type: StreamEventEnd, FinishReason: FinishReasonStop})
+
+	resp := acc.Response()
+	if len(resp.Logprobs) != 2 || resp.Logprobs[0].Token != "Yes" {
+		t.Fatalf("expected two logprobs, got %+v", resp.Logprobs)
+	}
+	confidence, ok := resp.Confidence()
+	if !ok || math.Abs(confidence-math.Exp(-0.1)) > 1e-9 {
+		t.Errorf("Confidence() = %v, %v; want %v", confidence, ok, math.Exp(-0.1))
+	}
+	if _, ok := (&Response{}).Confidence(); ok {
+		t.Error("expected no confidence without logprobs")
+	}
+}
+
 func TestRoleConstants(t *testing.T) {
 	roles := []Role{RoleSystem, RoleUser, RoleAssistant, RoleTool, RoleDeveloper}
 	expected := []string{"system", "user", "assistant", "tool", "developer"}
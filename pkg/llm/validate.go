@@ -0,0 +1,59 @@
+package llm
+
+import "fmt"
+
+// Validate checks provider-independent constraints on a request so malformed
+// conversations fail fast with a descriptive error instead of a provider 400.
+// It returns an *LLMError of type ErrorTypeBadRequest.
+func (r *Request) Validate() error {
+	if len(r.Messages) == 0 {
+		return r.invalid("messages must not be empty")
+	}
+	if r.Temperature != nil && (*r.Temperature < 0 || *r.Temperature > 2) {
+		return r.invalid(fmt.Sprintf("temperature %g is out of range [0, 2]", *r.Temperature))
+	}
+	if r.TopP != nil && (*r.TopP < 0 || *r.TopP > 1) {
+		return r.invalid(fmt.Sprintf("top_p %g is out of range [0, 1]", *r.TopP))
+	}
+	if r.MaxTokens < 0 {
+		return r.invalid(fmt.Sprintf("max_tokens %d must not be negative", r.MaxTokens))
+	}
+	if r.TopLogprobs < 0 || r.TopLogprobs > 20 {
+		return r.invalid(fmt.Sprintf("top_logprobs %d is out of range [0, 20]", r.TopLogprobs))
+	}
+
+	// pending holds tool call IDs from the most recent assistant message that
+	// have not yet received a result.
+	pending := map[string]bool{}
+	for i, m := range r.Messages {
+		switch m.Role {
+		case RoleAssistant:
+			pending = map[string]bool{}
+			for _, tc := range m.ToolCalls {
+				pending[tc.ID] = true
+			}
+		case RoleTool:
+			if m.ToolCallID == "" {
+				return r.invalid(fmt.Sprintf("message %d: tool message has no tool_call_id", i))
+			}
+			if !pending[m.ToolCallID] {
+				return r.invalid(fmt.Sprintf("message %d: tool result %q does not follow an assistant message with a matching tool call", i, m.ToolCallID))
+			}
+			delete(pending, m.ToolCallID)
+		case RoleUser:
+			pending = map[string]bool{}
+		case RoleSystem, RoleDeveloper:
+		default:
+			return r.invalid(fmt.Sprintf("message %d: unknown role %q", i, m.Role))
+		}
+	}
+	return nil
+}
+
+func (r *Request) invalid(msg string) error {
+	provider := r.Provider
+	if provider == "" {
+		provider = "llm"
+	}
+	return &LLMError{Type: ErrorTypeBadRequest, Message: msg, Provider: provider}
+}
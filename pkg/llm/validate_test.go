@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRequestValidate(t *testing.T) {
+	hot := 2.5
+	badTopP := 1.5
+	user := Message{Role: RoleUser, Content: "Hi"}
+	assistant := Message{Role: RoleAssistant, ToolCalls: []ToolCall{
+		{ID: "call-1", Name: "read_file", Arguments: json.RawMessage(`{}`)},
+		{ID: "call-2", Name: "read_file", Arguments: json.RawMessage(`{}`)},
+	}}
+
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr bool
+	}{
+		{name: "valid", req: Request{Messages: []Message{user}}},
+		{name: "empty messages", req: Request{}, wantErr: true},
+		{name: "temperature out of range", req: Request{Messages: []Message{user}, Temperature: &hot}, wantErr: true},
+		{name: "top_p out of range", req: Request{Messages: []Message{user}, TopP: &badTopP}, wantErr: true},
+		{name: "negative max_tokens", req: Request{Messages: []Message{user}, MaxTokens: -1}, wantErr: true},
+		{name: "top_logprobs out of range", req: Request{Messages: []Message{user}, TopLogprobs: 21}, wantErr: true},
+		{
+			name: "tool results follow assistant",
+			req: Request{Messages: []Message{user, assistant,
+				{Role: RoleTool, ToolCallID: "call-2", Content: "b"},
+				{Role: RoleTool, ToolCallID: "call-1", Content: "a"},
+			}},
+		},
+		{
+			name:    "tool result without assistant",
+			req:     Request{Messages: []Message{user, {Role: RoleTool, ToolCallID: "call-1"}}},
+			wantErr: true,
+		},
+		{
+			name:    "tool result with unknown id",
+			req:     Request{Messages: []Message{user, assistant, {Role: RoleTool, ToolCallID: "call-9"}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate tool result",
+			req: Request{Messages: []Message{user, assistant,
+				{Role: RoleTool, ToolCallID: "call-1"},
+				{Role: RoleTool, ToolCallID: "call-1"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "tool result missing id",
+			req:     Request{Messages: []Message{user, assistant, {Role: RoleTool}}},
+			wantErr: true,
+		},
+		{name: "unknown role", req: Request{Messages: []Message{{Role: "narrator"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var llmErr *LLMError
+			if !errors.As(err, &llmErr) || llmErr.Type != ErrorTypeBadRequest {
+				t.Fatalf("expected bad_request LLMError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClientValidatesBeforeProvider(t *testing.T) {
+	adapter := &mockAdapter{name: "test", response: &Response{Content: "ok"}}
+	client := NewClient(WithProvider("test", adapter))
+
+	if _, err := client.Complete(context.Background(), &Request{Model: "m"}); err == nil {
+		t.Error("expected validation error from Complete")
+	}
+	if _, err := client.Stream(context.Background(), &Request{Model: "m"}); err == nil {
+		t.Error("expected validation error from Stream")
+	}
+}
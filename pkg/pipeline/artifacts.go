@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Artifact is a file in a stage's logs directory.
+type Artifact struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ListArtifacts lists the files under dir, by slash-separated path relative
+// to it.
+func ListArtifacts(dir string) ([]Artifact, error) {
+	var artifacts []Artifact
+	err := fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, Artifact{Name: path, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Name < artifacts[j].Name })
+	return artifacts, err
+}
+
+// WriteZip writes the files under dir to w as a zip archive.
+func WriteZip(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+	if err := zw.AddFS(os.DirFS(dir)); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// runLogsDir returns the request's run and its logs directory, or writes an
+// error response and returns nil.
+func (s *Server) runLogsDir(w http.ResponseWriter, r *http.Request) (*pipelineRun, string) {
+	run, ok := s.lookup(r)
+	if !ok {
+		http.Error(w, "pipeline not found", http.StatusNotFound)
+		return nil, ""
+	}
+	if run.logsDir == "" {
+		http.Error(w, "no logs: the server has no logs directory", http.StatusNotFound)
+		return nil, ""
+	}
+	return run, run.logsDir
+}
+
+// stageDir returns the logs directory of the request's {node}, or writes an
+// error response and returns "". Only nodes of the run's graph whose IDs
+// are single path elements are served, so the node ID can't name a path
+// outside the run.
+func (s *Server) stageDir(w http.ResponseWriter, r *http.Request) string {
+	run, dir := s.runLogsDir(w, r)
+	if run == nil {
+		return ""
+	}
+	node := r.PathValue("node")
+	if _, ok := run.Graph.Nodes[node]; !ok || !localNodeID(node) {
+		http.Error(w, fmt.Sprintf("no node %q in pipeline", node), http.StatusNotFound)
+		return ""
+	}
+	dir = StageLogDir(dir, node)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		http.Error(w, fmt.Sprintf("stage %q has no artifacts", node), http.StatusNotFound)
+		return ""
+	}
+	return dir
+}
+
+// handleGetLogs serves the run's logs directory as a zip archive, or with
+// ?format=json, its RunLog summary.
+func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
+	run, dir := s.runLogsDir(w, r)
+	if run == nil {
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		log, err := LoadRunLog(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(log)
+		return
+	}
+	serveZip(w, dir, run.ID+".zip")
+}
+
+// handleGetArtifacts serves a stage's files as a zip archive, or with
+// ?format=json, a list of them.
+func (s *Server) handleGetArtifacts(w http.ResponseWriter, r *http.Request) {
+	dir := s.stageDir(w, r)
+	if dir == "" {
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		artifacts, err := ListArtifacts(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(artifacts)
+		return
+	}
+	serveZip(w, dir, r.PathValue("id")+"-"+r.PathValue("node")+".zip")
+}
+
+// handleGetArtifact serves one file of a stage, such as prompt.md,
+// response.md, or status.json.
+func (s *Server) handleGetArtifact(w http.ResponseWriter, r *http.Request) {
+	dir := s.stageDir(w, r)
+	if dir == "" {
+		return
+	}
+	// os.Root refuses paths that leave the stage directory.
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer root.Close()
+	name := r.PathValue("file")
+	f, err := root.Open(filepath.FromSlash(name))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("artifact %q not found", name), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.Error(w, fmt.Sprintf("artifact %q not found", name), http.StatusNotFound)
+		return
+	}
+	switch filepath.Ext(name) {
+	case ".md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	case ".json":
+		w.Header().Set("Content-Type", "application/json")
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+func serveZip(w http.ResponseWriter, dir, filename string) {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "no logs yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	// The status is sent with the first write, so a failure part way
+	// through can only truncate the archive.
+	WriteZip(w, dir)
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// APIKey is a credential accepted by the pipeline server. Runs created with
+// a key belong to its namespace: they are visible only to keys of the same
+// namespace and their logs are written under <logs root>/<namespace>.
+type APIKey struct {
+	Key       string `json:"key"`
+	Namespace string `json:"namespace"`
+	// MaxRuns is the most runs the key may have running at once; 0 means
+	// no limit.
+	MaxRuns int `json:"max_runs,omitempty"`
+}
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Validate checks that the key is set and its namespace is usable as a
+// directory name.
+func (k APIKey) Validate() error {
+	if k.Key == "" {
+		return fmt.Errorf("empty key")
+	}
+	if !namespacePattern.MatchString(k.Namespace) {
+		return fmt.Errorf("invalid namespace %q: use letters, digits, '_', '-', and '.'", k.Namespace)
+	}
+	if k.MaxRuns < 0 {
+		return fmt.Errorf("namespace %q: max_runs must not be negative", k.Namespace)
+	}
+	return nil
+}
+
+// LoadAPIKeys reads a JSON array of API keys from path.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, k := range keys {
+		if err := k.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: key %d: %w", path, i+1, err)
+		}
+		if seen[k.Key] {
+			return nil, fmt.Errorf("%s: key %d: duplicate key", path, i+1)
+		}
+		seen[k.Key] = true
+	}
+	return keys, nil
+}
+
+type apiKeyContextKey struct{}
+
+// authenticate rejects requests without a known API key, given as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>", and passes the key to
+// next in the request context. It is a no-op if the server has no keys.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if len(s.keys) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			scheme, value, _ := strings.Cut(auth, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unsupported authorization scheme", http.StatusUnauthorized)
+				return
+			}
+			token = strings.TrimSpace(value)
+		}
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing API key", http.StatusUnauthorized)
+			return
+		}
+		key, ok := s.lookupKey(token)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// lookupKey finds token among the server's keys, comparing every key in
+// constant time.
+func (s *Server) lookupKey(token string) (APIKey, bool) {
+	var found APIKey
+	ok := false
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(token)) == 1 {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
+
+// requestKey returns the API key of an authenticated request, or the zero
+// key, whose namespace is "", if the server has no keys.
+func requestKey(r *http.Request) APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(APIKey)
+	return key
+}
@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// StageCache records the outcomes of successful codergen stages so that a
+// later run can skip a stage whose inputs are unchanged. A stage's inputs
+// are its resolved prompt, model settings, attributes, and the context it
+// starts with, and the backend that runs it; see StageCache.Key.
+//
+// Only codergen stages are cached. A node opts out with cache=false.
+type StageCache struct {
+	dir     string
+	backend string
+}
+
+// NewStageCache returns a cache that stores outcomes as JSON files in dir.
+// backend identifies the codergen backend and its default provider and
+// model, e.g. "llm/anthropic/claude-sonnet-4-5"; it is part of every key, so
+// outcomes produced by one backend are never replayed under another. A
+// cache with no backend caches nothing, so the outcomes of simulated stages
+// (which have no backend) are never stored.
+func NewStageCache(dir, backend string) *StageCache {
+	return &StageCache{dir: dir, backend: backend}
+}
+
+// DefaultStageCacheDir returns the directory the CLI caches stage outcomes
+// in, under the user's cache directory.
+func DefaultStageCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "attractor", "stages")
+	}
+	return filepath.Join(dir, "attractor", "stages")
+}
+
+// Cacheable reports whether outcomes of node may be cached.
+func (c *StageCache) Cacheable(node *Node) bool {
+	return c != nil && c.backend != "" && node.HandlerType() == "codergen" && node.Attrs["cache"] != "false"
+}
+
+// stageCacheInputs is what a stage cache key is the hash of.
+type stageCacheInputs struct {
+	Backend         string                 `json:"backend"`
+	Node            string                 `json:"node"`
+	Prompt          string                 `json:"prompt"`
+	LLMModel        string                 `json:"llm_model,omitempty"`
+	LLMProvider     string                 `json:"llm_provider,omitempty"`
+	ReasoningEffort string                 `json:"reasoning_effort,omitempty"`
+	Attrs           map[string]string      `json:"attrs,omitempty"`
+	Context         map[string]interface{} `json:"context"`
+}
+
+// Key returns the cache key of running node with ctx: the SHA-256 of the
+// backend, the resolved prompt, the model settings and attributes, and the
+// context values. It returns "" if the context can't be encoded.
+func (c *StageCache) Key(node *Node, ctx *Context, graph *Graph) string {
+	prompt := node.Prompt
+	if prompt == "" {
+		prompt = node.Label
+	}
+	data, err := json.Marshal(stageCacheInputs{
+		Backend:         c.backend,
+		Node:            node.ID,
+		Prompt:          strings.ReplaceAll(prompt, "$goal", graph.Goal),
+		LLMModel:        node.LLMModel,
+		LLMProvider:     node.LLMProvider,
+		ReasoningEffort: node.ReasoningEffort,
+		Attrs:           node.Attrs,
+		Context:         ctx.Snapshot(),
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the outcome recorded under key, decrypting it with cipher if
+// it was stored encrypted.
+func (c *StageCache) Get(key string, cipher *secrets.Cipher) (*Outcome, bool) {
+	data, err := readFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	if data, err = cipher.Open(data); err != nil {
+		return nil, false
+	}
+	var outcome Outcome
+	if json.Unmarshal(data, &outcome) != nil {
+		return nil, false
+	}
+	return &outcome, true
+}
+
+// Put records outcome under key, encrypted with cipher if it is set, as
+// checkpoints are. Only successful outcomes are recorded.
+func (c *StageCache) Put(key string, outcome *Outcome, cipher *secrets.Cipher) error {
+	if outcome.Status != StatusSuccess && outcome.Status != StatusPartialSuccess {
+		return nil
+	}
+	data, err := json.MarshalIndent(outcome, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = cipher.Seal(data); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(key), data)
+}
+
+func (c *StageCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// countingHandler counts executions per node and reports token usage.
+type countingHandler struct {
+	calls map[string]int
+}
+
+func (h *countingHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	h.calls[node.ID]++
+	return &Outcome{
+		Status:         StatusSuccess,
+		ContextUpdates: map[string]interface{}{node.ID + ".done": true},
+		Usage:          &Usage{TotalTokens: 100},
+	}, nil
+}
+
+func TestStageCache(t *testing.T) {
+	const source = `digraph G {
+		goal = "ship it"
+		start [shape=Mdiamond]
+		plan  [prompt="Plan: $goal"]
+		build [prompt="Build", cache=false]
+		done  [shape=Msquare]
+		start -> plan -> build -> done
+	}`
+	cache := NewStageCache(t.TempDir(), "test")
+	handler := &countingHandler{calls: make(map[string]int)}
+	run := func(source string) *RunResult {
+		t.Helper()
+		graph, err := Parse(source)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		logsRoot := t.TempDir()
+		engine := NewEngine(EngineConfig{LogsRoot: logsRoot, Cache: cache}, &staticResolver{handler: handler}, nil)
+		result, err := engine.Run(graph)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		return result
+	}
+
+	first := run(source)
+	second := run(source)
+	if handler.calls["plan"] != 1 {
+		t.Errorf("expected plan to run once, got %d", handler.calls["plan"])
+	}
+	if handler.calls["build"] != 2 {
+		t.Errorf("expected the cache=false stage to run every time, got %d", handler.calls["build"])
+	}
+	if second.Usage.TotalTokens >= first.Usage.TotalTokens {
+		t.Errorf("expected a cached stage to use no tokens, got %d then %d", first.Usage.TotalTokens, second.Usage.TotalTokens)
+	}
+	if o := second.NodeOutcomes["plan"]; o == nil || !strings.HasPrefix(o.Notes, "cached") || o.ContextUpdates["plan.done"] != true {
+		t.Errorf("expected the recorded outcome to be reused, got %+v", o)
+	}
+
+	run(strings.Replace(source, "ship it", "ship it today", 1))
+	if handler.calls["plan"] != 2 {
+		t.Errorf("expected a changed prompt to miss the cache, got %d runs", handler.calls["plan"])
+	}
+}
+
+func TestStageCacheWritesStatus(t *testing.T) {
+	cache := NewStageCache(t.TempDir(), "test")
+	node := &Node{ID: "plan", Shape: "box", Prompt: "Plan"}
+	graph := &Graph{Nodes: map[string]*Node{"plan": node}}
+	key := cache.Key(node, NewContext(), graph)
+	if err := cache.Put(key, &Outcome{Status: StatusFail}, nil); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := cache.Get(key, nil); ok {
+		t.Fatal("expected a failed outcome not to be cached")
+	}
+	cache.Put(key, &Outcome{Status: StatusSuccess, Notes: "planned"}, nil)
+
+	logsRoot := t.TempDir()
+	engine := NewEngine(EngineConfig{LogsRoot: logsRoot, Cache: cache}, &staticResolver{handler: &failHandler{}}, nil)
+	outcome, err := engine.executeWithRetry(node, NewContext(), graph, RetryPolicy{MaxAttempts: 1}, 0, logsRoot)
+	if err != nil {
+		t.Fatalf("executeWithRetry: %v", err)
+	}
+	if outcome.Status != StatusSuccess || outcome.Notes != "cached: planned" {
+		t.Errorf("expected the cached outcome, got %+v", outcome)
+	}
+	if _, err := os.Stat(filepath.Join(logsRoot, "plan", "status.json")); err != nil {
+		t.Errorf("expected status.json for the cached stage: %v", err)
+	}
+}
+
+func TestStageCacheBackendIdentity(t *testing.T) {
+	dir := t.TempDir()
+	node := &Node{ID: "plan", Shape: "box", Prompt: "Plan"}
+	graph := &Graph{Nodes: map[string]*Node{"plan": node}}
+
+	// Without a backend (simulation mode) nothing is cached.
+	if NewStageCache(dir, "").Cacheable(node) {
+		t.Error("expected a cache without a backend to cache nothing")
+	}
+
+	openai := NewStageCache(dir, "llm/openai/gpt-4o")
+	anthropic := NewStageCache(dir, "llm/anthropic/claude-sonnet-4-5")
+	if openai.Key(node, NewContext(), graph) == anthropic.Key(node, NewContext(), graph) {
+		t.Error("expected different backends to have different keys")
+	}
+}
+
+func TestStageCacheSecrets(t *testing.T) {
+	cipher, err := secrets.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewStageCache(t.TempDir(), "test")
+	handler := &outcomeHandler{outcome: &Outcome{
+		Status:         StatusSuccess,
+		ContextUpdates: map[string]interface{}{"plan": "step 1", "api_token": "hunter2"},
+	}}
+	node := &Node{ID: "plan", Shape: "box", Prompt: "Plan"}
+	graph := &Graph{Nodes: map[string]*Node{"plan": node}}
+	engine := NewEngine(EngineConfig{Cache: cache, Cipher: cipher, Scrubber: secrets.DefaultScrubber()},
+		&staticResolver{handler: handler}, nil)
+	key := cache.Key(node, NewContext(), graph)
+
+	// An outcome holding a secret-looking key isn't cached at all.
+	engine.executeWithRetry(node, NewContext(), graph, RetryPolicy{MaxAttempts: 1}, 0, "")
+	if _, ok := cache.Get(key, cipher); ok {
+		t.Error("expected an outcome with secrets not to be cached")
+	}
+
+	// Others are stored encrypted.
+	delete(handler.outcome.ContextUpdates, "api_token")
+	engine.executeWithRetry(node, NewContext(), graph, RetryPolicy{MaxAttempts: 1}, 0, "")
+	data, err := os.ReadFile(cache.path(key))
+	if err != nil {
+		t.Fatalf("expected a cached outcome: %v", err)
+	}
+	if !secrets.IsSealed(data) || strings.Contains(string(data), "step 1") {
+		t.Errorf("expected the cached outcome to be encrypted, got %s", data)
+	}
+	if o, ok := cache.Get(key, cipher); !ok || o.ContextUpdates["plan"] != "step 1" {
+		t.Errorf("expected the cached outcome to decrypt, got %+v", o)
+	}
+}
+
+// outcomeHandler returns a copy of a fixed outcome.
+type outcomeHandler struct {
+	outcome *Outcome
+}
+
+func (h *outcomeHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	o := *h.outcome
+	return &o, nil
+}
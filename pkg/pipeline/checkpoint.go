@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCheckpointHistory is how many checkpoints the engine keeps in
+// checkpoints/ besides checkpoint.json.
+const DefaultCheckpointHistory = 5
+
+// checkpointHistoryDir holds the numbered copies of a run's checkpoints.
+const checkpointHistoryDir = "checkpoints"
+
+// checksumField starts the checkpoint's top-level checksum field in
+// MarshalIndent output. Nested fields are indented further and strings can't
+// contain raw newlines, so it can't match anything else.
+const checksumField = "\n  \"checksum\": \""
+
+// marshal encodes cp with an empty checksum placeholder for withChecksum.
+func (cp *Checkpoint) marshal() ([]byte, error) {
+	c := *cp
+	c.Checksum = ""
+	data, err := json.MarshalIndent(&c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// omitempty dropped the field; add the placeholder before the closing
+	// brace.
+	end := bytes.LastIndexByte(data, '}')
+	placeholder := "," + checksumField + "\"\n"
+	return append(append(data[:end-1:end-1], placeholder...), '}'), nil
+}
+
+// withChecksum fills the checksum placeholder of data, from marshal, with
+// the SHA-256 of data.
+func withChecksum(data []byte) []byte {
+	empty := []byte(checksumField + "\"")
+	i := bytes.LastIndex(data, empty)
+	if i < 0 {
+		return data
+	}
+	sum := sha256.Sum256(data)
+	var out bytes.Buffer
+	out.Write(data[:i+len(checksumField)])
+	out.WriteString(hex.EncodeToString(sum[:]))
+	out.Write(data[i+len(checksumField):])
+	return out.Bytes()
+}
+
+// verifyChecksum checks the checksum written by withChecksum. Checkpoints
+// without one, from older versions, pass.
+func verifyChecksum(data []byte) error {
+	i := bytes.LastIndex(data, []byte(checksumField))
+	if i < 0 {
+		return nil
+	}
+	start := i + len(checksumField)
+	end := bytes.IndexByte(data[start:], '"')
+	if end < 0 {
+		return fmt.Errorf("checkpoint is truncated")
+	}
+	want := string(data[start : start+end])
+	var unsummed bytes.Buffer
+	unsummed.Write(data[:start])
+	unsummed.Write(data[start+end:])
+	sum := sha256.Sum256(unsummed.Bytes())
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checkpoint checksum mismatch: file is corrupt or was modified")
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers see either the old or the new contents, never a
+// partial write.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself; not every platform can sync a directory.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// checkpointHistoryPath is the path of the numbered copy of checkpoint seq.
+func checkpointHistoryPath(logsRoot string, seq int) string {
+	return filepath.Join(logsRoot, checkpointHistoryDir, fmt.Sprintf("checkpoint-%06d.json", seq))
+}
+
+// checkpointHistory returns the sequence numbers in logsRoot's history,
+// oldest first.
+func checkpointHistory(logsRoot string) []int {
+	entries, _ := os.ReadDir(filepath.Join(logsRoot, checkpointHistoryDir))
+	var seqs []int
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "checkpoint-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "checkpoint-"), ".json"))
+		if err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs
+}
+
+// pruneCheckpointHistory removes all but the newest keep checkpoints.
+func pruneCheckpointHistory(logsRoot string, keep int) {
+	seqs := checkpointHistory(logsRoot)
+	for len(seqs) > keep {
+		os.Remove(checkpointHistoryPath(logsRoot, seqs[0]))
+		seqs = seqs[1:]
+	}
+}
+
+// LoadLatestCheckpoint returns the newest intact checkpoint in a run's logs
+// directory: checkpoint.json, or if that is missing, corrupt, or older, the
+// newest numbered copy in checkpoints/ that passes its checksum.
+func LoadLatestCheckpoint(logsRoot string) (*Checkpoint, error) {
+	paths := []string{filepath.Join(logsRoot, "checkpoint.json")}
+	seqs := checkpointHistory(logsRoot)
+	for i := len(seqs) - 1; i >= 0; i-- {
+		paths = append(paths, checkpointHistoryPath(logsRoot, seqs[i]))
+	}
+
+	var latest *Checkpoint
+	var firstErr error
+	for _, path := range paths {
+		cp, err := LoadCheckpoint(path)
+		if err != nil {
+			if firstErr == nil && !errors.Is(err, os.ErrNotExist) {
+				firstErr = err
+			}
+			continue
+		}
+		if latest == nil || cp.Sequence > latest.Sequence {
+			latest = cp
+		}
+	}
+	if latest != nil {
+		return latest, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, fmt.Errorf("no checkpoint in %s: %w", logsRoot, os.ErrNotExist)
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointHistoryAndRecovery(t *testing.T) {
+	graph, err := Parse(`digraph G {
+		start [shape=Mdiamond]
+		a     [label="A"]
+		b     [label="B"]
+		done  [shape=Msquare]
+		start -> a -> b -> done
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	logsRoot := t.TempDir()
+	engine := NewEngine(EngineConfig{LogsRoot: logsRoot, CheckpointHistory: 2}, &staticResolver{handler: &simpleHandler{}}, nil)
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	latest, err := LoadCheckpoint(filepath.Join(logsRoot, "checkpoint.json"))
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	if latest.Sequence < 3 || latest.Checksum == "" {
+		t.Fatalf("expected a checksummed checkpoint with sequence >= 3, got %d %q", latest.Sequence, latest.Checksum)
+	}
+	seqs := checkpointHistory(logsRoot)
+	if len(seqs) != 2 || seqs[1] != latest.Sequence || seqs[0] != latest.Sequence-1 {
+		t.Errorf("expected the last 2 checkpoints kept, got %v", seqs)
+	}
+	entries, _ := os.ReadDir(logsRoot)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("expected no leftover temp files, found %s", e.Name())
+		}
+	}
+
+	// A torn checkpoint.json is detected, and the newest intact copy in the
+	// history is used instead.
+	path := filepath.Join(logsRoot, "checkpoint.json")
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)/2], 0o644)
+	if _, err := LoadCheckpoint(path); err == nil {
+		t.Error("expected an error loading a truncated checkpoint")
+	}
+	recovered, err := LoadLatestCheckpoint(logsRoot)
+	if err != nil {
+		t.Fatalf("LoadLatestCheckpoint: %v", err)
+	}
+	if recovered.Sequence != latest.Sequence || recovered.CurrentNode != latest.CurrentNode {
+		t.Errorf("expected checkpoint %d from history, got %d", latest.Sequence, recovered.Sequence)
+	}
+
+	// A modified checkpoint fails its checksum.
+	tampered := strings.Replace(string(data), `"current_node": "`+latest.CurrentNode, `"current_node": "x`, 1)
+	os.WriteFile(path, []byte(tampered), 0o644)
+	if _, err := LoadCheckpoint(path); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	// A second run in the same directory continues the numbering.
+	os.WriteFile(path, data, 0o644)
+	engine = NewEngine(EngineConfig{LogsRoot: logsRoot, CheckpointHistory: 2}, &staticResolver{handler: &simpleHandler{}}, nil)
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if cp, _ := LoadLatestCheckpoint(logsRoot); cp == nil || cp.Sequence <= latest.Sequence {
+		t.Errorf("expected numbering to continue past %d, got %+v", latest.Sequence, cp)
+	}
+}
@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// commandRefPattern matches tool_command placeholders:
+//
+//	{context.KEY}            the context value, shell-quoted
+//	{context.KEY:-DEFAULT}   DEFAULT when KEY is unset
+//	{artifact.NODE/FILE}     the path of a file in the run's logs directory, shell-quoted
+//	{raw:context.KEY}        the value inserted as-is
+//
+// Other braces, such as awk programs or ${VAR}, are left alone.
+var commandRefPattern = regexp.MustCompile(`\{(raw:)?(context|artifact)\.([A-Za-z0-9_.\-/]+)(:-[^}]*)?\}`)
+
+// CommandRef is a placeholder in a tool command.
+type CommandRef struct {
+	Kind       string // "context" or "artifact"
+	Key        string
+	Default    string
+	HasDefault bool
+	Raw        bool
+}
+
+// CommandRefs returns the placeholders in a tool command, in order.
+func CommandRefs(command string) []CommandRef {
+	var refs []CommandRef
+	for _, m := range commandRefPattern.FindAllStringSubmatch(command, -1) {
+		refs = append(refs, parseCommandRef(m))
+	}
+	return refs
+}
+
+func parseCommandRef(m []string) CommandRef {
+	ref := CommandRef{Raw: m[1] != "", Kind: m[2], Key: m[3]}
+	if m[4] != "" {
+		ref.HasDefault = true
+		ref.Default = strings.TrimPrefix(m[4], ":-")
+	}
+	return ref
+}
+
+// ExpandCommand substitutes the placeholders in a tool command with values
+// from ctx and paths under logsRoot. Values are single-quoted for sh unless
+// the placeholder is raw. It fails if a context key without a default is
+// unset.
+func ExpandCommand(command string, ctx *Context, logsRoot string) (string, error) {
+	return ExpandCommandQuoted(command, ctx, logsRoot, func(s string) (string, error) {
+		return ShellQuote(s), nil
+	})
+}
+
+// ExpandCommandQuoted is ExpandCommand for a command run by another shell:
+// values are quoted with quote, which fails for a value it can't make
+// literal.
+func ExpandCommandQuoted(command string, ctx *Context, logsRoot string, quote func(string) (string, error)) (string, error) {
+	var missing []string
+	var quoteErr error
+	expanded := commandRefPattern.ReplaceAllStringFunc(command, func(match string) string {
+		ref := parseCommandRef(commandRefPattern.FindStringSubmatch(match))
+		var value string
+		switch ref.Kind {
+		case "artifact":
+			value = filepath.Join(logsRoot, filepath.FromSlash(ref.Key))
+		default:
+			v, ok := ctx.Get(ref.Key)
+			switch {
+			case ok && v != nil:
+				value = fmt.Sprint(v)
+			case ref.HasDefault:
+				value = ref.Default
+			default:
+				missing = append(missing, ref.Key)
+				return match
+			}
+		}
+		if ref.Raw {
+			return value
+		}
+		quoted, err := quote(value)
+		if err != nil && quoteErr == nil {
+			quoteErr = fmt.Errorf("tool_command placeholder %s: %w", match, err)
+		}
+		return quoted
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("tool_command references unset context keys: %s", strings.Join(missing, ", "))
+	}
+	if quoteErr != nil {
+		return "", quoteErr
+	}
+	return expanded, nil
+}
+
+// ExpandPrompt substitutes the {context.KEY} and {context.KEY:-DEFAULT}
+// placeholders of a prompt with context values, unquoted. Placeholders of
+// unset keys without a default, and {artifact.*} placeholders, are left as
+// they are.
+func ExpandPrompt(prompt string, ctx *Context) string {
+	return commandRefPattern.ReplaceAllStringFunc(prompt, func(match string) string {
+		ref := parseCommandRef(commandRefPattern.FindStringSubmatch(match))
+		if ref.Kind != "context" {
+			return match
+		}
+		if v, ok := ctx.Get(ref.Key); ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		if ref.HasDefault {
+			return ref.Default
+		}
+		return match
+	})
+}
+
+// ShellQuote quotes s as a single sh word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandCommand(t *testing.T) {
+	ctx := NewContext()
+	ctx.Set("test_path", "tests/unit dir")
+	ctx.Set("flags", "-x -q")
+	ctx.Set("quote", "it's")
+	ctx.Set("retries", 3)
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"pytest {context.test_path}", "pytest 'tests/unit dir'"},
+		{"pytest {raw:context.flags}", "pytest -x -q"},
+		{"echo {context.quote}", `echo 'it'\''s'`},
+		{"run --retries={context.retries}", "run --retries='3'"},
+		{"cd {context.missing:-/tmp}", "cd '/tmp'"},
+		{"pytest {raw:context.missing:-}", "pytest "},
+		{"cat {artifact.impl/response.md}", "cat '" + filepath.Join("/logs", "impl", "response.md") + "'"},
+		{`awk '{print $1}' ${HOME}`, `awk '{print $1}' ${HOME}`},
+	}
+	for _, tt := range tests {
+		got, err := ExpandCommand(tt.command, ctx, "/logs")
+		if err != nil {
+			t.Errorf("ExpandCommand(%q): unexpected error: %v", tt.command, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ExpandCommand(%q) = %q, want %q", tt.command, got, tt.expected)
+		}
+	}
+}
+
+func TestExpandCommandMissingKey(t *testing.T) {
+	_, err := ExpandCommand("pytest {context.test_path} {context.other}", NewContext(), "")
+	if err == nil {
+		t.Fatal("expected error for unset keys")
+	}
+	if !strings.Contains(err.Error(), "test_path, other") {
+		t.Errorf("expected both keys in error, got %v", err)
+	}
+}
+
+func TestExpandCommandQuoted(t *testing.T) {
+	ctx := NewContext()
+	ctx.Set("name", "a&b")
+	ctx.Set("evil", `x" & calc & "`)
+	quote := func(s string) (string, error) {
+		if strings.Contains(s, `"`) {
+			return "", fmt.Errorf("unquotable")
+		}
+		return `"` + s + `"`, nil
+	}
+
+	got, err := ExpandCommandQuoted("echo {context.name}", ctx, "", quote)
+	if err != nil || got != `echo "a&b"` {
+		t.Errorf(`expected echo "a&b", got %q, %v`, got, err)
+	}
+	if _, err := ExpandCommandQuoted("echo {context.evil}", ctx, "", quote); err == nil || !strings.Contains(err.Error(), "unquotable") {
+		t.Errorf("expected the quoting error, got %v", err)
+	}
+}
+
+func TestCommandRefs(t *testing.T) {
+	refs := CommandRefs("x {context.a} {raw:context.b:-1} {artifact.n/f}")
+	if len(refs) != 3 {
+		t.Fatalf("expected 3 refs, got %d", len(refs))
+	}
+	if refs[0].Key != "a" || refs[0].HasDefault || refs[0].Raw {
+		t.Errorf("unexpected first ref %+v", refs[0])
+	}
+	if refs[1].Key != "b" || !refs[1].HasDefault || refs[1].Default != "1" || !refs[1].Raw {
+		t.Errorf("unexpected second ref %+v", refs[1])
+	}
+	if refs[2].Kind != "artifact" || refs[2].Key != "n/f" {
+		t.Errorf("unexpected third ref %+v", refs[2])
+	}
+}
+
+func TestExpandPrompt(t *testing.T) {
+	ctx := NewContext()
+	ctx.Set("review_notes", "it's fine")
+	got := ExpandPrompt("Notes: {context.review_notes}. Risk: {context.risk:-unknown}. {context.missing} {artifact.plan/response.md}", ctx)
+	want := "Notes: it's fine. Risk: unknown. {context.missing} {artifact.plan/response.md}"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -3,6 +3,7 @@ package condition
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/ashka-vakil/attractor/pkg/pipeline"
@@ -30,6 +31,11 @@ func Evaluate(condition string, outcome *pipeline.Outcome, ctx *pipeline.Context
 }
 
 func evaluateClause(clause string, outcome *pipeline.Outcome, ctx *pipeline.Context) bool {
+	// set(pattern): the previous stage wrote a matching context key
+	if pattern, ok := pipeline.ParseSetClause(clause); ok {
+		return outcome.Updated(pattern)
+	}
+
 	// Check for != operator first (before = to avoid partial match)
 	if idx := strings.Index(clause, "!="); idx >= 0 {
 		key := strings.TrimSpace(clause[:idx])
@@ -63,22 +69,9 @@ func resolveKey(key string, outcome *pipeline.Outcome, ctx *pipeline.Context) st
 		return outcome.PreferredLabel
 	}
 
-	// context.* prefix
-	if strings.HasPrefix(key, "context.") {
-		// Try with full key first
-		if v, ok := ctx.Get(key); ok {
-			return fmt.Sprint(v)
-		}
-		// Try without context. prefix
-		stripped := strings.TrimPrefix(key, "context.")
-		if v, ok := ctx.Get(stripped); ok {
-			return fmt.Sprint(v)
-		}
-		return ""
-	}
-
-	// Direct context lookup
-	if v, ok := ctx.Get(key); ok {
+	// Context keys, with or without a context. prefix, and paths into their
+	// values such as context.results[0].status
+	if v, ok := ctx.Lookup(key); ok {
 		return fmt.Sprint(v)
 	}
 	return ""
@@ -105,6 +98,16 @@ func Validate(condition string) error {
 }
 
 func validateClause(clause string) error {
+	if pattern, ok := pipeline.ParseSetClause(clause); ok {
+		if pattern == "" {
+			return fmt.Errorf("empty pattern in clause: %q", clause)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern in clause %q: %v", clause, err)
+		}
+		return nil
+	}
+
 	// Must have a key and optionally an operator with value
 	if strings.Contains(clause, "!=") {
 		parts := strings.SplitN(clause, "!=", 2)
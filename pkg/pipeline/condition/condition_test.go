@@ -94,6 +94,7 @@ func TestValidateConditionValid(t *testing.T) {
 		"outcome=success",
 		"outcome!=fail",
 		"outcome=success && context.x=y",
+		"set(context.tests_*)",
 		"",
 	}
 	for _, c := range cases {
@@ -107,6 +108,8 @@ func TestValidateConditionInvalid(t *testing.T) {
 	cases := []string{
 		"=value",
 		"!=value",
+		"set()",
+		"set(tests_[)",
 	}
 	for _, c := range cases {
 		if err := Validate(c); err == nil {
@@ -114,3 +117,41 @@ func TestValidateConditionInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestEvaluateSetClause(t *testing.T) {
+	outcome := &pipeline.Outcome{
+		Status:         pipeline.StatusSuccess,
+		ContextUpdates: map[string]interface{}{"tests_failed": "true"},
+	}
+	ctx := pipeline.NewContext()
+	ctx.Set("coverage", "80")
+
+	if !Evaluate("set(context.tests_*)", outcome, ctx) {
+		t.Error("expected set(context.tests_*) to match tests_failed")
+	}
+	if !Evaluate("set(tests_failed) && outcome=success", outcome, ctx) {
+		t.Error("expected set(tests_failed) && outcome=success to be true")
+	}
+	if Evaluate("set(coverage)", outcome, ctx) {
+		t.Error("expected set(coverage) to be false: the key was not set by the previous stage")
+	}
+	if Evaluate("set(tests_*)", nil, ctx) {
+		t.Error("expected set() to be false without an outcome")
+	}
+}
+
+func TestEvaluateContextPath(t *testing.T) {
+	outcome := &pipeline.Outcome{Status: pipeline.StatusSuccess}
+	ctx := pipeline.NewContext()
+	ctx.Set("results", `[{"status": "ok"}, {"status": "fail"}]`)
+
+	if !Evaluate("context.results[0].status=ok", outcome, ctx) {
+		t.Error("expected context.results[0].status=ok to be true")
+	}
+	if !Evaluate("results[1].status=fail", outcome, ctx) {
+		t.Error("expected results[1].status=fail to be true")
+	}
+	if Evaluate("context.results[2].status=ok", outcome, ctx) {
+		t.Error("expected an out-of-range index to be unset")
+	}
+}
@@ -0,0 +1,295 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GetInt retrieves an integer value from the context. Floats with no
+// fractional part and numeric strings are converted; anything else is 0.
+func (c *Context) GetInt(key string) int {
+	v, ok := c.Get(key)
+	if !ok {
+		return 0
+	}
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		if n == float64(int(n)) {
+			return int(n)
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return int(i)
+		}
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(n)); err == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// GetBool retrieves a boolean value from the context. The strings accepted
+// by strconv.ParseBool are converted; anything else is false.
+func (c *Context) GetBool(key string) bool {
+	v, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		parsed, _ := strconv.ParseBool(strings.TrimSpace(b))
+		return parsed
+	}
+	return false
+}
+
+// GetJSON decodes the value at key into out, as json.Unmarshal would. A
+// string value is decoded as a JSON document unless out is a *string, so
+// structured tool output stored as text can be read into a struct.
+func (c *Context) GetJSON(key string, out interface{}) error {
+	v, ok := c.Get(key)
+	if !ok {
+		return fmt.Errorf("context key %q not set", key)
+	}
+	data, err := json.Marshal(v)
+	if s, isString := v.(string); isString {
+		if _, wantString := out.(*string); !wantString {
+			data, err = []byte(s), nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("context key %q: %w", key, err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("context key %q: %w", key, err)
+	}
+	return nil
+}
+
+// Lookup resolves a path such as "results[0].status" or
+// "context.review.score": the longest dotted prefix that is a key selects a
+// value, and the rest of the path indexes into it by map key and [index].
+// A leading "context." is optional. Values that are neither maps nor slices
+// are traversed through their JSON encoding.
+func (c *Context) Lookup(path string) (interface{}, bool) {
+	if v, ok := c.Get(path); ok {
+		return v, true
+	}
+	segs, err := parsePath(path)
+	if err != nil {
+		return nil, false
+	}
+	if v, ok := c.lookupSegments(segs); ok {
+		return v, true
+	}
+	if len(segs) > 1 && segs[0].key == "context" {
+		return c.lookupSegments(segs[1:])
+	}
+	return nil, false
+}
+
+func (c *Context) lookupSegments(segs []pathSegment) (interface{}, bool) {
+	// Keys may themselves contain dots, so try the longest prefix of named
+	// segments first.
+	names := 0
+	for names < len(segs) && !segs[names].isIndex {
+		names++
+	}
+	for n := names; n > 0; n-- {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = segs[i].key
+		}
+		if v, ok := c.Get(strings.Join(keys, ".")); ok {
+			return walkPath(v, segs[n:])
+		}
+	}
+	return nil, false
+}
+
+// pathSegment is one step of a context path: a map key or a slice index.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parsePath splits "a.b[0].c" into its segments.
+func parsePath(path string) ([]pathSegment, error) {
+	var segs []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name == "" && (len(segs) == 0 || rest == "") {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		if name != "" {
+			segs = append(segs, pathSegment{key: name})
+		}
+		for rest != "" {
+			idx, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid path %q: missing ]", path)
+			}
+			i, err := strconv.Atoi(idx)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", path, idx)
+			}
+			segs = append(segs, pathSegment{index: i, isIndex: true})
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			rest = after[1:]
+		}
+	}
+	return segs, nil
+}
+
+// walkPath follows segs into v.
+func walkPath(v interface{}, segs []pathSegment) (interface{}, bool) {
+	for i, seg := range segs {
+		switch cur := v.(type) {
+		case map[string]interface{}:
+			next, ok := cur[seg.key]
+			if seg.isIndex || !ok {
+				return nil, false
+			}
+			v = next
+		case map[string]string:
+			next, ok := cur[seg.key]
+			if seg.isIndex || !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			if !seg.isIndex || seg.index >= len(cur) {
+				return nil, false
+			}
+			v = cur[seg.index]
+		case []string:
+			if !seg.isIndex || seg.index >= len(cur) {
+				return nil, false
+			}
+			v = cur[seg.index]
+		default:
+			generic, ok := toGeneric(v)
+			if !ok {
+				return nil, false
+			}
+			return walkPath(generic, segs[i:])
+		}
+	}
+	return v, true
+}
+
+// toGeneric converts v to a JSON map or slice: a string is parsed as a JSON
+// document, anything else goes through its JSON encoding.
+func toGeneric(v interface{}) (interface{}, bool) {
+	data, ok := v.(string)
+	if !ok {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, false
+		}
+		data = string(encoded)
+	}
+	var generic interface{}
+	if json.Unmarshal([]byte(data), &generic) != nil {
+		return nil, false
+	}
+	switch generic.(type) {
+	case map[string]interface{}, []interface{}:
+		return generic, true
+	}
+	return nil, false
+}
+
+// DefaultMaxContextValueBytes is the JSON size above which a context value is
+// written to its own file instead of into checkpoint.json.
+const DefaultMaxContextValueBytes = 64 * 1024
+
+// contextFileRef is the checkpoint.json placeholder of a context value
+// stored in a file, relative to the checkpoint's directory.
+const contextFileRef = "$file"
+
+// spillContext writes each value of cp whose JSON encoding exceeds maxBytes
+// to context/<key>.json under dir, passed through encode, replacing it in cp
+// with a reference that LoadCheckpoint resolves and listing the key in
+// cp.SpilledKeys.
+func spillContext(cp *Checkpoint, dir string, maxBytes int, encode func([]byte) ([]byte, error)) error {
+	cp.SpilledKeys = nil
+	for key, v := range cp.ContextValues {
+		data, err := json.Marshal(v)
+		if err != nil || len(data) <= maxBytes {
+			continue
+		}
+		rel := filepath.Join("context", contextFileName(key))
+		if data, err = encode(data); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, rel), data); err != nil {
+			return err
+		}
+		cp.ContextValues[key] = map[string]interface{}{
+			contextFileRef: filepath.ToSlash(rel),
+			"bytes":        len(data),
+		}
+		cp.SpilledKeys = append(cp.SpilledKeys, key)
+	}
+	sort.Strings(cp.SpilledKeys)
+	return nil
+}
+
+// loadSpilledContext replaces the file references of cp.SpilledKeys, written
+// by spillContext, with the values they point to, passed through decode.
+// Other context values are left as they are, even if they look like a
+// reference.
+func loadSpilledContext(cp *Checkpoint, dir string, decode func([]byte) ([]byte, error)) error {
+	for _, key := range cp.SpilledKeys {
+		ref, _ := cp.ContextValues[key].(map[string]interface{})
+		rel, ok := ref[contextFileRef].(string)
+		if !ok || !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return fmt.Errorf("context %q: invalid file reference", key)
+		}
+		data, err := readFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err == nil {
+			data, err = decode(data)
+		}
+		if err != nil {
+			return fmt.Errorf("context %q: %w", key, err)
+		}
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Errorf("context %q: %w", key, err)
+		}
+		cp.ContextValues[key] = value
+	}
+	return nil
+}
+
+// contextFileName maps a context key to a file name that can't leave the
+// context directory.
+func contextFileName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' && i > 0 {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "%%%02x", r)
+		}
+	}
+	return b.String() + ".json"
+}
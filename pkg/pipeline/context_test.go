@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+func TestContextTypedGetters(t *testing.T) {
+	ctx := NewContext()
+	ctx.Set("count", 3)
+	ctx.Set("decoded", float64(7))
+	ctx.Set("text_count", " 12 ")
+	ctx.Set("flag", true)
+	ctx.Set("text_flag", "true")
+	ctx.Set("report", `{"passed": 4, "failed": ["a"]}`)
+	ctx.Set("structured", map[string]interface{}{"passed": 2})
+
+	for key, want := range map[string]int{"count": 3, "decoded": 7, "text_count": 12, "flag": 0, "missing": 0} {
+		if got := ctx.GetInt(key); got != want {
+			t.Errorf("GetInt(%q): expected %d, got %d", key, want, got)
+		}
+	}
+	for key, want := range map[string]bool{"flag": true, "text_flag": true, "count": false, "missing": false} {
+		if got := ctx.GetBool(key); got != want {
+			t.Errorf("GetBool(%q): expected %v, got %v", key, want, got)
+		}
+	}
+
+	var report struct {
+		Passed int      `json:"passed"`
+		Failed []string `json:"failed"`
+	}
+	if err := ctx.GetJSON("report", &report); err != nil {
+		t.Fatalf("GetJSON(report): %v", err)
+	}
+	if report.Passed != 4 || len(report.Failed) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if err := ctx.GetJSON("structured", &report); err != nil || report.Passed != 2 {
+		t.Errorf("expected structured value decoded, got %+v, %v", report, err)
+	}
+	var s string
+	if err := ctx.GetJSON("report", &s); err != nil || !strings.HasPrefix(s, "{") {
+		t.Errorf("expected the raw string for a *string, got %q, %v", s, err)
+	}
+	if err := ctx.GetJSON("missing", &s); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestContextLookup(t *testing.T) {
+	ctx := NewContext()
+	ctx.Set("results", []interface{}{
+		map[string]interface{}{"status": "ok"},
+		map[string]interface{}{"status": "fail"},
+	})
+	ctx.Set("review.meta", map[string]string{"score": "9"})
+	ctx.Set("tool_output", `{"files": [{"name": "main.go"}]}`)
+	ctx.Set("outcome", &Outcome{Status: StatusSuccess})
+
+	tests := map[string]interface{}{
+		"results[1].status":            "fail",
+		"context.results[0].status":    "ok",
+		"review.meta.score":            "9",
+		"tool_output.files[0].name":    "main.go",
+		"context.outcome.outcome":      "success",
+		"results[2].status":            nil,
+		"results.status":               nil,
+		"review.meta.missing":          nil,
+		"context.tool_output.files[x]": nil,
+	}
+	for path, want := range tests {
+		got, ok := ctx.Lookup(path)
+		if want == nil {
+			if ok {
+				t.Errorf("Lookup(%q): expected no value, got %v", path, got)
+			}
+			continue
+		}
+		if !ok || got != want {
+			t.Errorf("Lookup(%q): expected %v, got %v (%v)", path, want, got, ok)
+		}
+	}
+}
+
+// largeOutputHandler writes a context value too large for checkpoint.json,
+// and a small one shaped like the reference that replaces it.
+type largeOutputHandler struct{}
+
+func (h *largeOutputHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	return &Outcome{
+		Status: StatusSuccess,
+		ContextUpdates: map[string]interface{}{
+			"tool/output": strings.Repeat("x", 2048),
+			"lookalike":   map[string]interface{}{"$file": "context/tool%2foutput.json"},
+		},
+	}, nil
+}
+
+func TestCheckpointSpillsLargeContextValues(t *testing.T) {
+	graph, err := Parse(`digraph G {
+		start [shape=Mdiamond]
+		work  [label="Work"]
+		done  [shape=Msquare]
+		start -> work -> done
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	logsRoot := t.TempDir()
+	resolver := &staticResolver{handler: &simpleHandler{}, special: map[string]Handler{"work": &largeOutputHandler{}}}
+	engine := NewEngine(EngineConfig{LogsRoot: logsRoot, MaxContextValueBytes: 1024}, resolver, nil)
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logsRoot, "checkpoint.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), strings.Repeat("x", 2048)) {
+		t.Error("expected the large value to be kept out of checkpoint.json")
+	}
+	var raw struct {
+		Context map[string]map[string]interface{} `json:"context"`
+	}
+	json.Unmarshal(data, &raw)
+	if ref := raw.Context["tool/output"]["$file"]; ref != "context/tool%2foutput.json" {
+		t.Errorf("expected a file reference, got %v", ref)
+	}
+
+	cp, err := LoadCheckpoint(filepath.Join(logsRoot, "checkpoint.json"))
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	if got := cp.ContextValues["tool/output"]; got != strings.Repeat("x", 2048) {
+		t.Errorf("expected the spilled value to be read back, got %.20v", got)
+	}
+	if !slices.Equal(cp.SpilledKeys, []string{"tool/output"}) {
+		t.Errorf("expected tool/output recorded as spilled, got %v", cp.SpilledKeys)
+	}
+	// Only recorded keys are resolved, so a value can't pull in a file.
+	if got, ok := cp.ContextValues["lookalike"].(map[string]interface{}); !ok || got["$file"] != "context/tool%2foutput.json" {
+		t.Errorf("expected a value shaped like a reference to be kept as is, got %.40v", cp.ContextValues["lookalike"])
+	}
+}
+
+// credentialHandler writes a context value under a secret-looking key.
+type credentialHandler struct{}
+
+func (h *credentialHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	return &Outcome{
+		Status:         StatusSuccess,
+		ContextUpdates: map[string]interface{}{"deploy.api_key": "sk-live-123", "deploy.url": "https://example.com"},
+	}, nil
+}
+
+func TestCheckpointScrubbedAndEncrypted(t *testing.T) {
+	t.Setenv("ATTRACTOR_CHECKPOINT_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	cipher, err := secrets.CipherFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := Parse(`digraph G {
+		start [shape=Mdiamond]
+		work  [label="Work"]
+		done  [shape=Msquare]
+		start -> work -> done
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	logsRoot := t.TempDir()
+	resolver := &staticResolver{handler: &simpleHandler{}, special: map[string]Handler{"work": &credentialHandler{}}}
+	engine := NewEngine(EngineConfig{LogsRoot: logsRoot, Scrubber: secrets.DefaultScrubber(), Cipher: cipher}, resolver, nil)
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	path := filepath.Join(logsRoot, "checkpoint.json")
+	data, _ := os.ReadFile(path)
+	if !secrets.IsSealed(data) || strings.Contains(string(data), "deploy") {
+		t.Fatalf("expected an encrypted checkpoint, got %s", data)
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	if got := cp.ContextValues["deploy.api_key"]; got != secrets.ScrubbedValue {
+		t.Errorf("expected deploy.api_key scrubbed, got %v", got)
+	}
+	if got := cp.ContextValues["deploy.url"]; got != "https://example.com" {
+		t.Errorf("expected deploy.url kept, got %v", got)
+	}
+	if !slices.Equal(cp.ScrubbedKeys, []string{"deploy.api_key"}) {
+		t.Errorf("expected deploy.api_key recorded as scrubbed, got %v", cp.ScrubbedKeys)
+	}
+
+	t.Setenv("ATTRACTOR_CHECKPOINT_KEY", "")
+	if _, err := LoadCheckpoint(path); err == nil {
+		t.Error("expected an error loading an encrypted checkpoint without a key")
+	}
+}
@@ -0,0 +1,314 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+)
+
+// Schedule modes selectable with the graph-level "schedule" attribute.
+const (
+	ScheduleWalk = "walk"
+	ScheduleDAG  = "dag"
+)
+
+// dagResult is a finished node execution reported back to the scheduler.
+type dagResult struct {
+	node     *Node
+	index    int
+	outcome  *Outcome
+	changes  map[string]interface{}
+	logs     []string
+	duration time.Duration
+}
+
+// runDAG executes graph as a dependency graph rather than a single walk. A
+// node becomes ready once every predecessor has finished or been skipped, and
+// runs if at least one incoming edge is active: its source succeeded (or, for
+// a conditional edge, the condition matched the source's outcome). Ready
+// nodes run concurrently, bounded by the graph's max_parallel. Each node sees
+// a snapshot of the context taken when it was dispatched; its writes are
+// merged back when it finishes. Parallel and terminal nodes only pass control
+// on; a fan-in node runs its handler once all its predecessors are resolved,
+// with parallel.results describing the predecessors that ran (see
+// dagBranchResults). A seed supplies the initial context and upstream
+// completions of a partial re-run.
+func (e *Engine) runDAG(graph *Graph, seed *resumeState) (*RunResult, error) {
+	startTime := time.Now()
+	pipelineID := fmt.Sprintf("run-%d", time.Now().UnixNano())
+
+	e.emitter.EmitPipelineStarted(graph.Name, pipelineID)
+
+	if _, err := graph.TopologicalOrder(); err != nil {
+		err = fmt.Errorf("dag schedule: %w", err)
+		e.emitter.EmitPipelineFailed(err.Error(), time.Since(startTime))
+		return nil, err
+	}
+
+	ctx := NewContext()
+	completedNodes, nodeOutcomes := seed.seed(graph, ctx)
+	mirrorGraphAttributes(graph, ctx)
+
+	maxParallel := graph.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = defaultMaxParallel
+	}
+
+	pending := make(map[string]int, len(graph.Nodes))
+	active := make(map[string]bool, len(graph.Nodes))
+	for id := range graph.Nodes {
+		pending[id] = 0
+	}
+	for _, edge := range graph.Edges {
+		if _, ok := graph.Nodes[edge.To]; ok {
+			pending[edge.To]++
+		}
+	}
+
+	var ready []string
+	for id, n := range pending {
+		if n == 0 {
+			ready = append(ready, id)
+			active[id] = true
+		}
+	}
+	sort.Strings(ready)
+
+	var usage usageTally
+	budgetReason := ""
+	var cancelErr error
+
+	// resolve marks a node as finished (or skipped, when outcome is nil) and
+	// releases any successors whose predecessors are now all resolved.
+	var resolve func(id string, outcome *Outcome)
+	resolve = func(id string, outcome *Outcome) {
+		for _, edge := range graph.OutgoingEdges(id) {
+			if _, ok := pending[edge.To]; !ok {
+				continue
+			}
+			if outcome != nil && dagEdgeActive(edge, outcome, ctx) {
+				active[edge.To] = true
+			}
+			pending[edge.To]--
+			if pending[edge.To] > 0 {
+				continue
+			}
+			if active[edge.To] {
+				ready = append(ready, edge.To)
+			} else {
+				resolve(edge.To, nil)
+			}
+		}
+	}
+
+	results := make(chan dagResult)
+	running := 0
+	stageIndex := 0
+
+	for len(ready) > 0 || running > 0 {
+		// Once cancelled, nothing new is dispatched; in-flight nodes drain.
+		if cancelErr == nil {
+			cancelErr = e.cancelled()
+		}
+		for budgetReason == "" && cancelErr == nil && len(ready) > 0 && running < maxParallel {
+			node := graph.Nodes[ready[0]]
+			ready = ready[1:]
+
+			// Terminal and fan-out nodes are structural in DAG mode;
+			// concurrency comes from the scheduler itself.
+			if isTerminal(node) || isParallel(node) {
+				outcome := &Outcome{Status: StatusSuccess}
+				if !isTerminal(node) {
+					completedNodes = append(completedNodes, node.ID)
+					nodeOutcomes[node.ID] = outcome
+				}
+				resolve(node.ID, outcome)
+				continue
+			}
+
+			if isFanIn(node) {
+				branches := dagBranchResults(graph, node.ID, nodeOutcomes, e.config.LogsRoot)
+				serialized, _ := json.Marshal(branches)
+				ctx.Set("parallel.results", string(serialized))
+				for _, b := range branches {
+					ctx.Set(branchContextKey(b.Branch, "outcome"), string(b.Outcome.Status))
+				}
+			}
+
+			e.emitter.EmitTyped(events.StageStarted{Name: node.Label, NodeID: node.ID, Index: stageIndex})
+			running++
+			go func(node *Node, index int, nodeCtx *Context) {
+				base := nodeCtx.Snapshot()
+				baseLogs := len(nodeCtx.Logs())
+				start := time.Now()
+				outcome, err := e.executeWithRetry(node, nodeCtx, graph, buildRetryPolicy(node, graph), index, e.config.LogsRoot)
+				if err != nil {
+					outcome = &Outcome{Status: StatusFail, FailureReason: err.Error()}
+				}
+				nodeCtx.ApplyUpdates(outcome.ContextUpdates)
+				results <- dagResult{
+					node:     node,
+					index:    index,
+					outcome:  outcome,
+					changes:  nodeCtx.ChangesSince(base),
+					logs:     nodeCtx.Logs()[baseLogs:],
+					duration: time.Since(start),
+				}
+			}(node, stageIndex, ctx.Clone())
+			stageIndex++
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+
+		if r.outcome.Status == StatusSuccess || r.outcome.Status == StatusPartialSuccess {
+			e.emitter.EmitTyped(events.StageCompleted{Name: r.node.Label, NodeID: r.node.ID, Index: r.index, Duration: r.duration})
+		} else {
+			e.emitter.EmitTyped(events.StageFailed{Name: r.node.Label, NodeID: r.node.ID, Index: r.index, Error: r.outcome.FailureReason})
+		}
+
+		completedNodes = append(completedNodes, r.node.ID)
+		nodeOutcomes[r.node.ID] = r.outcome
+		usage.add(r.node.ID, r.outcome.Usage)
+		ctx.ApplyUpdates(r.changes)
+		for _, entry := range r.logs {
+			ctx.AppendLog(entry)
+		}
+
+		e.saveCheckpoint(&Checkpoint{
+			Timestamp:      time.Now(),
+			CurrentNode:    r.node.ID,
+			CompletedNodes: completedNodes,
+			NodeStatuses:   nodeStatuses(nodeOutcomes),
+			NodeRetries:    make(map[string]int),
+			ContextValues:  ctx.Snapshot(),
+			Logs:           ctx.Logs(),
+		})
+
+		// Once over budget, nothing new is dispatched; in-flight nodes drain.
+		if budgetReason == "" {
+			budgetReason = budgetExceeded(graph, usage.total)
+		}
+		if budgetReason == "" {
+			resolve(r.node.ID, r.outcome)
+		}
+	}
+
+	if cancelErr != nil {
+		e.emitter.EmitPipelineFailed(cancelErr.Error(), time.Since(startTime))
+		return &RunResult{
+			Status:         StatusFail,
+			CompletedNodes: completedNodes,
+			FinalOutcome:   &Outcome{Status: StatusFail, FailureReason: cancelErr.Error()},
+			NodeOutcomes:   nodeOutcomes,
+			Usage:          usage.total,
+			NodeUsage:      usage.byNode,
+		}, cancelErr
+	}
+
+	if budgetReason != "" {
+		e.emitter.EmitPipelineFailed(budgetReason, time.Since(startTime))
+		return &RunResult{
+			Status:         StatusFail,
+			CompletedNodes: completedNodes,
+			FinalOutcome: &Outcome{
+				Status:        StatusFail,
+				FailureReason: budgetReason,
+			},
+			NodeOutcomes:   nodeOutcomes,
+			Usage:          usage.total,
+			NodeUsage:      usage.byNode,
+			BudgetExceeded: true,
+		}, nil
+	}
+
+	if gateOK, failedGate := checkGoalGates(graph, nodeOutcomes); !gateOK && failedGate != nil {
+		err := fmt.Errorf("goal gate %q unsatisfied", failedGate.ID)
+		e.emitter.EmitPipelineFailed(err.Error(), time.Since(startTime))
+		return &RunResult{
+			Status:         StatusFail,
+			CompletedNodes: completedNodes,
+			NodeOutcomes:   nodeOutcomes,
+			Usage:          usage.total,
+			NodeUsage:      usage.byNode,
+		}, nil
+	}
+
+	finalStatus := StatusSuccess
+	for _, outcome := range nodeOutcomes {
+		if outcome.Status == StatusFail {
+			finalStatus = StatusFail
+			break
+		}
+	}
+	e.emitter.EmitPipelineCompleted(time.Since(startTime), len(completedNodes))
+
+	return &RunResult{
+		Status:         finalStatus,
+		CompletedNodes: completedNodes,
+		NodeOutcomes:   nodeOutcomes,
+		Usage:          usage.total,
+		NodeUsage:      usage.byNode,
+	}, nil
+}
+
+// dagBranchResults describes the branches that meet at fan-in node id in a
+// DAG run, as executeParallel does for a walk: one per predecessor that ran,
+// in edge order, named after the node its chain of single predecessors starts
+// from after a parallel node (or the predecessor itself, if none).
+func dagBranchResults(graph *Graph, id string, outcomes map[string]*Outcome, logsRoot string) []*BranchResult {
+	var results []*BranchResult
+	for _, edge := range graph.IncomingEdges(id) {
+		outcome, ok := outcomes[edge.From]
+		if !ok {
+			continue
+		}
+		results = append(results, &BranchResult{
+			Branch:         dagBranchStart(graph, edge.From),
+			CompletedNodes: []string{edge.From},
+			NodeOutcomes:   map[string]*Outcome{edge.From: outcome},
+			Outcome:        outcome,
+			LogsDir:        logsRoot,
+		})
+	}
+	return results
+}
+
+// dagBranchStart follows id's chain of single predecessors back to the node
+// a parallel node fans out to, returning id if the chain has none.
+func dagBranchStart(graph *Graph, id string) string {
+	seen := make(map[string]bool)
+	for cur := id; !seen[cur]; {
+		seen[cur] = true
+		in := graph.IncomingEdges(cur)
+		if len(in) != 1 {
+			break
+		}
+		from := graph.Nodes[in[0].From]
+		if from == nil {
+			break
+		}
+		if isParallel(from) {
+			return cur
+		}
+		cur = from.ID
+	}
+	return id
+}
+
+// dagEdgeActive reports whether edge carries control from a finished source.
+// Unconditional edges follow successful outcomes only; conditional edges
+// follow whenever their condition matches.
+func dagEdgeActive(edge *Edge, outcome *Outcome, ctx *Context) bool {
+	if edge.Condition != "" {
+		return evaluateConditionSimple(edge.Condition, outcome, ctx)
+	}
+	return outcome.Status != StatusFail
+}
@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func diamondGraph() *Graph {
+	return &Graph{
+		Name:     "test",
+		Schedule: ScheduleDAG,
+		Nodes: map[string]*Node{
+			"start": {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"a":     {ID: "a", Shape: "box", Attrs: map[string]string{}},
+			"b":     {ID: "b", Shape: "box", Attrs: map[string]string{}},
+			"c":     {ID: "c", Shape: "box", Attrs: map[string]string{}},
+			"exit":  {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "a"},
+			{From: "start", To: "b"},
+			{From: "a", To: "c"},
+			{From: "b", To: "c"},
+			{From: "c", To: "exit"},
+		},
+	}
+}
+
+func TestDAGRunsIndependentNodesConcurrently(t *testing.T) {
+	branches := &branchHandler{}
+	join := &snapshotHandler{}
+	resolver := &staticResolver{
+		handler: branches,
+		special: map[string]Handler{
+			"start": &simpleHandler{},
+			"c":     join,
+		},
+	}
+	engine := NewEngine(EngineConfig{}, resolver, nil)
+
+	result, err := engine.Run(diamondGraph())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Status != StatusSuccess {
+		t.Errorf("expected SUCCESS, got %s", result.Status)
+	}
+	if branches.peak != 2 {
+		t.Errorf("expected a and b to overlap, peak concurrency was %d", branches.peak)
+	}
+	if join.seen["branch.a"] != "done" || join.seen["branch.b"] != "done" {
+		t.Errorf("expected c to see writes from both a and b, got %v", join.seen)
+	}
+
+	if n := len(result.CompletedNodes); n != 4 {
+		t.Fatalf("expected 4 completed nodes, got %d: %v", n, result.CompletedNodes)
+	}
+	if result.CompletedNodes[3] != "c" {
+		t.Errorf("expected c to complete last, got %v", result.CompletedNodes)
+	}
+}
+
+func TestDAGRespectsMaxParallel(t *testing.T) {
+	graph := diamondGraph()
+	graph.MaxParallel = 1
+
+	branches := &branchHandler{}
+	resolver := &staticResolver{
+		handler: branches,
+		special: map[string]Handler{"start": &simpleHandler{}},
+	}
+	engine := NewEngine(EngineConfig{}, resolver, nil)
+
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if branches.peak != 1 {
+		t.Errorf("expected peak concurrency 1, got %d", branches.peak)
+	}
+}
+
+func TestDAGSkipsInactiveBranches(t *testing.T) {
+	graph := &Graph{
+		Name:     "test",
+		Schedule: ScheduleDAG,
+		Nodes: map[string]*Node{
+			"start":  {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"build":  {ID: "build", Shape: "box", Attrs: map[string]string{}},
+			"fix":    {ID: "fix", Shape: "box", Attrs: map[string]string{}},
+			"report": {ID: "report", Shape: "box", Attrs: map[string]string{}},
+			"exit":   {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "build"},
+			{From: "build", To: "fix", Condition: "outcome=fail"},
+			{From: "build", To: "report"},
+			{From: "fix", To: "report"},
+			{From: "report", To: "exit"},
+		},
+	}
+
+	resolver := &staticResolver{handler: &simpleHandler{}}
+	engine := NewEngine(EngineConfig{}, resolver, nil)
+
+	result, err := engine.Run(graph)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, ran := result.NodeOutcomes["fix"]; ran {
+		t.Error("expected fix to be skipped when build succeeds")
+	}
+	if _, ran := result.NodeOutcomes["report"]; !ran {
+		t.Error("expected report to run")
+	}
+}
+
+func TestDAGRunsFanIn(t *testing.T) {
+	graph := parallelGraph("")
+	graph.Schedule = ScheduleDAG
+	join := &snapshotHandler{}
+	resolver := &staticResolver{
+		handler: &simpleHandler{},
+		special: map[string]Handler{"join": join},
+	}
+	result, err := NewEngine(EngineConfig{}, resolver, nil).Run(graph)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if join.seen == nil {
+		t.Fatal("expected the fan-in handler to run")
+	}
+	var branches []*BranchResult
+	if err := json.Unmarshal([]byte(fmt.Sprint(join.seen["parallel.results"])), &branches); err != nil {
+		t.Fatalf("expected parallel.results at the fan-in: %v", err)
+	}
+	if len(branches) != 2 || branches[0].Branch != "a" || branches[1].Branch != "b" {
+		t.Errorf("expected branches a and b, got %+v", branches)
+	}
+	if len(branches) == 2 && branches[1].CompletedNodes[0] != "b2" {
+		t.Errorf("expected branch b to end at b2, got %v", branches[1].CompletedNodes)
+	}
+	if join.seen["parallel.b.outcome"] != "success" {
+		t.Errorf("expected parallel.b.outcome at the fan-in, got %v", join.seen["parallel.b.outcome"])
+	}
+	if o := result.NodeOutcomes["join"]; o == nil || o.Status != StatusSuccess {
+		t.Errorf("expected the fan-in to complete, got %+v", o)
+	}
+}
+
+func TestDAGRejectsCycles(t *testing.T) {
+	graph := diamondGraph()
+	graph.Edges = append(graph.Edges, &Edge{From: "c", To: "a"})
+
+	engine := NewEngine(EngineConfig{}, &staticResolver{handler: &simpleHandler{}}, nil)
+	if _, err := engine.Run(graph); err == nil {
+		t.Fatal("expected error for cyclic graph")
+	}
+
+	diags := Validate(graph)
+	found := false
+	for _, d := range diags {
+		if d.Rule == "schedule_valid" && d.Severity == SeverityError {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected schedule_valid error diagnostic")
+	}
+}
+
+func TestParseScheduleAttributes(t *testing.T) {
+	graph, err := Parse(`digraph G { schedule = "dag"; max_parallel = 3; start [shape=Mdiamond] }`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if graph.Schedule != ScheduleDAG {
+		t.Errorf("expected schedule dag, got %q", graph.Schedule)
+	}
+	if graph.MaxParallel != 3 {
+		t.Errorf("expected max_parallel 3, got %d", graph.MaxParallel)
+	}
+}
@@ -0,0 +1,300 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AttrChange is a single attribute that differs between two graphs. Old is
+// empty for added attributes and New is empty for removed ones.
+type AttrChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// NodeChange lists the attribute changes of a node present in both graphs.
+type NodeChange struct {
+	ID    string       `json:"id"`
+	Attrs []AttrChange `json:"attrs"`
+}
+
+// EdgeChange lists the attribute changes of an edge present in both graphs.
+type EdgeChange struct {
+	Edge  string       `json:"edge"`
+	Attrs []AttrChange `json:"attrs"`
+}
+
+// GraphDiff is the structural difference between two pipeline graphs.
+// Edges are identified as "from -> to"; repeated edges between the same pair
+// are numbered "from -> to #2" in declaration order.
+type GraphDiff struct {
+	GraphAttrs   []AttrChange `json:"graph_attrs,omitempty"`
+	AddedNodes   []string     `json:"added_nodes,omitempty"`
+	RemovedNodes []string     `json:"removed_nodes,omitempty"`
+	ChangedNodes []NodeChange `json:"changed_nodes,omitempty"`
+	AddedEdges   []string     `json:"added_edges,omitempty"`
+	RemovedEdges []string     `json:"removed_edges,omitempty"`
+	ChangedEdges []EdgeChange `json:"changed_edges,omitempty"`
+	// Comments holds the source comments of added and removed nodes and
+	// edges, keyed by node ID or edge key, so the diff keeps their notes.
+	Comments map[string]string `json:"comments,omitempty"`
+}
+
+// Diff compares two graphs and reports added, removed, and changed nodes,
+// edges, and attributes. All lists are sorted. Comments are not compared,
+// but those of added and removed nodes and edges are kept in Comments.
+func Diff(a, b *Graph) *GraphDiff {
+	d := &GraphDiff{GraphAttrs: diffAttrs(graphAttrs(a), graphAttrs(b))}
+
+	for id, na := range a.Nodes {
+		nb, ok := b.Nodes[id]
+		if !ok {
+			d.RemovedNodes = append(d.RemovedNodes, id)
+			d.addComment(id, na.Comment)
+			continue
+		}
+		if changes := diffAttrs(nodeAttrs(na), nodeAttrs(nb)); len(changes) > 0 {
+			d.ChangedNodes = append(d.ChangedNodes, NodeChange{ID: id, Attrs: changes})
+		}
+	}
+	for id, nb := range b.Nodes {
+		if _, ok := a.Nodes[id]; !ok {
+			d.AddedNodes = append(d.AddedNodes, id)
+			d.addComment(id, nb.Comment)
+		}
+	}
+
+	ea, eb := keyedEdges(a.Edges), keyedEdges(b.Edges)
+	for key, edgeA := range ea {
+		edgeB, ok := eb[key]
+		if !ok {
+			d.RemovedEdges = append(d.RemovedEdges, key)
+			d.addComment(key, edgeA.Comment)
+			continue
+		}
+		if changes := diffAttrs(edgeAttrs(edgeA), edgeAttrs(edgeB)); len(changes) > 0 {
+			d.ChangedEdges = append(d.ChangedEdges, EdgeChange{Edge: key, Attrs: changes})
+		}
+	}
+	for key, edgeB := range eb {
+		if _, ok := ea[key]; !ok {
+			d.AddedEdges = append(d.AddedEdges, key)
+			d.addComment(key, edgeB.Comment)
+		}
+	}
+
+	sort.Strings(d.AddedNodes)
+	sort.Strings(d.RemovedNodes)
+	sort.Strings(d.AddedEdges)
+	sort.Strings(d.RemovedEdges)
+	sort.Slice(d.ChangedNodes, func(i, j int) bool { return d.ChangedNodes[i].ID < d.ChangedNodes[j].ID })
+	sort.Slice(d.ChangedEdges, func(i, j int) bool { return d.ChangedEdges[i].Edge < d.ChangedEdges[j].Edge })
+	return d
+}
+
+func (d *GraphDiff) addComment(key, comment string) {
+	if comment == "" {
+		return
+	}
+	if d.Comments == nil {
+		d.Comments = make(map[string]string)
+	}
+	d.Comments[key] = comment
+}
+
+// Empty reports whether the graphs are equivalent.
+func (d *GraphDiff) Empty() bool {
+	return len(d.GraphAttrs) == 0 &&
+		len(d.AddedNodes) == 0 && len(d.RemovedNodes) == 0 && len(d.ChangedNodes) == 0 &&
+		len(d.AddedEdges) == 0 && len(d.RemovedEdges) == 0 && len(d.ChangedEdges) == 0
+}
+
+// String renders the diff for humans: "+" added, "-" removed, "~" changed.
+func (d *GraphDiff) String() string {
+	if d.Empty() {
+		return "No differences.\n"
+	}
+	var b strings.Builder
+	writeAttrs := func(changes []AttrChange) {
+		for _, c := range changes {
+			switch {
+			case c.Old == "":
+				fmt.Fprintf(&b, "    + %s = %q\n", c.Name, c.New)
+			case c.New == "":
+				fmt.Fprintf(&b, "    - %s = %q\n", c.Name, c.Old)
+			default:
+				fmt.Fprintf(&b, "    ~ %s: %q -> %q\n", c.Name, c.Old, c.New)
+			}
+		}
+	}
+	// writeItem writes an added or removed node or edge and its comment.
+	writeItem := func(sign, key string) {
+		fmt.Fprintf(&b, "  %s %s\n", sign, key)
+		if comment := d.Comments[key]; comment != "" {
+			for _, line := range strings.Split(comment, "\n") {
+				fmt.Fprintf(&b, "      // %s\n", line)
+			}
+		}
+	}
+
+	if len(d.GraphAttrs) > 0 {
+		b.WriteString("Graph attributes:\n")
+		writeAttrs(d.GraphAttrs)
+	}
+	if len(d.AddedNodes)+len(d.RemovedNodes)+len(d.ChangedNodes) > 0 {
+		b.WriteString("Nodes:\n")
+		for _, id := range d.AddedNodes {
+			writeItem("+", id)
+		}
+		for _, id := range d.RemovedNodes {
+			writeItem("-", id)
+		}
+		for _, c := range d.ChangedNodes {
+			fmt.Fprintf(&b, "  ~ %s\n", c.ID)
+			writeAttrs(c.Attrs)
+		}
+	}
+	if len(d.AddedEdges)+len(d.RemovedEdges)+len(d.ChangedEdges) > 0 {
+		b.WriteString("Edges:\n")
+		for _, key := range d.AddedEdges {
+			writeItem("+", key)
+		}
+		for _, key := range d.RemovedEdges {
+			writeItem("-", key)
+		}
+		for _, c := range d.ChangedEdges {
+			fmt.Fprintf(&b, "  ~ %s\n", c.Edge)
+			writeAttrs(c.Attrs)
+		}
+	}
+	return b.String()
+}
+
+// diffAttrs compares two attribute maps, sorted by name.
+func diffAttrs(a, b map[string]string) []AttrChange {
+	var changes []AttrChange
+	for name, old := range a {
+		if v := b[name]; v != old {
+			changes = append(changes, AttrChange{Name: name, Old: old, New: v})
+		}
+	}
+	for name, v := range b {
+		if _, ok := a[name]; !ok {
+			changes = append(changes, AttrChange{Name: name, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// keyedEdges indexes edges by "from -> to", numbering repeats.
+func keyedEdges(edges []*Edge) map[string]*Edge {
+	keyed := make(map[string]*Edge, len(edges))
+	seen := make(map[string]int)
+	for _, e := range edges {
+		key := e.From + " -> " + e.To
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			key += " #" + strconv.Itoa(n)
+		}
+		keyed[key] = e
+	}
+	return keyed
+}
+
+// setAttr records a non-zero attribute value.
+func setAttr(attrs map[string]string, name, value string) {
+	if value != "" {
+		attrs[name] = value
+	}
+}
+
+func graphAttrs(g *Graph) map[string]string {
+	attrs := make(map[string]string, len(g.Attrs)+4)
+	for k, v := range g.Attrs {
+		setAttr(attrs, k, v)
+	}
+	setAttr(attrs, "goal", g.Goal)
+	setAttr(attrs, "label", g.Label)
+	setAttr(attrs, "model_stylesheet", g.ModelStylesheet)
+	setAttr(attrs, "default_fidelity", g.DefaultFidelity)
+	setAttr(attrs, "retry_target", g.RetryTarget)
+	setAttr(attrs, "fallback_retry_target", g.FallbackRetryTarget)
+	setAttr(attrs, "schedule", g.Schedule)
+	if g.DefaultMaxRetry != 0 {
+		attrs["default_max_retry"] = strconv.Itoa(g.DefaultMaxRetry)
+	}
+	if g.MaxTokens != 0 {
+		attrs["max_tokens"] = strconv.Itoa(g.MaxTokens)
+	}
+	if g.MaxCostUSD != 0 {
+		attrs["max_cost_usd"] = strconv.FormatFloat(g.MaxCostUSD, 'f', -1, 64)
+	}
+	if g.MaxParallel != 0 {
+		attrs["max_parallel"] = strconv.Itoa(g.MaxParallel)
+	}
+	if g.RequestsPerMinute != 0 {
+		attrs["requests_per_minute"] = strconv.Itoa(g.RequestsPerMinute)
+	}
+	if g.TokensPerMinute != 0 {
+		attrs["tokens_per_minute"] = strconv.Itoa(g.TokensPerMinute)
+	}
+	return attrs
+}
+
+func nodeAttrs(n *Node) map[string]string {
+	attrs := make(map[string]string, len(n.Attrs)+4)
+	for k, v := range n.Attrs {
+		setAttr(attrs, k, v)
+	}
+	setAttr(attrs, "label", n.Label)
+	setAttr(attrs, "shape", n.Shape)
+	setAttr(attrs, "type", n.Type)
+	setAttr(attrs, "prompt", n.Prompt)
+	setAttr(attrs, "retry_target", n.RetryTarget)
+	setAttr(attrs, "fallback_retry_target", n.FallbackRetryTarget)
+	setAttr(attrs, "fidelity", n.Fidelity)
+	setAttr(attrs, "thread_id", n.ThreadID)
+	setAttr(attrs, "class", n.Class)
+	setAttr(attrs, "llm_model", n.LLMModel)
+	setAttr(attrs, "llm_provider", n.LLMProvider)
+	setAttr(attrs, "reasoning_effort", n.ReasoningEffort)
+	if n.MaxRetries != 0 {
+		attrs["max_retries"] = strconv.Itoa(n.MaxRetries)
+	}
+	if n.Timeout != 0 {
+		attrs["timeout"] = n.Timeout.String()
+	}
+	if n.GoalGate {
+		attrs["goal_gate"] = "true"
+	}
+	if n.AutoStatus {
+		attrs["auto_status"] = "true"
+	}
+	if n.AllowPartial {
+		attrs["allow_partial"] = "true"
+	}
+	return attrs
+}
+
+func edgeAttrs(e *Edge) map[string]string {
+	attrs := make(map[string]string, 4)
+	setAttr(attrs, "label", e.Label)
+	setAttr(attrs, "condition", e.Condition)
+	setAttr(attrs, "fidelity", e.Fidelity)
+	setAttr(attrs, "thread_id", e.ThreadID)
+	setAttr(attrs, "tailport", e.TailPort)
+	setAttr(attrs, "headport", e.HeadPort)
+	if e.WeightExpr != "" {
+		attrs["weight"] = e.WeightExpr
+	} else if e.Weight != 0 {
+		attrs["weight"] = strconv.Itoa(e.Weight)
+	}
+	if e.LoopRestart {
+		attrs["loop_restart"] = "true"
+	}
+	return attrs
+}
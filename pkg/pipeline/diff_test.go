@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := Parse(`digraph p {
+		goal = "Ship it"
+		start [shape=Mdiamond]
+		plan [prompt="Plan the work", max_retries=2]
+		build [prompt="Build"]
+		exit [shape=Msquare]
+		start -> plan -> build -> exit
+		build -> plan [condition="outcome=fail"]
+	}`)
+	if err != nil {
+		t.Fatalf("parse a: %v", err)
+	}
+	b, err := Parse(`digraph p {
+		goal = "Ship it safely"
+		start [shape=Mdiamond]
+		plan [prompt="Plan the work carefully", max_retries=2, llm_model="gpt-4.1"]
+		test [prompt="Run tests"]
+		exit [shape=Msquare]
+		start -> plan -> test -> exit
+		test -> plan [condition="outcome=fail", label="retry"]
+	}`)
+	if err != nil {
+		t.Fatalf("parse b: %v", err)
+	}
+
+	d := Diff(a, b)
+	if len(d.GraphAttrs) != 1 || d.GraphAttrs[0] != (AttrChange{Name: "goal", Old: "Ship it", New: "Ship it safely"}) {
+		t.Errorf("unexpected graph attr changes: %+v", d.GraphAttrs)
+	}
+	if strings.Join(d.AddedNodes, ",") != "test" || strings.Join(d.RemovedNodes, ",") != "build" {
+		t.Errorf("unexpected node adds/removes: +%v -%v", d.AddedNodes, d.RemovedNodes)
+	}
+	if len(d.ChangedNodes) != 1 || d.ChangedNodes[0].ID != "plan" || len(d.ChangedNodes[0].Attrs) != 2 {
+		t.Fatalf("unexpected node changes: %+v", d.ChangedNodes)
+	}
+	if c := d.ChangedNodes[0].Attrs[0]; c.Name != "llm_model" || c.Old != "" || c.New != "gpt-4.1" {
+		t.Errorf("unexpected llm_model change: %+v", c)
+	}
+	if strings.Join(d.AddedEdges, ",") != "plan -> test,test -> exit,test -> plan" {
+		t.Errorf("unexpected added edges: %v", d.AddedEdges)
+	}
+	if strings.Join(d.RemovedEdges, ",") != "build -> exit,build -> plan,plan -> build" {
+		t.Errorf("unexpected removed edges: %v", d.RemovedEdges)
+	}
+
+	out := d.String()
+	for _, want := range []string{"  + test\n", "  - build\n", "  ~ plan\n", `    ~ prompt: "Plan the work" -> "Plan the work carefully"`, "  + test -> plan\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	if d := Diff(a, a); !d.Empty() || d.String() != "No differences.\n" {
+		t.Errorf("expected no differences, got %+v", d)
+	}
+}
+
+func TestDiffComments(t *testing.T) {
+	a, err := Parse(`digraph p {
+		start [shape=Mdiamond]
+		// Old plan.
+		plan [prompt="Plan"]
+		exit [shape=Msquare]
+		start -> plan -> exit
+	}`)
+	if err != nil {
+		t.Fatalf("Parse a: %v", err)
+	}
+	b, err := Parse(`digraph p {
+		start [shape=Mdiamond]
+		// Check the plan
+		// with a human.
+		review [shape=hexagon]
+		exit [shape=Msquare]
+		start -> review -> exit // Approved.
+	}`)
+	if err != nil {
+		t.Fatalf("Parse b: %v", err)
+	}
+
+	out := Diff(a, b).String()
+	for _, want := range []string{
+		"  + review\n      // Check the plan\n      // with a human.\n",
+		"  - plan\n      // Old plan.\n",
+		"  + review -> exit\n      // Approved.\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	// Comments alone are not differences.
+	b, _ = Parse(`digraph p {
+		start [shape=Mdiamond]
+		plan [prompt="Plan"] // New note.
+		exit [shape=Msquare]
+		start -> plan -> exit
+	}`)
+	if d := Diff(a, b); !d.Empty() {
+		t.Errorf("expected a comment change not to be a difference, got %+v", d)
+	}
+}
+
+func TestDiffRepeatedEdges(t *testing.T) {
+	a := makeSimpleGraph()
+	a.Edges = append(a.Edges, &Edge{From: "a", To: "exit", Condition: "outcome=fail"})
+	b := makeSimpleGraph()
+	b.Edges = append(b.Edges, &Edge{From: "a", To: "exit", Condition: "outcome=partial_success"})
+
+	d := Diff(a, b)
+	if len(d.ChangedEdges) != 1 || d.ChangedEdges[0].Edge != "a -> exit #2" {
+		t.Fatalf("unexpected edge changes: %+v", d.ChangedEdges)
+	}
+	if len(d.AddedEdges) != 0 || len(d.RemovedEdges) != 0 {
+		t.Errorf("expected no added or removed edges, got +%v -%v", d.AddedEdges, d.RemovedEdges)
+	}
+}
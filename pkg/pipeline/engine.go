@@ -1,17 +1,22 @@
 package pipeline
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // Handler is the interface for node execution (mirrors handler package to avoid circular import).
@@ -19,7 +24,8 @@ type Handler interface {
 	Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error)
 }
 
-// HandlerResolver resolves the appropriate handler for a node.
+// HandlerResolver resolves the appropriate handler for a node. Parallel
+// nodes are never resolved: the engine fans their branches out itself.
 type HandlerResolver interface {
 	Resolve(node *Node) Handler
 }
@@ -27,6 +33,32 @@ type HandlerResolver interface {
 // EngineConfig configures the pipeline engine.
 type EngineConfig struct {
 	LogsRoot string
+	// Secrets, if set, is used to redact resolved secret values from checkpoints.
+	Secrets *secrets.Store
+	// Middleware wraps every handler invocation; see Engine.Use.
+	Middleware []Middleware
+	// MaxContextValueBytes is the JSON size above which a context value is
+	// saved to context/<key>.json rather than inline in checkpoint.json.
+	// Zero means DefaultMaxContextValueBytes; negative keeps every value
+	// inline.
+	MaxContextValueBytes int
+	// Scrubber, if set, redacts context values whose keys look like secrets
+	// from checkpoints.
+	Scrubber *secrets.Scrubber
+	// Cipher, if set, encrypts checkpoint.json and spilled context values.
+	Cipher *secrets.Cipher
+	// CheckpointHistory is how many numbered checkpoints are kept in
+	// checkpoints/ for recovery from a corrupt checkpoint.json. Zero means
+	// DefaultCheckpointHistory; negative keeps none.
+	CheckpointHistory int
+	// Cache, if set, skips cacheable stages whose inputs match an earlier
+	// successful run and reuses its outcome.
+	Cache *StageCache
+	// Context, if set, stops the run once it is done: no further stage
+	// starts, retry waits are cut short, and the run returns its partial
+	// result with an error wrapping the context's error. Stages already
+	// running are not interrupted.
+	Context context.Context
 }
 
 // Engine orchestrates pipeline execution.
@@ -34,43 +66,116 @@ type Engine struct {
 	config         EngineConfig
 	handlerResolver HandlerResolver
 	emitter        *events.Emitter
+
+	checkpointMu  sync.Mutex
+	checkpointSeq int // last checkpoint sequence number written
 }
 
-// NewEngine creates a new pipeline engine.
-func NewEngine(config EngineConfig, resolver HandlerResolver, emitter *events.Emitter) *Engine {
+// EngineOption configures an Engine.
+type EngineOption func(*Engine)
+
+// WithEventHandler calls fn with every event the engine emits, on the
+// goroutine that emitted it. Parallel branches and dag stages emit
+// concurrently, so fn must be safe for concurrent use. It is the hook for
+// embedding applications driving progress bars or dashboards; Event.Typed
+// gives each event as a struct such as events.StageCompleted. fn is added to
+// the engine's emitter, so with a shared emitter it also sees the events of
+// other engines.
+func WithEventHandler(fn func(events.Event)) EngineOption {
+	return func(e *Engine) {
+		e.emitter.On(fn)
+	}
+}
+
+// NewEngine creates a new pipeline engine. emitter may be nil.
+func NewEngine(config EngineConfig, resolver HandlerResolver, emitter *events.Emitter, opts ...EngineOption) *Engine {
 	if emitter == nil {
 		emitter = events.NewEmitter()
 	}
-	return &Engine{
+	e := &Engine{
 		config:          config,
 		handlerResolver: resolver,
 		emitter:         emitter,
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
-// RunResult is the final result of a pipeline run.
+// RunResult is the final result of a pipeline run. Usage is the run's total
+// token usage and cost; NodeUsage breaks it down by node, summing every time
+// a node ran. LogsRoot is the run's logs directory, set by the Runner.
 type RunResult struct {
 	Status         StageStatus
 	CompletedNodes []string
 	FinalOutcome   *Outcome
 	NodeOutcomes   map[string]*Outcome
+	Usage          Usage
+	NodeUsage      map[string]Usage
+	BudgetExceeded bool
+	LogsRoot       string
+}
+
+// usageTally accumulates a run's usage in total and by node.
+type usageTally struct {
+	total  Usage
+	byNode map[string]Usage
 }
 
-// Run executes a pipeline graph.
+// add counts u, if any, toward the run and nodeID.
+func (t *usageTally) add(nodeID string, u *Usage) {
+	if u == nil {
+		return
+	}
+	t.total = t.total.Add(*u)
+	if t.byNode == nil {
+		t.byNode = make(map[string]Usage)
+	}
+	t.byNode[nodeID] = t.byNode[nodeID].Add(*u)
+}
+
+// Run executes a pipeline graph. Graphs with schedule="dag" are run by the
+// dependency scheduler; all others are walked one stage at a time.
 func (e *Engine) Run(graph *Graph) (*RunResult, error) {
+	if err := checkNodeIDs(graph); err != nil {
+		return nil, err
+	}
+	if graph.Schedule == ScheduleDAG {
+		return e.runDAG(graph, nil)
+	}
+	return e.run(graph, nil)
+}
+
+// checkNodeIDs rejects graphs built in code with node IDs that Parse would
+// refuse, since each ID names a directory under the logs root.
+func checkNodeIDs(graph *Graph) error {
+	for id := range graph.Nodes {
+		if !localNodeID(id) {
+			return fmt.Errorf("invalid node ID %q: node IDs name log directories and can't contain path separators or \"..\"", id)
+		}
+	}
+	return nil
+}
+
+// run walks graph from its start node, or with a seed, from the seed's node
+// with the seed's context.
+func (e *Engine) run(graph *Graph, seed *resumeState) (*RunResult, error) {
 	startTime := time.Now()
 	pipelineID := fmt.Sprintf("run-%d", time.Now().UnixNano())
 
 	e.emitter.EmitPipelineStarted(graph.Name, pipelineID)
 
 	ctx := NewContext()
+	completedNodes, nodeOutcomes := seed.seed(graph, ctx)
 	mirrorGraphAttributes(graph, ctx)
-
-	var completedNodes []string
-	nodeOutcomes := make(map[string]*Outcome)
+	var usage usageTally
 
 	// Find start node
 	startNode := e.findStartNode(graph)
+	if seed != nil {
+		startNode = graph.Nodes[seed.start]
+	}
 	if startNode == nil {
 		err := fmt.Errorf("no start node found")
 		e.emitter.EmitPipelineFailed(err.Error(), time.Since(startTime))
@@ -81,6 +186,18 @@ func (e *Engine) Run(graph *Graph) (*RunResult, error) {
 	stageIndex := 0
 
 	for {
+		if err := e.cancelled(); err != nil {
+			e.emitter.EmitPipelineFailed(err.Error(), time.Since(startTime))
+			return &RunResult{
+				Status:         StatusFail,
+				CompletedNodes: completedNodes,
+				FinalOutcome:   &Outcome{Status: StatusFail, FailureReason: err.Error()},
+				NodeOutcomes:   nodeOutcomes,
+				Usage:          usage.total,
+				NodeUsage:      usage.byNode,
+			}, err
+		}
+
 		node := graph.Nodes[currentNode.ID]
 		if node == nil {
 			err := fmt.Errorf("node %q not found in graph", currentNode.ID)
@@ -105,33 +222,53 @@ func (e *Engine) Run(graph *Graph) (*RunResult, error) {
 					Status:         StatusFail,
 					CompletedNodes: completedNodes,
 					NodeOutcomes:   nodeOutcomes,
+					Usage:          usage.total,
+					NodeUsage:      usage.byNode,
 				}, nil
 			}
 			break
 		}
 
 		// Step 2: Execute node handler with retry
-		e.emitter.EmitStageStarted(node.Label, stageIndex)
+		e.emitter.EmitTyped(events.StageStarted{Name: node.Label, NodeID: node.ID, Index: stageIndex})
 		stageStart := time.Now()
 
-		retryPolicy := buildRetryPolicy(node, graph)
-		outcome, err := e.executeWithRetry(node, ctx, graph, retryPolicy, stageIndex)
-		if err != nil {
-			e.emitter.EmitStageFailed(node.Label, stageIndex, err.Error(), false)
-			e.emitter.EmitPipelineFailed(err.Error(), time.Since(startTime))
-			return nil, err
+		// Parallel nodes are fanned out by the engine itself so that each
+		// branch runs concurrently with an isolated context.
+		var outcome *Outcome
+		joinNode := ""
+		if isParallel(node) {
+			var branches []*BranchResult
+			outcome, branches, joinNode = e.executeParallel(node, ctx, graph, stageIndex, e.config.LogsRoot)
+			for _, b := range branches {
+				completedNodes = append(completedNodes, b.CompletedNodes...)
+				for id, o := range b.NodeOutcomes {
+					nodeOutcomes[id] = o
+					usage.add(id, o.Usage)
+				}
+			}
+		} else {
+			retryPolicy := buildRetryPolicy(node, graph)
+			var err error
+			outcome, err = e.executeWithRetry(node, ctx, graph, retryPolicy, stageIndex, e.config.LogsRoot)
+			if err != nil {
+				e.emitter.EmitTyped(events.StageFailed{Name: node.Label, NodeID: node.ID, Index: stageIndex, Error: err.Error()})
+				e.emitter.EmitPipelineFailed(err.Error(), time.Since(startTime))
+				return nil, err
+			}
 		}
 
 		stageDuration := time.Since(stageStart)
 		if outcome.Status == StatusSuccess || outcome.Status == StatusPartialSuccess {
-			e.emitter.EmitStageCompleted(node.Label, stageIndex, stageDuration)
+			e.emitter.EmitTyped(events.StageCompleted{Name: node.Label, NodeID: node.ID, Index: stageIndex, Duration: stageDuration})
 		} else {
-			e.emitter.EmitStageFailed(node.Label, stageIndex, outcome.FailureReason, false)
+			e.emitter.EmitTyped(events.StageFailed{Name: node.Label, NodeID: node.ID, Index: stageIndex, Error: outcome.FailureReason})
 		}
 
 		// Step 3: Record completion
 		completedNodes = append(completedNodes, node.ID)
 		nodeOutcomes[node.ID] = outcome
+		usage.add(node.ID, outcome.Usage)
 
 		// Step 4: Apply context updates
 		ctx.ApplyUpdates(outcome.ContextUpdates)
@@ -159,16 +296,37 @@ func (e *Engine) Run(graph *Graph) (*RunResult, error) {
 			Timestamp:      time.Now(),
 			CurrentNode:    node.ID,
 			CompletedNodes: completedNodes,
+			NodeStatuses:   nodeStatuses(nodeOutcomes),
 			NodeRetries:    make(map[string]int),
 			ContextValues:  ctx.Snapshot(),
 			Logs:           ctx.Logs(),
 		}
-		if e.config.LogsRoot != "" {
-			cp.Save(filepath.Join(e.config.LogsRoot, "checkpoint.json"))
-			e.emitter.EmitCheckpointSaved(node.ID)
+		e.saveCheckpoint(cp)
+
+		// Step 5b: Enforce run budget; remaining stages are skipped.
+		if reason := budgetExceeded(graph, usage.total); reason != "" {
+			e.emitter.EmitPipelineFailed(reason, time.Since(startTime))
+			return &RunResult{
+				Status:         StatusFail,
+				CompletedNodes: completedNodes,
+				FinalOutcome: &Outcome{
+					Status:        StatusFail,
+					FailureReason: reason,
+				},
+				NodeOutcomes:   nodeOutcomes,
+				Usage:          usage.total,
+				NodeUsage:      usage.byNode,
+				BudgetExceeded: true,
+			}, nil
 		}
 
-		// Step 6: Select next edge
+		// Step 6: Select next edge; a parallel fan-out resumes at the node
+		// its branches converged on.
+		if joinNode != "" {
+			currentNode = graph.Nodes[joinNode]
+			stageIndex++
+			continue
+		}
 		nextEdge := selectEdge(node, outcome, ctx, graph)
 		if nextEdge == nil {
 			if outcome.Status == StatusFail {
@@ -179,6 +337,8 @@ func (e *Engine) Run(graph *Graph) (*RunResult, error) {
 					CompletedNodes: completedNodes,
 					FinalOutcome:   outcome,
 					NodeOutcomes:   nodeOutcomes,
+					Usage:          usage.total,
+					NodeUsage:      usage.byNode,
 				}, nil
 			}
 			break
@@ -211,9 +371,116 @@ func (e *Engine) Run(graph *Graph) (*RunResult, error) {
 		Status:         finalStatus,
 		CompletedNodes: completedNodes,
 		NodeOutcomes:   nodeOutcomes,
+		Usage:          usage.total,
+		NodeUsage:      usage.byNode,
 	}, nil
 }
 
+// cancelled returns an error if the engine's context is done.
+func (e *Engine) cancelled() error {
+	if e.config.Context == nil || e.config.Context.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("pipeline cancelled: %w", e.config.Context.Err())
+}
+
+// sleep waits for d, or until the engine's context is done, and returns
+// cancelled's error.
+func (e *Engine) sleep(d time.Duration) error {
+	if e.config.Context == nil {
+		time.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-e.config.Context.Done():
+	}
+	return e.cancelled()
+}
+
+// saveCheckpoint writes cp to the logs root, if any. Resolved secret values
+// are redacted so they never reach checkpoint.json.
+func (e *Engine) saveCheckpoint(cp *Checkpoint) {
+	if e.config.LogsRoot == "" {
+		return
+	}
+	scrubbed := e.config.Scrubber.ScrubMap(cp.ContextValues)
+	cp.ScrubbedKeys = nil
+	for k, v := range scrubbed {
+		if !reflect.DeepEqual(v, cp.ContextValues[k]) {
+			cp.ScrubbedKeys = append(cp.ScrubbedKeys, k)
+		}
+	}
+	sort.Strings(cp.ScrubbedKeys)
+	cp.ContextValues = scrubbed
+	maxBytes := e.config.MaxContextValueBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxContextValueBytes
+	}
+	if maxBytes > 0 {
+		if err := spillContext(cp, e.config.LogsRoot, maxBytes, e.encodeCheckpointData); err != nil {
+			return
+		}
+	}
+
+	e.checkpointMu.Lock()
+	defer e.checkpointMu.Unlock()
+	if e.checkpointSeq == 0 {
+		// Continue numbering after a previous run in the same directory.
+		if seqs := checkpointHistory(e.config.LogsRoot); len(seqs) > 0 {
+			e.checkpointSeq = seqs[len(seqs)-1]
+		}
+	}
+	e.checkpointSeq++
+	cp.Sequence = e.checkpointSeq
+
+	data, err := cp.marshal()
+	if err != nil {
+		return
+	}
+	// The checksum covers the file as written, so it is computed after
+	// redaction; encryption wraps it.
+	data = withChecksum([]byte(e.config.Secrets.Redact(string(data))))
+	if data, err = e.config.Cipher.Seal(data); err != nil {
+		return
+	}
+
+	history := e.config.CheckpointHistory
+	if history == 0 {
+		history = DefaultCheckpointHistory
+	}
+	if history > 0 {
+		if err := writeFileAtomic(checkpointHistoryPath(e.config.LogsRoot, cp.Sequence), data); err != nil {
+			return
+		}
+		pruneCheckpointHistory(e.config.LogsRoot, history)
+	}
+	if err := writeFileAtomic(filepath.Join(e.config.LogsRoot, "checkpoint.json"), data); err != nil {
+		return
+	}
+	e.emitter.EmitCheckpointSaved(cp.CurrentNode)
+}
+
+// encodeCheckpointData redacts resolved secrets from checkpoint data and
+// encrypts it if the engine has a cipher.
+func (e *Engine) encodeCheckpointData(data []byte) ([]byte, error) {
+	return e.config.Cipher.Seal([]byte(e.config.Secrets.Redact(string(data))))
+}
+
+// budgetExceeded returns a failure reason if usage exceeds the graph's
+// max_tokens or max_cost_usd budget, or "" if the run is within budget.
+func budgetExceeded(graph *Graph, usage Usage) string {
+	if graph.MaxTokens > 0 && usage.TotalTokens > graph.MaxTokens {
+		return fmt.Sprintf("budget exceeded: used %d tokens, max_tokens is %d", usage.TotalTokens, graph.MaxTokens)
+	}
+	if graph.MaxCostUSD > 0 && usage.CostUSD > graph.MaxCostUSD {
+		return fmt.Sprintf("budget exceeded: spent $%.4f, max_cost_usd is $%.4f", usage.CostUSD, graph.MaxCostUSD)
+	}
+	return ""
+}
+
 func (e *Engine) findStartNode(graph *Graph) *Node {
 	for _, node := range graph.Nodes {
 		if node.Shape == "Mdiamond" {
@@ -232,6 +499,15 @@ func isTerminal(node *Node) bool {
 	return node.Shape == "Msquare"
 }
 
+// nodeStatuses returns the status of each outcome, for a checkpoint.
+func nodeStatuses(outcomes map[string]*Outcome) map[string]StageStatus {
+	statuses := make(map[string]StageStatus, len(outcomes))
+	for id, o := range outcomes {
+		statuses[id] = o.Status
+	}
+	return statuses
+}
+
 func checkGoalGates(graph *Graph, nodeOutcomes map[string]*Outcome) (bool, *Node) {
 	for nodeID, outcome := range nodeOutcomes {
 		node := graph.Nodes[nodeID]
@@ -284,7 +560,7 @@ func buildRetryPolicy(node *Node, graph *Graph) RetryPolicy {
 	}
 }
 
-func (e *Engine) executeWithRetry(node *Node, ctx *Context, graph *Graph, policy RetryPolicy, stageIndex int) (*Outcome, error) {
+func (e *Engine) executeWithRetry(node *Node, ctx *Context, graph *Graph, policy RetryPolicy, stageIndex int, logsRoot string) (*Outcome, error) {
 	handler := e.handlerResolver.Resolve(node)
 	if handler == nil {
 		return &Outcome{
@@ -293,18 +569,46 @@ func (e *Engine) executeWithRetry(node *Node, ctx *Context, graph *Graph, policy
 		}, nil
 	}
 
+	execute := e.chain(func(node *Node, ctx *Context) (*Outcome, error) {
+		if node.HandlerType() != "wait.human" {
+			return handler.Execute(node, ctx, graph, logsRoot)
+		}
+		// Human gates block on an answer; announce them so listeners such
+		// as notification sinks can alert whoever has to respond.
+		start := time.Now()
+		e.emitter.EmitInterviewStarted(node.Label, node.ID)
+		defer func() { e.emitter.EmitInterviewCompleted(node.Label, node.ID, time.Since(start)) }()
+		return handler.Execute(node, ctx, graph, logsRoot)
+	})
+
 	maxAttempts := policy.MaxAttempts
 	if maxAttempts < 1 {
 		maxAttempts = 1
 	}
 
+	cacheKey := ""
+	if e.config.Cache.Cacheable(node) {
+		cacheKey = e.config.Cache.Key(node, ctx, graph)
+	}
+	if cacheKey != "" {
+		if outcome, ok := e.config.Cache.Get(cacheKey, e.config.Cipher); ok {
+			e.emitter.EmitTyped(events.StageCached{Name: node.Label, NodeID: node.ID, Branch: e.stageBranch(logsRoot), Index: stageIndex, Key: cacheKey})
+			return e.cachedOutcome(node, outcome, logsRoot), nil
+		}
+	}
+
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		outcome, err := handler.Execute(node, ctx, graph, e.config.LogsRoot)
+		outcome, err := execute(node, ctx)
+		if err == nil && outcome == nil {
+			err = fmt.Errorf("handler for node %q returned no outcome", node.ID)
+		}
 		if err != nil {
-			if attempt < maxAttempts {
-				delay := delayForAttempt(attempt, policy)
-				e.emitter.EmitStageRetrying(node.Label, stageIndex, attempt, delay)
-				time.Sleep(delay)
+			delay, ok := retryDelayForError(err, attempt, policy)
+			if ok && attempt < maxAttempts {
+				e.emitter.EmitTyped(events.StageRetrying{Name: node.Label, NodeID: node.ID, Branch: e.stageBranch(logsRoot), Index: stageIndex, Attempt: attempt, Delay: delay})
+				if err := e.sleep(delay); err != nil {
+					return &Outcome{Status: StatusFail, FailureReason: err.Error()}, nil
+				}
 				continue
 			}
 			return &Outcome{
@@ -314,14 +618,19 @@ func (e *Engine) executeWithRetry(node *Node, ctx *Context, graph *Graph, policy
 		}
 
 		if outcome.Status == StatusSuccess || outcome.Status == StatusPartialSuccess {
+			if cacheKey != "" && !e.holdsSecrets(outcome) {
+				e.config.Cache.Put(cacheKey, outcome, e.config.Cipher)
+			}
 			return outcome, nil
 		}
 
 		if outcome.Status == StatusRetry {
 			if attempt < maxAttempts {
 				delay := delayForAttempt(attempt, policy)
-				e.emitter.EmitStageRetrying(node.Label, stageIndex, attempt, delay)
-				time.Sleep(delay)
+				e.emitter.EmitTyped(events.StageRetrying{Name: node.Label, NodeID: node.ID, Branch: e.stageBranch(logsRoot), Index: stageIndex, Attempt: attempt, Delay: delay})
+				if err := e.sleep(delay); err != nil {
+					return &Outcome{Status: StatusFail, FailureReason: err.Error()}, nil
+				}
 				continue
 			}
 			if node.AllowPartial {
@@ -347,6 +656,64 @@ func (e *Engine) executeWithRetry(node *Node, ctx *Context, graph *Graph, policy
 	}, nil
 }
 
+// cachedOutcome prepares an outcome reused from the stage cache: it used no
+// tokens this run, and it is written to the stage's status.json as the
+// handler would have.
+func (e *Engine) cachedOutcome(node *Node, outcome *Outcome, logsRoot string) *Outcome {
+	outcome.Usage = nil
+	if outcome.Notes == "" {
+		outcome.Notes = "cached"
+	} else {
+		outcome.Notes = "cached: " + outcome.Notes
+	}
+	if logsRoot != "" {
+		data, _ := json.MarshalIndent(outcome, "", "  ")
+		writeFile(filepath.Join(logsRoot, node.ID, "status.json"), data)
+	}
+	return outcome
+}
+
+// holdsSecrets reports whether outcome contains a resolved secret or a
+// context value the scrubber would redact. Such outcomes aren't cached:
+// stored redacted, they would replay "[REDACTED]" into later runs.
+func (e *Engine) holdsSecrets(outcome *Outcome) bool {
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		return true
+	}
+	if e.config.Secrets.Redact(string(data)) != string(data) {
+		return true
+	}
+	scrubbed, err := json.Marshal(e.config.Scrubber.ScrubMap(outcome.ContextUpdates))
+	if err != nil {
+		return true
+	}
+	updates, _ := json.Marshal(outcome.ContextUpdates)
+	return string(scrubbed) != string(updates)
+}
+
+// retryDelayer is implemented by errors that say how long to wait before
+// retrying, such as a rate-limited *llm.LLMError.
+type retryDelayer interface {
+	RetryDelay() time.Duration
+}
+
+// retryDelayForError returns the delay before retrying after err: the wait
+// the error asks for, or else the policy's backoff. It returns false if the
+// requested wait exceeds the policy's MaxDelay, so the stage fails now
+// instead of retrying before a rate limit resets.
+func retryDelayForError(err error, attempt int, policy RetryPolicy) (time.Duration, bool) {
+	var rd retryDelayer
+	if errors.As(err, &rd) {
+		if wait := rd.RetryDelay(); wait > policy.MaxDelay {
+			return 0, false
+		} else if wait > 0 {
+			return wait, true
+		}
+	}
+	return delayForAttempt(attempt, policy), true
+}
+
 func delayForAttempt(attempt int, policy RetryPolicy) time.Duration {
 	delay := float64(policy.InitialDelay) * math.Pow(policy.BackoffFactor, float64(attempt-1))
 	if delay > float64(policy.MaxDelay) {
@@ -375,7 +742,7 @@ func selectEdge(node *Node, outcome *Outcome, ctx *Context, graph *Graph) *Edge
 		}
 	}
 	if len(conditionMatched) > 0 {
-		return bestByWeightThenLexical(conditionMatched)
+		return bestByWeightThenLexical(conditionMatched, outcome, ctx)
 	}
 
 	// Step 2: Preferred label
@@ -406,20 +773,26 @@ func selectEdge(node *Node, outcome *Outcome, ctx *Context, graph *Graph) *Edge
 		}
 	}
 	if len(unconditional) > 0 {
-		return bestByWeightThenLexical(unconditional)
+		return bestByWeightThenLexical(unconditional, outcome, ctx)
 	}
 
 	// Fallback: any edge
-	return bestByWeightThenLexical(edges)
+	return bestByWeightThenLexical(edges, outcome, ctx)
 }
 
-func bestByWeightThenLexical(edges []*Edge) *Edge {
+// bestByWeightThenLexical returns the heaviest edge, evaluating weight
+// expressions against the outcome and context, and breaks ties by target.
+func bestByWeightThenLexical(edges []*Edge, outcome *Outcome, ctx *Context) *Edge {
 	if len(edges) == 0 {
 		return nil
 	}
+	weights := make(map[*Edge]float64, len(edges))
+	for _, e := range edges {
+		weights[e] = EdgeWeight(e, outcome, ctx)
+	}
 	sort.Slice(edges, func(i, j int) bool {
-		if edges[i].Weight != edges[j].Weight {
-			return edges[i].Weight > edges[j].Weight
+		if weights[edges[i]] != weights[edges[j]] {
+			return weights[edges[i]] > weights[edges[j]]
 		}
 		return edges[i].To < edges[j].To
 	})
@@ -464,6 +837,10 @@ func evaluateConditionSimple(condition string, outcome *Outcome, ctx *Context) b
 }
 
 func evaluateClauseSimple(clause string, outcome *Outcome, ctx *Context) bool {
+	if pattern, ok := ParseSetClause(clause); ok {
+		return outcome.Updated(pattern)
+	}
+
 	if idx := strings.Index(clause, "!="); idx >= 0 {
 		key := strings.TrimSpace(clause[:idx])
 		value := strings.TrimSpace(clause[idx+2:])
@@ -1,9 +1,18 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/bus"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // simpleHandler always returns SUCCESS.
@@ -559,3 +568,422 @@ func TestPipelineWith10PlusNodes(t *testing.T) {
 		t.Errorf("expected SUCCESS, got %s", result.Status)
 	}
 }
+
+// usageHandler returns SUCCESS with a fixed token usage.
+type usageHandler struct {
+	tokens int
+}
+
+func (h *usageHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	return &Outcome{
+		Status: StatusSuccess,
+		Usage:  &Usage{TotalTokens: h.tokens, CostUSD: float64(h.tokens) / 1000},
+	}, nil
+}
+
+func TestBudgetExceededStopsRun(t *testing.T) {
+	graph := &Graph{
+		Name:      "test",
+		MaxTokens: 150,
+		Nodes: map[string]*Node{
+			"start": {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"a":     {ID: "a", Shape: "box", Attrs: map[string]string{}},
+			"b":     {ID: "b", Shape: "box", Attrs: map[string]string{}},
+			"c":     {ID: "c", Shape: "box", Attrs: map[string]string{}},
+			"exit":  {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "a"},
+			{From: "a", To: "b"},
+			{From: "b", To: "c"},
+			{From: "c", To: "exit"},
+		},
+	}
+
+	resolver := &staticResolver{
+		handler: &usageHandler{tokens: 100},
+		special: map[string]Handler{"start": &simpleHandler{}},
+	}
+	engine := NewEngine(EngineConfig{}, resolver, nil)
+
+	result, err := engine.Run(graph)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Status != StatusFail {
+		t.Errorf("expected FAIL, got %s", result.Status)
+	}
+	if !result.BudgetExceeded {
+		t.Error("expected BudgetExceeded to be set")
+	}
+	if result.Usage.TotalTokens != 200 {
+		t.Errorf("expected 200 tokens used, got %d", result.Usage.TotalTokens)
+	}
+	for _, id := range result.CompletedNodes {
+		if id == "c" {
+			t.Error("stage c should have been skipped after budget was exceeded")
+		}
+	}
+}
+
+func TestEngineContextStopsRun(t *testing.T) {
+	for _, schedule := range []string{"", ScheduleDAG} {
+		t.Run("schedule="+schedule, func(t *testing.T) {
+			graph := makeSimpleGraph()
+			graph.Schedule = schedule
+			graph.Nodes["b"] = &Node{ID: "b", Shape: "box", Attrs: map[string]string{}}
+			graph.Edges = []*Edge{
+				{From: "start", To: "a"},
+				{From: "a", To: "b"},
+				{From: "b", To: "exit"},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// Cancel while a runs, as Ctrl+C would.
+			cancelOnA := func(node *Node, pctx *Context, next HandlerFunc) (*Outcome, error) {
+				if node.ID == "a" {
+					cancel()
+				}
+				return next(node, pctx)
+			}
+			engine := NewEngine(EngineConfig{Context: ctx, Middleware: []Middleware{cancelOnA}}, &staticResolver{handler: &simpleHandler{}}, nil)
+
+			result, err := engine.Run(graph)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected a cancellation error, got %v", err)
+			}
+			if result == nil || result.Status != StatusFail {
+				t.Fatalf("expected a failed partial result, got %+v", result)
+			}
+			if _, ok := result.NodeOutcomes["a"]; !ok {
+				t.Error("expected the running stage to finish")
+			}
+			if _, ok := result.NodeOutcomes["b"]; ok {
+				t.Error("expected no stage to start after cancellation")
+			}
+		})
+	}
+}
+
+func TestRunResultNodeUsage(t *testing.T) {
+	for _, schedule := range []string{"", ScheduleDAG} {
+		t.Run("schedule="+schedule, func(t *testing.T) {
+			graph := &Graph{
+				Name:     "test",
+				Schedule: schedule,
+				Nodes: map[string]*Node{
+					"start": {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+					"a":     {ID: "a", Shape: "box", Attrs: map[string]string{}},
+					"b":     {ID: "b", Shape: "box", Attrs: map[string]string{}},
+					"exit":  {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+				},
+				Edges: []*Edge{
+					{From: "start", To: "a"},
+					{From: "a", To: "b"},
+					{From: "b", To: "exit"},
+				},
+			}
+			resolver := &staticResolver{
+				handler: &usageHandler{tokens: 100},
+				special: map[string]Handler{"start": &simpleHandler{}, "exit": &simpleHandler{}},
+			}
+			result, err := NewEngine(EngineConfig{}, resolver, nil).Run(graph)
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if len(result.NodeUsage) != 2 {
+				t.Errorf("expected usage for a and b only, got %v", result.NodeUsage)
+			}
+			if u := result.NodeUsage["a"]; u.TotalTokens != 100 || u.CostUSD != 0.1 {
+				t.Errorf("usage of a = %+v", u)
+			}
+			if result.Usage.TotalTokens != 200 {
+				t.Errorf("expected 200 tokens in total, got %d", result.Usage.TotalTokens)
+			}
+		})
+	}
+}
+
+func TestParseBudgetAttributes(t *testing.T) {
+	graph, err := Parse(`digraph G { max_tokens = 5000; max_cost_usd = "2.5"; start [shape=Mdiamond] }`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if graph.MaxTokens != 5000 {
+		t.Errorf("expected max_tokens 5000, got %d", graph.MaxTokens)
+	}
+	if graph.MaxCostUSD != 2.5 {
+		t.Errorf("expected max_cost_usd 2.5, got %v", graph.MaxCostUSD)
+	}
+}
+
+// secretLeakHandler writes a resolved secret into the context and logs.
+type secretLeakHandler struct {
+	store *secrets.Store
+}
+
+func (h *secretLeakHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	v, err := h.store.Get("API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	ctx.AppendLog("called api with " + v)
+	return &Outcome{
+		Status:         StatusSuccess,
+		ContextUpdates: map[string]interface{}{"response": "token " + v + " accepted"},
+	}, nil
+}
+
+func TestSecretsRedactedFromCheckpointAndEvents(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_API_TOKEN", "s3cr3t-value")
+	store := secrets.FromEnv()
+	dir := t.TempDir()
+
+	graph := &Graph{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start": {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"call":  {ID: "call", Shape: "box", Attrs: map[string]string{}},
+			"exit":  {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "call"},
+			{From: "call", To: "exit"},
+		},
+	}
+	resolver := &staticResolver{
+		handler: &secretLeakHandler{store: store},
+		special: map[string]Handler{"start": &simpleHandler{}},
+	}
+
+	var leaked bool
+	runner := NewRunner(resolver, WithLogsRoot(dir), WithSecrets(store))
+	runner.OnEvent(func(ev events.Event) {
+		for _, v := range ev.Data {
+			if s, ok := v.(string); ok && strings.Contains(s, "s3cr3t-value") {
+				leaked = true
+			}
+		}
+	})
+	// Emit an event carrying the secret once it has been resolved.
+	store.Get("API_TOKEN")
+	runner.emitter.Emit(events.NewEvent("custom", map[string]interface{}{"msg": "s3cr3t-value"}))
+
+	if _, err := runner.RunGraph(graph); err != nil {
+		t.Fatalf("RunGraph failed: %v", err)
+	}
+	if leaked {
+		t.Error("secret value reached an event listener")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "checkpoint.json"))
+	if err != nil {
+		t.Fatalf("read checkpoint: %v", err)
+	}
+	if strings.Contains(string(data), "s3cr3t-value") {
+		t.Errorf("secret value written to checkpoint.json:\n%s", data)
+	}
+	if !strings.Contains(string(data), "[REDACTED:API_TOKEN]") {
+		t.Error("expected redaction marker in checkpoint.json")
+	}
+
+	journal, err := os.ReadFile(filepath.Join(dir, events.JournalFile))
+	if err != nil {
+		t.Fatalf("read event journal: %v", err)
+	}
+	if strings.Contains(string(journal), "s3cr3t-value") {
+		t.Errorf("secret value written to the event journal:\n%s", journal)
+	}
+}
+
+func TestRunnerWritesEventJournal(t *testing.T) {
+	dir := t.TempDir()
+	runner := NewRunner(&staticResolver{handler: &simpleHandler{}}, WithLogsRoot(dir))
+	var live []events.EventType
+	runner.OnEvent(func(ev events.Event) { live = append(live, ev.Type) })
+
+	if _, err := runner.RunGraph(makeSimpleGraph()); err != nil {
+		t.Fatalf("RunGraph failed: %v", err)
+	}
+
+	entries, err := events.ReadJournal(filepath.Join(dir, events.JournalFile))
+	if err != nil {
+		t.Fatalf("ReadJournal: %v", err)
+	}
+	if len(entries) != len(live) {
+		t.Fatalf("expected %d journal entries, got %d", len(live), len(entries))
+	}
+	for i, e := range entries {
+		if e.Type != live[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, live[i], e.Type)
+		}
+	}
+	if entries[0].Type != events.EventPipelineStarted || entries[len(entries)-1].Type != events.EventPipelineCompleted {
+		t.Errorf("expected the journal to span the run, got %s..%s", entries[0].Type, entries[len(entries)-1].Type)
+	}
+
+	// A second run replaces the journal rather than appending to it.
+	if _, err := runner.RunGraph(makeSimpleGraph()); err != nil {
+		t.Fatalf("RunGraph failed: %v", err)
+	}
+	again, err := events.ReadJournal(filepath.Join(dir, events.JournalFile))
+	if err != nil || len(again) != len(entries) {
+		t.Errorf("expected %d entries after a second run, got %d (%v)", len(entries), len(again), err)
+	}
+}
+
+func TestRunnerPublishesToBus(t *testing.T) {
+	b := bus.New()
+	sub := b.Subscribe(bus.WithTopics(bus.TopicPipeline))
+	runner := NewRunner(&staticResolver{handler: &simpleHandler{}}, WithLogsRoot(t.TempDir()), WithBus(b))
+	if _, err := runner.RunGraph(makeSimpleGraph()); err != nil {
+		t.Fatalf("RunGraph failed: %v", err)
+	}
+	sub.Close()
+
+	var types []string
+	for msg := range sub.C() {
+		types = append(types, msg.Type)
+	}
+	if len(types) == 0 || types[0] != string(events.EventPipelineStarted) || types[len(types)-1] != string(events.EventPipelineCompleted) {
+		t.Errorf("expected the run's events on the bus, got %v", types)
+	}
+}
+
+// rateLimitError asks callers to wait before retrying.
+type rateLimitError struct{ wait time.Duration }
+
+func (e *rateLimitError) Error() string             { return "rate limited" }
+func (e *rateLimitError) RetryDelay() time.Duration { return e.wait }
+
+// errorHandler fails with err for a number of attempts, then succeeds.
+type errorHandler struct {
+	err      error
+	failures int
+	attempts int
+}
+
+func (h *errorHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	h.attempts++
+	if h.attempts <= h.failures {
+		return nil, h.err
+	}
+	return &Outcome{Status: StatusSuccess}, nil
+}
+
+func TestExecuteWithRetryHonorsRetryDelay(t *testing.T) {
+	node := &Node{ID: "a", Label: "A", Attrs: map[string]string{}}
+	graph := &Graph{Nodes: map[string]*Node{"a": node}}
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Hour, BackoffFactor: 1, MaxDelay: time.Second}
+
+	emitter := events.NewEmitter()
+	var delays []string
+	emitter.On(func(ev events.Event) {
+		if ev.Type == events.EventStageRetrying {
+			delays = append(delays, ev.Data["delay"].(string))
+		}
+	})
+
+	h := &errorHandler{err: fmt.Errorf("backend: %w", &rateLimitError{wait: 5 * time.Millisecond}), failures: 1}
+	engine := NewEngine(EngineConfig{}, &staticResolver{handler: h}, emitter)
+	outcome, err := engine.executeWithRetry(node, NewContext(), graph, policy, 0, "")
+	if err != nil || outcome.Status != StatusSuccess {
+		t.Fatalf("expected success after retry, got %+v, %v", outcome, err)
+	}
+	if len(delays) != 1 || delays[0] != "5ms" {
+		t.Errorf("expected one retry after the requested 5ms, got %v", delays)
+	}
+
+	// A reset beyond MaxDelay fails the stage without retrying.
+	h = &errorHandler{err: &rateLimitError{wait: time.Hour}, failures: 3}
+	engine = NewEngine(EngineConfig{}, &staticResolver{handler: h}, nil)
+	outcome, _ = engine.executeWithRetry(node, NewContext(), graph, policy, 0, "")
+	if outcome.Status != StatusFail || outcome.FailureReason != "rate limited" {
+		t.Errorf("expected immediate failure, got %+v", outcome)
+	}
+	if h.attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", h.attempts)
+	}
+}
+
+func TestWithEventHandler(t *testing.T) {
+	graph := &Graph{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start": {ID: "start", Shape: "Mdiamond", Label: "Start", Attrs: map[string]string{}},
+			"a":     {ID: "a", Shape: "box", Label: "A", Prompt: "Do A", Attrs: map[string]string{}},
+			"exit":  {ID: "exit", Shape: "Msquare", Label: "Exit", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "a"},
+			{From: "a", To: "exit"},
+		},
+	}
+
+	var started, completed []string
+	var finished bool
+	handler := func(e events.Event) {
+		switch ev := e.Typed().(type) {
+		case events.StageStarted:
+			started = append(started, ev.Name)
+		case events.StageCompleted:
+			completed = append(completed, ev.Name)
+		case events.PipelineCompleted:
+			finished = ev.Duration > 0
+		}
+	}
+	resolver := &staticResolver{handler: &simpleHandler{response: "ok"}}
+	engine := NewEngine(EngineConfig{LogsRoot: t.TempDir()}, resolver, nil, WithEventHandler(handler))
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := strings.Join(started, ","); got != "Start,A" {
+		t.Errorf("expected stages Start,A to start, got %s", got)
+	}
+	if got := strings.Join(completed, ","); got != "Start,A" {
+		t.Errorf("expected stages Start,A to complete, got %s", got)
+	}
+	if !finished {
+		t.Error("expected a pipeline completed event with a duration")
+	}
+}
+
+func TestEngineEmitsInterviewEventsForHumanGates(t *testing.T) {
+	graph := &Graph{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start":   {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"work":    {ID: "work", Label: "Work", Shape: "box", Attrs: map[string]string{}},
+			"approve": {ID: "approve", Label: "Approve", Shape: "hexagon", Attrs: map[string]string{}},
+			"exit":    {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "work"},
+			{From: "work", To: "approve"},
+			{From: "approve", To: "exit"},
+		},
+	}
+	runner := NewRunner(&staticResolver{handler: &simpleHandler{}})
+	var interviews []events.Event
+	runner.OnEvent(func(ev events.Event) {
+		if ev.Type == events.EventInterviewStarted || ev.Type == events.EventInterviewCompleted {
+			interviews = append(interviews, ev)
+		}
+	})
+
+	if _, err := runner.RunGraph(graph); err != nil {
+		t.Fatalf("RunGraph failed: %v", err)
+	}
+	if len(interviews) != 2 {
+		t.Fatalf("expected interview started and completed, got %+v", interviews)
+	}
+	started, ok := interviews[0].Typed().(events.InterviewStarted)
+	if !ok || started.NodeID != "approve" || started.Name != "Approve" {
+		t.Errorf("unexpected interview started event: %+v", interviews[0])
+	}
+	if interviews[1].Type != events.EventInterviewCompleted {
+		t.Errorf("expected interview completed second, got %s", interviews[1].Type)
+	}
+}
@@ -4,6 +4,8 @@ package events
 import (
 	"sync"
 	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/bus"
 )
 
 // EventType identifies the type of pipeline event.
@@ -20,6 +22,7 @@ const (
 	EventStageCompleted EventType = "stage_completed"
 	EventStageFailed    EventType = "stage_failed"
 	EventStageRetrying  EventType = "stage_retrying"
+	EventStageCached    EventType = "stage_cached"
 
 	// Parallel execution events
 	EventParallelStarted         EventType = "parallel_started"
@@ -55,7 +58,14 @@ func NewEvent(eventType EventType, data map[string]interface{}) Event {
 // Emitter provides event emission and subscription.
 type Emitter struct {
 	mu        sync.RWMutex
-	listeners []func(Event)
+	listeners []listener
+	nextID    int
+	filters   []func(Event) Event
+}
+
+type listener struct {
+	id int
+	fn func(Event)
 }
 
 // NewEmitter creates a new event emitter.
@@ -64,85 +74,136 @@ func NewEmitter() *Emitter {
 }
 
 // On registers a listener for all events.
-func (e *Emitter) On(listener func(Event)) {
+func (e *Emitter) On(fn func(Event)) {
+	e.Subscribe(fn)
+}
+
+// Subscribe registers a listener for all events and returns a function that
+// removes it again.
+func (e *Emitter) Subscribe(fn func(Event)) (unsubscribe func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextID++
+	id := e.nextID
+	e.listeners = append(e.listeners, listener{id: id, fn: fn})
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for i, l := range e.listeners {
+			if l.id == id {
+				e.listeners = append(e.listeners[:i:i], e.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// AddFilter registers a function that rewrites every event before it reaches
+// listeners, e.g. to redact secrets. Filters run in registration order.
+func (e *Emitter) AddFilter(filter func(Event) Event) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.listeners = append(e.listeners, listener)
+	e.filters = append(e.filters, filter)
 }
 
-// Emit sends an event to all listeners.
+// Emit sends an event to all listeners. It is safe to call from concurrent
+// goroutines; listeners are invoked outside the lock so they may register
+// further listeners or emit events themselves.
 func (e *Emitter) Emit(event Event) {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
-	for _, listener := range e.listeners {
-		listener(event)
+	listeners := make([]listener, len(e.listeners))
+	copy(listeners, e.listeners)
+	filters := e.filters
+	e.mu.RUnlock()
+	for _, filter := range filters {
+		event = filter(event)
+	}
+	for _, l := range listeners {
+		l.fn(event)
 	}
 }
 
+// PublishTo forwards every event, after filters, to b on bus.TopicPipeline.
+// Call the returned function to stop forwarding.
+func (e *Emitter) PublishTo(b *bus.Bus) (unsubscribe func()) {
+	return e.Subscribe(func(ev Event) {
+		b.Publish(bus.Message{Topic: bus.TopicPipeline, Type: string(ev.Type), Timestamp: ev.Timestamp, Data: ev.Data})
+	})
+}
+
 // EmitPipelineStarted emits a pipeline started event.
 func (e *Emitter) EmitPipelineStarted(name, id string) {
-	e.Emit(NewEvent(EventPipelineStarted, map[string]interface{}{
-		"name": name,
-		"id":   id,
-	}))
+	e.EmitTyped(PipelineStarted{Name: name, ID: id})
 }
 
 // EmitPipelineCompleted emits a pipeline completed event.
 func (e *Emitter) EmitPipelineCompleted(duration time.Duration, artifactCount int) {
-	e.Emit(NewEvent(EventPipelineCompleted, map[string]interface{}{
-		"duration":       duration.String(),
-		"artifact_count": artifactCount,
-	}))
+	e.EmitTyped(PipelineCompleted{Duration: duration, ArtifactCount: artifactCount})
 }
 
 // EmitPipelineFailed emits a pipeline failed event.
 func (e *Emitter) EmitPipelineFailed(errMsg string, duration time.Duration) {
-	e.Emit(NewEvent(EventPipelineFailed, map[string]interface{}{
-		"error":    errMsg,
-		"duration": duration.String(),
-	}))
+	e.EmitTyped(PipelineFailed{Error: errMsg, Duration: duration})
 }
 
 // EmitStageStarted emits a stage started event.
 func (e *Emitter) EmitStageStarted(name string, index int) {
-	e.Emit(NewEvent(EventStageStarted, map[string]interface{}{
-		"name":  name,
-		"index": index,
-	}))
+	e.EmitTyped(StageStarted{Name: name, Index: index})
 }
 
 // EmitStageCompleted emits a stage completed event.
 func (e *Emitter) EmitStageCompleted(name string, index int, duration time.Duration) {
-	e.Emit(NewEvent(EventStageCompleted, map[string]interface{}{
-		"name":     name,
-		"index":    index,
-		"duration": duration.String(),
-	}))
+	e.EmitTyped(StageCompleted{Name: name, Index: index, Duration: duration})
 }
 
 // EmitStageFailed emits a stage failed event.
 func (e *Emitter) EmitStageFailed(name string, index int, errMsg string, willRetry bool) {
-	e.Emit(NewEvent(EventStageFailed, map[string]interface{}{
-		"name":       name,
-		"index":      index,
-		"error":      errMsg,
-		"will_retry": willRetry,
-	}))
+	e.EmitTyped(StageFailed{Name: name, Index: index, Error: errMsg, WillRetry: willRetry})
 }
 
 // EmitStageRetrying emits a stage retrying event.
 func (e *Emitter) EmitStageRetrying(name string, index, attempt int, delay time.Duration) {
-	e.Emit(NewEvent(EventStageRetrying, map[string]interface{}{
-		"name":    name,
-		"index":   index,
-		"attempt": attempt,
-		"delay":   delay.String(),
-	}))
+	e.EmitTyped(StageRetrying{Name: name, Index: index, Attempt: attempt, Delay: delay})
+}
+
+// EmitStageCached emits a stage cached event: the stage was skipped and the
+// outcome recorded under key reused.
+func (e *Emitter) EmitStageCached(name string, index int, key string) {
+	e.EmitTyped(StageCached{Name: name, Index: index, Key: key})
+}
+
+// EmitParallelStarted emits a parallel fan-out started event.
+func (e *Emitter) EmitParallelStarted(name string, branchCount int) {
+	e.EmitTyped(ParallelStarted{Name: name, BranchCount: branchCount})
+}
+
+// EmitParallelBranchStarted emits a parallel branch started event.
+func (e *Emitter) EmitParallelBranchStarted(branch string, index int) {
+	e.EmitTyped(ParallelBranchStarted{Branch: branch, Index: index})
+}
+
+// EmitParallelBranchCompleted emits a parallel branch completed event.
+func (e *Emitter) EmitParallelBranchCompleted(branch string, index int, duration time.Duration, success bool) {
+	e.EmitTyped(ParallelBranchCompleted{Branch: branch, Index: index, Duration: duration, Success: success})
+}
+
+// EmitParallelCompleted emits a parallel fan-out completed event.
+func (e *Emitter) EmitParallelCompleted(duration time.Duration, successCount, failureCount int) {
+	e.EmitTyped(ParallelCompleted{Duration: duration, SuccessCount: successCount, FailureCount: failureCount})
+}
+
+// EmitInterviewStarted emits an interview started event: a human gate is
+// waiting for an answer.
+func (e *Emitter) EmitInterviewStarted(name, nodeID string) {
+	e.EmitTyped(InterviewStarted{Name: name, NodeID: nodeID})
+}
+
+// EmitInterviewCompleted emits an interview completed event.
+func (e *Emitter) EmitInterviewCompleted(name, nodeID string, duration time.Duration) {
+	e.EmitTyped(InterviewCompleted{Name: name, NodeID: nodeID, Duration: duration})
 }
 
 // EmitCheckpointSaved emits a checkpoint saved event.
 func (e *Emitter) EmitCheckpointSaved(nodeID string) {
-	e.Emit(NewEvent(EventCheckpointSaved, map[string]interface{}{
-		"node_id": nodeID,
-	}))
+	e.EmitTyped(CheckpointSaved{NodeID: nodeID})
 }
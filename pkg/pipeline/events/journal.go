@@ -0,0 +1,125 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// JournalFile is the name of the event journal the runner writes in a run's
+// logs directory.
+const JournalFile = "events.jsonl"
+
+// Entry is one line of an event journal: an event and its sequence number.
+// Sequence numbers start at 1 and increase by one per event, in the order
+// the events were emitted.
+type Entry struct {
+	Seq int64 `json:"seq"`
+	Event
+}
+
+// Journal appends events to a JSONL file as they are emitted. Register
+// Record as an emitter listener; it is safe for concurrent use.
+type Journal struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	seq int64
+	err error
+}
+
+// CreateJournal creates or truncates the journal file at path.
+func CreateJournal(path string) (*Journal, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Record appends an event with the next sequence number. Each entry is
+// flushed so the journal can be tailed while the run is in progress. Write
+// errors are kept and reported by Close; later events are dropped.
+func (j *Journal) Record(event Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return
+	}
+	j.seq++
+	data, err := json.Marshal(Entry{Seq: j.seq, Event: event})
+	if err != nil {
+		j.err = fmt.Errorf("encode event %d: %w", j.seq, err)
+		return
+	}
+	data = append(data, '\n')
+	if _, err := j.w.Write(data); err != nil {
+		j.err = err
+		return
+	}
+	j.err = j.w.Flush()
+}
+
+// Close closes the journal file and returns the first error encountered
+// while recording.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.f.Close(); err != nil && j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+// Replay reads a journal and calls fn for each entry in sequence order,
+// stopping at the first error fn returns. It fails if the sequence has a gap
+// or goes backwards, which means the journal is truncated or corrupt. A
+// final line without a newline, as left by a run that was killed mid-write,
+// is ignored if it isn't valid JSON.
+func Replay(r io.Reader, fn func(Entry) error) error {
+	br := bufio.NewReader(r)
+	var last int64
+	for line := 1; ; line++ {
+		data, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if len(data) > 0 {
+			var entry Entry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				if readErr == io.EOF {
+					return nil
+				}
+				return fmt.Errorf("journal line %d: %w", line, err)
+			}
+			if entry.Seq != last+1 {
+				return fmt.Errorf("journal line %d: expected seq %d, got %d", line, last+1, entry.Seq)
+			}
+			last = entry.Seq
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// ReadJournal reads every entry of the journal file at path.
+func ReadJournal(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	err = Replay(f, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), JournalFile)
+	journal, err := CreateJournal(path)
+	if err != nil {
+		t.Fatalf("CreateJournal: %v", err)
+	}
+	emitter := NewEmitter()
+	emitter.On(journal.Record)
+
+	emitter.EmitPipelineStarted("test", "run-1")
+	emitter.EmitStageStarted("Build", 0)
+	emitter.EmitStageCompleted("Build", 0, 2*time.Second)
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("ReadJournal: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Seq != int64(i+1) {
+			t.Errorf("entry %d: expected seq %d, got %d", i, i+1, e.Seq)
+		}
+	}
+	if entries[1].Type != EventStageStarted || entries[1].Data["name"] != "Build" {
+		t.Errorf("unexpected second entry %+v", entries[1])
+	}
+	if entries[2].Timestamp.IsZero() {
+		t.Error("expected timestamps to round-trip")
+	}
+}
+
+func TestJournalConcurrentRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), JournalFile)
+	journal, err := CreateJournal(path)
+	if err != nil {
+		t.Fatalf("CreateJournal: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			journal.Record(NewEvent(EventParallelBranchCompleted, nil))
+		}()
+	}
+	wg.Wait()
+	journal.Close()
+
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("ReadJournal: %v", err)
+	}
+	if len(entries) != 50 {
+		t.Errorf("expected 50 entries, got %d", len(entries))
+	}
+}
+
+func TestReplayRejectsGap(t *testing.T) {
+	journal := `{"seq":1,"type":"stage_started","timestamp":"2026-01-01T00:00:00Z"}
+{"seq":3,"type":"stage_completed","timestamp":"2026-01-01T00:00:01Z"}
+`
+	err := Replay(strings.NewReader(journal), func(Entry) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "expected seq 2") {
+		t.Errorf("expected sequence gap error, got %v", err)
+	}
+}
+
+func TestReplayIgnoresTruncatedLastLine(t *testing.T) {
+	journal := `{"seq":1,"type":"stage_started","timestamp":"2026-01-01T00:00:00Z"}
+{"seq":2,"type":"stage_comp`
+	var seen int
+	if err := Replay(strings.NewReader(journal), func(Entry) error { seen++; return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seen != 1 {
+		t.Errorf("expected 1 complete entry, got %d", seen)
+	}
+}
+
+func TestReplayStopsOnCallbackError(t *testing.T) {
+	journal := `{"seq":1,"type":"stage_started","timestamp":"2026-01-01T00:00:00Z"}
+{"seq":2,"type":"stage_completed","timestamp":"2026-01-01T00:00:01Z"}
+`
+	stop := errors.New("stop")
+	var seen int
+	err := Replay(strings.NewReader(journal), func(Entry) error { seen++; return stop })
+	if !errors.Is(err, stop) || seen != 1 {
+		t.Errorf("expected to stop after one entry, got %v after %d", err, seen)
+	}
+}
+
+func TestSubscribeUnsubscribe(t *testing.T) {
+	emitter := NewEmitter()
+	var count int
+	unsubscribe := emitter.Subscribe(func(Event) { count++ })
+	emitter.Emit(NewEvent(EventStageStarted, nil))
+	unsubscribe()
+	emitter.Emit(NewEvent(EventStageStarted, nil))
+	if count != 1 {
+		t.Errorf("expected 1 event before unsubscribing, got %d", count)
+	}
+}
+
+func TestCreateJournalError(t *testing.T) {
+	if _, err := CreateJournal(filepath.Join(t.TempDir(), "missing", JournalFile)); !os.IsNotExist(err) {
+		t.Errorf("expected not-exist error, got %v", err)
+	}
+}
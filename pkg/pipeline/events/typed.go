@@ -0,0 +1,288 @@
+package events
+
+import "time"
+
+// TypedEvent is the typed form of an engine event, for callers that would
+// rather switch on Go types than read Event.Data:
+//
+//	switch ev := e.Typed().(type) {
+//	case events.StageStarted:
+//		bar.Describe(ev.Name)
+//	case events.StageCompleted:
+//		bar.Add(1)
+//	}
+type TypedEvent interface {
+	EventType() EventType
+	data() map[string]interface{}
+}
+
+// PipelineStarted is the typed form of EventPipelineStarted.
+type PipelineStarted struct {
+	Name string
+	ID   string
+}
+
+// PipelineCompleted is the typed form of EventPipelineCompleted.
+type PipelineCompleted struct {
+	Duration      time.Duration
+	ArtifactCount int
+}
+
+// PipelineFailed is the typed form of EventPipelineFailed.
+type PipelineFailed struct {
+	Error    string
+	Duration time.Duration
+}
+
+// StageStarted is the typed form of EventStageStarted. Name is the stage's
+// label and Index its position in the run. NodeID is the stage's node, and
+// Branch, for a stage in a parallel branch, the branch's logs directory
+// relative to the run's (see pipeline.BranchLogsRoot). Events emitted through
+// the Emit helpers leave both empty.
+type StageStarted struct {
+	Name   string
+	NodeID string
+	Branch string
+	Index  int
+}
+
+// StageCompleted is the typed form of EventStageCompleted.
+type StageCompleted struct {
+	Name     string
+	NodeID   string
+	Branch   string
+	Index    int
+	Duration time.Duration
+}
+
+// StageFailed is the typed form of EventStageFailed.
+type StageFailed struct {
+	Name      string
+	NodeID    string
+	Branch    string
+	Index     int
+	Error     string
+	WillRetry bool
+}
+
+// StageRetrying is the typed form of EventStageRetrying.
+type StageRetrying struct {
+	Name    string
+	NodeID  string
+	Branch  string
+	Index   int
+	Attempt int
+	Delay   time.Duration
+}
+
+// StageCached is the typed form of EventStageCached.
+type StageCached struct {
+	Name   string
+	NodeID string
+	Branch string
+	Index  int
+	Key    string
+}
+
+// ParallelStarted is the typed form of EventParallelStarted.
+type ParallelStarted struct {
+	Name        string
+	BranchCount int
+}
+
+// ParallelBranchStarted is the typed form of EventParallelBranchStarted.
+type ParallelBranchStarted struct {
+	Branch string
+	Index  int
+}
+
+// ParallelBranchCompleted is the typed form of EventParallelBranchCompleted.
+type ParallelBranchCompleted struct {
+	Branch   string
+	Index    int
+	Duration time.Duration
+	Success  bool
+}
+
+// ParallelCompleted is the typed form of EventParallelCompleted.
+type ParallelCompleted struct {
+	Duration     time.Duration
+	SuccessCount int
+	FailureCount int
+}
+
+// InterviewStarted is the typed form of EventInterviewStarted: the human
+// gate Name (its label) is waiting for an answer.
+type InterviewStarted struct {
+	Name   string
+	NodeID string
+}
+
+// InterviewCompleted is the typed form of EventInterviewCompleted.
+type InterviewCompleted struct {
+	Name     string
+	NodeID   string
+	Duration time.Duration
+}
+
+// CheckpointSaved is the typed form of EventCheckpointSaved.
+type CheckpointSaved struct {
+	NodeID string
+}
+
+func (PipelineStarted) EventType() EventType         { return EventPipelineStarted }
+func (PipelineCompleted) EventType() EventType       { return EventPipelineCompleted }
+func (PipelineFailed) EventType() EventType          { return EventPipelineFailed }
+func (StageStarted) EventType() EventType            { return EventStageStarted }
+func (StageCompleted) EventType() EventType          { return EventStageCompleted }
+func (StageFailed) EventType() EventType             { return EventStageFailed }
+func (StageRetrying) EventType() EventType           { return EventStageRetrying }
+func (StageCached) EventType() EventType             { return EventStageCached }
+func (ParallelStarted) EventType() EventType         { return EventParallelStarted }
+func (ParallelBranchStarted) EventType() EventType   { return EventParallelBranchStarted }
+func (ParallelBranchCompleted) EventType() EventType { return EventParallelBranchCompleted }
+func (ParallelCompleted) EventType() EventType       { return EventParallelCompleted }
+func (InterviewStarted) EventType() EventType        { return EventInterviewStarted }
+func (InterviewCompleted) EventType() EventType      { return EventInterviewCompleted }
+func (CheckpointSaved) EventType() EventType         { return EventCheckpointSaved }
+
+func (ev PipelineStarted) data() map[string]interface{} {
+	return map[string]interface{}{"name": ev.Name, "id": ev.ID}
+}
+
+func (ev PipelineCompleted) data() map[string]interface{} {
+	return map[string]interface{}{"duration": ev.Duration.String(), "artifact_count": ev.ArtifactCount}
+}
+
+func (ev PipelineFailed) data() map[string]interface{} {
+	return map[string]interface{}{"error": ev.Error, "duration": ev.Duration.String()}
+}
+
+func (ev StageStarted) data() map[string]interface{} {
+	return stageData(ev.NodeID, ev.Branch, map[string]interface{}{"name": ev.Name, "index": ev.Index})
+}
+
+func (ev StageCompleted) data() map[string]interface{} {
+	return stageData(ev.NodeID, ev.Branch, map[string]interface{}{"name": ev.Name, "index": ev.Index, "duration": ev.Duration.String()})
+}
+
+func (ev StageFailed) data() map[string]interface{} {
+	return stageData(ev.NodeID, ev.Branch, map[string]interface{}{"name": ev.Name, "index": ev.Index, "error": ev.Error, "will_retry": ev.WillRetry})
+}
+
+func (ev StageRetrying) data() map[string]interface{} {
+	return stageData(ev.NodeID, ev.Branch, map[string]interface{}{"name": ev.Name, "index": ev.Index, "attempt": ev.Attempt, "delay": ev.Delay.String()})
+}
+
+func (ev StageCached) data() map[string]interface{} {
+	return stageData(ev.NodeID, ev.Branch, map[string]interface{}{"name": ev.Name, "index": ev.Index, "key": ev.Key})
+}
+
+// stageData adds a stage event's node_id and branch to d, when set.
+func stageData(nodeID, branch string, d map[string]interface{}) map[string]interface{} {
+	if nodeID != "" {
+		d["node_id"] = nodeID
+	}
+	if branch != "" {
+		d["branch"] = branch
+	}
+	return d
+}
+
+func (ev ParallelStarted) data() map[string]interface{} {
+	return map[string]interface{}{"name": ev.Name, "branch_count": ev.BranchCount}
+}
+
+func (ev ParallelBranchStarted) data() map[string]interface{} {
+	return map[string]interface{}{"branch": ev.Branch, "index": ev.Index}
+}
+
+func (ev ParallelBranchCompleted) data() map[string]interface{} {
+	return map[string]interface{}{"branch": ev.Branch, "index": ev.Index, "duration": ev.Duration.String(), "success": ev.Success}
+}
+
+func (ev ParallelCompleted) data() map[string]interface{} {
+	return map[string]interface{}{"duration": ev.Duration.String(), "success_count": ev.SuccessCount, "failure_count": ev.FailureCount}
+}
+
+func (ev InterviewStarted) data() map[string]interface{} {
+	return map[string]interface{}{"name": ev.Name, "node_id": ev.NodeID}
+}
+
+func (ev InterviewCompleted) data() map[string]interface{} {
+	return map[string]interface{}{"name": ev.Name, "node_id": ev.NodeID, "duration": ev.Duration.String()}
+}
+
+func (ev CheckpointSaved) data() map[string]interface{} {
+	return map[string]interface{}{"node_id": ev.NodeID}
+}
+
+// Typed returns the typed form of e, or nil for event types without one,
+// such as validation warnings. It reads e.Data as the engine writes it or as
+// it comes back from a journal, where numbers are float64.
+func (e Event) Typed() TypedEvent {
+	d := e.Data
+	switch e.Type {
+	case EventPipelineStarted:
+		return PipelineStarted{Name: str(d, "name"), ID: str(d, "id")}
+	case EventPipelineCompleted:
+		return PipelineCompleted{Duration: dur(d, "duration"), ArtifactCount: num(d, "artifact_count")}
+	case EventPipelineFailed:
+		return PipelineFailed{Error: str(d, "error"), Duration: dur(d, "duration")}
+	case EventStageStarted:
+		return StageStarted{Name: str(d, "name"), NodeID: str(d, "node_id"), Branch: str(d, "branch"), Index: num(d, "index")}
+	case EventStageCompleted:
+		return StageCompleted{Name: str(d, "name"), NodeID: str(d, "node_id"), Branch: str(d, "branch"), Index: num(d, "index"), Duration: dur(d, "duration")}
+	case EventStageFailed:
+		return StageFailed{Name: str(d, "name"), NodeID: str(d, "node_id"), Branch: str(d, "branch"), Index: num(d, "index"), Error: str(d, "error"), WillRetry: flag(d, "will_retry")}
+	case EventStageRetrying:
+		return StageRetrying{Name: str(d, "name"), NodeID: str(d, "node_id"), Branch: str(d, "branch"), Index: num(d, "index"), Attempt: num(d, "attempt"), Delay: dur(d, "delay")}
+	case EventStageCached:
+		return StageCached{Name: str(d, "name"), NodeID: str(d, "node_id"), Branch: str(d, "branch"), Index: num(d, "index"), Key: str(d, "key")}
+	case EventParallelStarted:
+		return ParallelStarted{Name: str(d, "name"), BranchCount: num(d, "branch_count")}
+	case EventParallelBranchStarted:
+		return ParallelBranchStarted{Branch: str(d, "branch"), Index: num(d, "index")}
+	case EventParallelBranchCompleted:
+		return ParallelBranchCompleted{Branch: str(d, "branch"), Index: num(d, "index"), Duration: dur(d, "duration"), Success: flag(d, "success")}
+	case EventParallelCompleted:
+		return ParallelCompleted{Duration: dur(d, "duration"), SuccessCount: num(d, "success_count"), FailureCount: num(d, "failure_count")}
+	case EventInterviewStarted:
+		return InterviewStarted{Name: str(d, "name"), NodeID: str(d, "node_id")}
+	case EventInterviewCompleted:
+		return InterviewCompleted{Name: str(d, "name"), NodeID: str(d, "node_id"), Duration: dur(d, "duration")}
+	case EventCheckpointSaved:
+		return CheckpointSaved{NodeID: str(d, "node_id")}
+	}
+	return nil
+}
+
+// EmitTyped emits the event ev is the typed form of.
+func (e *Emitter) EmitTyped(ev TypedEvent) {
+	e.Emit(NewEvent(ev.EventType(), ev.data()))
+}
+
+func str(d map[string]interface{}, key string) string {
+	s, _ := d[key].(string)
+	return s
+}
+
+func num(d map[string]interface{}, key string) int {
+	switch n := d[key].(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func dur(d map[string]interface{}, key string) time.Duration {
+	v, _ := time.ParseDuration(str(d, key))
+	return v
+}
+
+func flag(d map[string]interface{}, key string) bool {
+	b, _ := d[key].(bool)
+	return b
+}
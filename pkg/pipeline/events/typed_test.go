@@ -0,0 +1,57 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTypedRoundTrip(t *testing.T) {
+	typed := []TypedEvent{
+		PipelineStarted{Name: "p", ID: "run-1"},
+		PipelineCompleted{Duration: 3 * time.Second, ArtifactCount: 2},
+		PipelineFailed{Error: "boom", Duration: time.Second},
+		StageStarted{Name: "Plan", Index: 1},
+		StageCompleted{Name: "Plan", Index: 1, Duration: 250 * time.Millisecond},
+		StageCompleted{Name: "Review", NodeID: "review", Branch: "fan/branches/a", Index: 2, Duration: time.Second},
+		StageFailed{Name: "Plan", Index: 1, Error: "bad", WillRetry: true},
+		StageRetrying{Name: "Plan", Index: 1, Attempt: 2, Delay: 400 * time.Millisecond},
+		StageCached{Name: "Plan", Index: 1, Key: "abc"},
+		ParallelStarted{Name: "fan", BranchCount: 3},
+		ParallelBranchStarted{Branch: "b", Index: 2},
+		ParallelBranchCompleted{Branch: "b", Index: 2, Duration: time.Minute, Success: true},
+		ParallelCompleted{Duration: time.Minute, SuccessCount: 2, FailureCount: 1},
+		InterviewStarted{Name: "Approve", NodeID: "approve"},
+		InterviewCompleted{Name: "Approve", NodeID: "approve", Duration: time.Minute},
+		CheckpointSaved{NodeID: "plan"},
+	}
+	for _, want := range typed {
+		emitter := NewEmitter()
+		var got Event
+		emitter.On(func(e Event) { got = e })
+		emitter.EmitTyped(want)
+
+		if got.Type != want.EventType() {
+			t.Errorf("%T: expected type %s, got %s", want, want.EventType(), got.Type)
+		}
+		if typed := got.Typed(); typed != want {
+			t.Errorf("expected %+v, got %+v", want, typed)
+		}
+
+		// Journaled events come back with float64 numbers.
+		data, _ := json.Marshal(got)
+		var decoded Event
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if typed := decoded.Typed(); typed != want {
+			t.Errorf("after JSON: expected %+v, got %+v", want, typed)
+		}
+	}
+}
+
+func TestTypedUnknown(t *testing.T) {
+	if typed := NewEvent("validation_warning", nil).Typed(); typed != nil {
+		t.Errorf("expected no typed form, got %+v", typed)
+	}
+}
@@ -0,0 +1,277 @@
+package pipeline
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ShapeToType maps DOT shapes to the handler type they select when a node
+// has no explicit type.
+var ShapeToType = map[string]string{
+	"Mdiamond":      "start",
+	"Msquare":       "exit",
+	"box":           "codergen",
+	"hexagon":       "wait.human",
+	"diamond":       "conditional",
+	"component":     "parallel",
+	"tripleoctagon": "parallel.fan_in",
+	"parallelogram": "tool",
+	"house":         "stack.manager_loop",
+}
+
+// HandlerType returns the node's explicit type, else the type its shape
+// maps to, else "codergen".
+func (n *Node) HandlerType() string {
+	if n.Type != "" {
+		return n.Type
+	}
+	if t, ok := ShapeToType[n.Shape]; ok {
+		return t
+	}
+	return "codergen"
+}
+
+// WorkDir returns the directory the node's commands and agent run in, from
+// its "workdir" attribute ("tool_workdir" is accepted for tool nodes). Empty
+// means the current directory.
+func (n *Node) WorkDir() string {
+	if dir := n.Attrs["workdir"]; dir != "" {
+		return dir
+	}
+	return n.Attrs["tool_workdir"]
+}
+
+// Env returns the node's env.* attributes as KEY=VALUE pairs, sorted by key,
+// for adding to the environment of the processes the stage starts.
+func (n *Node) Env() []string {
+	var env []string
+	for key, value := range n.Attrs {
+		if name, ok := strings.CutPrefix(key, "env."); ok && name != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// graphIndex caches a graph's adjacency lists. It is dropped by AddEdge,
+// RemoveEdge, and Invalidate, and rebuilt on the next query.
+type graphIndex struct {
+	first *Edge // Edges[0] when the index was built
+	n     int   // len(Edges) when the index was built
+	out   map[string][]*Edge
+	in    map[string][]*Edge
+}
+
+// adjacency returns the graph's index, building it if it was invalidated.
+// An index built for a different Edges slice, such as after an append or in
+// a copy whose Edges was reassigned, is also rebuilt; that check looks only
+// at the slice's first element and length, so changing an edge's endpoints
+// or replacing an edge in place needs Invalidate. The index is held in an
+// atomic.Value rather than behind a mutex so a Graph stays safe to copy;
+// goroutines that rebuild it at the same time build identical indexes.
+func (g *Graph) adjacency() *graphIndex {
+	var first *Edge
+	if len(g.Edges) > 0 {
+		first = g.Edges[0]
+	}
+	if idx, _ := g.index.Load().(*graphIndex); idx != nil && idx.first == first && idx.n == len(g.Edges) {
+		return idx
+	}
+	idx := &graphIndex{
+		first: first,
+		n:     len(g.Edges),
+		out:   make(map[string][]*Edge),
+		in:    make(map[string][]*Edge),
+	}
+	for _, e := range g.Edges {
+		idx.out[e.From] = append(idx.out[e.From], e)
+		idx.in[e.To] = append(idx.in[e.To], e)
+	}
+	g.index.Store(idx)
+	return idx
+}
+
+// AddEdge appends e to the graph's edges.
+func (g *Graph) AddEdge(e *Edge) {
+	g.Edges = append(g.Edges, e)
+	g.Invalidate()
+}
+
+// RemoveEdge removes e from the graph's edges and reports whether it was
+// present.
+func (g *Graph) RemoveEdge(e *Edge) bool {
+	i := slices.Index(g.Edges, e)
+	if i < 0 {
+		return false
+	}
+	g.Edges = slices.Delete(g.Edges, i, i+1)
+	g.Invalidate()
+	return true
+}
+
+// Invalidate discards cached adjacency. Call it after changing an edge's
+// From or To, or replacing an element of Edges, directly; AddEdge and
+// RemoveEdge invalidate the index themselves.
+func (g *Graph) Invalidate() {
+	g.index.Store((*graphIndex)(nil))
+}
+
+// OutgoingEdges returns all edges originating from the given node ID.
+func (g *Graph) OutgoingEdges(nodeID string) []*Edge {
+	return append([]*Edge(nil), g.adjacency().out[nodeID]...)
+}
+
+// IncomingEdges returns all edges targeting the given node ID.
+func (g *Graph) IncomingEdges(nodeID string) []*Edge {
+	return append([]*Edge(nil), g.adjacency().in[nodeID]...)
+}
+
+// Successors returns the distinct targets of the node's outgoing edges, in
+// edge order.
+func (g *Graph) Successors(nodeID string) []string {
+	return distinct(g.adjacency().out[nodeID], func(e *Edge) string { return e.To })
+}
+
+// Predecessors returns the distinct sources of the node's incoming edges, in
+// edge order.
+func (g *Graph) Predecessors(nodeID string) []string {
+	return distinct(g.adjacency().in[nodeID], func(e *Edge) string { return e.From })
+}
+
+func distinct(edges []*Edge, end func(*Edge) string) []string {
+	var ids []string
+	seen := make(map[string]bool, len(edges))
+	for _, e := range edges {
+		if id := end(e); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// TopologicalOrder returns the node IDs in topological order, or an error
+// naming a node on a cycle if the graph is not acyclic. Ties are broken by
+// ID so the order is deterministic. Edges to unknown nodes are ignored.
+func (g *Graph) TopologicalOrder() ([]string, error) {
+	idx := g.adjacency()
+	inDegree := make(map[string]int, len(g.Nodes))
+	for id := range g.Nodes {
+		inDegree[id] = 0
+	}
+	for _, edge := range g.Edges {
+		if _, ok := g.Nodes[edge.To]; ok {
+			inDegree[edge.To]++
+		}
+	}
+
+	var ready []string
+	for id, d := range inDegree {
+		if d == 0 {
+			ready = append(ready, id)
+		}
+	}
+	sort.Strings(ready)
+
+	var order []string
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		var next []string
+		for _, edge := range idx.out[id] {
+			if _, ok := inDegree[edge.To]; !ok {
+				continue
+			}
+			inDegree[edge.To]--
+			if inDegree[edge.To] == 0 {
+				next = append(next, edge.To)
+			}
+		}
+		sort.Strings(next)
+		ready = append(ready, next...)
+	}
+
+	if len(order) != len(g.Nodes) {
+		var cyclic []string
+		for id, d := range inDegree {
+			if d > 0 {
+				cyclic = append(cyclic, id)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("graph has a cycle through node %q", cyclic[0])
+	}
+	return order, nil
+}
+
+// SubgraphFrom returns a copy of the part of the graph reachable from
+// nodeID: those nodes, the edges between them, and the graph's attributes.
+// Nodes and edges are copied, so the result can be modified independently.
+// It returns nil if nodeID is not in the graph.
+func (g *Graph) SubgraphFrom(nodeID string) *Graph {
+	if _, ok := g.Nodes[nodeID]; !ok {
+		return nil
+	}
+	idx := g.adjacency()
+	reached := map[string]bool{nodeID: true}
+	queue := []string{nodeID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range idx.out[current] {
+			if _, ok := g.Nodes[e.To]; ok && !reached[e.To] {
+				reached[e.To] = true
+				queue = append(queue, e.To)
+			}
+		}
+	}
+
+	sub := &Graph{
+		Name:                g.Name,
+		Goal:                g.Goal,
+		Label:               g.Label,
+		ModelStylesheet:     g.ModelStylesheet,
+		DefaultMaxRetry:     g.DefaultMaxRetry,
+		DefaultFidelity:     g.DefaultFidelity,
+		RetryTarget:         g.RetryTarget,
+		FallbackRetryTarget: g.FallbackRetryTarget,
+		MaxTokens:           g.MaxTokens,
+		MaxCostUSD:          g.MaxCostUSD,
+		Schedule:            g.Schedule,
+		MaxParallel:         g.MaxParallel,
+		RequestsPerMinute:   g.RequestsPerMinute,
+		TokensPerMinute:     g.TokensPerMinute,
+		Nodes:               make(map[string]*Node, len(reached)),
+		Attrs:               maps.Clone(g.Attrs),
+	}
+	for id := range reached {
+		node := *g.Nodes[id]
+		node.Attrs = maps.Clone(node.Attrs)
+		sub.Nodes[id] = &node
+	}
+	for _, e := range g.Edges {
+		if reached[e.From] && reached[e.To] {
+			edge := *e
+			sub.AddEdge(&edge)
+		}
+	}
+	return sub
+}
+
+// FindByType returns the nodes whose HandlerType is handlerType, sorted by
+// ID.
+func (g *Graph) FindByType(handlerType string) []*Node {
+	var nodes []*Node
+	for _, node := range g.Nodes {
+		if node.HandlerType() == handlerType {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func makeBranchingGraph() *Graph {
+	graph := makeSimpleGraph()
+	graph.Nodes["b"] = &Node{ID: "b", Shape: "parallelogram", Attrs: map[string]string{"tool_command": "make"}}
+	graph.Nodes["gate"] = &Node{ID: "gate", Shape: "diamond", Attrs: map[string]string{}}
+	graph.Edges = []*Edge{
+		{From: "start", To: "gate"},
+		{From: "gate", To: "a", Condition: "outcome=success"},
+		{From: "gate", To: "b", Condition: "outcome=fail"},
+		{From: "gate", To: "b", Label: "again"},
+		{From: "a", To: "exit"},
+		{From: "b", To: "exit"},
+	}
+	return graph
+}
+
+func TestGraphSuccessorsAndPredecessors(t *testing.T) {
+	graph := makeBranchingGraph()
+
+	if got := graph.Successors("gate"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected successors [a b], got %v", got)
+	}
+	if got := graph.Predecessors("exit"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected predecessors [a b], got %v", got)
+	}
+	if got := graph.OutgoingEdges("gate"); len(got) != 3 {
+		t.Errorf("expected 3 outgoing edges, got %d", len(got))
+	}
+	if got := graph.Successors("missing"); got != nil {
+		t.Errorf("expected no successors, got %v", got)
+	}
+}
+
+func TestGraphIndexTracksEdgeChanges(t *testing.T) {
+	graph := makeSimpleGraph()
+	if got := graph.Successors("a"); !reflect.DeepEqual(got, []string{"exit"}) {
+		t.Fatalf("expected [exit], got %v", got)
+	}
+
+	graph.Nodes["b"] = &Node{ID: "b", Shape: "box", Attrs: map[string]string{}}
+	added := &Edge{From: "a", To: "b"}
+	graph.AddEdge(added)
+	if got := graph.Successors("a"); !reflect.DeepEqual(got, []string{"exit", "b"}) {
+		t.Errorf("expected added edge to be indexed, got %v", got)
+	}
+
+	graph.Edges[0].To = "b"
+	graph.Invalidate()
+	if got := graph.Predecessors("b"); !reflect.DeepEqual(got, []string{"start", "a"}) {
+		t.Errorf("expected a changed endpoint to be indexed, got %v", got)
+	}
+
+	if !graph.RemoveEdge(added) {
+		t.Fatal("expected RemoveEdge to find the edge")
+	}
+	if got := graph.Successors("a"); !reflect.DeepEqual(got, []string{"exit"}) {
+		t.Errorf("expected removed edge to be dropped, got %v", got)
+	}
+	if graph.RemoveEdge(added) {
+		t.Error("expected RemoveEdge to report a missing edge")
+	}
+
+	// Appending to Edges directly is picked up too.
+	graph.Edges = append(graph.Edges, &Edge{From: "b", To: "exit"})
+	if got := graph.Predecessors("exit"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected appended edge to be indexed, got %v", got)
+	}
+
+	copied := *graph
+	copied.Edges = copied.Edges[:1]
+	if got := copied.Successors("start"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("expected a copy to index its own edges, got %v", got)
+	}
+}
+
+func TestGraphOutgoingEdgesReturnsCopy(t *testing.T) {
+	graph := makeBranchingGraph()
+	edges := graph.OutgoingEdges("gate")
+	edges[0] = nil
+	if graph.OutgoingEdges("gate")[0] == nil {
+		t.Error("expected OutgoingEdges to return a copy of the index")
+	}
+}
+
+func TestGraphConcurrentQueries(t *testing.T) {
+	graph := makeBranchingGraph()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			graph.OutgoingEdges("gate")
+			graph.Predecessors("exit")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGraphTopologicalOrder(t *testing.T) {
+	graph := makeBranchingGraph()
+	order, err := graph.TopologicalOrder()
+	if err != nil {
+		t.Fatalf("TopologicalOrder: %v", err)
+	}
+	want := []string{"start", "gate", "a", "b", "exit"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+
+	graph.Edges = append(graph.Edges, &Edge{From: "a", To: "gate"})
+	if _, err := graph.TopologicalOrder(); err == nil {
+		t.Error("expected cycle error")
+	}
+}
+
+func TestGraphSubgraphFrom(t *testing.T) {
+	graph := makeBranchingGraph()
+	graph.Goal = "ship it"
+
+	sub := graph.SubgraphFrom("b")
+	if sub == nil {
+		t.Fatal("expected subgraph")
+	}
+	if len(sub.Nodes) != 2 || sub.Nodes["b"] == nil || sub.Nodes["exit"] == nil {
+		t.Errorf("expected nodes b and exit, got %v", sub.Nodes)
+	}
+	if len(sub.Edges) != 1 || sub.Edges[0].From != "b" {
+		t.Errorf("expected edge b -> exit, got %v", sub.Edges)
+	}
+	if sub.Goal != "ship it" {
+		t.Errorf("expected goal to be copied, got %q", sub.Goal)
+	}
+
+	sub.Nodes["b"].Attrs["tool_command"] = "changed"
+	sub.Edges[0].Label = "changed"
+	if graph.Nodes["b"].Attrs["tool_command"] != "make" || graph.Edges[5].Label != "" {
+		t.Error("expected subgraph changes not to affect the original")
+	}
+
+	if graph.SubgraphFrom("missing") != nil {
+		t.Error("expected nil for unknown node")
+	}
+}
+
+func TestGraphFindByType(t *testing.T) {
+	graph := makeBranchingGraph()
+	graph.Nodes["custom"] = &Node{ID: "custom", Shape: "box", Type: "tool", Attrs: map[string]string{}}
+
+	var ids []string
+	for _, node := range graph.FindByType("tool") {
+		ids = append(ids, node.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"b", "custom"}) {
+		t.Errorf("expected [b custom], got %v", ids)
+	}
+	if got := graph.FindByType("codergen"); len(got) != 1 || got[0].ID != "a" {
+		t.Errorf("expected codergen node a, got %v", got)
+	}
+}
+
+func TestNodeWorkDirAndEnv(t *testing.T) {
+	graph, err := Parse(`digraph P {
+	a [workdir="services/api", env.GOFLAGS="-count=1", env.CGO_ENABLED="0"]
+	b [tool_workdir="legacy"]
+}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	a := graph.Nodes["a"]
+	if a.WorkDir() != "services/api" {
+		t.Errorf("expected workdir services/api, got %q", a.WorkDir())
+	}
+	env := a.Env()
+	if len(env) != 2 || env[0] != "CGO_ENABLED=0" || env[1] != "GOFLAGS=-count=1" {
+		t.Errorf("expected sorted env pairs, got %v", env)
+	}
+	if got := graph.Nodes["b"].WorkDir(); got != "legacy" {
+		t.Errorf("expected tool_workdir fallback, got %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ashka-vakil/attractor/pkg/agent"
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+	"github.com/ashka-vakil/attractor/pkg/bus"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// AgentBackend is a CodergenBackend that runs each stage as a coding agent
+// session, so stages can read and edit files and run commands. The session
+// works in the node's workdir with its env.* variables, as a ToolHandler
+// command would, except that a relative workdir is resolved against WorkDir.
+//
+// The stage prompt is sent as the session's task, with the outcome contract
+// of OutcomeSchema appended, and the agent's final message is mapped to the
+// stage outcome. The files the agent changed are listed in the context as
+// agent.changed_files. A session stopped by Config.MaxTurns fails the stage
+// and sets agent.turn_limit_reached to "true".
+type AgentBackend struct {
+	Client  *llm.Client
+	Profile *agent.ProviderProfile
+	Config  agent.SessionConfig
+	// WorkDir is the base directory for stages; empty means the current
+	// directory.
+	WorkDir string
+	Secrets *secrets.Store
+	// Policy restricts the agent's tools. If nil, file tools are jailed to
+	// the stage's directory.
+	Policy *env.Policy
+	// Shell runs the agent's bash commands; the zero value uses
+	// env.DefaultShell.
+	Shell env.Shell
+	// Bus, if set, receives the session's events on bus.TopicAgent, with
+	// secret values redacted and the stage's node ID in Data["node"].
+	Bus *bus.Bus
+}
+
+// Run submits the prompt to a new agent session and returns its final
+// response and outcome. A node's llm_model overrides the profile's model and
+// its timeout bounds the session.
+func (b *AgentBackend) Run(node *pipeline.Node, prompt string, ctx *pipeline.Context) (interface{}, error) {
+	dir, vars, err := stageEnvironment(b.Secrets, node, b.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	localEnv := env.NewLocalEnvironment(dir)
+	localEnv.Env = vars
+	localEnv.Secrets = b.Secrets
+	localEnv.Policy = b.Policy
+	localEnv.Shell = b.Shell
+	if localEnv.Policy == nil {
+		localEnv.Policy = &env.Policy{JailPaths: true}
+	}
+
+	profile := *b.Profile
+	if node.LLMModel != "" {
+		profile.Model = node.LLMModel
+	}
+	session := agent.NewSession(b.Client, &profile, localEnv, b.Config)
+	defer session.Close()
+	session.Prompt = agent.NewPromptBuilder(localEnv.WorkDir)
+	if b.Bus != nil {
+		session.EventEmitter.On(func(ev agent.Event) {
+			data := map[string]interface{}{"node": node.ID}
+			for k, v := range ev.Data {
+				data[k] = b.Secrets.RedactValue(v)
+			}
+			b.Bus.Publish(bus.Message{Topic: bus.TopicAgent, Type: string(ev.Type), Timestamp: ev.Timestamp, Data: data})
+		})
+	}
+
+	runCtx := context.Background()
+	if node.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, node.Timeout)
+		defer cancel()
+	}
+
+	// A budget stop still ends with a summary turn, so report it as the
+	// stage's response.
+	var budgetErr *agent.BudgetExceededError
+	if err := session.Submit(runCtx, agentTask(node, prompt)); err != nil && !errors.As(err, &budgetErr) {
+		return nil, err
+	}
+
+	var text string
+	var filtered bool
+	for i := len(session.History) - 1; i >= 0; i-- {
+		if at, ok := session.History[i].(*agent.AssistantTurn); ok {
+			text = at.Content
+			filtered = at.FinishReason == llm.FinishReasonContentFilter
+			break
+		}
+	}
+	outcome, err := ParseOutcomeResponse(text)
+	if err != nil {
+		outcome = &pipeline.Outcome{Status: pipeline.StatusSuccess, Notes: text}
+	} else {
+		text = outcome.Notes
+	}
+	if filtered {
+		outcome = contentFilteredOutcome()
+	}
+	if budgetErr != nil {
+		outcome.Status = pipeline.StatusFail
+		outcome.FailureReason = budgetErr.Error()
+	}
+	turnLimit := session.State == agent.StateTurnLimitReached
+	if turnLimit {
+		outcome.Status = pipeline.StatusFail
+		outcome.FailureReason = fmt.Sprintf("agent reached its limit of %d turns before finishing", b.Config.MaxTurns)
+	}
+	changed := []string{}
+	for _, f := range session.Changes().Files {
+		changed = append(changed, f.Path)
+	}
+	if outcome.ContextUpdates == nil {
+		outcome.ContextUpdates = make(map[string]interface{})
+	}
+	outcome.ContextUpdates["agent.changed_files"] = changed
+	outcome.ContextUpdates["agent.turn_limit_reached"] = strconv.FormatBool(turnLimit)
+
+	usage := session.Usage()
+	return &BackendResult{
+		Text:    text,
+		Outcome: outcome,
+		Usage: pipeline.Usage{
+			InputTokens:  usage.Total.InputTokens,
+			OutputTokens: usage.Total.OutputTokens,
+			TotalTokens:  usage.Total.TotalTokens,
+			CostUSD:      usage.CostUSD,
+		},
+	}, nil
+}
+
+// agentTask frames a stage prompt as an agent session task.
+func agentTask(node *pipeline.Node, prompt string) string {
+	stage := node.ID
+	if node.Label != "" && node.Label != node.ID {
+		stage += " (" + node.Label + ")"
+	}
+	return "You are carrying out pipeline stage " + stage + ". Use your tools to do the task in the current " +
+		"directory, then reply with the stage outcome.\n\nTask:\n" + prompt + outcomeInstructions
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/internal/testutil"
+	"github.com/ashka-vakil/attractor/pkg/agent"
+	"github.com/ashka-vakil/attractor/pkg/bus"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+func TestAgentBackendUsesNodeWorkdirAndEnv(t *testing.T) {
+	base := t.TempDir()
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		if len(adapter.CompleteCalls) == 1 {
+			return testutil.MockToolCallResponse([]llm.ToolCall{{
+				ID:        "call-1",
+				Name:      "bash",
+				Arguments: json.RawMessage(`{"command":"echo \"$STAGE_NAME\"; pwd"}`),
+			}}), nil
+		}
+		return testutil.MockResponse("done"), nil
+	}
+	backend := &AgentBackend{
+		Client:  testutil.NewMockClient(adapter),
+		Profile: &agent.ProviderProfile{Name: "mock", Provider: "mock", Model: "mock-model", Tools: agent.DefaultToolSet()},
+		Config:  agent.DefaultSessionConfig(),
+		WorkDir: base,
+		Bus:     bus.New(),
+	}
+	sub := backend.Bus.Subscribe(bus.WithTopics(bus.TopicAgent))
+	node := &pipeline.Node{ID: "impl", Attrs: map[string]string{"workdir": ".", "env.STAGE_NAME": "impl-stage"}}
+
+	result, err := backend.Run(node, "Build it", pipeline.NewContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if br, ok := result.(*BackendResult); !ok || br.Text != "done" {
+		t.Errorf("expected final response %q, got %#v", "done", result)
+	}
+
+	if len(adapter.CompleteCalls) < 2 {
+		t.Fatalf("expected a follow-up call with the tool result, got %d calls", len(adapter.CompleteCalls))
+	}
+	var toolOutput string
+	for _, msg := range adapter.CompleteCalls[1].Messages {
+		if msg.Role == llm.RoleTool {
+			toolOutput += msg.Content
+		}
+	}
+	if !strings.Contains(toolOutput, "impl-stage") {
+		t.Errorf("expected env.STAGE_NAME in bash output, got %q", toolOutput)
+	}
+	if abs, _ := filepath.Abs(base); !strings.Contains(toolOutput, abs) {
+		t.Errorf("expected bash to run in %s, got %q", abs, toolOutput)
+	}
+
+	sub.Close()
+	var sawToolCall bool
+	for msg := range sub.C() {
+		if msg.Data["node"] != "impl" {
+			t.Errorf("expected agent events tagged with node impl, got %v", msg.Data)
+		}
+		if msg.Type == string(agent.EventToolCallStarted) {
+			sawToolCall = true
+		}
+	}
+	if !sawToolCall {
+		t.Error("expected the tool call to be published on the bus")
+	}
+}
+
+func TestAgentBackendMapsFinalMessageToOutcome(t *testing.T) {
+	dir := t.TempDir()
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		if len(adapter.CompleteCalls) == 1 {
+			return testutil.MockToolCallResponse([]llm.ToolCall{{
+				ID:        "call-1",
+				Name:      "write_file",
+				Arguments: json.RawMessage(`{"path":"fix.go","content":"package fix\n"}`),
+			}}), nil
+		}
+		return testutil.MockResponse(`{"status": "retry", "notes": "tests still fail", "context_updates": {"tests_failed": "true"}}`), nil
+	}
+	h := &CodergenHandler{Backend: &AgentBackend{
+		Client:  testutil.NewMockClient(adapter),
+		Profile: &agent.ProviderProfile{Name: "mock", Provider: "mock", Model: "mock-model", Tools: agent.DefaultToolSet()},
+		Config:  agent.DefaultSessionConfig(),
+		WorkDir: dir,
+	}}
+	node := &pipeline.Node{ID: "fix", Label: "Fix tests", Prompt: "Make the tests pass", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	task := adapter.CompleteCalls[0].Messages[len(adapter.CompleteCalls[0].Messages)-1].Content
+	if !strings.Contains(task, "fix (Fix tests)") || !strings.Contains(task, "Make the tests pass") || !strings.Contains(task, `"status"`) {
+		t.Errorf("expected the prompt framed as a stage task with the outcome contract, got %q", task)
+	}
+	if outcome.Status != pipeline.StatusRetry || outcome.FailureReason != "tests still fail" {
+		t.Errorf("expected the agent's retry outcome, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+	if outcome.ContextUpdates["tests_failed"] != "true" {
+		t.Errorf("expected the agent's context updates, got %v", outcome.ContextUpdates)
+	}
+	changed, _ := outcome.ContextUpdates["agent.changed_files"].([]string)
+	if len(changed) != 1 || !strings.HasSuffix(changed[0], "fix.go") {
+		t.Errorf("expected fix.go in agent.changed_files, got %v", outcome.ContextUpdates["agent.changed_files"])
+	}
+}
+
+func TestAgentBackendFailsAtTurnLimit(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		return testutil.MockToolCallResponse([]llm.ToolCall{{
+			ID:        "call-1",
+			Name:      "write_file",
+			Arguments: json.RawMessage(`{"path":"fix.go","content":"package fix\n"}`),
+		}}), nil
+	}
+	config := agent.DefaultSessionConfig()
+	config.MaxTurns = 1
+	h := &CodergenHandler{Backend: &AgentBackend{
+		Client:  testutil.NewMockClient(adapter),
+		Profile: &agent.ProviderProfile{Name: "mock", Provider: "mock", Model: "mock-model", Tools: agent.DefaultToolSet()},
+		Config:  config,
+		WorkDir: t.TempDir(),
+	}}
+	node := &pipeline.Node{ID: "fix", Prompt: "Make the tests pass", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail || !strings.Contains(outcome.FailureReason, "limit of 1 turns") {
+		t.Errorf("expected the turn limit to fail the stage, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+	if outcome.ContextUpdates["agent.turn_limit_reached"] != "true" {
+		t.Errorf("expected agent.turn_limit_reached, got %v", outcome.ContextUpdates)
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+// BackendResult is a codergen backend response that carries token usage.
+// The codergen handler copies Usage onto the stage outcome so the engine
+// can account for it against the run budget. Outcome, when set, is the
+// outcome the model reported and replaces the default success. Confidence,
+// when set, is the model's 0-1 confidence in Text, from its token logprobs.
+type BackendResult struct {
+	Text       string
+	Usage      pipeline.Usage
+	Outcome    *pipeline.Outcome
+	Confidence *float64
+}
+
+// contentFilteredOutcome fails a stage whose response the provider's content
+// filter withheld or cut short, rather than passing on a partial answer.
+func contentFilteredOutcome() *pipeline.Outcome {
+	return &pipeline.Outcome{
+		Status:        pipeline.StatusFail,
+		FailureReason: "response blocked by the provider's content filter",
+	}
+}
+
+// LLMBackend is a CodergenBackend that sends the stage prompt to an LLM client
+// and reports token usage and estimated cost for each call.
+type LLMBackend struct {
+	Client       *llm.Client
+	DefaultModel string
+}
+
+// Run sends the prompt as a single user message using the node's model
+// settings. A node with structured_outcome=true asks for a response
+// following OutcomeSchema and reports its notes as the response text; a
+// response that doesn't parse is kept as plain text. A response the
+// provider's content filter stopped fails the stage. A node with
+// logprobs=true or a confidence_threshold asks for token logprobs and
+// reports the response's confidence.
+func (b *LLMBackend) Run(node *pipeline.Node, prompt string, ctx *pipeline.Context) (interface{}, error) {
+	model := node.LLMModel
+	if model == "" {
+		model = b.DefaultModel
+	}
+
+	req := &llm.Request{
+		Model:           model,
+		Provider:        node.LLMProvider,
+		Messages:        []llm.Message{{Role: llm.RoleUser, Content: prompt}},
+		ReasoningEffort: node.ReasoningEffort,
+		Logprobs:        node.Attrs["logprobs"] == "true" || node.Attrs["confidence_threshold"] != "",
+	}
+	structured := structuredOutcome(node)
+	if structured {
+		req.Messages[0].Content = prompt + outcomeInstructions
+		req.ResponseFormat = &llm.ResponseFormat{Type: "json_schema", JSONSchema: OutcomeSchema}
+	}
+
+	resp, err := b.Client.Complete(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	text := resp.Content
+	var outcome *pipeline.Outcome
+	if structured {
+		if parsed, err := ParseOutcomeResponse(resp.Content); err == nil {
+			outcome, text = parsed, parsed.Notes
+		}
+	}
+	if resp.FinishReason == llm.FinishReasonContentFilter {
+		outcome = contentFilteredOutcome()
+	}
+
+	result := &BackendResult{
+		Text:    text,
+		Outcome: outcome,
+		Usage: pipeline.Usage{
+			InputTokens:  resp.Usage.InputTokens,
+			OutputTokens: resp.Usage.OutputTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+			CostUSD:      llm.EstimateCost(model, resp.Usage),
+		},
+	}
+	if confidence, ok := resp.Confidence(); ok {
+		result.Confidence = &confidence
+	}
+	return result, nil
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/internal/testutil"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+func TestLLMBackendReportsUsage(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		resp := testutil.MockResponse("generated")
+		resp.Usage = llm.Usage{InputTokens: 1000, OutputTokens: 500, TotalTokens: 1500}
+		return resp, nil
+	}
+	backend := &LLMBackend{Client: testutil.NewMockClient(adapter), DefaultModel: "claude-sonnet-4-5-20250929"}
+
+	h := &CodergenHandler{Backend: backend}
+	node := &pipeline.Node{ID: "impl", Prompt: "Write the code", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Usage == nil {
+		t.Fatal("expected usage on outcome")
+	}
+	if outcome.Usage.TotalTokens != 1500 {
+		t.Errorf("expected 1500 tokens, got %d", outcome.Usage.TotalTokens)
+	}
+	if outcome.Usage.CostUSD <= 0 {
+		t.Errorf("expected positive cost for a priced model, got %v", outcome.Usage.CostUSD)
+	}
+	if got := adapter.CompleteCalls[0].Model; got != "claude-sonnet-4-5-20250929" {
+		t.Errorf("expected default model, got %q", got)
+	}
+}
+
+func TestLLMBackendStructuredOutcome(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		return testutil.MockResponse("```json\n" + `{"status": "fail", "notes": "tests still fail", "context_updates": [{"key": "tests_failed", "value": "true"}, {"key": "graph.goal", "value": "x"}, {"key": "last_stage", "value": "x"}], "suggested_next": ["fix"]}` + "\n```"), nil
+	}
+	h := &CodergenHandler{Backend: &LLMBackend{Client: testutil.NewMockClient(adapter), DefaultModel: "mock-model"}}
+	node := &pipeline.Node{ID: "test", Prompt: "Run the tests", Attrs: map[string]string{"structured_outcome": "true"}}
+	logsRoot := t.TempDir()
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, logsRoot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := adapter.CompleteCalls[0]
+	if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_schema" {
+		t.Errorf("expected json_schema response format, got %+v", req.ResponseFormat)
+	}
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected fail, got %s", outcome.Status)
+	}
+	if outcome.FailureReason != "tests still fail" {
+		t.Errorf("expected failure reason from notes, got %q", outcome.FailureReason)
+	}
+	if outcome.ContextUpdates["tests_failed"] != "true" || outcome.ContextUpdates["last_stage"] != "test" {
+		t.Errorf("expected model and default context updates, got %v", outcome.ContextUpdates)
+	}
+	if _, ok := outcome.ContextUpdates["graph.goal"]; ok {
+		t.Errorf("expected reserved keys to be dropped, got %v", outcome.ContextUpdates)
+	}
+	if outcome.ContextUpdates["last_response"] != "tests still fail" {
+		t.Errorf("expected the notes as the response, got %v", outcome.ContextUpdates["last_response"])
+	}
+	if response, _ := os.ReadFile(filepath.Join(logsRoot, "test", "response.md")); string(response) != "tests still fail" {
+		t.Errorf("expected the notes in response.md, got %q", response)
+	}
+	if len(outcome.SuggestedNextIDs) != 1 || outcome.SuggestedNextIDs[0] != "fix" {
+		t.Errorf("expected suggested next [fix], got %v", outcome.SuggestedNextIDs)
+	}
+}
+
+func TestLLMBackendUnstructuredResponse(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		return testutil.MockResponse("just some prose"), nil
+	}
+	h := &CodergenHandler{Backend: &LLMBackend{Client: testutil.NewMockClient(adapter), DefaultModel: "mock-model"}}
+	node := &pipeline.Node{ID: "impl", Prompt: "Write the code", Attrs: map[string]string{"structured_outcome": "true"}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusSuccess {
+		t.Errorf("expected success for an unparsed response, got %s", outcome.Status)
+	}
+}
+
+func TestLLMBackendPlainTextByDefault(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	backend := &LLMBackend{Client: testutil.NewMockClient(adapter), DefaultModel: "mock-model"}
+	node := &pipeline.Node{ID: "impl", Attrs: map[string]string{}}
+	if _, err := backend.Run(node, "Write the code", pipeline.NewContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := adapter.CompleteCalls[0]
+	if req.ResponseFormat != nil {
+		t.Errorf("expected no response format, got %+v", req.ResponseFormat)
+	}
+	if req.Messages[0].Content != "Write the code" {
+		t.Errorf("expected prompt unchanged, got %q", req.Messages[0].Content)
+	}
+}
+
+func TestParseOutcomeResponse(t *testing.T) {
+	invalid := []string{
+		"not json",
+		`{"notes": "no status"}`,
+		`{"status": "great", "notes": "x"}`,
+	}
+	for _, text := range invalid {
+		if _, err := ParseOutcomeResponse(text); err == nil {
+			t.Errorf("expected error for %q", text)
+		}
+	}
+
+	outcome, err := ParseOutcomeResponse(`{"status": "partial_success", "notes": "half done"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusPartialSuccess || outcome.Notes != "half done" || outcome.FailureReason != "" {
+		t.Errorf("unexpected outcome %+v", outcome)
+	}
+
+	// Both the schema's key/value list and a plain object are accepted.
+	for _, text := range []string{
+		`{"status": "success", "notes": "ok", "context_updates": [{"key": "k", "value": "v"}]}`,
+		`{"status": "success", "notes": "ok", "context_updates": {"k": "v"}}`,
+	} {
+		outcome, err := ParseOutcomeResponse(text)
+		if err != nil || outcome.ContextUpdates["k"] != "v" {
+			t.Errorf("ParseOutcomeResponse(%s) = %+v, %v", text, outcome, err)
+		}
+	}
+}
+
+func TestLLMBackendConfidence(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		resp := testutil.MockResponse("yes")
+		resp.Logprobs = []llm.TokenLogprob{{Token: "yes", Logprob: -0.5}}
+		return resp, nil
+	}
+	h := &CodergenHandler{Backend: &LLMBackend{Client: testutil.NewMockClient(adapter), DefaultModel: "mock-model"}}
+	node := &pipeline.Node{ID: "judge", Prompt: "Is it done?", Attrs: map[string]string{"confidence_threshold": "0.8"}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !adapter.CompleteCalls[0].Logprobs {
+		t.Error("expected logprobs to be requested")
+	}
+	if got := outcome.ContextUpdates["confidence"]; got != "0.607" {
+		t.Errorf("confidence = %v, want 0.607", got)
+	}
+	if got := outcome.ContextUpdates["confident"]; got != "false" {
+		t.Errorf("confident = %v, want false", got)
+	}
+
+	// A provider that returns no logprobs leaves the stage unconfident.
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		return testutil.MockResponse("yes"), nil
+	}
+	outcome, err = h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := outcome.ContextUpdates["confident"]; got != "false" {
+		t.Errorf("confident without logprobs = %v, want false", got)
+	}
+	if _, ok := outcome.ContextUpdates["confidence"]; ok || !strings.Contains(outcome.Notes, "no logprobs") {
+		t.Errorf("expected a warning and no confidence, got %+v", outcome)
+	}
+}
+
+func TestLLMBackendContentFilter(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		resp := testutil.MockResponse(`{"status": "success"}`)
+		resp.FinishReason = llm.FinishReasonContentFilter
+		return resp, nil
+	}
+	h := &CodergenHandler{Backend: &LLMBackend{Client: testutil.NewMockClient(adapter), DefaultModel: "mock-model"}}
+	node := &pipeline.Node{ID: "impl", Prompt: "Write the code", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail || !strings.Contains(outcome.FailureReason, "content filter") {
+		t.Errorf("outcome = %s (%q), want a content filter failure", outcome.Status, outcome.FailureReason)
+	}
+}
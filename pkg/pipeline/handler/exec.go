@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// ExecTypePrefix marks a node type that is handled by an external program,
+// e.g. type="exec:./handlers/lint.py".
+const ExecTypePrefix = "exec:"
+
+// ExecRequest is written as JSON to an external handler's stdin.
+type ExecRequest struct {
+	Node     *pipeline.Node         `json:"node"`
+	Context  map[string]interface{} `json:"context"`
+	Graph    ExecGraphInfo          `json:"graph"`
+	LogsRoot string                 `json:"logs_root,omitempty"`
+}
+
+// ExecGraphInfo is the subset of graph metadata passed to external handlers.
+type ExecGraphInfo struct {
+	Name  string            `json:"name"`
+	Goal  string            `json:"goal,omitempty"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// ExecHandler runs an external program as a node handler using a JSON-over-stdio
+// protocol: the program reads an ExecRequest from stdin and writes an outcome
+// object (the same JSON shape as status.json) to stdout. A non-zero exit status
+// fails the stage with the program's stderr as the failure reason. The program
+// runs in the node's workdir with its env.* variables, and the context it is
+// given has secret values redacted and keys matching Scrubber scrubbed.
+type ExecHandler struct {
+	Binary   string
+	Args     []string
+	Secrets  *secrets.Store
+	Scrubber *secrets.Scrubber
+}
+
+func (h *ExecHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
+	outcome, err := h.run(node, ctx, graph, logsRoot)
+	if err != nil || logsRoot == "" {
+		return outcome, err
+	}
+	stageDir := filepath.Join(logsRoot, node.ID)
+	os.MkdirAll(stageDir, 0o755)
+	writeStatus(stageDir, outcome, h.Scrubber)
+	return outcome, nil
+}
+
+func (h *ExecHandler) run(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
+	if h.Binary == "" {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: "No handler binary specified",
+		}, nil
+	}
+
+	// The program is outside the engine's redaction, so it only sees the
+	// context as a checkpoint would record it.
+	snapshot := h.Scrubber.ScrubMap(ctx.Snapshot())
+	for k, v := range snapshot {
+		snapshot[k] = h.Secrets.RedactValue(v)
+	}
+	input, err := json.Marshal(&ExecRequest{
+		Node:    node,
+		Context: snapshot,
+		Graph: ExecGraphInfo{
+			Name:  graph.Name,
+			Goal:  graph.Goal,
+			Attrs: graph.Attrs,
+		},
+		LogsRoot: logsRoot,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode exec request: %w", err)
+	}
+
+	dir, vars, err := stageEnvironment(h.Secrets, node, "")
+	if err != nil {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: err.Error(),
+		}, nil
+	}
+
+	runCtx := context.Background()
+	if node.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, node.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, h.Binary, h.Args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), vars...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = err.Error()
+		}
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: h.Secrets.Redact(fmt.Sprintf("handler %s failed: %s", h.Binary, reason)),
+		}, nil
+	}
+
+	var outcome pipeline.Outcome
+	if err := json.Unmarshal(stdout.Bytes(), &outcome); err != nil {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: fmt.Sprintf("handler %s returned invalid outcome: %v", h.Binary, err),
+		}, nil
+	}
+	switch outcome.Status {
+	case "":
+		outcome.Status = pipeline.StatusSuccess
+	case pipeline.StatusSuccess, pipeline.StatusPartialSuccess, pipeline.StatusSkipped:
+	case pipeline.StatusFail, pipeline.StatusRetry:
+	default:
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: fmt.Sprintf("handler %s returned unknown outcome status %q", h.Binary, outcome.Status),
+		}, nil
+	}
+	return &outcome, nil
+}
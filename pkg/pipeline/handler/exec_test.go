@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts not supported on windows")
+	}
+	path := filepath.Join(t.TempDir(), "handler.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	return path
+}
+
+func TestExecHandlerProtocol(t *testing.T) {
+	script := writeScript(t, `input=$(cat)
+case "$input" in
+  *'"id":"lint"'*'"repo":"attractor"'*) ;;
+  *) echo "unexpected input: $input" >&2; exit 3 ;;
+esac
+echo '{"outcome":"partial_success","notes":"2 warnings","context_updates":{"lint.warnings":"2"}}'
+`)
+
+	ctx := pipeline.NewContext()
+	ctx.Set("repo", "attractor")
+	node := &pipeline.Node{ID: "lint", Type: "exec:" + script, Attrs: map[string]string{}}
+
+	registry := NewRegistry(nil, nil)
+	h := registry.Resolve(node)
+	if _, ok := h.(*ExecHandler); !ok {
+		t.Fatalf("expected ExecHandler, got %T", h)
+	}
+
+	outcome, err := h.Execute(node, ctx, &pipeline.Graph{Name: "test"}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusPartialSuccess {
+		t.Fatalf("expected PARTIAL_SUCCESS, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+	if outcome.ContextUpdates["lint.warnings"] != "2" {
+		t.Errorf("expected context update from handler, got %v", outcome.ContextUpdates)
+	}
+}
+
+func TestExecHandlerNonZeroExit(t *testing.T) {
+	script := writeScript(t, "echo 'boom' >&2\nexit 1\n")
+
+	h := &ExecHandler{Binary: script}
+	node := &pipeline.Node{ID: "n", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL, got %s", outcome.Status)
+	}
+	if !strings.Contains(outcome.FailureReason, "boom") {
+		t.Errorf("expected stderr in failure reason, got %q", outcome.FailureReason)
+	}
+}
+
+func TestExecHandlerInvalidOutput(t *testing.T) {
+	script := writeScript(t, "cat >/dev/null\necho not-json\n")
+
+	h := &ExecHandler{Binary: script}
+	node := &pipeline.Node{ID: "n", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL for invalid output, got %s", outcome.Status)
+	}
+}
+
+func TestExecHandlerStageEnvironment(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_DB_PASSWORD", "hunter2")
+	store := secrets.FromEnv()
+	scrubber, err := secrets.NewScrubber("(?i)token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := writeScript(t, `input=$(cat)
+case "$input" in
+  *hunter2*|*tok_live*) echo "secret in input" >&2; exit 3 ;;
+esac
+echo "{\"outcome\":\"success\",\"context_updates\":{\"dir\":\"$(pwd)\",\"pass\":\"$DB_PASS\"}}"
+`)
+	workdir := t.TempDir()
+	logsRoot := t.TempDir()
+
+	h := &ExecHandler{Binary: script, Secrets: store, Scrubber: scrubber}
+	node := &pipeline.Node{ID: "n", Attrs: map[string]string{
+		"workdir":     workdir,
+		"env.DB_PASS": "${secret:DB_PASSWORD}",
+	}}
+	ctx := pipeline.NewContext()
+	ctx.Set("api_token", "tok_live")
+	outcome, err := h.Execute(node, ctx, &pipeline.Graph{}, logsRoot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx.Set("echo", "hunter2")
+	if outcome.Status != pipeline.StatusSuccess {
+		t.Fatalf("expected SUCCESS, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+	wantDir, _ := filepath.EvalSymlinks(workdir)
+	if gotDir, _ := filepath.EvalSymlinks(outcome.ContextUpdates["dir"].(string)); gotDir != wantDir {
+		t.Errorf("expected handler to run in %s, got %v", wantDir, outcome.ContextUpdates["dir"])
+	}
+	if outcome.ContextUpdates["pass"] != "hunter2" {
+		t.Errorf("expected env.DB_PASS in the handler's environment, got %v", outcome.ContextUpdates["pass"])
+	}
+
+	// A second run sees the resolved secret in the context redacted.
+	outcome, err = h.Execute(node, ctx, &pipeline.Graph{}, logsRoot)
+	if err != nil || outcome.Status != pipeline.StatusSuccess {
+		t.Fatalf("expected SUCCESS, got %v %v", outcome, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logsRoot, "n", "status.json"))
+	if err != nil {
+		t.Fatalf("expected status.json: %v", err)
+	}
+	if !strings.Contains(string(data), `"success"`) {
+		t.Errorf("unexpected status.json: %s", data)
+	}
+}
+
+func TestExecHandlerUnknownStatus(t *testing.T) {
+	script := writeScript(t, "cat >/dev/null\necho '{\"outcome\":\"done\"}'\n")
+
+	h := &ExecHandler{Binary: script}
+	node := &pipeline.Node{ID: "n", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail || !strings.Contains(outcome.FailureReason, `"done"`) {
+		t.Errorf("expected FAIL for unknown status, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+}
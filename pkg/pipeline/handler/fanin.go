@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// Fan-in aggregation strategies, set with a fan-in node's fan_in.strategy
+// attribute.
+const (
+	// FanInConcat joins every branch's output into parallel.fan_in.output.
+	FanInConcat = "concat"
+	// FanInVote gives the fan-in the most common branch outcome.
+	FanInVote = "vote"
+	// FanInBest asks the LLM backend to pick the best branch output.
+	FanInBest = "best"
+)
+
+// FanInHandler consolidates parallel results. Without a fan_in.strategy it
+// only checks that there are results; with one it aggregates the branches'
+// outputs into context keys for downstream stages:
+//
+//   - concat writes every branch's output, headed by its branch, to
+//     parallel.fan_in.output.
+//   - vote succeeds, partially succeeds, or fails with the most common
+//     branch outcome, the less successful one on a tie, and writes it to
+//     parallel.fan_in.outcome.
+//   - best sends the outputs to Backend with the node's prompt, asking for
+//     the chosen candidate as JSON, and writes the branch it names to
+//     parallel.fan_in.best and that branch's output to
+//     parallel.fan_in.output. An answer that names no candidate fails the
+//     stage. As for codergen stages, secrets are expanded in the prompt
+//     sent and redacted from the prompt, response, and status logged.
+//
+// A branch's output is the value of the fan_in.key context key set by its
+// last stage, or that stage's notes. For the default key, last_response,
+// which is truncated in the context, it is the stage's full response.md.
+type FanInHandler struct {
+	Backend  CodergenBackend
+	Secrets  *secrets.Store
+	Scrubber *secrets.Scrubber
+}
+
+func (h *FanInHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
+	resultsJSON := ctx.GetString("parallel.results")
+	if resultsJSON == "" {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: "No parallel results to evaluate",
+		}, nil
+	}
+
+	var results []*pipeline.BranchResult
+	if strategy := node.Attrs["fan_in.strategy"]; strategy != "" {
+		if err := json.Unmarshal([]byte(resultsJSON), &results); err != nil {
+			return &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: fmt.Sprintf("Invalid parallel results: %v", err),
+			}, nil
+		}
+		if len(results) == 0 {
+			return &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: "No parallel results to evaluate",
+			}, nil
+		}
+	}
+
+	var outcome *pipeline.Outcome
+	switch strategy := node.Attrs["fan_in.strategy"]; strategy {
+	case "":
+		outcome = &pipeline.Outcome{Status: pipeline.StatusSuccess, Notes: "Fan-in completed"}
+	case FanInConcat:
+		outcome = &pipeline.Outcome{
+			Status:         pipeline.StatusSuccess,
+			Notes:          fmt.Sprintf("Concatenated %d branch outputs", len(results)),
+			ContextUpdates: map[string]interface{}{"parallel.fan_in.output": concatOutputs(node, results)},
+		}
+	case FanInVote:
+		status := voteOutcome(results)
+		outcome = &pipeline.Outcome{
+			Status:         status,
+			Notes:          fmt.Sprintf("Majority outcome of %d branches: %s", len(results), status),
+			ContextUpdates: map[string]interface{}{"parallel.fan_in.outcome": string(status)},
+		}
+		if status == pipeline.StatusFail {
+			outcome.FailureReason = "most parallel branches failed"
+		}
+	case FanInBest:
+		var err error
+		outcome, err = h.pickBest(node, ctx, graph, logsRoot, results)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: fmt.Sprintf("Unknown fan_in.strategy %q (want concat, vote, or best)", strategy),
+		}, nil
+	}
+
+	if outcome.ContextUpdates == nil {
+		outcome.ContextUpdates = make(map[string]interface{})
+	}
+	outcome.ContextUpdates["parallel.fan_in.complete"] = "true"
+	return outcome, nil
+}
+
+// branchOutput returns the output of a branch, as FanInHandler describes.
+func branchOutput(node *pipeline.Node, r *pipeline.BranchResult) string {
+	if r.Outcome == nil {
+		return ""
+	}
+	key := node.Attrs["fan_in.key"]
+	if key == "" {
+		key = "last_response"
+	}
+	if key == "last_response" && r.LogsDir != "" && len(r.CompletedNodes) > 0 {
+		last := r.CompletedNodes[len(r.CompletedNodes)-1]
+		if data, err := os.ReadFile(filepath.Join(r.LogsDir, last, "response.md")); err == nil {
+			return string(data)
+		}
+	}
+	if v, ok := r.Outcome.ContextUpdates[key]; ok {
+		return fmt.Sprint(v)
+	}
+	return r.Outcome.Notes
+}
+
+func concatOutputs(node *pipeline.Node, results []*pipeline.BranchResult) string {
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "## %s\n\n%s", r.Branch, branchOutput(node, r))
+	}
+	return b.String()
+}
+
+// voteOutcome returns the most common branch outcome, counting partial
+// successes apart from successes and treating a missing outcome as a
+// failure. A tie goes to the less successful outcome.
+func voteOutcome(results []*pipeline.BranchResult) pipeline.StageStatus {
+	ranked := []pipeline.StageStatus{pipeline.StatusFail, pipeline.StatusPartialSuccess, pipeline.StatusSuccess}
+	counts := make(map[pipeline.StageStatus]int)
+	for _, r := range results {
+		switch {
+		case r.Outcome == nil:
+			counts[pipeline.StatusFail]++
+		case r.Outcome.Status == pipeline.StatusSuccess, r.Outcome.Status == pipeline.StatusPartialSuccess:
+			counts[r.Outcome.Status]++
+		default:
+			counts[pipeline.StatusFail]++
+		}
+	}
+	best := ranked[0]
+	for _, status := range ranked[1:] {
+		if counts[status] > counts[best] {
+			best = status
+		}
+	}
+	return best
+}
+
+// pickBest asks the backend which branch output is best, by candidate
+// number in a JSON answer. Without a backend the first successful branch is
+// taken.
+func (h *FanInHandler) pickBest(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string, results []*pipeline.BranchResult) (*pipeline.Outcome, error) {
+	criteria := node.Prompt
+	if criteria == "" {
+		criteria = "Pick the candidate that best achieves the goal: $goal"
+	}
+	var b strings.Builder
+	b.WriteString(expandVariables(criteria, node, graph, ctx))
+	b.WriteString("\n\nReply with a single JSON object and nothing else: {\"best\": <number of the best candidate>}\n")
+	for i, r := range results {
+		fmt.Fprintf(&b, "\n### Candidate %d (%s)\n\n%s\n", i+1, r.Branch, branchOutput(node, r))
+	}
+	prompt := b.String()
+
+	stageDir := filepath.Join(logsRoot, node.ID)
+	os.MkdirAll(stageDir, 0o755)
+	os.WriteFile(filepath.Join(stageDir, "prompt.md"), []byte(h.Secrets.Redact(prompt)), 0o644)
+
+	chosen := -1
+	if h.Backend != nil {
+		expanded, err := h.Secrets.Expand(prompt)
+		if err != nil {
+			return h.finish(stageDir, &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: err.Error(),
+			}), nil
+		}
+		result, err := h.Backend.Run(node, expanded, ctx)
+		if err != nil {
+			return h.finish(stageDir, &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: h.Secrets.Redact(fmt.Sprintf("Scoring branches: %v", err)),
+			}), nil
+		}
+		response := h.Secrets.Redact(backendText(result))
+		os.WriteFile(filepath.Join(stageDir, "response.md"), []byte(response), 0o644)
+		if chosen = chosenBranch(response, len(results)); chosen < 0 {
+			return h.finish(stageDir, &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: fmt.Sprintf("Scoring branches: the answer names no candidate from 1 to %d", len(results)),
+			}), nil
+		}
+	}
+	if chosen < 0 {
+		for i, r := range results {
+			if r.Outcome != nil && (r.Outcome.Status == pipeline.StatusSuccess || r.Outcome.Status == pipeline.StatusPartialSuccess) {
+				chosen = i
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		return h.finish(stageDir, &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: "No parallel branch succeeded to pick from",
+		}), nil
+	}
+
+	best := results[chosen]
+	return h.finish(stageDir, &pipeline.Outcome{
+		Status: pipeline.StatusSuccess,
+		Notes:  "Best branch: " + best.Branch,
+		ContextUpdates: map[string]interface{}{
+			"parallel.fan_in.best":   best.Branch,
+			"parallel.fan_in.output": h.Secrets.Redact(branchOutput(node, best)),
+		},
+	}), nil
+}
+
+// finish writes the best strategy's outcome to the stage's status.json.
+func (h *FanInHandler) finish(stageDir string, outcome *pipeline.Outcome) *pipeline.Outcome {
+	writeStatus(stageDir, outcome, h.Scrubber)
+	return outcome
+}
+
+// chosenBranch reads the candidate a scoring response names, as a JSON
+// object {"best": N} optionally in a markdown code fence. It returns the
+// candidate's index, or -1 if the response is not such an object or N is
+// not a candidate from 1 to n.
+func chosenBranch(response string, n int) int {
+	text := strings.TrimSpace(response)
+	if body, ok := strings.CutPrefix(text, "```"); ok {
+		body = strings.TrimPrefix(body, "json")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(body), "```"))
+	}
+	var answer struct {
+		Best *int `json:"best"`
+	}
+	if err := json.Unmarshal([]byte(text), &answer); err != nil || answer.Best == nil {
+		return -1
+	}
+	if *answer.Best < 1 || *answer.Best > n {
+		return -1
+	}
+	return *answer.Best - 1
+}
+
+// backendText returns the response text of a CodergenBackend result.
+func backendText(result interface{}) string {
+	switch r := result.(type) {
+	case *BackendResult:
+		return r.Text
+	case *pipeline.Outcome:
+		return r.Notes
+	}
+	return fmt.Sprint(result)
+}
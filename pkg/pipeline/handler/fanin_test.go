@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+func fanInContext(t *testing.T, results ...*pipeline.BranchResult) *pipeline.Context {
+	t.Helper()
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatalf("marshal results: %v", err)
+	}
+	ctx := pipeline.NewContext()
+	ctx.Set("parallel.results", string(data))
+	return ctx
+}
+
+func branch(id string, status pipeline.StageStatus, response string) *pipeline.BranchResult {
+	return &pipeline.BranchResult{Branch: id, Outcome: &pipeline.Outcome{
+		Status:         status,
+		ContextUpdates: map[string]interface{}{"last_response": response},
+	}}
+}
+
+func TestFanInStrategies(t *testing.T) {
+	ctx := fanInContext(t,
+		branch("security", pipeline.StatusSuccess, "No issues."),
+		branch("perf", pipeline.StatusFail, "Too slow."),
+		branch("style", pipeline.StatusFail, "Tabs."),
+	)
+	graph := &pipeline.Graph{Goal: "ship a fast service"}
+	h := &FanInHandler{}
+	run := func(attrs map[string]string) *pipeline.Outcome {
+		t.Helper()
+		outcome, err := h.Execute(&pipeline.Node{ID: "join", Attrs: attrs}, ctx, graph, t.TempDir())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if outcome.ContextUpdates["parallel.fan_in.complete"] != "true" {
+			t.Errorf("expected parallel.fan_in.complete, got %v", outcome.ContextUpdates)
+		}
+		return outcome
+	}
+
+	if o := run(map[string]string{}); o.Status != pipeline.StatusSuccess {
+		t.Errorf("expected success without a strategy, got %s", o.Status)
+	}
+
+	o := run(map[string]string{"fan_in.strategy": FanInConcat})
+	want := "## security\n\nNo issues.\n\n## perf\n\nToo slow.\n\n## style\n\nTabs."
+	if got := o.ContextUpdates["parallel.fan_in.output"]; got != want {
+		t.Errorf("expected concatenated output %q, got %q", want, got)
+	}
+
+	o = run(map[string]string{"fan_in.strategy": FanInVote})
+	if o.Status != pipeline.StatusFail || o.ContextUpdates["parallel.fan_in.outcome"] != "fail" {
+		t.Errorf("expected the majority to fail, got %s %v", o.Status, o.ContextUpdates)
+	}
+
+	o, _ = h.Execute(&pipeline.Node{ID: "join", Attrs: map[string]string{"fan_in.strategy": "average"}}, ctx, graph, t.TempDir())
+	if o.Status != pipeline.StatusFail || !strings.Contains(o.FailureReason, "average") {
+		t.Errorf("expected an unknown strategy to fail, got %+v", o)
+	}
+}
+
+func TestFanInVoteTie(t *testing.T) {
+	ctx := fanInContext(t,
+		branch("a", pipeline.StatusSuccess, ""),
+		branch("b", pipeline.StatusPartialSuccess, ""),
+	)
+	outcome, _ := (&FanInHandler{}).Execute(&pipeline.Node{ID: "join", Attrs: map[string]string{"fan_in.strategy": "vote"}}, ctx, &pipeline.Graph{}, t.TempDir())
+	if outcome.Status != pipeline.StatusPartialSuccess {
+		t.Errorf("expected a tie to go to partial_success, got %s", outcome.Status)
+	}
+}
+
+func TestFanInBest(t *testing.T) {
+	ctx := fanInContext(t,
+		branch("draft_a", pipeline.StatusSuccess, "Short answer."),
+		branch("draft_b", pipeline.StatusSuccess, "Thorough answer."),
+	)
+	node := &pipeline.Node{ID: "judge", Prompt: "Pick the most thorough draft for $goal.", Attrs: map[string]string{"fan_in.strategy": FanInBest}}
+	graph := &pipeline.Graph{Goal: "the docs"}
+	logs := t.TempDir()
+
+	h := &FanInHandler{Backend: &mockBackend{response: "```json\n{\"best\": 2}\n```"}}
+	outcome, err := h.Execute(node, ctx, graph, logs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.ContextUpdates["parallel.fan_in.best"] != "draft_b" || outcome.ContextUpdates["parallel.fan_in.output"] != "Thorough answer." {
+		t.Errorf("expected draft_b to be picked, got %v", outcome.ContextUpdates)
+	}
+	prompt, err := os.ReadFile(filepath.Join(logs, "judge", "prompt.md"))
+	if err != nil {
+		t.Fatalf("read prompt: %v", err)
+	}
+	if !strings.Contains(string(prompt), "for the docs.") || !strings.Contains(string(prompt), "### Candidate 1 (draft_a)\n\nShort answer.") {
+		t.Errorf("expected the prompt to list the candidates, got:\n%s", prompt)
+	}
+
+	// An answer that isn't the JSON choice fails rather than guessing.
+	for _, response := range []string{"Candidate 2 is best.", `{"best": 3}`, `{"choice": 1}`} {
+		h := &FanInHandler{Backend: &mockBackend{response: response}}
+		outcome, _ := h.Execute(node, ctx, graph, t.TempDir())
+		if outcome.Status != pipeline.StatusFail || outcome.ContextUpdates["parallel.fan_in.best"] != nil {
+			t.Errorf("expected %q to fail the stage, got %+v", response, outcome)
+		}
+	}
+
+	// Without a backend, the first successful branch is taken.
+	outcome, _ = (&FanInHandler{}).Execute(node, ctx, graph, t.TempDir())
+	if outcome.ContextUpdates["parallel.fan_in.best"] != "draft_a" {
+		t.Errorf("expected draft_a without a backend, got %v", outcome.ContextUpdates)
+	}
+}
+
+func TestFanInBranchOutputReadsFullResponse(t *testing.T) {
+	logs := t.TempDir()
+	full := strings.Repeat("long answer ", 50)
+	os.MkdirAll(filepath.Join(logs, "write"), 0o755)
+	os.WriteFile(filepath.Join(logs, "write", "response.md"), []byte(full), 0o644)
+
+	r := branch("draft", pipeline.StatusSuccess, truncate(full, 200))
+	r.CompletedNodes = []string{"write"}
+	r.LogsDir = logs
+	node := &pipeline.Node{ID: "join", Attrs: map[string]string{}}
+	if got := branchOutput(node, r); got != full {
+		t.Errorf("expected the full response.md, got %q", got)
+	}
+
+	node.Attrs["fan_in.key"] = "last_response"
+	r.LogsDir = ""
+	if got := branchOutput(node, r); got != truncate(full, 200) {
+		t.Errorf("expected the context value without logs, got %q", got)
+	}
+}
+
+func TestFanInBestRedactsSecrets(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_API_TOKEN", "tok-secret-1")
+	store := secrets.FromEnv()
+	store.Expand("${secret:API_TOKEN}")
+
+	ctx := fanInContext(t,
+		branch("a", pipeline.StatusSuccess, "uses tok-secret-1"),
+		branch("b", pipeline.StatusSuccess, "clean"),
+	)
+	node := &pipeline.Node{ID: "judge", Attrs: map[string]string{"fan_in.strategy": FanInBest}}
+	logs := t.TempDir()
+	h := &FanInHandler{Backend: &mockBackend{response: `{"best": 1}`}, Secrets: store}
+	outcome, _ := h.Execute(node, ctx, &pipeline.Graph{}, logs)
+	if outcome.ContextUpdates["parallel.fan_in.best"] != "a" {
+		t.Fatalf("expected branch a to be picked, got %+v", outcome)
+	}
+
+	for _, name := range []string{"prompt.md", "response.md", "status.json"} {
+		data, err := os.ReadFile(filepath.Join(logs, "judge", name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if strings.Contains(string(data), "tok-secret-1") {
+			t.Errorf("%s leaks the secret:\n%s", name, data)
+		}
+	}
+}
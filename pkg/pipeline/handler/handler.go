@@ -2,10 +2,14 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -13,7 +17,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ashka-vakil/attractor/pkg/agent/env"
+	"github.com/ashka-vakil/attractor/pkg/llm"
 	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/condition"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/notify"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // Handler is the interface for node execution.
@@ -31,28 +40,50 @@ type Registry struct {
 	mu             sync.RWMutex
 	handlers       map[string]Handler
 	defaultHandler Handler
+	secrets        *secrets.Store
+	scrubber       *secrets.Scrubber
+	shell          env.Shell
 }
 
-// ShapeToType maps DOT shapes to handler type strings.
-var ShapeToType = map[string]string{
-	"Mdiamond":       "start",
-	"Msquare":        "exit",
-	"box":            "codergen",
-	"hexagon":        "wait.human",
-	"diamond":        "conditional",
-	"component":      "parallel",
-	"tripleoctagon":  "parallel.fan_in",
-	"parallelogram":  "tool",
-	"house":          "stack.manager_loop",
+// RegistryOption configures a Registry.
+type RegistryOption func(*Registry)
+
+// WithSecrets expands ${secret:NAME} references in codergen prompts and tool
+// commands from store, and redacts resolved values from handler output.
+func WithSecrets(store *secrets.Store) RegistryOption {
+	return func(r *Registry) {
+		r.secrets = store
+	}
+}
+
+// WithScrubber redacts context updates whose keys match s from the
+// status.json files handlers write.
+func WithScrubber(s *secrets.Scrubber) RegistryOption {
+	return func(r *Registry) {
+		r.scrubber = s
+	}
+}
+
+// WithShell runs tool_command with shell instead of env.SystemShell.
+func WithShell(shell env.Shell) RegistryOption {
+	return func(r *Registry) {
+		r.shell = shell
+	}
 }
 
+// ShapeToType maps DOT shapes to handler type strings.
+var ShapeToType = pipeline.ShapeToType
+
 // NewRegistry creates a new handler registry with all built-in handlers.
-func NewRegistry(backend CodergenBackend, interviewer Interviewer) *Registry {
+func NewRegistry(backend CodergenBackend, interviewer Interviewer, opts ...RegistryOption) *Registry {
 	r := &Registry{
 		handlers: make(map[string]Handler),
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
 
-	codergen := &CodergenHandler{Backend: backend}
+	codergen := &CodergenHandler{Backend: backend, Secrets: r.secrets, Scrubber: r.scrubber}
 	r.defaultHandler = codergen
 
 	r.Register("start", &StartHandler{})
@@ -61,9 +92,11 @@ func NewRegistry(backend CodergenBackend, interviewer Interviewer) *Registry {
 	r.Register("wait.human", &WaitForHumanHandler{Interviewer: interviewer})
 	r.Register("conditional", &ConditionalHandler{})
 	r.Register("parallel", &ParallelHandler{})
-	r.Register("parallel.fan_in", &FanInHandler{})
-	r.Register("tool", &ToolHandler{})
+	r.Register("parallel.fan_in", &FanInHandler{Backend: backend, Secrets: r.secrets, Scrubber: r.scrubber})
+	r.Register("tool", &ToolHandler{Secrets: r.secrets, Shell: r.shell})
 	r.Register("stack.manager_loop", &ManagerLoopHandler{})
+	r.Register("notify", &NotifyHandler{Secrets: r.secrets})
+	r.Register("assert", &AssertHandler{})
 
 	return r
 }
@@ -85,6 +118,13 @@ func (r *Registry) Resolve(node *pipeline.Node) Handler {
 		if h, ok := r.handlers[node.Type]; ok {
 			return h
 		}
+		if strings.HasPrefix(node.Type, ExecTypePrefix) {
+			return &ExecHandler{
+				Binary:   strings.TrimPrefix(node.Type, ExecTypePrefix),
+				Secrets:  r.secrets,
+				Scrubber: r.scrubber,
+			}
+		}
 	}
 
 	// 2. Shape-based resolution
@@ -120,7 +160,9 @@ func (h *ExitHandler) Execute(_ *pipeline.Node, _ *pipeline.Context, _ *pipeline
 
 // CodergenHandler executes LLM tasks.
 type CodergenHandler struct {
-	Backend CodergenBackend
+	Backend  CodergenBackend
+	Secrets  *secrets.Store
+	Scrubber *secrets.Scrubber
 }
 
 func (h *CodergenHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
@@ -129,29 +171,54 @@ func (h *CodergenHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, gr
 	if prompt == "" {
 		prompt = node.Label
 	}
-	prompt = expandVariables(prompt, graph, ctx)
+	prompt = expandVariables(prompt, node, graph, ctx)
 
-	// 2. Write prompt to logs
+	// 2. Write prompt to logs, with secret references left unexpanded
 	stageDir := filepath.Join(logsRoot, node.ID)
 	os.MkdirAll(stageDir, 0o755)
 	os.WriteFile(filepath.Join(stageDir, "prompt.md"), []byte(prompt), 0o644)
 
 	// 3. Call LLM backend
 	var responseText string
+	var usage *pipeline.Usage
+	var reported *pipeline.Outcome
+	var confidence *float64
 	if h.Backend != nil {
-		result, err := h.Backend.Run(node, prompt, ctx)
+		expanded, err := h.Secrets.Expand(prompt)
 		if err != nil {
 			return &pipeline.Outcome{
 				Status:        pipeline.StatusFail,
 				FailureReason: err.Error(),
 			}, nil
 		}
+		result, err := h.Backend.Run(node, expanded, ctx)
+		// Transient provider errors go back to the engine, which retries
+		// them per the node's retry policy and the provider's rate limit
+		// reset.
+		var llmErr *llm.LLMError
+		if errors.As(err, &llmErr) && llmErr.IsRetryable() {
+			return nil, &redactedError{msg: h.Secrets.Redact(err.Error()), err: err}
+		}
+		if err != nil {
+			return &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: h.Secrets.Redact(err.Error()),
+			}, nil
+		}
 		// If result is an Outcome, return it directly.
 		if outcome, ok := result.(*pipeline.Outcome); ok {
-			writeStatus(stageDir, outcome)
+			writeStatus(stageDir, outcome, h.Scrubber)
 			return outcome, nil
 		}
-		responseText = fmt.Sprint(result)
+		if br, ok := result.(*BackendResult); ok {
+			responseText = br.Text
+			usage = &br.Usage
+			reported = br.Outcome
+			confidence = br.Confidence
+		} else {
+			responseText = fmt.Sprint(result)
+		}
+		responseText = h.Secrets.Redact(responseText)
 	} else {
 		responseText = "[Simulated] Response for stage: " + node.ID
 	}
@@ -167,11 +234,49 @@ func (h *CodergenHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, gr
 			"last_stage":    node.ID,
 			"last_response": truncate(responseText, 200),
 		},
+		Usage: usage,
+	}
+	if reported != nil {
+		outcome.Status = reported.Status
+		outcome.SuggestedNextIDs = reported.SuggestedNextIDs
+		outcome.FailureReason = h.Secrets.Redact(reported.FailureReason)
+		if reported.Notes != "" {
+			outcome.Notes = h.Secrets.Redact(reported.Notes)
+		}
+		for k, v := range reported.ContextUpdates {
+			if !reservedContextKey(k) {
+				outcome.ContextUpdates[k] = h.Secrets.RedactValue(v)
+			}
+		}
 	}
-	writeStatus(stageDir, outcome)
+	if h.Backend != nil {
+		setConfidence(outcome, node, confidence)
+	}
+	writeStatus(stageDir, outcome, h.Scrubber)
 	return outcome, nil
 }
 
+// setConfidence records the model's confidence in the stage's response as
+// context.confidence, for edge weights such as "context.confidence * 10".
+// With a confidence_threshold attribute it also sets context.confident to
+// "true" or "false", for edge conditions. A response without logprobs is
+// never confident, and the stage's notes carry a warning saying why.
+func setConfidence(outcome *pipeline.Outcome, node *pipeline.Node, confidence *float64) {
+	threshold, err := strconv.ParseFloat(node.Attrs["confidence_threshold"], 64)
+	hasThreshold := err == nil
+	if confidence == nil {
+		if hasThreshold {
+			outcome.ContextUpdates["confident"] = "false"
+			outcome.Notes += " (warning: the provider returned no logprobs, so confident is false)"
+		}
+		return
+	}
+	outcome.ContextUpdates["confidence"] = strconv.FormatFloat(*confidence, 'f', 3, 64)
+	if hasThreshold {
+		outcome.ContextUpdates["confident"] = strconv.FormatBool(*confidence >= threshold)
+	}
+}
+
 // --- Conditional Handler ---
 
 // ConditionalHandler is a pass-through; the engine evaluates edge conditions.
@@ -184,6 +289,83 @@ func (h *ConditionalHandler) Execute(node *pipeline.Node, _ *pipeline.Context, _
 	}, nil
 }
 
+// --- Assert Handler ---
+
+// AssertHandler checks the node's "expect" attribute, a condition in the
+// edge condition language, against the context and the previous stage's
+// outcome. A false expectation fails the stage, so an outcome=fail edge can
+// route to a fix loop; without one the pipeline fails. The failure reason
+// is the node's "message" attribute, if set, followed by each false clause
+// and the value it saw.
+type AssertHandler struct{}
+
+func (h *AssertHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, _ *pipeline.Graph, _ string) (*pipeline.Outcome, error) {
+	expect := strings.TrimSpace(node.Attrs["expect"])
+	if expect == "" {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: "assert node has no expect attribute",
+		}, nil
+	}
+	if err := condition.Validate(expect); err != nil {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: fmt.Sprintf("invalid expect: %v", err),
+		}, nil
+	}
+
+	previous := &pipeline.Outcome{
+		Status:         pipeline.StageStatus(ctx.GetString("outcome")),
+		PreferredLabel: ctx.GetString("preferred_label"),
+	}
+	var failed []string
+	for _, clause := range strings.Split(expect, "&&") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" || condition.Evaluate(clause, previous, ctx) {
+			continue
+		}
+		failed = append(failed, describeClause(clause, previous, ctx))
+	}
+	if len(failed) == 0 {
+		return &pipeline.Outcome{
+			Status: pipeline.StatusSuccess,
+			Notes:  "Assertion passed: " + expect,
+		}, nil
+	}
+
+	reason := "assertion failed: " + strings.Join(failed, "; ")
+	if message := node.Attrs["message"]; message != "" {
+		reason = message + ": " + strings.Join(failed, "; ")
+	}
+	return &pipeline.Outcome{
+		Status:        pipeline.StatusFail,
+		FailureReason: reason,
+	}, nil
+}
+
+// describeClause renders a false clause with the value its key resolved to,
+// e.g. `context.tests_passed=true (got "false")`.
+func describeClause(clause string, previous *pipeline.Outcome, ctx *pipeline.Context) string {
+	if _, ok := pipeline.ParseSetClause(clause); ok {
+		return clause
+	}
+	key := clause
+	if idx := strings.IndexAny(clause, "!="); idx >= 0 {
+		key = strings.TrimSpace(clause[:idx])
+	}
+	switch key {
+	case "outcome":
+		return fmt.Sprintf("%s (got %q)", clause, previous.Status)
+	case "preferred_label":
+		return fmt.Sprintf("%s (got %q)", clause, previous.PreferredLabel)
+	}
+	v, ok := ctx.Lookup(key)
+	if !ok {
+		return clause + " (not set)"
+	}
+	return fmt.Sprintf("%s (got %q)", clause, fmt.Sprint(v))
+}
+
 // --- Interviewer Interface ---
 
 // QuestionType identifies the type of human interaction question.
@@ -196,21 +378,54 @@ const (
 	QuestionConfirmation
 )
 
+// questionTypeNames are the names of QuestionTypes in JSON, the same as
+// the question types of the pipeline server.
+var questionTypeNames = map[QuestionType]string{
+	QuestionYesNo:          pipeline.QuestionTypeYesNo,
+	QuestionMultipleChoice: pipeline.QuestionTypeMultipleChoice,
+	QuestionFreeform:       pipeline.QuestionTypeFreeform,
+	QuestionConfirmation:   pipeline.QuestionTypeConfirmation,
+}
+
+func (t QuestionType) String() string {
+	if name, ok := questionTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("QuestionType(%d)", int(t))
+}
+
+func (t QuestionType) MarshalText() ([]byte, error) {
+	if _, ok := questionTypeNames[t]; !ok {
+		return nil, fmt.Errorf("unknown question type %d", int(t))
+	}
+	return []byte(t.String()), nil
+}
+
+func (t *QuestionType) UnmarshalText(text []byte) error {
+	for qt, name := range questionTypeNames {
+		if name == string(text) {
+			*t = qt
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown question type %q", text)
+}
+
 // Question is a question to present to a human.
 type Question struct {
-	Text           string
-	Type           QuestionType
-	Options        []QuestionOption
-	Default        *Answer
-	TimeoutSeconds float64
-	Stage          string
-	Metadata       map[string]interface{}
+	Text           string                 `json:"text"`
+	Type           QuestionType           `json:"type"`
+	Options        []QuestionOption       `json:"options,omitempty"`
+	Default        *Answer                `json:"default,omitempty"`
+	TimeoutSeconds float64                `json:"timeout_seconds,omitempty"`
+	Stage          string                 `json:"stage,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // QuestionOption is a choice in a multiple-choice question.
 type QuestionOption struct {
-	Key   string
-	Label string
+	Key   string `json:"key"`
+	Label string `json:"label"`
 }
 
 // AnswerValue represents special answer types.
@@ -223,6 +438,20 @@ const (
 	AnswerTimeout
 )
 
+var answerValueNames = map[AnswerValue]string{
+	AnswerYes:     "yes",
+	AnswerNo:      "no",
+	AnswerSkipped: "skipped",
+	AnswerTimeout: "timeout",
+}
+
+func (v AnswerValue) String() string {
+	if name, ok := answerValueNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("AnswerValue(%d)", int(v))
+}
+
 // Answer is a human's response to a question.
 type Answer struct {
 	Value          interface{}
@@ -230,6 +459,45 @@ type Answer struct {
 	Text           string
 }
 
+// answerJSON is the JSON form of an Answer. An AnswerValue is written as
+// Special, since a plain value could not be told apart from a string or
+// number answer when read back.
+type answerJSON struct {
+	Value          interface{}     `json:"value,omitempty"`
+	Special        string          `json:"special,omitempty"`
+	SelectedOption *QuestionOption `json:"selected_option,omitempty"`
+	Text           string          `json:"text,omitempty"`
+}
+
+func (a Answer) MarshalJSON() ([]byte, error) {
+	aj := answerJSON{Value: a.Value, SelectedOption: a.SelectedOption, Text: a.Text}
+	if v, ok := a.Value.(AnswerValue); ok {
+		if _, known := answerValueNames[v]; !known {
+			return nil, fmt.Errorf("unknown answer value %d", int(v))
+		}
+		aj.Value, aj.Special = nil, v.String()
+	}
+	return json.Marshal(aj)
+}
+
+func (a *Answer) UnmarshalJSON(data []byte) error {
+	var aj answerJSON
+	if err := json.Unmarshal(data, &aj); err != nil {
+		return err
+	}
+	*a = Answer{Value: aj.Value, SelectedOption: aj.SelectedOption, Text: aj.Text}
+	if aj.Special == "" {
+		return nil
+	}
+	for v, name := range answerValueNames {
+		if name == aj.Special {
+			a.Value = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown special answer %q", aj.Special)
+}
+
 // Interviewer is the interface for human interaction.
 type Interviewer interface {
 	Ask(question *Question) *Answer
@@ -238,12 +506,17 @@ type Interviewer interface {
 
 // --- Wait For Human Handler ---
 
-// WaitForHumanHandler blocks until a human selects an option.
+// WaitForHumanHandler blocks until a human selects an option, or for
+// freeform, confirm, and form gates (see pipeline.Node.HumanFields), until
+// the gate's questions are answered.
 type WaitForHumanHandler struct {
 	Interviewer Interviewer
 }
 
 func (h *WaitForHumanHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
+	if node.HumanType() != pipeline.HumanChoice {
+		return h.askFields(node, ctx, graph)
+	}
 	edges := graph.OutgoingEdges(node.ID)
 	if len(edges) == 0 {
 		return &pipeline.Outcome{
@@ -340,9 +613,132 @@ func (h *WaitForHumanHandler) Execute(node *pipeline.Node, ctx *pipeline.Context
 	}, nil
 }
 
+// askFields asks the questions of a freeform, confirm, or form gate and
+// stores the answers in the context. A declined confirm gate fails, so an
+// outcome=fail edge can route around the confirmed path.
+func (h *WaitForHumanHandler) askFields(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph) (*pipeline.Outcome, error) {
+	fields, err := node.HumanFields()
+	if err != nil {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: err.Error(),
+		}, nil
+	}
+
+	updates := make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		question := &Question{
+			Text:  expandVariables(f.Text, node, graph, ctx),
+			Type:  fieldQuestionType(f.Type),
+			Stage: node.ID,
+			Metadata: map[string]interface{}{
+				"key":   f.Key,
+				"index": i,
+				"count": len(fields),
+			},
+		}
+		for _, opt := range f.Options {
+			question.Options = append(question.Options, QuestionOption{Key: opt, Label: opt})
+		}
+		if f.Default != "" {
+			question.Default = &Answer{Value: f.Default, Text: f.Default}
+		}
+
+		answer := h.Interviewer.Ask(question)
+		if answer == nil || answer.Value == AnswerTimeout {
+			if f.Default == "" {
+				return &pipeline.Outcome{
+					Status:        pipeline.StatusRetry,
+					FailureReason: fmt.Sprintf("human gate timeout, no default for %q", f.Key),
+				}, nil
+			}
+			answer = &Answer{Value: f.Default, Text: f.Default}
+		}
+		if answer.Value == AnswerSkipped {
+			return &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: "human skipped interaction",
+			}, nil
+		}
+		value, ok := fieldAnswer(f, answer)
+		if !ok {
+			return &pipeline.Outcome{
+				Status:        pipeline.StatusFail,
+				FailureReason: fmt.Sprintf("answer %q to %q is not one of %s", answerText(answer), f.Key, strings.Join(f.Options, ", ")),
+			}, nil
+		}
+		updates[f.Key] = value
+	}
+
+	outcome := &pipeline.Outcome{
+		Status:         pipeline.StatusSuccess,
+		ContextUpdates: updates,
+	}
+	if node.HumanType() == pipeline.HumanConfirm && updates[fields[0].Key] != true {
+		outcome.Status = pipeline.StatusFail
+		outcome.FailureReason = "human declined"
+	}
+	return outcome, nil
+}
+
+func fieldQuestionType(fieldType string) QuestionType {
+	switch fieldType {
+	case pipeline.HumanConfirm:
+		return QuestionConfirmation
+	case pipeline.HumanChoice:
+		return QuestionMultipleChoice
+	}
+	return QuestionFreeform
+}
+
+// fieldAnswer converts an answer to the value stored for f: a bool for a
+// confirm field, the matching option for a choice field, and the text
+// otherwise. It reports false if a choice answer matches no option.
+func fieldAnswer(f pipeline.HumanField, answer *Answer) (interface{}, bool) {
+	switch f.Type {
+	case pipeline.HumanConfirm:
+		if answer.Value == AnswerYes {
+			return true, true
+		}
+		if answer.Value == AnswerNo {
+			return false, true
+		}
+		yes, _ := strconv.ParseBool(answerText(answer))
+		return yes || strings.EqualFold(answerText(answer), "y") || strings.EqualFold(answerText(answer), "yes"), true
+	case pipeline.HumanChoice:
+		if answer.SelectedOption != nil {
+			return answer.SelectedOption.Key, true
+		}
+		for _, opt := range f.Options {
+			if strings.EqualFold(opt, answerText(answer)) {
+				return opt, true
+			}
+		}
+		return nil, false
+	}
+	return answerText(answer), true
+}
+
+// answerText returns the answer's text, or its value when it has none.
+func answerText(answer *Answer) string {
+	if answer.Text != "" {
+		return answer.Text
+	}
+	if s, ok := answer.Value.(string); ok {
+		return s
+	}
+	return ""
+}
+
 // --- Parallel Handler ---
 
-// ParallelHandler fans out execution to multiple branches.
+// ParallelHandler fans out execution to multiple branches, running each
+// branch's first stage with its own context and logs directory (see
+// pipeline.BranchLogsRoot).
+//
+// Deprecated: the engine fans parallel nodes out itself, running each branch
+// to its fan-in, and never calls this handler. It remains registered for
+// callers that resolve parallel nodes directly.
 type ParallelHandler struct {
 	Registry *Registry // set by engine after creation
 }
@@ -364,12 +760,7 @@ func (h *ParallelHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, gr
 		}
 	}
 
-	type branchResult struct {
-		nodeID  string
-		outcome *pipeline.Outcome
-	}
-
-	results := make([]branchResult, len(edges))
+	results := make([]*pipeline.BranchResult, len(edges))
 	sem := make(chan struct{}, maxParallel)
 	var wg sync.WaitGroup
 
@@ -383,28 +774,28 @@ func (h *ParallelHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, gr
 			branchCtx := ctx.Clone()
 			targetNode := graph.Nodes[e.To]
 			if targetNode == nil {
-				results[idx] = branchResult{
-					nodeID:  e.To,
-					outcome: &pipeline.Outcome{Status: pipeline.StatusFail, FailureReason: "node not found"},
+				results[idx] = &pipeline.BranchResult{
+					Branch:  e.To,
+					Outcome: &pipeline.Outcome{Status: pipeline.StatusFail, FailureReason: "node not found"},
 				}
 				return
 			}
 
 			if h.Registry != nil {
 				handler := h.Registry.Resolve(targetNode)
-				outcome, err := handler.Execute(targetNode, branchCtx, graph, logsRoot)
+				outcome, err := handler.Execute(targetNode, branchCtx, graph, pipeline.BranchLogsRoot(logsRoot, node.ID, e.To))
 				if err != nil {
-					results[idx] = branchResult{
-						nodeID:  e.To,
-						outcome: &pipeline.Outcome{Status: pipeline.StatusFail, FailureReason: err.Error()},
+					results[idx] = &pipeline.BranchResult{
+						Branch:  e.To,
+						Outcome: &pipeline.Outcome{Status: pipeline.StatusFail, FailureReason: err.Error()},
 					}
 					return
 				}
-				results[idx] = branchResult{nodeID: e.To, outcome: outcome}
+				results[idx] = &pipeline.BranchResult{Branch: e.To, CompletedNodes: []string{e.To}, Outcome: outcome}
 			} else {
-				results[idx] = branchResult{
-					nodeID:  e.To,
-					outcome: &pipeline.Outcome{Status: pipeline.StatusSuccess, Notes: "Branch: " + e.To},
+				results[idx] = &pipeline.BranchResult{
+					Branch:  e.To,
+					Outcome: &pipeline.Outcome{Status: pipeline.StatusSuccess, Notes: "Branch: " + e.To},
 				}
 			}
 		}(i, edge)
@@ -416,16 +807,23 @@ func (h *ParallelHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, gr
 	successCount := 0
 	failCount := 0
 	for _, r := range results {
-		if r.outcome.Status == pipeline.StatusSuccess || r.outcome.Status == pipeline.StatusPartialSuccess {
+		if r.Outcome.Status == pipeline.StatusSuccess || r.Outcome.Status == pipeline.StatusPartialSuccess {
 			successCount++
-		} else if r.outcome.Status == pipeline.StatusFail {
+		} else if r.Outcome.Status == pipeline.StatusFail {
 			failCount++
 		}
 	}
 
-	// Serialize results for fan-in
+	// Serialize results for fan-in, and keep each branch's writes under
+	// parallel.<branch>.<key> as the engine does.
 	serialized, _ := json.Marshal(results)
 	ctx.Set("parallel.results", string(serialized))
+	for _, r := range results {
+		for k, v := range r.Outcome.ContextUpdates {
+			ctx.Set("parallel."+r.Branch+"."+k, v)
+		}
+		ctx.Set("parallel."+r.Branch+".outcome", string(r.Outcome.Status))
+	}
 
 	joinPolicy := node.Attrs["join_policy"]
 	if joinPolicy == "" {
@@ -451,65 +849,258 @@ func (h *ParallelHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, gr
 	}
 }
 
-// --- Fan-In Handler ---
+// --- Tool Handler ---
 
-// FanInHandler consolidates parallel results.
-type FanInHandler struct{}
+// ToolHandler runs a node's tool_command with its Shell after expanding its
+// {context.KEY} and {artifact.PATH} placeholders (see
+// pipeline.ExpandCommand). The command runs in the node's WorkDir, with the
+// process environment plus the node's env.* attributes and the
+// comma-separated KEY=VALUE pairs in tool_env, and is killed after the
+// node's timeout (default 30s). Placeholder values are quoted for the shell
+// (see env.Shell.Quote). Stdout and stderr are captured separately,
+// streamed to stdout.log and stderr.log in the stage directory, and
+// reported in the context as tool.output, tool.stderr, and tool.exit_code.
+// A non-zero exit fails the stage.
+type ToolHandler struct {
+	Secrets *secrets.Store
+	// Shell runs the command; the zero value uses env.SystemShell, which is
+	// sh -c, or pwsh or cmd on Windows.
+	Shell env.Shell
+}
 
-func (h *FanInHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
-	resultsJSON := ctx.GetString("parallel.results")
-	if resultsJSON == "" {
+func (h *ToolHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
+	command := node.Attrs["tool_command"]
+	if command == "" {
 		return &pipeline.Outcome{
 			Status:        pipeline.StatusFail,
-			FailureReason: "No parallel results to evaluate",
+			FailureReason: "No tool_command specified",
 		}, nil
 	}
 
-	return &pipeline.Outcome{
+	shell := h.Shell
+	if shell.Name == "" {
+		shell = env.SystemShell()
+	}
+	// Placeholders are quoted for the shell that runs the command, since
+	// context values may come from a model.
+	expanded, err := h.Secrets.Expand(command)
+	if err == nil {
+		expanded, err = pipeline.ExpandCommandQuoted(expanded, ctx, logsRoot, shell.Quote)
+	}
+	if err != nil {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: err.Error(),
+		}, nil
+	}
+	dir, vars, err := stageEnvironment(h.Secrets, node, "")
+	if err == nil {
+		vars, err = h.toolEnv(node, vars)
+	}
+	if err != nil {
+		return &pipeline.Outcome{
+			Status:        pipeline.StatusFail,
+			FailureReason: err.Error(),
+		}, nil
+	}
+
+	timeout := node.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	runCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	var stdoutLog, stderrLog io.Writer = io.Discard, io.Discard
+	if logsRoot != "" {
+		stageDir := filepath.Join(logsRoot, node.ID)
+		os.MkdirAll(stageDir, 0o755)
+		if f, err := os.Create(filepath.Join(stageDir, "stdout.log")); err == nil {
+			w := &redactingWriter{w: f, secrets: h.Secrets}
+			defer f.Close()
+			defer w.Flush()
+			stdoutLog = w
+		}
+		if f, err := os.Create(filepath.Join(stageDir, "stderr.log")); err == nil {
+			w := &redactingWriter{w: f, secrets: h.Secrets}
+			defer f.Close()
+			defer w.Flush()
+			stderrLog = w
+		}
+	}
+
+	cmd := shell.Command(runCtx, expanded)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), vars...)
+	cmd.Stdout = io.MultiWriter(&stdout, stdoutLog)
+	cmd.Stderr = io.MultiWriter(&stderr, stderrLog)
+	// Don't wait on pipes held open by background children after a kill.
+	cmd.WaitDelay = time.Second
+
+	runErr := cmd.Run()
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	outcome := &pipeline.Outcome{
 		Status: pipeline.StatusSuccess,
-		Notes:  "Fan-in completed",
 		ContextUpdates: map[string]interface{}{
-			"parallel.fan_in.complete": "true",
+			"tool.output":    h.Secrets.Redact(stdout.String()),
+			"tool.stderr":    h.Secrets.Redact(stderr.String()),
+			"tool.exit_code": exitCode,
 		},
-	}, nil
+		Notes: "Tool completed: " + command,
+	}
+	if runErr != nil {
+		outcome.Status = pipeline.StatusFail
+		outcome.Notes = "Tool failed: " + command
+		switch {
+		case runCtx.Err() == context.DeadlineExceeded:
+			outcome.FailureReason = fmt.Sprintf("tool timed out after %s", timeout)
+		case strings.TrimSpace(stderr.String()) != "":
+			outcome.FailureReason = fmt.Sprintf("tool exited with status %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+		default:
+			outcome.FailureReason = fmt.Sprintf("tool execution failed: %v", runErr)
+		}
+		outcome.FailureReason = h.Secrets.Redact(outcome.FailureReason)
+	}
+	return outcome, nil
 }
 
-// --- Tool Handler ---
+// toolEnv appends the variables in the node's tool_env attribute of
+// comma-separated KEY=VALUE pairs to env, expanding secret references in the
+// values.
+func (h *ToolHandler) toolEnv(node *pipeline.Node, env []string) ([]string, error) {
+	for _, pair := range strings.Split(node.Attrs["tool_env"], ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid tool_env entry %q: expected KEY=VALUE", pair)
+		}
+		value, err := h.Secrets.Expand(value)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, strings.TrimSpace(key)+"="+value)
+	}
+	return env, nil
+}
 
-// ToolHandler executes external commands.
-type ToolHandler struct{}
+// stageEnvironment returns the directory a node's processes run in and the
+// variables added to their environment, for tool commands and agent
+// sessions alike: the node's WorkDir, joined to base if relative and made
+// absolute, and its env.* attributes with secret references expanded. An
+// empty directory means the current one.
+func stageEnvironment(store *secrets.Store, node *pipeline.Node, base string) (string, []string, error) {
+	dir := base
+	if nodeDir := node.WorkDir(); filepath.IsAbs(nodeDir) {
+		dir = nodeDir
+	} else if nodeDir != "" {
+		dir = filepath.Join(dir, nodeDir)
+	}
+	if dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	vars := make([]string, 0, len(node.Env()))
+	for _, pair := range node.Env() {
+		key, value, _ := strings.Cut(pair, "=")
+		value, err := store.Expand(value)
+		if err != nil {
+			return "", nil, err
+		}
+		vars = append(vars, key+"="+value)
+	}
+	return dir, vars, nil
+}
 
-func (h *ToolHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
-	command := node.Attrs["tool_command"]
-	if command == "" {
+// redactingWriter writes complete lines to w with secret values masked, so a
+// secret is never split across two writes. Flush writes any partial last
+// line.
+type redactingWriter struct {
+	w       io.Writer
+	secrets *secrets.Store
+	buf     []byte
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	if i := bytes.LastIndexByte(r.buf, '\n'); i >= 0 {
+		if _, err := io.WriteString(r.w, r.secrets.Redact(string(r.buf[:i+1]))); err != nil {
+			return 0, err
+		}
+		r.buf = append(r.buf[:0], r.buf[i+1:]...)
+	}
+	return len(p), nil
+}
+
+func (r *redactingWriter) Flush() {
+	if len(r.buf) > 0 {
+		io.WriteString(r.w, r.secrets.Redact(string(r.buf)))
+		r.buf = nil
+	}
+}
+
+// --- Notify Handler ---
+
+// NotifyHandler sends the node's message to Slack and/or email. Channels come
+// from Notifiers when set; otherwise from the environment, overridden by graph
+// and then node notify_* attributes (see notify.Config.WithAttrs). Secrets are
+// redacted from the subject and message, and delivery gives up after the
+// node's timeout (default 30s).
+type NotifyHandler struct {
+	Notifiers []notify.Notifier
+	Secrets   *secrets.Store
+}
+
+func (h *NotifyHandler) Execute(node *pipeline.Node, ctx *pipeline.Context, graph *pipeline.Graph, logsRoot string) (*pipeline.Outcome, error) {
+	notifiers := h.Notifiers
+	if notifiers == nil {
+		notifiers = notify.ConfigFromEnv().WithAttrs(graph.Attrs).WithAttrs(node.Attrs).Notifiers()
+	}
+	if len(notifiers) == 0 {
 		return &pipeline.Outcome{
 			Status:        pipeline.StatusFail,
-			FailureReason: "No tool_command specified",
+			FailureReason: "No notification channel configured",
 		}, nil
 	}
 
+	message := node.Attrs["message"]
+	if message == "" {
+		message = node.Prompt
+	}
+	if message == "" {
+		message = node.Label
+	}
+	message = expandVariables(message, node, graph, ctx)
+
+	subject := node.Attrs["subject"]
+	if subject == "" {
+		subject = "[attractor] " + graph.Name + ": " + node.Label
+	}
+
+	subject = h.Secrets.Redact(subject)
+	message = h.Secrets.Redact(message)
+
 	timeout := node.Timeout
 	if timeout == 0 {
 		timeout = 30 * time.Second
 	}
-
-	cmd := exec.Command("sh", "-c", command)
-	cmd.Env = os.Environ()
-
-	output, err := cmd.Output()
-	if err != nil {
+	if err := notify.SendTimeout(notifiers, subject, message, timeout); err != nil {
 		return &pipeline.Outcome{
 			Status:        pipeline.StatusFail,
-			FailureReason: fmt.Sprintf("tool execution failed: %v", err),
+			FailureReason: h.Secrets.Redact(err.Error()),
 		}, nil
 	}
 
 	return &pipeline.Outcome{
 		Status: pipeline.StatusSuccess,
-		ContextUpdates: map[string]interface{}{
-			"tool.output": string(output),
-		},
-		Notes: "Tool completed: " + command,
+		Notes:  fmt.Sprintf("Notification sent to %d channel(s)", len(notifiers)),
 	}, nil
 }
 
@@ -557,7 +1148,8 @@ func (h *ManagerLoopHandler) Execute(node *pipeline.Node, ctx *pipeline.Context,
 
 // --- Built-in Interviewer Implementations ---
 
-// AutoApproveInterviewer always selects YES or the first option.
+// AutoApproveInterviewer always selects YES or the first option. Freeform
+// questions get their default, or an empty answer.
 type AutoApproveInterviewer struct{}
 
 func (a *AutoApproveInterviewer) Ask(question *Question) *Answer {
@@ -571,6 +1163,12 @@ func (a *AutoApproveInterviewer) Ask(question *Question) *Answer {
 				SelectedOption: &question.Options[0],
 			}
 		}
+	case QuestionFreeform:
+		if question.Default != nil {
+			answer := *question.Default
+			return &answer
+		}
+		return &Answer{}
 	}
 	return &Answer{Value: "auto-approved", Text: "auto-approved"}
 }
@@ -580,6 +1178,15 @@ func (a *AutoApproveInterviewer) Inform(message, stage string) {}
 // ConsoleInterviewer reads from standard input.
 type ConsoleInterviewer struct{}
 
+// stdin buffers standard input for ConsoleInterviewer, which reads it a line
+// at a time.
+var stdin = bufio.NewReader(os.Stdin)
+
+func readLine() string {
+	line, _ := stdin.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
 func (c *ConsoleInterviewer) Ask(question *Question) *Answer {
 	fmt.Printf("[?] %s\n", question.Text)
 	switch question.Type {
@@ -588,9 +1195,7 @@ func (c *ConsoleInterviewer) Ask(question *Question) *Answer {
 			fmt.Printf("  [%s] %s\n", opt.Key, opt.Label)
 		}
 		fmt.Print("Select: ")
-		var input string
-		fmt.Scanln(&input)
-		input = strings.TrimSpace(input)
+		input := readLine()
 		for _, opt := range question.Options {
 			if strings.EqualFold(opt.Key, input) {
 				return &Answer{Value: opt.Key, SelectedOption: &opt}
@@ -599,19 +1204,15 @@ func (c *ConsoleInterviewer) Ask(question *Question) *Answer {
 		if len(question.Options) > 0 {
 			return &Answer{Value: question.Options[0].Key, SelectedOption: &question.Options[0]}
 		}
-	case QuestionYesNo:
+	case QuestionYesNo, QuestionConfirmation:
 		fmt.Print("[Y/N]: ")
-		var input string
-		fmt.Scanln(&input)
-		if strings.EqualFold(strings.TrimSpace(input), "y") {
+		if input := readLine(); strings.EqualFold(input, "y") || strings.EqualFold(input, "yes") {
 			return &Answer{Value: AnswerYes}
 		}
 		return &Answer{Value: AnswerNo}
 	case QuestionFreeform:
 		fmt.Print("> ")
-		var input string
-		fmt.Scanln(&input)
-		return &Answer{Text: strings.TrimSpace(input)}
+		return &Answer{Text: readLine()}
 	}
 	return &Answer{Value: AnswerSkipped}
 }
@@ -651,23 +1252,22 @@ func (q *QueueInterviewer) Ask(question *Question) *Answer {
 func (q *QueueInterviewer) Inform(message, stage string) {}
 
 // RecordingInterviewer wraps another interviewer and records interactions.
+// Save writes them out for a ReplayInterviewer.
 type RecordingInterviewer struct {
 	Inner      Interviewer
 	mu         sync.Mutex
-	Recordings []struct {
-		Question *Question
-		Answer   *Answer
-	}
+	Recordings []Interaction
 }
 
 func (r *RecordingInterviewer) Ask(question *Question) *Answer {
 	answer := r.Inner.Ask(question)
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.Recordings = append(r.Recordings, struct {
-		Question *Question
-		Answer   *Answer
-	}{question, answer})
+	r.Recordings = append(r.Recordings, Interaction{
+		Hash:     QuestionHash(question),
+		Question: question,
+		Answer:   answer,
+	})
 	return answer
 }
 
@@ -675,8 +1275,68 @@ func (r *RecordingInterviewer) Inform(message, stage string) {
 	r.Inner.Inform(message, stage)
 }
 
+// WebInterviewer asks its questions on a pipeline server's question queue,
+// where they are answered over HTTP or in the server's web UI; see
+// pipeline.WithRunResolver. A question left unanswered past its
+// TimeoutSeconds times out, and one pending when the run is cancelled is
+// skipped.
+type WebInterviewer struct {
+	Questions *pipeline.QuestionQueue
+}
+
+func (w *WebInterviewer) Ask(question *Question) *Answer {
+	q := pipeline.HumanQuestion{
+		Stage: question.Stage,
+		Text:  question.Text,
+		Type:  question.Type.String(),
+	}
+	for _, opt := range question.Options {
+		q.Options = append(q.Options, pipeline.HumanOption{Key: opt.Key, Label: opt.Label})
+	}
+	if question.Default != nil {
+		q.Default = answerText(question.Default)
+	}
+	timeout := time.Duration(question.TimeoutSeconds * float64(time.Second))
+	answer, err := w.Questions.Ask(q, timeout)
+	if errors.Is(err, pipeline.ErrQuestionTimeout) {
+		return &Answer{Value: AnswerTimeout}
+	}
+	if err != nil {
+		return &Answer{Value: AnswerSkipped}
+	}
+
+	// The queue has checked the answer and normalized it to "yes", "no",
+	// or an option key.
+	switch question.Type {
+	case QuestionYesNo, QuestionConfirmation:
+		if answer.Value == "yes" {
+			return &Answer{Value: AnswerYes}
+		}
+		return &Answer{Value: AnswerNo}
+	case QuestionMultipleChoice:
+		for _, opt := range question.Options {
+			if opt.Key == answer.Value {
+				return &Answer{Value: opt.Key, SelectedOption: &opt}
+			}
+		}
+	}
+	return &Answer{Value: answer.Value, Text: answer.Value}
+}
+
+func (w *WebInterviewer) Inform(message, stage string) {}
+
 // --- Helpers ---
 
+// redactedError replaces an error's message with a redacted one while
+// keeping the original error reachable through errors.As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
 var acceleratorPattern = regexp.MustCompile(`^\[([A-Za-z])\]\s|^([A-Za-z])\)\s|^([A-Za-z])\s-\s`)
 
 func parseAcceleratorKey(label string) string {
@@ -694,12 +1354,25 @@ func parseAcceleratorKey(label string) string {
 	return ""
 }
 
-func expandVariables(prompt string, graph *pipeline.Graph, ctx *pipeline.Context) string {
+// expandVariables replaces $goal in prompt and, if node sets
+// expand_context=true, its {context.KEY} placeholders. Context values can
+// come from model output, so they only reach prompts that ask for them.
+func expandVariables(prompt string, node *pipeline.Node, graph *pipeline.Graph, ctx *pipeline.Context) string {
 	prompt = strings.ReplaceAll(prompt, "$goal", graph.Goal)
-	return prompt
+	if node.Attrs["expand_context"] != "true" {
+		return prompt
+	}
+	return pipeline.ExpandPrompt(prompt, ctx)
 }
 
-func writeStatus(stageDir string, outcome *pipeline.Outcome) {
+// writeStatus writes outcome to status.json, with context updates whose keys
+// match scrubber redacted.
+func writeStatus(stageDir string, outcome *pipeline.Outcome, scrubber *secrets.Scrubber) {
+	if scrubber != nil {
+		scrubbed := *outcome
+		scrubbed.ContextUpdates = scrubber.ScrubMap(outcome.ContextUpdates)
+		outcome = &scrubbed
+	}
 	data, _ := json.MarshalIndent(outcome, "", "  ")
 	os.WriteFile(filepath.Join(stageDir, "status.json"), data, 0o644)
 }
@@ -1,9 +1,17 @@
 package handler
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ashka-vakil/attractor/pkg/llm"
 	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/notify"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 func TestStartHandler(t *testing.T) {
@@ -133,6 +141,17 @@ func TestWaitForHumanHandlerNoEdges(t *testing.T) {
 	}
 }
 
+func TestAutoApproveInterviewerFreeform(t *testing.T) {
+	a := &AutoApproveInterviewer{}
+	if got := a.Ask(&Question{Type: QuestionFreeform, Text: "Notes?"}); answerText(got) != "" {
+		t.Errorf("expected an empty answer, got %+v", got)
+	}
+	def := &Answer{Value: "none", Text: "none"}
+	if got := a.Ask(&Question{Type: QuestionFreeform, Text: "Notes?", Default: def}); answerText(got) != "none" {
+		t.Errorf("expected the default answer, got %+v", got)
+	}
+}
+
 func TestWaitForHumanHandlerAutoApprove(t *testing.T) {
 	h := &WaitForHumanHandler{Interviewer: &AutoApproveInterviewer{}}
 	node := &pipeline.Node{ID: "gate", Label: "Review Changes", Attrs: map[string]string{}}
@@ -164,6 +183,75 @@ func TestWaitForHumanHandlerAutoApprove(t *testing.T) {
 	}
 }
 
+func TestWaitForHumanHandlerFreeformAndConfirm(t *testing.T) {
+	ctx := pipeline.NewContext()
+	ctx.Set("plan", "add a cache")
+	var asked *Question
+	h := &WaitForHumanHandler{Interviewer: &CallbackInterviewer{Callback: func(q *Question) *Answer {
+		asked = q
+		return &Answer{Text: "keep it small"}
+	}}}
+	node := &pipeline.Node{ID: "notes", Prompt: "Notes on {context.plan}?", Attrs: map[string]string{
+		"human.type":     "freeform",
+		"human.store":    "review_notes",
+		"expand_context": "true",
+	}}
+	outcome, err := h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if asked.Type != QuestionFreeform || asked.Text != "Notes on add a cache?" {
+		t.Errorf("unexpected question %+v", asked)
+	}
+	delete(node.Attrs, "expand_context")
+	h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if asked.Text != "Notes on {context.plan}?" {
+		t.Errorf("expected placeholders kept without expand_context, got %q", asked.Text)
+	}
+	if outcome.Status != pipeline.StatusSuccess || outcome.ContextUpdates["review_notes"] != "keep it small" {
+		t.Errorf("expected the answer under review_notes, got %+v", outcome)
+	}
+
+	h.Interviewer = &QueueInterviewer{Answers: []*Answer{{Value: AnswerNo}}}
+	node = &pipeline.Node{ID: "ship", Label: "Ship it?", Attrs: map[string]string{"human.type": "confirm"}}
+	outcome, _ = h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if outcome.Status != pipeline.StatusFail || outcome.ContextUpdates["human.gate.confirmed"] != false {
+		t.Errorf("expected a declined confirmation to fail, got %+v", outcome)
+	}
+}
+
+func TestWaitForHumanHandlerForm(t *testing.T) {
+	h := &WaitForHumanHandler{Interviewer: &QueueInterviewer{Answers: []*Answer{
+		{Text: "flaky network test"},
+		{Value: "HIGH"},
+		{Value: AnswerTimeout},
+	}}}
+	node := &pipeline.Node{ID: "triage", Attrs: map[string]string{
+		"human.type":                   "form",
+		"human.fields":                 "summary, risk, approved",
+		"human.field.summary":          "Summarize the failure",
+		"human.field.risk.type":        "choice",
+		"human.field.risk.options":     "low,high",
+		"human.field.approved.type":    "confirm",
+		"human.field.approved.default": "yes",
+	}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"summary": "flaky network test", "risk": "high", "approved": true}
+	for key, value := range want {
+		if outcome.ContextUpdates[key] != value {
+			t.Errorf("expected %s=%v, got %v", key, value, outcome.ContextUpdates[key])
+		}
+	}
+
+	h.Interviewer = &QueueInterviewer{Answers: []*Answer{{Text: "x"}, {Value: "medium"}}}
+	if outcome, _ := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, ""); outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected an answer outside the options to fail, got %s", outcome.Status)
+	}
+}
+
 func TestQueueInterviewer(t *testing.T) {
 	q := &QueueInterviewer{
 		Answers: []*Answer{
@@ -201,6 +289,54 @@ func TestRecordingInterviewer(t *testing.T) {
 	}
 }
 
+func TestWebInterviewer(t *testing.T) {
+	questions := pipeline.NewQuestionQueue()
+	h := &WaitForHumanHandler{Interviewer: &WebInterviewer{Questions: questions}}
+	node := &pipeline.Node{ID: "triage", Attrs: map[string]string{
+		"human.type":                "form",
+		"human.fields":              "summary, risk, approved",
+		"human.field.risk.type":     "choice",
+		"human.field.risk.options":  "low,high",
+		"human.field.approved.type": "confirm",
+	}}
+
+	// Answer each question as it is asked, as the web UI would.
+	answers := map[string]string{"summary": "flaky test", "risk": "HIGH", "approved": "y"}
+	updates, stop := questions.Subscribe()
+	defer stop()
+	go func() {
+		for e := range updates {
+			if e.Type != "asked" {
+				continue
+			}
+			for key, value := range answers {
+				if strings.HasPrefix(e.Question.Text, key) {
+					questions.Answer(e.Question.ID, pipeline.HumanAnswer{Value: value})
+				}
+			}
+		}
+	}()
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"summary": "flaky test", "risk": "high", "approved": true}
+	for key, value := range want {
+		if outcome.ContextUpdates[key] != value {
+			t.Errorf("expected %s=%v, got %v", key, value, outcome.ContextUpdates[key])
+		}
+	}
+
+	timedOut := (&WebInterviewer{Questions: pipeline.NewQuestionQueue()}).Ask(&Question{Text: "Wait?", TimeoutSeconds: 0.01})
+	if timedOut.Value != AnswerTimeout {
+		t.Errorf("expected TIMEOUT, got %v", timedOut.Value)
+	}
+	questions.Close()
+	if skipped := h.Interviewer.Ask(&Question{Text: "Again?"}); skipped.Value != AnswerSkipped {
+		t.Errorf("expected a closed queue to skip, got %v", skipped.Value)
+	}
+}
+
 func TestCallbackInterviewer(t *testing.T) {
 	called := false
 	cb := &CallbackInterviewer{
@@ -320,3 +456,354 @@ func TestAcceleratorKeyParsing(t *testing.T) {
 		}
 	}
 }
+
+// recordingNotifier captures notifications in memory.
+type recordingNotifier struct {
+	subjects []string
+	messages []string
+}
+
+func (n *recordingNotifier) Notify(subject, message string) error {
+	n.subjects = append(n.subjects, subject)
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func TestNotifyHandler(t *testing.T) {
+	rec := &recordingNotifier{}
+	h := &NotifyHandler{Notifiers: []notify.Notifier{rec}}
+	node := &pipeline.Node{
+		ID:    "alert",
+		Label: "Alert",
+		Type:  "notify",
+		Attrs: map[string]string{"message": "Review needed for $goal"},
+	}
+	graph := &pipeline.Graph{Name: "release", Goal: "v2"}
+
+	outcome, err := h.Execute(node, pipeline.NewContext(), graph, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusSuccess {
+		t.Fatalf("expected SUCCESS, got %s", outcome.Status)
+	}
+	if len(rec.messages) != 1 || rec.messages[0] != "Review needed for v2" {
+		t.Errorf("expected expanded message, got %v", rec.messages)
+	}
+}
+
+func TestNotifyHandlerRedactsAndTimesOut(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_HOOK_TOKEN", "hook_abc123")
+	store := secrets.FromEnv()
+	// Resolve the secret, as a stage using it would.
+	if _, err := store.Expand("${secret:HOOK_TOKEN}"); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingNotifier{}
+	h := &NotifyHandler{Notifiers: []notify.Notifier{rec}, Secrets: store}
+	node := &pipeline.Node{
+		ID:    "alert",
+		Type:  "notify",
+		Attrs: map[string]string{"message": "token is hook_abc123", "subject": "hook_abc123"},
+	}
+	ctx := pipeline.NewContext()
+	if _, err := h.Execute(node, ctx, &pipeline.Graph{}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, sent := range append(rec.subjects, rec.messages...) {
+		if strings.Contains(sent, "hook_abc123") {
+			t.Errorf("secret sent in notification: %q", sent)
+		}
+	}
+
+	slow := &blockingNotifier{release: make(chan struct{})}
+	defer close(slow.release)
+	h = &NotifyHandler{Notifiers: []notify.Notifier{slow}}
+	node = &pipeline.Node{ID: "alert", Type: "notify", Timeout: 10 * time.Millisecond, Attrs: map[string]string{"message": "hi"}}
+	outcome, err := h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail || !strings.Contains(outcome.FailureReason, "timed out") {
+		t.Errorf("expected a timeout failure, got %s: %s", outcome.Status, outcome.FailureReason)
+	}
+}
+
+// blockingNotifier never returns until release is closed.
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(subject, message string) error {
+	<-n.release
+	return nil
+}
+
+func TestNotifyHandlerNoChannels(t *testing.T) {
+	t.Setenv("ATTRACTOR_SLACK_WEBHOOK_URL", "")
+	t.Setenv("ATTRACTOR_SMTP_ADDR", "")
+	h := &NotifyHandler{}
+	node := &pipeline.Node{ID: "alert", Type: "notify", Attrs: map[string]string{}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL with no channels, got %s", outcome.Status)
+	}
+}
+
+func TestToolHandlerSecrets(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_NPM_TOKEN", "npm_abc123")
+	registry := NewRegistry(nil, nil, WithSecrets(secrets.FromEnv()))
+	node := &pipeline.Node{
+		ID:    "publish",
+		Shape: "parallelogram",
+		Attrs: map[string]string{"tool_command": "echo token=${secret:NPM_TOKEN}"},
+	}
+
+	outcome, err := registry.Resolve(node).Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusSuccess {
+		t.Fatalf("expected SUCCESS, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+	if got := outcome.ContextUpdates["tool.output"]; got != "token=[REDACTED:NPM_TOKEN]\n" {
+		t.Errorf("expected redacted tool output, got %q", got)
+	}
+}
+
+func TestToolHandlerMissingSecret(t *testing.T) {
+	h := &ToolHandler{Secrets: secrets.NewStore(secrets.Chain{})}
+	node := &pipeline.Node{ID: "t", Attrs: map[string]string{"tool_command": "echo ${secret:NOPE}"}}
+	outcome, _ := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL for unresolved secret, got %s", outcome.Status)
+	}
+}
+
+func TestToolHandlerCapturesStderrAndExitCode(t *testing.T) {
+	h := &ToolHandler{}
+	logsRoot := t.TempDir()
+	node := &pipeline.Node{
+		ID:    "check",
+		Attrs: map[string]string{"tool_command": "echo out; echo oops >&2; exit 3"},
+	}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, logsRoot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL for non-zero exit, got %s", outcome.Status)
+	}
+	if got := outcome.ContextUpdates["tool.exit_code"]; got != 3 {
+		t.Errorf("expected exit code 3, got %v", got)
+	}
+	if got := outcome.ContextUpdates["tool.output"]; got != "out\n" {
+		t.Errorf("expected stdout only in tool.output, got %q", got)
+	}
+	if got := outcome.ContextUpdates["tool.stderr"]; got != "oops\n" {
+		t.Errorf("expected stderr in tool.stderr, got %q", got)
+	}
+	if !strings.Contains(outcome.FailureReason, "oops") {
+		t.Errorf("expected stderr in failure reason, got %q", outcome.FailureReason)
+	}
+	data, err := os.ReadFile(filepath.Join(logsRoot, "check", "stdout.log"))
+	if err != nil || string(data) != "out\n" {
+		t.Errorf("expected stdout.log to hold the output, got %q (%v)", data, err)
+	}
+}
+
+func TestToolHandlerEnvAndWorkdir(t *testing.T) {
+	h := &ToolHandler{}
+	dir := t.TempDir()
+	node := &pipeline.Node{
+		ID: "env",
+		Attrs: map[string]string{
+			"tool_command": `echo "$GREETING $TARGET"; basename "$(pwd)"`,
+			"tool_env":     "GREETING=hello",
+			"env.TARGET":   "world",
+			"workdir":      dir,
+		},
+	}
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "hello world\n" + filepath.Base(dir) + "\n"
+	if got := outcome.ContextUpdates["tool.output"]; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := outcome.ContextUpdates["tool.exit_code"]; got != 0 {
+		t.Errorf("expected exit code 0, got %v", got)
+	}
+}
+
+func TestStageEnvironment(t *testing.T) {
+	t.Setenv("ATTRACTOR_SECRET_TOKEN", "s3cret")
+	store := secrets.FromEnv()
+	base := t.TempDir()
+	node := &pipeline.Node{ID: "n", Attrs: map[string]string{"workdir": "pkg", "env.TOKEN": "${secret:TOKEN}"}}
+
+	dir, vars, err := stageEnvironment(store, node, base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(base, "pkg"); dir != want {
+		t.Errorf("expected workdir %s under the base, got %s", want, dir)
+	}
+	if len(vars) != 1 || vars[0] != "TOKEN=s3cret" {
+		t.Errorf("expected the secret expanded in env.TOKEN, got %v", vars)
+	}
+
+	node.Attrs["workdir"] = base
+	if dir, _, _ := stageEnvironment(store, node, "/elsewhere"); dir != base {
+		t.Errorf("expected an absolute workdir to ignore the base, got %s", dir)
+	}
+	if dir, _, _ := stageEnvironment(store, &pipeline.Node{Attrs: map[string]string{}}, ""); dir != "" {
+		t.Errorf("expected no workdir to mean the current directory, got %q", dir)
+	}
+}
+
+func TestToolHandlerTimeout(t *testing.T) {
+	h := &ToolHandler{}
+	node := &pipeline.Node{
+		ID:      "slow",
+		Timeout: 100 * time.Millisecond,
+		Attrs:   map[string]string{"tool_command": "sleep 5"},
+	}
+	start := time.Now()
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected the command to be killed, took %s", elapsed)
+	}
+	if outcome.Status != pipeline.StatusFail || !strings.Contains(outcome.FailureReason, "timed out") {
+		t.Errorf("expected timeout failure, got %s (%s)", outcome.Status, outcome.FailureReason)
+	}
+}
+
+func TestToolHandlerInvalidEnv(t *testing.T) {
+	h := &ToolHandler{}
+	node := &pipeline.Node{ID: "t", Attrs: map[string]string{"tool_command": "true", "tool_env": "NOEQUALS"}}
+	outcome, _ := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL for invalid tool_env, got %s", outcome.Status)
+	}
+}
+
+func TestToolHandlerExpandsContext(t *testing.T) {
+	h := &ToolHandler{}
+	ctx := pipeline.NewContext()
+	ctx.Set("name", "a b; rm -rf /")
+	node := &pipeline.Node{ID: "t", Attrs: map[string]string{"tool_command": "printf '%s|' {context.name}"}}
+	outcome, _ := h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if got := outcome.ContextUpdates["tool.output"]; got != "a b; rm -rf /|" {
+		t.Errorf("expected the value passed as one quoted argument, got %q", got)
+	}
+
+	node.Attrs["tool_command"] = "echo {context.unset}"
+	outcome, _ = h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, "")
+	if outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL for an unset context key, got %s", outcome.Status)
+	}
+}
+
+type errBackend struct {
+	err error
+}
+
+func (b *errBackend) Run(node *pipeline.Node, prompt string, ctx *pipeline.Context) (interface{}, error) {
+	return nil, b.err
+}
+
+func TestCodergenHandlerReturnsRetryableErrors(t *testing.T) {
+	node := &pipeline.Node{ID: "plan", Prompt: "Plan", Attrs: map[string]string{}}
+	graph := &pipeline.Graph{}
+
+	limited := &llm.LLMError{Type: llm.ErrorTypeRateLimit, RetryAfter: 5 * time.Second}
+	h := &CodergenHandler{Backend: &errBackend{err: limited}}
+	_, err := h.Execute(node, pipeline.NewContext(), graph, t.TempDir())
+	var llmErr *llm.LLMError
+	if !errors.As(err, &llmErr) || llmErr.RetryDelay() != 5*time.Second {
+		t.Fatalf("expected the rate limit error for the engine to retry, got %v", err)
+	}
+
+	h = &CodergenHandler{Backend: &errBackend{err: &llm.LLMError{Type: llm.ErrorTypeBadRequest, Message: "bad"}}}
+	outcome, err := h.Execute(node, pipeline.NewContext(), graph, t.TempDir())
+	if err != nil || outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected a failed outcome for a permanent error, got %+v, %v", outcome, err)
+	}
+}
+
+// outcomeBackend returns a fixed outcome.
+type outcomeBackend struct {
+	outcome *pipeline.Outcome
+}
+
+func (b *outcomeBackend) Run(node *pipeline.Node, prompt string, ctx *pipeline.Context) (interface{}, error) {
+	return b.outcome, nil
+}
+
+func TestCodergenHandlerScrubsStatus(t *testing.T) {
+	backend := &outcomeBackend{outcome: &pipeline.Outcome{
+		Status:         pipeline.StatusSuccess,
+		ContextUpdates: map[string]interface{}{"github_token": "ghp_abc", "branch": "main"},
+	}}
+	h := &CodergenHandler{Backend: backend, Scrubber: secrets.DefaultScrubber()}
+	node := &pipeline.Node{ID: "push", Prompt: "Push", Attrs: map[string]string{}}
+	logsRoot := t.TempDir()
+
+	outcome, err := h.Execute(node, pipeline.NewContext(), &pipeline.Graph{}, logsRoot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.ContextUpdates["github_token"] != "ghp_abc" {
+		t.Errorf("expected the returned outcome to keep its value, got %v", outcome.ContextUpdates["github_token"])
+	}
+	data, err := os.ReadFile(filepath.Join(logsRoot, "push", "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "ghp_abc") || !strings.Contains(string(data), `"branch": "main"`) {
+		t.Errorf("expected only github_token scrubbed from status.json, got %s", data)
+	}
+}
+
+func TestAssertHandler(t *testing.T) {
+	h := &AssertHandler{}
+	ctx := pipeline.NewContext()
+	ctx.Set("outcome", "success")
+	ctx.Set("tests_passed", "false")
+	ctx.Set("report", map[string]interface{}{"coverage": 0.9})
+
+	node := &pipeline.Node{ID: "check", Type: "assert", Attrs: map[string]string{
+		"expect": "outcome=success && context.report.coverage=0.9",
+	}}
+	outcome, err := h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outcome.Status != pipeline.StatusSuccess {
+		t.Errorf("expected SUCCESS, got %s: %s", outcome.Status, outcome.FailureReason)
+	}
+
+	node.Attrs["expect"] = "context.tests_passed=true && context.lint_clean"
+	node.Attrs["message"] = "Quality gate"
+	outcome, _ = h.Execute(node, ctx, &pipeline.Graph{}, "")
+	if outcome.Status != pipeline.StatusFail {
+		t.Fatalf("expected FAIL, got %s", outcome.Status)
+	}
+	want := `Quality gate: context.tests_passed=true (got "false"); context.lint_clean (not set)`
+	if outcome.FailureReason != want {
+		t.Errorf("expected reason %q, got %q", want, outcome.FailureReason)
+	}
+
+	delete(node.Attrs, "expect")
+	if outcome, _ := h.Execute(node, ctx, &pipeline.Graph{}, ""); outcome.Status != pipeline.StatusFail {
+		t.Errorf("expected FAIL without expect, got %s", outcome.Status)
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+// OutcomeSchema is the JSON schema, in the OpenAI {"name", "schema"}
+// wrapper, that LLM stages with structured_outcome=true are asked to answer
+// with. It lets the model report its own stage outcome instead of every
+// completed call counting as success. context_updates is a list of key/value
+// pairs rather than a free-form object, which Gemini's schema subset cannot
+// express.
+var OutcomeSchema = json.RawMessage(`{
+  "name": "stage_outcome",
+  "schema": {
+    "type": "object",
+    "properties": {
+      "status": {
+        "type": "string",
+        "enum": ["success", "partial_success", "retry", "fail", "skipped"],
+        "description": "How the stage went"
+      },
+      "notes": {
+        "type": "string",
+        "description": "The stage's answer or a summary of the work done"
+      },
+      "context_updates": {
+        "type": "array",
+        "items": {
+          "type": "object",
+          "properties": {
+            "key": {"type": "string"},
+            "value": {"type": "string"}
+          },
+          "required": ["key", "value"]
+        },
+        "description": "Values to set in the pipeline context for later stages"
+      },
+      "suggested_next": {
+        "type": "array",
+        "items": {"type": "string"},
+        "description": "IDs of the nodes that should run next, in order of preference"
+      }
+    },
+    "required": ["status", "notes"]
+  }
+}`)
+
+// outcomeInstructions is appended to the prompt so that providers without
+// schema-constrained output still know the contract.
+const outcomeInstructions = `
+
+Respond with a single JSON object and nothing else:
+{"status": "success" | "partial_success" | "retry" | "fail" | "skipped", "notes": "<your answer or summary>", "context_updates": [{"key": "<key>", "value": "<value>"}], "suggested_next": ["<node id>"]}
+Use "fail" or "retry" with the reason in notes if you could not complete the task. context_updates and suggested_next are optional.`
+
+// structuredOutcome reports whether node asks its LLM stage to answer with
+// OutcomeSchema.
+func structuredOutcome(node *pipeline.Node) bool {
+	return node.Attrs["structured_outcome"] == "true"
+}
+
+// reservedContextKey reports whether a context update reported by a model
+// would overwrite a key the engine or the codergen handler sets itself.
+func reservedContextKey(key string) bool {
+	switch key {
+	case "outcome", "preferred_label", "last_stage", "last_response", "confidence", "confident":
+		return true
+	}
+	return strings.HasPrefix(key, "graph.")
+}
+
+// outcomeResponse is the structured outcome an LLM stage reports.
+type outcomeResponse struct {
+	Status         pipeline.StageStatus `json:"status"`
+	Notes          string               `json:"notes"`
+	ContextUpdates json.RawMessage      `json:"context_updates"`
+	SuggestedNext  []string             `json:"suggested_next"`
+}
+
+// ParseOutcomeResponse parses a model response that follows OutcomeSchema.
+// A surrounding markdown code fence is ignored, and context_updates may also
+// be given as an object. For "fail" and "retry" the notes also become the
+// failure reason.
+func ParseOutcomeResponse(text string) (*pipeline.Outcome, error) {
+	text = strings.TrimSpace(text)
+	if body, ok := strings.CutPrefix(text, "```"); ok {
+		body = strings.TrimPrefix(body, "json")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(body), "```"))
+	}
+
+	var resp outcomeResponse
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		return nil, fmt.Errorf("invalid outcome JSON: %w", err)
+	}
+	switch resp.Status {
+	case pipeline.StatusSuccess, pipeline.StatusPartialSuccess, pipeline.StatusSkipped:
+	case pipeline.StatusFail, pipeline.StatusRetry:
+	case "":
+		return nil, fmt.Errorf("outcome has no status")
+	default:
+		return nil, fmt.Errorf("unknown outcome status %q", resp.Status)
+	}
+
+	updates, err := parseContextUpdates(resp.ContextUpdates)
+	if err != nil {
+		return nil, err
+	}
+
+	outcome := &pipeline.Outcome{
+		Status:           resp.Status,
+		Notes:            resp.Notes,
+		ContextUpdates:   updates,
+		SuggestedNextIDs: resp.SuggestedNext,
+	}
+	if resp.Status == pipeline.StatusFail || resp.Status == pipeline.StatusRetry {
+		outcome.FailureReason = resp.Notes
+	}
+	return outcome, nil
+}
+
+// parseContextUpdates reads context_updates as OutcomeSchema's list of
+// key/value pairs or as an object.
+func parseContextUpdates(raw json.RawMessage) (map[string]interface{}, error) {
+	raw = json.RawMessage(strings.TrimSpace(string(raw)))
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '[' {
+		var updates map[string]interface{}
+		if err := json.Unmarshal(raw, &updates); err != nil {
+			return nil, fmt.Errorf("invalid context_updates: %w", err)
+		}
+		return updates, nil
+	}
+	var pairs []struct {
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &pairs); err != nil {
+		return nil, fmt.Errorf("invalid context_updates: %w", err)
+	}
+	updates := make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		if p.Key != "" {
+			updates[p.Key] = p.Value
+		}
+	}
+	return updates, nil
+}
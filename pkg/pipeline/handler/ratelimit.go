@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+// RateLimiter spaces out LLM calls that share a run so they stay under a
+// requests-per-minute and a tokens-per-minute budget. Each budget is a token
+// bucket that holds up to a minute's allowance and refills continuously, so
+// a burst of parallel stages is let through until the bucket empties and
+// then paced at the configured rate.
+//
+// A call's token cost is unknown until it returns, so Wait reserves an
+// estimate and Record settles the difference; a call that used more than
+// estimated leaves the bucket in debt, delaying the calls after it.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+	now      func() time.Time
+}
+
+// NewRateLimiter returns a limiter allowing requestsPerMinute calls and
+// tokensPerMinute input plus output tokens. A zero or negative rate is not
+// limited; if both are, NewRateLimiter returns nil, which never waits.
+func NewRateLimiter(requestsPerMinute, tokensPerMinute int) *RateLimiter {
+	if requestsPerMinute <= 0 && tokensPerMinute <= 0 {
+		return nil
+	}
+	l := &RateLimiter{now: time.Now}
+	start := l.now()
+	if requestsPerMinute > 0 {
+		l.requests = newBucket(requestsPerMinute, start)
+	}
+	if tokensPerMinute > 0 {
+		l.tokens = newBucket(tokensPerMinute, start)
+	}
+	return l
+}
+
+// Wait blocks until a call estimated to use tokens fits both budgets, then
+// reserves it. It returns early with ctx's error if ctx ends first.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		delay := l.reserve(tokens)
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Record settles a call reserved with an estimate of estimated tokens that
+// actually used used tokens.
+func (l *RateLimiter) Record(estimated, used int) {
+	if l == nil || l.tokens == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.level -= float64(used - estimated)
+}
+
+// reserve takes one request and tokens from the buckets if both have room
+// and returns 0, or else takes nothing and returns how long until they
+// will. An estimate larger than the bucket waits only for a full bucket.
+func (l *RateLimiter) reserve(tokens int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var delay time.Duration
+	if l.requests != nil {
+		delay = max(delay, l.requests.wait(1, now))
+	}
+	if l.tokens != nil {
+		delay = max(delay, l.tokens.wait(float64(tokens), now))
+	}
+	if delay > 0 {
+		return delay
+	}
+	if l.requests != nil {
+		l.requests.level--
+	}
+	if l.tokens != nil {
+		l.tokens.level -= float64(tokens)
+	}
+	return 0
+}
+
+// RunRateLimit paces every call made through an LLM client by the graph
+// being run: LLM stages, agent sessions, and fan-in judges alike. Register it
+// as a transform, which gives each run a fresh limiter from the graph's
+// requests_per_minute and tokens_per_minute attributes, and install
+// Middleware and StreamMiddleware on the run's client. Runs sharing a
+// RunRateLimit share its budget, so give concurrent runs one each.
+type RunRateLimit struct {
+	mu      sync.Mutex
+	limiter *RateLimiter
+}
+
+// Apply implements the transform interface, replacing the limiter for the
+// run about to start.
+func (r *RunRateLimit) Apply(graph *pipeline.Graph) *pipeline.Graph {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limiter = NewRateLimiter(graph.RequestsPerMinute, graph.TokensPerMinute)
+	return graph
+}
+
+// Limiter returns the current run's limiter, or nil if it has no limits.
+func (r *RunRateLimit) Limiter() *RateLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limiter
+}
+
+// Middleware returns client middleware that waits for each call's turn and
+// settles its token usage once it returns.
+func (r *RunRateLimit) Middleware() llm.Middleware {
+	return func(ctx context.Context, req *llm.Request, next llm.MiddlewareNext) (*llm.Response, error) {
+		l := r.Limiter()
+		estimate := llm.EstimateTokens(req)
+		if err := l.Wait(ctx, estimate); err != nil {
+			return nil, err
+		}
+		resp, err := next(ctx, req)
+		if err != nil {
+			l.Record(estimate, 0)
+			return nil, err
+		}
+		l.Record(estimate, resp.Usage.TotalTokens)
+		return resp, nil
+	}
+}
+
+// StreamMiddleware is Middleware for streamed calls. Their usage is settled
+// from the end event; a stream that ends without one keeps its estimate.
+func (r *RunRateLimit) StreamMiddleware() llm.StreamMiddleware {
+	return func(ctx context.Context, req *llm.Request, next llm.StreamMiddlewareNext) (<-chan llm.StreamEvent, error) {
+		l := r.Limiter()
+		estimate := llm.EstimateTokens(req)
+		if err := l.Wait(ctx, estimate); err != nil {
+			return nil, err
+		}
+		events, err := next(ctx, req)
+		if err != nil {
+			l.Record(estimate, 0)
+			return nil, err
+		}
+		if l == nil {
+			return events, nil
+		}
+		out := make(chan llm.StreamEvent)
+		go func() {
+			defer close(out)
+			sender := llm.NewStreamSender(ctx, out)
+			for ev := range events {
+				if ev.Type == llm.StreamEventEnd && ev.Usage != nil {
+					l.Record(estimate, ev.Usage.TotalTokens)
+				}
+				if !sender.Send(ev) {
+					sender.Finish(nil)
+					return
+				}
+			}
+		}()
+		return out, nil
+	}
+}
+
+// bucket is a token bucket holding up to a minute's allowance.
+type bucket struct {
+	capacity float64
+	perSec   float64
+	level    float64
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	return &bucket{
+		capacity: float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		level:    float64(perMinute),
+		last:     now,
+	}
+}
+
+// wait refills the bucket to now and returns how long until it holds n.
+func (b *bucket) wait(n float64, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.level = math.Min(b.capacity, b.level+elapsed*b.perSec)
+		b.last = now
+	}
+	n = math.Min(n, b.capacity)
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration(math.Ceil((n - b.level) / b.perSec * float64(time.Second)))
+}
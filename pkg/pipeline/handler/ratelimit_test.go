@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ashka-vakil/attractor/internal/testutil"
+	"github.com/ashka-vakil/attractor/pkg/llm"
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+func TestRateLimiterRequests(t *testing.T) {
+	l := NewRateLimiter(2, 0)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	l.requests.last = now
+
+	for i := 0; i < 2; i++ {
+		if d := l.reserve(0); d != 0 {
+			t.Fatalf("call %d waited %v within the burst", i, d)
+		}
+	}
+	if d := l.reserve(0); d != 30*time.Second {
+		t.Errorf("third call waits %v, want 30s", d)
+	}
+	now = now.Add(30 * time.Second)
+	if d := l.reserve(0); d != 0 {
+		t.Errorf("call after refill waited %v", d)
+	}
+}
+
+func TestRateLimiterTokens(t *testing.T) {
+	l := NewRateLimiter(0, 600)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	l.tokens.last = now
+
+	if d := l.reserve(100); d != 0 {
+		t.Fatalf("first call waited %v", d)
+	}
+	// The call used 700 tokens, not 100, leaving the bucket 100 in debt.
+	l.Record(100, 700)
+	if d := l.reserve(100); d != 20*time.Second {
+		t.Errorf("call after overrun waits %v, want 20s", d)
+	}
+	// Estimates above the bucket size wait for a full bucket, not forever.
+	now = now.Add(time.Hour)
+	if d := l.reserve(5000); d != 0 {
+		t.Errorf("oversized call waited %v with a full bucket", d)
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	l := NewRateLimiter(0, 0)
+	if l != nil {
+		t.Fatal("expected no limiter without rates")
+	}
+	if err := l.Wait(context.Background(), 100); err != nil {
+		t.Errorf("nil limiter Wait: %v", err)
+	}
+	l.Record(100, 200)
+}
+
+func TestRunRateLimit(t *testing.T) {
+	adapter := testutil.NewMockAdapter("mock")
+	adapter.CompleteFunc = func(_ context.Context, req *llm.Request) (*llm.Response, error) {
+		resp := testutil.MockResponse("done")
+		resp.Usage = llm.Usage{InputTokens: 40, OutputTokens: 20, TotalTokens: 60}
+		return resp, nil
+	}
+	pacer := &RunRateLimit{}
+	client := llm.NewClient(
+		llm.WithProvider("mock", adapter),
+		llm.WithMiddleware(pacer.Middleware()),
+		llm.WithStreamMiddleware(pacer.StreamMiddleware()),
+	)
+	graph, err := pipeline.Parse(`digraph g { graph [requests_per_minute=30, tokens_per_minute=1000]; start [shape=Mdiamond]; exit [shape=Msquare]; start -> exit }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	pacer.Apply(graph)
+	limiter := pacer.Limiter()
+	if limiter == nil || limiter.requests.capacity != 30 || limiter.tokens.capacity != 1000 {
+		t.Fatalf("limiter = %+v", limiter)
+	}
+
+	backend := &LLMBackend{Client: client, DefaultModel: "mock-model"}
+	node := &pipeline.Node{ID: "impl", Prompt: "Write the code", Attrs: map[string]string{}}
+	if _, err := backend.Run(node, "Write the code", pipeline.NewContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := limiter.tokens.level; got != 940 {
+		t.Errorf("tokens left after a call = %v, want 940", got)
+	}
+
+	// Streamed calls, as agent sessions make, are paced too.
+	events, err := client.Stream(context.Background(), &llm.Request{Model: "mock-model", Messages: []llm.Message{{Role: llm.RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	for range events {
+	}
+	if got := limiter.requests.level; got > 28.1 {
+		t.Errorf("requests left after a stream = %v, want about 28", got)
+	}
+	if got := limiter.tokens.level; got > 880.1 {
+		t.Errorf("tokens left after a stream = %v, want about 880", got)
+	}
+
+	pacer.Apply(&pipeline.Graph{})
+	if pacer.Limiter() != nil {
+		t.Error("expected no limit for a graph without rate attributes")
+	}
+	if _, err := backend.Run(node, "Write the code", pipeline.NewContext()); err != nil {
+		t.Fatalf("unexpected error without limits: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// InterviewVersion is the current version of the JSON interview format.
+const InterviewVersion = 1
+
+// Interview is a recorded interviewer session: the questions a run's human
+// gates asked, in order, and the answers they got.
+type Interview struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded question and its answer.
+type Interaction struct {
+	// Hash is the question's QuestionHash.
+	Hash     string    `json:"hash"`
+	Question *Question `json:"question"`
+	Answer   *Answer   `json:"answer"`
+}
+
+// QuestionHash identifies a question for replay by its stage, type, text,
+// and options. Defaults, timeouts, and metadata are not part of it.
+func QuestionHash(q *Question) string {
+	data, _ := json.Marshal(struct {
+		Stage   string           `json:"stage"`
+		Type    int              `json:"type"`
+		Text    string           `json:"text"`
+		Options []QuestionOption `json:"options"`
+	}{q.Stage, int(q.Type), q.Text, q.Options})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Interview returns the interactions recorded so far.
+func (r *RecordingInterviewer) Interview() *Interview {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Interview{
+		Version:      InterviewVersion,
+		Interactions: append([]Interaction(nil), r.Recordings...),
+	}
+}
+
+// Save writes the interactions recorded so far to path as JSON.
+func (r *RecordingInterviewer) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("save interview: %w", err)
+	}
+	if err := r.Interview().Export(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Export writes the interview to w as indented JSON.
+func (iv *Interview) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(iv); err != nil {
+		return fmt.Errorf("export interview: %w", err)
+	}
+	return nil
+}
+
+// ImportInterview reads an interview written by Interview.Export.
+func ImportInterview(r io.Reader) (*Interview, error) {
+	var iv Interview
+	if err := json.NewDecoder(r).Decode(&iv); err != nil {
+		return nil, fmt.Errorf("import interview: %w", err)
+	}
+	if iv.Version > InterviewVersion {
+		return nil, fmt.Errorf("interview version %d is newer than supported version %d", iv.Version, InterviewVersion)
+	}
+	for i, in := range iv.Interactions {
+		if in.Question == nil {
+			return nil, fmt.Errorf("import interview: interaction %d has no question", i)
+		}
+		// A hand-written fixture may leave the hash out.
+		if in.Hash == "" {
+			iv.Interactions[i].Hash = QuestionHash(in.Question)
+		}
+	}
+	return &iv, nil
+}
+
+// LoadInterview reads the interview saved at path.
+func LoadInterview(path string) (*Interview, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open interview: %w", err)
+	}
+	defer f.Close()
+	return ImportInterview(f)
+}
+
+// ReplayInterviewer answers questions from a recorded Interview, matching
+// each to a recorded question by QuestionHash, so the order of questions
+// may differ from the recording's. A question asked several times gets its
+// recorded answers in order. Questions the recording does not answer go to
+// Fallback, or are skipped if it is nil.
+type ReplayInterviewer struct {
+	Fallback Interviewer
+
+	mu      sync.Mutex
+	answers map[string][]*Answer
+	missed  []*Question
+}
+
+// NewReplayInterviewer returns an interviewer that replays iv.
+func NewReplayInterviewer(iv *Interview, fallback Interviewer) *ReplayInterviewer {
+	r := &ReplayInterviewer{Fallback: fallback, answers: make(map[string][]*Answer)}
+	for _, in := range iv.Interactions {
+		r.answers[in.Hash] = append(r.answers[in.Hash], in.Answer)
+	}
+	return r
+}
+
+func (r *ReplayInterviewer) Ask(question *Question) *Answer {
+	hash := QuestionHash(question)
+	r.mu.Lock()
+	if answers := r.answers[hash]; len(answers) > 0 {
+		r.answers[hash] = answers[1:]
+		r.mu.Unlock()
+		return answers[0]
+	}
+	r.missed = append(r.missed, question)
+	r.mu.Unlock()
+
+	if r.Fallback != nil {
+		return r.Fallback.Ask(question)
+	}
+	return &Answer{Value: AnswerSkipped}
+}
+
+func (r *ReplayInterviewer) Inform(message, stage string) {
+	if r.Fallback != nil {
+		r.Fallback.Inform(message, stage)
+	}
+}
+
+// Missed returns the questions the recording had no answer for, in the
+// order they were asked. A run that replays cleanly has none.
+func (r *ReplayInterviewer) Missed() []*Question {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Question(nil), r.missed...)
+}
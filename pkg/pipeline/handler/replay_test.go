@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+func TestRecordAndReplayInterview(t *testing.T) {
+	graph := &pipeline.Graph{Edges: []*pipeline.Edge{
+		{From: "gate", To: "ship", Label: "[S] Ship"},
+		{From: "gate", To: "hold", Label: "[H] Hold"},
+	}}
+	gate := &pipeline.Node{ID: "gate", Label: "Ship it?", Attrs: map[string]string{}}
+	form := &pipeline.Node{ID: "triage", Attrs: map[string]string{
+		"human.type":                "form",
+		"human.fields":              "summary, approved",
+		"human.field.approved.type": "confirm",
+	}}
+
+	recorder := &RecordingInterviewer{Inner: &QueueInterviewer{Answers: []*Answer{
+		{Value: "H"},
+		{Text: "flaky test"},
+		{Value: AnswerNo},
+	}}}
+	h := &WaitForHumanHandler{Interviewer: recorder}
+	recorded := []*pipeline.Outcome{}
+	for _, node := range []*pipeline.Node{gate, form} {
+		outcome, err := h.Execute(node, pipeline.NewContext(), graph, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		recorded = append(recorded, outcome)
+	}
+	path := filepath.Join(t.TempDir(), "interview.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	iv, err := LoadInterview(path)
+	if err != nil {
+		t.Fatalf("LoadInterview: %v", err)
+	}
+	if len(iv.Interactions) != 3 || iv.Interactions[2].Answer.Value != AnswerNo {
+		t.Fatalf("expected 3 interactions ending in NO, got %+v", iv.Interactions)
+	}
+
+	// Replay in the opposite order: answers are matched by question.
+	replay := NewReplayInterviewer(iv, nil)
+	h = &WaitForHumanHandler{Interviewer: replay}
+	for i, node := range []*pipeline.Node{form, gate} {
+		outcome, err := h.Execute(node, pipeline.NewContext(), graph, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := recorded[1-i]
+		if outcome.Status != want.Status {
+			t.Errorf("%s: expected %s, got %s", node.ID, want.Status, outcome.Status)
+		}
+		for key, value := range want.ContextUpdates {
+			if outcome.ContextUpdates[key] != value {
+				t.Errorf("%s: expected %s=%v, got %v", node.ID, key, value, outcome.ContextUpdates[key])
+			}
+		}
+	}
+	if missed := replay.Missed(); len(missed) != 0 {
+		t.Errorf("expected every question to be replayed, missed %d", len(missed))
+	}
+
+	// The recording is used up, so asking again falls through.
+	if a := replay.Ask(&Question{Text: "Ship it?", Stage: "gate"}); a.Value != AnswerSkipped {
+		t.Errorf("expected an unrecorded question to be skipped, got %v", a.Value)
+	}
+	if len(replay.Missed()) != 1 {
+		t.Errorf("expected 1 missed question, got %d", len(replay.Missed()))
+	}
+}
+
+func TestImportInterview(t *testing.T) {
+	// A hand-written fixture may leave out hashes.
+	iv, err := ImportInterview(strings.NewReader(`{"version": 1, "interactions": [
+		{"question": {"text": "Deploy?", "type": "confirmation", "stage": "deploy"}, "answer": {"special": "yes"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("ImportInterview: %v", err)
+	}
+	replay := NewReplayInterviewer(iv, &AutoApproveInterviewer{})
+	if a := replay.Ask(&Question{Text: "Deploy?", Type: QuestionConfirmation, Stage: "deploy"}); a.Value != AnswerYes {
+		t.Errorf("expected YES, got %v", a.Value)
+	}
+
+	if _, err := ImportInterview(strings.NewReader(`{"version": 99}`)); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected a version error, got %v", err)
+	}
+	if _, err := ImportInterview(strings.NewReader(`{"version": 1, "interactions": [{"answer": {"special": "maybe"}}]}`)); err == nil {
+		t.Error("expected an unknown special answer to be rejected")
+	}
+}
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of human gate, selected with a wait.human node's "human.type"
+// attribute.
+const (
+	HumanChoice   = "choice"   // pick an outgoing edge (the default)
+	HumanFreeform = "freeform" // enter text
+	HumanConfirm  = "confirm"  // answer yes or no
+	HumanForm     = "form"     // answer several questions
+)
+
+// HumanField is one question of a human gate that collects answers into the
+// context rather than choosing an edge.
+type HumanField struct {
+	// Key is the context key the answer is stored under.
+	Key string
+	// Text is the question shown to the human.
+	Text string
+	// Type is HumanFreeform, HumanConfirm, or HumanChoice.
+	Type string
+	// Options are the answers a HumanChoice field accepts.
+	Options []string
+	// Default is the answer used when the question times out.
+	Default string
+}
+
+// HumanType returns the node's human.type, defaulting to HumanChoice.
+func (n *Node) HumanType() string {
+	if t := strings.TrimSpace(n.Attrs["human.type"]); t != "" {
+		return t
+	}
+	return HumanChoice
+}
+
+// HumanFields returns the questions of a freeform, confirm, or form human
+// gate, or nil for a choice gate.
+//
+// A freeform or confirm gate asks its prompt (or label) and stores the
+// answer under "human.store", by default human.gate.text or
+// human.gate.confirmed; "human.default" is its timeout answer. A form lists
+// its context keys in "human.fields", comma separated, and describes each
+// key K with:
+//
+//	human.field.K          the question (default: K)
+//	human.field.K.type     freeform (default), confirm, or choice
+//	human.field.K.options  a choice field's answers, comma separated
+//	human.field.K.default  the timeout answer
+func (n *Node) HumanFields() ([]HumanField, error) {
+	text := n.Prompt
+	if text == "" {
+		text = n.Label
+	}
+	switch kind := n.HumanType(); kind {
+	case HumanChoice:
+		return nil, nil
+	case HumanFreeform, HumanConfirm:
+		key := n.Attrs["human.store"]
+		if key == "" {
+			key = "human.gate.text"
+			if kind == HumanConfirm {
+				key = "human.gate.confirmed"
+			}
+		}
+		return []HumanField{{Key: key, Text: text, Type: kind, Default: n.Attrs["human.default"]}}, nil
+	case HumanForm:
+		var fields []HumanField
+		for _, key := range strings.Split(n.Attrs["human.fields"], ",") {
+			if key = strings.TrimSpace(key); key == "" {
+				continue
+			}
+			prefix := "human.field." + key
+			f := HumanField{
+				Key:     key,
+				Text:    n.Attrs[prefix],
+				Type:    n.Attrs[prefix+".type"],
+				Default: n.Attrs[prefix+".default"],
+			}
+			if f.Text == "" {
+				f.Text = key
+			}
+			if f.Type == "" {
+				f.Type = HumanFreeform
+			}
+			for _, opt := range strings.Split(n.Attrs[prefix+".options"], ",") {
+				if opt = strings.TrimSpace(opt); opt != "" {
+					f.Options = append(f.Options, opt)
+				}
+			}
+			switch f.Type {
+			case HumanFreeform, HumanConfirm:
+			case HumanChoice:
+				if len(f.Options) == 0 {
+					return nil, fmt.Errorf("form field %q is a choice with no %s.options", key, prefix)
+				}
+			default:
+				return nil, fmt.Errorf("form field %q has unknown type %q", key, f.Type)
+			}
+			fields = append(fields, f)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("form has no human.fields")
+		}
+		return fields, nil
+	default:
+		return nil, fmt.Errorf("unknown human.type %q", kind)
+	}
+}
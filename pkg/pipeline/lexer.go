@@ -30,6 +30,8 @@ const (
 	TokenSemicolon
 	TokenArrow
 	TokenDot
+	TokenColon
+	TokenPlus
 )
 
 var tokenNames = map[TokenType]string{
@@ -53,6 +55,8 @@ var tokenNames = map[TokenType]string{
 	TokenSemicolon:  ";",
 	TokenArrow:      "->",
 	TokenDot:        ".",
+	TokenColon:      ":",
+	TokenPlus:       "+",
 }
 
 func (t TokenType) String() string {
@@ -72,33 +76,63 @@ type Token struct {
 
 // Lexer tokenizes DOT source.
 type Lexer struct {
-	input  []rune
-	pos    int
-	line   int
-	col    int
-	tokens []Token
+	input    []rune
+	pos      int
+	line     int
+	col      int
+	tokens   []Token
+	comments []Comment
+}
+
+// Comment is a // or /* */ comment in DOT source.
+type Comment struct {
+	// Text is the comment without its delimiters, trimmed, with the leading
+	// "*" of each line of a block comment removed.
+	Text string
+	// Line and EndLine are the comment's first and last lines.
+	Line    int
+	EndLine int
+	// Trailing is true for a comment that follows code on its first line.
+	Trailing bool
 }
 
 // NewLexer creates a new lexer for the given input.
 func NewLexer(input string) *Lexer {
 	// Strip comments first.
-	cleaned := stripComments(input)
+	cleaned, comments := stripComments(input)
 	return &Lexer{
-		input: []rune(cleaned),
-		line:  1,
-		col:   1,
+		input:    []rune(cleaned),
+		line:     1,
+		col:      1,
+		comments: comments,
 	}
 }
 
-// stripComments removes // and /* */ comments.
-func stripComments(s string) string {
+// Comments returns the comments in the input, in order.
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+// stripComments removes // and /* */ comments, replacing them with spaces so
+// that tokens keep their lines and columns, and returns them.
+func stripComments(s string) (string, []Comment) {
 	var result strings.Builder
+	var comments []Comment
 	runes := []rune(s)
 	i := 0
+	line := 1
+	code := false // non-space code precedes i on its line
 	inString := false
 	for i < len(runes) {
+		if runes[i] == '\n' {
+			line++
+			code = false
+		}
 		if inString {
 			if runes[i] == '\\' && i+1 < len(runes) {
+				if runes[i+1] == '\n' {
+					line++
+				}
 				result.WriteRune(runes[i])
 				result.WriteRune(runes[i+1])
 				i += 2
@@ -114,39 +148,100 @@ func stripComments(s string) string {
 
 		if runes[i] == '"' {
 			inString = true
+			code = true
 			result.WriteRune(runes[i])
 			i++
 			continue
 		}
 
+		// An HTML string may contain "//", as in a URL.
+		if runes[i] == '<' {
+			code = true
+			for depth := 0; i < len(runes); i++ {
+				switch runes[i] {
+				case '<':
+					depth++
+				case '>':
+					depth--
+				case '\n':
+					line++
+				}
+				result.WriteRune(runes[i])
+				if depth == 0 {
+					i++
+					break
+				}
+			}
+			continue
+		}
+
 		// Line comment
 		if runes[i] == '/' && i+1 < len(runes) && runes[i+1] == '/' {
+			start := i
 			for i < len(runes) && runes[i] != '\n' {
+				result.WriteRune(' ')
 				i++
 			}
+			comments = append(comments, Comment{
+				Text:     strings.TrimSpace(string(runes[start+2 : i])),
+				Line:     line,
+				EndLine:  line,
+				Trailing: code,
+			})
 			continue
 		}
 
 		// Block comment
 		if runes[i] == '/' && i+1 < len(runes) && runes[i+1] == '*' {
+			start, startLine := i, line
+			result.WriteString("  ")
 			i += 2
-			for i+1 < len(runes) {
-				if runes[i] == '*' && runes[i+1] == '/' {
+			end := len(runes)
+			for i < len(runes) {
+				if runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/' {
+					end = i
+					result.WriteString("  ")
 					i += 2
 					break
 				}
 				if runes[i] == '\n' {
 					result.WriteRune('\n') // preserve line numbers
+					line++
+				} else {
+					result.WriteRune(' ')
 				}
 				i++
 			}
+			comments = append(comments, Comment{
+				Text:     blockCommentText(string(runes[min(start+2, end):end])),
+				Line:     startLine,
+				EndLine:  line,
+				Trailing: code,
+			})
 			continue
 		}
 
+		if !unicode.IsSpace(runes[i]) {
+			code = true
+		}
 		result.WriteRune(runes[i])
 		i++
 	}
-	return result.String()
+	return result.String(), comments
+}
+
+// blockCommentText trims a block comment's lines and the "*" that commonly
+// starts each of them.
+func blockCommentText(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if i > 0 {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+		}
+		lines[i] = line
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
 }
 
 // Tokenize produces all tokens from the input.
@@ -229,6 +324,12 @@ func (l *Lexer) next() (Token, error) {
 	case '.':
 		l.advance()
 		return Token{Type: TokenDot, Value: ".", Line: startLine, Column: startCol}, nil
+	case ':':
+		l.advance()
+		return Token{Type: TokenColon, Value: ":", Line: startLine, Column: startCol}, nil
+	case '+':
+		l.advance()
+		return Token{Type: TokenPlus, Value: "+", Line: startLine, Column: startCol}, nil
 	}
 
 	// Arrow ->
@@ -243,6 +344,11 @@ func (l *Lexer) next() (Token, error) {
 		return l.readString(startLine, startCol)
 	}
 
+	// HTML string
+	if ch == '<' {
+		return l.readHTML(startLine, startCol)
+	}
+
 	// Number (including negative)
 	if ch == '-' || unicode.IsDigit(ch) {
 		return l.readNumber(startLine, startCol)
@@ -253,7 +359,7 @@ func (l *Lexer) next() (Token, error) {
 		return l.readIdentifier(startLine, startCol)
 	}
 
-	return Token{}, fmt.Errorf("unexpected character %q at line %d, column %d", ch, startLine, startCol)
+	return Token{}, &ParseError{Line: startLine, Column: startCol, Message: fmt.Sprintf("unexpected character %q", ch)}
 }
 
 func (l *Lexer) readString(line, col int) (Token, error) {
@@ -266,7 +372,14 @@ func (l *Lexer) readString(line, col int) (Token, error) {
 			switch next {
 			case '"':
 				s.WriteByte('"')
-			case 'n':
+			case '\n':
+				// A backslash at the end of a line continues the string.
+			case '\r':
+				if l.peek() == '\n' {
+					l.advance()
+				}
+			case 'n', 'l', 'r':
+				// Graphviz's centered, left-, and right-justified line breaks.
 				s.WriteByte('\n')
 			case 't':
 				s.WriteByte('\t')
@@ -283,7 +396,28 @@ func (l *Lexer) readString(line, col int) (Token, error) {
 		}
 		s.WriteRune(ch)
 	}
-	return Token{}, fmt.Errorf("unterminated string at line %d, column %d", line, col)
+	return Token{}, &ParseError{Line: line, Column: col, Message: "unterminated string"}
+}
+
+// readHTML reads a Graphviz HTML string, <...> with nested <> balanced, as
+// a string token of the text between the outer brackets.
+func (l *Lexer) readHTML(line, col int) (Token, error) {
+	l.advance() // skip opening '<'
+	var s strings.Builder
+	depth := 1
+	for l.pos < len(l.input) {
+		ch := l.advance()
+		switch ch {
+		case '<':
+			depth++
+		case '>':
+			if depth--; depth == 0 {
+				return Token{Type: TokenString, Value: s.String(), Line: line, Column: col}, nil
+			}
+		}
+		s.WriteRune(ch)
+	}
+	return Token{}, &ParseError{Line: line, Column: col, Message: "unterminated HTML string"}
 }
 
 func (l *Lexer) readNumber(line, col int) (Token, error) {
@@ -298,7 +432,7 @@ func (l *Lexer) readNumber(line, col int) (Token, error) {
 	if l.pos >= len(l.input) || !unicode.IsDigit(l.peek()) {
 		// It was just a '-' which we shouldn't have consumed as a number.
 		// But since we handle '->' separately, this shouldn't happen in valid input.
-		return Token{}, fmt.Errorf("unexpected '-' at line %d, column %d", line, col)
+		return Token{}, &ParseError{Line: line, Column: col, Message: "unexpected '-'"}
 	}
 
 	for l.pos < len(l.input) && unicode.IsDigit(l.peek()) {
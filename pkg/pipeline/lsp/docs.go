@@ -0,0 +1,136 @@
+package lsp
+
+// doc is a completion candidate with its hover documentation.
+type doc struct {
+	Name   string
+	Detail string
+}
+
+// nodeAttrDocs documents the node attributes the parser and handlers read.
+var nodeAttrDocs = []doc{
+	{"label", "Display name of the node. Used as the prompt when `prompt` is empty."},
+	{"shape", "DOT shape; selects the handler when `type` is not set (`Mdiamond` start, `Msquare` exit, `box` codergen, ...)."},
+	{"type", "Handler type, overriding the shape mapping (e.g. `codergen`, `tool`, `exec:<binary>`)."},
+	{"prompt", "Instructions for the LLM stage. Supports `$goal`, `${secret:NAME}`, and, with `expand_context=true`, `{context.KEY}`."},
+	{"max_retries", "Additional attempts after the first when the stage returns `retry` or fails."},
+	{"goal_gate", "If `true`, the pipeline cannot exit successfully until this node succeeds."},
+	{"retry_target", "Node to jump to when this goal gate is unsatisfied at exit."},
+	{"fallback_retry_target", "Node to jump to when `retry_target` is missing or exhausted."},
+	{"fidelity", "Context carried into the stage: `full`, `truncate`, `compact`, or `summary:low|medium|high`."},
+	{"thread_id", "Conversation thread shared by stages under `fidelity=full`."},
+	{"class", "Stylesheet class names, comma separated."},
+	{"timeout", "Maximum stage duration, e.g. `900s`, `15m`, `2h`, `1d`."},
+	{"llm_model", "Model for this stage, overriding the stylesheet."},
+	{"llm_provider", "Provider for this stage, overriding the stylesheet."},
+	{"reasoning_effort", "Reasoning effort for this stage: `low`, `medium`, or `high`."},
+	{"logprobs", "If `true`, asks the model for token logprobs and sets `context.confidence` (0-1) from them. OpenAI-compatible providers only."},
+	{"confidence_threshold", "Confidence, 0-1, at or above which the stage sets `context.confident=true` (otherwise `false`); implies `logprobs=true`."},
+	{"expand_context", "If `true`, `{context.KEY}` and `{context.KEY:-DEFAULT}` in the prompt, question, or message are replaced with context values."},
+	{"structured_outcome", "If `true`, the LLM stage answers with a JSON outcome whose `status`, `context_updates`, and `suggested_next` set the stage's outcome."},
+	{"auto_status", "If `true`, a stage that writes no status is treated as successful."},
+	{"allow_partial", "If `true`, exhausting retries yields `partial_success` instead of `fail`."},
+	{"tool_command", "Shell command run by a `tool` (parallelogram) node. `{context.KEY}`, `{context.KEY:-DEFAULT}`, and `{artifact.NODE/FILE}` expand to quoted values; `{raw:context.KEY}` is unquoted."},
+	{"workdir", "Directory the stage's commands and agent session run in."},
+	{"env.", "Environment variable for the stage's commands and agent session, e.g. `env.GOFLAGS=\"-count=1\"`."},
+	{"tool_workdir", "Working directory for `tool_command`; prefer `workdir`."},
+	{"tool_env", "Extra environment for `tool_command` as comma-separated `KEY=VALUE` pairs."},
+	{"join_policy", "How a parallel node joins its branches: `wait_all` (default) or `first_success`."},
+	{"fan_in.strategy", "How a fan-in node aggregates its branches: `concat` their outputs into `parallel.fan_in.output`, `vote` on their outcome, or pick the `best` output with the node's prompt."},
+	{"fan_in.key", "Context key holding each branch's output for `fan_in.strategy`; defaults to `last_response`."},
+	{"human.default_choice", "Choice taken by a `wait.human` node when no answer is given."},
+	{"human.type", "Kind of human gate: `choice` (pick an edge, default), `freeform`, `confirm`, or `form`."},
+	{"human.store", "Context key a `freeform` or `confirm` gate stores its answer under."},
+	{"human.default", "Answer a `freeform` or `confirm` gate uses when no answer is given."},
+	{"human.fields", "Comma-separated context keys a `form` gate asks for, each described by `human.field.KEY` (the question), `.type` (`freeform`, `confirm`, or `choice`), `.options`, and `.default`."},
+	{"manager.max_cycles", "Maximum supervision cycles of a `stack.manager_loop` node."},
+	{"manager.poll_interval", "Delay between supervision cycles of a `stack.manager_loop` node."},
+	{"message", "Body of a `notify` node's message, or the failure reason of an `assert` node."},
+	{"expect", "Condition an `assert` node checks, in the edge condition language, e.g. `context.tests_passed=true`."},
+	{"subject", "Subject of a `notify` node's email."},
+	{"produces", "Comma-separated context keys or glob patterns this stage writes, checked against downstream conditions."},
+	{"cache", "If `false`, the stage always runs instead of reusing an outcome cached by an earlier run."},
+}
+
+// edgeAttrDocs documents edge attributes.
+var edgeAttrDocs = []doc{
+	{"label", "Edge label; matched against a stage's preferred label."},
+	{"condition", "Routing condition: `&&`-joined `key=value`, `key!=value`, or bare truthy keys."},
+	{"weight", "Priority among unconditional edges; higher wins. May be an expression over context keys, e.g. `context.confidence * 10`."},
+	{"fidelity", "Context fidelity for the target stage, overriding the node."},
+	{"thread_id", "Conversation thread for the target stage."},
+	{"loop_restart", "If `true`, following this edge restarts the run with fresh logs."},
+	{"tailport", "Port of the source node the edge leaves from, as in `a:out -> b`. Layout only."},
+	{"headport", "Port of the target node the edge enters, as in `a -> b:in`. Layout only."},
+}
+
+// graphAttrDocs documents graph-level attributes.
+var graphAttrDocs = []doc{
+	{"goal", "The pipeline's objective, available to prompts as `$goal`."},
+	{"label", "Display name of the pipeline."},
+	{"model_stylesheet", "CSS-like rules assigning models to nodes by shape, class, or ID."},
+	{"default_max_retry", "Retry budget for nodes without `max_retries`."},
+	{"default_fidelity", "Context fidelity for nodes without `fidelity`."},
+	{"retry_target", "Graph-wide retry target for unsatisfied goal gates."},
+	{"fallback_retry_target", "Graph-wide fallback retry target."},
+	{"max_tokens", "Stop the run after this many LLM tokens."},
+	{"max_cost_usd", "Stop the run after this estimated cost in USD."},
+	{"schedule", "Cron expression for `attractor serve` to run the pipeline on."},
+	{"max_parallel", "Maximum concurrently running parallel branches."},
+	{"requests_per_minute", "LLM calls per minute allowed across all stages of a run; further calls wait."},
+	{"tokens_per_minute", "LLM input plus output tokens per minute allowed across all stages of a run; further calls wait."},
+}
+
+// shapeDocs documents the shapes that map to built-in handlers.
+var shapeDocs = []doc{
+	{"Mdiamond", "start: pipeline entry point."},
+	{"Msquare", "exit: pipeline terminal."},
+	{"box", "codergen: LLM task execution (default)."},
+	{"hexagon", "wait.human: human interaction gate."},
+	{"diamond", "conditional: conditional branching."},
+	{"component", "parallel: fan-out to branches."},
+	{"tripleoctagon", "parallel.fan_in: consolidate parallel results."},
+	{"parallelogram", "tool: external command execution."},
+	{"house", "stack.manager_loop: manager-run loop pattern."},
+}
+
+// typeDocs documents the built-in handler types.
+var typeDocs = []doc{
+	{"start", "Pipeline entry point."},
+	{"exit", "Pipeline terminal."},
+	{"codergen", "Runs the node's prompt through the LLM backend."},
+	{"wait.human", "Asks a human to choose an outgoing edge."},
+	{"conditional", "Routes on edge conditions without doing work."},
+	{"parallel", "Runs outgoing branches concurrently."},
+	{"parallel.fan_in", "Waits for and combines parallel branches."},
+	{"tool", "Runs `tool_command` in a shell."},
+	{"stack.manager_loop", "Supervises a child pipeline in cycles."},
+	{"notify", "Sends `message` to the configured notifiers."},
+	{"assert", "Fails the stage when its `expect` condition is false."},
+	{"exec:", "Runs an external handler binary, e.g. `exec:my-handler`."},
+}
+
+// conditionKeyDocs documents the keys available in edge conditions.
+var conditionKeyDocs = []doc{
+	{"outcome", "Status of the previous stage: `success`, `partial_success`, `retry`, `fail`, or `skipped`."},
+	{"preferred_label", "Edge label the previous stage asked to follow."},
+	{"context.", "A value from the pipeline context, e.g. `context.tests_passed=true`, or a path into one, e.g. `context.results[0].status=ok`."},
+	{"set(", "`set(pattern)`: the previous stage wrote a context key matching the glob, e.g. `set(context.tests_*)`."},
+}
+
+// outcomeDocs documents the values of the outcome condition key.
+var outcomeDocs = []doc{
+	{"success", "The stage completed."},
+	{"partial_success", "The stage completed with allowed gaps."},
+	{"retry", "The stage asked to be retried."},
+	{"fail", "The stage failed."},
+	{"skipped", "The stage was skipped."},
+}
+
+func lookupDoc(docs []doc, name string) (doc, bool) {
+	for _, d := range docs {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return doc{}, false
+}
@@ -0,0 +1,632 @@
+// Package lsp implements a Language Server Protocol server for pipeline DOT
+// files. It publishes pipeline.Validate diagnostics as documents change and
+// offers hover docs for attributes, shapes, and handler types, completion
+// for attribute names and values, node IDs, and condition keys, and
+// go-to-definition for node references.
+//
+// Documents are synchronized in full on every change. Positions are
+// converted between the parser's 1-based rune columns and LSP's 0-based
+// characters; the two agree for text in the Basic Multilingual Plane.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+)
+
+// Server is a language server speaking JSON-RPC over a byte stream,
+// typically stdin and stdout.
+type Server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*document
+
+	shutdown bool
+}
+
+// document is an open DOT file.
+type document struct {
+	text  string
+	lines []string
+	// graph is the last version of the document that parsed, so hover and
+	// completion keep working while the user is mid-edit.
+	graph *pipeline.Graph
+}
+
+// NewServer returns a server reading requests from in and writing responses
+// and notifications to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Run serves requests until the client sends exit or closes the stream. It
+// returns an error if exit arrives without a preceding shutdown, as the
+// protocol requires.
+func (s *Server) Run() error {
+	for {
+		msg, err := readMessage(s.in)
+		if errors.Is(err, errMalformed) {
+			if err := writeMessage(s.out, &response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{Code: codeParseError, Message: err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+		if msg.ID == nil {
+			if err := s.handleNotification(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "" {
+			continue // a response to a request we never send
+		}
+		if err := s.reply(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// reply handles a request and writes its response.
+func (s *Server) reply(msg *message) error {
+	resp := &response{JSONRPC: "2.0", ID: msg.ID}
+	result, rerr := s.handleRequest(msg)
+	if rerr != nil {
+		resp.Error = rerr
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = data
+	}
+	return writeMessage(s.out, resp)
+}
+
+func (s *Server) handleRequest(msg *message) (any, *rpcError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": 1, // full
+				"hoverProvider":    true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"=", "\"", ">", "[", ",", " "},
+				},
+				"definitionProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "attractor"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/hover", "textDocument/completion", "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil {
+			return nil, nil
+		}
+		switch msg.Method {
+		case "textDocument/hover":
+			if h := doc.hover(params.Position); h != nil {
+				return h, nil
+			}
+			return nil, nil
+		case "textDocument/completion":
+			return doc.complete(params.Position), nil
+		default:
+			if loc := doc.definition(params.TextDocument.URI, params.Position); loc != nil {
+				return loc, nil
+			}
+			return nil, nil
+		}
+	default:
+		return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+}
+
+func (s *Server) handleNotification(msg *message) error {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// With full sync the last change holds the whole document.
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return s.update(params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.publish(params.TextDocument.URI, []Diagnostic{})
+	}
+	return nil // initialized, $/cancelRequest, and others need no action
+}
+
+// update stores the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) error {
+	doc := s.docs[uri]
+	if doc == nil {
+		doc = &document{}
+		s.docs[uri] = doc
+	}
+	doc.text = text
+	doc.lines = strings.Split(text, "\n")
+	return s.publish(uri, doc.diagnose())
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) error {
+	params, err := json.Marshal(PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// diagnose parses the document strictly and validates it. A parse error is
+// reported alone; otherwise the graph is kept for hover and completion.
+func (d *document) diagnose() []Diagnostic {
+	graph, err := pipeline.Parse(d.text, pipeline.WithStrict())
+	if err != nil {
+		var perr *pipeline.ParseError
+		r := Range{}
+		if errors.As(err, &perr) {
+			r = d.wordRange(perr.Pos())
+		}
+		return []Diagnostic{{Range: r, Severity: SeverityError, Code: "parse", Source: "attractor", Message: err.Error()}}
+	}
+	d.graph = graph
+
+	diagnostics := []Diagnostic{}
+	for _, v := range pipeline.Validate(graph) {
+		message := v.Message
+		if v.Fix != "" {
+			message += "\n" + v.Fix
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.wordRange(v.Pos),
+			Severity: lspSeverity(v.Severity),
+			Code:     v.Rule,
+			Source:   "attractor",
+			Message:  message,
+		})
+	}
+	return diagnostics
+}
+
+func lspSeverity(s pipeline.Severity) int {
+	switch s {
+	case pipeline.SeverityError:
+		return SeverityError
+	case pipeline.SeverityWarning:
+		return SeverityWarning
+	default:
+		return SeverityInformation
+	}
+}
+
+// wordRange returns the range of the word starting at pos, or an empty range
+// at the start of the document if pos is unknown.
+func (d *document) wordRange(pos pipeline.Position) Range {
+	if !pos.IsValid() {
+		return Range{}
+	}
+	start := Position{Line: pos.Line - 1, Character: pos.Column - 1}
+	end := start
+	if start.Line < len(d.lines) {
+		line := []rune(d.lines[start.Line])
+		if start.Character < len(line) && line[start.Character] == '"' {
+			end.Character++ // include the quotes of a quoted ID
+			for end.Character < len(line) && line[end.Character] != '"' {
+				end.Character++
+			}
+			end.Character++
+		} else {
+			for end.Character < len(line) && isWordRune(line[end.Character]) {
+				end.Character++
+			}
+		}
+		end.Character = min(end.Character, len(line))
+	}
+	return Range{Start: start, End: end}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '.' || r == ':' ||
+		'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r > 127
+}
+
+// wordAt returns the word under pos and its range.
+func (d *document) wordAt(pos Position) (string, Range) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return "", Range{}
+	}
+	line := []rune(d.lines[pos.Line])
+	start, end := min(pos.Character, len(line)), min(pos.Character, len(line))
+	for start > 0 && isWordRune(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWordRune(line[end]) {
+		end++
+	}
+	return string(line[start:end]), Range{
+		Start: Position{Line: pos.Line, Character: start},
+		End:   Position{Line: pos.Line, Character: end},
+	}
+}
+
+// prefix returns the document text from the start of the current statement
+// to pos, spanning lines so attribute blocks split across lines work.
+func (d *document) prefix(pos Position) string {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return ""
+	}
+	var b strings.Builder
+	for i := 0; i < pos.Line; i++ {
+		b.WriteString(d.lines[i])
+		b.WriteByte('\n')
+	}
+	line := []rune(d.lines[pos.Line])
+	b.WriteString(string(line[:min(pos.Character, len(line))]))
+	return b.String()
+}
+
+// scope describes where in the DOT grammar a position is.
+type scope struct {
+	kind     string // "node", "edge", "graph", or "" outside an attribute block
+	inString bool
+	// attr and value are the attribute being assigned and the text typed so
+	// far when the position is in a value; attr is empty otherwise.
+	attr  string
+	value string
+}
+
+// scopeAt scans the document up to pos, tracking strings and attribute
+// blocks, to classify the position.
+func (d *document) scopeAt(pos Position) scope {
+	text := d.prefix(pos)
+	var sc scope
+	blockStart := -1
+	stmtStart := 0
+	escaped := false
+	for i, r := range text {
+		switch {
+		case sc.inString:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == '"' {
+				sc.inString = false
+			}
+		case r == '"':
+			sc.inString = true
+		case r == '[':
+			blockStart = i
+		case r == ']':
+			blockStart = -1
+		case blockStart < 0 && (r == ';' || r == '{' || r == '}' || r == '\n'):
+			stmtStart = i + 1
+		}
+	}
+
+	if blockStart >= 0 {
+		head := strings.TrimSpace(text[stmtStart:blockStart])
+		switch {
+		case strings.Contains(head, "->"), head == "edge":
+			sc.kind = "edge"
+		case head == "graph":
+			sc.kind = "graph"
+		default:
+			sc.kind = "node"
+		}
+	}
+
+	// Find the attribute being assigned: the text after the last separator
+	// outside a string, e.g. `shape=bo` or `condition="outcome=su`.
+	assignStart := max(stmtStart, blockStart+1)
+	tail := text[assignStart:]
+	if idx := lastSeparator(tail); idx >= 0 {
+		tail = tail[idx+1:]
+	}
+	if eq := strings.Index(tail, "="); eq >= 0 {
+		sc.attr = strings.TrimSpace(tail[:eq])
+		sc.value = strings.TrimLeft(tail[eq+1:], " \t\"")
+		if blockStart < 0 && sc.kind == "" {
+			sc.kind = "graph" // top-level key = value
+		}
+	}
+	return sc
+}
+
+// lastSeparator returns the index of the last ',' or whitespace-separated
+// boundary outside a string in an attribute list, or -1.
+func lastSeparator(s string) int {
+	last := -1
+	inString, escaped := false, false
+	for i, r := range s {
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == '"' {
+				inString = false
+			}
+		case r == '"':
+			inString = true
+		case r == ',' || r == ';':
+			last = i
+		case r == ' ' || r == '\t' || r == '\n':
+			// Whitespace separates attributes unless it surrounds '='.
+			rest := strings.TrimLeft(s[i:], " \t\n")
+			before := strings.TrimRight(s[:i], " \t\n")
+			if !strings.HasPrefix(rest, "=") && !strings.HasSuffix(before, "=") {
+				last = i
+			}
+		}
+	}
+	return last
+}
+
+// hover documents the attribute, value, or node under pos.
+func (d *document) hover(pos Position) *Hover {
+	word, r := d.wordAt(pos)
+	if word == "" {
+		return nil
+	}
+	sc := d.scopeAt(Position{Line: pos.Line, Character: r.End.Character})
+
+	var text string
+	switch {
+	case sc.attr == "condition" && sc.inString:
+		if dc, ok := lookupDoc(conditionKeyDocs, word); ok {
+			text = dc.Detail
+		} else if strings.HasPrefix(word, "context.") {
+			text = "Context key `" + strings.TrimPrefix(word, "context.") + "`. " + conditionKeyDocs[2].Detail
+		} else if dc, ok := lookupDoc(outcomeDocs, word); ok {
+			text = "Outcome `" + word + "`: " + dc.Detail
+		}
+	case sc.attr == "shape" && sc.value == word:
+		if dc, ok := lookupDoc(shapeDocs, word); ok {
+			text = "Shape `" + word + "` → " + dc.Detail
+		}
+	case sc.attr == "type" && sc.value == word:
+		if dc, ok := lookupDoc(typeDocs, word); ok {
+			text = "Handler `" + word + "`: " + dc.Detail
+		} else if strings.HasPrefix(word, "exec:") {
+			dc, _ := lookupDoc(typeDocs, "exec:")
+			text = "Handler `" + word + "`: " + dc.Detail
+		}
+	case sc.attr == "" && d.followedByEquals(pos.Line, r.End.Character):
+		if dc, ok := lookupDoc(attrDocs(sc.kind), word); ok {
+			text = "`" + word + "`: " + dc.Detail
+		} else if dc, ok := lookupDoc(attrDocs(sc.kind), "env."); ok && strings.HasPrefix(word, "env.") {
+			text = "`" + word + "`: " + dc.Detail
+		}
+	}
+	if text == "" && d.graph != nil {
+		if node, ok := d.graph.Nodes[word]; ok {
+			text = nodeSummary(node)
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// followedByEquals reports whether the next non-space character after col
+// on the line is '='.
+func (d *document) followedByEquals(line, col int) bool {
+	rest := []rune(d.lines[line])
+	if col > len(rest) {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimLeft(string(rest[col:]), " \t"), "=")
+}
+
+func attrDocs(kind string) []doc {
+	switch kind {
+	case "edge":
+		return edgeAttrDocs
+	case "graph":
+		return graphAttrDocs
+	default:
+		return nodeAttrDocs
+	}
+}
+
+func nodeSummary(node *pipeline.Node) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** (shape `%s`", node.ID, node.Shape)
+	if node.Type != "" {
+		fmt.Fprintf(&b, ", type `%s`", node.Type)
+	}
+	b.WriteString(")")
+	if node.Label != "" && node.Label != node.ID {
+		fmt.Fprintf(&b, "\n\n%s", node.Label)
+	}
+	if node.Comment != "" {
+		fmt.Fprintf(&b, "\n\n%s", node.Comment)
+	}
+	if node.Prompt != "" {
+		prompt := node.Prompt
+		if len(prompt) > 300 {
+			prompt = prompt[:300] + "…"
+		}
+		fmt.Fprintf(&b, "\n\n> %s", strings.ReplaceAll(prompt, "\n", "\n> "))
+	}
+	return b.String()
+}
+
+// complete returns candidates for the position: values for shape, type,
+// condition, and retry targets; attribute names inside attribute blocks;
+// node IDs elsewhere.
+func (d *document) complete(pos Position) []CompletionItem {
+	sc := d.scopeAt(pos)
+	if sc.attr != "" {
+		switch sc.attr {
+		case "shape":
+			return docItems(shapeDocs, CompletionKindEnumMember)
+		case "type":
+			return docItems(typeDocs, CompletionKindEnumMember)
+		case "fidelity", "default_fidelity":
+			return valueItems([]string{"full", "truncate", "compact", "summary:low", "summary:medium", "summary:high"})
+		case "human.type":
+			return valueItems([]string{"choice", "freeform", "confirm", "form"})
+		case "goal_gate", "auto_status", "allow_partial", "loop_restart", "cache":
+			return valueItems([]string{"true", "false"})
+		case "retry_target", "fallback_retry_target":
+			return d.nodeItems()
+		case "condition":
+			if sc.inString {
+				return d.conditionItems(sc.value)
+			}
+		}
+		return []CompletionItem{} // a free-form value
+	}
+	if sc.kind != "" && !sc.inString {
+		return docItems(attrDocs(sc.kind), CompletionKindProperty)
+	}
+	if sc.inString {
+		return []CompletionItem{}
+	}
+	return d.nodeItems()
+}
+
+// conditionItems completes the clause being typed in a condition value.
+func (d *document) conditionItems(value string) []CompletionItem {
+	clause := value
+	if idx := strings.LastIndex(value, "&&"); idx >= 0 {
+		clause = value[idx+2:]
+	}
+	clause = strings.TrimSpace(clause)
+	if eq := strings.Index(clause, "="); eq >= 0 {
+		key := strings.TrimSuffix(strings.TrimSpace(clause[:eq]), "!")
+		if key == "outcome" {
+			return docItems(outcomeDocs, CompletionKindEnumMember)
+		}
+		return []CompletionItem{}
+	}
+
+	items := docItems(conditionKeyDocs, CompletionKindProperty)
+	for _, key := range d.contextKeys() {
+		items = append(items, CompletionItem{Label: key, Kind: CompletionKindProperty, Detail: "context key used in this pipeline"})
+	}
+	return items
+}
+
+// contextKeys returns the context.* keys referenced by the graph's
+// conditions, sorted.
+func (d *document) contextKeys() []string {
+	if d.graph == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, e := range d.graph.Edges {
+		for _, clause := range strings.Split(e.Condition, "&&") {
+			key := strings.TrimSpace(clause)
+			if idx := strings.IndexAny(key, "!="); idx >= 0 {
+				key = strings.TrimSpace(key[:idx])
+			}
+			if strings.HasPrefix(key, "context.") && len(key) > len("context.") {
+				seen[key] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *document) nodeItems() []CompletionItem {
+	if d.graph == nil {
+		return []CompletionItem{}
+	}
+	ids := make([]string, 0, len(d.graph.Nodes))
+	for id := range d.graph.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	items := make([]CompletionItem, 0, len(ids))
+	for _, id := range ids {
+		node := d.graph.Nodes[id]
+		items = append(items, CompletionItem{
+			Label:         id,
+			Kind:          CompletionKindReference,
+			Detail:        node.Label,
+			Documentation: &MarkupContent{Kind: "markdown", Value: nodeSummary(node)},
+		})
+	}
+	return items
+}
+
+func docItems(docs []doc, kind int) []CompletionItem {
+	items := make([]CompletionItem, len(docs))
+	for i, dc := range docs {
+		items[i] = CompletionItem{Label: dc.Name, Kind: kind, Documentation: &MarkupContent{Kind: "markdown", Value: dc.Detail}}
+	}
+	return items
+}
+
+func valueItems(values []string) []CompletionItem {
+	items := make([]CompletionItem, len(values))
+	for i, v := range values {
+		items[i] = CompletionItem{Label: v, Kind: CompletionKindValue}
+	}
+	return items
+}
+
+// definition locates the statement that defines the node under pos.
+func (d *document) definition(uri string, pos Position) *Location {
+	if d.graph == nil {
+		return nil
+	}
+	word, _ := d.wordAt(pos)
+	node, ok := d.graph.Nodes[word]
+	if !ok || !node.Pos.IsValid() {
+		return nil
+	}
+	return &Location{URI: uri, Range: d.wordRange(node.Pos)}
+}
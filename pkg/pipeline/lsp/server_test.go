@@ -0,0 +1,279 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///tmp/pipeline.dot"
+
+const testSource = `digraph P {
+	start [shape=Mdiamond]
+	exit [shape=Msquare]
+	work [label="Work", prompt="Do the work", shape=box] // The main stage.
+	start -> work
+	work -> exit [condition="outcome=success"]
+	work -> orphan_target [condition="context.retry=true"]
+}`
+
+// session runs a server over the given client messages and returns every
+// message it wrote, decoded.
+func session(t *testing.T, msgs ...map[string]any) []map[string]any {
+	t.Helper()
+	var in bytes.Buffer
+	for _, m := range msgs {
+		m["jsonrpc"] = "2.0"
+		if err := writeMessage(&in, m); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var replies []map[string]any
+	r := bufio.NewReader(&out)
+	for {
+		msg, err := readRaw(r)
+		if err != nil {
+			break
+		}
+		replies = append(replies, msg)
+	}
+	return replies
+}
+
+// readRaw reads one framed message as a generic map.
+func readRaw(r *bufio.Reader) (map[string]any, error) {
+	var length int
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			json.Unmarshal([]byte(v), &length)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var msg map[string]any
+	return msg, json.Unmarshal(body, &msg)
+}
+
+func openDoc(text string) map[string]any {
+	return map[string]any{
+		"method": "textDocument/didOpen",
+		"params": map[string]any{"textDocument": map[string]any{"uri": testURI, "text": text}},
+	}
+}
+
+func positionRequest(id int, method string, line, char int) map[string]any {
+	return map[string]any{
+		"id":     id,
+		"method": method,
+		"params": map[string]any{
+			"textDocument": map[string]any{"uri": testURI},
+			"position":     map[string]any{"line": line, "character": char},
+		},
+	}
+}
+
+// reply returns the response to request id.
+func reply(t *testing.T, msgs []map[string]any, id int) map[string]any {
+	t.Helper()
+	for _, m := range msgs {
+		if v, ok := m["id"].(float64); ok && int(v) == id {
+			return m
+		}
+	}
+	t.Fatalf("no reply to request %d in %v", id, msgs)
+	return nil
+}
+
+func completionLabels(t *testing.T, resp map[string]any) []string {
+	t.Helper()
+	items, ok := resp["result"].([]any)
+	if !ok {
+		t.Fatalf("expected completion list, got %v", resp["result"])
+	}
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.(map[string]any)["label"].(string))
+	}
+	return labels
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInitializeAndShutdown(t *testing.T) {
+	msgs := session(t,
+		map[string]any{"id": 1, "method": "initialize", "params": map[string]any{}},
+		map[string]any{"method": "initialized", "params": map[string]any{}},
+		map[string]any{"id": 2, "method": "shutdown"},
+		map[string]any{"method": "exit"},
+	)
+	caps := reply(t, msgs, 1)["result"].(map[string]any)["capabilities"].(map[string]any)
+	for _, c := range []string{"hoverProvider", "completionProvider", "definitionProvider"} {
+		if caps[c] == nil {
+			t.Errorf("expected capability %s", c)
+		}
+	}
+	if resp := reply(t, msgs, 2); resp["error"] != nil {
+		t.Errorf("expected shutdown to succeed, got %v", resp["error"])
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	msgs := session(t, map[string]any{"id": 1, "method": "workspace/unknown"})
+	if reply(t, msgs, 1)["error"] == nil {
+		t.Error("expected method not found error")
+	}
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	msgs := session(t, openDoc(testSource))
+	if len(msgs) != 1 || msgs[0]["method"] != "textDocument/publishDiagnostics" {
+		t.Fatalf("expected one publishDiagnostics, got %v", msgs)
+	}
+	diags := msgs[0]["params"].(map[string]any)["diagnostics"].([]any)
+	found := false
+	for _, d := range diags {
+		diag := d.(map[string]any)
+		if diag["code"] == "terminal_reachable" {
+			found = true
+			start := diag["range"].(map[string]any)["start"].(map[string]any)
+			// orphan_target is first referenced on line 7 (0-based 6), column 10.
+			if start["line"] != float64(6) || start["character"] != float64(9) {
+				t.Errorf("expected range start 6:9, got %v", start)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected terminal_reachable diagnostic, got %v", diags)
+	}
+}
+
+func TestPublishStrictWarnings(t *testing.T) {
+	msgs := session(t, openDoc(strings.Replace(testSource, "prompt=", "promt=", 1)))
+	diags := msgs[0]["params"].(map[string]any)["diagnostics"].([]any)
+	for _, d := range diags {
+		diag := d.(map[string]any)
+		if diag["code"] == "unknown_attribute" {
+			if !strings.Contains(diag["message"].(string), `Did you mean "prompt"?`) {
+				t.Errorf("expected a suggestion of prompt, got %q", diag["message"])
+			}
+			return
+		}
+	}
+	t.Errorf("expected an unknown_attribute diagnostic, got %v", diags)
+}
+
+func TestPublishParseError(t *testing.T) {
+	msgs := session(t, openDoc("digraph P {\n\ta -> b;\n\t= x\n}"))
+	diags := msgs[0]["params"].(map[string]any)["diagnostics"].([]any)
+	if len(diags) != 1 {
+		t.Fatalf("expected one parse diagnostic, got %v", diags)
+	}
+	start := diags[0].(map[string]any)["range"].(map[string]any)["start"].(map[string]any)
+	if start["line"] != float64(2) || start["character"] != float64(1) {
+		t.Errorf("expected parse error at 2:1, got %v", start)
+	}
+}
+
+func TestHover(t *testing.T) {
+	msgs := session(t, openDoc(testSource),
+		positionRequest(1, "textDocument/hover", 3, 22), // "prompt" attribute name
+		positionRequest(2, "textDocument/hover", 1, 15), // "Mdiamond" shape value
+		positionRequest(3, "textDocument/hover", 5, 27), // "outcome" in condition
+		positionRequest(4, "textDocument/hover", 4, 12), // "work" node reference
+		positionRequest(5, "textDocument/hover", 4, 12), // and its comment
+	)
+	want := map[int]string{
+		1: "Instructions for the LLM stage",
+		2: "start: pipeline entry point",
+		3: "Status of the previous stage",
+		4: "Do the work",
+		5: "The main stage.",
+	}
+	for id, text := range want {
+		result, ok := reply(t, msgs, id)["result"].(map[string]any)
+		if !ok {
+			t.Errorf("hover %d: expected result", id)
+			continue
+		}
+		value := result["contents"].(map[string]any)["value"].(string)
+		if !strings.Contains(value, text) {
+			t.Errorf("hover %d: expected %q in %q", id, text, value)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		line string // typed on a new line before the closing brace
+		want string
+	}{
+		{`a [shape=`, "Msquare"},
+		{`a [type="`, "wait.human"},
+		{`a -> b [condition="outcome=`, "partial_success"},
+		{`a -> b [condition="outcome=success && `, "context.retry"}, // from the last graph that parsed
+		{`a [label="A", `, "prompt"},
+		{`a -> b [`, "condition"},
+		{`a -> `, "work"},
+	}
+	lines := strings.Split(testSource, "\n")
+	for _, tt := range tests {
+		edited := strings.Join(lines[:len(lines)-1], "\n") + "\n\t" + tt.line + "\n}"
+		msgs := session(t, openDoc(testSource),
+			map[string]any{
+				"method": "textDocument/didChange",
+				"params": map[string]any{
+					"textDocument":   map[string]any{"uri": testURI},
+					"contentChanges": []any{map[string]any{"text": edited}},
+				},
+			},
+			positionRequest(1, "textDocument/completion", len(lines)-1, len(tt.line)+1),
+		)
+		labels := completionLabels(t, reply(t, msgs, 1))
+		if !contains(labels, tt.want) {
+			t.Errorf("completion after %q: expected %q in %v", tt.line, tt.want, labels)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	msgs := session(t, openDoc(testSource),
+		positionRequest(1, "textDocument/definition", 5, 2),  // "work" in "work -> exit"
+		positionRequest(2, "textDocument/definition", 3, 22), // "prompt" is not a node
+	)
+	loc, ok := reply(t, msgs, 1)["result"].(map[string]any)
+	if !ok {
+		t.Fatal("expected a definition location")
+	}
+	start := loc["range"].(map[string]any)["start"].(map[string]any)
+	if loc["uri"] != testURI || start["line"] != float64(3) || start["character"] != float64(1) {
+		t.Errorf("expected work defined at 3:1, got %v", loc)
+	}
+	if result := reply(t, msgs, 2)["result"]; result != nil {
+		t.Errorf("expected no definition, got %v", result)
+	}
+}
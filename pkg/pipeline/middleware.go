@@ -0,0 +1,31 @@
+package pipeline
+
+// HandlerFunc runs a stage: the resolved handler, or the rest of a
+// middleware chain.
+type HandlerFunc func(node *Node, ctx *Context) (*Outcome, error)
+
+// Middleware wraps every handler invocation, including each retry attempt.
+// It can observe the node and context before calling next, inspect or
+// replace the outcome after, or return without calling next to skip the
+// handler, for example to enforce a policy. Middleware should not modify
+// node, which is shared with the graph.
+type Middleware func(node *Node, ctx *Context, next HandlerFunc) (*Outcome, error)
+
+// Use adds middleware to the engine. The first middleware added is the
+// outermost: it runs first and sees the final outcome. Use must not be
+// called while a run is in progress.
+func (e *Engine) Use(mw ...Middleware) {
+	n := len(e.config.Middleware)
+	e.config.Middleware = append(e.config.Middleware[:n:n], mw...)
+}
+
+// chain wraps h in the engine's middleware.
+func (e *Engine) chain(h HandlerFunc) HandlerFunc {
+	for i := len(e.config.Middleware) - 1; i >= 0; i-- {
+		mw, next := e.config.Middleware[i], h
+		h = func(node *Node, ctx *Context) (*Outcome, error) {
+			return mw(node, ctx, next)
+		}
+	}
+	return h
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func branchGraph() *Graph {
+	return &Graph{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start":   {ID: "start", Shape: "Mdiamond", Label: "Start", Attrs: map[string]string{}},
+			"a":       {ID: "a", Shape: "box", Label: "A", Attrs: map[string]string{}},
+			"success": {ID: "success", Shape: "box", Label: "Success", Attrs: map[string]string{}},
+			"fail":    {ID: "fail", Shape: "box", Label: "Fail", Attrs: map[string]string{}},
+			"exit":    {ID: "exit", Shape: "Msquare", Label: "Exit", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "a"},
+			{From: "a", To: "success", Condition: "outcome=success"},
+			{From: "a", To: "fail", Condition: "outcome=fail"},
+			{From: "success", To: "exit"},
+			{From: "fail", To: "exit"},
+		},
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(node *Node, ctx *Context, next HandlerFunc) (*Outcome, error) {
+			calls = append(calls, name+">"+node.ID)
+			outcome, err := next(node, ctx)
+			calls = append(calls, name+"<"+node.ID)
+			return outcome, err
+		}
+	}
+
+	engine := NewEngine(EngineConfig{LogsRoot: t.TempDir(), Middleware: []Middleware{trace("outer")}}, &staticResolver{handler: &simpleHandler{}}, nil)
+	engine.Use(trace("inner"))
+	if _, err := engine.Run(makeSimpleGraph()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	got := strings.Join(calls, " ")
+	if !strings.Contains(got, "outer>a inner>a inner<a outer<a") {
+		t.Errorf("expected outer to wrap inner for node a, got %s", got)
+	}
+}
+
+func TestMiddlewareMutatesOutcome(t *testing.T) {
+	failA := func(node *Node, ctx *Context, next HandlerFunc) (*Outcome, error) {
+		outcome, err := next(node, ctx)
+		if err == nil && node.ID == "a" {
+			outcome.Status = StatusFail
+		}
+		return outcome, err
+	}
+	engine := NewEngine(EngineConfig{LogsRoot: t.TempDir()}, &staticResolver{handler: &simpleHandler{}}, nil)
+	engine.Use(failA)
+
+	result, err := engine.Run(branchGraph())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, ok := result.NodeOutcomes["fail"]; !ok {
+		t.Errorf("expected routing on the rewritten outcome, got %v", result.CompletedNodes)
+	}
+}
+
+func TestMiddlewareCanSkipHandler(t *testing.T) {
+	spy := &retryHandler{}
+	deny := func(node *Node, ctx *Context, next HandlerFunc) (*Outcome, error) {
+		if node.ID == "a" {
+			return &Outcome{Status: StatusFail, FailureReason: "denied by policy"}, nil
+		}
+		return next(node, ctx)
+	}
+	resolver := &staticResolver{handler: &simpleHandler{}, special: map[string]Handler{"a": spy}}
+	engine := NewEngine(EngineConfig{LogsRoot: t.TempDir()}, resolver, nil)
+	engine.Use(deny)
+
+	result, err := engine.Run(branchGraph())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if spy.attempts != 0 {
+		t.Errorf("expected the handler for a not to run, ran %d times", spy.attempts)
+	}
+	if outcome := result.NodeOutcomes["a"]; outcome == nil || outcome.FailureReason != "denied by policy" {
+		t.Errorf("expected the middleware's outcome for a, got %+v", outcome)
+	}
+}
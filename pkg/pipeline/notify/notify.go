@@ -0,0 +1,399 @@
+// Package notify delivers pipeline notifications to Slack webhooks and email.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+)
+
+// Notifier sends a single notification.
+type Notifier interface {
+	Notify(subject, message string) error
+}
+
+// --- Slack ---
+
+// SlackNotifier posts messages to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	HTTPClient *http.Client
+}
+
+func (n *SlackNotifier) Notify(subject, message string) error {
+	text := message
+	if subject != "" {
+		text = "*" + subject + "*\n" + message
+	}
+	body, _ := json.Marshal(map[string]string{"text": text})
+
+	client := n.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(n.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("slack webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// --- Email ---
+
+// EmailNotifier sends plain-text email through an SMTP server.
+type EmailNotifier struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (n *EmailNotifier) Notify(subject, message string) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		host := n.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(message)
+
+	if err := smtp.SendMail(n.Addr, auth, n.From, n.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return nil
+}
+
+// --- Configuration ---
+
+// Config selects notification channels. It can be loaded from the environment
+// and overridden by graph attributes.
+type Config struct {
+	SlackWebhookURL string
+	SMTPAddr        string
+	SMTPUsername    string
+	SMTPPassword    string
+	EmailFrom       string
+	EmailTo         []string
+	// On lists the event types forwarded by a Sink. Empty means DefaultEvents.
+	On []events.EventType
+	// attrWebhook is set when SlackWebhookURL came from an attribute.
+	attrWebhook bool
+}
+
+// DefaultEvents are the events a Sink forwards when Config.On is empty:
+// pipeline completion and failure, stage failures, and human gates.
+var DefaultEvents = []events.EventType{
+	events.EventPipelineCompleted,
+	events.EventPipelineFailed,
+	events.EventStageFailed,
+	events.EventInterviewStarted,
+}
+
+// ConfigFromEnv reads notification settings from ATTRACTOR_SLACK_WEBHOOK_URL,
+// ATTRACTOR_SMTP_ADDR, ATTRACTOR_SMTP_USERNAME, ATTRACTOR_SMTP_PASSWORD,
+// ATTRACTOR_NOTIFY_FROM, ATTRACTOR_NOTIFY_TO, and ATTRACTOR_NOTIFY_ON.
+func ConfigFromEnv() Config {
+	return Config{
+		SlackWebhookURL: os.Getenv("ATTRACTOR_SLACK_WEBHOOK_URL"),
+		SMTPAddr:        os.Getenv("ATTRACTOR_SMTP_ADDR"),
+		SMTPUsername:    os.Getenv("ATTRACTOR_SMTP_USERNAME"),
+		SMTPPassword:    os.Getenv("ATTRACTOR_SMTP_PASSWORD"),
+		EmailFrom:       os.Getenv("ATTRACTOR_NOTIFY_FROM"),
+		EmailTo:         splitList(os.Getenv("ATTRACTOR_NOTIFY_TO")),
+		On:              eventTypes(os.Getenv("ATTRACTOR_NOTIFY_ON")),
+	}
+}
+
+// WithAttrs returns a copy of c overridden by notify_* attributes: notify_slack_webhook,
+// notify_smtp_addr, notify_email_from, notify_email_to, and notify_on.
+//
+// Attributes come from pipeline files, which API clients can submit, so they
+// can't reach what only the environment should: SMTP credentials are only read
+// from the environment and are dropped when notify_smtp_addr names a different
+// server, and a notify_slack_webhook is posted to through a client that
+// refuses internal addresses (see pipeline.NewPublicHTTPClient).
+func (c Config) WithAttrs(attrs map[string]string) Config {
+	if v := attrs["notify_slack_webhook"]; v != "" && v != c.SlackWebhookURL {
+		c.SlackWebhookURL = v
+		c.attrWebhook = true
+	}
+	if v := attrs["notify_smtp_addr"]; v != "" && v != c.SMTPAddr {
+		c.SMTPAddr = v
+		c.SMTPUsername = ""
+		c.SMTPPassword = ""
+	}
+	if v := attrs["notify_email_from"]; v != "" {
+		c.EmailFrom = v
+	}
+	if v := attrs["notify_email_to"]; v != "" {
+		c.EmailTo = splitList(v)
+	}
+	if v := attrs["notify_on"]; v != "" {
+		c.On = eventTypes(v)
+	}
+	return c
+}
+
+// Notifiers builds a notifier for each configured channel.
+func (c Config) Notifiers() []Notifier {
+	var notifiers []Notifier
+	if c.SlackWebhookURL != "" {
+		slack := &SlackNotifier{WebhookURL: c.SlackWebhookURL}
+		if c.attrWebhook {
+			slack.HTTPClient = pipeline.NewPublicHTTPClient()
+		}
+		notifiers = append(notifiers, slack)
+	}
+	if c.SMTPAddr != "" && c.EmailFrom != "" && len(c.EmailTo) > 0 {
+		notifiers = append(notifiers, &EmailNotifier{
+			Addr:     c.SMTPAddr,
+			From:     c.EmailFrom,
+			To:       c.EmailTo,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+		})
+	}
+	return notifiers
+}
+
+// Send delivers a notification to every notifier and returns the combined errors.
+func Send(notifiers []Notifier, subject, message string) error {
+	var errs []string
+	for _, n := range notifiers {
+		if err := n.Notify(subject, message); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notify: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// SendTimeout is Send, giving up after timeout. A notifier that outlives the
+// timeout finishes in the background and its result is discarded.
+func SendTimeout(notifiers []Notifier, subject, message string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- Send(notifiers, subject, message) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("notify: %q timed out after %s", subject, timeout)
+	}
+}
+
+// --- Event sink ---
+
+// DefaultSinkTimeout bounds a single delivery when Sink.Timeout is zero.
+const DefaultSinkTimeout = 30 * time.Second
+
+// sinkQueueSize is how many notifications a Sink buffers before it starts
+// dropping them.
+const sinkQueueSize = 64
+
+// Sink forwards selected engine events to notifiers. Register Handle as an
+// event listener; registering the Sink as a graph transform as well lets
+// notify_* graph attributes override its configuration for that run.
+//
+// Handle only queues a notification; a background goroutine delivers them in
+// order, so a slow webhook or mail server never stalls the engine. Call Flush
+// before exiting to wait for queued notifications.
+type Sink struct {
+	mu        sync.Mutex
+	base      Config
+	config    Config
+	notifiers []Notifier
+	pipeline  string
+	start     sync.Once
+	queue     chan delivery
+	// OnError is called when a notification fails, times out, or is dropped
+	// because the queue is full. Defaults to ignoring errors.
+	OnError func(error)
+	// Timeout bounds each delivery. Defaults to DefaultSinkTimeout.
+	Timeout time.Duration
+}
+
+// delivery is a queued notification, or with flushed set, a marker that
+// Flush waits on.
+type delivery struct {
+	notifiers []Notifier
+	subject   string
+	message   string
+	timeout   time.Duration
+	onError   func(error)
+	flushed   chan struct{}
+}
+
+// NewSink creates a sink with the given base configuration.
+func NewSink(config Config) *Sink {
+	return &Sink{base: config, config: config, notifiers: config.Notifiers()}
+}
+
+// Apply implements the transform interface, picking up graph-level notify_* attributes.
+func (s *Sink) Apply(graph *pipeline.Graph) *pipeline.Graph {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = s.base.WithAttrs(graph.Attrs)
+	s.notifiers = s.config.Notifiers()
+	s.pipeline = graph.Name
+	return graph
+}
+
+// Enabled reports whether any notification channel is configured.
+func (s *Sink) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.notifiers) > 0
+}
+
+// Handle is an events listener that queues a notification for selected event
+// types. It does not wait for delivery.
+func (s *Sink) Handle(event events.Event) {
+	s.mu.Lock()
+	if event.Type == events.EventPipelineStarted {
+		if name, ok := event.Data["name"].(string); ok {
+			s.pipeline = name
+		}
+	}
+	notifiers := s.notifiers
+	selected := s.config.On
+	name := s.pipeline
+	onError := s.OnError
+	timeout := s.Timeout
+	s.mu.Unlock()
+
+	if len(notifiers) == 0 || !containsType(selected, event.Type) {
+		return
+	}
+	if timeout <= 0 {
+		timeout = DefaultSinkTimeout
+	}
+	subject, message := FormatEvent(name, event)
+	select {
+	case s.deliveries() <- delivery{notifiers: notifiers, subject: subject, message: message, timeout: timeout, onError: onError}:
+	default:
+		if onError != nil {
+			onError(fmt.Errorf("notify: queue full, dropped %q", subject))
+		}
+	}
+}
+
+// Flush waits until every notification queued before the call has been
+// delivered or has timed out.
+func (s *Sink) Flush() {
+	flushed := make(chan struct{})
+	s.deliveries() <- delivery{flushed: flushed}
+	<-flushed
+}
+
+// deliveries returns the sink's queue, starting its delivery goroutine on
+// first use.
+func (s *Sink) deliveries() chan delivery {
+	s.start.Do(func() {
+		s.queue = make(chan delivery, sinkQueueSize)
+		go func() {
+			for d := range s.queue {
+				if d.flushed != nil {
+					close(d.flushed)
+					continue
+				}
+				d.send()
+			}
+		}()
+	})
+	return s.queue
+}
+
+// send delivers d, giving up after d.timeout.
+func (d delivery) send() {
+	if err := SendTimeout(d.notifiers, d.subject, d.message, d.timeout); err != nil && d.onError != nil {
+		d.onError(err)
+	}
+}
+
+// FormatEvent renders an event as a notification subject and body.
+func FormatEvent(pipelineName string, event events.Event) (string, string) {
+	if pipelineName == "" {
+		pipelineName = "pipeline"
+	}
+
+	var subject string
+	switch event.Type {
+	case events.EventPipelineCompleted:
+		subject = fmt.Sprintf("[attractor] %s completed", pipelineName)
+	case events.EventPipelineFailed:
+		subject = fmt.Sprintf("[attractor] %s failed", pipelineName)
+	case events.EventStageFailed:
+		subject = fmt.Sprintf("[attractor] %s: stage %v failed", pipelineName, event.Data["name"])
+	case events.EventInterviewStarted:
+		subject = fmt.Sprintf("[attractor] %s is waiting for input", pipelineName)
+	default:
+		subject = fmt.Sprintf("[attractor] %s: %s", pipelineName, event.Type)
+	}
+
+	keys := make([]string, 0, len(event.Data))
+	for k := range event.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var body strings.Builder
+	fmt.Fprintf(&body, "event: %s\ntime: %s\n", event.Type, event.Timestamp.Format(time.RFC3339))
+	for _, k := range keys {
+		fmt.Fprintf(&body, "%s: %v\n", k, event.Data[k])
+	}
+	return subject, body.String()
+}
+
+func containsType(types []events.EventType, t events.EventType) bool {
+	if len(types) == 0 {
+		types = DefaultEvents
+	}
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
+
+func eventTypes(s string) []events.EventType {
+	var types []events.EventType
+	for _, v := range splitList(s) {
+		types = append(types, events.EventType(v))
+	}
+	return types
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline"
+	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+)
+
+// recordingNotifier captures notifications in memory.
+type recordingNotifier struct {
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (n *recordingNotifier) Notify(subject, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subjects = append(n.subjects, subject)
+	return nil
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	n := &SlackNotifier{WebhookURL: srv.URL}
+	if err := n.Notify("Deploy", "all green"); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if !strings.Contains(got["text"], "Deploy") || !strings.Contains(got["text"], "all green") {
+		t.Errorf("expected subject and message in text, got %q", got["text"])
+	}
+}
+
+func TestSlackNotifierErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	n := &SlackNotifier{WebhookURL: srv.URL}
+	if err := n.Notify("s", "m"); err == nil {
+		t.Fatal("expected error for 403 response")
+	}
+}
+
+func TestConfigWithAttrs(t *testing.T) {
+	base := Config{SlackWebhookURL: "https://env.example", SMTPPassword: "secret"}
+	c := base.WithAttrs(map[string]string{
+		"notify_slack_webhook": "https://graph.example",
+		"notify_smtp_addr":     "smtp.example:587",
+		"notify_email_from":    "bot@example.com",
+		"notify_email_to":      "a@example.com, b@example.com",
+		"notify_on":            "pipeline_failed",
+	})
+
+	if c.SlackWebhookURL != "https://graph.example" {
+		t.Errorf("expected graph webhook to override env, got %q", c.SlackWebhookURL)
+	}
+	if len(c.EmailTo) != 2 || c.EmailTo[1] != "b@example.com" {
+		t.Errorf("expected 2 recipients, got %v", c.EmailTo)
+	}
+	if c.SMTPPassword != "" {
+		t.Error("expected env SMTP credentials to be dropped for a server named by an attribute")
+	}
+	if len(c.On) != 1 || c.On[0] != events.EventPipelineFailed {
+		t.Errorf("expected notify_on to select pipeline_failed, got %v", c.On)
+	}
+	if n := len(c.Notifiers()); n != 2 {
+		t.Errorf("expected slack and email notifiers, got %d", n)
+	}
+}
+
+func TestConfigWithAttrsKeepsCredentialsForEnvServer(t *testing.T) {
+	base := Config{SMTPAddr: "smtp.example:587", SMTPUsername: "bot", SMTPPassword: "secret"}
+	c := base.WithAttrs(map[string]string{
+		"notify_smtp_addr":  "smtp.example:587",
+		"notify_email_from": "bot@example.com",
+	})
+	if c.SMTPUsername != "bot" || c.SMTPPassword != "secret" {
+		t.Errorf("expected credentials kept for the env server, got %q/%q", c.SMTPUsername, c.SMTPPassword)
+	}
+}
+
+func TestAttrWebhookRefusesInternalAddresses(t *testing.T) {
+	posted := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer srv.Close()
+
+	// The server's own webhook may be internal.
+	env := Config{SlackWebhookURL: srv.URL}
+	if err := Send(env.Notifiers(), "s", "m"); err != nil {
+		t.Fatalf("env webhook: %v", err)
+	}
+	// One from a pipeline file may not.
+	attr := Config{}.WithAttrs(map[string]string{"notify_slack_webhook": srv.URL})
+	if err := Send(attr.Notifiers(), "s", "m"); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("expected the attribute webhook to be refused, got %v", err)
+	}
+	if posted != 1 {
+		t.Errorf("expected 1 delivery, got %d", posted)
+	}
+}
+
+func TestSinkFiltersEvents(t *testing.T) {
+	rec := &recordingNotifier{}
+	sink := &Sink{notifiers: []Notifier{rec}}
+
+	emitter := events.NewEmitter()
+	emitter.On(sink.Handle)
+	emitter.EmitPipelineStarted("build", "run-1")
+	emitter.EmitStageCompleted("compile", 0, 0)
+	emitter.EmitStageFailed("test", 1, "exit 1", false)
+	emitter.EmitPipelineFailed("stage failed", 0)
+	sink.Flush()
+
+	if len(rec.subjects) != 2 {
+		t.Fatalf("expected 2 notifications, got %d: %v", len(rec.subjects), rec.subjects)
+	}
+	if !strings.Contains(rec.subjects[0], "build: stage test failed") {
+		t.Errorf("unexpected subject %q", rec.subjects[0])
+	}
+}
+
+func TestSinkAppliesGraphAttrs(t *testing.T) {
+	sink := NewSink(Config{})
+	if sink.Enabled() {
+		t.Fatal("expected sink with empty config to be disabled")
+	}
+	sink.Apply(&pipeline.Graph{
+		Name:  "nightly",
+		Attrs: map[string]string{"notify_slack_webhook": "https://hooks.example"},
+	})
+	if !sink.Enabled() {
+		t.Error("expected graph attributes to enable the sink")
+	}
+}
+
+// blockingNotifier never returns until release is closed.
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(subject, message string) error {
+	<-n.release
+	return nil
+}
+
+func TestSinkDeliversInBackground(t *testing.T) {
+	slow := &blockingNotifier{release: make(chan struct{})}
+	defer close(slow.release)
+	var mu sync.Mutex
+	var errs []error
+	sink := &Sink{notifiers: []Notifier{slow}, Timeout: 200 * time.Millisecond}
+	sink.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+
+	start := time.Now()
+	sink.Handle(events.NewEvent(events.EventPipelineFailed, nil))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected Handle to return without waiting for delivery, took %s", elapsed)
+	}
+	sink.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "timed out") {
+		t.Errorf("expected one timeout error, got %v", errs)
+	}
+}
+
+func TestSinkNotifiesOnHumanGates(t *testing.T) {
+	rec := &recordingNotifier{}
+	sink := &Sink{notifiers: []Notifier{rec}, pipeline: "release"}
+
+	emitter := events.NewEmitter()
+	emitter.On(sink.Handle)
+	emitter.EmitInterviewStarted("Approve deploy", "approve")
+	emitter.EmitInterviewCompleted("Approve deploy", "approve", time.Second)
+	sink.Flush()
+
+	if len(rec.subjects) != 1 || !strings.Contains(rec.subjects[0], "release is waiting for input") {
+		t.Errorf("expected one waiting-for-input notification, got %v", rec.subjects)
+	}
+}
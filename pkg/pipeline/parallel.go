@@ -0,0 +1,307 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+)
+
+// defaultMaxParallel bounds concurrent branches when a parallel node has no max_parallel attribute.
+const defaultMaxParallel = 4
+
+// BranchResult is the outcome of one branch of a parallel fan-out.
+type BranchResult struct {
+	Branch         string              `json:"branch"`
+	CompletedNodes []string            `json:"completed_nodes"`
+	NodeOutcomes   map[string]*Outcome `json:"-"`
+	Outcome        *Outcome            `json:"outcome"`
+	StopNode       string              `json:"stop_node,omitempty"`
+	// LogsDir is the branch's logs directory (see BranchLogsRoot), where
+	// each of its stages has a directory named after the node.
+	LogsDir string `json:"logs_dir,omitempty"`
+}
+
+func (r *BranchResult) succeeded() bool {
+	return r.Outcome != nil && (r.Outcome.Status == StatusSuccess || r.Outcome.Status == StatusPartialSuccess)
+}
+
+// BranchLogsRoot returns the logs directory of the branch of parallel node
+// parallelID that starts at branch: <logsRoot>/<parallelID>/branches/<branch>.
+// The branch's stages write their prompt, response, and status there, so
+// branches running the same kind of stage don't overwrite each other. An
+// empty logsRoot stays empty.
+func BranchLogsRoot(logsRoot, parallelID, branch string) string {
+	if logsRoot == "" {
+		return ""
+	}
+	return filepath.Join(logsRoot, parallelID, "branches", branch)
+}
+
+// stageBranch returns the branch that stages logged under logsRoot ran in:
+// logsRoot relative to the run's logs directory, or "" for stages outside a
+// parallel branch or runs without a logs directory.
+func (e *Engine) stageBranch(logsRoot string) string {
+	if e.config.LogsRoot == "" || logsRoot == "" || logsRoot == e.config.LogsRoot {
+		return ""
+	}
+	rel, err := filepath.Rel(e.config.LogsRoot, logsRoot)
+	if err != nil || !filepath.IsLocal(rel) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// StageLogDir returns the directory of a stage's logs in a run's logs
+// directory: <logsRoot>/<nodeID>, or the stage's directory in a parallel
+// branch if it ran in one. A node ID that isn't a single local path element
+// (see localNodeID) has no directory and yields "".
+func StageLogDir(logsRoot, nodeID string) string {
+	if !localNodeID(nodeID) {
+		return ""
+	}
+	dir := filepath.Join(logsRoot, nodeID)
+	if _, err := os.Stat(dir); err == nil {
+		return dir
+	}
+	// Glob only the branch directories, so metacharacters in the node ID
+	// are taken literally.
+	branches, _ := filepath.Glob(filepath.Join(logsRoot, "*", "branches", "*"))
+	for _, branch := range branches {
+		if info, err := os.Stat(filepath.Join(branch, nodeID)); err == nil && info.IsDir() {
+			return filepath.Join(branch, nodeID)
+		}
+	}
+	return dir
+}
+
+// localNodeID reports whether id can name a directory under a run's logs
+// directory: a single path element that isn't "." or "..".
+func localNodeID(id string) bool {
+	return id != "." && filepath.IsLocal(id) && !strings.ContainsAny(id, `/\`)
+}
+
+// branchContextKey is the context key under which a parallel fan-out
+// stores key as written by branch.
+func branchContextKey(branch, key string) string {
+	return "parallel." + branch + "." + key
+}
+
+func isParallel(node *Node) bool {
+	return node.Type == "parallel" || (node.Type == "" && node.Shape == "component")
+}
+
+func isFanIn(node *Node) bool {
+	return node.Type == "parallel.fan_in" || (node.Type == "" && node.Shape == "tripleoctagon")
+}
+
+// executeParallel fans out to every outgoing edge of node, running each branch
+// concurrently with its own cloned Context and logs directory under logsRoot
+// (see BranchLogsRoot). A branch walks forward until it reaches a fan-in node,
+// a terminal node, or has no further edge. Branch context writes and logs are
+// merged back into ctx in edge order once all branches finish; each branch's
+// writes are also kept under parallel.<branch>.<key>, with its final status
+// as parallel.<branch>.outcome, so a fan-in sees every branch's values.
+// It returns the parallel node's outcome, the per-branch results, and the node
+// the branches converged on ("" if none).
+func (e *Engine) executeParallel(node *Node, ctx *Context, graph *Graph, stageIndex int, logsRoot string) (*Outcome, []*BranchResult, string) {
+	edges := graph.OutgoingEdges(node.ID)
+	if len(edges) == 0 {
+		return &Outcome{
+			Status:        StatusFail,
+			FailureReason: "No branches for parallel execution",
+		}, nil, ""
+	}
+
+	maxParallel := defaultMaxParallel
+	if n, err := strconv.Atoi(node.Attrs["max_parallel"]); err == nil && n > 0 {
+		maxParallel = n
+	}
+
+	start := time.Now()
+	e.emitter.EmitParallelStarted(node.Label, len(edges))
+
+	base := ctx.Snapshot()
+	baseLogs := len(ctx.Logs())
+	branchCtxs := make([]*Context, len(edges))
+	results := make([]*BranchResult, len(edges))
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for i, edge := range edges {
+		branchCtxs[i] = ctx.Clone()
+		wg.Add(1)
+		go func(idx int, target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			branchStart := time.Now()
+			e.emitter.EmitParallelBranchStarted(target, idx)
+			branchLogs := BranchLogsRoot(logsRoot, node.ID, target)
+			results[idx] = e.runBranch(target, branchCtxs[idx], graph, stageIndex, branchLogs)
+			e.emitter.EmitParallelBranchCompleted(target, idx, time.Since(branchStart), results[idx].succeeded())
+		}(i, edge.To)
+	}
+	wg.Wait()
+
+	successCount, failCount := 0, 0
+	joinNode := ""
+	for i, r := range results {
+		changes := branchCtxs[i].ChangesSince(base)
+		ctx.ApplyUpdates(changes)
+		for k, v := range changes {
+			ctx.Set(branchContextKey(r.Branch, k), v)
+		}
+		if r.Outcome != nil {
+			ctx.Set(branchContextKey(r.Branch, "outcome"), string(r.Outcome.Status))
+		}
+		for _, entry := range branchCtxs[i].Logs()[baseLogs:] {
+			ctx.AppendLog(entry)
+		}
+		if r.succeeded() {
+			successCount++
+		} else if r.Outcome == nil || r.Outcome.Status == StatusFail {
+			failCount++
+		}
+		if joinNode == "" {
+			joinNode = r.StopNode
+		}
+	}
+
+	serialized, _ := json.Marshal(results)
+	ctx.Set("parallel.results", string(serialized))
+	e.emitter.EmitParallelCompleted(time.Since(start), successCount, failCount)
+
+	return joinOutcome(node.Attrs["join_policy"], successCount, failCount), results, joinNode
+}
+
+// runBranch executes stages serially from startID using the given branch
+// context, logging them under logsRoot. A parallel node inside the branch is
+// fanned out in turn; the branch carries on from the fan-in its branches
+// converged on if that fan-in closes the nested fan-out (see nestedJoin), and
+// otherwise stops there.
+func (e *Engine) runBranch(startID string, ctx *Context, graph *Graph, stageIndex int, logsRoot string) *BranchResult {
+	result := &BranchResult{
+		Branch:       startID,
+		NodeOutcomes: make(map[string]*Outcome),
+		LogsDir:      logsRoot,
+	}
+
+	current := graph.Nodes[startID]
+	if current == nil {
+		result.Outcome = &Outcome{Status: StatusFail, FailureReason: "node not found"}
+		return result
+	}
+
+	joined := false
+	for current != nil {
+		if isTerminal(current) || (isFanIn(current) && !joined) {
+			result.StopNode = current.ID
+			break
+		}
+		joined = false
+		if err := e.cancelled(); err != nil {
+			result.Outcome = &Outcome{Status: StatusFail, FailureReason: err.Error()}
+			break
+		}
+
+		branch := e.stageBranch(logsRoot)
+		e.emitter.EmitTyped(events.StageStarted{Name: current.Label, NodeID: current.ID, Branch: branch, Index: stageIndex})
+		stageStart := time.Now()
+		var outcome *Outcome
+		joinNode := ""
+		if isParallel(current) {
+			var branches []*BranchResult
+			outcome, branches, joinNode = e.executeParallel(current, ctx, graph, stageIndex, logsRoot)
+			for _, b := range branches {
+				result.CompletedNodes = append(result.CompletedNodes, b.CompletedNodes...)
+				for id, o := range b.NodeOutcomes {
+					result.NodeOutcomes[id] = o
+				}
+			}
+		} else {
+			var err error
+			outcome, err = e.executeWithRetry(current, ctx, graph, buildRetryPolicy(current, graph), stageIndex, logsRoot)
+			if err != nil {
+				outcome = &Outcome{Status: StatusFail, FailureReason: err.Error()}
+			}
+		}
+		if outcome.Status == StatusSuccess || outcome.Status == StatusPartialSuccess {
+			e.emitter.EmitTyped(events.StageCompleted{Name: current.Label, NodeID: current.ID, Branch: branch, Index: stageIndex, Duration: time.Since(stageStart)})
+		} else {
+			e.emitter.EmitTyped(events.StageFailed{Name: current.Label, NodeID: current.ID, Branch: branch, Index: stageIndex, Error: outcome.FailureReason})
+		}
+
+		result.CompletedNodes = append(result.CompletedNodes, current.ID)
+		result.NodeOutcomes[current.ID] = outcome
+		result.Outcome = outcome
+
+		ctx.ApplyUpdates(outcome.ContextUpdates)
+		ctx.Set("outcome", string(outcome.Status))
+
+		if joinNode != "" {
+			joined = nestedJoin(graph, current.ID, joinNode)
+			current = graph.Nodes[joinNode]
+			continue
+		}
+		next := selectEdge(current, outcome, ctx, graph)
+		if next == nil {
+			break
+		}
+		current = graph.Nodes[next.To]
+	}
+
+	if result.Outcome == nil {
+		// The branch went straight to a fan-in or terminal node.
+		result.Outcome = &Outcome{Status: StatusSuccess}
+	}
+	return result
+}
+
+// nestedJoin reports whether the fan-in join closes the fan-out at
+// parallelID, rather than one enclosing it: every edge into join comes from
+// parallelID or a node its branches reach before any fan-in or terminal node.
+func nestedJoin(graph *Graph, parallelID, join string) bool {
+	inside := map[string]bool{parallelID: true}
+	queue := []string{parallelID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, edge := range graph.OutgoingEdges(id) {
+			next := graph.Nodes[edge.To]
+			if next == nil || inside[next.ID] || isFanIn(next) || isTerminal(next) {
+				continue
+			}
+			inside[next.ID] = true
+			queue = append(queue, next.ID)
+		}
+	}
+	for _, edge := range graph.IncomingEdges(join) {
+		if !inside[edge.From] {
+			return false
+		}
+	}
+	return true
+}
+
+// joinOutcome evaluates a parallel node's join_policy over its branch results.
+func joinOutcome(policy string, successCount, failCount int) *Outcome {
+	switch policy {
+	case "first_success":
+		if successCount > 0 {
+			return &Outcome{Status: StatusSuccess}
+		}
+		return &Outcome{Status: StatusFail, FailureReason: "no parallel branch succeeded"}
+	default: // wait_all
+		if failCount == 0 {
+			return &Outcome{Status: StatusSuccess}
+		}
+		return &Outcome{Status: StatusPartialSuccess, Notes: strconv.Itoa(failCount) + " parallel branch(es) failed"}
+	}
+}
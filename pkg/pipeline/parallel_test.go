@@ -0,0 +1,309 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// branchHandler records a per-node context key and tracks peak concurrency.
+type branchHandler struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (h *branchHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	h.mu.Lock()
+	h.running++
+	if h.running > h.peak {
+		h.peak = h.running
+	}
+	h.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	h.mu.Lock()
+	h.running--
+	h.mu.Unlock()
+
+	return &Outcome{
+		Status: StatusSuccess,
+		ContextUpdates: map[string]interface{}{
+			"branch." + node.ID: "done",
+		},
+	}, nil
+}
+
+// snapshotHandler captures the context it sees.
+type snapshotHandler struct {
+	seen map[string]interface{}
+}
+
+func (h *snapshotHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	h.seen = ctx.Snapshot()
+	return &Outcome{Status: StatusSuccess}, nil
+}
+
+// summaryHandler writes a summary key and a response file into its logs.
+type summaryHandler struct{}
+
+func (h *summaryHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	writeFile(filepath.Join(logsRoot, node.ID, "response.md"), []byte("by "+node.ID))
+	return &Outcome{
+		Status:         StatusSuccess,
+		ContextUpdates: map[string]interface{}{"summary": "from " + node.ID},
+	}, nil
+}
+
+func parallelGraph(maxParallel string) *Graph {
+	return &Graph{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start": {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"fan":   {ID: "fan", Shape: "component", Attrs: map[string]string{"max_parallel": maxParallel}},
+			"a":     {ID: "a", Shape: "box", Attrs: map[string]string{}},
+			"b":     {ID: "b", Shape: "box", Attrs: map[string]string{}},
+			"b2":    {ID: "b2", Shape: "box", Attrs: map[string]string{}},
+			"join":  {ID: "join", Shape: "tripleoctagon", Attrs: map[string]string{}},
+			"exit":  {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "fan"},
+			{From: "fan", To: "a"},
+			{From: "fan", To: "b"},
+			{From: "a", To: "join"},
+			{From: "b", To: "b2"},
+			{From: "b2", To: "join"},
+			{From: "join", To: "exit"},
+		},
+	}
+}
+
+func TestParallelBranchesRunConcurrently(t *testing.T) {
+	branches := &branchHandler{}
+	join := &snapshotHandler{}
+	resolver := &staticResolver{
+		handler: branches,
+		special: map[string]Handler{
+			"start": &simpleHandler{},
+			"join":  join,
+		},
+	}
+	engine := NewEngine(EngineConfig{}, resolver, nil)
+
+	result, err := engine.Run(parallelGraph(""))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Status != StatusSuccess {
+		t.Errorf("expected SUCCESS, got %s", result.Status)
+	}
+	if branches.peak < 2 {
+		t.Errorf("expected branches to overlap, peak concurrency was %d", branches.peak)
+	}
+
+	for _, key := range []string{"branch.a", "branch.b", "branch.b2"} {
+		if join.seen[key] != "done" {
+			t.Errorf("expected %s merged into parent context at join", key)
+		}
+	}
+	if join.seen["parallel.results"] == nil {
+		t.Error("expected parallel.results to be set")
+	}
+
+	completed := make(map[string]bool)
+	for _, id := range result.CompletedNodes {
+		completed[id] = true
+	}
+	for _, id := range []string{"fan", "a", "b", "b2", "join"} {
+		if !completed[id] {
+			t.Errorf("expected %s in completed nodes", id)
+		}
+	}
+}
+
+func TestParallelBranchIsolation(t *testing.T) {
+	join := &snapshotHandler{}
+	resolver := &staticResolver{
+		handler: &summaryHandler{},
+		special: map[string]Handler{
+			"start": &simpleHandler{},
+			"join":  join,
+		},
+	}
+	logsRoot := t.TempDir()
+	engine := NewEngine(EngineConfig{LogsRoot: logsRoot}, resolver, nil)
+	if _, err := engine.Run(parallelGraph("")); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for key, want := range map[string]string{
+		"parallel.a.summary": "from a",
+		"parallel.b.summary": "from b2",
+		"parallel.a.outcome": "success",
+	} {
+		if join.seen[key] != want {
+			t.Errorf("expected %s=%q at join, got %v", key, want, join.seen[key])
+		}
+	}
+
+	for branch, stage := range map[string]string{"a": "a", "b": "b2"} {
+		dir := filepath.Join(logsRoot, "fan", "branches", branch, stage)
+		if _, err := os.Stat(filepath.Join(dir, "response.md")); err != nil {
+			t.Errorf("expected %s's response in its branch logs: %v", stage, err)
+		}
+		if got := StageLogDir(logsRoot, stage); got != dir {
+			t.Errorf("expected StageLogDir(%s) = %s, got %s", stage, dir, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(logsRoot, "a")); err == nil {
+		t.Error("expected no branch stage logs at the top level")
+	}
+}
+
+func TestParallelNestedFanOut(t *testing.T) {
+	graph := &Graph{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start":      {ID: "start", Shape: "Mdiamond", Attrs: map[string]string{}},
+			"fan":        {ID: "fan", Shape: "component", Attrs: map[string]string{}},
+			"a":          {ID: "a", Shape: "box", Attrs: map[string]string{}},
+			"inner":      {ID: "inner", Shape: "component", Attrs: map[string]string{}},
+			"x":          {ID: "x", Shape: "box", Attrs: map[string]string{}},
+			"y":          {ID: "y", Shape: "box", Attrs: map[string]string{}},
+			"inner_join": {ID: "inner_join", Shape: "tripleoctagon", Attrs: map[string]string{}},
+			"after":      {ID: "after", Shape: "box", Attrs: map[string]string{}},
+			"b":          {ID: "b", Shape: "box", Attrs: map[string]string{}},
+			"join":       {ID: "join", Shape: "tripleoctagon", Attrs: map[string]string{}},
+			"exit":       {ID: "exit", Shape: "Msquare", Attrs: map[string]string{}},
+		},
+		Edges: []*Edge{
+			{From: "start", To: "fan"},
+			{From: "fan", To: "a"},
+			{From: "fan", To: "b"},
+			{From: "a", To: "inner"},
+			{From: "inner", To: "x"},
+			{From: "inner", To: "y"},
+			{From: "x", To: "inner_join"},
+			{From: "y", To: "inner_join"},
+			{From: "inner_join", To: "after"},
+			{From: "after", To: "join"},
+			{From: "b", To: "join"},
+			{From: "join", To: "exit"},
+		},
+	}
+	// The parallel nodes fail if they ever reach a handler.
+	resolver := &staticResolver{
+		handler: &summaryHandler{},
+		special: map[string]Handler{
+			"fan":   &failHandler{},
+			"inner": &failHandler{},
+		},
+	}
+	logsRoot := t.TempDir()
+	result, err := NewEngine(EngineConfig{LogsRoot: logsRoot}, resolver, nil).Run(graph)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Status != StatusSuccess {
+		t.Errorf("expected SUCCESS, got %s", result.Status)
+	}
+
+	count := make(map[string]int)
+	for _, id := range result.CompletedNodes {
+		count[id]++
+	}
+	for _, id := range []string{"fan", "a", "inner", "x", "y", "inner_join", "after", "b", "join"} {
+		if count[id] != 1 {
+			t.Errorf("expected %s to run once, ran %d times (completed %v)", id, count[id], result.CompletedNodes)
+		}
+	}
+	if o := result.NodeOutcomes["inner"]; o == nil || o.Status != StatusSuccess {
+		t.Errorf("expected the nested fan-out to succeed, got %+v", o)
+	}
+	if _, err := os.Stat(filepath.Join(logsRoot, "fan", "branches", "a", "inner", "branches", "y", "y", "response.md")); err != nil {
+		t.Errorf("expected the inner branch logs under the outer branch: %v", err)
+	}
+}
+
+func TestParallelNestedSharedJoin(t *testing.T) {
+	// The inner branches converge on the outer fan-in, which runs once.
+	graph := parallelGraph("")
+	graph.Nodes["inner"] = &Node{ID: "inner", Shape: "component", Attrs: map[string]string{}}
+	graph.Nodes["x"] = &Node{ID: "x", Shape: "box", Attrs: map[string]string{}}
+	graph.Nodes["y"] = &Node{ID: "y", Shape: "box", Attrs: map[string]string{}}
+	graph.Edges = []*Edge{
+		{From: "start", To: "fan"},
+		{From: "fan", To: "a"},
+		{From: "fan", To: "b"},
+		{From: "a", To: "inner"},
+		{From: "inner", To: "x"},
+		{From: "inner", To: "y"},
+		{From: "x", To: "join"},
+		{From: "y", To: "join"},
+		{From: "b", To: "join"},
+		{From: "join", To: "exit"},
+	}
+	resolver := &staticResolver{handler: &branchHandler{}}
+	result, err := NewEngine(EngineConfig{}, resolver, nil).Run(graph)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	count := make(map[string]int)
+	for _, id := range result.CompletedNodes {
+		count[id]++
+	}
+	for _, id := range []string{"x", "y", "b", "join"} {
+		if count[id] != 1 {
+			t.Errorf("expected %s to run once, ran %d times (completed %v)", id, count[id], result.CompletedNodes)
+		}
+	}
+}
+
+func TestParallelRespectsMaxParallel(t *testing.T) {
+	branches := &branchHandler{}
+	resolver := &staticResolver{
+		handler: branches,
+		special: map[string]Handler{
+			"start": &simpleHandler{},
+			"join":  &simpleHandler{},
+		},
+	}
+	engine := NewEngine(EngineConfig{}, resolver, nil)
+
+	if _, err := engine.Run(parallelGraph("1")); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if branches.peak != 1 {
+		t.Errorf("expected peak concurrency 1, got %d", branches.peak)
+	}
+}
+
+func TestParallelJoinPolicy(t *testing.T) {
+	if o := joinOutcome("wait_all", 1, 1); o.Status != StatusPartialSuccess {
+		t.Errorf("expected PARTIAL_SUCCESS for wait_all with a failure, got %s", o.Status)
+	}
+	if o := joinOutcome("first_success", 1, 1); o.Status != StatusSuccess {
+		t.Errorf("expected SUCCESS for first_success, got %s", o.Status)
+	}
+	if o := joinOutcome("first_success", 0, 2); o.Status != StatusFail {
+		t.Errorf("expected FAIL when no branch succeeded, got %s", o.Status)
+	}
+}
+
+func TestStageLogDirRejectsNonLocalIDs(t *testing.T) {
+	logsRoot := t.TempDir()
+	for _, id := range []string{"../other", "a/b", ".", ".."} {
+		if got := StageLogDir(logsRoot, id); got != "" {
+			t.Errorf("expected no directory for %q, got %s", id, got)
+		}
+	}
+	if _, err := NewEngine(EngineConfig{LogsRoot: logsRoot}, &staticResolver{}, nil).Run(&Graph{
+		Nodes: map[string]*Node{"../escape": {ID: "../escape"}},
+	}); err == nil {
+		t.Error("expected the engine to refuse a node ID outside the logs root")
+	}
+}
@@ -7,16 +7,41 @@ import (
 	"time"
 )
 
+// ParseError is a lexer or parser error at a source position. Parse wraps
+// it, so use errors.As to recover the position.
+type ParseError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Message, e.Line, e.Column)
+}
+
+// Pos returns the error's source position.
+func (e *ParseError) Pos() Position {
+	return Position{Line: e.Line, Column: e.Column}
+}
+
 // Parser parses a DOT file into a pipeline Graph.
 type Parser struct {
 	tokens      []Token
 	pos         int
 	nodeDefaults map[string]string
 	edgeDefaults map[string]string
+	declared     map[string]bool // nodes with an explicit node statement
+	comments     []Comment
+	attached     map[int]bool // indexes of comments given to a statement
+	members      []map[string]bool // nodes mentioned in each open subgraph
+
+	strict    bool
+	warnings  []Diagnostic
+	nodeDecls map[string]map[string]string // attributes of each node's statements, in strict mode
 }
 
 // Parse parses DOT source into a pipeline.Graph.
-func Parse(source string) (*Graph, error) {
+func Parse(source string, opts ...ParseOption) (*Graph, error) {
 	lexer := NewLexer(source)
 	tokens, err := lexer.Tokenize()
 	if err != nil {
@@ -27,8 +52,28 @@ func Parse(source string) (*Graph, error) {
 		tokens:       tokens,
 		nodeDefaults: make(map[string]string),
 		edgeDefaults: make(map[string]string),
+		declared:     make(map[string]bool),
+		comments:     lexer.Comments(),
+		attached:     make(map[int]bool),
+		nodeDecls:    make(map[string]map[string]string),
 	}
-	return p.parseGraph()
+	for _, opt := range opts {
+		opt(p)
+	}
+	graph, err := p.parseGraph()
+	if err != nil {
+		return nil, err
+	}
+	// Node IDs name stage directories in the logs; one like "../x" would
+	// read and write outside the run.
+	for _, id := range sortedNodeIDs(graph) {
+		if !localNodeID(id) {
+			pos := graph.Nodes[id].Pos
+			return nil, &ParseError{Line: pos.Line, Column: pos.Column,
+				Message: fmt.Sprintf("invalid node ID %q: node IDs name log directories and can't be empty, \".\", or contain path separators or \"..\"", id)}
+		}
+	}
+	return graph, nil
 }
 
 func (p *Parser) peek() Token {
@@ -38,6 +83,14 @@ func (p *Parser) peek() Token {
 	return p.tokens[p.pos]
 }
 
+// peekAt returns the token n places after the next one.
+func (p *Parser) peekAt(n int) Token {
+	if p.pos+n >= len(p.tokens) {
+		return Token{Type: TokenEOF}
+	}
+	return p.tokens[p.pos+n]
+}
+
 func (p *Parser) advance() Token {
 	tok := p.peek()
 	if tok.Type != TokenEOF {
@@ -49,13 +102,17 @@ func (p *Parser) advance() Token {
 func (p *Parser) expect(t TokenType) (Token, error) {
 	tok := p.advance()
 	if tok.Type != t {
-		return tok, fmt.Errorf("expected %s but got %s (%q) at line %d, column %d",
-			t, tok.Type, tok.Value, tok.Line, tok.Column)
+		return tok, &ParseError{Line: tok.Line, Column: tok.Column,
+			Message: fmt.Sprintf("expected %s but got %s (%q)", t, tok.Type, tok.Value)}
 	}
 	return tok, nil
 }
 
 func (p *Parser) parseGraph() (*Graph, error) {
+	// A strict graph merges repeated edges when drawn; pipelines keep them.
+	if tok := p.peek(); tok.Type == TokenIdentifier && strings.EqualFold(tok.Value, "strict") {
+		p.advance()
+	}
 	if _, err := p.expect(TokenDigraph); err != nil {
 		return nil, fmt.Errorf("expected 'digraph': %w", err)
 	}
@@ -87,6 +144,9 @@ func (p *Parser) parseGraph() (*Graph, error) {
 	// Apply defaults and resolve graph attributes.
 	p.resolveGraphAttrs(graph)
 
+	p.checkGraph(graph)
+	graph.warnings = p.warnings
+
 	return graph, nil
 }
 
@@ -119,12 +179,24 @@ func (p *Parser) parseStatement(graph *Graph, subgraphDefaults map[string]string
 	case TokenSubgraph:
 		return p.parseSubgraph(graph)
 
-	case TokenIdentifier, TokenString:
+	case TokenIdentifier, TokenString, TokenInteger:
 		return p.parseNodeOrEdge(graph, subgraphDefaults)
 
+	case TokenLBrace:
+		first := tok
+		group, err := p.parseGroup(graph, subgraphDefaults)
+		if err != nil {
+			return err
+		}
+		if p.peek().Type == TokenArrow {
+			return p.parseEdgeChain(graph, first, group, subgraphDefaults)
+		}
+		p.skipSemicolon()
+		return nil
+
 	default:
-		return fmt.Errorf("unexpected token %s (%q) at line %d, column %d",
-			tok.Type, tok.Value, tok.Line, tok.Column)
+		return &ParseError{Line: tok.Line, Column: tok.Column,
+			Message: fmt.Sprintf("unexpected token %s (%q)", tok.Type, tok.Value)}
 	}
 }
 
@@ -132,7 +204,7 @@ func (p *Parser) parseGraphAttrStmt(graph *Graph) error {
 	p.advance() // consume 'graph'
 
 	if p.peek().Type == TokenLBracket {
-		attrs, err := p.parseAttrBlock()
+		attrs, err := p.parseAttrBlock(graph.Attrs)
 		if err != nil {
 			return err
 		}
@@ -149,7 +221,7 @@ func (p *Parser) parseGraphAttrStmt(graph *Graph) error {
 
 func (p *Parser) parseNodeDefaults() error {
 	p.advance() // consume 'node'
-	attrs, err := p.parseAttrBlock()
+	attrs, err := p.parseAttrBlock(p.nodeDefaults)
 	if err != nil {
 		return err
 	}
@@ -161,11 +233,12 @@ func (p *Parser) parseNodeDefaults() error {
 }
 
 func (p *Parser) parseEdgeDefaults() error {
-	p.advance() // consume 'edge'
-	attrs, err := p.parseAttrBlock()
+	edgeTok := p.advance() // consume 'edge'
+	attrs, err := p.parseAttrBlock(p.edgeDefaults)
 	if err != nil {
 		return err
 	}
+	p.checkEdgeAttrs(attrs, "", "", edgeTok)
 	for k, v := range attrs {
 		p.edgeDefaults[k] = v
 	}
@@ -194,6 +267,7 @@ func (p *Parser) parseSubgraph(graph *Graph) error {
 
 	// Parse subgraph-local statements.
 	sgDefaults := make(map[string]string)
+	p.members = append(p.members, make(map[string]bool))
 
 	for p.peek().Type != TokenRBrace && p.peek().Type != TokenEOF {
 		tok := p.peek()
@@ -206,7 +280,7 @@ func (p *Parser) parseSubgraph(graph *Graph) error {
 			// subgraph graph attrs
 			p.advance()
 			if p.peek().Type == TokenLBracket {
-				attrs, err := p.parseAttrBlock()
+				attrs, err := p.parseAttrBlock(sgDefaults)
 				if err != nil {
 					return err
 				}
@@ -226,11 +300,21 @@ func (p *Parser) parseSubgraph(graph *Graph) error {
 		return err
 	}
 
-	// Derive class from subgraph label for nodes.
-	if subgraphLabel != "" {
-		derivedClass := deriveClassName(subgraphLabel)
-		// Apply derived class to nodes that were defined in this subgraph and don't have explicit class.
-		_ = derivedClass // Applied during node creation via subgraphDefaults
+	// Nodes mentioned in the subgraph get a class derived from its label,
+	// or its name, unless they set one themselves. An inner subgraph closes
+	// first, so its class wins over an enclosing one's.
+	members := p.members[len(p.members)-1]
+	p.members = p.members[:len(p.members)-1]
+	className := sgDefaults["label"]
+	if className == "" {
+		className = subgraphLabel
+	}
+	if derived := deriveClassName(className); derived != "" {
+		for id := range members {
+			if node := graph.Nodes[id]; node != nil && node.Class == "" {
+				node.Class = derived
+			}
+		}
 	}
 
 	// Restore defaults.
@@ -261,125 +345,314 @@ func (p *Parser) parseNodeOrEdge(graph *Graph, subgraphDefaults map[string]strin
 	idTok := p.advance()
 	id := idTok.Value
 
-	// Check if this is a top-level key=value declaration.
-	if p.peek().Type == TokenEquals {
-		p.advance() // consume '='
-		valueTok := p.advance()
-		graph.Attrs[id] = valueTok.Value
+	// Check if this is a top-level key=value (or key+=value) declaration.
+	if p.peek().Type == TokenEquals || p.peek().Type == TokenPlus && p.peekAt(1).Type == TokenEquals {
+		appendValue := p.advance().Type == TokenPlus
+		if appendValue {
+			p.advance() // consume '='
+		}
+		// Inside a subgraph, key=value sets a subgraph attribute.
+		attrs := graph.Attrs
+		if subgraphDefaults != nil {
+			attrs = subgraphDefaults
+		}
+		value := p.parseValue().Value
+		if appendValue {
+			value = attrs[id] + value
+		}
+		attrs[id] = value
 		p.skipSemicolon()
 		return nil
 	}
 
+	// A port (a:out) places an edge's end on the node; it is kept on the
+	// edge and ignored for node statements.
+	port := p.parsePort()
+
 	// Check if this is an edge statement (A -> B -> C).
 	if p.peek().Type == TokenArrow {
-		return p.parseEdgeChain(graph, id, subgraphDefaults)
+		return p.parseEdgeChain(graph, idTok, []endpoint{{tok: idTok, port: port}}, subgraphDefaults)
 	}
 
-	// Otherwise it's a node statement.
-	p.ensureNode(graph, id, subgraphDefaults)
+	// Otherwise it's a node statement. It defines the node's position even if
+	// an earlier edge referenced the node first.
+	p.ensureNode(graph, id, idTok, subgraphDefaults)
+	if !p.declared[id] {
+		p.declared[id] = true
+		graph.Nodes[id].Pos = tokenPos(idTok)
+	}
 
 	if p.peek().Type == TokenLBracket {
-		attrs, err := p.parseAttrBlock()
+		attrs, err := p.parseAttrBlock(nodeAttrs(graph.Nodes[id]))
 		if err != nil {
 			return err
 		}
 		p.applyNodeAttrs(graph.Nodes[id], attrs)
+		p.declareNode(id, attrs, idTok)
 	}
 
 	p.skipSemicolon()
+	if comment := p.commentFor(idTok); comment != "" {
+		node := graph.Nodes[id]
+		if node.Comment != "" {
+			node.Comment += "\n"
+		}
+		node.Comment += comment
+	}
 	return nil
 }
 
-func (p *Parser) parseEdgeChain(graph *Graph, firstID string, subgraphDefaults map[string]string) error {
-	chain := []string{firstID}
+// endpoint is a node named in an edge statement, with its port if any.
+type endpoint struct {
+	tok  Token
+	port string
+}
+
+// parseEdgeChain parses the rest of an edge statement that starts at
+// firstTok with the endpoints first. Each endpoint is a node or a group of
+// nodes ({a b}); an edge joins every node of each endpoint to every node of
+// the next.
+func (p *Parser) parseEdgeChain(graph *Graph, firstTok Token, first []endpoint, subgraphDefaults map[string]string) error {
+	chain := [][]endpoint{first}
 
 	for p.peek().Type == TokenArrow {
 		p.advance() // consume '->'
-		idTok := p.advance()
-		chain = append(chain, idTok.Value)
+		next, err := p.parseEndpoint(graph, subgraphDefaults)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, next)
 	}
 
 	// Parse optional edge attributes.
 	var attrs map[string]string
 	if p.peek().Type == TokenLBracket {
 		var err error
-		attrs, err = p.parseAttrBlock()
+		attrs, err = p.parseAttrBlock(p.edgeDefaults)
 		if err != nil {
 			return err
 		}
+		if len(chain) > 1 && len(chain[0]) > 0 && len(chain[1]) > 0 {
+			p.checkEdgeAttrs(attrs, chain[0][0].tok.Value, chain[1][0].tok.Value, firstTok)
+		}
 	}
 
+	p.skipSemicolon()
+	comment := p.commentFor(firstTok)
+
 	// Create edges for each consecutive pair.
 	for i := 0; i < len(chain)-1; i++ {
-		from := chain[i]
-		to := chain[i+1]
+		for _, from := range chain[i] {
+			for _, to := range chain[i+1] {
+				p.ensureNode(graph, from.tok.Value, from.tok, subgraphDefaults)
+				p.ensureNode(graph, to.tok.Value, to.tok, subgraphDefaults)
+
+				edge := &Edge{
+					From:     from.tok.Value,
+					To:       to.tok.Value,
+					TailPort: from.port,
+					HeadPort: to.port,
+					Comment:  comment,
+					Pos:      tokenPos(from.tok),
+				}
 
-		p.ensureNode(graph, from, subgraphDefaults)
-		p.ensureNode(graph, to, subgraphDefaults)
+				// Apply edge defaults.
+				for k, v := range p.edgeDefaults {
+					p.applyEdgeAttr(edge, k, v)
+				}
 
-		edge := &Edge{
-			From: from,
-			To:   to,
-		}
+				// Apply explicit attrs.
+				for k, v := range attrs {
+					p.applyEdgeAttr(edge, k, v)
+				}
 
-		// Apply edge defaults.
-		for k, v := range p.edgeDefaults {
-			p.applyEdgeAttr(edge, k, v)
+				graph.AddEdge(edge)
+			}
 		}
+	}
+	return nil
+}
 
-		// Apply explicit attrs.
-		for k, v := range attrs {
-			p.applyEdgeAttr(edge, k, v)
+// parseEndpoint parses the node or group of nodes after an arrow.
+func (p *Parser) parseEndpoint(graph *Graph, subgraphDefaults map[string]string) ([]endpoint, error) {
+	if p.peek().Type == TokenLBrace {
+		return p.parseGroup(graph, subgraphDefaults)
+	}
+	tok := p.advance()
+	switch tok.Type {
+	case TokenIdentifier, TokenString, TokenInteger:
+		return []endpoint{{tok: tok, port: p.parsePort()}}, nil
+	}
+	return nil, &ParseError{Line: tok.Line, Column: tok.Column,
+		Message: fmt.Sprintf("expected a node after '->' but got %s (%q)", tok.Type, tok.Value)}
+}
+
+// parseGroup parses an anonymous subgraph, { a b [label="B"]; rank=same },
+// and returns its nodes. Graphviz files use these to group nodes for layout
+// or as an edge endpoint. Node statements in it are applied; attribute
+// assignments and attribute statements are layout hints and are ignored.
+func (p *Parser) parseGroup(graph *Graph, subgraphDefaults map[string]string) ([]endpoint, error) {
+	if _, err := p.expect(TokenLBrace); err != nil {
+		return nil, err
+	}
+	var nodes []endpoint
+	for p.peek().Type != TokenRBrace {
+		tok := p.advance()
+		switch tok.Type {
+		case TokenSemicolon, TokenComma:
+		case TokenGraph, TokenNode, TokenEdge:
+			if p.peek().Type == TokenLBracket {
+				if _, err := p.parseAttrBlock(nil); err != nil {
+					return nil, err
+				}
+			}
+		case TokenIdentifier, TokenString, TokenInteger:
+			if p.peek().Type == TokenEquals {
+				p.advance()
+				p.parseValue()
+				continue
+			}
+			port := p.parsePort()
+			p.ensureNode(graph, tok.Value, tok, subgraphDefaults)
+			if p.peek().Type == TokenLBracket {
+				node := graph.Nodes[tok.Value]
+				attrs, err := p.parseAttrBlock(nodeAttrs(node))
+				if err != nil {
+					return nil, err
+				}
+				p.applyNodeAttrs(node, attrs)
+				p.declareNode(tok.Value, attrs, tok)
+			}
+			nodes = append(nodes, endpoint{tok: tok, port: port})
+		default:
+			return nil, &ParseError{Line: tok.Line, Column: tok.Column,
+				Message: fmt.Sprintf("unexpected token %s (%q) in node group", tok.Type, tok.Value)}
 		}
+	}
+	p.advance() // consume '}'
+	return nodes, nil
+}
 
-		graph.Edges = append(graph.Edges, edge)
+// parsePort parses a node's optional port, :port or :port:compass, and
+// returns it without the leading colon.
+func (p *Parser) parsePort() string {
+	var parts []string
+	for len(parts) < 2 && p.peek().Type == TokenColon {
+		p.advance()
+		parts = append(parts, p.advance().Value)
 	}
+	return strings.Join(parts, ":")
+}
 
-	p.skipSemicolon()
-	return nil
+// parseValue parses an attribute value. Quoted strings joined with "+" are
+// concatenated, as in Graphviz.
+func (p *Parser) parseValue() Token {
+	tok := p.advance()
+	for tok.Type == TokenString && p.peek().Type == TokenPlus && p.peekAt(1).Type == TokenString {
+		p.advance() // consume '+'
+		tok.Value += p.advance().Value
+	}
+	return tok
 }
 
-func (p *Parser) parseAttrBlock() (map[string]string, error) {
+// commentFor returns the comments documenting the statement that starts at
+// first and ends at the last token consumed: the comments on whole lines
+// directly above it, with no blank line between, and the comments within
+// or after it on its lines. Each comment documents one statement.
+func (p *Parser) commentFor(first Token) string {
+	last := p.tokens[p.pos-1]
+	// A comment after "a; b" on one line is b's.
+	if next := p.peek(); next.Line == last.Line && (next.Type == TokenIdentifier || next.Type == TokenString) {
+		last.Line--
+	}
+	var leading, within []string
+	above := first.Line - 1
+scan:
+	for i := len(p.comments) - 1; i >= 0; i-- {
+		c := p.comments[i]
+		switch {
+		case p.attached[i] || c.Line > last.Line:
+			continue
+		case c.Line >= first.Line:
+			within = append([]string{c.Text}, within...)
+		case !c.Trailing && c.EndLine == above:
+			leading = append([]string{c.Text}, leading...)
+			above = c.Line - 1
+		case c.EndLine < above:
+			break scan // the rest are not adjacent
+		default:
+			continue
+		}
+		p.attached[i] = true
+	}
+	return strings.Join(append(leading, within...), "\n")
+}
+
+// parseAttrBlock parses one or more attribute lists, [a=1, b=2][c=3].
+// Attributes are separated by commas or semicolons. key+=value appends to
+// the key's value earlier in the lists, or else its value in current.
+func (p *Parser) parseAttrBlock(current map[string]string) (map[string]string, error) {
 	if _, err := p.expect(TokenLBracket); err != nil {
 		return nil, err
 	}
 
 	attrs := make(map[string]string)
 
-	for p.peek().Type != TokenRBracket && p.peek().Type != TokenEOF {
-		// Key (may be qualified: key.subkey)
-		keyTok := p.advance()
-		key := keyTok.Value
+	for {
+		for p.peek().Type != TokenRBracket && p.peek().Type != TokenEOF {
+			// Key (may be qualified: key.subkey)
+			keyTok := p.advance()
+			key := keyTok.Value
+
+			// Handle qualified IDs (key.subkey.subsubkey)
+			for p.peek().Type == TokenDot {
+				p.advance() // consume '.'
+				subkey := p.advance()
+				key += "." + subkey.Value
+			}
+
+			appendValue := false
+			if p.peek().Type == TokenPlus {
+				p.advance()
+				appendValue = true
+			}
+			if _, err := p.expect(TokenEquals); err != nil {
+				return nil, err
+			}
+
+			// Value
+			value := p.parseValue().Value
+			if appendValue {
+				base, ok := attrs[key]
+				if !ok {
+					base = current[key]
+				}
+				value = base + value
+			}
+			attrs[key] = value
 
-		// Handle qualified IDs (key.subkey.subsubkey)
-		for p.peek().Type == TokenDot {
-			p.advance() // consume '.'
-			subkey := p.advance()
-			key += "." + subkey.Value
+			// Optional separator.
+			if p.peek().Type == TokenComma || p.peek().Type == TokenSemicolon {
+				p.advance()
+			}
 		}
 
-		if _, err := p.expect(TokenEquals); err != nil {
+		if _, err := p.expect(TokenRBracket); err != nil {
 			return nil, err
 		}
-
-		// Value
-		valTok := p.advance()
-		attrs[key] = valTok.Value
-
-		// Optional comma separator.
-		if p.peek().Type == TokenComma {
-			p.advance()
+		if p.peek().Type != TokenLBracket {
+			return attrs, nil
 		}
+		p.advance()
 	}
-
-	if _, err := p.expect(TokenRBracket); err != nil {
-		return nil, err
-	}
-
-	return attrs, nil
 }
 
-func (p *Parser) ensureNode(graph *Graph, id string, subgraphDefaults map[string]string) {
+// ensureNode creates the node on first reference, positioned at tok, and
+// makes it a member of the open subgraphs.
+func (p *Parser) ensureNode(graph *Graph, id string, tok Token, subgraphDefaults map[string]string) {
+	for _, members := range p.members {
+		members[id] = true
+	}
 	if _, exists := graph.Nodes[id]; exists {
 		return
 	}
@@ -388,6 +661,7 @@ func (p *Parser) ensureNode(graph *Graph, id string, subgraphDefaults map[string
 		ID:    id,
 		Label: id,
 		Shape: "box",
+		Pos:   tokenPos(tok),
 		Attrs: make(map[string]string),
 	}
 
@@ -465,14 +739,24 @@ func (p *Parser) applyEdgeAttr(edge *Edge, key, value string) {
 	case "condition":
 		edge.Condition = value
 	case "weight":
-		n, _ := strconv.Atoi(value)
-		edge.Weight = n
+		// A weight that isn't an integer is an expression, such as
+		// "context.confidence * 10", evaluated when the edge is chosen.
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil && strings.TrimSpace(value) != "" {
+			edge.Weight, edge.WeightExpr = 0, value
+			return
+		}
+		edge.Weight, edge.WeightExpr = n, ""
 	case "fidelity":
 		edge.Fidelity = value
 	case "thread_id":
 		edge.ThreadID = value
 	case "loop_restart":
 		edge.LoopRestart = value == "true"
+	case "tailport":
+		edge.TailPort = value
+	case "headport":
+		edge.HeadPort = value
 	}
 }
 
@@ -499,6 +783,33 @@ func (p *Parser) resolveGraphAttrs(graph *Graph) {
 	if v, ok := graph.Attrs["fallback_retry_target"]; ok {
 		graph.FallbackRetryTarget = v
 	}
+	if v, ok := graph.Attrs["max_tokens"]; ok {
+		n, _ := strconv.Atoi(v)
+		graph.MaxTokens = n
+	}
+	if v, ok := graph.Attrs["max_cost_usd"]; ok {
+		f, _ := strconv.ParseFloat(v, 64)
+		graph.MaxCostUSD = f
+	}
+	if v, ok := graph.Attrs["schedule"]; ok {
+		graph.Schedule = v
+	}
+	if v, ok := graph.Attrs["max_parallel"]; ok {
+		n, _ := strconv.Atoi(v)
+		graph.MaxParallel = n
+	}
+	if v, ok := graph.Attrs["requests_per_minute"]; ok {
+		n, _ := strconv.Atoi(v)
+		graph.RequestsPerMinute = n
+	}
+	if v, ok := graph.Attrs["tokens_per_minute"]; ok {
+		n, _ := strconv.Atoi(v)
+		graph.TokensPerMinute = n
+	}
+}
+
+func tokenPos(tok Token) Position {
+	return Position{Line: tok.Line, Column: tok.Column}
 }
 
 func (p *Parser) skipSemicolon() {
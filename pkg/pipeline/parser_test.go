@@ -1,6 +1,9 @@
 package pipeline
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -219,6 +222,108 @@ func TestParseComments(t *testing.T) {
 	if len(graph.Nodes) != 2 {
 		t.Errorf("expected 2 nodes, got %d", len(graph.Nodes))
 	}
+	if got := graph.Nodes["start"].Comment; got != "Block comment\ninline comment" {
+		t.Errorf("expected start's comments, got %q", got)
+	}
+	if got := graph.Nodes["exit"].Comment; got != "" {
+		t.Errorf("expected exit to have no comment, got %q", got)
+	}
+}
+
+func TestParseCommentAttachment(t *testing.T) {
+	source := `digraph Test {
+	// Not about plan: a blank line follows.
+
+	/*
+	 * Plan the change.
+	 * Keep it small.
+	 */
+	plan [
+		// The planning prompt.
+		prompt="Plan, see http://example.com/guide" // not a comment in the string
+	]
+	// Review the plan.
+	plan -> review [label="ok"] /* on success */
+	review; exit [shape=Msquare] // exit's note
+}`
+
+	graph, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := "Plan the change.\nKeep it small.\nThe planning prompt.\nnot a comment in the string"
+	if got := graph.Nodes["plan"].Comment; got != want {
+		t.Errorf("expected plan's comment %q, got %q", want, got)
+	}
+	if got := graph.Nodes["plan"].Prompt; got != "Plan, see http://example.com/guide" {
+		t.Errorf("expected the prompt to keep its //, got %q", got)
+	}
+	if got := graph.Edges[0].Comment; got != "Review the plan.\non success" {
+		t.Errorf("expected the edge's comment, got %q", got)
+	}
+	if got := graph.Nodes["exit"].Comment; got != "exit's note" || graph.Nodes["review"].Comment != "" {
+		t.Errorf("expected a trailing comment to go to the last statement on its line, got %q", got)
+	}
+	if got := graph.Edges[0].Pos; got != (Position{Line: 13, Column: 2}) {
+		t.Errorf("expected comments to keep positions, got %s", got)
+	}
+}
+
+func TestParseGraphvizConstructs(t *testing.T) {
+	source := `strict digraph G {
+	node [shape=box; fontname="Helvetica"]
+	label = "Release " + "pipeline"
+	start [shape=Mdiamond]
+	plan  [label=<<b>Plan</b> the <i>change</i>>, prompt="Plan the change.\
+ Be brief.\lThen stop."]
+	plan  [prompt+=" Cite http://example.com"][class="planning"]
+	{ rank=same; build test [label="Test"] }
+	exit  [shape=Msquare]
+	start -> plan:out:s
+	plan:out -> {build test} [label="go"]
+	{build; test} -> exit:in
+	1 -> exit
+}`
+
+	graph, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if graph.Label != "Release pipeline" {
+		t.Errorf("expected concatenated label, got %q", graph.Label)
+	}
+	plan := graph.Nodes["plan"]
+	if plan.Label != "<b>Plan</b> the <i>change</i>" {
+		t.Errorf("expected the HTML label's content, got %q", plan.Label)
+	}
+	if want := "Plan the change. Be brief.\nThen stop. Cite http://example.com"; plan.Prompt != want {
+		t.Errorf("expected prompt %q, got %q", want, plan.Prompt)
+	}
+	if plan.Class != "planning" || plan.Attrs["fontname"] != "Helvetica" {
+		t.Errorf("expected attributes from every list and the defaults, got class %q, attrs %v", plan.Class, plan.Attrs)
+	}
+	if graph.Nodes["test"].Label != "Test" {
+		t.Errorf("expected a node statement in a group to apply, got %q", graph.Nodes["test"].Label)
+	}
+	if _, ok := graph.Nodes["rank"]; ok {
+		t.Error("expected rank=same not to be a node")
+	}
+
+	var edges []string
+	for _, e := range graph.Edges {
+		edge := e.From + "->" + e.To
+		if e.TailPort != "" || e.HeadPort != "" {
+			edge += "(" + e.TailPort + "," + e.HeadPort + ")"
+		}
+		edges = append(edges, edge)
+	}
+	want := "start->plan(,out:s) plan->build(out,) plan->test(out,) build->exit(,in) test->exit(,in) 1->exit"
+	if got := strings.Join(edges, " "); got != want {
+		t.Errorf("expected edges %s, got %s", want, got)
+	}
+	if graph.Edges[2].Label != "go" {
+		t.Errorf("expected a group edge to get the statement's attributes, got %q", graph.Edges[2].Label)
+	}
 }
 
 func TestParseQuotedAndUnquoted(t *testing.T) {
@@ -268,6 +373,47 @@ func TestParseSubgraph(t *testing.T) {
 	if _, ok := graph.Nodes["Implement"]; !ok {
 		t.Error("Implement node not found")
 	}
+	if graph.Nodes["Plan"].Class != "loop" {
+		t.Errorf("expected class loop from the subgraph name, got %q", graph.Nodes["Plan"].Class)
+	}
+	if graph.Nodes["start"].Class != "" {
+		t.Errorf("expected start to have no class, got %q", graph.Nodes["start"].Class)
+	}
+}
+
+func TestParseSubgraphClass(t *testing.T) {
+	source := `digraph Test {
+		subgraph cluster_review {
+			label = "Code Review"
+			Lint
+			Audit [class="security"]
+			subgraph cluster_inner {
+				graph [label="Deep Dive"]
+				Trace -> Profile
+			}
+		}
+		Lint -> Report
+	}`
+
+	graph, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := map[string]string{
+		"Lint":    "code-review",
+		"Audit":   "security",
+		"Trace":   "deep-dive",
+		"Profile": "deep-dive",
+		"Report":  "",
+	}
+	for id, class := range want {
+		if got := graph.Nodes[id].Class; got != class {
+			t.Errorf("%s: expected class %q, got %q", id, class, got)
+		}
+	}
+	if _, ok := graph.Attrs["label"]; ok {
+		t.Error("expected the subgraph label not to become a graph attribute")
+	}
 }
 
 func TestParseBranchingWorkflow(t *testing.T) {
@@ -345,3 +491,63 @@ func TestParseTopLevelKeyValue(t *testing.T) {
 		t.Errorf("expected rankdir=LR, got %q", graph.Attrs["rankdir"])
 	}
 }
+
+func TestParsePositions(t *testing.T) {
+	source := `digraph P {
+	a -> b
+	b [label="B"]
+	b -> c [condition="outcome=success"]
+}`
+
+	graph, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tests := []struct {
+		id  string
+		pos Position
+	}{
+		{"a", Position{Line: 2, Column: 2}},
+		{"b", Position{Line: 3, Column: 2}}, // explicit statement wins over the edge reference
+		{"c", Position{Line: 4, Column: 7}},
+	}
+	for _, tt := range tests {
+		if got := graph.Nodes[tt.id].Pos; got != tt.pos {
+			t.Errorf("node %s: expected %s, got %s", tt.id, tt.pos, got)
+		}
+	}
+	if got := graph.Edges[1].Pos; got != (Position{Line: 4, Column: 2}) {
+		t.Errorf("expected edge b -> c at line 4, column 2, got %s", got)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := Parse("digraph P {\n\ta -> b;\n\t= x\n}")
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *ParseError, got %v", err)
+	}
+	if perr.Line != 3 || perr.Column != 2 {
+		t.Errorf("expected line 3, column 2, got %s", perr.Pos())
+	}
+
+	_, err = Parse("digraph P {\n  a @ b\n}")
+	if !errors.As(err, &perr) || perr.Line != 2 || perr.Column != 5 {
+		t.Errorf("expected lexer error at line 2, column 5, got %v", err)
+	}
+}
+
+func TestParseRejectsPathNodeIDs(t *testing.T) {
+	for _, id := range []string{"../../B", "a/b", `a\b`, ".", ".."} {
+		src := fmt.Sprintf("digraph P {\n\tstart -> %q\n}", id)
+		_, err := Parse(src)
+		var perr *ParseError
+		if !errors.As(err, &perr) || !strings.Contains(perr.Message, "invalid node ID") {
+			t.Errorf("%s: expected an invalid node ID error, got %v", id, err)
+		}
+	}
+	if _, err := Parse(`digraph P { "a.b-c" -> "x y" }`); err != nil {
+		t.Errorf("expected dotted and spaced IDs to parse, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Context keys the engine sets after every stage, and the graph attributes
+// it mirrors at the start of a run.
+var engineKeys = []string{"outcome", "preferred_label", "graph.goal", "graph.label"}
+
+var (
+	producedKeysMu sync.RWMutex
+	// producedKeys lists the context keys each handler type may write.
+	// Handler types missing from the map are treated as able to write
+	// anything.
+	producedKeys = map[string][]string{
+		"start":              nil,
+		"exit":               nil,
+		"conditional":        nil,
+		"notify":             nil,
+		"stack.manager_loop": nil,
+		"assert":             nil,
+		"codergen":           {"last_stage", "last_response"},
+		"wait.human":         {"human.gate.selected", "human.gate.label"},
+		"parallel":           {"parallel.results", "parallel.*"},
+		"parallel.fan_in":    {"parallel.fan_in.complete", "parallel.fan_in.output", "parallel.fan_in.outcome", "parallel.fan_in.best"},
+		"tool":               {"tool.output", "tool.stderr", "tool.exit_code"},
+	}
+)
+
+// RegisterProducedKeys declares the context keys a handler type writes, so
+// validation can check that conditions only test keys some upstream stage
+// produces. Keys may be glob patterns such as "lint.*". Handlers that write
+// keys depending on their input should instead document a "produces" node
+// attribute for pipeline authors to set.
+func RegisterProducedKeys(handlerType string, keys ...string) {
+	producedKeysMu.Lock()
+	defer producedKeysMu.Unlock()
+	producedKeys[handlerType] = append([]string(nil), keys...)
+}
+
+// ProducedKeys returns the context keys node may write: those registered for
+// its handler type, the answer keys of a human gate, and any listed in its
+// comma-separated "produces" attribute. known is false if the handler type
+// has no registration and the node doesn't declare any keys, meaning it may
+// write anything.
+func ProducedKeys(node *Node) (keys []string, known bool) {
+	producedKeysMu.RLock()
+	registered, ok := producedKeys[node.HandlerType()]
+	producedKeysMu.RUnlock()
+	keys = append(keys, registered...)
+	if node.HandlerType() == "wait.human" {
+		fields, _ := node.HumanFields()
+		for _, f := range fields {
+			keys = append(keys, f.Key)
+		}
+	}
+
+	declared := node.Attrs["produces"]
+	for _, key := range strings.Split(declared, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, strings.TrimPrefix(key, "context."))
+		}
+	}
+	return keys, ok || strings.TrimSpace(declared) != ""
+}
+
+// ParseSetClause recognizes the condition clause "set(pattern)", which is
+// true when the previous stage's context updates include a key matching the
+// glob pattern. A "context." prefix on the pattern is optional.
+func ParseSetClause(clause string) (pattern string, ok bool) {
+	clause = strings.TrimSpace(clause)
+	if !strings.HasPrefix(clause, "set(") || !strings.HasSuffix(clause, ")") {
+		return "", false
+	}
+	pattern = strings.TrimSpace(clause[len("set(") : len(clause)-1])
+	return strings.TrimPrefix(pattern, "context."), true
+}
+
+// Updated reports whether the outcome's context updates include a key
+// matching the glob pattern. Keys a handler sets directly on the Context
+// rather than through ContextUpdates are not seen.
+func (o *Outcome) Updated(pattern string) bool {
+	if o == nil {
+		return false
+	}
+	pattern = strings.TrimPrefix(pattern, "context.")
+	for key := range o.ContextUpdates {
+		if keyMatches(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyMatches reports whether a context key matches a glob pattern.
+func keyMatches(pattern, key string) bool {
+	matched, err := path.Match(pattern, key)
+	return err == nil && matched
+}
+
+// conditionContextKeys returns the context keys or patterns a condition
+// tests: "context."-prefixed keys of comparisons and bare clauses, and the
+// patterns of set() clauses, all without the "context." prefix.
+func conditionContextKeys(condition string) []string {
+	var keys []string
+	for _, clause := range strings.Split(condition, "&&") {
+		clause = strings.TrimSpace(clause)
+		if pattern, ok := ParseSetClause(clause); ok {
+			keys = append(keys, pattern)
+			continue
+		}
+		key := clause
+		if idx := strings.IndexAny(clause, "!="); idx >= 0 {
+			key = strings.TrimSpace(clause[:idx])
+		}
+		if strings.HasPrefix(key, "context.") {
+			keys = append(keys, strings.TrimPrefix(key, "context."))
+		}
+	}
+	return keys
+}
+
+// keysOverlap reports whether a tested key or pattern can match a produced
+// key or pattern. A path into a produced value, such as results[0].status
+// for results, matches it.
+func keysOverlap(tested, produced string) bool {
+	if strings.HasPrefix(tested, produced+".") || strings.HasPrefix(tested, produced+"[") {
+		return true
+	}
+	return tested == produced || keyMatches(tested, produced) || keyMatches(produced, tested)
+}
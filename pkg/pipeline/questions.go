@@ -0,0 +1,264 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of HumanQuestion.
+const (
+	QuestionTypeYesNo          = "yes_no"
+	QuestionTypeMultipleChoice = "multiple_choice"
+	QuestionTypeFreeform       = "freeform"
+	QuestionTypeConfirmation   = "confirmation"
+)
+
+var (
+	// ErrQuestionNotFound is returned by QuestionQueue.Answer for a question
+	// that isn't pending.
+	ErrQuestionNotFound = errors.New("question not found")
+	// ErrQuestionTimeout is returned by QuestionQueue.Ask when no answer
+	// arrives in time.
+	ErrQuestionTimeout = errors.New("question timed out")
+	// ErrQuestionsClosed is returned by QuestionQueue.Ask once the queue is
+	// closed.
+	ErrQuestionsClosed = errors.New("question queue closed")
+)
+
+// HumanQuestion is a question a human gate is waiting on, as served by the
+// pipeline server.
+type HumanQuestion struct {
+	ID      string        `json:"id"`
+	Stage   string        `json:"stage"`
+	Text    string        `json:"text"`
+	Type    string        `json:"type"`
+	Options []HumanOption `json:"options,omitempty"`
+	Default string        `json:"default,omitempty"`
+	AskedAt time.Time     `json:"asked_at"`
+}
+
+// HumanOption is a choice of a multiple_choice HumanQuestion.
+type HumanOption struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+// HumanAnswer answers a HumanQuestion. Value is an option key or label for
+// a multiple_choice question, yes or no (or y, n, true, false) for a yes_no
+// or confirmation question, and the text for a freeform one.
+type HumanAnswer struct {
+	Value string `json:"value"`
+}
+
+// QuestionEvent reports a change to a QuestionQueue: a question was
+// "asked", "answered", or "expired".
+type QuestionEvent struct {
+	Type     string        `json:"type"`
+	Question HumanQuestion `json:"question"`
+}
+
+// QuestionQueue holds the questions of one run's human gates until they are
+// answered. Ask blocks the gate; Pending, Answer, and Subscribe serve the
+// questions to whoever answers them.
+type QuestionQueue struct {
+	mu      sync.Mutex
+	next    int
+	pending []*queuedQuestion
+	subs    map[chan QuestionEvent]struct{}
+	closed  bool
+	done    chan struct{}
+}
+
+type queuedQuestion struct {
+	question HumanQuestion
+	answer   chan HumanAnswer
+}
+
+// NewQuestionQueue returns an empty queue.
+func NewQuestionQueue() *QuestionQueue {
+	return &QuestionQueue{
+		subs: make(map[chan QuestionEvent]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Ask queues q and waits for its answer. A timeout of zero waits until the
+// question is answered or the queue is closed.
+func (qq *QuestionQueue) Ask(q HumanQuestion, timeout time.Duration) (HumanAnswer, error) {
+	qq.mu.Lock()
+	if qq.closed {
+		qq.mu.Unlock()
+		return HumanAnswer{}, ErrQuestionsClosed
+	}
+	qq.next++
+	q.ID = fmt.Sprintf("q%d", qq.next)
+	q.AskedAt = time.Now()
+	entry := &queuedQuestion{question: q, answer: make(chan HumanAnswer, 1)}
+	qq.pending = append(qq.pending, entry)
+	qq.publish(QuestionEvent{Type: "asked", Question: q})
+	qq.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case a := <-entry.answer:
+		return a, nil
+	case <-expired:
+		qq.mu.Lock()
+		defer qq.mu.Unlock()
+		// The answer may have arrived while the lock was contended.
+		select {
+		case a := <-entry.answer:
+			return a, nil
+		default:
+		}
+		qq.remove(q.ID)
+		qq.publish(QuestionEvent{Type: "expired", Question: q})
+		return HumanAnswer{}, ErrQuestionTimeout
+	case <-qq.done:
+		return HumanAnswer{}, ErrQuestionsClosed
+	}
+}
+
+// Pending returns the unanswered questions in the order they were asked.
+func (qq *QuestionQueue) Pending() []HumanQuestion {
+	qq.mu.Lock()
+	defer qq.mu.Unlock()
+	questions := make([]HumanQuestion, 0, len(qq.pending))
+	for _, entry := range qq.pending {
+		questions = append(questions, entry.question)
+	}
+	return questions
+}
+
+// Answer answers the pending question id. It returns ErrQuestionNotFound if
+// there is no such question, and an error if a is not a valid answer to it.
+// The answer Ask returns is normalized as HumanAnswer describes: an option
+// key, or "yes" or "no".
+func (qq *QuestionQueue) Answer(id string, a HumanAnswer) error {
+	qq.mu.Lock()
+	defer qq.mu.Unlock()
+	var entry *queuedQuestion
+	for _, e := range qq.pending {
+		if e.question.ID == id {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return ErrQuestionNotFound
+	}
+	a, err := normalizeAnswer(entry.question, a)
+	if err != nil {
+		return err
+	}
+	qq.remove(id)
+	entry.answer <- a
+	qq.publish(QuestionEvent{Type: "answered", Question: entry.question})
+	return nil
+}
+
+// Subscribe returns a channel of the queue's events from now on, and a
+// function that ends the subscription. The channel is closed when the queue
+// is. A subscriber that falls behind misses events; Pending has the
+// current state.
+func (qq *QuestionQueue) Subscribe() (<-chan QuestionEvent, func()) {
+	qq.mu.Lock()
+	defer qq.mu.Unlock()
+	ch := make(chan QuestionEvent, 16)
+	if qq.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	qq.subs[ch] = struct{}{}
+	return ch, func() {
+		qq.mu.Lock()
+		defer qq.mu.Unlock()
+		if _, ok := qq.subs[ch]; ok {
+			delete(qq.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close fails the pending questions with ErrQuestionsClosed, and any asked
+// later, and ends all subscriptions. It is safe to call more than once.
+func (qq *QuestionQueue) Close() {
+	qq.mu.Lock()
+	defer qq.mu.Unlock()
+	if qq.closed {
+		return
+	}
+	qq.closed = true
+	qq.pending = nil
+	close(qq.done)
+	for ch := range qq.subs {
+		close(ch)
+	}
+	qq.subs = nil
+}
+
+// publish sends e to the subscribers. qq.mu must be held.
+func (qq *QuestionQueue) publish(e QuestionEvent) {
+	for ch := range qq.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// remove takes question id off the pending list. qq.mu must be held.
+func (qq *QuestionQueue) remove(id string) {
+	for i, entry := range qq.pending {
+		if entry.question.ID == id {
+			qq.pending = append(qq.pending[:i], qq.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// normalizeAnswer checks that a answers q, and returns it in canonical
+// form: the option key of a multiple_choice answer, and "yes" or "no" for a
+// yes_no or confirmation one.
+func normalizeAnswer(q HumanQuestion, a HumanAnswer) (HumanAnswer, error) {
+	switch q.Type {
+	case QuestionTypeMultipleChoice:
+		var keys []string
+		for _, opt := range q.Options {
+			if strings.EqualFold(opt.Key, a.Value) || strings.EqualFold(opt.Label, a.Value) {
+				return HumanAnswer{Value: opt.Key}, nil
+			}
+			keys = append(keys, opt.Key)
+		}
+		return a, fmt.Errorf("answer %q is not one of %s", a.Value, strings.Join(keys, ", "))
+	case QuestionTypeYesNo, QuestionTypeConfirmation:
+		yes, ok := ParseYesNo(a.Value)
+		if !ok {
+			return a, fmt.Errorf("answer %q is not yes or no", a.Value)
+		}
+		if yes {
+			return HumanAnswer{Value: "yes"}, nil
+		}
+		return HumanAnswer{Value: "no"}, nil
+	}
+	return a, nil
+}
+
+// ParseYesNo parses a yes/no answer: yes, y, or true, and no, n, or false,
+// in any case.
+func ParseYesNo(s string) (yes, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "y", "true":
+		return true, true
+	case "no", "n", "false":
+		return false, true
+	}
+	return false, false
+}
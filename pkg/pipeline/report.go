@@ -0,0 +1,230 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Report file names, written to a run's logs directory.
+const (
+	ReportMarkdownFile = "report.md"
+	ReportHTMLFile     = "report.html"
+)
+
+// reportArtifacts are the stage files a report links to, in link order.
+var reportArtifacts = []string{"prompt.md", "response.md", "status.json"}
+
+// WriteReport summarizes the run in dir as report.md and report.html in the
+// same directory: a table of stages with their outcomes, durations, retries,
+// and usage, links to each stage's prompt and response, and the state of the
+// run's goal gates. The runner writes one when a run ends; calling it again
+// regenerates both from the logs.
+func WriteReport(dir string) error {
+	run, err := LoadRunLog(dir)
+	if err != nil {
+		return err
+	}
+	var md, page bytes.Buffer
+	if err := WriteReportMarkdown(&md, run); err != nil {
+		return err
+	}
+	if err := WriteReportHTML(&page, run); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, ReportMarkdownFile), md.Bytes()); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	if err := writeFile(filepath.Join(dir, ReportHTMLFile), page.Bytes()); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
+
+// reportLink is a stage artifact, by path relative to the run's logs
+// directory.
+type reportLink struct {
+	Name string
+	Path string
+}
+
+// reportStage is a StageLog with its cells formatted for a report.
+type reportStage struct {
+	StageLog
+	Input, Output, Cost string
+	Links               []reportLink
+}
+
+func newReportStage(dir string, s StageLog) reportStage {
+	rs := reportStage{StageLog: s, Input: "-", Output: "-", Cost: "-"}
+	if rs.Duration == "" {
+		rs.Duration = "-"
+	}
+	if s.Usage != nil {
+		rs.Input = fmt.Sprint(s.Usage.InputTokens)
+		rs.Output = fmt.Sprint(s.Usage.OutputTokens)
+		if s.Usage.CostUSD > 0 {
+			rs.Cost = fmt.Sprintf("$%.4f", s.Usage.CostUSD)
+		}
+	}
+	stageDir := s.LogDir(dir)
+	for _, name := range reportArtifacts {
+		path := filepath.Join(stageDir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		rs.Links = append(rs.Links, reportLink{
+			Name: strings.TrimSuffix(name, filepath.Ext(name)),
+			Path: filepath.ToSlash(rel),
+		})
+	}
+	return rs
+}
+
+// reportData is what both report formats render.
+type reportData struct {
+	*RunLog
+	Title  string
+	Cost   string
+	Stages []reportStage
+}
+
+func newReportData(run *RunLog) reportData {
+	data := reportData{RunLog: run, Title: run.Name}
+	if data.Title == "" {
+		data.Title = filepath.Base(run.Dir)
+	}
+	if run.Usage.CostUSD > 0 {
+		data.Cost = fmt.Sprintf("$%.4f", run.Usage.CostUSD)
+	}
+	for _, s := range run.Stages {
+		data.Stages = append(data.Stages, newReportStage(run.Dir, s))
+	}
+	return data
+}
+
+// WriteReportMarkdown writes run's report as Markdown.
+func WriteReportMarkdown(w io.Writer, run *RunLog) error {
+	data := newReportData(run)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Run %s\n\n", mdText(data.Title))
+	if run.Goal != "" {
+		fmt.Fprintf(&b, "**Goal:** %s\n\n", mdText(run.Goal))
+	}
+	summary := []string{fmt.Sprintf("**Status:** %s", run.Status)}
+	if run.Duration != "" {
+		summary = append(summary, fmt.Sprintf("**Duration:** %s", run.Duration))
+	}
+	summary = append(summary, fmt.Sprintf("**Stages:** %d", len(run.Stages)))
+	if run.Usage.TotalTokens > 0 {
+		summary = append(summary, fmt.Sprintf("**Tokens:** %d in / %d out", run.Usage.InputTokens, run.Usage.OutputTokens))
+	}
+	if data.Cost != "" {
+		summary = append(summary, fmt.Sprintf("**Cost:** %s", data.Cost))
+	}
+	b.WriteString(strings.Join(summary, " · ") + "\n\n")
+	if run.Error != "" {
+		fmt.Fprintf(&b, "**Error:** %s\n\n", mdText(run.Error))
+	}
+
+	if len(run.GoalGates) > 0 {
+		b.WriteString("## Goal gates\n\n| Gate | Status | Satisfied |\n|---|---|---|\n")
+		for _, g := range run.GoalGates {
+			status := string(g.Status)
+			if status == "" {
+				status = "not run"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", mdText(g.ID), status, yesNo(g.Satisfied))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Stages\n\n| Stage | Outcome | Duration | Retries | Input tokens | Output tokens | Cost | Artifacts |\n|---|---|---|---|---:|---:|---:|---|\n")
+	for _, s := range data.Stages {
+		var links []string
+		for _, l := range s.Links {
+			links = append(links, fmt.Sprintf("[%s](%s)", l.Name, l.Path))
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %d | %s | %s | %s | %s |\n",
+			mdText(s.Path()), s.Status, s.Duration, s.Retries, s.Input, s.Output, s.Cost, strings.Join(links, " · "))
+	}
+
+	var failures []reportStage
+	for _, s := range data.Stages {
+		if s.FailureReason != "" {
+			failures = append(failures, s)
+		}
+	}
+	if len(failures) > 0 {
+		b.WriteString("\n## Failures\n\n")
+		for _, s := range failures {
+			fmt.Fprintf(&b, "- **%s:** %s\n", mdText(s.Path()), mdText(s.FailureReason))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mdText flattens s onto one line and escapes the characters that would
+// break a Markdown table cell.
+func mdText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`).Replace(s)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"yesNo": yesNo}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Run {{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+td.num { text-align: right; }
+.success, .partial_success, .yes { color: #1a7f37; }
+.fail, .no { color: #cf222e; }
+.retry, .skipped { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>Run {{.Title}}</h1>
+{{if .Goal}}<p><strong>Goal:</strong> {{.Goal}}</p>
+{{end}}<p><strong>Status:</strong> <span class="{{.Status}}">{{.Status}}</span>
+{{- if .Duration}} · <strong>Duration:</strong> {{.Duration}}{{end}} · <strong>Stages:</strong> {{len .Stages}}
+{{- if .Usage.TotalTokens}} · <strong>Tokens:</strong> {{.Usage.InputTokens}} in / {{.Usage.OutputTokens}} out{{end}}
+{{- if .Cost}} · <strong>Cost:</strong> {{.Cost}}{{end}}</p>
+{{if .Error}}<p><strong>Error:</strong> {{.Error}}</p>
+{{end}}{{if .GoalGates}}<h2>Goal gates</h2>
+<table>
+<tr><th>Gate</th><th>Status</th><th>Satisfied</th></tr>
+{{range .GoalGates}}<tr><td>{{.ID}}</td><td class="{{.Status}}">{{or .Status "not run"}}</td><td class="{{yesNo .Satisfied}}">{{yesNo .Satisfied}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Stages</h2>
+<table>
+<tr><th>Stage</th><th>Outcome</th><th>Duration</th><th>Retries</th><th>Input tokens</th><th>Output tokens</th><th>Cost</th><th>Artifacts</th></tr>
+{{range .Stages}}<tr><td>{{.Path}}</td><td class="{{.Status}}">{{.Status}}{{if .FailureReason}}<br><small>{{.FailureReason}}</small>{{end}}</td><td>{{.Duration}}</td><td class="num">{{.Retries}}</td><td class="num">{{.Input}}</td><td class="num">{{.Output}}</td><td class="num">{{.Cost}}</td><td>{{range $i, $l := .Links}}{{if $i}} · {{end}}<a href="{{$l.Path}}">{{$l.Name}}</a>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteReportHTML writes run's report as a standalone HTML page.
+func WriteReportHTML(w io.Writer, run *RunLog) error {
+	return reportTemplate.Execute(w, newReportData(run))
+}
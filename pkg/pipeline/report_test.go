@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	src := `digraph test {
+		goal="Ship <it> | fast"
+		start [shape=Mdiamond]
+		a [label="A", goal_gate=true]
+		b [label="B", max_retries=2]
+		exit [shape=Msquare]
+		start -> a -> b -> exit
+	}`
+	dir := t.TempDir()
+	resolver := &staticResolver{
+		handler: &simpleHandler{response: "ok"},
+		special: map[string]Handler{"b": &retryHandler{attemptsBeforeSuccess: 1}},
+	}
+	runner := NewRunner(resolver, WithLogsRoot(dir))
+	result, err := runner.RunFromSource(src)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.LogsRoot != dir {
+		t.Errorf("expected LogsRoot %s, got %q", dir, result.LogsRoot)
+	}
+	// The runner writes the report when the run ends.
+	if _, err := os.Stat(filepath.Join(dir, ReportMarkdownFile)); err != nil {
+		t.Fatalf("runner did not write the report: %v", err)
+	}
+
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "prompt.md"), []byte("do A"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "status.json"), []byte(`{"outcome": "success", "usage": {"input_tokens": 30, "output_tokens": 12, "total_tokens": 42, "cost_usd": 0.5}}`), 0o644)
+	if err := WriteReport(dir); err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+
+	md, err := os.ReadFile(filepath.Join(dir, ReportMarkdownFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Run test",
+		`**Goal:** Ship <it> \| fast`,
+		"**Status:** success",
+		"**Cost:** $0.5000",
+		"| a | success | yes |",
+		"| a | success |",
+		"| 30 | 12 | $0.5000 | [prompt](a/prompt.md) · [status](a/status.json) |",
+		"| b | success |",
+		"| 1 |",
+	} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("markdown report missing %q:\n%s", want, md)
+		}
+	}
+
+	page, err := os.ReadFile(filepath.Join(dir, ReportHTMLFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>Run test</title>",
+		"Ship &lt;it&gt; | fast",
+		`<a href="a/prompt.md">prompt</a>`,
+		`<td class="yes">yes</td>`,
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("HTML report missing %q:\n%s", want, page)
+		}
+	}
+}
+
+func TestLoadRunLogGoalGates(t *testing.T) {
+	src := `digraph test {
+		start [shape=Mdiamond]
+		a [label="A", goal_gate=true]
+		b [label="B"]
+		c [label="C", goal_gate=true]
+		exit [shape=Msquare]
+		start -> a -> b -> c -> exit
+	}`
+	dir := t.TempDir()
+	resolver := &staticResolver{
+		handler: &simpleHandler{response: "ok"},
+		special: map[string]Handler{"c": &failHandler{}},
+	}
+	runner := NewRunner(resolver, WithLogsRoot(dir))
+	runner.RunFromSource(src)
+
+	run, err := LoadRunLog(dir)
+	if err != nil {
+		t.Fatalf("LoadRunLog: %v", err)
+	}
+	if len(run.GoalGates) != 2 {
+		t.Fatalf("expected 2 goal gates, got %+v", run.GoalGates)
+	}
+	if a := run.GoalGates[0]; a.ID != "a" || a.Status != StatusSuccess || !a.Satisfied {
+		t.Errorf("unexpected gate a: %+v", a)
+	}
+	if c := run.GoalGates[1]; c.ID != "c" || c.Status != StatusFail || c.Satisfied {
+		t.Errorf("unexpected gate c: %+v", c)
+	}
+}
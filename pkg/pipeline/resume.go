@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+// resumeState is where a partial re-run starts: a node, and the checkpoint
+// of an earlier run that supplies the context and upstream completions.
+type resumeState struct {
+	start      string
+	checkpoint *Checkpoint
+}
+
+// RunFrom re-executes the part of graph downstream of nodeID, seeding the
+// context from cp, a checkpoint of an earlier run of the same pipeline.
+// Nodes cp records as completed that are not downstream of nodeID are not
+// run again and keep the status cp records for them. See ValidateResume for
+// the checks made first.
+func (e *Engine) RunFrom(graph *Graph, nodeID string, cp *Checkpoint) (*RunResult, error) {
+	if err := checkNodeIDs(graph); err != nil {
+		return nil, err
+	}
+	if err := ValidateResume(graph, nodeID, cp); err != nil {
+		return nil, err
+	}
+	seed := &resumeState{start: nodeID, checkpoint: cp}
+	if graph.Schedule == ScheduleDAG {
+		return e.runDAG(graph.SubgraphFrom(nodeID), seed)
+	}
+	return e.run(graph, seed)
+}
+
+// ValidateResume checks that a run can start at nodeID from cp: the node
+// exists, no goal gate upstream of it, which the run would not repeat, ended
+// unsuccessfully in the checkpointed run, and unless it is the start node, at
+// least one of its predecessors completed there, so the context it expects
+// is there.
+func ValidateResume(graph *Graph, nodeID string, cp *Checkpoint) error {
+	node, ok := graph.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("cannot start from %q: no such node in pipeline", nodeID)
+	}
+	if cp == nil {
+		return fmt.Errorf("cannot start from %q: no checkpoint", nodeID)
+	}
+	downstream := graph.SubgraphFrom(nodeID)
+	for _, id := range cp.CompletedNodes {
+		gate := graph.Nodes[id]
+		if _, rerun := downstream.Nodes[id]; rerun || gate == nil || !gate.GoalGate {
+			continue
+		}
+		if status := checkpointStatus(cp, id); status != StatusSuccess && status != StatusPartialSuccess {
+			return fmt.Errorf("cannot start from %q: goal gate %q upstream of it ended %s in the checkpoint; start from %q or earlier", nodeID, id, status, id)
+		}
+	}
+	if node.Shape == "Mdiamond" {
+		return nil
+	}
+	preds := graph.Predecessors(nodeID)
+	if len(preds) == 0 {
+		return fmt.Errorf("cannot start from %q: it has no incoming edges", nodeID)
+	}
+	completed := make(map[string]bool, len(cp.CompletedNodes))
+	for _, id := range cp.CompletedNodes {
+		completed[id] = true
+	}
+	for _, id := range preds {
+		if completed[id] {
+			return nil
+		}
+	}
+	sort.Strings(preds)
+	return fmt.Errorf("cannot start from %q: none of its dependencies (%s) completed in the checkpoint", nodeID, strings.Join(preds, ", "))
+}
+
+// seed restores the checkpointed context into ctx and returns the
+// upstream completions: the checkpoint's completed nodes, less those
+// downstream of the start node, each with the status it ended with. Values the
+// scrubber replaced, by the checkpoint's ScrubbedKeys or, for checkpoints
+// without them, by their placeholders, are left out rather than restored,
+// and the context log notes each one.
+func (s *resumeState) seed(graph *Graph, ctx *Context) ([]string, map[string]*Outcome) {
+	outcomes := make(map[string]*Outcome)
+	if s == nil {
+		return nil, outcomes
+	}
+	scrubbed := make(map[string]bool, len(s.checkpoint.ScrubbedKeys))
+	for _, k := range s.checkpoint.ScrubbedKeys {
+		scrubbed[k] = true
+	}
+	values := make(map[string]interface{}, len(s.checkpoint.ContextValues))
+	var dropped []string
+	for k, v := range s.checkpoint.ContextValues {
+		if scrubbed[k] || containsScrubbed(v) {
+			dropped = append(dropped, k)
+			continue
+		}
+		values[k] = v
+	}
+	ctx.ApplyUpdates(values)
+	for _, entry := range s.checkpoint.Logs {
+		ctx.AppendLog(entry)
+	}
+	sort.Strings(dropped)
+	for _, k := range dropped {
+		ctx.AppendLog(fmt.Sprintf("resume: %s was scrubbed from the checkpoint and is not restored", k))
+	}
+	downstream := graph.SubgraphFrom(s.start)
+	var completed []string
+	for _, id := range s.checkpoint.CompletedNodes {
+		if _, rerun := downstream.Nodes[id]; rerun {
+			continue
+		}
+		completed = append(completed, id)
+		outcomes[id] = &Outcome{Status: checkpointStatus(s.checkpoint, id), Notes: "restored from checkpoint"}
+	}
+	return completed, outcomes
+}
+
+// checkpointStatus returns the status cp records for completed node id.
+// Checkpoints written before statuses were recorded count it a success.
+func checkpointStatus(cp *Checkpoint, id string) StageStatus {
+	if status, ok := cp.NodeStatuses[id]; ok {
+		return status
+	}
+	return StatusSuccess
+}
+
+// containsScrubbed reports whether v, or any value nested in its maps and
+// slices, is secrets.ScrubbedValue.
+func containsScrubbed(v interface{}) bool {
+	switch val := v.(type) {
+	case string:
+		return val == secrets.ScrubbedValue
+	case map[string]interface{}:
+		for _, item := range val {
+			if containsScrubbed(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if containsScrubbed(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
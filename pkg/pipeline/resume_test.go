@@ -0,0 +1,190 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
+)
+
+func TestRunFrom(t *testing.T) {
+	for _, schedule := range []string{ScheduleWalk, ScheduleDAG} {
+		t.Run(schedule, func(t *testing.T) {
+			graph, err := Parse(`digraph G {
+				schedule = "` + schedule + `"
+				start [shape=Mdiamond]
+				a     [label="A"]
+				b     [label="B"]
+				c     [label="C"]
+				done  [shape=Msquare]
+				start -> a -> b -> c -> done
+			}`)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			logsRoot := t.TempDir()
+			handler := &countingHandler{calls: make(map[string]int)}
+			engine := NewEngine(EngineConfig{LogsRoot: logsRoot}, &staticResolver{handler: handler}, nil)
+			if _, err := engine.Run(graph); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			cp, err := LoadCheckpoint(filepath.Join(logsRoot, "checkpoint.json"))
+			if err != nil {
+				t.Fatalf("LoadCheckpoint: %v", err)
+			}
+
+			handler.calls = make(map[string]int)
+			result, err := engine.RunFrom(graph, "b", cp)
+			if err != nil {
+				t.Fatalf("RunFrom failed: %v", err)
+			}
+			if handler.calls["start"] != 0 || handler.calls["a"] != 0 {
+				t.Errorf("expected upstream stages to be skipped, got %v", handler.calls)
+			}
+			if handler.calls["b"] != 1 || handler.calls["c"] != 1 {
+				t.Errorf("expected b and c to run once, got %v", handler.calls)
+			}
+			if result.Status != StatusSuccess {
+				t.Errorf("expected success, got %s", result.Status)
+			}
+			if !slices.Contains(result.CompletedNodes, "a") || result.NodeOutcomes["a"] == nil {
+				t.Errorf("expected a to be satisfied by the checkpoint, got %v", result.CompletedNodes)
+			}
+		})
+	}
+}
+
+func TestRunFromSeedsContext(t *testing.T) {
+	graph, err := Parse(`digraph G {
+		start [shape=Mdiamond]
+		a     [label="A"]
+		b     [label="B"]
+		done  [shape=Msquare]
+		start -> a -> b -> done
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var seen interface{}
+	handler := &ctxCapture{key: "a.done", seen: &seen}
+	engine := NewEngine(EngineConfig{}, &staticResolver{handler: &simpleHandler{}, special: map[string]Handler{"b": handler}}, nil)
+	cp := &Checkpoint{
+		CompletedNodes: []string{"start", "a"},
+		ContextValues:  map[string]interface{}{"a.done": true},
+	}
+	if _, err := engine.RunFrom(graph, "b", cp); err != nil {
+		t.Fatalf("RunFrom failed: %v", err)
+	}
+	if seen != true {
+		t.Errorf("expected b to see the checkpointed context, got %v", seen)
+	}
+
+	// Scrubbed values are not restored as placeholders.
+	cp.ContextValues = map[string]interface{}{
+		"a.done":    true,
+		"api_token": secrets.ScrubbedValue,
+		"service":   map[string]interface{}{"password": secrets.ScrubbedValue},
+		"db":        "spilled",
+	}
+	cp.ScrubbedKeys = []string{"api_token", "db"}
+	for _, key := range []string{"api_token", "service", "db"} {
+		handler.key = key
+		if _, err := engine.RunFrom(graph, "b", cp); err != nil {
+			t.Fatalf("RunFrom failed: %v", err)
+		}
+		if seen != nil {
+			t.Errorf("expected scrubbed %s to be left unset, got %v", key, seen)
+		}
+	}
+}
+
+// ctxCapture records the value of a context key when it runs.
+type ctxCapture struct {
+	key  string
+	seen *interface{}
+}
+
+func (h *ctxCapture) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	*h.seen, _ = ctx.Get(h.key)
+	return &Outcome{Status: StatusSuccess}, nil
+}
+
+func TestValidateResume(t *testing.T) {
+	graph, err := Parse(`digraph G {
+		start [shape=Mdiamond]
+		a     [label="A"]
+		b     [label="B"]
+		done  [shape=Msquare]
+		start -> a -> b -> done
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	cp := &Checkpoint{CompletedNodes: []string{"start"}}
+	if err := ValidateResume(graph, "a", cp); err != nil {
+		t.Errorf("expected a to be resumable, got %v", err)
+	}
+	if err := ValidateResume(graph, "start", &Checkpoint{}); err != nil {
+		t.Errorf("expected the start node to be resumable, got %v", err)
+	}
+	if err := ValidateResume(graph, "b", cp); err == nil || !strings.Contains(err.Error(), "dependencies (a)") {
+		t.Errorf("expected a missing dependency error, got %v", err)
+	}
+	if err := ValidateResume(graph, "nope", cp); err == nil || !strings.Contains(err.Error(), "no such node") {
+		t.Errorf("expected an unknown node error, got %v", err)
+	}
+}
+
+func TestRunFromRestoresUpstreamStatuses(t *testing.T) {
+	graph, err := Parse(`digraph G {
+		start [shape=Mdiamond]
+		a     [label="A"]
+		gate  [label="Gate", goal_gate=true]
+		b     [label="B"]
+		done  [shape=Msquare]
+		start -> a -> gate -> b -> done
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	logsRoot := t.TempDir()
+	engine := NewEngine(EngineConfig{LogsRoot: logsRoot}, &staticResolver{
+		handler: &simpleHandler{},
+		special: map[string]Handler{"a": &partialHandler{}},
+	}, nil)
+	if _, err := engine.Run(graph); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	cp, err := LoadCheckpoint(filepath.Join(logsRoot, "checkpoint.json"))
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	if cp.NodeStatuses["a"] != StatusPartialSuccess {
+		t.Fatalf("expected the checkpoint to record a's status, got %v", cp.NodeStatuses)
+	}
+
+	result, err := engine.RunFrom(graph, "b", cp)
+	if err != nil {
+		t.Fatalf("RunFrom failed: %v", err)
+	}
+	if o := result.NodeOutcomes["a"]; o == nil || o.Status != StatusPartialSuccess {
+		t.Errorf("expected a's partial success restored, got %+v", o)
+	}
+
+	cp.NodeStatuses["gate"] = StatusFail
+	if err := ValidateResume(graph, "b", cp); err == nil || !strings.Contains(err.Error(), `goal gate "gate"`) {
+		t.Errorf("expected a failed upstream goal gate to be rejected, got %v", err)
+	}
+	if err := ValidateResume(graph, "gate", cp); err != nil {
+		t.Errorf("expected a re-run of the gate itself to be allowed, got %v", err)
+	}
+}
+
+// partialHandler partially succeeds.
+type partialHandler struct{}
+
+func (h *partialHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	return &Outcome{Status: StatusPartialSuccess}, nil
+}
@@ -0,0 +1,254 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+)
+
+// RunRunning is the RunLog status of a run that hasn't finished.
+const RunRunning = "running"
+
+// RunLog summarizes a run from the files it left in its logs directory.
+type RunLog struct {
+	Dir         string     `json:"dir"`
+	Name        string     `json:"name,omitempty"`
+	Goal        string     `json:"goal,omitempty"`
+	Status      string     `json:"status"`
+	Duration    string     `json:"duration,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CurrentNode string     `json:"current_node,omitempty"`
+	Stages      []StageLog `json:"stages"`
+	Usage       Usage      `json:"usage"`
+	// GoalGates lists the run's goal_gate stages by ID.
+	GoalGates []GoalGateLog `json:"goal_gates,omitempty"`
+}
+
+// GoalGateLog is the state of a goal gate: satisfied once its stage has
+// succeeded or partially succeeded. A gate whose stage hasn't run has no
+// status.
+type GoalGateLog struct {
+	ID        string      `json:"id"`
+	Status    StageStatus `json:"status,omitempty"`
+	Satisfied bool        `json:"satisfied"`
+}
+
+// StageLog is the recorded outcome of one stage. A stage that ran more than
+// once, in a loop, reports its last outcome and duration and the retries of
+// every run. A node that ran in several parallel branches has a StageLog for
+// each, told apart by Branch.
+type StageLog struct {
+	ID string `json:"id"`
+	// Branch is the logs directory of the parallel branch the stage ran in,
+	// relative to the run's (see BranchLogsRoot); empty outside a branch.
+	Branch        string      `json:"branch,omitempty"`
+	Status        StageStatus `json:"status"`
+	Duration      string      `json:"duration,omitempty"`
+	Retries       int         `json:"retries"`
+	Notes         string      `json:"notes,omitempty"`
+	FailureReason string      `json:"failure_reason,omitempty"`
+	Usage         *Usage      `json:"usage,omitempty"`
+}
+
+// Finished reports whether the run has ended.
+func (l *RunLog) Finished() bool {
+	return l.Status != RunRunning
+}
+
+// Path names the stage within its run: its ID, prefixed with its branch if
+// it ran in one.
+func (s StageLog) Path() string {
+	if s.Branch == "" {
+		return s.ID
+	}
+	return s.Branch + "/" + s.ID
+}
+
+// LogDir returns the directory of the stage's logs in the run's logs
+// directory runDir, or "" if it has none (see StageLogDir).
+func (s StageLog) LogDir(runDir string) string {
+	if s.Branch == "" {
+		return StageLogDir(runDir, s.ID)
+	}
+	if !filepath.IsLocal(s.Branch) || !localNodeID(s.ID) {
+		return ""
+	}
+	return filepath.Join(runDir, filepath.FromSlash(s.Branch), s.ID)
+}
+
+// LoadRunLog reads a run's logs directory. Stage outcomes come from each
+// stage's status.json, and the stages, in the order they finished, their
+// durations and retries, and the run's status from the event journal, whose
+// stage events name each stage's node and branch. Stages the journal doesn't
+// cover are taken from checkpoint.json. It can be called while the run is in
+// progress; a run without a finished journal is reported as running.
+func LoadRunLog(dir string) (*RunLog, error) {
+	log := &RunLog{Dir: dir, Status: RunRunning}
+
+	cp, err := LoadLatestCheckpoint(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	entries, jerr := events.ReadJournal(filepath.Join(dir, events.JournalFile))
+	if jerr != nil && !errors.Is(jerr, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read event journal: %w", jerr)
+	}
+	if cp == nil && errors.Is(jerr, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no run found in %s: missing checkpoint.json and %s", dir, events.JournalFile)
+	}
+
+	var manifest struct {
+		Name      string   `json:"name"`
+		Goal      string   `json:"goal"`
+		GoalGates []string `json:"goal_gates"`
+	}
+	if data, err := readFile(filepath.Join(dir, "manifest.json")); err == nil {
+		json.Unmarshal(data, &manifest)
+		log.Name, log.Goal = manifest.Name, manifest.Goal
+	}
+
+	// Stages are keyed by branch and node ID, since stages in parallel
+	// branches and DAG runs start and finish interleaved.
+	type stageKey struct{ branch, id string }
+	type stageRun struct {
+		StageLog
+		started  time.Time
+		retries  int
+		finished bool
+	}
+	runs := make(map[stageKey]*stageRun)
+	var order []stageKey
+	stage := func(branch, id string) *stageRun {
+		key := stageKey{branch, id}
+		r, ok := runs[key]
+		if !ok {
+			r = &stageRun{StageLog: StageLog{ID: id, Branch: branch}}
+			runs[key] = r
+		}
+		return r
+	}
+	finish := func(r *stageRun) {
+		if !r.finished {
+			r.finished = true
+			order = append(order, stageKey{r.Branch, r.ID})
+		}
+	}
+
+	pipelineDone := false
+	for _, e := range entries {
+		switch ev := e.Typed().(type) {
+		case events.StageStarted:
+			if ev.NodeID != "" {
+				stage(ev.Branch, ev.NodeID).started = e.Timestamp
+			}
+		case events.StageRetrying:
+			if ev.NodeID != "" {
+				stage(ev.Branch, ev.NodeID).retries++
+			}
+		case events.StageCompleted:
+			if ev.NodeID != "" {
+				r := stage(ev.Branch, ev.NodeID)
+				r.Status = StatusSuccess
+				r.Duration = eventString(e.Data, "duration")
+				r.FailureReason = ""
+				r.Retries += r.retries
+				r.retries = 0
+				finish(r)
+			}
+		case events.StageFailed:
+			if ev.NodeID != "" {
+				// Failure events carry no duration, so time it from the start.
+				r := stage(ev.Branch, ev.NodeID)
+				r.Status = StatusFail
+				r.FailureReason = ev.Error
+				r.Duration = ""
+				if !r.started.IsZero() {
+					r.Duration = e.Timestamp.Sub(r.started).String()
+				}
+				r.Retries += r.retries
+				r.retries = 0
+				finish(r)
+			}
+		case events.PipelineCompleted:
+			pipelineDone = true
+			log.Status = string(StatusSuccess)
+			log.Duration = eventString(e.Data, "duration")
+		case events.PipelineFailed:
+			pipelineDone = true
+			log.Status = string(StatusFail)
+			log.Duration = eventString(e.Data, "duration")
+			log.Error = ev.Error
+		}
+	}
+
+	if cp != nil {
+		log.CurrentNode = cp.CurrentNode
+		// Completed nodes include those of parallel branches, which the
+		// journal has already placed in their branches.
+		inJournal := make(map[string]bool, len(order))
+		for _, key := range order {
+			inJournal[key.id] = true
+		}
+		for _, id := range cp.CompletedNodes {
+			if inJournal[id] {
+				continue
+			}
+			finish(stage("", id))
+		}
+		for _, key := range order {
+			if key.branch == "" {
+				r := runs[key]
+				r.Retries = max(r.Retries, cp.NodeRetries[key.id])
+			}
+		}
+	}
+
+	latest := make(map[string]StageLog)
+	for _, key := range order {
+		s := runs[key].StageLog
+		if data, err := readFile(filepath.Join(s.LogDir(dir), "status.json")); err == nil {
+			var outcome Outcome
+			if json.Unmarshal(data, &outcome) == nil && outcome.Status != "" {
+				s.Status = outcome.Status
+				s.Notes = outcome.Notes
+				s.FailureReason = outcome.FailureReason
+				s.Usage = outcome.Usage
+			}
+		}
+		if s.Status == "" {
+			s.Status = StatusSuccess
+		}
+		if s.Usage != nil {
+			log.Usage = log.Usage.Add(*s.Usage)
+		}
+		if pipelineDone && s.Status == StatusFail {
+			log.Status = string(StatusFail)
+		}
+		log.Stages = append(log.Stages, s)
+		if _, ok := latest[s.ID]; !ok || s.Branch == "" {
+			latest[s.ID] = s
+		}
+	}
+
+	for _, id := range manifest.GoalGates {
+		gate := GoalGateLog{ID: id}
+		if s, ok := latest[id]; ok {
+			gate.Status = s.Status
+			gate.Satisfied = s.Status == StatusSuccess || s.Status == StatusPartialSuccess
+		}
+		log.GoalGates = append(log.GoalGates, gate)
+	}
+	return log, nil
+}
+
+func eventString(data map[string]interface{}, key string) string {
+	if s, ok := data[key].(string); ok {
+		return s
+	}
+	return ""
+}
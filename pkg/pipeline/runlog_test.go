@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRunLog(t *testing.T) {
+	src := `digraph test {
+		start [shape=Mdiamond]
+		a [label="A", max_retries=3]
+		b [label="B"]
+		exit [shape=Msquare]
+		start -> a -> b -> exit
+	}`
+	dir := t.TempDir()
+	resolver := &staticResolver{
+		handler: &simpleHandler{response: "ok"},
+		special: map[string]Handler{"a": &retryHandler{attemptsBeforeSuccess: 2}, "b": &failHandler{}},
+	}
+	runner := NewRunner(resolver, WithLogsRoot(dir))
+	if _, err := runner.RunFromSource(src); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	// A handler-written status.json takes precedence over the events.
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "status.json"), []byte(`{"outcome": "success", "notes": "done", "usage": {"total_tokens": 42}}`), 0o644)
+
+	run, err := LoadRunLog(dir)
+	if err != nil {
+		t.Fatalf("LoadRunLog: %v", err)
+	}
+	if run.Name != "test" {
+		t.Errorf("expected name test, got %q", run.Name)
+	}
+	if run.Status != string(StatusFail) || !run.Finished() {
+		t.Errorf("expected finished fail run, got %q", run.Status)
+	}
+
+	var ids []string
+	for _, s := range run.Stages {
+		ids = append(ids, s.ID)
+	}
+	if strings.Join(ids, ",") != "start,a,b" {
+		t.Fatalf("expected stages start,a,b, got %v", ids)
+	}
+	a, b := run.Stages[1], run.Stages[2]
+	if a.Retries != 2 || a.Notes != "done" || a.Duration == "" {
+		t.Errorf("unexpected stage a: %+v", a)
+	}
+	if b.Status != StatusFail || b.FailureReason != "deliberate failure" || b.Duration == "" {
+		t.Errorf("unexpected stage b: %+v", b)
+	}
+	if run.Usage.TotalTokens != 42 {
+		t.Errorf("expected 42 total tokens, got %d", run.Usage.TotalTokens)
+	}
+}
+
+func TestLoadRunLogMissingRun(t *testing.T) {
+	if _, err := LoadRunLog(t.TempDir()); err == nil {
+		t.Fatal("expected error for a directory without a run")
+	}
+}
+
+// branchStatusHandler writes a status.json naming the branch logs directory
+// it ran under, and fails in branch b.
+type branchStatusHandler struct{}
+
+func (h *branchStatusHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	outcome := &Outcome{Status: StatusSuccess, Notes: filepath.Base(logsRoot)}
+	if filepath.Base(logsRoot) == "b" {
+		outcome = &Outcome{Status: StatusFail, Notes: "b", FailureReason: "broken in b"}
+	}
+	data, _ := json.Marshal(outcome)
+	writeFile(filepath.Join(logsRoot, node.ID, "status.json"), data)
+	return outcome, nil
+}
+
+func TestLoadRunLogParallelBranches(t *testing.T) {
+	src := `digraph test {
+		start [shape=Mdiamond]
+		fan [shape=component]
+		a [label="A"]
+		b [label="B"]
+		shared [label="Shared"]
+		join [shape=tripleoctagon]
+		exit [shape=Msquare]
+		start -> fan
+		fan -> a -> shared -> join
+		fan -> b -> shared
+		join -> exit
+	}`
+	dir := t.TempDir()
+	resolver := &staticResolver{
+		handler: &simpleHandler{response: "ok"},
+		special: map[string]Handler{"shared": &branchStatusHandler{}},
+	}
+	runner := NewRunner(resolver, WithLogsRoot(dir))
+	if _, err := runner.RunFromSource(src); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	run, err := LoadRunLog(dir)
+	if err != nil {
+		t.Fatalf("LoadRunLog: %v", err)
+	}
+	shared := make(map[string]StageLog)
+	for _, s := range run.Stages {
+		if s.ID == "shared" {
+			shared[s.Branch] = s
+		}
+	}
+	if len(shared) != 2 {
+		t.Fatalf("expected shared once per branch, got %+v", run.Stages)
+	}
+	if a := shared["fan/branches/a"]; a.Status != StatusSuccess || a.Notes != "a" || a.Duration == "" {
+		t.Errorf("unexpected shared stage in branch a: %+v", a)
+	}
+	if b := shared["fan/branches/b"]; b.Status != StatusFail || b.Notes != "b" || b.FailureReason != "broken in b" {
+		t.Errorf("unexpected shared stage in branch b: %+v", b)
+	}
+	if got, want := shared["fan/branches/b"].LogDir(dir), filepath.Join(dir, "fan", "branches", "b", "shared"); got != want {
+		t.Errorf("expected LogDir %s, got %s", want, got)
+	}
+}
+
+func TestLoadRunLogDAG(t *testing.T) {
+	src := `digraph test {
+		schedule=dag
+		start [shape=Mdiamond]
+		slow [label="Slow", max_retries=2]
+		fast [label="Fast"]
+		exit [shape=Msquare]
+		start -> slow -> exit
+		start -> fast -> exit
+	}`
+	dir := t.TempDir()
+	resolver := &staticResolver{
+		handler: &simpleHandler{response: "ok"},
+		special: map[string]Handler{"slow": &retryHandler{attemptsBeforeSuccess: 1}, "fast": &failHandler{}},
+	}
+	runner := NewRunner(resolver, WithLogsRoot(dir))
+	runner.RunFromSource(src)
+
+	run, err := LoadRunLog(dir)
+	if err != nil {
+		t.Fatalf("LoadRunLog: %v", err)
+	}
+	stages := make(map[string]StageLog)
+	for _, s := range run.Stages {
+		stages[s.ID] = s
+	}
+	if s := stages["slow"]; s.Status != StatusSuccess || s.Retries != 1 {
+		t.Errorf("unexpected slow stage: %+v", s)
+	}
+	if s := stages["fast"]; s.Status != StatusFail || s.Retries != 0 || s.FailureReason != "deliberate failure" {
+		t.Errorf("unexpected fast stage: %+v", s)
+	}
+}
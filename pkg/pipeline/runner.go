@@ -1,12 +1,16 @@
 package pipeline
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/ashka-vakil/attractor/pkg/bus"
 	"github.com/ashka-vakil/attractor/pkg/pipeline/events"
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // Runner is a high-level pipeline execution helper.
@@ -15,6 +19,14 @@ type Runner struct {
 	emitter     *events.Emitter
 	transforms  []interface{ Apply(*Graph) *Graph }
 	logsRoot    string
+	secrets     *secrets.Store
+	middleware  []Middleware
+	bus         *bus.Bus
+	scrubber    *secrets.Scrubber
+	cipher      *secrets.Cipher
+	cache       *StageCache
+	resume      *resumeState
+	ctx         context.Context
 }
 
 // RunnerOption configures a Runner.
@@ -34,6 +46,68 @@ func WithEmitter(emitter *events.Emitter) RunnerOption {
 	}
 }
 
+// WithSecrets redacts values resolved from store out of events and checkpoints.
+// Handlers that expand ${secret:NAME} references must share the same store.
+func WithSecrets(store *secrets.Store) RunnerOption {
+	return func(r *Runner) {
+		r.secrets = store
+	}
+}
+
+// WithScrubber redacts context values whose keys match s from checkpoints.
+func WithScrubber(s *secrets.Scrubber) RunnerOption {
+	return func(r *Runner) {
+		r.scrubber = s
+	}
+}
+
+// WithCheckpointCipher encrypts checkpoints with c.
+func WithCheckpointCipher(c *secrets.Cipher) RunnerOption {
+	return func(r *Runner) {
+		r.cipher = c
+	}
+}
+
+// WithStageCache skips stages whose inputs match an earlier successful run
+// recorded in c, reusing that run's outcome.
+func WithStageCache(c *StageCache) RunnerOption {
+	return func(r *Runner) {
+		r.cache = c
+	}
+}
+
+// WithResumeFrom makes the runner re-execute only the part of the pipeline
+// downstream of nodeID, seeded from cp; see Engine.RunFrom.
+func WithResumeFrom(nodeID string, cp *Checkpoint) RunnerOption {
+	return func(r *Runner) {
+		r.resume = &resumeState{start: nodeID, checkpoint: cp}
+	}
+}
+
+// WithContext stops runs between stages once ctx is done; see
+// EngineConfig.Context.
+func WithContext(ctx context.Context) RunnerOption {
+	return func(r *Runner) {
+		r.ctx = ctx
+	}
+}
+
+// WithMiddleware wraps every handler invocation in mw, outermost first.
+func WithMiddleware(mw ...Middleware) RunnerOption {
+	return func(r *Runner) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// WithBus publishes the runner's events, after redaction, to b on
+// bus.TopicPipeline. Give the same bus to an agent backend to follow agent
+// activity alongside the stages it belongs to.
+func WithBus(b *bus.Bus) RunnerOption {
+	return func(r *Runner) {
+		r.bus = b
+	}
+}
+
 // NewRunner creates a new pipeline runner.
 func NewRunner(resolver HandlerResolver, opts ...RunnerOption) *Runner {
 	r := &Runner{
@@ -43,6 +117,17 @@ func NewRunner(resolver HandlerResolver, opts ...RunnerOption) *Runner {
 	for _, opt := range opts {
 		opt(r)
 	}
+	if r.secrets != nil {
+		r.emitter.AddFilter(func(ev events.Event) events.Event {
+			if data, ok := r.secrets.RedactValue(ev.Data).(map[string]interface{}); ok && ev.Data != nil {
+				ev.Data = data
+			}
+			return ev
+		})
+	}
+	if r.bus != nil {
+		r.emitter.PublishTo(r.bus)
+	}
 	return r
 }
 
@@ -88,6 +173,26 @@ func (r *Runner) RunGraph(graph *Graph) (*RunResult, error) {
 	if err != nil {
 		return nil, err
 	}
+	if r.resume != nil {
+		if err := ValidateResume(graph, r.resume.start, r.resume.checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. Initialize logs
+	logsRoot := r.logsRoot
+	if logsRoot == "" {
+		logsRoot = filepath.Join(os.TempDir(), fmt.Sprintf("attractor-run-%d", time.Now().UnixNano()))
+	}
+	os.MkdirAll(logsRoot, 0o755)
+
+	// Journal every event of this run, after redaction, for replay.
+	journal, err := events.CreateJournal(filepath.Join(logsRoot, events.JournalFile))
+	if err != nil {
+		return nil, fmt.Errorf("create event journal: %w", err)
+	}
+	defer journal.Close()
+	defer r.emitter.Subscribe(journal.Record)()
 
 	// Log warnings
 	for _, d := range diagnostics {
@@ -99,19 +204,43 @@ func (r *Runner) RunGraph(graph *Graph) (*RunResult, error) {
 		}
 	}
 
-	// 3. Initialize logs
-	logsRoot := r.logsRoot
-	if logsRoot == "" {
-		logsRoot = filepath.Join(os.TempDir(), fmt.Sprintf("attractor-run-%d", time.Now().UnixNano()))
-	}
-	os.MkdirAll(logsRoot, 0o755)
-
 	// Write manifest
-	manifest := fmt.Sprintf(`{"name": %q, "goal": %q, "start_time": %q}`,
-		graph.Name, graph.Goal, time.Now().Format(time.RFC3339))
-	os.WriteFile(filepath.Join(logsRoot, "manifest.json"), []byte(manifest), 0o644)
+	var goalGates []string
+	for _, id := range sortedNodeIDs(graph) {
+		if graph.Nodes[id].GoalGate {
+			goalGates = append(goalGates, id)
+		}
+	}
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"name":       graph.Name,
+		"goal":       graph.Goal,
+		"start_time": time.Now().Format(time.RFC3339),
+		"goal_gates": goalGates,
+	})
+	os.WriteFile(filepath.Join(logsRoot, "manifest.json"), manifest, 0o644)
 
 	// 4. Execute
-	engine := NewEngine(EngineConfig{LogsRoot: logsRoot}, r.resolver, r.emitter)
-	return engine.Run(graph)
+	engine := NewEngine(EngineConfig{
+		LogsRoot:   logsRoot,
+		Secrets:    r.secrets,
+		Middleware: r.middleware,
+		Scrubber:   r.scrubber,
+		Cipher:     r.cipher,
+		Cache:      r.cache,
+		Context:    r.ctx,
+	}, r.resolver, r.emitter)
+	var result *RunResult
+	if r.resume != nil {
+		result, err = engine.RunFrom(graph, r.resume.start, r.resume.checkpoint)
+	} else {
+		result, err = engine.Run(graph)
+	}
+
+	// 5. Summarize. The report is a convenience: failing to write it
+	// doesn't fail the run, and `attractor report` can regenerate it.
+	WriteReport(logsRoot)
+	if result != nil {
+		result.LogsRoot = logsRoot
+	}
+	return result, err
 }
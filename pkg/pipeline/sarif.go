@@ -0,0 +1,158 @@
+package pipeline
+
+import "sort"
+
+// SARIF 2.1.0 schema and version identifiers.
+const (
+	SARIFSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	SARIFVersion = "2.1.0"
+)
+
+// SARIFLog is the top-level SARIF document. Only the subset of the format
+// needed to report lint findings is modelled.
+type SARIFLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SARIFRun `json:"runs"`
+}
+
+// SARIFRun is a single invocation of the validator.
+type SARIFRun struct {
+	Tool    SARIFTool     `json:"tool"`
+	Results []SARIFResult `json:"results"`
+}
+
+// SARIFTool describes the validator and the rules it ran.
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+// SARIFDriver identifies the tool component that produced the results.
+type SARIFDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []SARIFRule `json:"rules,omitempty"`
+}
+
+// SARIFRule is a reporting descriptor for one lint rule.
+type SARIFRule struct {
+	ID string `json:"id"`
+}
+
+// SARIFResult is one diagnostic.
+type SARIFResult struct {
+	RuleID     string            `json:"ruleId"`
+	RuleIndex  int               `json:"ruleIndex"`
+	Level      string            `json:"level"`
+	Message    SARIFMessage      `json:"message"`
+	Locations  []SARIFLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// SARIFMessage is a plain-text message.
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+// SARIFLocation points at the pipeline file and the node or edge a result
+// concerns.
+type SARIFLocation struct {
+	PhysicalLocation *SARIFPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []SARIFLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+// SARIFPhysicalLocation identifies a file and optionally a region in it.
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+	Region           *SARIFRegion          `json:"region,omitempty"`
+}
+
+// SARIFArtifactLocation is the URI of an analyzed file.
+type SARIFArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// SARIFRegion is a 1-based line and column range.
+type SARIFRegion struct {
+	StartLine   int `json:"startLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// SARIFLogicalLocation names a node ("node") or edge ("edge") in the graph.
+type SARIFLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName,omitempty"`
+	Kind               string `json:"kind"`
+}
+
+// DiagnosticsToSARIF converts diagnostics for the pipeline file at uri into a
+// SARIF log. Each result carries the node or edge as a logical location, and
+// suggested fixes are reported in the "fix" result property because SARIF
+// fixes require concrete text edits.
+func DiagnosticsToSARIF(diagnostics []Diagnostic, uri string) *SARIFLog {
+	ruleIndex := make(map[string]int)
+	var ruleIDs []string
+	for _, d := range diagnostics {
+		if _, ok := ruleIndex[d.Rule]; !ok {
+			ruleIndex[d.Rule] = 0
+			ruleIDs = append(ruleIDs, d.Rule)
+		}
+	}
+	sort.Strings(ruleIDs)
+	rules := make([]SARIFRule, len(ruleIDs))
+	for i, id := range ruleIDs {
+		ruleIndex[id] = i
+		rules[i] = SARIFRule{ID: id}
+	}
+
+	results := make([]SARIFResult, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		result := SARIFResult{
+			RuleID:    d.Rule,
+			RuleIndex: ruleIndex[d.Rule],
+			Level:     sarifLevel(d.Severity),
+			Message:   SARIFMessage{Text: d.Message},
+		}
+		loc := SARIFLocation{}
+		if uri != "" {
+			loc.PhysicalLocation = &SARIFPhysicalLocation{ArtifactLocation: SARIFArtifactLocation{URI: uri}}
+			if d.Pos.IsValid() {
+				loc.PhysicalLocation.Region = &SARIFRegion{StartLine: d.Pos.Line, StartColumn: d.Pos.Column}
+			}
+		}
+		switch {
+		case d.Edge != nil:
+			name := d.Edge[0] + " -> " + d.Edge[1]
+			loc.LogicalLocations = []SARIFLogicalLocation{{Name: name, FullyQualifiedName: "edge:" + name, Kind: "edge"}}
+		case d.NodeID != "":
+			loc.LogicalLocations = []SARIFLogicalLocation{{Name: d.NodeID, FullyQualifiedName: "node:" + d.NodeID, Kind: "node"}}
+		}
+		if loc.PhysicalLocation != nil || loc.LogicalLocations != nil {
+			result.Locations = []SARIFLocation{loc}
+		}
+		if d.Fix != "" {
+			result.Properties = map[string]string{"fix": d.Fix}
+		}
+		results = append(results, result)
+	}
+
+	return &SARIFLog{
+		Schema:  SARIFSchema,
+		Version: SARIFVersion,
+		Runs: []SARIFRun{{
+			Tool:    SARIFTool{Driver: SARIFDriver{Name: "attractor", Rules: rules}},
+			Results: results,
+		}},
+	}
+}
+
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiagnosticsToSARIF(t *testing.T) {
+	edge := [2]string{"a", "b"}
+	diagnostics := []Diagnostic{
+		{Rule: "reachability", Severity: SeverityError, Message: "unreachable", NodeID: "orphan", Fix: "Add an edge"},
+		{Rule: "condition_syntax", Severity: SeverityWarning, Message: "bad condition", Edge: &edge},
+		{Rule: "reachability", Severity: SeverityInfo, Message: "also unreachable", NodeID: "other"},
+	}
+
+	log := DiagnosticsToSARIF(diagnostics, "pipeline.dot")
+	if log.Version != SARIFVersion || len(log.Runs) != 1 {
+		t.Fatalf("expected one SARIF %s run, got %+v", SARIFVersion, log)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 {
+		t.Fatalf("expected 2 distinct rules, got %d", len(run.Tool.Driver.Rules))
+	}
+	if len(run.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(run.Results))
+	}
+
+	first := run.Results[0]
+	if first.Level != "error" {
+		t.Errorf("expected level error, got %s", first.Level)
+	}
+	if run.Tool.Driver.Rules[first.RuleIndex].ID != "reachability" {
+		t.Errorf("expected ruleIndex to point at reachability, got %d", first.RuleIndex)
+	}
+	loc := first.Locations[0]
+	if loc.PhysicalLocation == nil || loc.PhysicalLocation.ArtifactLocation.URI != "pipeline.dot" {
+		t.Errorf("expected physical location pipeline.dot, got %+v", loc.PhysicalLocation)
+	}
+	if loc.LogicalLocations[0].Name != "orphan" || loc.LogicalLocations[0].Kind != "node" {
+		t.Errorf("expected node logical location orphan, got %+v", loc.LogicalLocations[0])
+	}
+	if first.Properties["fix"] != "Add an edge" {
+		t.Errorf("expected fix property, got %v", first.Properties)
+	}
+
+	second := run.Results[1]
+	if second.Level != "warning" || second.Locations[0].LogicalLocations[0].Name != "a -> b" {
+		t.Errorf("expected warning on edge a -> b, got %+v", second)
+	}
+	if run.Results[2].Level != "note" {
+		t.Errorf("expected info to map to note, got %s", run.Results[2].Level)
+	}
+
+	data, err := json.Marshal(log)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded["$schema"] != SARIFSchema {
+		t.Errorf("expected $schema %s, got %v", SARIFSchema, decoded["$schema"])
+	}
+}
+
+func TestSeverityJSON(t *testing.T) {
+	data, err := json.Marshal(Diagnostic{Rule: "r", Severity: SeverityWarning})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var d Diagnostic
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if d.Severity != SeverityWarning {
+		t.Errorf("expected warning to round-trip, got %v (%s)", d.Severity, data)
+	}
+}
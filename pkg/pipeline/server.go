@@ -1,9 +1,12 @@
 package pipeline
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -16,6 +19,46 @@ type Server struct {
 	resolver  HandlerResolver
 	pipelines map[string]*pipelineRun
 	emitter   *events.Emitter
+	logsRoot  string
+	keys      []APIKey
+	webhooks  WebhookConfig
+	// active counts each API key's runs whose goroutine has not returned,
+	// cancelled ones included.
+	active map[string]int
+
+	// runResolver, if set, makes the resolver of each run; see
+	// WithRunResolver.
+	runResolver func(*QuestionQueue) HandlerResolver
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithServerLogsRoot writes the logs of each run to <path>/<id>, or to
+// <path>/<namespace>/<id> when the server requires API keys.
+func WithServerLogsRoot(path string) ServerOption {
+	return func(s *Server) {
+		s.logsRoot = path
+	}
+}
+
+// WithAPIKeys requires every request to carry one of keys. Each key sees
+// only the runs created in its namespace, and may be limited in how many
+// runs it has in progress. Keys should be checked with APIKey.Validate.
+func WithAPIKeys(keys ...APIKey) ServerOption {
+	return func(s *Server) {
+		s.keys = append(s.keys, keys...)
+	}
+}
+
+// WithRunResolver resolves the handlers of each run with the resolver f
+// returns for the run's question queue, in place of the server's resolver.
+// Human gates that ask on the queue are answered over HTTP, at
+// /pipelines/{id}/questions, or in the web UI at /ui.
+func WithRunResolver(f func(questions *QuestionQueue) HandlerResolver) ServerOption {
+	return func(s *Server) {
+		s.runResolver = f
+	}
 }
 
 type pipelineRun struct {
@@ -24,23 +67,28 @@ type pipelineRun struct {
 	Graph     *Graph      `json:"graph"`
 	Result    *RunResult  `json:"result,omitempty"`
 	Events    []events.Event `json:"events"`
-	Questions []pendingQuestion `json:"questions,omitempty"`
 	StartTime time.Time   `json:"start_time"`
+	Namespace string      `json:"namespace,omitempty"`
+	Webhook   *webhookStatus `json:"webhook,omitempty"`
+	key       string
+	logsDir   string
+	questions *QuestionQueue
+	cancel    context.CancelFunc
 	mu        sync.Mutex
 }
 
-type pendingQuestion struct {
-	ID       string          `json:"id"`
-	Question json.RawMessage `json:"question"`
-}
-
 // NewServer creates a new HTTP pipeline server.
-func NewServer(resolver HandlerResolver) *Server {
-	return &Server{
+func NewServer(resolver HandlerResolver, opts ...ServerOption) *Server {
+	s := &Server{
 		resolver:  resolver,
 		pipelines: make(map[string]*pipelineRun),
 		emitter:   events.NewEmitter(),
+		active:    make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Handler returns the HTTP handler for the server.
@@ -53,18 +101,59 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("GET /pipelines/{id}/context", s.handleGetContext)
 	mux.HandleFunc("GET /pipelines/{id}/checkpoint", s.handleGetCheckpoint)
 	mux.HandleFunc("GET /pipelines/{id}/questions", s.handleGetQuestions)
+	mux.HandleFunc("GET /pipelines/{id}/questions/events", s.handleQuestionEvents)
 	mux.HandleFunc("POST /pipelines/{id}/questions/{qid}/answer", s.handleAnswerQuestion)
-	return mux
+	mux.HandleFunc("GET /pipelines/{id}/logs", s.handleGetLogs)
+	mux.HandleFunc("GET /pipelines/{id}/stages/{node}/artifacts", s.handleGetArtifacts)
+	mux.HandleFunc("GET /pipelines/{id}/stages/{node}/artifacts/{file...}", s.handleGetArtifact)
+
+	// The UI is a static page that calls the API with the key it is given,
+	// so it is served without one.
+	root := http.NewServeMux()
+	root.HandleFunc("GET /ui", handleUI)
+	root.Handle("/", s.authenticate(mux))
+	return root
+}
+
+// runKey is the key of a run in s.pipelines; run IDs are only unique within
+// a namespace.
+func runKey(namespace, id string) string {
+	return namespace + "/" + id
+}
+
+// lookup returns the run named by the request's {id} in the namespace of
+// its API key.
+func (s *Server) lookup(r *http.Request) (*pipelineRun, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	run, ok := s.pipelines[runKey(requestKey(r).Namespace, r.PathValue("id"))]
+	return run, ok
+}
+
+// runDone records that a run created with key has stopped executing.
+func (s *Server) runDone(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[key]--; s.active[key] <= 0 {
+		delete(s.active, key)
+	}
 }
 
 func (s *Server) handleCreatePipeline(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		DOTSource string `json:"dot_source"`
+		DOTSource   string `json:"dot_source"`
+		CallbackURL string `json:"callback_url"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL, s.webhooks.AllowPrivate || s.webhooks.Client != nil); err != nil {
+			http.Error(w, fmt.Sprintf("invalid callback_url: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
 
 	graph, err := Parse(req.DOTSource)
 	if err != nil {
@@ -77,42 +166,91 @@ func (s *Server) handleCreatePipeline(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	key := requestKey(r)
 	id := fmt.Sprintf("pipeline-%d", time.Now().UnixNano())
 	run := &pipelineRun{
 		ID:        id,
 		Status:    "running",
 		Graph:     graph,
 		StartTime: time.Now(),
+		Namespace: key.Namespace,
+		key:       key.Key,
+		questions: NewQuestionQueue(),
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	run.cancel = cancel
+	if s.logsRoot != "" {
+		run.logsDir = filepath.Join(s.logsRoot, key.Namespace, id)
 	}
 
 	s.mu.Lock()
-	s.pipelines[id] = run
+	if key.MaxRuns > 0 {
+		if active := s.active[key.Key]; active >= key.MaxRuns {
+			s.mu.Unlock()
+			http.Error(w, fmt.Sprintf("run quota exceeded: %d of %d runs in progress", active, key.MaxRuns), http.StatusTooManyRequests)
+			return
+		}
+	}
+	s.pipelines[runKey(key.Namespace, id)] = run
+	s.active[key.Key]++
 	s.mu.Unlock()
 
+	resolver := s.resolver
+	if s.runResolver != nil {
+		resolver = s.runResolver(run.questions)
+	}
+
 	// Run pipeline in background
 	go func() {
+		defer cancel()
+		defer run.questions.Close()
+
 		emitter := events.NewEmitter()
 		emitter.On(func(e events.Event) {
 			run.mu.Lock()
 			run.Events = append(run.Events, e)
 			run.mu.Unlock()
 		})
+		// The server's runs have no client of their own to pace.
+		if graph.RequestsPerMinute > 0 || graph.TokensPerMinute > 0 {
+			emitter.Emit(events.NewEvent("validation_warning", map[string]interface{}{
+				"rule":    "rate_limit",
+				"message": "requests_per_minute and tokens_per_minute are not enforced by the server",
+			}))
+		}
 
-		engine := NewEngine(EngineConfig{}, s.resolver, emitter)
-		result, err := engine.Run(graph)
+		var result *RunResult
+		var err error
+		if run.logsDir != "" {
+			result, err = NewRunner(resolver, WithLogsRoot(run.logsDir), WithEmitter(emitter), WithContext(runCtx)).RunGraph(graph)
+		} else {
+			result, err = NewEngine(EngineConfig{Context: runCtx}, resolver, emitter).Run(graph)
+		}
+
+		s.runDone(run.key)
 
 		run.mu.Lock()
-		if err != nil {
-			run.Status = "failed"
-		} else {
+		// A cancelled run still reports the stages it completed.
+		if result != nil {
 			run.Result = result
-			if result.Status == StatusSuccess {
-				run.Status = "completed"
-			} else {
-				run.Status = "failed"
-			}
+		}
+		// A cancelled run stays cancelled however the engine finished.
+		switch {
+		case run.Status == "cancelled":
+		case err != nil || result.Status != StatusSuccess:
+			run.Status = "failed"
+		default:
+			run.Status = "completed"
+		}
+		var payload WebhookPayload
+		if req.CallbackURL != "" {
+			payload = newWebhookPayload(run, time.Now(), err)
 		}
 		run.mu.Unlock()
+
+		if req.CallbackURL != "" {
+			s.deliverWebhook(run, req.CallbackURL, payload)
+		}
 	}()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -121,10 +259,7 @@ func (s *Server) handleCreatePipeline(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetPipeline(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	run, ok := s.pipelines[id]
-	s.mu.RUnlock()
+	run, ok := s.lookup(r)
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
@@ -136,6 +271,9 @@ func (s *Server) handleGetPipeline(w http.ResponseWriter, r *http.Request) {
 		"status": run.Status,
 		"result": run.Result,
 	}
+	if run.Webhook != nil {
+		resp["webhook"] = run.Webhook
+	}
 	run.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -143,10 +281,7 @@ func (s *Server) handleGetPipeline(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetEvents(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	run, ok := s.pipelines[id]
-	s.mu.RUnlock()
+	run, ok := s.lookup(r)
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
@@ -176,10 +311,7 @@ func (s *Server) handleGetEvents(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleCancelPipeline(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	run, ok := s.pipelines[id]
-	s.mu.RUnlock()
+	run, ok := s.lookup(r)
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
@@ -187,14 +319,15 @@ func (s *Server) handleCancelPipeline(w http.ResponseWriter, r *http.Request) {
 	run.mu.Lock()
 	run.Status = "cancelled"
 	run.mu.Unlock()
+	// Stop the run before its next stage, and unblock any human gate,
+	// which fails as skipped.
+	run.cancel()
+	run.questions.Close()
 	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) handleGetContext(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	run, ok := s.pipelines[id]
-	s.mu.RUnlock()
+	run, ok := s.lookup(r)
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
@@ -213,11 +346,7 @@ func (s *Server) handleGetContext(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetCheckpoint(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	_, ok := s.pipelines[id]
-	s.mu.RUnlock()
-	if !ok {
+	if _, ok := s.lookup(r); !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
 	}
@@ -226,31 +355,79 @@ func (s *Server) handleGetCheckpoint(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetQuestions(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	run, ok := s.pipelines[id]
-	s.mu.RUnlock()
+	run, ok := s.lookup(r)
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
 	}
-	run.mu.Lock()
-	questions := run.Questions
-	run.mu.Unlock()
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(questions)
+	json.NewEncoder(w).Encode(run.questions.Pending())
+}
+
+// handleQuestionEvents streams the run's questions as server-sent events:
+// an "asked" event for each pending question, then "asked", "answered", and
+// "expired" events as they happen, until the run ends.
+func (s *Server) handleQuestionEvents(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookup(r)
+	if !ok {
+		http.Error(w, "pipeline not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Subscribe first so no question falls between the two; a question may
+	// then be sent twice, and clients should key on its id.
+	updates, stop := run.questions.Subscribe()
+	defer stop()
+	send := func(e QuestionEvent) {
+		data, _ := json.Marshal(e.Question)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		flusher.Flush()
+	}
+	for _, q := range run.questions.Pending() {
+		send(QuestionEvent{Type: "asked", Question: q})
+	}
+	for {
+		select {
+		case e, ok := <-updates:
+			if !ok {
+				fmt.Fprint(w, "event: closed\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			send(e)
+		case <-r.Context().Done():
+			return
+		}
+	}
 }
 
 func (s *Server) handleAnswerQuestion(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	s.mu.RLock()
-	_, ok := s.pipelines[id]
-	s.mu.RUnlock()
+	run, ok := s.lookup(r)
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
 	}
+	var answer HumanAnswer
+	if err := json.NewDecoder(r.Body).Decode(&answer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := run.questions.Answer(r.PathValue("qid"), answer); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrQuestionNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
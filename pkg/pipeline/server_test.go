@@ -0,0 +1,508 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingHandler succeeds once release is closed.
+type blockingHandler struct {
+	release chan struct{}
+}
+
+func (h *blockingHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	<-h.release
+	return &Outcome{Status: StatusSuccess}, nil
+}
+
+// promptHandler writes a prompt.md artifact to the stage's logs directory.
+type promptHandler struct{}
+
+func (h *promptHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	dir := filepath.Join(logsRoot, node.ID)
+	os.MkdirAll(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, "prompt.md"), []byte("prompt for "+node.ID), 0o644)
+	return &Outcome{Status: StatusSuccess}, nil
+}
+
+const serverTestDOT = `digraph serve {
+	start [shape=Mdiamond]
+	work  [label="Work"]
+	done  [shape=Msquare]
+	start -> work -> done
+}`
+
+func serverRequest(t *testing.T, ts *httptest.Server, method, path, key string) *http.Response {
+	t.Helper()
+	var body io.Reader
+	if method == http.MethodPost {
+		data, _ := json.Marshal(map[string]string{"dot_source": serverTestDOT})
+		body = strings.NewReader(string(data))
+	}
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// waitForRun polls the run until it is no longer running and returns its
+// status.
+func waitForRun(t *testing.T, ts *httptest.Server, id, key string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var status string
+	for time.Now().Before(deadline) {
+		resp := serverRequest(t, ts, http.MethodGet, "/pipelines/"+id, key)
+		var run struct {
+			Status string `json:"status"`
+		}
+		json.NewDecoder(resp.Body).Decode(&run)
+		if status = run.Status; status != "running" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return status
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	release := make(chan struct{})
+	resolver := &staticResolver{
+		handler: &simpleHandler{},
+		special: map[string]Handler{"work": &blockingHandler{release: release}},
+	}
+	logsRoot := t.TempDir()
+	server := NewServer(resolver, WithServerLogsRoot(logsRoot), WithAPIKeys(
+		APIKey{Key: "alice-key", Namespace: "alice", MaxRuns: 1},
+		APIKey{Key: "bob-key", Namespace: "bob"},
+	))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	if resp := serverRequest(t, ts, http.MethodPost, "/pipelines", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", resp.StatusCode)
+	}
+	if resp := serverRequest(t, ts, http.MethodPost, "/pipelines", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with an unknown key, got %d", resp.StatusCode)
+	}
+
+	resp := serverRequest(t, ts, http.MethodPost, "/pipelines", "alice-key")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+
+	if resp := serverRequest(t, ts, http.MethodGet, "/pipelines/"+created.ID, "bob-key"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected another namespace's run to be 404, got %d", resp.StatusCode)
+	}
+	if resp := serverRequest(t, ts, http.MethodPost, "/pipelines", "alice-key"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected 429 over the run quota, got %d", resp.StatusCode)
+	}
+	resp = serverRequest(t, ts, http.MethodPost, "/pipelines", "bob-key")
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected another key's quota to be separate, got %d", resp.StatusCode)
+	}
+	var bobRun struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&bobRun)
+
+	close(release)
+	if status := waitForRun(t, ts, created.ID, "alice-key"); status != "completed" {
+		t.Fatalf("expected status completed, got %q", status)
+	}
+
+	if _, err := os.Stat(filepath.Join(logsRoot, "alice", created.ID, "manifest.json")); err != nil {
+		t.Errorf("expected logs under the key's namespace: %v", err)
+	}
+	resp = serverRequest(t, ts, http.MethodPost, "/pipelines", "alice-key")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected a new run once the first finished, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+
+	// Let the remaining runs finish writing their logs before cleanup.
+	waitForRun(t, ts, created.ID, "alice-key")
+	waitForRun(t, ts, bobRun.ID, "bob-key")
+}
+
+func TestServerQuotaCountsCancelledRuns(t *testing.T) {
+	release := make(chan struct{})
+	resolver := &staticResolver{
+		handler: &simpleHandler{},
+		special: map[string]Handler{"work": &blockingHandler{release: release}},
+	}
+	server := NewServer(resolver, WithAPIKeys(APIKey{Key: "alice-key", Namespace: "alice", MaxRuns: 1}))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(serverRequest(t, ts, http.MethodPost, "/pipelines", "alice-key").Body).Decode(&created)
+	serverRequest(t, ts, http.MethodPost, "/pipelines/"+created.ID+"/cancel", "alice-key")
+
+	// The cancelled run is still executing, so it still counts.
+	if resp := serverRequest(t, ts, http.MethodPost, "/pipelines", "alice-key"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected 429 while the cancelled run executes, got %d", resp.StatusCode)
+	}
+
+	close(release)
+	var run struct {
+		Status string     `json:"status"`
+		Result *RunResult `json:"result"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for ; run.Result == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		json.NewDecoder(serverRequest(t, ts, http.MethodGet, "/pipelines/"+created.ID, "alice-key").Body).Decode(&run)
+	}
+	if run.Status != "cancelled" {
+		t.Errorf("expected the run to stay cancelled, got %q", run.Status)
+	}
+	if run.Result == nil || run.Result.FinalOutcome == nil || !strings.Contains(run.Result.FinalOutcome.FailureReason, "cancelled") {
+		t.Errorf("expected the engine to stop the cancelled run, got %+v", run.Result)
+	}
+	resp := serverRequest(t, ts, http.MethodPost, "/pipelines", "alice-key")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected a new run once the cancelled one finished, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	waitForRun(t, ts, created.ID, "alice-key")
+}
+
+func TestServerArtifacts(t *testing.T) {
+	server := NewServer(&staticResolver{handler: &promptHandler{}}, WithServerLogsRoot(t.TempDir()))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(serverRequest(t, ts, http.MethodPost, "/pipelines", "").Body).Decode(&created)
+	if status := waitForRun(t, ts, created.ID, ""); status != "completed" {
+		t.Fatalf("expected status completed, got %q", status)
+	}
+	base := "/pipelines/" + created.ID
+
+	resp := serverRequest(t, ts, http.MethodGet, base+"/stages/work/artifacts/prompt.md", "")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "prompt for work" {
+		t.Errorf("expected prompt.md, got %d %q", resp.StatusCode, body)
+	}
+	for _, path := range []string{
+		base + "/stages/work/artifacts/missing.md",
+		base + "/stages/work/artifacts/../../manifest.json",
+		base + "/stages/nope/artifacts",
+	} {
+		if resp := serverRequest(t, ts, http.MethodGet, path, ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp = serverRequest(t, ts, http.MethodGet, base+"/stages/work/artifacts?format=json", "")
+	var artifacts []Artifact
+	json.NewDecoder(resp.Body).Decode(&artifacts)
+	if len(artifacts) != 1 || artifacts[0].Name != "prompt.md" {
+		t.Errorf("expected [prompt.md], got %+v", artifacts)
+	}
+
+	resp = serverRequest(t, ts, http.MethodGet, base+"/logs", "")
+	if ct := resp.Header.Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("expected application/zip, got %q", ct)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	for _, want := range []string{"manifest.json", "checkpoint.json", "work/prompt.md"} {
+		if !names[want] {
+			t.Errorf("expected %s in logs zip, got %v", want, names)
+		}
+	}
+
+	resp = serverRequest(t, ts, http.MethodGet, base+"/logs?format=json", "")
+	var log RunLog
+	json.NewDecoder(resp.Body).Decode(&log)
+	if log.Status != string(StatusSuccess) || len(log.Stages) == 0 {
+		t.Errorf("expected a successful run log, got %+v", log)
+	}
+}
+
+func TestServerWebhook(t *testing.T) {
+	type delivery struct {
+		signature string
+		body      []byte
+	}
+	deliveries := make(chan delivery, 4)
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{r.Header.Get(SignatureHeader), body}
+	}))
+	defer receiver.Close()
+
+	server := NewServer(&staticResolver{handler: &simpleHandler{}}, WithWebhooks(WebhookConfig{
+		Secret:       "s3cret",
+		Backoff:      time.Millisecond,
+		AllowPrivate: true,
+	}))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	bad := `{"dot_source": "digraph g {}", "callback_url": "ftp://example.com"}`
+	resp, err := http.Post(ts.URL+"/pipelines", "application/json", strings.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a non-http callback_url, got %d", resp.StatusCode)
+	}
+
+	data, _ := json.Marshal(map[string]string{"dot_source": serverTestDOT, "callback_url": receiver.URL})
+	resp, err = http.Post(ts.URL+"/pipelines", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	if want := SignWebhook("s3cret", d.body); d.signature != want {
+		t.Errorf("expected signature %s, got %s", want, d.signature)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(d.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.ID != created.ID || payload.Status != "completed" || payload.Event != "pipeline.completed" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if len(payload.Stages) == 0 || payload.Stages[0].ID != "start" {
+		t.Errorf("expected per-stage outcomes, got %+v", payload.Stages)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var run struct {
+		Webhook *webhookStatus `json:"webhook"`
+	}
+	for time.Now().Before(deadline) && run.Webhook == nil {
+		json.NewDecoder(serverRequest(t, ts, http.MethodGet, "/pipelines/"+created.ID, "").Body).Decode(&run)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if run.Webhook == nil || !run.Webhook.Delivered || run.Webhook.Attempts != 2 {
+		t.Errorf("expected delivery on the second attempt, got %+v", run.Webhook)
+	}
+}
+
+func TestWebhookClientRefusesInternalHosts(t *testing.T) {
+	hits := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/moved", http.StatusFound)
+		}
+	}))
+	defer receiver.Close()
+
+	retry, err := postWebhook(newWebhookClient(false), receiver.URL, "", "pipeline.completed", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("expected the loopback receiver to be refused, got %v", err)
+	}
+	if hits != 0 {
+		t.Errorf("expected no request to reach the receiver, got %d", hits)
+	}
+
+	retry, err = postWebhook(newWebhookClient(true), receiver.URL, "", "pipeline.completed", []byte("{}"))
+	if err == nil || retry || hits != 1 {
+		t.Errorf("expected the redirect to be returned, not followed: hits=%d retry=%v err=%v", hits, retry, err)
+	}
+
+	for _, raw := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest", "http://10.0.0.5/", "http://[::1]/", "http://localhost/"} {
+		if err := validateCallbackURL(raw, false); err == nil {
+			t.Errorf("expected %s to be rejected", raw)
+		}
+		if err := validateCallbackURL(raw, true); err != nil {
+			t.Errorf("expected %s to be allowed with AllowPrivate: %v", raw, err)
+		}
+	}
+	if err := validateCallbackURL("https://hooks.example.com/x", false); err != nil {
+		t.Errorf("expected a public host to be allowed: %v", err)
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`[{"key": "k1", "namespace": "team-a", "max_runs": 2}]`), 0o644)
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("LoadAPIKeys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].Namespace != "team-a" || keys[0].MaxRuns != 2 {
+		t.Errorf("unexpected keys: %+v", keys)
+	}
+
+	os.WriteFile(path, []byte(`[{"key": "k1", "namespace": "../escape"}]`), 0o644)
+	if _, err := LoadAPIKeys(path); err == nil || !strings.Contains(err.Error(), "invalid namespace") {
+		t.Errorf("expected an invalid namespace error, got %v", err)
+	}
+}
+
+// askingHandler asks on its run's question queue whether to ship, and
+// succeeds if the answer is "ship".
+type askingHandler struct {
+	questions *QuestionQueue
+}
+
+func (h *askingHandler) Execute(node *Node, ctx *Context, graph *Graph, logsRoot string) (*Outcome, error) {
+	answer, err := h.questions.Ask(HumanQuestion{
+		Stage:   node.ID,
+		Text:    "Ship it?",
+		Type:    QuestionTypeMultipleChoice,
+		Options: []HumanOption{{Key: "ship", Label: "Ship"}, {Key: "hold", Label: "Hold"}},
+	}, 0)
+	if err != nil || answer.Value != "ship" {
+		return &Outcome{Status: StatusFail, FailureReason: fmt.Sprint(err)}, nil
+	}
+	return &Outcome{Status: StatusSuccess}, nil
+}
+
+func TestServerQuestions(t *testing.T) {
+	server := NewServer(&staticResolver{handler: &simpleHandler{}}, WithRunResolver(func(questions *QuestionQueue) HandlerResolver {
+		return &staticResolver{
+			handler: &simpleHandler{},
+			special: map[string]Handler{"work": &askingHandler{questions: questions}},
+		}
+	}))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	createRun := func() string {
+		resp := serverRequest(t, ts, http.MethodPost, "/pipelines", "")
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(resp.Body).Decode(&created)
+		return created.ID
+	}
+	answer := func(id, qid, value string) int {
+		resp, err := http.Post(ts.URL+"/pipelines/"+id+"/questions/"+qid+"/answer", "application/json", strings.NewReader(`{"value": "`+value+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	id := createRun()
+	resp := serverRequest(t, ts, http.MethodGet, "/pipelines/"+id+"/questions/events", "")
+	stream := bufio.NewScanner(resp.Body)
+	next := func() (string, HumanQuestion) {
+		t.Helper()
+		var event string
+		var q HumanQuestion
+		for stream.Scan() {
+			line := stream.Text()
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				json.Unmarshal([]byte(data), &q)
+			} else if line == "" && event != "" {
+				return event, q
+			}
+		}
+		t.Fatalf("question stream ended: %v", stream.Err())
+		return "", q
+	}
+
+	event, q := next()
+	if event != "asked" || q.Stage != "work" || len(q.Options) != 2 {
+		t.Fatalf("expected the work stage's question, got %s %+v", event, q)
+	}
+	var pending []HumanQuestion
+	json.NewDecoder(serverRequest(t, ts, http.MethodGet, "/pipelines/"+id+"/questions", "").Body).Decode(&pending)
+	if len(pending) != 1 || pending[0].ID != q.ID {
+		t.Errorf("expected one pending question, got %+v", pending)
+	}
+	if code := answer(id, q.ID, "maybe"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an answer that isn't an option, got %d", code)
+	}
+	if code := answer(id, "q99", "ship"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown question, got %d", code)
+	}
+	if code := answer(id, q.ID, "Ship"); code != http.StatusOK {
+		t.Fatalf("expected the answer to be accepted, got %d", code)
+	}
+	if event, _ := next(); event != "answered" {
+		t.Errorf("expected an answered event, got %s", event)
+	}
+	if event, _ := next(); event != "closed" {
+		t.Errorf("expected the stream to close with the run, got %s", event)
+	}
+	if status := waitForRun(t, ts, id, ""); status != "completed" {
+		t.Errorf("expected status completed, got %q", status)
+	}
+
+	// Cancelling a run fails the gate it is waiting on.
+	id = createRun()
+	deadline := time.Now().Add(5 * time.Second)
+	for pending = nil; len(pending) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		json.NewDecoder(serverRequest(t, ts, http.MethodGet, "/pipelines/"+id+"/questions", "").Body).Decode(&pending)
+	}
+	serverRequest(t, ts, http.MethodPost, "/pipelines/"+id+"/cancel", "")
+	var run struct {
+		Result *RunResult `json:"result"`
+	}
+	for ; run.Result == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		json.NewDecoder(serverRequest(t, ts, http.MethodGet, "/pipelines/"+id, "").Body).Decode(&run)
+	}
+	if run.Result == nil || run.Result.Status != StatusFail {
+		t.Errorf("expected the cancelled gate to fail the run, got %+v", run.Result)
+	}
+
+	ui := serverRequest(t, ts, http.MethodGet, "/ui", "")
+	if ui.StatusCode != http.StatusOK || !strings.HasPrefix(ui.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("expected the web UI, got %d %s", ui.StatusCode, ui.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,211 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseOption configures Parse.
+type ParseOption func(*Parser)
+
+// WithStrict makes Parse collect warnings about likely mistakes the DOT
+// grammar allows: unknown node and edge attributes (such as "promt"), a node
+// declared twice with conflicting attributes, and repeated edges. Validate
+// reports them alongside its own diagnostics.
+func WithStrict() ParseOption {
+	return func(p *Parser) {
+		p.strict = true
+	}
+}
+
+// knownNodeAttrs are the node attributes the parser and built-in handlers
+// read, plus the Graphviz attributes that only affect drawing.
+var knownNodeAttrs = map[string]bool{
+	"label": true, "shape": true, "type": true, "prompt": true,
+	"max_retries": true, "goal_gate": true, "retry_target": true,
+	"fallback_retry_target": true, "fidelity": true, "thread_id": true,
+	"class": true, "timeout": true, "llm_model": true, "llm_provider": true,
+	"reasoning_effort": true, "auto_status": true, "allow_partial": true,
+	"tool_command": true, "workdir": true, "tool_workdir": true, "tool_env": true,
+	"join_policy": true, "max_parallel": true, "human.default_choice": true,
+	"human.type": true, "human.store": true, "human.default": true,
+	"human.fields": true, "manager.max_cycles": true, "manager.poll_interval": true,
+	"message": true, "expect": true, "subject": true, "produces": true, "cache": true,
+	"fan_in.strategy": true, "fan_in.key": true, "logprobs": true,
+	"confidence_threshold": true, "structured_outcome": true, "expand_context": true,
+
+	"color": true, "fillcolor": true, "fontcolor": true, "fontname": true,
+	"fontsize": true, "style": true, "penwidth": true, "width": true,
+	"height": true, "fixedsize": true, "margin": true, "peripheries": true,
+	"tooltip": true, "xlabel": true, "URL": true, "href": true, "target": true,
+	"id": true, "comment": true, "group": true, "pos": true, "image": true,
+	"labelloc": true, "orientation": true, "sides": true, "skew": true,
+	"distortion": true, "regular": true,
+}
+
+// knownNodeAttrPrefixes are families of node attributes, such as env.PATH.
+var knownNodeAttrPrefixes = []string{"env.", "human.field.", "notify_"}
+
+// knownEdgeAttrs are the edge attributes the parser reads, plus the
+// Graphviz attributes that only affect drawing.
+var knownEdgeAttrs = map[string]bool{
+	"label": true, "condition": true, "weight": true, "fidelity": true,
+	"thread_id": true, "loop_restart": true, "tailport": true, "headport": true,
+
+	"color": true, "fontcolor": true, "fontname": true, "fontsize": true,
+	"style": true, "penwidth": true, "arrowhead": true, "arrowtail": true,
+	"arrowsize": true, "dir": true, "constraint": true, "minlen": true,
+	"headlabel": true, "taillabel": true, "xlabel": true, "labelfloat": true,
+	"decorate": true, "lhead": true, "ltail": true, "samehead": true,
+	"sametail": true, "tooltip": true, "URL": true, "href": true,
+	"target": true, "id": true, "comment": true,
+}
+
+func knownNodeAttr(key string) bool {
+	if knownNodeAttrs[key] {
+		return true
+	}
+	for _, prefix := range knownNodeAttrPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// declareNode records the attributes of a node statement for id and, in
+// strict mode, warns about ones an earlier statement set differently.
+func (p *Parser) declareNode(id string, attrs map[string]string, tok Token) {
+	if !p.strict {
+		return
+	}
+	prev, ok := p.nodeDecls[id]
+	if !ok {
+		p.nodeDecls[id] = attrs
+		return
+	}
+	for _, key := range sortedKeys(attrs) {
+		if old, ok := prev[key]; ok && old != attrs[key] {
+			p.warnings = append(p.warnings, Diagnostic{
+				Rule:     "duplicate_node",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("Node %s is declared again with %s=%q, overriding %q", id, key, attrs[key], old),
+				NodeID:   id,
+				Pos:      tokenPos(tok),
+				Fix:      "Merge the node's declarations into one",
+			})
+		}
+		prev[key] = attrs[key]
+	}
+}
+
+// checkEdgeAttrs warns, in strict mode, about the unknown attributes of an
+// edge statement, whose first edge is from -> to, or of edge defaults, for
+// which from is empty.
+func (p *Parser) checkEdgeAttrs(attrs map[string]string, from, to string, tok Token) {
+	if !p.strict {
+		return
+	}
+	for _, key := range sortedKeys(attrs) {
+		if knownEdgeAttrs[key] {
+			continue
+		}
+		d := unknownAttr("edge", key, knownEdgeAttrs)
+		if from != "" {
+			d.Edge = &[2]string{from, to}
+		}
+		d.Pos = tokenPos(tok)
+		p.warnings = append(p.warnings, d)
+	}
+}
+
+// checkGraph adds the strict-mode warnings that need the whole graph:
+// unknown node attributes, including ones set by node defaults, and
+// repeated edges.
+func (p *Parser) checkGraph(graph *Graph) {
+	if !p.strict {
+		return
+	}
+	for _, id := range sortedKeys(graph.Nodes) {
+		node := graph.Nodes[id]
+		// Exec handlers are given every attribute of their node.
+		if strings.HasPrefix(node.Type, "exec:") {
+			continue
+		}
+		for _, key := range sortedKeys(node.Attrs) {
+			if !knownNodeAttr(key) {
+				d := unknownAttr("node", key, knownNodeAttrs)
+				d.NodeID = id
+				p.warnings = append(p.warnings, d)
+			}
+		}
+	}
+
+	type edgeKey struct{ from, to, label, condition string }
+	seen := make(map[edgeKey]bool)
+	for _, e := range graph.Edges {
+		k := edgeKey{e.From, e.To, e.Label, e.Condition}
+		if seen[k] {
+			p.warnings = append(p.warnings, Diagnostic{
+				Rule:     "duplicate_edge",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("Edge %s -> %s is repeated with the same label and condition", e.From, e.To),
+				Edge:     &[2]string{e.From, e.To},
+				Pos:      e.Pos,
+				Fix:      "Remove the repeated edge",
+			})
+		}
+		seen[k] = true
+	}
+}
+
+// unknownAttr returns the warning for an unknown attribute of a node or
+// edge, suggesting the known attribute it is closest to.
+func unknownAttr(kind, key string, known map[string]bool) Diagnostic {
+	d := Diagnostic{
+		Rule:     "unknown_attribute",
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf("Unknown %s attribute %q", kind, key),
+	}
+	best, bestDist := "", 3 // suggest only close matches
+	for _, name := range sortedKeys(known) {
+		if dist := editDistance(key, name); dist < bestDist {
+			best, bestDist = name, dist
+		}
+	}
+	if best != "" {
+		d.Fix = fmt.Sprintf("Did you mean %q?", best)
+	}
+	return d
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
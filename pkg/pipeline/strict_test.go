@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStrict(t *testing.T) {
+	source := `digraph Test {
+		edge [wieght=2]
+		start [shape=Mdiamond]
+		exit  [shape=Msquare]
+		plan  [promt="Plan it", color=blue, env.GOFLAGS="-count=1"]
+		plan  [label="Plan", color=red]
+		run   [type="exec:deploy", region="eu"]
+		start -> plan [condtion="outcome=success"]
+		plan -> run
+		plan -> run
+		run -> exit
+	}`
+
+	graph, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if d := Validate(graph); len(rulesOf(d, "unknown_attribute", "duplicate_node", "duplicate_edge")) != 0 {
+		t.Fatalf("expected no strict warnings without WithStrict, got %v", d)
+	}
+
+	graph, err = Parse(source, WithStrict())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	found := rulesOf(Validate(graph), "unknown_attribute", "duplicate_node", "duplicate_edge")
+	want := []string{
+		`unknown_attribute: Unknown edge attribute "wieght"`,
+		`duplicate_node: Node plan is declared again with color="red", overriding "blue"`,
+		`unknown_attribute: Unknown edge attribute "condtion"`,
+		`unknown_attribute: Unknown node attribute "promt"`,
+		`duplicate_edge: Edge plan -> run is repeated`,
+	}
+	if len(found) != len(want) {
+		t.Fatalf("expected %d warnings, got %d: %v", len(want), len(found), found)
+	}
+	for i, d := range found {
+		if !strings.Contains(d.Rule+": "+d.Message, want[i]) {
+			t.Errorf("warning %d: expected %q, got %s", i, want[i], d)
+		}
+		if d.Severity != SeverityWarning {
+			t.Errorf("warning %d: expected a warning, got %s", i, d.Severity)
+		}
+	}
+	if found[3].NodeID != "plan" || found[3].Fix != `Did you mean "prompt"?` {
+		t.Errorf("expected a fix suggesting prompt on plan, got %+v", found[3])
+	}
+	if found[2].Edge == nil || found[2].Edge[1] != "plan" || found[2].Pos.Line != 8 {
+		t.Errorf("expected the condtion warning on start -> plan at line 8, got %+v", found[2])
+	}
+}
+
+func rulesOf(diagnostics []Diagnostic, rules ...string) []Diagnostic {
+	var found []Diagnostic
+	for _, d := range diagnostics {
+		for _, rule := range rules {
+			if d.Rule == rule {
+				found = append(found, d)
+			}
+		}
+	}
+	return found
+}
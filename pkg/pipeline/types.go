@@ -4,8 +4,13 @@ package pipeline
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ashka-vakil/attractor/pkg/secrets"
 )
 
 // StageStatus represents the outcome status of a node handler.
@@ -27,6 +32,41 @@ type Outcome struct {
 	ContextUpdates   map[string]interface{} `json:"context_updates,omitempty"`
 	Notes            string             `json:"notes,omitempty"`
 	FailureReason    string             `json:"failure_reason,omitempty"`
+	Usage            *Usage             `json:"usage,omitempty"`
+}
+
+// Usage is the token and cost consumption attributed to a stage or run.
+type Usage struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+	CostUSD      float64 `json:"cost_usd,omitempty"`
+}
+
+// Add adds two Usage values together.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + other.InputTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+		TotalTokens:  u.TotalTokens + other.TotalTokens,
+		CostUSD:      u.CostUSD + other.CostUSD,
+	}
+}
+
+// Position is a 1-based line and column in DOT source. The zero value means
+// the position is unknown, e.g. for graphs built in code.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
 }
 
 // Node represents a node in the pipeline graph.
@@ -50,18 +90,25 @@ type Node struct {
 	AutoStatus          bool              `json:"auto_status,omitempty"`
 	AllowPartial        bool              `json:"allow_partial,omitempty"`
 	Attrs               map[string]string `json:"attrs,omitempty"`
+	Comment             string            `json:"comment,omitempty"`
+	Pos                 Position          `json:"pos,omitzero"`
 }
 
 // Edge represents a directed edge in the pipeline graph.
 type Edge struct {
-	From        string `json:"from"`
-	To          string `json:"to"`
-	Label       string `json:"label,omitempty"`
-	Condition   string `json:"condition,omitempty"`
-	Weight      int    `json:"weight,omitempty"`
-	Fidelity    string `json:"fidelity,omitempty"`
-	ThreadID    string `json:"thread_id,omitempty"`
-	LoopRestart bool   `json:"loop_restart,omitempty"`
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Label       string   `json:"label,omitempty"`
+	Condition   string   `json:"condition,omitempty"`
+	Weight      int      `json:"weight,omitempty"`
+	WeightExpr  string   `json:"weight_expr,omitempty"` // weight computed at routing time, see EdgeWeight
+	Fidelity    string   `json:"fidelity,omitempty"`
+	ThreadID    string   `json:"thread_id,omitempty"`
+	LoopRestart bool     `json:"loop_restart,omitempty"`
+	TailPort    string   `json:"tailport,omitempty"`
+	HeadPort    string   `json:"headport,omitempty"`
+	Comment     string   `json:"comment,omitempty"`
+	Pos         Position `json:"pos,omitzero"`
 }
 
 // Graph is the complete pipeline graph.
@@ -74,31 +121,18 @@ type Graph struct {
 	DefaultFidelity      string            `json:"default_fidelity,omitempty"`
 	RetryTarget          string            `json:"retry_target,omitempty"`
 	FallbackRetryTarget  string            `json:"fallback_retry_target,omitempty"`
+	MaxTokens            int               `json:"max_tokens,omitempty"`
+	MaxCostUSD           float64           `json:"max_cost_usd,omitempty"`
+	Schedule             string            `json:"schedule,omitempty"`
+	MaxParallel          int               `json:"max_parallel,omitempty"`
+	RequestsPerMinute    int               `json:"requests_per_minute,omitempty"`
+	TokensPerMinute      int               `json:"tokens_per_minute,omitempty"`
 	Nodes                map[string]*Node  `json:"nodes"`
 	Edges                []*Edge           `json:"edges"`
 	Attrs                map[string]string `json:"attrs,omitempty"`
-}
 
-// OutgoingEdges returns all edges originating from the given node ID.
-func (g *Graph) OutgoingEdges(nodeID string) []*Edge {
-	var edges []*Edge
-	for _, e := range g.Edges {
-		if e.From == nodeID {
-			edges = append(edges, e)
-		}
-	}
-	return edges
-}
-
-// IncomingEdges returns all edges targeting the given node ID.
-func (g *Graph) IncomingEdges(nodeID string) []*Edge {
-	var edges []*Edge
-	for _, e := range g.Edges {
-		if e.To == nodeID {
-			edges = append(edges, e)
-		}
-	}
-	return edges
+	index    atomic.Value // *graphIndex adjacency cache, see graph.go
+	warnings []Diagnostic // strict-mode parse warnings, see WithStrict
 }
 
 // Context is a thread-safe key-value store for pipeline state.
@@ -186,6 +220,21 @@ func (c *Context) ApplyUpdates(updates map[string]interface{}) {
 	}
 }
 
+// ChangesSince returns the values that were added or changed relative to base,
+// a map previously obtained from Snapshot. It is used to merge the writes made
+// by a cloned branch context back into its parent.
+func (c *Context) ChangesSince(base map[string]interface{}) map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	changes := make(map[string]interface{})
+	for k, v := range c.values {
+		if old, ok := base[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes[k] = v
+		}
+	}
+	return changes
+}
+
 // Logs returns a copy of the log entries.
 func (c *Context) Logs() []string {
 	c.mu.RLock()
@@ -203,27 +252,61 @@ type Checkpoint struct {
 	NodeRetries    map[string]int         `json:"node_retries"`
 	ContextValues  map[string]interface{} `json:"context"`
 	Logs           []string               `json:"logs"`
+	// NodeStatuses are the final statuses of the completed nodes, so a
+	// resumed run restores them rather than taking every upstream node as
+	// successful.
+	NodeStatuses map[string]StageStatus `json:"node_statuses,omitempty"`
+	// ScrubbedKeys are the context keys whose values, or values nested in
+	// them, the scrubber replaced, so a resumed run can leave them out.
+	ScrubbedKeys []string `json:"scrubbed_keys,omitempty"`
+	// SpilledKeys are the context keys whose values were too large for
+	// checkpoint.json and are stored in files under context/; their entries
+	// in ContextValues are references to those files. Only these keys are
+	// resolved on load.
+	SpilledKeys []string `json:"spilled_keys,omitempty"`
+	// Sequence numbers the checkpoints of a run, starting at 1.
+	Sequence int `json:"sequence,omitempty"`
+	// Checksum is the SHA-256 of the file with this field empty; see
+	// withChecksum.
+	Checksum string `json:"checksum,omitempty"`
 }
 
-// Save writes the checkpoint to a JSON file.
+// Save writes the checkpoint to a JSON file atomically, with a checksum.
 func (cp *Checkpoint) Save(path string) error {
-	data, err := json.MarshalIndent(cp, "", "  ")
+	data, err := cp.marshal()
 	if err != nil {
 		return err
 	}
-	return writeFile(path, data)
+	return writeFileAtomic(path, withChecksum(data))
 }
 
-// LoadCheckpoint reads a checkpoint from a JSON file.
+// LoadCheckpoint reads a checkpoint from a JSON file. Context values the
+// engine saved to separate files are read back in. An encrypted checkpoint
+// is decrypted with the key in ATTRACTOR_CHECKPOINT_KEY.
 func LoadCheckpoint(path string) (*Checkpoint, error) {
 	data, err := readFile(path)
 	if err != nil {
 		return nil, err
 	}
+	var c *secrets.Cipher
+	if secrets.IsSealed(data) {
+		if c, err = secrets.CipherFromEnv(); err != nil {
+			return nil, err
+		}
+	}
+	if data, err = c.Open(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := verifyChecksum(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
 	var cp Checkpoint
 	if err := json.Unmarshal(data, &cp); err != nil {
 		return nil, err
 	}
+	if err := loadSpilledContext(&cp, filepath.Dir(path), c.Open); err != nil {
+		return nil, err
+	}
 	return &cp, nil
 }
 
@@ -0,0 +1,19 @@
+package pipeline
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the web UI for answering a run's human gates. It takes the run
+// ID, and the API key if the server needs one, and follows the run's
+// questions over /pipelines/{id}/questions/events.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(uiPage)
+}
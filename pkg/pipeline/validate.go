@@ -2,7 +2,11 @@ package pipeline
 
 import (
 	"fmt"
+	"path"
+	"sort"
 	"strings"
+
+	"github.com/ashka-vakil/attractor/pkg/llm"
 )
 
 // Severity levels for diagnostics.
@@ -27,6 +31,26 @@ func (s Severity) String() string {
 	}
 }
 
+// MarshalText encodes the severity as "error", "warning", or "info".
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(s.String())), nil
+}
+
+// UnmarshalText decodes a severity written by MarshalText.
+func (s *Severity) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "error":
+		*s = SeverityError
+	case "warning":
+		*s = SeverityWarning
+	case "info":
+		*s = SeverityInfo
+	default:
+		return fmt.Errorf("unknown severity %q", text)
+	}
+	return nil
+}
+
 // Diagnostic is a single validation finding.
 type Diagnostic struct {
 	Rule     string   `json:"rule"`
@@ -35,6 +59,7 @@ type Diagnostic struct {
 	NodeID   string   `json:"node_id,omitempty"`
 	Edge     *[2]string `json:"edge,omitempty"`
 	Fix      string   `json:"fix,omitempty"`
+	Pos      Position   `json:"pos,omitzero"` // source position of the node or edge, if known
 }
 
 func (d Diagnostic) String() string {
@@ -45,6 +70,9 @@ func (d Diagnostic) String() string {
 	if d.Edge != nil {
 		loc = fmt.Sprintf(" (edge: %s -> %s)", d.Edge[0], d.Edge[1])
 	}
+	if d.Pos.IsValid() {
+		loc += " at " + d.Pos.String()
+	}
 	return fmt.Sprintf("[%s] %s: %s%s", d.Severity, d.Rule, d.Message, loc)
 }
 
@@ -73,6 +101,9 @@ func (e *ValidationError) Error() string {
 func Validate(graph *Graph, extraRules ...LintRule) []Diagnostic {
 	var diagnostics []Diagnostic
 
+	// Warnings from a strict parse
+	diagnostics = append(diagnostics, graph.warnings...)
+
 	// Built-in rules
 	diagnostics = append(diagnostics, ruleStartNode(graph)...)
 	diagnostics = append(diagnostics, ruleTerminalNode(graph)...)
@@ -81,18 +112,32 @@ func Validate(graph *Graph, extraRules ...LintRule) []Diagnostic {
 	diagnostics = append(diagnostics, ruleReachability(graph)...)
 	diagnostics = append(diagnostics, ruleEdgeTargetExists(graph)...)
 	diagnostics = append(diagnostics, ruleConditionSyntax(graph)...)
+	diagnostics = append(diagnostics, ruleWeightSyntax(graph)...)
 	diagnostics = append(diagnostics, ruleStylesheetSyntax(graph)...)
+	diagnostics = append(diagnostics, ruleStylesheetClassUsed(graph)...)
 	diagnostics = append(diagnostics, ruleTypeKnown(graph)...)
 	diagnostics = append(diagnostics, ruleFidelityValid(graph)...)
 	diagnostics = append(diagnostics, ruleRetryTargetExists(graph)...)
 	diagnostics = append(diagnostics, ruleGoalGateHasRetry(graph)...)
 	diagnostics = append(diagnostics, rulePromptOnLLMNodes(graph)...)
+	diagnostics = append(diagnostics, ruleScheduleValid(graph)...)
+	diagnostics = append(diagnostics, ruleUnconditionalCycle(graph)...)
+	diagnostics = append(diagnostics, ruleUnsatisfiableCondition(graph)...)
+	diagnostics = append(diagnostics, ruleTerminalReachable(graph)...)
+	diagnostics = append(diagnostics, ruleContextKeyProduced(graph)...)
+	diagnostics = append(diagnostics, ruleToolCommandKeys(graph)...)
+	diagnostics = append(diagnostics, ruleAssertExpect(graph)...)
+	diagnostics = append(diagnostics, ruleHumanFields(graph)...)
+	diagnostics = append(diagnostics, ruleFanInStrategy(graph)...)
+	diagnostics = append(diagnostics, ruleConfidenceProvider(graph)...)
 
 	// Custom rules
 	for _, rule := range extraRules {
 		diagnostics = append(diagnostics, rule.Apply(graph)...)
 	}
 
+	locateDiagnostics(graph, diagnostics)
+
 	return diagnostics
 }
 
@@ -111,6 +156,31 @@ func ValidateOrRaise(graph *Graph, extraRules ...LintRule) ([]Diagnostic, error)
 	return diagnostics, nil
 }
 
+// locateDiagnostics fills in the source position of diagnostics that name a
+// node or edge and don't already have one. Edge diagnostics use the first
+// edge between the two nodes.
+func locateDiagnostics(graph *Graph, diagnostics []Diagnostic) {
+	for i := range diagnostics {
+		d := &diagnostics[i]
+		if d.Pos.IsValid() {
+			continue
+		}
+		switch {
+		case d.Edge != nil:
+			for _, e := range graph.Edges {
+				if e.From == d.Edge[0] && e.To == d.Edge[1] {
+					d.Pos = e.Pos
+					break
+				}
+			}
+		case d.NodeID != "":
+			if node, ok := graph.Nodes[d.NodeID]; ok {
+				d.Pos = node.Pos
+			}
+		}
+	}
+}
+
 // --- Built-in lint rules ---
 
 func findStartNode(graph *Graph) *Node {
@@ -189,17 +259,15 @@ func ruleStartNoIncoming(graph *Graph) []Diagnostic {
 	if start == nil {
 		return nil
 	}
-	for _, e := range graph.Edges {
-		if e.To == start.ID {
-			edge := [2]string{e.From, e.To}
-			return []Diagnostic{{
-				Rule:     "start_no_incoming",
-				Severity: SeverityError,
-				Message:  "Start node must have no incoming edges",
-				NodeID:   start.ID,
-				Edge:     &edge,
-			}}
-		}
+	if incoming := graph.IncomingEdges(start.ID); len(incoming) > 0 {
+		edge := [2]string{incoming[0].From, incoming[0].To}
+		return []Diagnostic{{
+			Rule:     "start_no_incoming",
+			Severity: SeverityError,
+			Message:  "Start node must have no incoming edges",
+			NodeID:   start.ID,
+			Edge:     &edge,
+		}}
 	}
 	return nil
 }
@@ -209,17 +277,15 @@ func ruleExitNoOutgoing(graph *Graph) []Diagnostic {
 	if exit == nil {
 		return nil
 	}
-	for _, e := range graph.Edges {
-		if e.From == exit.ID {
-			edge := [2]string{e.From, e.To}
-			return []Diagnostic{{
-				Rule:     "exit_no_outgoing",
-				Severity: SeverityError,
-				Message:  "Exit node must have no outgoing edges",
-				NodeID:   exit.ID,
-				Edge:     &edge,
-			}}
-		}
+	if outgoing := graph.OutgoingEdges(exit.ID); len(outgoing) > 0 {
+		edge := [2]string{outgoing[0].From, outgoing[0].To}
+		return []Diagnostic{{
+			Rule:     "exit_no_outgoing",
+			Severity: SeverityError,
+			Message:  "Exit node must have no outgoing edges",
+			NodeID:   exit.ID,
+			Edge:     &edge,
+		}}
 	}
 	return nil
 }
@@ -238,10 +304,10 @@ func ruleReachability(graph *Graph) []Diagnostic {
 	for len(queue) > 0 {
 		current := queue[0]
 		queue = queue[1:]
-		for _, e := range graph.Edges {
-			if e.From == current && !visited[e.To] {
-				visited[e.To] = true
-				queue = append(queue, e.To)
+		for _, next := range graph.Successors(current) {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
 			}
 		}
 	}
@@ -303,6 +369,25 @@ func ruleConditionSyntax(graph *Graph) []Diagnostic {
 	return diagnostics
 }
 
+func ruleWeightSyntax(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, e := range graph.Edges {
+		if e.WeightExpr == "" {
+			continue
+		}
+		if err := validateWeightSyntax(e.WeightExpr); err != nil {
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "weight_syntax",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Invalid weight expression %q: %v", e.WeightExpr, err),
+				Edge:     &[2]string{e.From, e.To},
+				Fix:      `Use numbers and context keys joined by + - * /, e.g. weight="context.confidence * 10"`,
+			})
+		}
+	}
+	return diagnostics
+}
+
 func validateConditionSyntax(condition string) error {
 	clauses := strings.Split(condition, "&&")
 	for _, clause := range clauses {
@@ -311,7 +396,14 @@ func validateConditionSyntax(condition string) error {
 			continue
 		}
 		// Each clause must have at least a key
-		if strings.Contains(clause, "!=") {
+		if pattern, ok := ParseSetClause(clause); ok {
+			if pattern == "" {
+				return fmt.Errorf("empty pattern in clause: %q", clause)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern in clause %q: %v", clause, err)
+			}
+		} else if strings.Contains(clause, "!=") {
 			parts := strings.SplitN(clause, "!=", 2)
 			if strings.TrimSpace(parts[0]) == "" {
 				return fmt.Errorf("empty key in clause: %q", clause)
@@ -358,16 +450,57 @@ func ruleStylesheetSyntax(graph *Graph) []Diagnostic {
 	return nil
 }
 
+// ruleStylesheetClassUsed warns about class selectors that match no node,
+// such as a class whose subgraph was renamed.
+func ruleStylesheetClassUsed(graph *Graph) []Diagnostic {
+	classes := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		for _, c := range strings.Split(node.Class, ",") {
+			classes[strings.TrimSpace(c)] = true
+		}
+	}
+	var diagnostics []Diagnostic
+	for _, rule := range strings.Split(graph.ModelStylesheet, "}") {
+		selector, _, ok := strings.Cut(rule, "{")
+		selector = strings.TrimSpace(selector)
+		if !ok || !strings.HasPrefix(selector, ".") {
+			continue
+		}
+		if class := strings.TrimPrefix(selector, "."); !classes[class] {
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "stylesheet_class_used",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("model_stylesheet selector .%s matches no node", class),
+				Fix:      fmt.Sprintf("Set class=%q on a node, or put nodes in a subgraph labeled %q", class, class),
+			})
+		}
+	}
+	return diagnostics
+}
+
 var knownHandlerTypes = map[string]bool{
 	"start": true, "exit": true, "codergen": true,
 	"wait.human": true, "conditional": true,
 	"parallel": true, "parallel.fan_in": true,
 	"tool": true, "stack.manager_loop": true,
+	"notify": true, "assert": true,
 }
 
 func ruleTypeKnown(graph *Graph) []Diagnostic {
 	var diagnostics []Diagnostic
 	for _, node := range graph.Nodes {
+		if strings.HasPrefix(node.Type, "exec:") {
+			if strings.TrimPrefix(node.Type, "exec:") == "" {
+				diagnostics = append(diagnostics, Diagnostic{
+					Rule:     "type_known",
+					Severity: SeverityError,
+					Message:  "exec handler type has no binary",
+					NodeID:   node.ID,
+					Fix:      "Use type=\"exec:<binary>\"",
+				})
+			}
+			continue
+		}
 		if node.Type != "" && !knownHandlerTypes[node.Type] {
 			diagnostics = append(diagnostics, Diagnostic{
 				Rule:     "type_known",
@@ -464,3 +597,479 @@ func rulePromptOnLLMNodes(graph *Graph) []Diagnostic {
 	}
 	return diagnostics
 }
+
+func ruleScheduleValid(graph *Graph) []Diagnostic {
+	switch graph.Schedule {
+	case "", ScheduleWalk:
+		return nil
+	case ScheduleDAG:
+		if _, err := graph.TopologicalOrder(); err != nil {
+			return []Diagnostic{{
+				Rule:     "schedule_valid",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("schedule=dag requires an acyclic graph: %v", err),
+				Fix:      "Remove the loop or use the default walk schedule",
+			}}
+		}
+		return nil
+	default:
+		return []Diagnostic{{
+			Rule:     "schedule_valid",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("Schedule %q is not valid; expected walk or dag", graph.Schedule),
+		}}
+	}
+}
+
+// sortedNodeIDs returns the graph's node IDs in lexical order so diagnostics
+// are deterministic.
+func sortedNodeIDs(graph *Graph) []string {
+	ids := make([]string, 0, len(graph.Nodes))
+	for id := range graph.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// noExitCycles returns the strongly connected components that, once entered,
+// can never be left: every edge out of every member is unconditional and
+// stays inside the component, and no member is a terminal node.
+func noExitCycles(graph *Graph) [][]string {
+	var cycles [][]string
+	for _, scc := range stronglyConnected(graph) {
+		members := make(map[string]bool, len(scc))
+		for _, id := range scc {
+			members[id] = true
+		}
+		cyclic := len(scc) > 1
+		exits := false
+		for _, id := range scc {
+			if graph.Nodes[id].Shape == "Msquare" {
+				exits = true
+			}
+			for _, e := range graph.OutgoingEdges(id) {
+				if e.To == id {
+					cyclic = true
+				}
+				if e.Condition != "" || !members[e.To] {
+					exits = true
+				}
+			}
+		}
+		if cyclic && !exits {
+			sort.Strings(scc)
+			cycles = append(cycles, scc)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// stronglyConnected returns the strongly connected components of the graph
+// using Tarjan's algorithm. Edges to unknown nodes are ignored.
+func stronglyConnected(graph *Graph) [][]string {
+	index := make(map[string]int, len(graph.Nodes))
+	low := make(map[string]int, len(graph.Nodes))
+	onStack := make(map[string]bool, len(graph.Nodes))
+	var stack []string
+	var sccs [][]string
+	next := 0
+
+	var visit func(id string)
+	visit = func(id string) {
+		index[id], low[id] = next, next
+		next++
+		stack = append(stack, id)
+		onStack[id] = true
+		for _, e := range graph.OutgoingEdges(id) {
+			if _, ok := graph.Nodes[e.To]; !ok {
+				continue
+			}
+			if _, seen := index[e.To]; !seen {
+				visit(e.To)
+				low[id] = min(low[id], low[e.To])
+			} else if onStack[e.To] {
+				low[id] = min(low[id], index[e.To])
+			}
+		}
+		if low[id] == index[id] {
+			var scc []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				scc = append(scc, top)
+				if top == id {
+					break
+				}
+			}
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, id := range sortedNodeIDs(graph) {
+		if _, seen := index[id]; !seen {
+			visit(id)
+		}
+	}
+	return sccs
+}
+
+func ruleUnconditionalCycle(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, cycle := range noExitCycles(graph) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Rule:     "unconditional_cycle",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("Nodes %s form a cycle with no conditional edge out; the pipeline can never leave it", strings.Join(cycle, ", ")),
+			NodeID:   cycle[0],
+			Fix:      "Add a conditional edge out of the cycle, e.g. [condition=\"outcome=success\"]",
+		})
+	}
+	return diagnostics
+}
+
+// validOutcomeValues are the values an "outcome" condition key can take.
+var validOutcomeValues = map[string]bool{
+	string(StatusSuccess): true, string(StatusPartialSuccess): true,
+	string(StatusRetry): true, string(StatusFail): true, string(StatusSkipped): true,
+}
+
+// unsatisfiableReason explains why a condition can never be true, or returns
+// "" if it may match. It detects clauses that require one key to equal two
+// different values, to both equal and differ from a value, and outcome
+// comparisons against values that are not stage statuses.
+func unsatisfiableReason(condition string) string {
+	equals := map[string]string{}
+	notEquals := map[string]map[string]bool{}
+	for _, clause := range strings.Split(condition, "&&") {
+		clause = strings.TrimSpace(clause)
+		var key, value string
+		negated := false
+		if idx := strings.Index(clause, "!="); idx >= 0 {
+			key, value, negated = strings.TrimSpace(clause[:idx]), strings.TrimSpace(clause[idx+2:]), true
+		} else if idx := strings.Index(clause, "="); idx >= 0 {
+			key, value = strings.TrimSpace(clause[:idx]), strings.TrimSpace(clause[idx+1:])
+		} else {
+			continue
+		}
+		if key == "outcome" && !negated && !validOutcomeValues[value] {
+			return fmt.Sprintf("outcome is never %q", value)
+		}
+		if negated {
+			if eq, ok := equals[key]; ok && eq == value {
+				return fmt.Sprintf("%s cannot both equal and differ from %q", key, value)
+			}
+			if notEquals[key] == nil {
+				notEquals[key] = map[string]bool{}
+			}
+			notEquals[key][value] = true
+			continue
+		}
+		if eq, ok := equals[key]; ok && eq != value {
+			return fmt.Sprintf("%s cannot equal both %q and %q", key, eq, value)
+		}
+		if notEquals[key][value] {
+			return fmt.Sprintf("%s cannot both equal and differ from %q", key, value)
+		}
+		equals[key] = value
+	}
+	return ""
+}
+
+func ruleUnsatisfiableCondition(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, id := range sortedNodeIDs(graph) {
+		edges := graph.OutgoingEdges(id)
+		dead := 0
+		for _, e := range edges {
+			if e.Condition == "" {
+				continue
+			}
+			reason := unsatisfiableReason(e.Condition)
+			if reason == "" {
+				continue
+			}
+			dead++
+			edge := [2]string{e.From, e.To}
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "unsatisfiable_condition",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("Condition %q can never match: %s", e.Condition, reason),
+				Edge:     &edge,
+			})
+		}
+		if dead > 0 && dead == len(edges) {
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "unsatisfiable_condition",
+				Severity: SeverityWarning,
+				Message:  "No outgoing edge condition can ever match; routing always falls back to edge weight",
+				NodeID:   id,
+				Fix:      "Fix the conditions or add an unconditional edge",
+			})
+		}
+	}
+	return diagnostics
+}
+
+// ruleTerminalReachable warns about nodes that cannot reach an exit. It is
+// only a warning: such a node may be a deliberate end of the run, like a
+// failure handler with no outgoing edges, or its outgoing edges may be added
+// by a transform before the run starts.
+func ruleTerminalReachable(graph *Graph) []Diagnostic {
+	// Walk edges backwards from every terminal node.
+	canFinish := make(map[string]bool)
+	var queue []string
+	for id, node := range graph.Nodes {
+		if node.Shape == "Msquare" {
+			canFinish[id] = true
+			queue = append(queue, id)
+		}
+	}
+	if len(queue) == 0 {
+		return nil // reported by terminal_node
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range graph.IncomingEdges(current) {
+			if !canFinish[e.From] {
+				canFinish[e.From] = true
+				queue = append(queue, e.From)
+			}
+		}
+	}
+
+	// Nodes in a no-exit cycle are already reported by unconditional_cycle.
+	inCycle := make(map[string]bool)
+	for _, cycle := range noExitCycles(graph) {
+		for _, id := range cycle {
+			inCycle[id] = true
+		}
+	}
+
+	var diagnostics []Diagnostic
+	for _, id := range sortedNodeIDs(graph) {
+		if canFinish[id] || inCycle[id] {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Rule:     "terminal_reachable",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("Node %q cannot reach any terminal node (shape=Msquare)", id),
+			NodeID:   id,
+			Fix:      "Add an edge from this node toward an exit node",
+		})
+	}
+	return diagnostics
+}
+
+func ruleContextKeyProduced(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, e := range graph.Edges {
+		tested := conditionContextKeys(e.Condition)
+		if len(tested) == 0 {
+			continue
+		}
+		produced, known := producedBy(graph, []string{e.From})
+		if !known {
+			continue // an upstream stage may write arbitrary keys
+		}
+
+		for _, key := range tested {
+			if anyKeyOverlaps(key, produced) {
+				continue
+			}
+			edge := [2]string{e.From, e.To}
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "context_key_produced",
+				Severity: SeverityInfo,
+				Message:  fmt.Sprintf("Condition tests context key %q, but no upstream stage declares producing it", key),
+				Edge:     &edge,
+				Fix:      fmt.Sprintf("Add produces=%q to the stage that sets it", key),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func ruleToolCommandKeys(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, id := range sortedNodeIDs(graph) {
+		node := graph.Nodes[id]
+		var keys []string
+		for _, ref := range CommandRefs(node.Attrs["tool_command"]) {
+			if ref.Kind == "context" && !ref.HasDefault {
+				keys = append(keys, ref.Key)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		produced, known := producedBy(graph, graph.Predecessors(id))
+		if !known {
+			continue
+		}
+		for _, key := range keys {
+			if anyKeyOverlaps(key, produced) {
+				continue
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "tool_command_keys",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("tool_command references context key %q, which no upstream stage declares producing; the stage fails if it is unset", key),
+				NodeID:   id,
+				Fix:      fmt.Sprintf("Give it a default with {context.%s:-value}, or add produces=%q to the stage that sets it", key, key),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func ruleAssertExpect(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, node := range graph.FindByType("assert") {
+		expect := strings.TrimSpace(node.Attrs["expect"])
+		if expect == "" {
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "assert_expect",
+				Severity: SeverityError,
+				Message:  "assert node has no expect attribute",
+				NodeID:   node.ID,
+				Fix:      `Add an expectation, e.g. expect="context.tests_passed=true"`,
+			})
+			continue
+		}
+		if err := validateConditionSyntax(expect); err != nil {
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "assert_expect",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Invalid expect expression: %v", err),
+				NodeID:   node.ID,
+			})
+			continue
+		}
+		produced, known := producedBy(graph, graph.Predecessors(node.ID))
+		if !known {
+			continue
+		}
+		for _, key := range conditionContextKeys(expect) {
+			if anyKeyOverlaps(key, produced) {
+				continue
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "context_key_produced",
+				Severity: SeverityInfo,
+				Message:  fmt.Sprintf("expect tests context key %q, but no upstream stage declares producing it", key),
+				NodeID:   node.ID,
+				Fix:      fmt.Sprintf("Add produces=%q to the stage that sets it", key),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func ruleHumanFields(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, node := range graph.FindByType("wait.human") {
+		if _, err := node.HumanFields(); err != nil {
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "human_fields",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Invalid human gate: %v", err),
+				NodeID:   node.ID,
+			})
+		}
+	}
+	return diagnostics
+}
+
+func ruleFanInStrategy(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, node := range graph.Nodes {
+		switch strategy := node.Attrs["fan_in.strategy"]; strategy {
+		case "", "concat", "vote", "best":
+		default:
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     "fan_in_strategy",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Unknown fan_in.strategy %q", strategy),
+				NodeID:   node.ID,
+				Fix:      "Use concat, vote, or best",
+			})
+		}
+	}
+	return diagnostics
+}
+
+// ruleConfidenceProvider warns about confidence_threshold on nodes whose
+// provider returns no logprobs: those stages are never confident. Nodes whose
+// provider can't be told from llm_provider or the model catalog are skipped.
+func ruleConfidenceProvider(graph *Graph) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, id := range sortedNodeIDs(graph) {
+		node := graph.Nodes[id]
+		if node.Attrs["confidence_threshold"] == "" {
+			continue
+		}
+		provider := node.LLMProvider
+		if provider == "" {
+			if info, ok := llm.GetModelInfo(node.LLMModel); ok {
+				provider = info.Provider
+			}
+		}
+		if provider == "" || llm.ReturnsLogprobs(provider) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Rule:     "confidence_provider",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("Provider %s returns no logprobs, so confidence_threshold always sets confident=false", provider),
+			NodeID:   id,
+			Fix:      "Use an OpenAI or xAI model for this stage, or remove confidence_threshold",
+		})
+	}
+	return diagnostics
+}
+
+// producedBy gathers the keys the given stages and every stage that can run
+// before them may write, plus the keys the engine sets. known is false if any
+// of those stages may write arbitrary keys.
+func producedBy(graph *Graph, ids []string) (produced []string, known bool) {
+	produced = append(produced, engineKeys...)
+	seen := make(map[string]bool, len(ids))
+	var queue []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if node, ok := graph.Nodes[id]; ok {
+			keys, ok := ProducedKeys(node)
+			if !ok {
+				return nil, false
+			}
+			produced = append(produced, keys...)
+		}
+		for _, prev := range graph.Predecessors(id) {
+			if !seen[prev] {
+				seen[prev] = true
+				queue = append(queue, prev)
+			}
+		}
+	}
+	return produced, true
+}
+
+func anyKeyOverlaps(key string, produced []string) bool {
+	for _, p := range produced {
+		if keysOverlap(key, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -182,6 +183,31 @@ func TestValidatePromptOnLLMNodes(t *testing.T) {
 	}
 }
 
+func TestValidateConfidenceProvider(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["a"].Attrs["confidence_threshold"] = "0.8"
+
+	warned := func() bool {
+		for _, d := range Validate(graph) {
+			if d.Rule == "confidence_provider" && d.NodeID == "a" && d.Severity == SeverityWarning {
+				return true
+			}
+		}
+		return false
+	}
+	if warned() {
+		t.Error("expected no warning when the provider is unknown")
+	}
+	graph.Nodes["a"].LLMModel = "claude-opus-4-6"
+	if !warned() {
+		t.Error("expected a warning for a provider without logprobs")
+	}
+	graph.Nodes["a"].LLMProvider = "openai"
+	if warned() {
+		t.Error("expected no warning for a provider with logprobs")
+	}
+}
+
 func TestValidateGoalGateHasRetry(t *testing.T) {
 	graph := makeSimpleGraph()
 	graph.Nodes["a"].GoalGate = true
@@ -197,3 +223,296 @@ func TestValidateGoalGateHasRetry(t *testing.T) {
 		t.Error("expected goal_gate_has_retry warning")
 	}
 }
+
+func TestValidateUnconditionalCycle(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["b"] = &Node{ID: "b", Shape: "box", Label: "B", Prompt: "Do B", Attrs: map[string]string{}}
+	graph.Edges = []*Edge{
+		{From: "start", To: "a"},
+		{From: "a", To: "b"},
+		{From: "b", To: "a"},
+	}
+
+	diagnostics := Validate(graph)
+	found := false
+	for _, d := range diagnostics {
+		if d.Rule == "unconditional_cycle" && d.NodeID == "a" && d.Severity == SeverityError {
+			found = true
+		}
+		if d.Rule == "terminal_reachable" && (d.NodeID == "a" || d.NodeID == "b") {
+			t.Errorf("cycle node %s should only be reported as unconditional_cycle", d.NodeID)
+		}
+	}
+	if !found {
+		t.Error("expected unconditional_cycle error")
+	}
+
+	// A conditional exit makes the loop legitimate.
+	graph.Edges = append(graph.Edges, &Edge{From: "b", To: "exit", Condition: "outcome=success"})
+	for _, d := range Validate(graph) {
+		if d.Rule == "unconditional_cycle" || d.Rule == "terminal_reachable" {
+			t.Errorf("unexpected %s diagnostic: %s", d.Rule, d.Message)
+		}
+	}
+}
+
+func TestValidateUnsatisfiableCondition(t *testing.T) {
+	tests := []struct {
+		condition   string
+		unsatisfied bool
+	}{
+		{"outcome=success", false},
+		{"outcome!=fail && context.x=1", false},
+		{"outcome=success && outcome=fail", true},
+		{"outcome=success && outcome!=success", true},
+		{"context.x!=1 && context.x=1", true},
+		{"outcome=done", true},
+	}
+	for _, tt := range tests {
+		graph := makeSimpleGraph()
+		graph.Edges = append(graph.Edges, &Edge{From: "a", To: "exit", Condition: tt.condition})
+
+		found := false
+		for _, d := range Validate(graph) {
+			if d.Rule == "unsatisfiable_condition" && d.Edge != nil {
+				found = true
+			}
+		}
+		if found != tt.unsatisfied {
+			t.Errorf("condition %q: expected unsatisfiable=%v, got %v", tt.condition, tt.unsatisfied, found)
+		}
+	}
+}
+
+func TestValidateUnsatisfiableConditionDeadEnd(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Edges[1].Condition = "outcome=success && outcome=fail"
+
+	found := false
+	for _, d := range Validate(graph) {
+		if d.Rule == "unsatisfiable_condition" && d.NodeID == "a" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected node-level unsatisfiable_condition warning")
+	}
+}
+
+func TestValidateTerminalReachable(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["stuck"] = &Node{ID: "stuck", Shape: "box", Label: "Stuck", Prompt: "Do it", Attrs: map[string]string{}}
+	graph.Edges = append(graph.Edges, &Edge{From: "a", To: "stuck", Condition: "outcome=fail"})
+
+	diagnostics := Validate(graph)
+	found := false
+	for _, d := range diagnostics {
+		if d.Rule == "terminal_reachable" {
+			if d.NodeID != "stuck" {
+				t.Errorf("expected terminal_reachable for stuck, got %s", d.NodeID)
+			}
+			if d.Severity != SeverityWarning {
+				t.Errorf("expected terminal_reachable to be a warning, got %v", d.Severity)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected terminal_reachable warning")
+	}
+	if _, err := ValidateOrRaise(graph); err != nil {
+		t.Errorf("expected a graph with a dead-end branch to pass validation, got %v", err)
+	}
+}
+
+func TestValidateDiagnosticPositions(t *testing.T) {
+	graph, err := Parse(`digraph P {
+	start [shape=Mdiamond]
+	exit [shape=Msquare]
+	start -> exit
+	orphan [prompt="Do it"]
+}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	found := false
+	for _, d := range Validate(graph) {
+		if d.Rule == "reachability" && d.NodeID == "orphan" {
+			found = true
+			if d.Pos != (Position{Line: 5, Column: 2}) {
+				t.Errorf("expected orphan at line 5, column 2, got %s", d.Pos)
+			}
+			if !strings.Contains(d.String(), "at line 5, column 2") {
+				t.Errorf("expected position in %q", d.String())
+			}
+		}
+	}
+	if !found {
+		t.Error("expected reachability diagnostic for orphan")
+	}
+}
+
+func TestValidateContextKeyProduced(t *testing.T) {
+	countRule := func(graph *Graph) int {
+		n := 0
+		for _, d := range Validate(graph) {
+			if d.Rule == "context_key_produced" {
+				if d.Severity != SeverityInfo {
+					t.Errorf("expected info severity, got %s", d.Severity)
+				}
+				n++
+			}
+		}
+		return n
+	}
+
+	graph := makeSimpleGraph()
+	graph.Edges[1].Condition = "context.tests_failed=true"
+	graph.Edges = append(graph.Edges, &Edge{From: "a", To: "exit"})
+	if n := countRule(graph); n != 1 {
+		t.Errorf("expected 1 context_key_produced diagnostic, got %d", n)
+	}
+
+	graph.Nodes["a"].Attrs["produces"] = "tests_*"
+	if n := countRule(graph); n != 0 {
+		t.Errorf("expected no diagnostic once a declares produces, got %d", n)
+	}
+
+	delete(graph.Nodes["a"].Attrs, "produces")
+	graph.Nodes["a"].Type = "exec:custom"
+	if n := countRule(graph); n != 0 {
+		t.Errorf("expected no diagnostic for an unregistered upstream handler, got %d", n)
+	}
+
+	graph.Nodes["a"].Type = ""
+	graph.Edges[1].Condition = "context.last_response=ok"
+	if n := countRule(graph); n != 0 {
+		t.Errorf("expected last_response to be known from codergen, got %d", n)
+	}
+
+	graph.Nodes["a"].Attrs["produces"] = "results"
+	graph.Edges[1].Condition = "context.results[0].status=ok"
+	if n := countRule(graph); n != 0 {
+		t.Errorf("expected a path into a produced key to be known, got %d", n)
+	}
+}
+
+func TestOutcomeUpdated(t *testing.T) {
+	outcome := &Outcome{ContextUpdates: map[string]interface{}{"lint.errors": 3}}
+	if !outcome.Updated("context.lint.*") {
+		t.Error("expected context.lint.* to match lint.errors")
+	}
+	if outcome.Updated("tests_*") {
+		t.Error("expected tests_* not to match")
+	}
+	var none *Outcome
+	if none.Updated("*") {
+		t.Error("expected nil outcome to match nothing")
+	}
+}
+
+func TestValidateToolCommandKeys(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["test"] = &Node{ID: "test", Shape: "parallelogram", Attrs: map[string]string{
+		"tool_command": "pytest {context.test_path} {context.flags:-} {context.last_stage}",
+	}}
+	graph.Edges[1].To = "test"
+	graph.Edges = append(graph.Edges, &Edge{From: "test", To: "exit"})
+
+	var found []string
+	for _, d := range Validate(graph) {
+		if d.Rule == "tool_command_keys" {
+			if d.NodeID != "test" || d.Severity != SeverityWarning {
+				t.Errorf("unexpected diagnostic %s", d)
+			}
+			found = append(found, d.Message)
+		}
+	}
+	if len(found) != 1 || !strings.Contains(found[0], `"test_path"`) {
+		t.Errorf("expected one warning for test_path, got %v", found)
+	}
+
+	graph.Nodes["a"].Attrs["produces"] = "test_path"
+	for _, d := range Validate(graph) {
+		if d.Rule == "tool_command_keys" {
+			t.Errorf("expected no warning once test_path is produced, got %s", d)
+		}
+	}
+}
+
+func TestValidateAssertExpect(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["check"] = &Node{ID: "check", Type: "assert", Attrs: map[string]string{}}
+	graph.Edges[1].To = "check"
+	graph.Edges = append(graph.Edges, &Edge{From: "check", To: "exit"})
+
+	rules := func() map[string]Severity {
+		found := make(map[string]Severity)
+		for _, d := range Validate(graph) {
+			if d.NodeID == "check" {
+				found[d.Rule] = d.Severity
+			}
+		}
+		return found
+	}
+	if sev, ok := rules()["assert_expect"]; !ok || sev != SeverityError {
+		t.Errorf("expected an error for a missing expect, got %v", rules())
+	}
+	if _, ok := rules()["type_known"]; ok {
+		t.Error("expected assert to be a known handler type")
+	}
+
+	graph.Nodes["check"].Attrs["expect"] = "context.tests_passed=true"
+	if got := rules(); len(got) != 1 || got["context_key_produced"] != SeverityInfo {
+		t.Errorf("expected only an unproduced key note, got %v", got)
+	}
+	graph.Nodes["a"].Attrs["produces"] = "tests_passed"
+	if got := rules(); len(got) != 0 {
+		t.Errorf("expected no diagnostics once the key is produced, got %v", got)
+	}
+}
+
+func TestValidateHumanFields(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["triage"] = &Node{ID: "triage", Shape: "hexagon", Attrs: map[string]string{
+		"human.type":            "form",
+		"human.fields":          "risk",
+		"human.field.risk.type": "choice",
+	}}
+	graph.Edges[1].To = "triage"
+	graph.Edges = append(graph.Edges, &Edge{From: "triage", To: "exit", Condition: "context.risk=low"})
+
+	var found []Diagnostic
+	for _, d := range Validate(graph) {
+		if d.Rule == "human_fields" {
+			found = append(found, d)
+		}
+	}
+	if len(found) != 1 || found[0].NodeID != "triage" || !strings.Contains(found[0].Message, "no human.field.risk.options") {
+		t.Errorf("expected a human_fields error, got %v", found)
+	}
+
+	graph.Nodes["triage"].Attrs["human.field.risk.options"] = "low,high"
+	for _, d := range Validate(graph) {
+		if d.NodeID == "triage" || (d.Edge != nil && d.Edge[0] == "triage") {
+			t.Errorf("expected the form's keys to count as produced, got %s", d)
+		}
+	}
+}
+
+func TestValidateStylesheetClassUsed(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Nodes["a"].Class = "fast, critical"
+	graph.ModelStylesheet = `* { llm_model: m } .critical { reasoning_effort: high } .review { llm_model: big }`
+
+	var found []Diagnostic
+	for _, d := range Validate(graph) {
+		if d.Rule == "stylesheet_class_used" {
+			found = append(found, d)
+		}
+	}
+	if len(found) != 1 || !strings.Contains(found[0].Message, ".review") {
+		t.Errorf("expected a warning for .review only, got %v", found)
+	}
+}
@@ -0,0 +1,250 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body, keyed with the
+// server's webhook secret, as "sha256=<hex>".
+const SignatureHeader = "X-Attractor-Signature-256"
+
+// WebhookConfig controls delivery of callback_url notifications.
+type WebhookConfig struct {
+	// Secret signs each payload; see SignatureHeader. Unsigned if empty.
+	Secret string
+	// MaxAttempts is how many times delivery is tried. Defaults to 5.
+	MaxAttempts int
+	// Backoff is the wait before the first retry; it doubles after each.
+	// Defaults to 1s.
+	Backoff time.Duration
+	// Client sends the requests. Defaults to a client with a 10s timeout
+	// that does not follow redirects or use a proxy and refuses to connect
+	// to loopback, private, and link-local addresses (such as the cloud
+	// metadata service at 169.254.169.254). A custom Client is used as is.
+	Client *http.Client
+	// AllowPrivate lets the default client deliver to loopback, private, and
+	// link-local addresses, for receivers on the server's own network.
+	AllowPrivate bool
+}
+
+// WithWebhooks sets how the server delivers completion callbacks.
+func WithWebhooks(config WebhookConfig) ServerOption {
+	return func(s *Server) {
+		s.webhooks = config
+	}
+}
+
+// WebhookPayload is the body POSTed to a run's callback_url when it
+// completes or fails.
+type WebhookPayload struct {
+	Event     string     `json:"event"`
+	ID        string     `json:"id"`
+	Namespace string     `json:"namespace,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   time.Time  `json:"end_time"`
+	Duration  string     `json:"duration"`
+	Stages    []StageLog `json:"stages"`
+	Usage     Usage      `json:"usage"`
+}
+
+// webhookStatus records the delivery of a run's callback.
+type webhookStatus struct {
+	URL       string `json:"url"`
+	Attempts  int    `json:"attempts"`
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SignWebhook returns the SignatureHeader value of body for secret.
+// Receivers should recompute it and compare with hmac.Equal.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateCallbackURL checks that raw is an absolute http or https URL and,
+// unless allowPrivate is set, that its host is not an internal IP literal or
+// localhost. Names are checked again when delivery dials them.
+func validateCallbackURL(raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL")
+	}
+	if allowPrivate {
+		return nil
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); (err == nil && internalAddr(addr)) || strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("host %s is not public", host)
+	}
+	return nil
+}
+
+// newWebhookPayload describes a finished run.
+func newWebhookPayload(run *pipelineRun, end time.Time, runErr error) WebhookPayload {
+	p := WebhookPayload{
+		Event:     "pipeline." + run.Status,
+		ID:        run.ID,
+		Namespace: run.Namespace,
+		Name:      run.Graph.Name,
+		Status:    run.Status,
+		StartTime: run.StartTime,
+		EndTime:   end,
+		Duration:  end.Sub(run.StartTime).Round(time.Millisecond).String(),
+		Stages:    []StageLog{},
+	}
+	if runErr != nil {
+		p.Error = runErr.Error()
+	}
+	if result := run.Result; result != nil {
+		p.Usage = result.Usage
+		for _, id := range result.CompletedNodes {
+			stage := StageLog{ID: id, Status: StatusSuccess}
+			if o := result.NodeOutcomes[id]; o != nil {
+				stage.Status = o.Status
+				stage.Notes = o.Notes
+				stage.FailureReason = o.FailureReason
+				stage.Usage = o.Usage
+			}
+			p.Stages = append(p.Stages, stage)
+		}
+		if p.Error == "" && result.FinalOutcome != nil {
+			p.Error = result.FinalOutcome.FailureReason
+		}
+	}
+	return p
+}
+
+// deliverWebhook POSTs payload to callbackURL, retrying with exponential
+// backoff on network errors, 429s, and 5xx responses, and records the outcome
+// on run.
+func (s *Server) deliverWebhook(run *pipelineRun, callbackURL string, payload WebhookPayload) {
+	body, _ := json.Marshal(payload)
+	config := s.webhooks
+	attempts := config.MaxAttempts
+	if attempts <= 0 {
+		attempts = 5
+	}
+	backoff := config.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	client := config.Client
+	if client == nil {
+		client = newWebhookClient(config.AllowPrivate)
+	}
+
+	status := &webhookStatus{URL: callbackURL}
+	for attempt := 1; attempt <= attempts; attempt++ {
+		status.Attempts = attempt
+		retry, err := postWebhook(client, callbackURL, config.Secret, payload.Event, body)
+		if err == nil {
+			status.Delivered, status.Error = true, ""
+			break
+		}
+		status.Error = err.Error()
+		if !retry || attempt == attempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	run.mu.Lock()
+	run.Webhook = status
+	run.mu.Unlock()
+}
+
+// NewPublicHTTPClient returns a client that, like the default callback
+// client, refuses to connect to loopback, private, and link-local addresses
+// and does not follow redirects, for posting to other URLs that come from API
+// clients or pipeline files.
+func NewPublicHTTPClient() *http.Client {
+	return newWebhookClient(false)
+}
+
+// newWebhookClient returns the default callback client. Callback URLs come
+// from API clients, so unless allowPrivate is set the dialer checks each
+// address a host resolves to, after resolution, so a public name cannot be
+// pointed at an internal service. Redirects are returned, not followed.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if internalAddr(addr) {
+				return fmt.Errorf("callback address %s is not public", addr)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// internalAddr reports whether addr is loopback, private, link-local, or
+// otherwise not a public unicast address.
+func internalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() || addr.IsUnspecified()
+}
+
+// postWebhook sends one delivery attempt and reports whether a failure is
+// worth retrying.
+func postWebhook(client *http.Client, callbackURL, secret, event string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "attractor-webhook")
+	req.Header.Set("X-Attractor-Event", event)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, SignWebhook(secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("callback returned status %d", resp.StatusCode)
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	return false, nil
+}
@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EdgeWeight returns the weight of e for routing: its integer weight, or
+// its weight expression evaluated against the outcome and context. An
+// expression that fails to evaluate weighs 0.
+func EdgeWeight(e *Edge, outcome *Outcome, ctx *Context) float64 {
+	if e.WeightExpr == "" {
+		return float64(e.Weight)
+	}
+	w, err := evalWeight(e.WeightExpr, func(key string) float64 {
+		f, _ := strconv.ParseFloat(strings.TrimSpace(resolveKeySimple(key, outcome, ctx)), 64)
+		return f
+	})
+	if err != nil {
+		return 0
+	}
+	return w
+}
+
+// validateWeightSyntax checks a weight expression without evaluating its keys.
+func validateWeightSyntax(expr string) error {
+	_, err := evalWeight(expr, func(string) float64 { return 0 })
+	return err
+}
+
+// evalWeight evaluates a weight expression: numbers and keys combined with
+// + - * / and parentheses, as in "context.confidence * 10". resolve gives
+// the value of a key such as context.confidence. Division by zero yields 0.
+func evalWeight(expr string, resolve func(key string) float64) (float64, error) {
+	p := &weightParser{src: expr, resolve: resolve}
+	v, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected %q at offset %d", p.src[p.pos:], p.pos)
+	}
+	return v, nil
+}
+
+type weightParser struct {
+	src     string
+	pos     int
+	resolve func(string) float64
+}
+
+func (p *weightParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// next skips spaces and returns the next byte, or 0 at the end.
+func (p *weightParser) next() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *weightParser) sum() (float64, error) {
+	v, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		w, err := p.product()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			v += w
+		} else {
+			v -= w
+		}
+	}
+	return v, nil
+}
+
+func (p *weightParser) product() (float64, error) {
+	v, err := p.operand()
+	if err != nil {
+		return 0, err
+	}
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+		w, err := p.operand()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case op == '*':
+			v *= w
+		case w == 0:
+			v = 0
+		default:
+			v /= w
+		}
+	}
+	return v, nil
+}
+
+func (p *weightParser) operand() (float64, error) {
+	c := p.next()
+	switch {
+	case c == '-':
+		p.pos++
+		v, err := p.operand()
+		return -v, err
+	case c == '(':
+		p.pos++
+		v, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.next() != ')' {
+			return 0, fmt.Errorf("missing ')' at offset %d", p.pos)
+		}
+		p.pos++
+		return v, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return v, nil
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+		start := p.pos
+		for p.pos < len(p.src) && isWeightKeyByte(p.src[p.pos]) {
+			p.pos++
+		}
+		return p.resolve(p.src[start:p.pos]), nil
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	return 0, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+}
+
+func isWeightKeyByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '[' || c == ']'
+}
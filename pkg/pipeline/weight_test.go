@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalWeight(t *testing.T) {
+	vars := map[string]float64{"context.confidence": 0.8, "context.cost": 2}
+	resolve := func(key string) float64 { return vars[key] }
+	cases := map[string]float64{
+		"3":                                  3,
+		"context.confidence * 10":            8,
+		"10 - context.cost * 2":              6,
+		"(10 - context.cost) * 2":            16,
+		"-context.cost + 1.5":                -0.5,
+		"context.confidence / 0":             0,
+		"context.missing * 10 + 1":           1,
+		" context.cost*context.cost ":        4,
+		"context.confidence*10/context.cost": 4,
+	}
+	for expr, want := range cases {
+		got, err := evalWeight(expr, resolve)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", expr, err)
+		} else if got != want {
+			t.Errorf("%q: expected %v, got %v", expr, want, got)
+		}
+	}
+	for _, expr := range []string{"", "context.x *", "(1 + 2", "1 2", "context.x % 2"} {
+		if _, err := evalWeight(expr, resolve); err == nil {
+			t.Errorf("%q: expected a syntax error", expr)
+		}
+	}
+}
+
+func TestEdgeSelectionWeightExpression(t *testing.T) {
+	graph, err := Parse(`digraph T {
+		judge -> careful [weight="10 - context.confidence * 10"]
+		judge -> fast    [weight="context.confidence * 10"]
+		judge -> other   [weight=7]
+	}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if e := graph.Edges[1]; e.WeightExpr != "context.confidence * 10" || e.Weight != 0 {
+		t.Fatalf("expected a weight expression, got %+v", e)
+	}
+
+	ctx := NewContext()
+	for confidence, want := range map[string]string{"0.9": "fast", "0.2": "careful", "0.5": "other"} {
+		ctx.Set("confidence", confidence)
+		if got := selectEdge(graph.Nodes["judge"], &Outcome{Status: StatusSuccess}, ctx, graph); got.To != want {
+			t.Errorf("confidence %s: expected %s, got %s", confidence, want, got.To)
+		}
+	}
+}
+
+func TestValidateWeightSyntax(t *testing.T) {
+	graph := makeSimpleGraph()
+	graph.Edges[1].WeightExpr = "context.confidence *"
+
+	var found []Diagnostic
+	for _, d := range Validate(graph) {
+		if d.Rule == "weight_syntax" {
+			found = append(found, d)
+		}
+	}
+	if len(found) != 1 || found[0].Severity != SeverityError || !strings.Contains(found[0].Message, "unexpected end") {
+		t.Errorf("expected one weight_syntax error, got %v", found)
+	}
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sealedAlgorithm identifies the envelope written by Cipher.Seal.
+const sealedAlgorithm = "AES-GCM"
+
+// sealed is the JSON envelope of an encrypted file.
+type sealed struct {
+	Encryption string `json:"encryption"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Cipher encrypts files at rest with AES-GCM. A nil *Cipher leaves data
+// unencrypted.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a cipher from a 16, 24, or 32 byte AES key.
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// CipherFromEnv creates a cipher from the base64-encoded key in
+// ATTRACTOR_CHECKPOINT_KEY (generate one with "openssl rand -base64 32"), or
+// returns nil if it is unset.
+func CipherFromEnv() (*Cipher, error) {
+	encoded := strings.TrimSpace(os.Getenv("ATTRACTOR_CHECKPOINT_KEY"))
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("ATTRACTOR_CHECKPOINT_KEY: not base64: %w", err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("ATTRACTOR_CHECKPOINT_KEY: must decode to 16, 24, or 32 bytes, got %d", len(key))
+	}
+	return c, nil
+}
+
+// Seal encrypts plaintext into a JSON envelope.
+func (c *Cipher) Seal(plaintext []byte) ([]byte, error) {
+	if c == nil {
+		return plaintext, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(sealed{
+		Encryption: sealedAlgorithm,
+		Nonce:      nonce,
+		Ciphertext: c.aead.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+}
+
+// Open decrypts data written by Seal. Data that isn't sealed is returned
+// as is.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("data is encrypted: set ATTRACTOR_CHECKPOINT_KEY")
+	}
+	var env sealed
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != c.aead.NonceSize() {
+		return nil, fmt.Errorf("decrypt: invalid nonce")
+	}
+	plaintext, err := c.aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
+
+// IsSealed reports whether data is an envelope written by Seal.
+func IsSealed(data []byte) bool {
+	if !bytes.Contains(data, []byte(`"encryption"`)) {
+		return false
+	}
+	var env sealed
+	return json.Unmarshal(data, &env) == nil && env.Encryption == sealedAlgorithm
+}
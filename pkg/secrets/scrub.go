@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ScrubbedValue replaces values removed by a Scrubber.
+const ScrubbedValue = "[REDACTED]"
+
+// DefaultKeyPattern matches keys that name a secret, such as api_key,
+// github_token, or db.password. The words must be whole segments of the key,
+// so total_tokens is not matched.
+const DefaultKeyPattern = `(?i)(^|[._-])(secrets?|token|password|passwd|api[_-]?key|private[_-]?key|access[_-]?key|credentials?)($|[._-])`
+
+// Scrubber redacts values stored under keys that look like secrets, which
+// Store.Redact can't catch because they were never resolved through it. A
+// nil *Scrubber scrubs nothing.
+type Scrubber struct {
+	patterns []*regexp.Regexp
+}
+
+// NewScrubber creates a scrubber matching keys against the given regular
+// expressions.
+func NewScrubber(patterns ...string) (*Scrubber, error) {
+	s := &Scrubber{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("scrub pattern %q: %w", p, err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+	return s, nil
+}
+
+// DefaultScrubber matches DefaultKeyPattern.
+func DefaultScrubber() *Scrubber {
+	s, _ := NewScrubber(DefaultKeyPattern)
+	return s
+}
+
+// ScrubberFromEnv returns DefaultScrubber extended with the comma-separated
+// patterns in ATTRACTOR_SCRUB_KEYS, or nil if ATTRACTOR_SCRUB_KEYS is "off".
+func ScrubberFromEnv() (*Scrubber, error) {
+	extra := strings.TrimSpace(os.Getenv("ATTRACTOR_SCRUB_KEYS"))
+	if extra == "off" {
+		return nil, nil
+	}
+	patterns := []string{DefaultKeyPattern}
+	for _, p := range strings.Split(extra, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return NewScrubber(patterns...)
+}
+
+// Matches reports whether key names a secret.
+func (s *Scrubber) Matches(key string) bool {
+	if s == nil {
+		return false
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScrubMap returns a copy of m in which the values of matching keys, at any
+// depth, are replaced with ScrubbedValue.
+func (s *Scrubber) ScrubMap(m map[string]interface{}) map[string]interface{} {
+	if s == nil || m == nil {
+		return m
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if s.Matches(k) {
+			out[k] = ScrubbedValue
+		} else {
+			out[k] = s.ScrubValue(v)
+		}
+	}
+	return out
+}
+
+// ScrubValue scrubs the maps inside a value built from maps and slices.
+// Other values are returned as is.
+func (s *Scrubber) ScrubValue(v interface{}) interface{} {
+	if s == nil {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return s.ScrubMap(val)
+	case map[string]string:
+		out := make(map[string]string, len(val))
+		for k, item := range val {
+			if s.Matches(k) {
+				item = ScrubbedValue
+			}
+			out[k] = item
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = s.ScrubValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,291 @@
+// Package secrets resolves named secrets for pipelines and agent tools,
+// expands ${secret:NAME} references, and redacts resolved values from output.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by a Provider that has no value for a name.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up secret values by name.
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+// --- Providers ---
+
+// EnvProvider reads secrets from environment variables, optionally prefixed.
+// With Prefix "ATTRACTOR_SECRET_", the secret GITHUB_TOKEN is read from
+// ATTRACTOR_SECRET_GITHUB_TOKEN.
+type EnvProvider struct {
+	Prefix string
+}
+
+func (p *EnvProvider) Get(name string) (string, error) {
+	v, ok := os.LookupEnv(p.Prefix + name)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// FileProvider reads each secret from a file named after it in Dir, in the
+// style of mounted Docker or Kubernetes secrets. A trailing newline is removed.
+type FileProvider struct {
+	Dir string
+}
+
+func (p *FileProvider) Get(name string) (string, error) {
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("read secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ExecProvider runs a command with the secret name appended as the final
+// argument and uses its trimmed stdout as the value, e.g.
+// Command: []string{"pass", "show"}.
+type ExecProvider struct {
+	Command []string
+}
+
+func (p *ExecProvider) Get(name string) (string, error) {
+	if len(p.Command) == 0 {
+		return "", fmt.Errorf("exec secret provider has no command")
+	}
+	args := append(append([]string{}, p.Command[1:]...), name)
+	out, err := exec.Command(p.Command[0], args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command for %s: %w", name, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// Chain tries each provider in order and returns the first value found.
+type Chain []Provider
+
+func (c Chain) Get(name string) (string, error) {
+	for _, p := range c {
+		v, err := p.Get(name)
+		if err == nil {
+			return v, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", ErrNotFound
+}
+
+// --- Store ---
+
+var referencePattern = regexp.MustCompile(`\$\{secret:([A-Za-z0-9_.-]+)\}`)
+
+// Store resolves secrets through a Provider, caches them, and remembers every
+// value it has handed out so that it can be redacted later. It is safe for
+// concurrent use. A nil *Store expands nothing and redacts nothing.
+type Store struct {
+	provider Provider
+	mu       sync.RWMutex
+	values   map[string]string
+}
+
+// NewStore creates a store backed by provider.
+func NewStore(provider Provider) *Store {
+	return &Store{provider: provider, values: make(map[string]string)}
+}
+
+// Get resolves a secret and records it for redaction.
+func (s *Store) Get(name string) (string, error) {
+	s.mu.RLock()
+	v, ok := s.values[name]
+	s.mu.RUnlock()
+	if ok {
+		return v, nil
+	}
+
+	v, err := s.provider.Get(name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", fmt.Errorf("secret %q: %w", name, err)
+		}
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.values[name] = v
+	s.mu.Unlock()
+	return v, nil
+}
+
+// Expand replaces every ${secret:NAME} reference in text with its value.
+func (s *Store) Expand(text string) (string, error) {
+	if s == nil || !strings.Contains(text, "${secret:") {
+		return text, nil
+	}
+	var firstErr error
+	out := referencePattern.ReplaceAllStringFunc(text, func(ref string) string {
+		name := referencePattern.FindStringSubmatch(ref)[1]
+		v, err := s.Get(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return ref
+		}
+		return v
+	})
+	if firstErr != nil {
+		return text, firstErr
+	}
+	return out, nil
+}
+
+// Redact replaces every resolved secret value in text with [REDACTED:NAME].
+// JSON-escaped forms of the values are redacted as well.
+func (s *Store) Redact(text string) string {
+	if s == nil {
+		return text
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Replace longer values first so a secret containing another is fully masked.
+	names := make([]string, 0, len(s.values))
+	for name, v := range s.values {
+		if v != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(s.values[names[i]]) > len(s.values[names[j]])
+	})
+
+	for _, name := range names {
+		v := s.values[name]
+		mask := "[REDACTED:" + name + "]"
+		text = strings.ReplaceAll(text, v, mask)
+		if quoted, _ := json.Marshal(v); string(quoted[1:len(quoted)-1]) != v {
+			text = strings.ReplaceAll(text, string(quoted[1:len(quoted)-1]), mask)
+		}
+	}
+	return text
+}
+
+// Boundary returns the largest offset no greater than n at which text can be
+// split for redaction without dividing a secret value: no resolved value (or
+// its JSON-escaped form) spans the offset, and none starts after it and runs
+// past the end of text, where more output may complete it. Streaming writers
+// redact and emit text[:Boundary(text, n)] and hold back the rest.
+func (s *Store) Boundary(text string, n int) int {
+	if n > len(text) {
+		n = len(text)
+	}
+	if n < 0 {
+		n = 0
+	}
+	if s == nil {
+		return n
+	}
+	s.mu.RLock()
+	var forms []string
+	for _, v := range s.values {
+		if v == "" {
+			continue
+		}
+		forms = append(forms, v)
+		if quoted, _ := json.Marshal(v); string(quoted[1:len(quoted)-1]) != v {
+			forms = append(forms, string(quoted[1:len(quoted)-1]))
+		}
+	}
+	s.mu.RUnlock()
+
+	// Moving the cut back can put it inside an earlier value, so repeat until
+	// no value crosses it.
+	for moved := true; moved; {
+		moved = false
+		for _, form := range forms {
+			start := n - len(form) + 1
+			if start < 0 {
+				start = 0
+			}
+			for i := start; i < n; i++ {
+				if strings.HasPrefix(text[i:], form) || strings.HasPrefix(form, text[i:]) {
+					n, moved = i, true
+					break
+				}
+			}
+		}
+	}
+	return n
+}
+
+// RedactValue redacts strings inside a value built from maps, slices and
+// strings, such as event data or context values. Other values are returned as is.
+func (s *Store) RedactValue(v interface{}) interface{} {
+	if s == nil {
+		return v
+	}
+	switch val := v.(type) {
+	case string:
+		return s.Redact(val)
+	case []string:
+		out := make([]string, len(val))
+		for i, item := range val {
+			out[i] = s.Redact(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = s.RedactValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = s.RedactValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Contains reports whether text contains any resolved secret value.
+func (s *Store) Contains(text string) bool {
+	return s != nil && s.Redact(text) != text
+}
+
+// FromEnv builds a store from the standard configuration: secrets are read
+// from ATTRACTOR_SECRET_<NAME> environment variables, then from files in
+// ATTRACTOR_SECRETS_DIR, then from the command in ATTRACTOR_SECRETS_COMMAND
+// (invoked with the secret name as its last argument).
+func FromEnv() *Store {
+	chain := Chain{&EnvProvider{Prefix: "ATTRACTOR_SECRET_"}}
+	if dir := os.Getenv("ATTRACTOR_SECRETS_DIR"); dir != "" {
+		chain = append(chain, &FileProvider{Dir: dir})
+	}
+	if command := strings.Fields(os.Getenv("ATTRACTOR_SECRETS_COMMAND")); len(command) > 0 {
+		chain = append(chain, &ExecProvider{Command: command})
+	}
+	return NewStore(chain)
+}
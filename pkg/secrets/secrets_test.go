@@ -0,0 +1,255 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mapProvider serves secrets from a map.
+type mapProvider map[string]string
+
+func (p mapProvider) Get(name string) (string, error) {
+	v, ok := p[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("TEST_SECRET_DB_PASS", "hunter2")
+	p := &EnvProvider{Prefix: "TEST_SECRET_"}
+
+	v, err := p.Get("DB_PASS")
+	if err != nil || v != "hunter2" {
+		t.Errorf("expected hunter2, got %q (%v)", v, err)
+	}
+	if _, err := p.Get("MISSING"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_key"), []byte("abc123\n"), 0o600)
+	p := &FileProvider{Dir: dir}
+
+	v, err := p.Get("api_key")
+	if err != nil || v != "abc123" {
+		t.Errorf("expected abc123, got %q (%v)", v, err)
+	}
+	if _, err := p.Get("../api_key"); err == nil {
+		t.Error("expected error for path traversal")
+	}
+	if _, err := p.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestExecProvider(t *testing.T) {
+	p := &ExecProvider{Command: []string{"echo", "value-for"}}
+	v, err := p.Get("TOKEN")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v != "value-for TOKEN" {
+		t.Errorf("expected 'value-for TOKEN', got %q", v)
+	}
+}
+
+func TestChainFallsThrough(t *testing.T) {
+	c := Chain{mapProvider{"A": "1"}, mapProvider{"B": "2"}}
+	if v, _ := c.Get("B"); v != "2" {
+		t.Errorf("expected 2, got %q", v)
+	}
+	if _, err := c.Get("C"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreExpandAndRedact(t *testing.T) {
+	s := NewStore(mapProvider{"TOKEN": "tok-123", "QUOTED": `a"b`})
+
+	out, err := s.Expand("curl -H 'Authorization: ${secret:TOKEN}' ${secret:QUOTED}")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	if out != `curl -H 'Authorization: tok-123' a"b` {
+		t.Errorf("unexpected expansion %q", out)
+	}
+
+	redacted := s.Redact("token is tok-123")
+	if redacted != "token is [REDACTED:TOKEN]" {
+		t.Errorf("unexpected redaction %q", redacted)
+	}
+	if got := s.Redact(`{"v":"a\"b"}`); strings.Contains(got, `a\"b`) {
+		t.Errorf("expected JSON-escaped value to be redacted, got %q", got)
+	}
+	if !s.Contains("xx tok-123 xx") {
+		t.Error("expected Contains to detect secret value")
+	}
+}
+
+func TestStoreBoundary(t *testing.T) {
+	s := NewStore(mapProvider{"TOKEN": "tok-123"})
+	s.Expand("${secret:TOKEN}")
+
+	tests := []struct {
+		text string
+		n    int
+		want int
+	}{
+		{"hello", 5, 5},
+		{"hello", 3, 3},
+		{"x tok-123 y", 11, 11},
+		{"x tok-123 y", 5, 2},   // value spans the cut
+		{"abc tok-1", 9, 4},     // value may continue past the end
+		{"abc tok-12x", 11, 11}, // prefix that cannot complete
+		{"abc", 10, 3},
+	}
+	for _, tt := range tests {
+		if got := s.Boundary(tt.text, tt.n); got != tt.want {
+			t.Errorf("Boundary(%q, %d) = %d, want %d", tt.text, tt.n, got, tt.want)
+		}
+	}
+
+	var nilStore *Store
+	if got := nilStore.Boundary("abc", 2); got != 2 {
+		t.Errorf("nil store Boundary = %d, want 2", got)
+	}
+}
+
+func TestStoreExpandMissing(t *testing.T) {
+	s := NewStore(mapProvider{})
+	if _, err := s.Expand("${secret:NOPE}"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	out, err := s.Expand("${secret:X}")
+	if err != nil || out != "${secret:X}" {
+		t.Errorf("expected nil store to leave text unchanged, got %q (%v)", out, err)
+	}
+	if s.Redact("abc") != "abc" {
+		t.Error("expected nil store to leave text unchanged")
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	s := NewStore(mapProvider{"K": "sekret"})
+	s.Get("K")
+
+	v := s.RedactValue(map[string]interface{}{
+		"a": "the sekret",
+		"b": []interface{}{"sekret", 3},
+		"c": 42,
+	}).(map[string]interface{})
+
+	if v["a"] != "the [REDACTED:K]" {
+		t.Errorf("unexpected a: %v", v["a"])
+	}
+	if v["b"].([]interface{})[0] != "[REDACTED:K]" {
+		t.Errorf("unexpected b: %v", v["b"])
+	}
+	if v["c"] != 42 {
+		t.Errorf("expected non-string values to pass through, got %v", v["c"])
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	s := DefaultScrubber()
+	for key, want := range map[string]bool{
+		"api_key":      true,
+		"github_token": true,
+		"db.password":  true,
+		"AWS-Secret":   true,
+		"total_tokens": false,
+		"monkey":       false,
+		"last_stage":   false,
+	} {
+		if got := s.Matches(key); got != want {
+			t.Errorf("Matches(%q): expected %v, got %v", key, want, got)
+		}
+	}
+
+	in := map[string]interface{}{
+		"api_key": "sk-123",
+		"nested":  map[string]interface{}{"password": "hunter2", "user": "bob"},
+		"list":    []interface{}{map[string]interface{}{"token": "t"}},
+	}
+	out := s.ScrubMap(in)
+	if out["api_key"] != ScrubbedValue {
+		t.Errorf("expected api_key scrubbed, got %v", out["api_key"])
+	}
+	nested := out["nested"].(map[string]interface{})
+	if nested["password"] != ScrubbedValue || nested["user"] != "bob" {
+		t.Errorf("unexpected nested map: %v", nested)
+	}
+	if item := out["list"].([]interface{})[0].(map[string]interface{}); item["token"] != ScrubbedValue {
+		t.Errorf("expected token in list scrubbed, got %v", item)
+	}
+	if in["api_key"] != "sk-123" {
+		t.Error("expected the input map to be left unchanged")
+	}
+
+	var none *Scrubber
+	if got := none.ScrubMap(in); got["api_key"] != "sk-123" {
+		t.Error("expected nil scrubber to scrub nothing")
+	}
+}
+
+func TestScrubberFromEnv(t *testing.T) {
+	t.Setenv("ATTRACTOR_SCRUB_KEYS", "^internal_")
+	s, err := ScrubberFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Matches("internal_url") || !s.Matches("api_key") {
+		t.Error("expected extra and default patterns to match")
+	}
+	t.Setenv("ATTRACTOR_SCRUB_KEYS", "off")
+	if s, _ := ScrubberFromEnv(); s != nil {
+		t.Error("expected off to disable scrubbing")
+	}
+}
+
+func TestCipher(t *testing.T) {
+	t.Setenv("ATTRACTOR_CHECKPOINT_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	c, err := CipherFromEnv()
+	if err != nil || c == nil {
+		t.Fatalf("CipherFromEnv: %v", err)
+	}
+	sealed, err := c.Seal([]byte(`{"context": {"x": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(string(sealed), "context") {
+		t.Fatalf("expected an encrypted envelope, got %s", sealed)
+	}
+	plain, err := c.Open(sealed)
+	if err != nil || string(plain) != `{"context": {"x": 1}}` {
+		t.Errorf("expected round trip, got %q (%v)", plain, err)
+	}
+	if plain, err := c.Open([]byte(`{"a": 1}`)); err != nil || string(plain) != `{"a": 1}` {
+		t.Errorf("expected unencrypted data returned as is, got %q (%v)", plain, err)
+	}
+
+	other, _ := NewCipher([]byte("fedcba9876543210fedcba9876543210"))
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("expected an error with the wrong key")
+	}
+	var none *Cipher
+	if _, err := none.Open(sealed); err == nil || !strings.Contains(err.Error(), "ATTRACTOR_CHECKPOINT_KEY") {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+
+	t.Setenv("ATTRACTOR_CHECKPOINT_KEY", "c2hvcnQ=")
+	if _, err := CipherFromEnv(); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
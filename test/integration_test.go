@@ -220,8 +220,8 @@ func TestEndToEndPipelineHTTPAPI(t *testing.T) {
 	registry := handler.NewRegistry(nil, &handler.AutoApproveInterviewer{})
 	resolver := &registryAdapter{registry: registry}
 
-	// Create a pipeline server.
-	server := pipeline.NewServer(resolver)
+	// Create a pipeline server that logs runs to a temporary directory.
+	server := pipeline.NewServer(resolver, pipeline.WithServerLogsRoot(t.TempDir()))
 	ts := httptest.NewServer(server.Handler())
 	defer ts.Close()
 